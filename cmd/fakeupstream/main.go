@@ -0,0 +1,56 @@
+// Command fakeupstream runs a local stand-in for the upstream chat service.
+//
+// 将 liao 的 HTTP 请求指向它：设置 HTTP_PROXY=http://<addr> 即可让固定的
+// v1.chat2019.cn 接口落到本地，getRandServer 返回的 WebSocket 地址为回环地址，不经代理。
+package main
+
+import (
+	"flag"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"liao/internal/fakeupstream"
+)
+
+var (
+	osExit          = os.Exit
+	notifySignalsFn = signal.Notify
+)
+
+func main() {
+	if err := run(os.Args[1:]); err != nil {
+		osExit(1)
+	}
+}
+
+func run(args []string) error {
+	fs := flag.NewFlagSet("fakeupstream", flag.ContinueOnError)
+	addr := fs.String("addr", "127.0.0.1:18080", "监听地址")
+	noBots := fs.Bool("no-bots", false, "不提供机器人用户，random 仅在客户端之间配对")
+	replyDelay := fs.Duration("bot-reply-delay", 500*time.Millisecond, "机器人回复延迟")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	var bots []fakeupstream.User
+	if !*noBots {
+		bots = fakeupstream.DefaultBots()
+	}
+	server, err := fakeupstream.Start(*addr, bots...)
+	if err != nil {
+		slog.Error("fake upstream 启动失败", "error", err)
+		return err
+	}
+	server.BotReplyDelay = *replyDelay
+	slog.Info("fake upstream 已启动", "url", server.URL(), "ws", server.WebSocketURL(), "randServerBase", server.RandServerBase(), "bots", len(bots))
+
+	shutdownSignals := make(chan os.Signal, 1)
+	notifySignalsFn(shutdownSignals, syscall.SIGINT, syscall.SIGTERM)
+	<-shutdownSignals
+
+	slog.Info("fake upstream 停止")
+	return server.Close()
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestRun_RejectsUnknownFlag(t *testing.T) {
+	if err := run([]string{"-unknown"}); err == nil {
+		t.Fatalf("expected flag error")
+	}
+}
+
+func TestRun_ServesUntilSignal(t *testing.T) {
+	oldNotify := notifySignalsFn
+	t.Cleanup(func() { notifySignalsFn = oldNotify })
+
+	registered := make(chan chan<- os.Signal, 1)
+	notifySignalsFn = func(c chan<- os.Signal, _ ...os.Signal) { registered <- c }
+
+	done := make(chan error, 1)
+	go func() { done <- run([]string{"-addr", "127.0.0.1:0", "-no-bots"}) }()
+
+	var sigCh chan<- os.Signal
+	select {
+	case sigCh = <-registered:
+	case err := <-done:
+		t.Fatalf("run returned early: %v", err)
+	case <-time.After(2 * time.Second):
+		t.Fatalf("timeout waiting for signal registration")
+	}
+	sigCh <- os.Interrupt
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("run: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("timeout waiting for shutdown")
+	}
+}
+
+func TestRun_ListenError(t *testing.T) {
+	if err := run([]string{"-addr", "256.0.0.1:bad"}); err == nil {
+		t.Fatalf("expected listen error")
+	}
+}
@@ -1,6 +1,7 @@
 import { beforeEach, describe, expect, it, vi } from 'vitest'
 
-import request, { createFormData, douyinRequest, navigation } from '@/api/request'
+import axios from 'axios'
+import request, { createFormData, douyinRequest, ensureFreshAccessToken, navigation, refreshAccessToken } from '@/api/request'
 
 const getRequestFulfilled = (instance: any) => instance.interceptors.request.handlers[0]?.fulfilled
 const getRequestRejected = (instance: any) => instance.interceptors.request.handlers[0]?.rejected
@@ -114,4 +115,74 @@ describe('api/request', () => {
     expect(removeSpy).not.toHaveBeenCalled()
     expect(toLoginSpy).not.toHaveBeenCalled()
   })
+
+  it('refreshAccessToken rotates tokens and shares one in-flight request', async () => {
+    await expect(refreshAccessToken()).rejects.toThrow()
+
+    localStorage.setItem('authRefreshToken', 'sid.r1')
+    const postSpy = vi.spyOn(axios, 'post').mockResolvedValue({
+      data: { code: 0, token: 't-new', refreshToken: 'sid.r2' }
+    } as any)
+
+    const [a, b] = await Promise.all([refreshAccessToken(), refreshAccessToken()])
+    expect(a).toBe('t-new')
+    expect(b).toBe('t-new')
+    expect(postSpy).toHaveBeenCalledTimes(1)
+    expect(postSpy.mock.calls[0]![0]).toContain('/auth/refresh')
+    expect((postSpy.mock.calls[0]![1] as URLSearchParams).get('refreshToken')).toBe('sid.r1')
+    expect(localStorage.getItem('authToken')).toBe('t-new')
+    expect(localStorage.getItem('authRefreshToken')).toBe('sid.r2')
+
+    postSpy.mockResolvedValue({ data: { code: 401, msg: 'expired' } } as any)
+    await expect(refreshAccessToken()).rejects.toThrow('expired')
+  })
+
+  it('error interceptor refreshes once and replays the request on 401', async () => {
+    const toLoginSpy = vi.spyOn(navigation, 'toLogin').mockImplementation(() => {})
+    localStorage.setItem('authToken', 't-old')
+    localStorage.setItem('authRefreshToken', 'sid.r1')
+    vi.spyOn(axios, 'post').mockResolvedValue({ data: { code: 0, token: 't-new', refreshToken: 'sid.r2' } } as any)
+
+    const replayed: any[] = []
+    const config: any = {
+      headers: {},
+      adapter: async (cfg: any) => {
+        replayed.push(cfg)
+        return { data: { code: 0 }, status: 200, statusText: 'OK', headers: {}, config: cfg }
+      }
+    }
+    const res = await getResponseRejected(request)({ response: { status: 401 }, config })
+    expect(res).toEqual({ code: 0 })
+    expect(replayed).toHaveLength(1)
+    expect(replayed[0].headers.Authorization).toBe('Bearer t-new')
+    expect(toLoginSpy).not.toHaveBeenCalled()
+
+    // 已重试过的请求再次 401：不再续期，清除登录状态
+    const err: any = { response: { status: 401 }, config: { ...config, _retried: true } }
+    await expect(getResponseRejected(request)(err)).rejects.toBe(err)
+    expect(localStorage.getItem('authToken')).toBeNull()
+    expect(localStorage.getItem('authRefreshToken')).toBeNull()
+    expect(toLoginSpy).toHaveBeenCalledTimes(1)
+  })
+
+  it('ensureFreshAccessToken refreshes only when the token is about to expire', async () => {
+    const toLoginSpy = vi.spyOn(navigation, 'toLogin').mockImplementation(() => {})
+    const jwtWithExp = (exp: number) => `h.${btoa(JSON.stringify({ exp })).replace(/=+$/, '')}.s`
+    const postSpy = vi.spyOn(axios, 'post').mockResolvedValue({ data: { code: 0, token: 't-new' } } as any)
+
+    const fresh = jwtWithExp(Math.floor(Date.now() / 1000) + 600)
+    localStorage.setItem('authToken', fresh)
+    localStorage.setItem('authRefreshToken', 'sid.r1')
+    expect(await ensureFreshAccessToken()).toBe(fresh)
+    expect(postSpy).not.toHaveBeenCalled()
+
+    localStorage.setItem('authToken', jwtWithExp(Math.floor(Date.now() / 1000) + 5))
+    expect(await ensureFreshAccessToken()).toBe('t-new')
+
+    postSpy.mockRejectedValue({ response: { status: 401 } })
+    localStorage.setItem('authToken', 'not-a-jwt')
+    expect(await ensureFreshAccessToken()).toBe('')
+    expect(localStorage.getItem('authRefreshToken')).toBeNull()
+    expect(toLoginSpy).toHaveBeenCalledTimes(1)
+  })
 })
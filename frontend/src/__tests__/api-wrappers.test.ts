@@ -47,10 +47,37 @@ describe('api/auth', () => {
     )
   })
 
+  it('loginWithPassword posts urlencoded username and password', () => {
+    authApi.loginWithPassword('alice', 'pw')
+    expect(spies.createFormData).toHaveBeenCalledWith({ username: 'alice', password: 'pw' })
+    expect(spies.requestPost).toHaveBeenCalledWith(
+      '/auth/login',
+      { __form: { username: 'alice', password: 'pw' } },
+      { headers: { 'Content-Type': 'application/x-www-form-urlencoded' } }
+    )
+  })
+
+  it('loginWithPassword includes totpCode when provided', () => {
+    authApi.loginWithPassword('alice', 'pw', '123456')
+    expect(spies.createFormData).toHaveBeenCalledWith({ username: 'alice', password: 'pw', totpCode: '123456' })
+  })
+
   it('verifyToken calls GET /auth/verify', () => {
     authApi.verifyToken()
     expect(spies.requestGet).toHaveBeenCalledWith('/auth/verify')
   })
+
+  it('logout posts /auth/logout with the given token', () => {
+    authApi.logout('t-1')
+    expect(spies.requestPost).toHaveBeenCalledWith('/auth/logout', undefined, {
+      headers: { Authorization: 'Bearer t-1' }
+    })
+  })
+
+  it('signMediaUrls posts the urls to /signMediaUrls', () => {
+    authApi.signMediaUrls(['/upload/a.jpg'])
+    expect(spies.requestPost).toHaveBeenCalledWith('/signMediaUrls', { urls: ['/upload/a.jpg'] })
+  })
 })
 
 describe('api/chat', () => {
@@ -1,11 +1,13 @@
 import { describe, expect, it } from 'vitest'
 
-import { API_BASE, IMG_SERVER_ADDRESS, IMG_SERVER_IMAGE_PORT, WS_URL, setImgServerAddress } from '@/constants/config'
+import { API_BASE, IMG_SERVER_ADDRESS, IMG_SERVER_IMAGE_PORT, SSE_SEND_URL, SSE_URL, WS_URL, setImgServerAddress } from '@/constants/config'
 
 describe('constants/config', () => {
   it('exports defaults and allows updating IMG_SERVER_ADDRESS', () => {
     expect(API_BASE).toBe('/api')
     expect(WS_URL).toBe('/ws')
+    expect(SSE_URL).toBe('/sse')
+    expect(SSE_SEND_URL).toBe('/sse/send')
     expect(IMG_SERVER_IMAGE_PORT).toBe(9006)
 
     const before = IMG_SERVER_ADDRESS
@@ -3,7 +3,10 @@ import { createPinia, setActivePinia } from 'pinia'
 
 vi.mock('@/api/auth', () => ({
   login: vi.fn(),
-  verifyToken: vi.fn()
+  loginWithPassword: vi.fn(),
+  verifyToken: vi.fn(),
+  logout: vi.fn(),
+  signMediaUrls: vi.fn()
 }))
 
 import request, { createFormData, navigation } from '@/api/request'
@@ -12,6 +15,7 @@ import { useToast } from '@/composables/useToast'
 import { useAuthStore } from '@/stores/auth'
 import { parseEmoji, escapeRegex, randomString, truncate } from '@/utils/string'
 import { formatFullTime, formatTime } from '@/utils/time'
+import { signMediaUrl } from '@/utils/media'
 
 beforeEach(() => {
   localStorage.clear()
@@ -198,6 +202,113 @@ describe('stores/auth', () => {
     expect(store.loginLoading).toBe(false)
   })
 
+  it('login exposes the throttle message on 429', async () => {
+    setActivePinia(createPinia())
+
+    const mockedLogin = vi.mocked(authApi.login)
+    mockedLogin.mockRejectedValueOnce({
+      response: { status: 429, data: { code: 429, msg: '登录尝试过于频繁，请 8 秒后再试', retryAfter: 8 } }
+    })
+
+    const store = useAuthStore()
+    const ok = await store.login('code')
+
+    expect(ok).toBe(false)
+    expect(store.loginError).toBe('登录尝试过于频繁，请 8 秒后再试')
+
+    mockedLogin.mockResolvedValueOnce({ code: -1, msg: '访问码错误' } as any)
+    await store.login('code')
+    expect(store.loginError).toBe('')
+  })
+
+  it('loginWithPassword stores token and role', async () => {
+    setActivePinia(createPinia())
+
+    const mockedLogin = vi.mocked(authApi.loginWithPassword)
+    mockedLogin.mockResolvedValue({ code: 0, token: 't-account', role: 'viewer' } as any)
+
+    const store = useAuthStore()
+    const ok = await store.loginWithPassword('alice', 'password-1')
+
+    expect(ok).toBe(true)
+    expect(mockedLogin).toHaveBeenCalledWith('alice', 'password-1', undefined)
+    expect(store.token).toBe('t-account')
+    expect(store.role).toBe('viewer')
+    expect(localStorage.getItem('authRole')).toBe('viewer')
+
+    store.logout()
+    expect(store.role).toBe('')
+    expect(localStorage.getItem('authRole')).toBeNull()
+  })
+
+  it('loginWithPassword asks for a TOTP code when the account requires one', async () => {
+    setActivePinia(createPinia())
+
+    const mockedLogin = vi.mocked(authApi.loginWithPassword)
+    mockedLogin.mockResolvedValueOnce({ code: -1, msg: '请输入两步验证码', totpRequired: true } as any)
+
+    const store = useAuthStore()
+    expect(await store.loginWithPassword('alice', 'password-1')).toBe(false)
+    expect(store.totpRequired).toBe(true)
+    expect(store.loginError).toBe('请输入两步验证码')
+    expect(store.isAuthenticated).toBe(false)
+
+    mockedLogin.mockRejectedValueOnce({
+      response: { status: 400, data: { code: -1, msg: '两步验证码错误', totpRequired: true } }
+    })
+    expect(await store.loginWithPassword('alice', 'password-1', '000000')).toBe(false)
+    expect(store.loginError).toBe('两步验证码错误')
+
+    mockedLogin.mockResolvedValueOnce({ code: 0, token: 't-totp', role: 'operator' } as any)
+    expect(await store.loginWithPassword('alice', 'password-1', '123456')).toBe(true)
+    expect(mockedLogin).toHaveBeenLastCalledWith('alice', 'password-1', '123456')
+    expect(store.totpRequired).toBe(false)
+    expect(store.token).toBe('t-totp')
+  })
+
+  it('stores refresh token on login and revokes session on explicit logout', async () => {
+    setActivePinia(createPinia())
+
+    vi.mocked(authApi.login).mockResolvedValue({ code: 0, token: 't-access', refreshToken: 'sid.r1' } as any)
+    const mockedLogout = vi.mocked(authApi.logout)
+    mockedLogout.mockResolvedValue({ code: 0 } as any)
+
+    const store = useAuthStore()
+    expect(await store.login('code')).toBe(true)
+    expect(localStorage.getItem('authRefreshToken')).toBe('sid.r1')
+
+    store.logout(true)
+    expect(mockedLogout).toHaveBeenCalledWith('t-access')
+    expect(localStorage.getItem('authToken')).toBeNull()
+    expect(localStorage.getItem('authRefreshToken')).toBeNull()
+
+    mockedLogout.mockClear()
+    store.logout(true)
+    expect(mockedLogout).not.toHaveBeenCalled()
+  })
+
+  it('enables per-url media signing after login and disables it on logout', async () => {
+    setActivePinia(createPinia())
+
+    vi.mocked(authApi.login).mockResolvedValue({ code: 0, token: 't-access' } as any)
+    const mockedSign = vi.mocked(authApi.signMediaUrls)
+    const expiresAt = Math.floor(Date.now() / 1000) + 3600
+    mockedSign.mockImplementation(async (urls: string[]) => ({
+      code: 0,
+      data: { enabled: true, urls: urls.map(url => `${url}?exp=${expiresAt}&sig=s`), expiresAt }
+    }) as any)
+
+    const store = useAuthStore()
+    expect(await store.login('code')).toBe(true)
+    await vi.waitFor(() => expect(mockedSign).toHaveBeenCalledWith([]))
+    await vi.waitFor(() => expect(signMediaUrl('/upload/a.jpg')).toBe(`/upload/a.jpg?exp=${expiresAt}&sig=s`))
+    expect(mockedSign).toHaveBeenCalledWith(['/upload/a.jpg'])
+
+    store.logout()
+    expect(signMediaUrl('/upload/a.jpg')).toBe('/upload/a.jpg')
+    mockedSign.mockReset()
+  })
+
   it('login returns false on failure and resets loading', async () => {
     setActivePinia(createPinia())
 
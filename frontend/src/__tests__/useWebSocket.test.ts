@@ -355,6 +355,56 @@ describe('composables/useWebSocket', () => {
     expect(sign.id).toBe('me')
   })
 
+  it('re-signs with lastSeq after unexpected close and handles resume result', async () => {
+    vi.useFakeTimers()
+
+    const userStore = useUserStore()
+    userStore.currentUser = {
+      id: 'resume-me',
+      name: 'Me',
+      nickname: 'Me',
+      sex: '男',
+      ip: '127.0.0.1',
+      area: 'CN'
+    } as any
+    localStorage.setItem('authToken', 't-1')
+
+    const mediaStore = useMediaStore()
+    vi.spyOn(mediaStore, 'loadImgServer').mockResolvedValue(undefined)
+    vi.spyOn(mediaStore, 'loadCachedImages').mockResolvedValue(undefined)
+
+    const socket = useWebSocket()
+    socket.connect()
+    await FakeWebSocket.instances[0]!.triggerOpen()
+    const firstSign = JSON.parse(FakeWebSocket.instances[0]!.sent[0] || '{}')
+    expect(firstSign.lastSeq).toBeUndefined()
+
+    await FakeWebSocket.instances[0]!.triggerMessage({ code: 18, seq: 41, epoch: 'e1' })
+    await FakeWebSocket.instances[0]!.triggerMessage({ code: 18, seq: 42, epoch: 'e1' })
+    FakeWebSocket.instances[0]!.close()
+
+    await vi.advanceTimersByTimeAsync(3000)
+    await FakeWebSocket.instances[1]!.triggerOpen()
+    const sign = JSON.parse(FakeWebSocket.instances[1]!.sent[0] || '{}')
+    expect(sign.act).toBe('sign')
+    expect(sign.lastSeq).toBe(42)
+    expect(sign.lastEpoch).toBe('e1')
+
+    toastShow.mockClear()
+    await FakeWebSocket.instances[1]!.triggerMessage({ code: -9, content: '部分离线消息已超出缓存，请刷新聊天记录', resumed: true, truncated: true, epoch: 'e1', latestSeq: 50 })
+    expect(toastShow).toHaveBeenCalledWith('部分离线消息已超出缓存，请刷新聊天记录')
+
+    // 其他副本接管后 seq 重新编号：新纪元的帧即使 seq 更小也要覆盖续传令牌
+    await FakeWebSocket.instances[1]!.triggerMessage({ code: 18, seq: 3, epoch: 'e2' })
+    FakeWebSocket.instances[1]!.close()
+
+    await vi.advanceTimersByTimeAsync(3000)
+    await FakeWebSocket.instances[2]!.triggerOpen()
+    const resign = JSON.parse(FakeWebSocket.instances[2]!.sent[0] || '{}')
+    expect(resign.lastSeq).toBe(3)
+    expect(resign.lastEpoch).toBe('e2')
+  })
+
   it('manual disconnect prevents auto reconnect', async () => {
     vi.useFakeTimers()
 
@@ -849,6 +899,32 @@ describe('composables/useWebSocket', () => {
     expect(toastShow).toHaveBeenCalledWith('连接已断开，请刷新页面重试')
   })
 
+  it('shows toast on code=-11 outbound filter reject message', async () => {
+    const userStore = useUserStore()
+    userStore.currentUser = { id: 'me', name: 'Me', nickname: 'Me', sex: '男', ip: '', area: '' } as any
+    localStorage.setItem('authToken', 't-1')
+
+    const messageStore = useMessageStore()
+    const mediaStore = useMediaStore()
+    vi.spyOn(mediaStore, 'loadImgServer').mockResolvedValue(undefined)
+    vi.spyOn(mediaStore, 'loadCachedImages').mockResolvedValue(undefined)
+
+    const socket = useWebSocket()
+    socket.connect()
+    await FakeWebSocket.instances[0]!.triggerOpen()
+
+    const warnSpy = vi.spyOn(console, 'warn').mockImplementation(() => {})
+    try {
+      await FakeWebSocket.instances[0]!.triggerMessage({ code: -11, rejected: true, rule: 'phone', content: '消息包含手机号，消息未发送' })
+    } finally {
+      warnSpy.mockRestore()
+    }
+
+    expect(toastShow).toHaveBeenCalledWith('消息包含手机号，消息未发送')
+    expect(socket.forceoutFlag.value).toBe(false)
+    expect(messageStore.getMessages('me') || []).toHaveLength(0)
+  })
+
   it('shows toast on code=12 tip message', async () => {
     const userStore = useUserStore()
     userStore.currentUser = { id: 'me', name: 'Me', nickname: 'Me', sex: '男', ip: '', area: '' } as any
@@ -959,6 +1035,29 @@ describe('composables/useWebSocket', () => {
     expect(socket.forceoutFlag.value).toBe(true)
   })
 
+  it('marks forceoutFlag on code=-10 capacity reject message', async () => {
+    const userStore = useUserStore()
+    userStore.currentUser = { id: 'me', name: 'Me', nickname: 'Me', sex: '男', ip: '', area: '' } as any
+    localStorage.setItem('authToken', 't-1')
+
+    const mediaStore = useMediaStore()
+    vi.spyOn(mediaStore, 'loadImgServer').mockResolvedValue(undefined)
+    vi.spyOn(mediaStore, 'loadCachedImages').mockResolvedValue(undefined)
+
+    const socket = useWebSocket()
+    socket.connect()
+    await FakeWebSocket.instances[0]!.triggerOpen()
+
+    const errSpy = vi.spyOn(console, 'error').mockImplementation(() => {})
+    try {
+      await FakeWebSocket.instances[0]!.triggerMessage({ code: -10, rejected: true, capacity: 2, content: 'full' })
+    } finally {
+      errSpy.mockRestore()
+    }
+
+    expect(socket.forceoutFlag.value).toBe(true)
+  })
+
   it('forceout (code=-3) uses default error message when content is empty and clears token', async () => {
     const userStore = useUserStore()
     userStore.currentUser = { id: 'me', name: 'Me', nickname: 'Me', sex: '男', ip: '', area: '' } as any
@@ -1292,6 +1391,65 @@ describe('composables/useWebSocket', () => {
     expect(messageStore.getMessages('u2')[0]?.content).toBe('raw-msg')
   })
 
+  it('onclose handles guard close codes (4409 stops reconnect, 4429 reconnects)', async () => {
+    vi.useFakeTimers()
+    try {
+      const userStore = useUserStore()
+      userStore.currentUser = { id: 'me', name: 'Me', nickname: 'Me' } as any
+      localStorage.setItem('authToken', 't-1')
+
+      const mediaStore = useMediaStore()
+      vi.spyOn(mediaStore, 'loadImgServer').mockResolvedValue(undefined)
+      vi.spyOn(mediaStore, 'loadCachedImages').mockResolvedValue(undefined)
+
+      const socket = useWebSocket()
+      socket.connect()
+      await FakeWebSocket.instances[0]!.triggerOpen()
+
+      FakeWebSocket.instances[0]!.readyState = FakeWebSocket.CLOSED
+      FakeWebSocket.instances[0]!.onclose?.({ code: 4429 })
+      expect(toastShow).toHaveBeenCalledWith('发送过于频繁，连接已断开，稍后自动重连')
+      await vi.advanceTimersByTimeAsync(3000)
+      expect(FakeWebSocket.instances).toHaveLength(2)
+
+      await FakeWebSocket.instances[1]!.triggerOpen()
+      FakeWebSocket.instances[1]!.readyState = FakeWebSocket.CLOSED
+      FakeWebSocket.instances[1]!.onclose?.({ code: 4409 })
+      expect(toastShow).toHaveBeenCalledWith('当前账号在线连接数已达上限，请关闭其他页面后刷新')
+      await vi.advanceTimersByTimeAsync(3000)
+      expect(FakeWebSocket.instances).toHaveLength(2)
+    } finally {
+      vi.useRealTimers()
+    }
+  })
+
+  it('onclose 4401 clears login state and does not reconnect', async () => {
+    vi.useFakeTimers()
+    try {
+      const userStore = useUserStore()
+      userStore.currentUser = { id: 'me', name: 'Me', nickname: 'Me' } as any
+      localStorage.setItem('authToken', 't-1')
+      localStorage.setItem('authRefreshToken', 'sid.r1')
+
+      const mediaStore = useMediaStore()
+      vi.spyOn(mediaStore, 'loadImgServer').mockResolvedValue(undefined)
+      vi.spyOn(mediaStore, 'loadCachedImages').mockResolvedValue(undefined)
+
+      const socket = useWebSocket()
+      socket.connect()
+      await FakeWebSocket.instances[0]!.triggerOpen()
+
+      FakeWebSocket.instances[0]!.readyState = FakeWebSocket.CLOSED
+      FakeWebSocket.instances[0]!.onclose?.({ code: 4401 })
+      expect(localStorage.getItem('authToken')).toBeNull()
+      expect(localStorage.getItem('authRefreshToken')).toBeNull()
+      await vi.advanceTimersByTimeAsync(3000)
+      expect(FakeWebSocket.instances).toHaveLength(1)
+    } finally {
+      vi.useRealTimers()
+    }
+  })
+
   it('onclose cancels continuous match and does not reconnect when forceoutFlag is set', async () => {
     vi.useFakeTimers()
     try {
@@ -1537,4 +1695,75 @@ describe('composables/useWebSocket', () => {
     const ret = FakeWebSocket.instances[0]!.onmessage?.({ data: '' })
     if (ret && typeof (ret as Promise<any>).then === 'function') await ret
   })
+
+  it('falls back to SSE transport after repeated WebSocket failures before open', async () => {
+    vi.useFakeTimers()
+
+    class FakeEventSource {
+      static instances: FakeEventSource[] = []
+      readonly url: string
+      closed = false
+      onmessage: ((ev: any) => any) | null = null
+      onerror: ((ev?: any) => any) | null = null
+      listeners: Record<string, (ev: any) => any> = {}
+
+      constructor(url: string) {
+        this.url = url
+        FakeEventSource.instances.push(this)
+      }
+
+      addEventListener(type: string, fn: (ev: any) => any) {
+        this.listeners[type] = fn
+      }
+
+      close() {
+        this.closed = true
+      }
+    }
+    const fetchMock = vi.fn().mockResolvedValue({ ok: true, status: 200 })
+    vi.stubGlobal('EventSource', FakeEventSource as any)
+    vi.stubGlobal('fetch', fetchMock)
+
+    try {
+      const userStore = useUserStore()
+      userStore.currentUser = { id: 'me', name: 'Me', nickname: 'Me' } as any
+      localStorage.setItem('authToken', 't-1')
+
+      const mediaStore = useMediaStore()
+      vi.spyOn(mediaStore, 'loadImgServer').mockResolvedValue(undefined)
+      vi.spyOn(mediaStore, 'loadCachedImages').mockResolvedValue(undefined)
+
+      const socket = useWebSocket()
+      socket.connect()
+      FakeWebSocket.instances[0]!.close()
+      await vi.advanceTimersByTimeAsync(3000)
+      FakeWebSocket.instances[1]!.close()
+      await vi.advanceTimersByTimeAsync(3000)
+
+      expect(FakeWebSocket.instances).toHaveLength(2)
+      expect(FakeEventSource.instances).toHaveLength(1)
+      const source = FakeEventSource.instances[0]!
+      expect(source.url).toBe('/sse?token=t-1')
+
+      await source.listeners.session!({ data: JSON.stringify({ sessionId: 's1' }) })
+      await vi.advanceTimersByTimeAsync(0)
+      const chatStore = useChatStore()
+      expect(chatStore.wsConnected).toBe(true)
+      expect(fetchMock).toHaveBeenCalledWith('/sse/send?sessionId=s1', expect.objectContaining({ method: 'POST' }))
+      const sign = JSON.parse(fetchMock.mock.calls[0]![1].body)
+      expect(sign.act).toBe('sign')
+
+      await source.onmessage!({ data: JSON.stringify({ code: -11, rejected: true, content: '消息被拦截' }) })
+      expect(toastShow).toHaveBeenCalledWith('消息被拦截')
+
+      source.listeners.close!({ data: JSON.stringify({ code: 4409, reason: '会话数超限' }) })
+      expect(source.closed).toBe(true)
+      expect(chatStore.wsConnected).toBe(false)
+      expect(toastShow).toHaveBeenCalledWith('当前账号在线连接数已达上限，请关闭其他页面后刷新')
+    } finally {
+      sessionStorage.removeItem('downstreamTransport')
+      vi.unstubAllGlobals()
+      vi.useRealTimers()
+    }
+  })
 })
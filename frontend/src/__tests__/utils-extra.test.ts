@@ -2,7 +2,14 @@ import { describe, expect, it, vi } from 'vitest'
 
 import { generateCookie, parseCookie } from '@/utils/cookie'
 import { extractFileName, formatFileSize, getFileExtension, isImageFile, isVideoFile } from '@/utils/file'
-import { encodeLocalMediaUrl, extractRemoteFilePathFromImgUploadUrl, extractUploadLocalPath, inferMediaTypeFromUrl } from '@/utils/media'
+import {
+  encodeLocalMediaUrl,
+  extractRemoteFilePathFromImgUploadUrl,
+  extractUploadLocalPath,
+  inferMediaTypeFromUrl,
+  setMediaUrlSigner,
+  signMediaUrl
+} from '@/utils/media'
 
 describe('utils/cookie', () => {
   it('generateCookie includes userid/nickname/timestamp/random', () => {
@@ -60,6 +67,43 @@ describe('utils/file', () => {
 })
 
 describe('utils/media', () => {
+  it('signMediaUrl batches protected same-origin urls to the server signer', async () => {
+    setMediaUrlSigner(null)
+    expect(signMediaUrl('/upload/images/a.jpg')).toBe('/upload/images/a.jpg')
+
+    const expiresAt = Math.floor(Date.now() / 1000) + 3600
+    const signer = vi.fn(async (urls: string[]) => ({
+      urls: urls.map(url => `${url}${url.includes('?') ? '&' : '?'}exp=${expiresAt}&sig=s`),
+      expiresAt
+    }))
+    setMediaUrlSigner(signer)
+
+    // 签名到达前返回原地址，同一轮请求合并为一次签发。
+    expect(signMediaUrl('/upload/images/a.jpg')).toBe('/upload/images/a.jpg')
+    expect(signMediaUrl('/api/getMtPhotoThumb?size=s260&md5=m#top')).toBe('/api/getMtPhotoThumb?size=s260&md5=m#top')
+    expect(signMediaUrl(`${window.location.origin}/lsp/a.jpg`)).toBe(`${window.location.origin}/lsp/a.jpg`)
+    expect(encodeLocalMediaUrl('/lsp/a b.jpg')).toBe('/lsp/a%20b.jpg')
+    await vi.waitFor(() => expect(signer).toHaveBeenCalledTimes(1))
+    expect(signer).toHaveBeenCalledWith([
+      '/upload/images/a.jpg',
+      '/api/getMtPhotoThumb?size=s260&md5=m',
+      `${window.location.origin}/lsp/a.jpg`,
+      '/lsp/a%20b.jpg'
+    ])
+
+    await vi.waitFor(() => expect(signMediaUrl('/upload/images/a.jpg')).toBe(`/upload/images/a.jpg?exp=${expiresAt}&sig=s`))
+    expect(signMediaUrl('/api/getMtPhotoThumb?size=s260&md5=m#top')).toBe(`/api/getMtPhotoThumb?size=s260&md5=m&exp=${expiresAt}&sig=s#top`)
+    expect(encodeLocalMediaUrl('/lsp/a b.jpg')).toBe(`/lsp/a%20b.jpg?exp=${expiresAt}&sig=s`)
+    // 服务端已签名、外站与非媒体地址保持不变，也不请求签发
+    expect(signMediaUrl('/upload/a.jpg?exp=1&sig=server')).toBe('/upload/a.jpg?exp=1&sig=server')
+    expect(signMediaUrl('https://cdn.example.com/upload/a.jpg')).toBe('https://cdn.example.com/upload/a.jpg')
+    expect(signMediaUrl('/api/getHistoryUserList')).toBe('/api/getHistoryUserList')
+    expect(signer).toHaveBeenCalledTimes(1)
+
+    setMediaUrlSigner(null)
+    expect(signMediaUrl('/upload/images/a.jpg')).toBe('/upload/images/a.jpg')
+  })
+
   it('extractUploadLocalPath extracts /images/... from /upload/images/...', () => {
     expect(extractUploadLocalPath('http://localhost:8080/upload/images/2026/01/a.jpg')).toBe('/images/2026/01/a.jpg')
     expect(extractUploadLocalPath('/images/2026/01/a.jpg')).toBe('/images/2026/01/a.jpg')
@@ -67,6 +111,7 @@ describe('utils/media', () => {
     expect(extractUploadLocalPath('http://localhost:8080/upload/videos/2026/01/a.mp4')).toBe('/videos/2026/01/a.mp4')
     expect(extractUploadLocalPath('')).toBe('')
     expect(extractUploadLocalPath('not a url')).toBe('not a url')
+    expect(extractUploadLocalPath('http://localhost:8080/upload/images/a.jpg?exp=1&sig=x')).toBe('/images/a.jpg')
   })
 
   it('extractRemoteFilePathFromImgUploadUrl extracts after /img/Upload/', () => {
@@ -39,9 +39,12 @@ const uploadMocks = {
 
 const authStoreMocks = {
   login: vi.fn(),
+  loginWithPassword: vi.fn(),
   checkToken: vi.fn(),
   isAuthenticated: false,
-  loginLoading: false
+  loginLoading: false,
+  loginError: '',
+  totpRequired: false
 }
 
 vi.mock('@/composables/useToast', () => ({
@@ -104,6 +107,7 @@ const createTestRouter = () => {
 beforeEach(() => {
   vi.clearAllMocks()
   localStorage.clear()
+  authStoreMocks.totpRequired = false
 })
 
 describe('views/LoginPage.vue', () => {
@@ -179,6 +183,78 @@ describe('views/LoginPage.vue', () => {
     expect(authStoreMocks.login).toHaveBeenCalledWith('code')
     expect(pushSpy).toHaveBeenCalledWith('/identity')
   })
+
+  it('switches to account mode and logs in with username and password', async () => {
+    authStoreMocks.checkToken.mockResolvedValue(false)
+    authStoreMocks.loginWithPassword.mockResolvedValue(true)
+
+    const router = createTestRouter()
+    await router.push('/login')
+    await router.isReady()
+
+    const pushSpy = vi.spyOn(router, 'push')
+    pushSpy.mockClear()
+
+    const wrapper = mount(LoginPage, {
+      global: {
+        plugins: [router],
+        stubs: { Toast: true }
+      }
+    })
+
+    await wrapper.findAll('button')[1]!.trigger('click')
+    const inputs = wrapper.findAll('input')
+    await inputs[0]!.setValue(' alice ')
+    await inputs[1]!.setValue('password-1')
+    await wrapper.get('button').trigger('click')
+
+    await Promise.resolve()
+    await nextTick()
+
+    expect(authStoreMocks.loginWithPassword).toHaveBeenCalledWith('alice', 'password-1', '')
+    expect(authStoreMocks.login).not.toHaveBeenCalled()
+    expect(pushSpy).toHaveBeenCalledWith('/identity')
+  })
+
+  it('shows the TOTP input and submits the code when the account requires it', async () => {
+    authStoreMocks.checkToken.mockResolvedValue(false)
+    authStoreMocks.loginWithPassword.mockImplementationOnce(async () => {
+      authStoreMocks.totpRequired = true
+      return false
+    })
+    authStoreMocks.loginWithPassword.mockResolvedValueOnce(true)
+
+    const router = createTestRouter()
+    await router.push('/login')
+    await router.isReady()
+
+    const wrapper = mount(LoginPage, {
+      global: {
+        plugins: [router],
+        stubs: { Toast: true }
+      }
+    })
+
+    await wrapper.findAll('button')[1]!.trigger('click')
+    const inputs = wrapper.findAll('input')
+    await inputs[0]!.setValue('alice')
+    await inputs[1]!.setValue('password-1')
+    await wrapper.get('button').trigger('click')
+    await Promise.resolve()
+    await nextTick()
+
+    // 模拟的 store 不是响应式的，需手动触发重新渲染
+    await wrapper.vm.$forceUpdate()
+    await nextTick()
+    const totpInput = wrapper.find('input[autocomplete="one-time-code"]')
+    expect(totpInput.exists()).toBe(true)
+    await totpInput.setValue(' 123456 ')
+    await wrapper.get('button').trigger('click')
+    await Promise.resolve()
+    await nextTick()
+
+    expect(authStoreMocks.loginWithPassword).toHaveBeenLastCalledWith('alice', 'password-1', '123456')
+  })
 })
 
 describe('views/IdentityPicker.vue', () => {
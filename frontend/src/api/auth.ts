@@ -1,5 +1,5 @@
 import request, { createFormData } from './request'
-import type { ApiResponse } from '@/types'
+import type { ApiResponse, SignedMediaUrls } from '@/types'
 
 // 登录（使用urlencoded格式）
 export const login = (accessCode: string) => {
@@ -11,7 +11,31 @@ export const login = (accessCode: string) => {
   })
 }
 
+// 账号登录（使用urlencoded格式）；账号启用两步验证时需携带 totpCode（验证码或恢复码）
+export const loginWithPassword = (username: string, password: string, totpCode?: string) => {
+  const formData = createFormData({ username, password, totpCode: totpCode || undefined })
+  return request.post<any, ApiResponse>('/auth/login', formData, {
+    headers: {
+      'Content-Type': 'application/x-www-form-urlencoded'
+    }
+  })
+}
+
 // 验证Token
 export const verifyToken = () => {
   return request.get<any, ApiResponse>('/auth/verify')
 }
+
+// 退出登录（吊销当前会话）；显式携带 Token，调用方随后会清理本地登录状态
+export const logout = (token: string) => {
+  return request.post<any, ApiResponse>('/auth/logout', undefined, {
+    headers: {
+      Authorization: `Bearer ${token}`
+    }
+  })
+}
+
+// 为前端自行构造的 /upload、/lsp 等媒体地址逐个签发签名（MEDIA_URL_SIGNING 开启时）；传空数组可探测是否开启
+export const signMediaUrls = (urls: string[]) => {
+  return request.post<any, ApiResponse<SignedMediaUrls>>('/signMediaUrls', { urls })
+}
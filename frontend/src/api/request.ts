@@ -1,4 +1,4 @@
-import axios, { AxiosError } from 'axios'
+import axios, { AxiosError, type AxiosInstance, type InternalAxiosRequestConfig } from 'axios'
 import { API_BASE } from '@/constants/config'
 import type { ApiResponse } from '@/types'
 
@@ -19,6 +19,92 @@ export const navigation = {
   }
 }
 
+export const clearAuthStorage = () => {
+  localStorage.removeItem('authToken')
+  localStorage.removeItem('authRefreshToken')
+}
+
+let refreshing: Promise<string> | null = null
+
+// 用 refresh token 换发 access token（refresh token 同时轮换）；并发调用共享同一次请求，避免旧 refresh token 被重复使用而触发会话吊销。
+export const refreshAccessToken = (): Promise<string> => {
+  const refreshToken = localStorage.getItem('authRefreshToken')
+  if (!refreshToken) {
+    return Promise.reject(new Error('没有 refresh token'))
+  }
+  if (!refreshing) {
+    refreshing = axios
+      .post(`${API_BASE}/auth/refresh`, createFormData({ refreshToken }), {
+        headers: { 'Content-Type': 'application/x-www-form-urlencoded' }
+      })
+      .then(res => {
+        const data = res.data as ApiResponse
+        if (data?.code !== 0 || !data.token) {
+          throw new Error(data?.msg || '刷新登录状态失败')
+        }
+        localStorage.setItem('authToken', data.token)
+        if (data.refreshToken) {
+          localStorage.setItem('authRefreshToken', data.refreshToken)
+        }
+        return data.token
+      })
+      .finally(() => {
+        refreshing = null
+      })
+  }
+  return refreshing
+}
+
+// 读取 JWT 的 exp（秒）；解析失败返回 0
+const tokenExpiresAt = (token: string): number => {
+  try {
+    const payload = token.split('.')[1] || ''
+    return Number(JSON.parse(atob(payload.replace(/-/g, '+').replace(/_/g, '/')))?.exp) || 0
+  } catch {
+    return 0
+  }
+}
+
+// WebSocket/SSE 建连无法走 401 重试，建连前调用：access token 将在 30 秒内过期时先刷新。
+// 刷新被服务端拒绝（会话已吊销）时清除登录状态并跳转登录页。
+export const ensureFreshAccessToken = async (): Promise<string> => {
+  const token = localStorage.getItem('authToken') || ''
+  const expiresAt = tokenExpiresAt(token)
+  if (!localStorage.getItem('authRefreshToken') || (expiresAt && expiresAt * 1000 - Date.now() > 30_000)) {
+    return token
+  }
+  try {
+    return await refreshAccessToken()
+  } catch (error) {
+    if ((error as AxiosError)?.response?.status === 401) {
+      clearAuthStorage()
+      navigation.toLogin()
+      return ''
+    }
+    return token
+  }
+}
+
+// 401 时先尝试用 refresh token 续期并重放原请求（每个请求仅一次），失败再清除登录状态并跳转登录页
+const handleUnauthorized = async (instance: AxiosInstance, error: AxiosError) => {
+  if (error.response?.status !== 401) {
+    return Promise.reject(error)
+  }
+  const config = error.config as (InternalAxiosRequestConfig & { _retried?: boolean }) | undefined
+  if (config && !config._retried && localStorage.getItem('authRefreshToken')) {
+    config._retried = true
+    try {
+      await refreshAccessToken()
+      return instance(config)
+    } catch {
+      // 续期失败，按未登录处理
+    }
+  }
+  clearAuthStorage()
+  navigation.toLogin()
+  return Promise.reject(error)
+}
+
 // 请求拦截器：添加Token
 request.interceptors.request.use(
   config => {
@@ -50,13 +136,7 @@ request.interceptors.response.use(
     }
     return data
   },
-  (error: AxiosError) => {
-    if (error.response?.status === 401) {
-      localStorage.removeItem('authToken')
-      navigation.toLogin()
-    }
-    return Promise.reject(error)
-  }
+  (error: AxiosError) => handleUnauthorized(request, error)
 )
 
 // 辅助函数：创建URLSearchParams
@@ -100,13 +180,7 @@ douyinRequest.interceptors.response.use(
     }
     return data
   },
-  (error: AxiosError) => {
-    if (error.response?.status === 401) {
-      localStorage.removeItem('authToken')
-      navigation.toLogin()
-    }
-    return Promise.reject(error)
-  }
+  (error: AxiosError) => handleUnauthorized(douyinRequest, error)
 )
 
 export default request
@@ -14,7 +14,7 @@ export const useAuth = () => {
   }
 
   const logout = () => {
-    authStore.logout()
+    authStore.logout(true)
     router.push('/login')
   }
 
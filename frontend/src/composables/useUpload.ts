@@ -4,6 +4,7 @@ import * as mediaApi from '@/api/media'
 import { useSystemConfigStore } from '@/stores/systemConfig'
 import type { UploadedMedia } from '@/types'
 import { generateCookie } from '@/utils/cookie'
+import { signMediaUrl } from '@/utils/media'
 import { useToast } from '@/composables/useToast'
 
 export type UploadSource = 'local' | 'douyin' | 'mtphoto'
@@ -89,7 +90,7 @@ export const useUpload = () => {
           const posterLocalPath = String(res.posterLocalPath || '').trim()
           if (posterUrl) media.posterUrl = posterUrl
           else if (posterLocalPath) {
-            media.posterUrl = posterLocalPath.startsWith('/upload/') ? posterLocalPath : `/upload${posterLocalPath.startsWith('/') ? '' : '/'}${posterLocalPath}`
+            media.posterUrl = signMediaUrl(posterLocalPath.startsWith('/upload/') ? posterLocalPath : `/upload${posterLocalPath.startsWith('/') ? '' : '/'}${posterLocalPath}`)
           }
         }
 
@@ -122,10 +123,10 @@ export const useUpload = () => {
 
   const getMediaUrl = (input: string): string => {
     if (!input) return ''
-    if (input.startsWith('http://') || input.startsWith('https://')) return input
-    if (input.startsWith('/upload/')) return `${window.location.origin}${input}`
-    if (input.startsWith('/images/') || input.startsWith('/videos/')) return `${window.location.origin}/upload${input}`
-    return input
+    if (input.startsWith('http://') || input.startsWith('https://')) return signMediaUrl(input)
+    if (input.startsWith('/upload/')) return signMediaUrl(`${window.location.origin}${input}`)
+    if (input.startsWith('/images/') || input.startsWith('/videos/')) return signMediaUrl(`${window.location.origin}/upload${input}`)
+    return signMediaUrl(input)
   }
 
   return {
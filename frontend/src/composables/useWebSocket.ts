@@ -4,7 +4,7 @@ import { useChatStore } from '@/stores/chat'
 import { useMessageStore } from '@/stores/message'
 import { useUserStore } from '@/stores/user'
 import { generateCookie } from '@/utils/cookie'
-import { WS_URL } from '@/constants/config'
+import { SSE_SEND_URL, SSE_URL, WS_URL } from '@/constants/config'
 import type { WebSocketMessage, ChatMessage, User } from '@/types'
 import * as chatApi from '@/api/chat'
 import * as systemApi from '@/api/system'
@@ -13,6 +13,8 @@ import { useSystemConfigStore } from '@/stores/systemConfig'
 import { useToast } from '@/composables/useToast'
 import { emojiMap } from '@/constants/emoji'
 import { md5Hex } from '@/utils/md5'
+import { SSESocket } from '@/utils/sseSocket'
+import { clearAuthStorage, ensureFreshAccessToken } from '@/api/request'
 import router from '@/router'
 import { buildLastMsgPreviewFromSegments, getSegmentsMeta, parseMessageSegments } from '@/utils/messageSegments'
 
@@ -36,7 +38,15 @@ export const inferWsPrivateMessageIsSelf = (params: {
 }
 
 let activeConnection: ActiveWebSocketConnection | null = null
+// 每个身份最近收到的上游帧续传令牌（owner 纪元 epoch + 帧序号 seq），断线重连 sign 时携带 lastEpoch/lastSeq 以补发离线期间的消息；
+// seq 只在同一纪元内可比较，纪元变化（其他副本接管或服务端重启）时直接以新帧覆盖
+const resumeTokenByUser: Record<string, { epoch: string; seq: number }> = {}
 const forceoutFlag = ref(false)
+// WebSocket 连续多次在建立前就断开（网络剥离升级请求）时，改用 SSE 降级通道；选择记录在 sessionStorage，本标签页内生效
+const WS_FALLBACK_THRESHOLD = 2
+const TRANSPORT_STORAGE_KEY = 'downstreamTransport'
+let wsFailuresBeforeOpen = 0
+const useSSETransport = () => sessionStorage.getItem(TRANSPORT_STORAGE_KEY) === 'sse'
 
 // 滚动到底部的方法引用（全局单例）
 let scrollToBottomCallback: (() => void) | null = null
@@ -152,7 +162,11 @@ export const useWebSocket = () => {
     const wsUrlWithToken = `${scheme}://${window.location.host}${WS_URL}?token=${encodeURIComponent(token)}`
     console.log('正在连接 WebSocket (已携带认证信息)')
 
-    const socket = new WebSocket(wsUrlWithToken)
+    // SSESocket 实现了这里用到的 WebSocket 子集（readyState/send/close/on*）
+    const socket = useSSETransport()
+      ? (new SSESocket(SSE_URL, SSE_SEND_URL, token) as unknown as WebSocket)
+      : new WebSocket(wsUrlWithToken)
+    let opened = false
     const connection: ActiveWebSocketConnection = {
       socket,
       userId: desiredUserId
@@ -163,6 +177,8 @@ export const useWebSocket = () => {
       if (activeConnection !== connection) return
 
       console.log('WebSocket 连接成功')
+      opened = true
+      wsFailuresBeforeOpen = 0
       chatStore.wsConnected = true
       forceoutFlag.value = false
 
@@ -181,7 +197,10 @@ export const useWebSocket = () => {
         "randomvipcode": ""
       }
 
-      const signMsg = JSON.stringify(signMessage)
+      const resumeToken = resumeTokenByUser[String(currentUser.id)]
+      const signMsg = JSON.stringify(
+        resumeToken !== undefined ? { ...signMessage, lastSeq: resumeToken.seq, lastEpoch: resumeToken.epoch } : signMessage
+      )
       socket.send(signMsg)
       console.log('已发送登录消息:', signMsg)
 
@@ -228,6 +247,14 @@ export const useWebSocket = () => {
       try {
         const data: WebSocketMessage = JSON.parse(event.data)
         const code = Number((data as any)?.code)
+        const seq = Number((data as any)?.seq)
+        if (Number.isFinite(seq)) {
+          const epoch = String((data as any)?.epoch ?? '')
+          const token = resumeTokenByUser[connection.userId]
+          if (!token || token.epoch !== epoch || seq > token.seq) {
+            resumeTokenByUser[connection.userId] = { epoch, seq }
+          }
+        }
 
         // 检测forceout消息（code=-3, forceout=true）
         if (code === -3 && data.forceout === true) {
@@ -251,6 +278,33 @@ export const useWebSocket = () => {
           return
         }
 
+        // 在线身份已达上限且无可驱逐身份（code=-10, rejected=true）
+        if (code === -10 && (data as any)?.rejected === true) {
+          console.error('在线身份已达上限:', data.content)
+          forceoutFlag.value = true
+          window.location.href = `/?error=${encodeURIComponent(data.content || '当前在线身份已达上限，请稍后再试')}`
+          return
+        }
+
+        // 出站消息被后端过滤拦截（code=-11, rejected=true）：消息未发往上游，仅提示原因
+        if (code === -11 && (data as any)?.rejected === true) {
+          console.warn('消息被拦截:', data.content)
+          show(data.content || '消息包含敏感内容，未发送')
+          return
+        }
+
+        // 断线续传结果（code=-9）：补发已完成；truncated 表示部分消息已超出后端缓存，reset 表示纪元已变化
+        if (code === -9) {
+          const latestSeq = Number((data as any)?.latestSeq)
+          if (Number.isFinite(latestSeq)) {
+            resumeTokenByUser[connection.userId] = { epoch: String((data as any)?.epoch ?? ''), seq: latestSeq }
+          }
+          if ((data as any)?.truncated === true && data.content) {
+            show(data.content)
+          }
+          return
+        }
+
         // Code=12 单独处理（保留Toast提示）
         if (code === 12) {
           console.log('连接成功提示:', data)
@@ -664,29 +718,54 @@ export const useWebSocket = () => {
       chatStore.wsConnected = false
     }
 
-    socket.onclose = () => {
+    socket.onclose = (event?: CloseEvent) => {
       if (activeConnection !== connection) return
 
-      console.log('WebSocket 连接关闭')
+      console.log('WebSocket 连接关闭', event?.code)
       activeConnection = null
       chatStore.wsConnected = false
 
+      if (!opened && !useSSETransport() && typeof EventSource !== 'undefined') {
+        wsFailuresBeforeOpen++
+        if (wsFailuresBeforeOpen >= WS_FALLBACK_THRESHOLD) {
+          console.warn('WebSocket 多次无法建立，切换到 SSE 降级通道')
+          sessionStorage.setItem(TRANSPORT_STORAGE_KEY, 'sse')
+        }
+      }
+
       // WebSocket断开时取消连续匹配
       if (chatStore.continuousMatchConfig.enabled) {
         chatStore.cancelContinuousMatch()
         show('连接断开，连续匹配已取消')
       }
 
+      // 后端防滥用关闭码：4409 同一 token 会话数超限（不再重连），4429 发帧过于频繁（稍后重连）
+      const closeCode = Number(event?.code)
+      // 4401：登录会话已被吊销（退出登录/管理员吊销/账号变更），需要重新登录
+      if (closeCode === 4401) {
+        forceoutFlag.value = true
+        clearAuthStorage()
+        window.location.href = `/?error=${encodeURIComponent('登录已失效，请重新登录')}`
+        return
+      }
+      if (closeCode === 4409) {
+        show('当前账号在线连接数已达上限，请关闭其他页面后刷新')
+        return
+      }
+      if (closeCode === 4429) {
+        show('发送过于频繁，连接已断开，稍后自动重连')
+      }
+
       // 检查forceout标志
       if (forceoutFlag.value) {
         console.log('因forceout被禁止，跳过重连')
         return
       }
 
-      // 尝试重连
+      // 尝试重连（握手只能携带 query token，先确保 access token 未过期）
       setTimeout(() => {
         console.log('尝试重新连接...')
-        connect()
+        void ensureFreshAccessToken().then(() => connect())
       }, 3000)
     }
   }
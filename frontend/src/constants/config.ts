@@ -1,5 +1,8 @@
 export const API_BASE = '/api'
 export const WS_URL = '/ws'
+// WebSocket 升级被网络剥离时的 SSE 降级通道（下行 EventSource，上行 POST）
+export const SSE_URL = '/sse'
+export const SSE_SEND_URL = '/sse/send'
 // 上游媒体服务器默认端口（fixed 模式默认值）
 export const IMG_SERVER_IMAGE_PORT = 9006
 
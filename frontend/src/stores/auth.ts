@@ -1,25 +1,103 @@
 import { defineStore } from 'pinia'
 import { ref } from 'vue'
 import * as authApi from '@/api/auth'
+import type { AxiosError } from 'axios'
+import type { ApiResponse } from '@/types'
+import { setMediaUrlSigner } from '@/utils/media'
 
 export const useAuthStore = defineStore('auth', () => {
   const token = ref(localStorage.getItem('authToken') || '')
+  // 当前角色（admin/operator/viewer），用于前端隐藏无权限操作；真正的权限校验在服务端。
+  const role = ref(localStorage.getItem('authRole') || '')
   const isAuthenticated = ref(false)
   const loginLoading = ref(false)
+  // 登录被限流（429）时服务端返回的提示，如"请 N 秒后再试"；其余失败为空。
+  const loginError = ref('')
+  // 账号启用了两步验证，需要再提交验证码（或恢复码）完成登录。
+  const totpRequired = ref(false)
+
+  const setRole = (value?: string) => {
+    role.value = value || ''
+    if (role.value) {
+      localStorage.setItem('authRole', role.value)
+    } else {
+      localStorage.removeItem('authRole')
+    }
+  }
+
+  const applyLogin = (res: ApiResponse) => {
+    if (res.code === 0 && res.token) {
+      token.value = res.token
+      localStorage.setItem('authToken', res.token)
+      if (res.refreshToken) {
+        localStorage.setItem('authRefreshToken', res.refreshToken)
+      } else {
+        localStorage.removeItem('authRefreshToken')
+      }
+      setRole(res.role)
+      isAuthenticated.value = true
+      void loadMediaUrlSigning()
+      return true
+    }
+    return false
+  }
+
+  // 按需为前端自行构造的媒体地址批量签发签名
+  const requestMediaUrlSignatures = async (urls: string[]) => {
+    const res = await authApi.signMediaUrls(urls)
+    if (res?.code !== 0 || !res.data?.enabled) throw new Error(res?.msg || '媒体地址签名未开启')
+    return { urls: res.data.urls || [], expiresAt: res.data.expiresAt || 0 }
+  }
+
+  // 探测服务端是否开启媒体地址签名；开启时由 signMediaUrl 按需签发
+  const loadMediaUrlSigning = async () => {
+    try {
+      const res = await authApi.signMediaUrls([])
+      if (res?.code !== 0) return
+      setMediaUrlSigner(res.data?.enabled ? requestMediaUrlSignatures : null)
+    } catch (error) {
+      console.warn('获取媒体地址签名失败:', error)
+    }
+  }
+
+  const captureLoginError = (error: unknown) => {
+    const response = (error as AxiosError<ApiResponse>)?.response
+    if (response?.status === 429) {
+      loginError.value = response.data?.msg || '登录尝试过于频繁，请稍后再试'
+    } else if (response?.data?.totpRequired) {
+      loginError.value = response.data.msg || '两步验证码错误'
+    }
+  }
 
   const login = async (accessCode: string) => {
     loginLoading.value = true
+    loginError.value = ''
     try {
-      const res = await authApi.login(accessCode)
-      if (res.code === 0 && res.token) {
-        token.value = res.token
-        localStorage.setItem('authToken', res.token)
-        isAuthenticated.value = true
-        return true
-      }
+      return applyLogin(await authApi.login(accessCode))
+    } catch (error) {
+      console.error('登录失败:', error)
+      captureLoginError(error)
       return false
+    } finally {
+      loginLoading.value = false
+    }
+  }
+
+  const loginWithPassword = async (username: string, password: string, totpCode?: string) => {
+    loginLoading.value = true
+    loginError.value = ''
+    try {
+      const res = await authApi.loginWithPassword(username, password, totpCode)
+      if (res.totpRequired) {
+        totpRequired.value = true
+        loginError.value = res.msg || '请输入两步验证码'
+        return false
+      }
+      totpRequired.value = false
+      return applyLogin(res)
     } catch (error) {
       console.error('登录失败:', error)
+      captureLoginError(error)
       return false
     } finally {
       loginLoading.value = false
@@ -35,7 +113,13 @@ export const useAuthStore = defineStore('auth', () => {
     try {
       const res = await authApi.verifyToken()
       if (res.code === 0) {
+        // 校验期间 access token 可能已被拦截器续期
+        token.value = localStorage.getItem('authToken') || token.value
+        if (res.role) {
+          setRole(res.role)
+        }
         isAuthenticated.value = true
+        void loadMediaUrlSigning()
         return true
       }
       logout()
@@ -46,17 +130,29 @@ export const useAuthStore = defineStore('auth', () => {
     }
   }
 
-  const logout = () => {
+  // revoke 为 true 时通知服务端吊销当前会话（用户主动退出）；Token 已失效时仅清理本地状态
+  const logout = (revoke = false) => {
+    const current = localStorage.getItem('authToken')
+    if (revoke && current) {
+      void authApi.logout(current).catch(error => console.warn('退出登录失败:', error))
+    }
     token.value = ''
     localStorage.removeItem('authToken')
+    localStorage.removeItem('authRefreshToken')
+    setRole('')
+    setMediaUrlSigner(null)
     isAuthenticated.value = false
   }
 
   return {
     token,
+    role,
     isAuthenticated,
     loginLoading,
+    loginError,
+    totpRequired,
     login,
+    loginWithPassword,
     checkToken,
     logout
   }
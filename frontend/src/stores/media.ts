@@ -2,7 +2,7 @@ import { defineStore } from 'pinia'
 import { ref } from 'vue'
 import type { UploadedMedia } from '@/types'
 import * as mediaApi from '@/api/media'
-import { extractUploadLocalPath, inferMediaTypeFromUrl } from '@/utils/media'
+import { extractUploadLocalPath, inferMediaTypeFromUrl, signMediaUrl } from '@/utils/media'
 import { useSystemConfigStore } from '@/stores/systemConfig'
 
 export const useMediaStore = defineStore('media', () => {
@@ -59,7 +59,7 @@ export const useMediaStore = defineStore('media', () => {
           const filename = localPath.substring(localPath.lastIndexOf('/') + 1)
           // 视频缩略图：后端在落盘时生成 /videos/.../*.poster.jpg，前端可用作 poster。
           const posterUrl = type === 'video'
-            ? `/upload${localPath.replace(/\.[a-zA-Z0-9]+$/, '')}.poster.jpg`
+            ? signMediaUrl(`/upload${localPath.replace(/\.[a-zA-Z0-9]+$/, '')}.poster.jpg`)
             : undefined
           const relativePath = localPath.replace(/^\//, '')
           const uploadPath = relativePath.replace(/^images\//, '').replace(/^videos\//, '')
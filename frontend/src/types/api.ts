@@ -4,6 +4,11 @@ export interface ApiResponse<T = any> {
   message?: string
   data?: T
   token?: string
+  refreshToken?: string
+  expiresIn?: number
+  role?: string
+  // 账号启用两步验证但未提交或提交了错误的验证码时为 true
+  totpRequired?: boolean
 }
 
 export interface ConnectionStats {
@@ -18,6 +23,14 @@ export interface RuntimeConfig {
   randomVipCode: string
 }
 
+export interface SignedMediaUrls {
+  enabled: boolean
+  // 与请求顺序一致；非受保护地址原样返回
+  urls?: string[]
+  // 过期时间（Unix 秒）
+  expiresAt?: number
+}
+
 export interface SystemConfig {
   imagePortMode: ImagePortMode
   imagePortFixed: string
@@ -1,11 +1,91 @@
+import { reactive } from 'vue'
 import { isImageFile, isVideoFile } from './file'
 
+export type MediaUrlSigner = (urls: string[]) => Promise<{ urls: string[]; expiresAt: number }>
+
+const MEDIA_SIGNED_PREFIXES = ['/upload/', '/lsp/', '/api/getMtPhotoThumb', '/api/douyin/download', '/api/douyin/cover']
+// 与服务端 /api/signMediaUrls 单次上限一致
+const MEDIA_URL_SIGN_BATCH = 200
+// 剩余有效期不足该值时重新签发（期间沿用旧签名）
+const MEDIA_URL_REFRESH_MARGIN_MS = 60_000
+
+// 服务端按地址签发的签名缓存（键为未签名地址）；reactive 使已渲染的媒体地址在签名到达后重新计算。
+const signedMediaUrls = reactive(new Map<string, { url: string; expiresAt: number }>())
+const pendingMediaUrls = new Set<string>()
+const mediaUrlSigning = reactive({ signer: null as MediaUrlSigner | null })
+let mediaUrlFlushScheduled = false
+
+// 设置签发函数（登录后服务端开启签名时）；传 null 关闭签名并清空缓存
+export const setMediaUrlSigner = (signer: MediaUrlSigner | null) => {
+  mediaUrlSigning.signer = signer
+  signedMediaUrls.clear()
+  pendingMediaUrls.clear()
+}
+
+const scheduleMediaUrlFlush = () => {
+  if (mediaUrlFlushScheduled) return
+  mediaUrlFlushScheduled = true
+  setTimeout(() => void flushMediaUrlSigning(), 0)
+}
+
+// 把同一轮渲染中请求的地址合并为一次批量签发
+const flushMediaUrlSigning = async () => {
+  mediaUrlFlushScheduled = false
+  const signer = mediaUrlSigning.signer
+  if (!signer) return
+  const batch = Array.from(pendingMediaUrls).slice(0, MEDIA_URL_SIGN_BATCH)
+  batch.forEach(url => pendingMediaUrls.delete(url))
+  if (pendingMediaUrls.size > 0) scheduleMediaUrlFlush()
+  if (batch.length === 0) return
+
+  try {
+    const res = await signer(batch)
+    if (signer !== mediaUrlSigning.signer) return
+    const now = Date.now()
+    signedMediaUrls.forEach((entry, key) => {
+      if (entry.expiresAt <= now) signedMediaUrls.delete(key)
+    })
+    batch.forEach((raw, index) => {
+      const url = res.urls[index]
+      if (url) signedMediaUrls.set(raw, { url, expiresAt: res.expiresAt * 1000 })
+    })
+  } catch (error) {
+    console.warn('签发媒体地址失败:', error)
+  }
+}
+
+// 为本站受保护的媒体地址返回服务端签发的地址；签名未到达前返回原地址并在后台批量签发。
+// 已带 sig 的地址（服务端签发）与外站地址原样返回
+export const signMediaUrl = (url: string): string => {
+  const raw = String(url || '')
+  if (!mediaUrlSigning.signer || !raw) return raw
+
+  let path = raw
+  if (/^[a-z][a-z0-9+.-]*:\/\//i.test(raw)) {
+    if (typeof window === 'undefined' || !raw.startsWith(`${window.location.origin}/`)) return raw
+    path = raw.slice(window.location.origin.length)
+  }
+  if (!MEDIA_SIGNED_PREFIXES.some(prefix => path.startsWith(prefix))) return raw
+  if (/[?&]sig=/.test(raw)) return raw
+
+  const hashIndex = raw.indexOf('#')
+  const base = hashIndex >= 0 ? raw.slice(0, hashIndex) : raw
+  const hash = hashIndex >= 0 ? raw.slice(hashIndex) : ''
+  const cached = signedMediaUrls.get(base)
+  if (cached && cached.expiresAt - Date.now() > MEDIA_URL_REFRESH_MARGIN_MS) return `${cached.url}${hash}`
+  if (!pendingMediaUrls.has(base)) {
+    pendingMediaUrls.add(base)
+    scheduleMediaUrlFlush()
+  }
+  return `${cached ? cached.url : base}${hash}`
+}
+
 export const extractUploadLocalPath = (url: string): string => {
   if (!url) return ''
 
-  // URL格式：http://localhost:8080/upload/images/2025/12/19/xxx.jpg
+  // URL格式：http://localhost:8080/upload/images/2025/12/19/xxx.jpg（可能带签名参数）
   // 提取：/images/2025/12/19/xxx.jpg
-  const match = url.match(/\/upload(\/.+)$/)
+  const match = (url.split(/[?#]/)[0] || '').match(/\/upload(\/.+)$/)
   if (match && match[1]) return match[1]
 
   // 已经是 /images/... 或 /videos/... 的情况
@@ -35,7 +115,7 @@ export const encodeLocalMediaUrl = (url: string): string => {
     .map((part, index) => (index === 0 ? '' : encodeURIComponent(part)))
     .join('/')
 
-  return `${encodedPath}${suffix}`
+  return signMediaUrl(`${encodedPath}${suffix}`)
 }
 
 export const inferMediaTypeFromUrl = (url: string): 'image' | 'video' | 'file' => {
@@ -0,0 +1,97 @@
+// SSE 降级传输：WebSocket 升级被网络剥离时，用 EventSource 接收下行帧、POST 发送上行帧。
+// 对外暴露 WebSocket 的最小子集（readyState/send/close/on*），useWebSocket 可无差别使用。
+export class SSESocket {
+  static readonly CONNECTING = 0
+  static readonly OPEN = 1
+  static readonly CLOSING = 2
+  static readonly CLOSED = 3
+
+  readyState = SSESocket.CONNECTING
+  onopen: ((ev: any) => any) | null = null
+  onmessage: ((ev: { data: string }) => any) | null = null
+  onerror: ((ev: any) => any) | null = null
+  onclose: ((ev: { code: number; reason?: string }) => any) | null = null
+
+  private readonly source: EventSource
+  private readonly sendUrl: string
+  private readonly token: string
+  private sessionId = ''
+
+  constructor(streamUrl: string, sendUrl: string, token: string) {
+    this.sendUrl = sendUrl
+    this.token = token
+    this.source = new EventSource(`${streamUrl}?token=${encodeURIComponent(token)}`)
+
+    // 首个 session 事件携带 sessionId，之后才允许发送
+    this.source.addEventListener('session', (event) => {
+      try {
+        this.sessionId = String(JSON.parse((event as MessageEvent).data)?.sessionId || '')
+      } catch {
+        this.sessionId = ''
+      }
+      if (!this.sessionId) {
+        this.finish(1002, 'invalid session')
+        return
+      }
+      this.readyState = SSESocket.OPEN
+      this.onopen?.({})
+    })
+
+    this.source.onmessage = (event) => {
+      if (this.readyState !== SSESocket.OPEN) return
+      this.onmessage?.({ data: event.data })
+    }
+
+    // 服务端主动关闭（如 4409/4429）时以 close 事件告知关闭码
+    this.source.addEventListener('close', (event) => {
+      let code = 1000
+      let reason = ''
+      try {
+        const data = JSON.parse((event as MessageEvent).data)
+        code = Number(data?.code) || 1000
+        reason = String(data?.reason || '')
+      } catch {
+        // ignore
+      }
+      this.finish(code, reason)
+    })
+
+    // EventSource 断线会自行重连，但新流是新会话需要重新 sign，因此交给上层统一重连
+    this.source.onerror = (event) => {
+      this.onerror?.(event)
+      this.finish(1006)
+    }
+  }
+
+  send(data: string) {
+    if (this.readyState !== SSESocket.OPEN) {
+      throw new Error('SSE 通道未就绪')
+    }
+    void fetch(`${this.sendUrl}?sessionId=${encodeURIComponent(this.sessionId)}`, {
+      method: 'POST',
+      headers: {
+        // access token 可能已在建连后续期，优先使用最新值（服务端按登录会话校验归属）
+        Authorization: `Bearer ${localStorage.getItem('authToken') || this.token}`,
+        'Content-Type': 'application/json'
+      },
+      body: data
+    })
+      .then((res) => {
+        if (!res.ok) console.warn('SSE 上行消息发送失败:', res.status)
+      })
+      .catch((error) => {
+        console.warn('SSE 上行消息发送失败:', error)
+      })
+  }
+
+  close() {
+    this.finish(1000)
+  }
+
+  private finish(code: number, reason = '') {
+    if (this.readyState === SSESocket.CLOSED) return
+    this.readyState = SSESocket.CLOSED
+    this.source.close()
+    this.onclose?.({ code, reason })
+  }
+}
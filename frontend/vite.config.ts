@@ -20,6 +20,10 @@ export default defineConfig({
       '/ws': {
         target: 'ws://localhost:8080',
         ws: true
+      },
+      '/sse': {
+        target: 'http://localhost:8080',
+        changeOrigin: true
       }
     }
   },
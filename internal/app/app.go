@@ -76,6 +76,7 @@ var (
 			timeoutSeconds,
 		)
 	}
+	newWSClusterFn = NewWSCluster
 )
 
 // App 负责组装依赖并提供 HTTP Handler。
@@ -85,6 +86,21 @@ type App struct {
 
 	httpClient *http.Client
 	jwt        *JWTService
+	authUsers  *DBAuthUserService
+	// authSessions 为服务端登录会话（refresh token 与吊销列表），authConns 按会话登记下游连接以便吊销时关闭。
+	authSessions *DBAuthSessionService
+	authConns    *authConnRegistry
+	// authAPIKeys 为供脚本使用的长期 API Key，jwtMiddleware 将其作为 Token 之外的凭据。
+	authAPIKeys *DBAuthAPIKeyService
+	// authTOTP 为账号可选的 TOTP 两步验证，启用后账号登录需额外提交验证码或恢复码。
+	authTOTP *DBAuthTOTPService
+	// loginGuard 限制登录尝试频率，loginFailures 持久化失败记录供审查。
+	loginGuard    *LoginGuard
+	loginFailures *DBAuthLoginFailureService
+	// auditLogs 异步记录 /api 写操作审计（调用者、参数、结果与耗时）。
+	auditLogs *DBAuditLogService
+	// mediaURLSigner 非空（MEDIA_URL_SIGNING=true）时为媒体地址签名并校验免 Token 媒体请求。
+	mediaURLSigner *MediaURLSigner
 
 	systemConfig      *SystemConfigService
 	imagePortResolver *ImagePortResolver
@@ -104,8 +120,15 @@ type App struct {
 	userInfoCache         UserInfoCacheService
 	chatHistoryCache      ChatHistoryCacheService
 	userArchive           UserArchiveService
+	upstreamOutbox        *DBUpstreamOutboxService
+	autoReply             AutoReplyService
+	autoReplyEngine       *AutoReplyEngine
+	scheduledMessages     *DBScheduledMessageService
 	forceoutManager       *ForceoutManager
 	wsManager             *UpstreamWebSocketManager
+	wsConnectionEvents    *DBWSConnectionEventService
+	wsGuard               *WSGuard
+	sseSessions           *sseSessionRegistry
 
 	staticDir string
 	handler   http.Handler
@@ -177,6 +200,7 @@ func New(cfg config.Config) (*App, error) {
 		db:               db,
 		httpClient:       &http.Client{Timeout: time.Duration(cfg.UpstreamHTTPTimeoutSeconds) * time.Second},
 		jwt:              NewJWTService(cfg.JWTSecret, cfg.TokenExpireHours),
+		authConns:        newAuthConnRegistry(),
 		identityService:  NewIdentityService(db),
 		favoriteService:  NewFavoriteService(db),
 		douyinFavorite:   NewDouyinFavoriteService(db),
@@ -187,6 +211,7 @@ func New(cfg config.Config) (*App, error) {
 		userInfoCache:    userInfoCache,
 		chatHistoryCache: chatHistoryCache,
 		userArchive:      NewDBUserArchiveService(db),
+		upstreamOutbox:   NewDBUpstreamOutboxService(db),
 		forceoutManager:  NewForceoutManager(),
 		staticDir:        staticDir,
 	}
@@ -194,10 +219,123 @@ func New(cfg config.Config) (*App, error) {
 	systemDefaults.MtPhotoTimelineDeferSubfolderThreshold = cfg.MtPhotoTimelineDeferSubfolderThreshold
 	application.systemConfig = NewSystemConfigService(db, systemDefaults)
 	application.imagePortResolver = NewImagePortResolver(application.httpClient)
+	if users := NewDBAuthUserService(db); users != nil {
+		application.authUsers = users
+		if created, err := users.EnsureBootstrapAdmin(context.Background(), cfg.AuthAdminUsername, cfg.AuthAdminPassword); err != nil {
+			slog.Warn("创建初始管理员账号失败", "username", cfg.AuthAdminUsername, "error", err)
+		} else if created {
+			slog.Info("已创建初始管理员账号", "username", cfg.AuthAdminUsername)
+		}
+	}
+	if sessions := NewDBAuthSessionService(db, time.Duration(cfg.TokenExpireHours)*time.Hour); sessions != nil {
+		application.authSessions = sessions
+		application.jwt.SetAccessTokenTTL(time.Duration(cfg.AccessTokenExpireMinutes) * time.Minute)
+		sessions.SetOnRevoke(application.authConns.closeSessions)
+		application.authUsers.SetOnCredentialChange(func(ctx context.Context, username string) {
+			if _, err := sessions.RevokeAccount(ctx, username, AuthSessionRevokeAccount); err != nil {
+				slog.Warn("吊销账号会话失败", "username", username, "error", err)
+			}
+		})
+		sessions.Start()
+	}
+	application.authAPIKeys = NewDBAuthAPIKeyService(db)
+	application.authTOTP = NewDBAuthTOTPService(db, cfg.AuthTOTPIssuer)
+	application.loginGuard = newLoginGuardFromConfig(cfg)
+	application.loginFailures = NewDBAuthLoginFailureService(db)
+	application.auditLogs = NewDBAuditLogService(db, time.Duration(cfg.AuditLogRetentionDays)*24*time.Hour)
+	if cfg.MediaURLSigning {
+		application.mediaURLSigner = NewMediaURLSigner(cfg.MediaURLSecret, time.Duration(cfg.MediaURLTTLSeconds)*time.Second)
+	}
 	_ = application.systemConfig.EnsureDefaults(context.Background())
+	application.forceoutManager.SetDuration(time.Duration(cfg.ForceoutBanSeconds) * time.Second)
+	if store := NewDBForceoutEventService(db); store != nil {
+		if err := application.forceoutManager.SetStore(store); err != nil {
+			slog.Warn("加载持久化 forceout 禁止失败", "error", err)
+		}
+	}
 	application.wsManager = NewUpstreamWebSocketManager(application.httpClient, cfg.WebSocketFallback, application.forceoutManager, application.userInfoCache, application.chatHistoryCache, application.userArchive)
+	if application.upstreamOutbox != nil {
+		application.wsManager.SetOutbox(application.upstreamOutbox)
+	}
+	if events := NewDBWSConnectionEventService(db); events != nil {
+		application.wsConnectionEvents = events
+		application.wsManager.SetConnectionEventStore(events)
+	}
+	if autoReply := NewDBAutoReplyService(db); autoReply != nil {
+		application.autoReply = autoReply
+		application.autoReplyEngine = NewAutoReplyEngine(autoReply, application.wsManager.SendToUpstream)
+		_ = application.wsManager.Pipeline().Register(UpstreamStageAutoReply, application.autoReplyEngine, 7)
+		application.wsManager.SetKeepAlive(application.autoReplyEngine.KeepsAlive)
+	}
+	if scheduled := NewDBScheduledMessageService(db); scheduled != nil {
+		application.scheduledMessages = scheduled
+		scheduled.Start(application.wsManager.SendScheduled)
+	}
+	evictionPolicy, err := ParseIdentityEvictionPolicy(cfg.WSEvictionPolicy)
+	if err != nil {
+		slog.Warn("驱逐策略非法，使用默认策略", "policy", cfg.WSEvictionPolicy, "error", err)
+	}
+	identityCloseDelays := make(map[string]time.Duration, len(cfg.WSIdentityCloseDelaySeconds))
+	for userID, seconds := range cfg.WSIdentityCloseDelaySeconds {
+		identityCloseDelays[userID] = time.Duration(seconds) * time.Second
+	}
+	application.wsManager.ConfigureScheduler(IdentitySchedulerConfig{
+		Capacity:    cfg.WSMaxIdentities,
+		Policy:      evictionPolicy,
+		Pinned:      cfg.WSPinnedIdentities,
+		CloseDelay:  time.Duration(cfg.WSCloseDelaySeconds) * time.Second,
+		CloseDelays: identityCloseDelays,
+	})
+	application.wsManager.ConfigureHeartbeat(WSHeartbeatConfig{
+		UpstreamInterval:   time.Duration(cfg.WSUpstreamPingSeconds) * time.Second,
+		DownstreamInterval: time.Duration(cfg.WSDownstreamPingSeconds) * time.Second,
+		MaxMissed:          cfg.WSPingMaxMissed,
+	})
+	application.wsGuard = NewWSGuard(WSGuardConfigFromConfig(cfg))
+	application.sseSessions = newSSESessionRegistry()
+	if err := configureOutboundFilter(application.wsManager.OutboundFilter(), cfg); err != nil {
+		slog.Warn("出站过滤配置非法，已关闭对应规则", "error", err)
+	}
+	if recorder := NewWSSessionRecorder(cfg.WSRecordDir); recorder != nil {
+		for _, userID := range cfg.WSRecordUserIDs {
+			if _, err := recorder.Enable(userID); err != nil {
+				slog.Warn("开启WebSocket录制失败", "userID", userID, "error", err)
+			}
+		}
+		application.wsManager.SetRecorder(recorder)
+	}
+	if cfg.WSClusterEnabled {
+		cluster, err := newWSClusterFn(
+			cfg.RedisURL,
+			cfg.RedisHost,
+			cfg.RedisPort,
+			cfg.RedisPassword,
+			cfg.RedisDB,
+			cfg.WSClusterReplicaID,
+			cfg.WSClusterKeyPrefix,
+			cfg.WSClusterLeaseSeconds,
+			cfg.RedisTimeoutSeconds,
+		)
+		if err == nil {
+			if err = application.wsManager.SetCluster(cluster); err != nil {
+				_ = cluster.Close()
+			}
+		}
+		if err != nil {
+			if closer, ok := userInfoCache.(interface{ Close() error }); ok {
+				_ = closer.Close()
+			}
+			if closer, ok := chatHistoryCache.(interface{ Close() error }); ok {
+				_ = closer.Close()
+			}
+			_ = db.Close()
+			return nil, err
+		}
+	}
 	application.mediaUpload = NewMediaUploadService(db, cfg.ServerPort, application.fileStorage, application.imageServer, application.httpClient)
+	application.mediaUpload.SetURLSigner(application.mediaURLSigner)
 	application.douyinDownloader = NewDouyinDownloaderService(cfg.TikTokDownloaderBaseURL, cfg.TikTokDownloaderToken, cfg.DouyinDefaultCookie, cfg.DouyinDefaultProxy, time.Duration(cfg.TikTokDownloaderTimeoutSeconds)*time.Second)
+	application.douyinDownloader.SetURLSigner(application.mediaURLSigner)
 	if strings.TrimSpace(cfg.CookieCloudBaseURL) != "" {
 		provider, err := NewDouyinCookieCloudProvider(cfg, application.httpClient)
 		if err != nil {
@@ -215,6 +353,7 @@ func New(cfg config.Config) (*App, error) {
 	application.mtPhoto = NewMtPhotoService(cfg.MtPhotoBaseURL, cfg.MtPhotoAPIKey, cfg.LspRoot, application.httpClient)
 	application.mtPhotoFolderFavorite = NewMtPhotoFolderFavoriteService(db)
 	application.videoExtract = NewVideoExtractService(db, cfg, application.fileStorage, application.mtPhoto)
+	application.videoExtract.SetURLSigner(application.mediaURLSigner)
 
 	application.handler = application.buildRouter()
 	return application, nil
@@ -227,6 +366,34 @@ func (a *App) Handler() http.Handler {
 func (a *App) Shutdown(ctx context.Context) {
 	if a.wsManager != nil {
 		a.wsManager.CloseAllConnections()
+		_ = a.wsManager.Cluster().Close()
+		_ = a.wsManager.Recorder().Close()
+	}
+	if a.scheduledMessages != nil {
+		_ = a.scheduledMessages.Close()
+	}
+	if a.upstreamOutbox != nil {
+		_ = a.upstreamOutbox.Close()
+	}
+	if a.authSessions != nil {
+		_ = a.authSessions.Close()
+	}
+	if a.wsConnectionEvents != nil {
+		_ = a.wsConnectionEvents.Close()
+	}
+	if a.loginFailures != nil {
+		_ = a.loginFailures.Close()
+	}
+	if a.auditLogs != nil {
+		_ = a.auditLogs.Close()
+	}
+	if a.authAPIKeys != nil {
+		_ = a.authAPIKeys.Close()
+	}
+	if a.loginGuard != nil {
+		if closer, ok := a.loginGuard.store.(interface{ Close() error }); ok {
+			_ = closer.Close()
+		}
 	}
 	if a.videoExtract != nil {
 		a.videoExtract.Shutdown()
@@ -0,0 +1,113 @@
+package app
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// asyncWriter 为有界异步写入队列：Enqueue 不阻塞调用方，队列满或已关闭时丢弃并计数；Close 前写完已入队的记录。
+type asyncWriter[T any] struct {
+	label   string
+	timeout time.Duration
+	write   func(ctx context.Context, item T) error
+	// attrs 返回写入失败或丢弃时附加到日志的字段。
+	attrs func(item T) []any
+
+	queue   chan T
+	dropped atomic.Int64
+
+	stopCh    chan struct{}
+	wg        sync.WaitGroup
+	closeOnce sync.Once
+}
+
+// newAsyncWriter 创建写入队列并启动后台写入协程；label 用于日志，如 "连接事件"。
+func newAsyncWriter[T any](label string, size int, timeout time.Duration, write func(context.Context, T) error, attrs func(T) []any) *asyncWriter[T] {
+	w := &asyncWriter[T]{
+		label:   label,
+		timeout: timeout,
+		write:   write,
+		attrs:   attrs,
+		queue:   make(chan T, size),
+		stopCh:  make(chan struct{}),
+	}
+	w.wg.Add(1)
+	go w.loop()
+	return w
+}
+
+func (w *asyncWriter[T]) Enqueue(item T) {
+	if w == nil {
+		return
+	}
+	select {
+	case <-w.stopCh:
+		w.dropped.Add(1)
+		return
+	default:
+	}
+	select {
+	case w.queue <- item:
+	default:
+		if w.dropped.Add(1)%100 == 1 {
+			slog.Warn(w.label+"队列已满，丢弃记录", append(w.logAttrs(item), "dropped", w.dropped.Load())...)
+		}
+	}
+}
+
+// Dropped 返回因队列已满或已关闭而丢弃的记录数。
+func (w *asyncWriter[T]) Dropped() int64 {
+	if w == nil {
+		return 0
+	}
+	return w.dropped.Load()
+}
+
+func (w *asyncWriter[T]) loop() {
+	defer w.wg.Done()
+	for {
+		select {
+		case <-w.stopCh:
+			// 关闭前写完已入队的记录。
+			for {
+				select {
+				case item := <-w.queue:
+					w.writeOne(item)
+				default:
+					return
+				}
+			}
+		case item := <-w.queue:
+			w.writeOne(item)
+		}
+	}
+}
+
+func (w *asyncWriter[T]) writeOne(item T) {
+	ctx, cancel := context.WithTimeout(context.Background(), w.timeout)
+	defer cancel()
+	if err := w.write(ctx, item); err != nil {
+		slog.Warn("写入"+w.label+"失败", append(w.logAttrs(item), "error", err)...)
+	}
+}
+
+func (w *asyncWriter[T]) logAttrs(item T) []any {
+	if w.attrs == nil {
+		return nil
+	}
+	return w.attrs(item)
+}
+
+// Close 停止接收新记录并等待已入队的记录写完，可重复调用。
+func (w *asyncWriter[T]) Close() {
+	if w == nil {
+		return
+	}
+	w.closeOnce.Do(func() {
+		close(w.stopCh)
+		w.wg.Wait()
+	})
+}
@@ -0,0 +1,263 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"liao/internal/database"
+)
+
+const (
+	auditLogQueueSize     = 1024
+	auditLogPurgeInterval = time.Hour
+	// 截断后会追加 "..."，留出余量以不超过列宽（80/255）。
+	auditLogMaxSubjectRunes   = 76
+	auditLogMaxPathRunes      = 250
+	auditLogMaxUserAgentRunes = 250
+)
+
+// auditLogWriteTimeout 为后台写入单条记录的超时。
+var auditLogWriteTimeout = 5 * time.Second
+
+// AuditLog 为一次写操作 API 调用的审计记录；Params 为打码后的请求参数 JSON。
+type AuditLog struct {
+	ID         int64  `json:"id"`
+	Subject    string `json:"subject"`
+	Role       string `json:"role"`
+	APIKeyID   int64  `json:"apiKeyId,omitempty"`
+	Method     string `json:"method"`
+	Path       string `json:"path"`
+	Params     string `json:"params,omitempty"`
+	Status     int    `json:"status"`
+	ResultCode *int   `json:"resultCode,omitempty"`
+	LatencyMs  int64  `json:"latencyMs"`
+	RemoteIP   string `json:"remoteIp,omitempty"`
+	UserAgent  string `json:"userAgent,omitempty"`
+	CreateTime string `json:"createTime"`
+
+	createdAt time.Time
+}
+
+// AuditLogQuery 为审计记录查询条件；Path 按前缀匹配，Status 为 0 时不限制，Page 从 1 开始。
+type AuditLogQuery struct {
+	Subject  string
+	Path     string
+	Method   string
+	Status   int
+	Since    time.Time
+	Until    time.Time
+	Page     int
+	PageSize int
+}
+
+// DBAuditLogService 持久化写操作审计记录；记录经有界队列由后台协程写入，不阻塞请求，
+// retention 大于 0 时定期删除超期记录。
+type DBAuditLogService struct {
+	db        *database.DB
+	retention time.Duration
+	writer    *asyncWriter[AuditLog]
+
+	stopCh    chan struct{}
+	wg        sync.WaitGroup
+	closeOnce sync.Once
+}
+
+// NewDBAuditLogService 创建审计记录服务并启动后台写入（及清理）协程；retention 为 0 表示永久保留。
+func NewDBAuditLogService(db *database.DB, retention time.Duration) *DBAuditLogService {
+	if db == nil {
+		return nil
+	}
+	svc := &DBAuditLogService{
+		db:        db,
+		retention: retention,
+		stopCh:    make(chan struct{}),
+	}
+	svc.writer = newAsyncWriter("审计记录", auditLogQueueSize, auditLogWriteTimeout, svc.Record,
+		func(item AuditLog) []any {
+			return []any{"subject", item.Subject, "path", item.Path}
+		})
+	if retention > 0 {
+		svc.wg.Add(1)
+		go svc.purgeLoop(auditLogPurgeInterval)
+	}
+	return svc
+}
+
+func (s *DBAuditLogService) Enqueue(item AuditLog) {
+	if s == nil {
+		return
+	}
+	if item.createdAt.IsZero() {
+		item.createdAt = time.Now()
+	}
+	s.writer.Enqueue(item)
+}
+
+// Dropped 返回因队列已满或服务已关闭而丢弃的记录数。
+func (s *DBAuditLogService) Dropped() int64 {
+	if s == nil {
+		return 0
+	}
+	return s.writer.Dropped()
+}
+
+// Record 同步写入一条记录。
+func (s *DBAuditLogService) Record(ctx context.Context, item AuditLog) error {
+	if s == nil || s.db == nil {
+		return fmt.Errorf("db not initialized")
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	createdAt := item.createdAt
+	if createdAt.IsZero() {
+		createdAt = time.Now()
+	}
+	var apiKeyID, resultCode any
+	if item.APIKeyID > 0 {
+		apiKeyID = item.APIKeyID
+	}
+	if item.ResultCode != nil {
+		resultCode = *item.ResultCode
+	}
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO audit_log (subject, role, api_key_id, method, path, params, status, result_code, latency_ms, remote_ip, user_agent, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, truncateRunes(item.Subject, auditLogMaxSubjectRunes), item.Role, apiKeyID, item.Method,
+		truncateRunes(item.Path, auditLogMaxPathRunes), nullIfEmpty(item.Params), item.Status, resultCode,
+		max(item.LatencyMs, 0), nullIfEmpty(truncateRunes(item.RemoteIP, 60)),
+		nullIfEmpty(truncateRunes(item.UserAgent, auditLogMaxUserAgentRunes)), createdAt)
+	return err
+}
+
+// List 按时间倒序分页返回审计记录及满足条件的总数。
+func (s *DBAuditLogService) List(ctx context.Context, query AuditLogQuery) ([]AuditLog, int, error) {
+	if s == nil || s.db == nil {
+		return nil, 0, fmt.Errorf("db not initialized")
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	var where strings.Builder
+	where.WriteString("1 = 1")
+	args := make([]any, 0, 6)
+	if subject := strings.TrimSpace(query.Subject); subject != "" {
+		where.WriteString(" AND subject = ?")
+		args = append(args, subject)
+	}
+	if path := strings.TrimSpace(query.Path); path != "" {
+		where.WriteString(" AND path LIKE ?")
+		args = append(args, path+"%")
+	}
+	if method := strings.ToUpper(strings.TrimSpace(query.Method)); method != "" {
+		where.WriteString(" AND method = ?")
+		args = append(args, method)
+	}
+	if query.Status > 0 {
+		where.WriteString(" AND status = ?")
+		args = append(args, query.Status)
+	}
+	if !query.Since.IsZero() {
+		where.WriteString(" AND created_at >= ?")
+		args = append(args, query.Since)
+	}
+	if !query.Until.IsZero() {
+		where.WriteString(" AND created_at < ?")
+		args = append(args, query.Until)
+	}
+
+	var total int
+	if err := s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM audit_log WHERE `+where.String(), args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	page, pageSize := normalizePage(query.Page, query.PageSize)
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, subject, role, api_key_id, method, path, params, status, result_code, latency_ms, remote_ip, user_agent, created_at
+		FROM audit_log
+		WHERE `+where.String()+`
+		ORDER BY created_at DESC, id DESC
+		LIMIT ? OFFSET ?
+	`, append(args, pageSize, (page-1)*pageSize)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	out := make([]AuditLog, 0)
+	for rows.Next() {
+		var item AuditLog
+		var apiKeyID, resultCode sql.NullInt64
+		var params, remoteIP, userAgent sql.NullString
+		var created sql.NullTime
+		if err := rows.Scan(&item.ID, &item.Subject, &item.Role, &apiKeyID, &item.Method, &item.Path, &params,
+			&item.Status, &resultCode, &item.LatencyMs, &remoteIP, &userAgent, &created); err != nil {
+			return nil, 0, err
+		}
+		item.APIKeyID = apiKeyID.Int64
+		if resultCode.Valid {
+			code := int(resultCode.Int64)
+			item.ResultCode = &code
+		}
+		item.Params = params.String
+		item.RemoteIP = remoteIP.String
+		item.UserAgent = userAgent.String
+		item.CreateTime = formatNullLocalDateTimeISO(created)
+		out = append(out, item)
+	}
+	return out, total, rows.Err()
+}
+
+// Purge 删除 before 之前的审计记录，返回删除行数。
+func (s *DBAuditLogService) Purge(ctx context.Context, before time.Time) (int64, error) {
+	if s == nil || s.db == nil {
+		return 0, fmt.Errorf("db not initialized")
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	res, err := s.db.ExecContext(ctx, "DELETE FROM audit_log WHERE created_at < ?", before)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func (s *DBAuditLogService) purgeLoop(interval time.Duration) {
+	defer s.wg.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stopCh:
+			return
+		case <-ticker.C:
+			n, err := s.Purge(context.Background(), time.Now().Add(-s.retention))
+			if err != nil {
+				slog.Warn("清理审计记录失败", "error", err)
+				continue
+			}
+			if n > 0 {
+				slog.Info("清理审计记录", "deleted", n)
+			}
+		}
+	}
+}
+
+func (s *DBAuditLogService) Close() error {
+	if s == nil {
+		return nil
+	}
+	s.closeOnce.Do(func() {
+		close(s.stopCh)
+		s.wg.Wait()
+	})
+	s.writer.Close()
+	return nil
+}
@@ -0,0 +1,59 @@
+package app
+
+import (
+	"net/http"
+	"strings"
+	"time"
+)
+
+// handleListAuditLogs 分页查询写操作审计记录，支持 subject/path（前缀）/method/status/since/until 过滤。
+func (a *App) handleListAuditLogs(w http.ResponseWriter, r *http.Request) {
+	if a.auditLogs == nil {
+		writeJSON(w, http.StatusOK, map[string]any{"code": -1, "msg": "审计记录存储未初始化"})
+		return
+	}
+
+	q := r.URL.Query()
+	query := AuditLogQuery{
+		Subject:  strings.TrimSpace(q.Get("subject")),
+		Path:     strings.TrimSpace(q.Get("path")),
+		Method:   strings.TrimSpace(q.Get("method")),
+		Status:   parseIntDefault(q.Get("status"), 0),
+		Page:     parseIntDefault(q.Get("page"), 1),
+		PageSize: parseIntDefault(q.Get("pageSize"), wsConnectionEventDefaultPageSize),
+	}
+	for _, item := range []struct {
+		name   string
+		target *time.Time
+	}{{"since", &query.Since}, {"until", &query.Until}} {
+		raw := strings.TrimSpace(q.Get(item.name))
+		if raw == "" {
+			continue
+		}
+		t := parseOptionalLocalDateTimeISO(raw)
+		if t == nil {
+			writeJSON(w, http.StatusBadRequest, map[string]any{"code": 400, "msg": item.name + "时间格式非法"})
+			return
+		}
+		*item.target = *t
+	}
+
+	items, total, err := a.auditLogs.List(r.Context(), query)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]any{"code": -1, "msg": "查询审计记录失败: " + err.Error()})
+		return
+	}
+	page, pageSize := normalizePage(query.Page, query.PageSize)
+	writeJSON(w, http.StatusOK, map[string]any{
+		"code": 0,
+		"msg":  "success",
+		"data": map[string]any{
+			"items":      items,
+			"total":      total,
+			"page":       page,
+			"pageSize":   pageSize,
+			"totalPages": calcTotalPages(total, pageSize),
+			"dropped":    a.auditLogs.Dropped(),
+		},
+	})
+}
@@ -0,0 +1,232 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+const (
+	// auditLogMaxBodyBytes 为 JSON 请求体参与审计的最大字节数，超出部分照常交给 handler 但不记录。
+	auditLogMaxBodyBytes = 64 << 10
+	// auditLogMaxParamsRunes 为参数 JSON 的最大字符数（TEXT 列按 utf8mb4 最坏情况留足余量）。
+	auditLogMaxParamsRunes  = 16000
+	auditLogMaxValueRunes   = 512
+	auditLogResponseSniffed = 512
+	auditLogRedacted        = "***"
+)
+
+var (
+	// auditLogSensitiveKeys 为参数名（小写）包含即打码的片段。
+	auditLogSensitiveKeys = []string{"password", "token", "secret", "cookie", "accesscode", "apikey", "authorization", "credential", "totp"}
+	// auditLogViewerMutations 虽在 authViewerPostPaths 中，但会改变调用者自身状态，仍需记录。
+	auditLogViewerMutations = map[string]bool{
+		"/api/auth/changePassword":     true,
+		"/api/auth/logout":             true,
+		"/api/auth/totp/setup":         true,
+		"/api/auth/totp/enable":        true,
+		"/api/auth/totp/disable":       true,
+		"/api/auth/totp/recoveryCodes": true,
+	}
+
+	auditLogResultCodePattern = regexp.MustCompile(`"code"\s*:\s*(-?\d+)`)
+)
+
+// auditable 判断请求是否需要审计：仅记录写方法，只读查询类 POST 除外。
+func auditable(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return false
+	}
+	return !authViewerPostPaths[r.URL.Path] || auditLogViewerMutations[r.URL.Path]
+}
+
+// auditMiddleware 记录已鉴权调用者的写操作：调用者、路径、打码后的参数、HTTP 状态、响应 code 与耗时。
+// 挂在 jwtMiddleware 之后、roleMiddleware 之前，权限不足被拒绝的调用同样留痕。
+func (a *App) auditMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal, ok := authPrincipalFromContext(r.Context())
+		if a.auditLogs == nil || !ok || !auditable(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		start := time.Now()
+		body := captureAuditJSONBody(r)
+		sniff := &auditLogPrefixBuffer{limit: auditLogResponseSniffed}
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		ww.Tee(sniff)
+
+		next.ServeHTTP(ww, r)
+
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		a.auditLogs.Enqueue(AuditLog{
+			Subject:    principal.Subject,
+			Role:       principal.Role,
+			APIKeyID:   principal.APIKeyID,
+			Method:     r.Method,
+			Path:       r.URL.Path,
+			Params:     auditLogParams(r, body),
+			Status:     status,
+			ResultCode: parseAuditResultCode(sniff.Bytes()),
+			LatencyMs:  time.Since(start).Milliseconds(),
+			RemoteIP:   a.loginClientIP(r),
+			UserAgent:  r.UserAgent(),
+			createdAt:  start,
+		})
+	})
+}
+
+// captureAuditJSONBody 读取 JSON 请求体的前 auditLogMaxBodyBytes 字节用于审计，并还原 r.Body 供 handler 完整读取。
+func captureAuditJSONBody(r *http.Request) []byte {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil
+	}
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != "application/json" {
+		return nil
+	}
+	buf, err := io.ReadAll(io.LimitReader(r.Body, auditLogMaxBodyBytes))
+	r.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(buf), r.Body), r.Body}
+	if err != nil {
+		return nil
+	}
+	return buf
+}
+
+// auditLogParams 汇总 query、JSON 请求体与 handler 已解析的表单（multipart 仅记录字段与文件名），打码后序列化为 JSON。
+func auditLogParams(r *http.Request, body []byte) string {
+	params := make(map[string]any)
+	mergeAuditValues(params, r.URL.Query())
+
+	switch {
+	case len(body) > 0:
+		var decoded any
+		if err := json.Unmarshal(body, &decoded); err != nil {
+			params["_body"] = truncateRunes(string(body), auditLogMaxValueRunes)
+		} else if obj, ok := decoded.(map[string]any); ok {
+			for k, v := range obj {
+				params[k] = v
+			}
+		} else {
+			params["_body"] = decoded
+		}
+	case r.MultipartForm != nil:
+		mergeAuditValues(params, r.MultipartForm.Value)
+		files := make([]string, 0)
+		for field, headers := range r.MultipartForm.File {
+			for _, header := range headers {
+				files = append(files, field+":"+header.Filename)
+			}
+		}
+		if len(files) > 0 {
+			params["_files"] = files
+		}
+	case r.PostForm != nil:
+		mergeAuditValues(params, r.PostForm)
+	}
+
+	if len(params) == 0 {
+		return ""
+	}
+	out, err := json.Marshal(redactAuditValue("", params))
+	if err != nil {
+		return ""
+	}
+	return truncateRunes(string(out), auditLogMaxParamsRunes)
+}
+
+func mergeAuditValues(dst map[string]any, values url.Values) {
+	for k, vs := range values {
+		if len(vs) == 1 {
+			dst[k] = vs[0]
+		} else {
+			dst[k] = vs
+		}
+	}
+}
+
+// redactAuditValue 递归打码敏感字段并截断过长字符串。
+func redactAuditValue(key string, v any) any {
+	if key != "" && isAuditSensitiveKey(key) {
+		return auditLogRedacted
+	}
+	switch val := v.(type) {
+	case map[string]any:
+		out := make(map[string]any, len(val))
+		for k, item := range val {
+			out[k] = redactAuditValue(k, item)
+		}
+		return out
+	case []any:
+		out := make([]any, len(val))
+		for i, item := range val {
+			out[i] = redactAuditValue("", item)
+		}
+		return out
+	case []string:
+		out := make([]string, len(val))
+		for i, item := range val {
+			out[i] = truncateRunes(item, auditLogMaxValueRunes)
+		}
+		return out
+	case string:
+		return truncateRunes(val, auditLogMaxValueRunes)
+	default:
+		return v
+	}
+}
+
+func isAuditSensitiveKey(key string) bool {
+	key = strings.ToLower(strings.NewReplacer("_", "", "-", "").Replace(key))
+	for _, fragment := range auditLogSensitiveKeys {
+		if strings.Contains(key, fragment) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseAuditResultCode 从响应体开头提取业务 code（多数接口以 HTTP 200 + code=-1 表示失败）。
+func parseAuditResultCode(prefix []byte) *int {
+	m := auditLogResultCodePattern.FindSubmatch(prefix)
+	if m == nil {
+		return nil
+	}
+	code, err := strconv.Atoi(string(m[1]))
+	if err != nil {
+		return nil
+	}
+	return &code
+}
+
+// auditLogPrefixBuffer 只保留写入内容的前 limit 字节。
+type auditLogPrefixBuffer struct {
+	bytes.Buffer
+	limit int
+}
+
+func (b *auditLogPrefixBuffer) Write(p []byte) (int, error) {
+	if room := b.limit - b.Len(); room > 0 {
+		if len(p) > room {
+			b.Buffer.Write(p[:room])
+		} else {
+			b.Buffer.Write(p)
+		}
+	}
+	return len(p), nil
+}
@@ -0,0 +1,209 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+
+	"liao/internal/database"
+)
+
+func TestAuditLogParams_RedactsSensitiveFields(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/api/auth/changePassword?token=abc&id=7", bytes.NewBufferString(
+		`{"oldPassword":"p1","newPassword":"p2","nested":{"refresh_token":"x","name":"ok"},"paths":["a","b"],"note":"`+strings.Repeat("长", 600)+`"}`))
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	body := captureAuditJSONBody(req)
+
+	// handler 仍能读到完整请求体。
+	if rest, _ := io.ReadAll(req.Body); !bytes.Equal(rest, body) {
+		t.Fatalf("body not restored: %q", rest)
+	}
+
+	var params map[string]any
+	if err := json.Unmarshal([]byte(auditLogParams(req, body)), &params); err != nil {
+		t.Fatalf("params: %v", err)
+	}
+	if params["oldPassword"] != auditLogRedacted || params["newPassword"] != auditLogRedacted || params["token"] != auditLogRedacted || params["id"] != "7" {
+		t.Fatalf("params=%v", params)
+	}
+	if nested := params["nested"].(map[string]any); nested["refresh_token"] != auditLogRedacted || nested["name"] != "ok" {
+		t.Fatalf("nested=%v", nested)
+	}
+	if paths := params["paths"].([]any); len(paths) != 2 {
+		t.Fatalf("paths=%v", paths)
+	}
+	if note := params["note"].(string); len([]rune(note)) != auditLogMaxValueRunes+3 {
+		t.Fatalf("note runes=%d", len([]rune(note)))
+	}
+
+	// 表单与 multipart 使用 handler 解析后的结果，文件只记录文件名。
+	form := newURLEncodedRequest(t, http.MethodPost, "/api/deleteMedia", url.Values{"localPath": {"/images/a.jpg"}, "accessCode": {"x"}})
+	_ = form.ParseForm()
+	if got := auditLogParams(form, nil); !strings.Contains(got, `"localPath":"/images/a.jpg"`) || !strings.Contains(got, `"accessCode":"***"`) {
+		t.Fatalf("form params=%s", got)
+	}
+
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	_ = mw.WriteField("userid", "u1")
+	fw, _ := mw.CreateFormFile("file", "a.png")
+	_, _ = fw.Write([]byte("png"))
+	_ = mw.Close()
+	upload := httptest.NewRequest(http.MethodPost, "/api/uploadMedia", &buf)
+	upload.Header.Set("Content-Type", mw.FormDataContentType())
+	if captureAuditJSONBody(upload) != nil {
+		t.Fatalf("multipart body should not be buffered")
+	}
+	_ = upload.ParseMultipartForm(1 << 20)
+	if got := auditLogParams(upload, nil); !strings.Contains(got, `"userid":"u1"`) || !strings.Contains(got, `"file:a.png"`) {
+		t.Fatalf("multipart params=%s", got)
+	}
+}
+
+func TestAuditable(t *testing.T) {
+	cases := []struct {
+		method string
+		path   string
+		want   bool
+	}{
+		{http.MethodGet, "/api/getAllUploadImages", false},
+		{http.MethodPost, "/api/getMessageHistory", false},
+		{http.MethodPost, "/api/batchDeleteMedia", true},
+		{http.MethodPost, "/api/auth/changePassword", true},
+		{http.MethodPost, "/api/auth/totp/disable", true},
+		{http.MethodPost, "/api/douyin/favoriteUser/tag/remove", true},
+	}
+	for _, tc := range cases {
+		if got := auditable(httptest.NewRequest(tc.method, tc.path, nil)); got != tc.want {
+			t.Fatalf("%s %s: auditable=%v, want %v", tc.method, tc.path, got, tc.want)
+		}
+	}
+	if code := parseAuditResultCode([]byte(`{"code":-1,"msg":"x"}`)); code == nil || *code != -1 {
+		t.Fatalf("code=%v", code)
+	}
+	if parseAuditResultCode([]byte("plain text")) != nil {
+		t.Fatalf("expected nil code for non-JSON body")
+	}
+}
+
+func TestAuditMiddleware_RecordsMutations(t *testing.T) {
+	svc, mock := newMockDBService(t, func(db *database.DB) *DBAuditLogService { return NewDBAuditLogService(db, 0) })
+	jwtService := NewJWTService("secret", 1)
+	a := &App{jwt: jwtService, auditLogs: svc}
+
+	var got map[string]any
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewDecoder(r.Body).Decode(&got)
+		writeJSON(w, http.StatusOK, map[string]any{"code": -1, "msg": "身份不存在"})
+	})
+	h := a.jwtMiddleware(a.auditMiddleware(a.roleMiddleware(handler)))
+
+	do := func(method, role, body string) int {
+		req := httptest.NewRequest(method, "/api/deleteIdentity", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		token, _ := jwtService.GenerateTokenFor(AuthPrincipal{Subject: authLegacySubject, Role: role})
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	insert := `INSERT INTO audit_log \(subject, role, api_key_id, method, path, params, status, result_code, latency_ms, remote_ip, user_agent, created_at\)`
+	mock.ExpectExec(insert).
+		WithArgs(authLegacySubject, AuthRoleViewer, nil, http.MethodPost, "/api/deleteIdentity", `{"id":"i1"}`, http.StatusForbidden, 403,
+			sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(insert).
+		WithArgs(authLegacySubject, AuthRoleOperator, nil, http.MethodPost, "/api/deleteIdentity", `{"id":"i1"}`, http.StatusOK, -1,
+			sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(2, 1))
+
+	if code := do(http.MethodPost, AuthRoleViewer, `{"id":"i1"}`); code != http.StatusForbidden {
+		t.Fatalf("status=%d, want 403", code)
+	}
+	if code := do(http.MethodPost, AuthRoleOperator, `{"id":"i1"}`); code != http.StatusOK || got["id"] != "i1" {
+		t.Fatalf("status=%d got=%v", code, got)
+	}
+	// 读请求不记录。
+	if code := do(http.MethodGet, AuthRoleViewer, ""); code != http.StatusOK {
+		t.Fatalf("status=%d", code)
+	}
+
+	_ = svc.Close()
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("expectations: %v", err)
+	}
+	if svc.Dropped() != 0 {
+		t.Fatalf("dropped=%d", svc.Dropped())
+	}
+}
+
+func TestDBAuditLogService_ListAndPurge(t *testing.T) {
+	if NewDBAuditLogService(nil, 0) != nil {
+		t.Fatalf("expected nil service for nil db")
+	}
+	svc, mock := newMockDBService(t, func(db *database.DB) *DBAuditLogService { return NewDBAuditLogService(db, 0) })
+	ctx := context.Background()
+	since := time.Date(2026, 1, 1, 0, 0, 0, 0, time.Local)
+
+	mock.ExpectQuery(`SELECT COUNT\(\*\) FROM audit_log WHERE 1 = 1 AND subject = \? AND path LIKE \? AND method = \? AND status = \? AND created_at >= \?`).
+		WithArgs("alice", "/api/delete%", "POST", 200, since).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	created := time.Date(2026, 1, 2, 3, 4, 5, 0, time.Local)
+	mock.ExpectQuery(`SELECT id, subject, role, api_key_id, method, path, params, status, result_code, latency_ms, remote_ip, user_agent, created_at\s+FROM audit_log`).
+		WithArgs("alice", "/api/delete%", "POST", 200, since, 20, 0).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "subject", "role", "api_key_id", "method", "path", "params", "status", "result_code", "latency_ms", "remote_ip", "user_agent", "created_at"}).
+			AddRow(int64(5), "alice", AuthRoleAdmin, nil, "POST", "/api/deleteMedia", `{"localPath":"/a.jpg"}`, 200, int64(0), int64(12), "10.0.0.1", nil, created))
+	items, total, err := svc.List(ctx, AuditLogQuery{Subject: "alice", Path: "/api/delete", Method: "post", Status: 200, Since: since})
+	if err != nil || total != 1 || len(items) != 1 {
+		t.Fatalf("items=%+v total=%d err=%v", items, total, err)
+	}
+	if item := items[0]; item.ResultCode == nil || *item.ResultCode != 0 || item.LatencyMs != 12 || item.CreateTime != "2026-01-02T03:04:05" {
+		t.Fatalf("item=%+v", item)
+	}
+
+	before := time.Date(2025, 10, 1, 0, 0, 0, 0, time.Local)
+	mock.ExpectExec(`DELETE FROM audit_log WHERE created_at < \?`).WithArgs(before).WillReturnResult(sqlmock.NewResult(0, 3))
+	if n, err := svc.Purge(ctx, before); err != nil || n != 3 {
+		t.Fatalf("Purge: n=%d err=%v", n, err)
+	}
+}
+
+func TestHandleListAuditLogs(t *testing.T) {
+	rr := httptest.NewRecorder()
+	(&App{}).handleListAuditLogs(rr, httptest.NewRequest(http.MethodGet, "/api/audit/list", nil))
+	if body := decodeJSONBody(t, rr.Body); body["code"] != float64(-1) {
+		t.Fatalf("body=%v", body)
+	}
+
+	svc, mock := newMockDBService(t, func(db *database.DB) *DBAuditLogService { return NewDBAuditLogService(db, 0) })
+	a := &App{auditLogs: svc}
+
+	rr = httptest.NewRecorder()
+	a.handleListAuditLogs(rr, httptest.NewRequest(http.MethodGet, "/api/audit/list?since=bad", nil))
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("status=%d", rr.Code)
+	}
+
+	mock.ExpectQuery(`SELECT COUNT\(\*\) FROM audit_log WHERE 1 = 1 AND path LIKE \?`).WithArgs("/api/douyin/%").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	mock.ExpectQuery(`FROM audit_log`).WithArgs("/api/douyin/%", 10, 10).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	rr = httptest.NewRecorder()
+	a.handleListAuditLogs(rr, httptest.NewRequest(http.MethodGet, "/api/audit/list?path=/api/douyin/&page=2&pageSize=10", nil))
+	body := decodeJSONBody(t, rr.Body)
+	data, _ := body["data"].(map[string]any)
+	if body["code"] != float64(0) || data["page"] != float64(2) || data["total"] != float64(0) {
+		t.Fatalf("body=%v", body)
+	}
+}
@@ -0,0 +1,129 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+)
+
+func (a *App) handleListAuthAPIKeys(w http.ResponseWriter, r *http.Request) {
+	if a.authAPIKeys == nil {
+		writeJSON(w, http.StatusOK, map[string]any{"code": -1, "msg": "API Key 服务未初始化"})
+		return
+	}
+
+	items, err := a.authAPIKeys.List(r.Context())
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]any{"code": -1, "msg": "查询API Key失败: " + err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"code": 0,
+		"msg":  "success",
+		"data": items,
+	})
+}
+
+// handleCreateAuthAPIKey 创建 API Key；响应中的 key 为明文，仅此一次返回。
+func (a *App) handleCreateAuthAPIKey(w http.ResponseWriter, r *http.Request) {
+	if a.authAPIKeys == nil {
+		writeJSON(w, http.StatusOK, map[string]any{"code": -1, "msg": "API Key 服务未初始化"})
+		return
+	}
+
+	var in struct {
+		Name      string   `json:"name"`
+		Scopes    []string `json:"scopes"`
+		Role      string   `json:"role"`
+		ExpiresAt string   `json:"expiresAt"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"code": 400, "msg": "参数解析失败"})
+		return
+	}
+	principal, _ := authPrincipalFromContext(r.Context())
+	item, key, err := a.authAPIKeys.Create(r.Context(), AuthAPIKeyInput{
+		Name:      in.Name,
+		Scopes:    in.Scopes,
+		Role:      in.Role,
+		ExpiresAt: in.ExpiresAt,
+	}, principal.Subject)
+	if err != nil {
+		writeAuthAPIKeyError(w, err)
+		return
+	}
+	slog.Info("创建API Key", "id", item.ID, "name", item.Name, "scopes", item.Scopes, "subject", principal.Subject)
+	writeJSON(w, http.StatusOK, map[string]any{
+		"code": 0,
+		"msg":  "success",
+		"data": map[string]any{"apiKey": item, "key": key},
+	})
+}
+
+func (a *App) handleUpdateAuthAPIKey(w http.ResponseWriter, r *http.Request) {
+	if a.authAPIKeys == nil {
+		writeJSON(w, http.StatusOK, map[string]any{"code": -1, "msg": "API Key 服务未初始化"})
+		return
+	}
+
+	var in struct {
+		ID        int64    `json:"id"`
+		Name      *string  `json:"name"`
+		Scopes    []string `json:"scopes"`
+		Role      *string  `json:"role"`
+		Disabled  *bool    `json:"disabled"`
+		ExpiresAt *string  `json:"expiresAt"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"code": 400, "msg": "参数解析失败"})
+		return
+	}
+	item, err := a.authAPIKeys.Update(r.Context(), in.ID, AuthAPIKeyUpdate{
+		Name:      in.Name,
+		Scopes:    in.Scopes,
+		Role:      in.Role,
+		Disabled:  in.Disabled,
+		ExpiresAt: in.ExpiresAt,
+	})
+	if err != nil {
+		writeAuthAPIKeyError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"code": 0,
+		"msg":  "success",
+		"data": item,
+	})
+}
+
+func (a *App) handleDeleteAuthAPIKey(w http.ResponseWriter, r *http.Request) {
+	if a.authAPIKeys == nil {
+		writeJSON(w, http.StatusOK, map[string]any{"code": -1, "msg": "API Key 服务未初始化"})
+		return
+	}
+
+	var in struct {
+		ID int64 `json:"id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"code": 400, "msg": "参数解析失败"})
+		return
+	}
+	if err := a.authAPIKeys.Delete(r.Context(), in.ID); err != nil {
+		writeAuthAPIKeyError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"code": 0, "msg": "success"})
+}
+
+func writeAuthAPIKeyError(w http.ResponseWriter, err error) {
+	switch {
+	case isBadRequestError(err):
+		writeJSON(w, http.StatusBadRequest, map[string]any{"code": 400, "msg": err.Error()})
+	case errors.Is(err, ErrAuthAPIKeyNotFound):
+		writeJSON(w, http.StatusOK, map[string]any{"code": -1, "msg": err.Error()})
+	default:
+		writeJSON(w, http.StatusInternalServerError, map[string]any{"code": -1, "msg": "保存API Key失败: " + err.Error()})
+	}
+}
@@ -0,0 +1,339 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"database/sql/driver"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+var authAPIKeyTestColumns = []string{"id", "name", "key_prefix", "scopes", "role", "disabled", "expires_at", "last_used_at", "last_used_ip", "created_by", "created_at", "updated_at"}
+
+// waitAuthAPIKeyTouch 等待后台写入最近使用时间完成，使后续期望按顺序匹配。
+func waitAuthAPIKeyTouch(t *testing.T, mock sqlmock.Sqlmock) {
+	t.Helper()
+	waitFor(t, "API Key 使用时间写入", func() bool { return mock.ExpectationsWereMet() == nil })
+}
+
+func authAPIKeyTestRows(id int64, scopes string, disabled int, expiresAt driver.Value) *sqlmock.Rows {
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.Local)
+	return sqlmock.NewRows(authAPIKeyTestColumns).
+		AddRow(id, "sync-script", "lk_AbCdEfGh", scopes, AuthRoleOperator, disabled, expiresAt, nil, nil, "alice", now, now)
+}
+
+func TestAuthAPIKeyScopeForPath(t *testing.T) {
+	cases := map[string]string{
+		"/api/douyin/favoriteUser/aweme/pullLatest": AuthAPIKeyScopeDouyin,
+		"/api/importMtPhotoMedia":                   AuthAPIKeyScopeMtPhoto,
+		"/api/getMtPhotoAlbums":                     AuthAPIKeyScopeMtPhoto,
+		"/api/uploadMedia":                          AuthAPIKeyScopeMedia,
+		"/api/getVideoExtractTaskList":              AuthAPIKeyScopeMedia,
+		"/api/getSystemConfig":                      AuthAPIKeyScopeSystem,
+		"/api/wsConnectionEvent/list":               AuthAPIKeyScopeSystem,
+		"/api/getIdentityList":                      "",
+		"/api/apiKey/list":                          "",
+		"/api/authUser/list":                        "",
+	}
+	for path, want := range cases {
+		if got := authAPIKeyScopeForPath(path); got != want {
+			t.Fatalf("%s: scope=%q, want %q", path, got, want)
+		}
+	}
+
+	p := AuthPrincipal{Role: AuthRoleOperator, APIKeyID: 1, Scopes: []string{AuthAPIKeyScopeDouyin}}
+	if !p.AllowsPath("/api/douyin/import") || !p.AllowsPath("/api/auth/me") {
+		t.Fatalf("expected douyin key to reach douyin routes and /auth/me")
+	}
+	if p.AllowsPath("/api/importMtPhotoMedia") || p.AllowsPath("/api/apiKey/create") {
+		t.Fatalf("expected douyin key to be denied outside its scope")
+	}
+	if !(AuthPrincipal{Role: AuthRoleViewer}).AllowsPath("/api/apiKey/list") {
+		t.Fatalf("token callers should not be scope-limited")
+	}
+}
+
+func TestNormalizeAuthAPIKeyScopes(t *testing.T) {
+	got, err := normalizeAuthAPIKeyScopes([]string{" System", "douyin", "", "douyin"})
+	if err != nil || strings.Join(got, ",") != "douyin,system" {
+		t.Fatalf("scopes=%v err=%v", got, err)
+	}
+	if _, err := normalizeAuthAPIKeyScopes(nil); !isBadRequestError(err) {
+		t.Fatalf("expected empty scopes rejected, got %v", err)
+	}
+	if _, err := normalizeAuthAPIKeyScopes([]string{"chat"}); !isBadRequestError(err) {
+		t.Fatalf("expected unknown scope rejected, got %v", err)
+	}
+}
+
+func TestDBAuthAPIKeyService_CreateAndAuthenticate(t *testing.T) {
+	if NewDBAuthAPIKeyService(nil) != nil {
+		t.Fatalf("expected nil service for nil db")
+	}
+	svc, mock := newMockDBService(t, NewDBAuthAPIKeyService)
+	ctx := context.Background()
+
+	if _, _, err := svc.Create(ctx, AuthAPIKeyInput{Name: "x", Scopes: []string{"douyin"}, Role: "root"}, "alice"); !isBadRequestError(err) {
+		t.Fatalf("expected role error, got %v", err)
+	}
+	if _, _, err := svc.Create(ctx, AuthAPIKeyInput{Name: "x", Scopes: []string{"douyin"}, ExpiresAt: "2000-01-01T00:00:00"}, "alice"); !isBadRequestError(err) {
+		t.Fatalf("expected past expiry rejected, got %v", err)
+	}
+
+	var stored string
+	expectInsertReturningID(mock, `INSERT INTO auth_api_key \(name, key_prefix, key_hash, scopes, role, disabled, expires_at, created_by, created_at, updated_at\)`, 3,
+		"sync-script", sqlmock.AnyArg(), authAPIKeyHashArg{&stored}, "douyin,mtphoto", AuthRoleOperator, nil, "alice", sqlmock.AnyArg(), sqlmock.AnyArg())
+	mock.ExpectQuery(`SELECT .* FROM auth_api_key WHERE id = \?`).WithArgs(int64(3)).
+		WillReturnRows(authAPIKeyTestRows(3, "douyin,mtphoto", 0, nil))
+	key, raw, err := svc.Create(ctx, AuthAPIKeyInput{Name: " sync-script ", Scopes: []string{"mtphoto", "douyin"}}, "alice")
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if key.ID != 3 || strings.Join(key.Scopes, ",") != "douyin,mtphoto" || key.Role != AuthRoleOperator {
+		t.Fatalf("key=%+v", key)
+	}
+	// 数据库只保存哈希，明文以 lk_ 开头。
+	if !strings.HasPrefix(raw, authAPIKeyPrefix) || stored != hashAuthAPIKey(raw) || strings.Contains(stored, raw) {
+		t.Fatalf("raw=%q stored=%q", raw, stored)
+	}
+
+	mock.ExpectQuery(`SELECT .* FROM auth_api_key WHERE key_hash = \?`).WithArgs(hashAuthAPIKey(raw)).
+		WillReturnRows(authAPIKeyTestRows(3, "douyin,mtphoto", 0, nil))
+	mock.ExpectExec(`UPDATE auth_api_key SET last_used_at = \?, last_used_ip = \? WHERE id = \?`).
+		WithArgs(sqlmock.AnyArg(), "10.0.0.1", int64(3)).WillReturnResult(sqlmock.NewResult(0, 1))
+	got, err := svc.Authenticate(ctx, raw, "10.0.0.1")
+	if err != nil || got.ID != 3 {
+		t.Fatalf("Authenticate: key=%+v err=%v", got, err)
+	}
+	waitAuthAPIKeyTouch(t, mock)
+	if p := got.Principal(); p.APIKeyID != 3 || p.Subject != "apikey:sync-script" || p.Account {
+		t.Fatalf("principal=%+v", p)
+	}
+
+	// 缓存命中且未到写入间隔：不查库也不写库。
+	if _, err := svc.Authenticate(ctx, raw, "10.0.0.1"); err != nil {
+		t.Fatalf("cached Authenticate: %v", err)
+	}
+	if _, err := svc.Authenticate(ctx, "not-a-key", ""); !errors.Is(err, ErrAuthAPIKeyInvalid) {
+		t.Fatalf("expected invalid, got %v", err)
+	}
+
+	mock.ExpectQuery(`SELECT .* FROM auth_api_key WHERE key_hash = \?`).WithArgs(hashAuthAPIKey(authAPIKeyPrefix + "unknown")).
+		WillReturnRows(sqlmock.NewRows(authAPIKeyTestColumns))
+	if _, err := svc.Authenticate(ctx, authAPIKeyPrefix+"unknown", ""); !errors.Is(err, ErrAuthAPIKeyInvalid) {
+		t.Fatalf("expected unknown key rejected, got %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("expectations: %v", err)
+	}
+}
+
+func TestDBAuthAPIKeyService_UpdateInvalidatesAndExpiry(t *testing.T) {
+	svc, mock := newMockDBService(t, NewDBAuthAPIKeyService)
+	ctx := context.Background()
+	raw := authAPIKeyPrefix + "secret"
+
+	mock.ExpectQuery(`SELECT .* FROM auth_api_key WHERE key_hash = \?`).WithArgs(hashAuthAPIKey(raw)).
+		WillReturnRows(authAPIKeyTestRows(3, "douyin", 0, nil))
+	mock.ExpectExec(`UPDATE auth_api_key SET last_used_at`).WillReturnResult(sqlmock.NewResult(0, 1))
+	if _, err := svc.Authenticate(ctx, raw, ""); err != nil {
+		t.Fatalf("Authenticate: %v", err)
+	}
+	waitAuthAPIKeyTouch(t, mock)
+
+	disabled := true
+	mock.ExpectQuery(`SELECT .* FROM auth_api_key WHERE id = \?`).WithArgs(int64(3)).
+		WillReturnRows(authAPIKeyTestRows(3, "douyin", 0, nil))
+	mock.ExpectExec(`UPDATE auth_api_key SET name = \?, scopes = \?, role = \?, disabled = \?, expires_at = \?, updated_at = \? WHERE id = \?`).
+		WithArgs("sync-script", "douyin", AuthRoleOperator, 1, nil, sqlmock.AnyArg(), int64(3)).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`SELECT .* FROM auth_api_key WHERE id = \?`).WithArgs(int64(3)).
+		WillReturnRows(authAPIKeyTestRows(3, "douyin", 1, nil))
+	if _, err := svc.Update(ctx, 3, AuthAPIKeyUpdate{Disabled: &disabled}); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+
+	// 修改后缓存失效，停用立即生效。
+	mock.ExpectQuery(`SELECT .* FROM auth_api_key WHERE key_hash = \?`).WithArgs(hashAuthAPIKey(raw)).
+		WillReturnRows(authAPIKeyTestRows(3, "douyin", 1, nil))
+	if _, err := svc.Authenticate(ctx, raw, ""); !errors.Is(err, ErrAuthAPIKeyInvalid) {
+		t.Fatalf("expected disabled key rejected, got %v", err)
+	}
+
+	svc.invalidate()
+	mock.ExpectQuery(`SELECT .* FROM auth_api_key WHERE key_hash = \?`).WithArgs(hashAuthAPIKey(raw)).
+		WillReturnRows(authAPIKeyTestRows(3, "douyin", 0, time.Now().Add(-time.Minute)))
+	if _, err := svc.Authenticate(ctx, raw, ""); !errors.Is(err, ErrAuthAPIKeyInvalid) {
+		t.Fatalf("expected expired key rejected, got %v", err)
+	}
+
+	mock.ExpectExec(`DELETE FROM auth_api_key WHERE id = \?`).WithArgs(int64(9)).WillReturnResult(sqlmock.NewResult(0, 0))
+	if err := svc.Delete(ctx, 9); !errors.Is(err, ErrAuthAPIKeyNotFound) {
+		t.Fatalf("expected not found, got %v", err)
+	}
+}
+
+func TestDBAuthAPIKeyService_MissNotCachedAndTouchAsync(t *testing.T) {
+	svc, mock := newMockDBService(t, NewDBAuthAPIKeyService)
+	ctx := context.Background()
+
+	// 不存在的 Key 每次都查库，且不进入缓存。
+	for i := 0; i < 3; i++ {
+		unknown := authAPIKeyPrefix + "unknown-" + strings.Repeat("x", i)
+		mock.ExpectQuery(`SELECT .* FROM auth_api_key WHERE key_hash = \?`).WithArgs(hashAuthAPIKey(unknown)).
+			WillReturnRows(sqlmock.NewRows(authAPIKeyTestColumns))
+		if _, err := svc.Authenticate(ctx, unknown, ""); !errors.Is(err, ErrAuthAPIKeyInvalid) {
+			t.Fatalf("expected unknown key rejected, got %v", err)
+		}
+	}
+	mock.ExpectQuery(`SELECT .* FROM auth_api_key WHERE key_hash = \?`).WithArgs(hashAuthAPIKey(authAPIKeyPrefix + "unknown-")).
+		WillReturnRows(sqlmock.NewRows(authAPIKeyTestColumns))
+	if _, err := svc.Authenticate(ctx, authAPIKeyPrefix+"unknown-", ""); !errors.Is(err, ErrAuthAPIKeyInvalid) {
+		t.Fatalf("expected repeated unknown key rejected, got %v", err)
+	}
+	if n := len(svc.cache); n != 0 {
+		t.Fatalf("cache size=%d, want 0", n)
+	}
+
+	// 最近使用时间经后台队列写入：数据库慢或请求上下文已取消都不影响请求。
+	raw := authAPIKeyPrefix + "secret"
+	mock.ExpectQuery(`SELECT .* FROM auth_api_key WHERE key_hash = \?`).WithArgs(hashAuthAPIKey(raw)).
+		WillReturnRows(authAPIKeyTestRows(3, "douyin", 0, nil))
+	mock.ExpectExec(`UPDATE auth_api_key SET last_used_at = \?, last_used_ip = \? WHERE id = \?`).
+		WithArgs(sqlmock.AnyArg(), "10.0.0.2", int64(3)).WillDelayFor(500 * time.Millisecond).WillReturnResult(sqlmock.NewResult(0, 1))
+	canceled, cancel := context.WithCancel(ctx)
+	start := time.Now()
+	if _, err := svc.Authenticate(ctx, raw, "10.0.0.2"); err != nil {
+		t.Fatalf("Authenticate: %v", err)
+	}
+	cancel()
+	if elapsed := time.Since(start); elapsed >= 500*time.Millisecond {
+		t.Fatalf("Authenticate waited for the last-used write: %v", elapsed)
+	}
+	if _, err := svc.Authenticate(canceled, raw, "10.0.0.2"); err != nil {
+		t.Fatalf("cached Authenticate: %v", err)
+	}
+	if n := len(svc.cache); n != 1 {
+		t.Fatalf("cache size=%d, want 1", n)
+	}
+	waitAuthAPIKeyTouch(t, mock)
+
+	// 缓存过期后重新查库：其他副本上的停用在 authAPIKeyCacheTTL 内生效。
+	oldTTL := authAPIKeyCacheTTL
+	authAPIKeyCacheTTL = 0
+	t.Cleanup(func() { authAPIKeyCacheTTL = oldTTL })
+	mock.ExpectQuery(`SELECT .* FROM auth_api_key WHERE key_hash = \?`).WithArgs(hashAuthAPIKey(raw)).
+		WillReturnRows(authAPIKeyTestRows(3, "douyin", 1, nil))
+	if _, err := svc.Authenticate(ctx, raw, ""); !errors.Is(err, ErrAuthAPIKeyInvalid) {
+		t.Fatalf("expected key disabled elsewhere rejected, got %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("expectations: %v", err)
+	}
+}
+
+func TestJWTMiddleware_APIKey(t *testing.T) {
+	svc, mock := newMockDBService(t, NewDBAuthAPIKeyService)
+	a := &App{jwt: NewJWTService("secret", 1), authAPIKeys: svc}
+	var seen AuthPrincipal
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen, _ = authPrincipalFromContext(r.Context())
+		w.WriteHeader(http.StatusOK)
+	})
+	h := a.jwtMiddleware(a.roleMiddleware(ok))
+	raw := authAPIKeyPrefix + "script"
+
+	mock.ExpectQuery(`SELECT .* FROM auth_api_key WHERE key_hash = \?`).WithArgs(hashAuthAPIKey(raw)).
+		WillReturnRows(authAPIKeyTestRows(3, "douyin,mtphoto", 0, nil))
+	mock.ExpectExec(`UPDATE auth_api_key SET last_used_at`).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	do := func(method, path string, header string) int {
+		req := httptest.NewRequest(method, "http://api.local"+path, nil)
+		switch header {
+		case "x-api-key":
+			req.Header.Set("X-API-Key", raw)
+		case "bearer":
+			req.Header.Set("Authorization", "Bearer "+raw)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	if code := do(http.MethodPost, "/api/douyin/favoriteUser/aweme/pullLatest", "x-api-key"); code != http.StatusOK {
+		t.Fatalf("status=%d, want 200", code)
+	}
+	if seen.APIKeyID != 3 || seen.Role != AuthRoleOperator {
+		t.Fatalf("principal=%+v", seen)
+	}
+	if code := do(http.MethodPost, "/api/importMtPhotoMedia", "bearer"); code != http.StatusOK {
+		t.Fatalf("status=%d, want bearer key accepted", code)
+	}
+	for _, path := range []string{"/api/getSystemConfig", "/api/getIdentityList", "/api/apiKey/list"} {
+		if code := do(http.MethodGet, path, "x-api-key"); code != http.StatusForbidden {
+			t.Fatalf("%s: status=%d, want 403", path, code)
+		}
+	}
+
+	// 未启用 API Key 服务时一律拒绝。
+	a.authAPIKeys = nil
+	if code := do(http.MethodPost, "/api/douyin/import", "x-api-key"); code != http.StatusUnauthorized {
+		t.Fatalf("status=%d, want 401", code)
+	}
+}
+
+func TestAuthAPIKeyHandlers(t *testing.T) {
+	rr := httptest.NewRecorder()
+	(&App{}).handleListAuthAPIKeys(rr, httptest.NewRequest(http.MethodGet, "/api/apiKey/list", nil))
+	if body := decodeJSONBody(t, rr.Body); body["code"] != float64(-1) {
+		t.Fatalf("body=%v", body)
+	}
+
+	svc, mock := newMockDBService(t, NewDBAuthAPIKeyService)
+	a := &App{authAPIKeys: svc}
+
+	rr = httptest.NewRecorder()
+	a.handleCreateAuthAPIKey(rr, httptest.NewRequest(http.MethodPost, "/api/apiKey/create", bytes.NewBufferString(`{"name":"x","scopes":[]}`)))
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("status=%d body=%s", rr.Code, rr.Body.String())
+	}
+
+	expectInsertReturningID(mock, `INSERT INTO auth_api_key`, 3,
+		"sync-script", sqlmock.AnyArg(), sqlmock.AnyArg(), "douyin", AuthRoleViewer, nil, "alice", sqlmock.AnyArg(), sqlmock.AnyArg())
+	mock.ExpectQuery(`SELECT .* FROM auth_api_key WHERE id = \?`).WithArgs(int64(3)).
+		WillReturnRows(authAPIKeyTestRows(3, "douyin", 0, nil))
+	req := httptest.NewRequest(http.MethodPost, "/api/apiKey/create", bytes.NewBufferString(`{"name":"sync-script","scopes":["douyin"],"role":"viewer"}`))
+	req = req.WithContext(withAuthPrincipal(req.Context(), AuthPrincipal{Subject: "alice", Role: AuthRoleAdmin, Account: true}))
+	rr = httptest.NewRecorder()
+	a.handleCreateAuthAPIKey(rr, req)
+	body := decodeJSONBody(t, rr.Body)
+	data, _ := body["data"].(map[string]any)
+	if key, _ := data["key"].(string); body["code"] != float64(0) || !strings.HasPrefix(key, authAPIKeyPrefix) {
+		t.Fatalf("body=%v", body)
+	}
+	if item, _ := data["apiKey"].(map[string]any); item["keyPrefix"] != "lk_AbCdEfGh" {
+		t.Fatalf("apiKey=%v", data["apiKey"])
+	}
+
+	mock.ExpectExec(`DELETE FROM auth_api_key WHERE id = \?`).WithArgs(int64(3)).WillReturnResult(sqlmock.NewResult(0, 1))
+	rr = httptest.NewRecorder()
+	a.handleDeleteAuthAPIKey(rr, httptest.NewRequest(http.MethodPost, "/api/apiKey/delete", bytes.NewBufferString(`{"id":3}`)))
+	if body := decodeJSONBody(t, rr.Body); body["code"] != float64(0) {
+		t.Fatalf("body=%v", body)
+	}
+}
+
+// authAPIKeyHashArg 匹配任意 64 位 hex 哈希并记录实际写入值。
+type authAPIKeyHashArg struct{ got *string }
+
+func (m authAPIKeyHashArg) Match(v driver.Value) bool {
+	s, ok := v.(string)
+	*m.got = s
+	return ok && len(s) == 64
+}
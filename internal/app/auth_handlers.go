@@ -1,8 +1,15 @@
 package app
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 )
 
 func (a *App) handleAuthLogin(w http.ResponseWriter, r *http.Request) {
@@ -14,6 +21,12 @@ func (a *App) handleAuthLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// 携带 username 时走账号登录，否则兼容共享访问码登录。
+	if username := strings.TrimSpace(r.FormValue("username")); username != "" {
+		a.handleAccountLogin(w, r, username, r.FormValue("password"))
+		return
+	}
+
 	accessCode := r.FormValue("accessCode")
 	if strings.TrimSpace(accessCode) == "" {
 		writeJSON(w, http.StatusBadRequest, map[string]any{
@@ -23,7 +36,19 @@ func (a *App) handleAuthLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if a.accessCodeLoginDisabled(r.Context()) {
+		writeJSON(w, http.StatusBadRequest, map[string]any{
+			"code": -1,
+			"msg":  "访问码登录已关闭，请使用账号登录",
+		})
+		return
+	}
+	if a.rejectThrottledLogin(w, r) {
+		return
+	}
+
 	if accessCode != a.cfg.AuthAccessCode {
+		a.recordLoginFailure(r, LoginMethodAccessCode, "", "访问码错误")
 		writeJSON(w, http.StatusBadRequest, map[string]any{
 			"code": -1,
 			"msg":  "访问码错误",
@@ -31,19 +56,233 @@ func (a *App) handleAuthLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	token, err := a.jwt.GenerateToken()
+	resp, err := a.issueLoginTokens(r, AuthPrincipal{Subject: authLegacySubject, Role: AuthRoleAdmin})
 	if err != nil {
+		slog.Error("签发登录凭证失败", "error", err)
+		a.loginGuard.Release(r.Context(), a.loginClientIP(r))
 		writeJSON(w, http.StatusInternalServerError, map[string]any{
 			"code": -1,
 			"msg":  "登录失败",
 		})
 		return
 	}
+	a.loginGuard.Succeed(r.Context(), a.loginClientIP(r))
+	resp["msg"] = "登录成功"
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// accessCodeLoginDisabled 判断共享访问码是否不可用：显式关闭，或已有账号启用两步验证（访问码没有第二因素，
+// AUTH_ACCESS_CODE_ALLOW_WITH_TOTP=true 时除外）；查询失败时按关闭处理。
+func (a *App) accessCodeLoginDisabled(ctx context.Context) bool {
+	if a.cfg.AuthAccessCodeDisabled {
+		return true
+	}
+	if a.cfg.AuthAccessCodeAllowWithTOTP || a.authTOTP == nil {
+		return false
+	}
+	enabled, err := a.authTOTP.AnyEnabled(ctx)
+	if err != nil {
+		slog.Warn("查询两步验证启用状态失败，暂停访问码登录", "error", err)
+		return true
+	}
+	return enabled
+}
+
+func (a *App) handleAccountLogin(w http.ResponseWriter, r *http.Request, username string, password string) {
+	if a.authUsers == nil {
+		writeJSON(w, http.StatusOK, map[string]any{"code": -1, "msg": "账号服务未初始化"})
+		return
+	}
+	if password == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"code": -1, "msg": "密码不能为空"})
+		return
+	}
+	if a.rejectThrottledLogin(w, r) {
+		return
+	}
+
+	user, err := a.authUsers.Authenticate(r.Context(), username, password)
+	if err != nil {
+		if errors.Is(err, ErrAuthInvalidLogin) || errors.Is(err, ErrAuthUserDisabled) {
+			slog.Warn("账号登录失败", "username", username, "remote", r.RemoteAddr, "error", err)
+			a.recordLoginFailure(r, LoginMethodAccount, username, err.Error())
+			writeJSON(w, http.StatusBadRequest, map[string]any{"code": -1, "msg": err.Error()})
+			return
+		}
+		slog.Error("账号登录失败", "username", username, "error", err)
+		a.loginGuard.Release(r.Context(), a.loginClientIP(r))
+		writeJSON(w, http.StatusInternalServerError, map[string]any{"code": -1, "msg": "登录失败"})
+		return
+	}
+	if !a.verifyLoginTOTP(w, r, user) {
+		return
+	}
+
+	resp, err := a.issueLoginTokens(r, AuthPrincipal{Subject: user.Username, Role: user.Role, Account: true})
+	if err != nil {
+		slog.Error("签发登录凭证失败", "username", user.Username, "error", err)
+		a.loginGuard.Release(r.Context(), a.loginClientIP(r))
+		writeJSON(w, http.StatusInternalServerError, map[string]any{"code": -1, "msg": "登录失败"})
+		return
+	}
+	a.loginGuard.Succeed(r.Context(), a.loginClientIP(r))
+	resp["msg"] = "登录成功"
+	resp["username"] = user.Username
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (a *App) loginClientIP(r *http.Request) string {
+	return loginClientIP(r, a.cfg.AuthLoginTrustForwarded)
+}
+
+// rejectThrottledLogin 为来源 IP 预占一次登录尝试；需要退避或处于锁定期时返回 429 并写入 Retry-After。
+// 预占后须以 recordLoginFailure、loginGuard.Succeed 或 loginGuard.Release 结束。
+func (a *App) rejectThrottledLogin(w http.ResponseWriter, r *http.Request) bool {
+	wait := a.loginGuard.Check(r.Context(), a.loginClientIP(r))
+	if wait <= 0 {
+		return false
+	}
+	seconds := retryAfterSeconds(wait)
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	writeJSON(w, http.StatusTooManyRequests, map[string]any{
+		"code":       429,
+		"msg":        fmt.Sprintf("登录尝试过于频繁，请 %d 秒后再试", seconds),
+		"retryAfter": seconds,
+	})
+	return true
+}
+
+// recordLoginFailure 累加失败计数（可能触发锁定）并异步持久化失败记录。
+func (a *App) recordLoginFailure(r *http.Request, method, username, reason string) {
+	ip := a.loginClientIP(r)
+	failures, locked := a.loginGuard.Fail(r.Context(), ip)
+	a.loginFailures.Enqueue(AuthLoginFailure{
+		RemoteIP:  ip,
+		Method:    method,
+		Username:  username,
+		Reason:    reason,
+		UserAgent: r.UserAgent(),
+		Failures:  failures,
+		Locked:    locked,
+	})
+}
+
+// issueLoginTokens 为调用者创建服务端会话并签发 access token 与 refresh token；未启用会话存储时仅签发 access token。
+func (a *App) issueLoginTokens(r *http.Request, principal AuthPrincipal) (map[string]any, error) {
+	var refreshToken string
+	if a.authSessions != nil {
+		sessionID, refresh, err := a.authSessions.Create(r.Context(), principal, r.UserAgent(), r.RemoteAddr)
+		if err != nil {
+			return nil, err
+		}
+		principal.SessionID, refreshToken = sessionID, refresh
+	}
+	return a.accessTokenResponse(principal, refreshToken)
+}
+
+func (a *App) accessTokenResponse(principal AuthPrincipal, refreshToken string) (map[string]any, error) {
+	token, err := a.jwt.GenerateTokenFor(principal)
+	if err != nil {
+		return nil, err
+	}
+	resp := map[string]any{
+		"code":      0,
+		"token":     token,
+		"expiresIn": int64(a.jwt.AccessTokenTTL() / time.Second),
+		"role":      principal.Role,
+	}
+	if refreshToken != "" {
+		resp["refreshToken"] = refreshToken
+	}
+	return resp, nil
+}
+
+// handleAuthRefresh 用 refresh token 换取新的 access token，并轮换 refresh token（旧值立即失效）。
+func (a *App) handleAuthRefresh(w http.ResponseWriter, r *http.Request) {
+	if a.authSessions == nil {
+		writeJSON(w, http.StatusOK, map[string]any{"code": -1, "msg": "会话服务未初始化"})
+		return
+	}
+	refreshToken := ""
+	if err := r.ParseForm(); err == nil {
+		refreshToken = strings.TrimSpace(r.FormValue("refreshToken"))
+	}
+	if refreshToken == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"code": -1, "msg": "refreshToken不能为空"})
+		return
+	}
+
+	principal, next, err := a.authSessions.Rotate(r.Context(), refreshToken)
+	if err != nil {
+		if errors.Is(err, ErrAuthSessionInvalid) {
+			writeJSON(w, http.StatusUnauthorized, map[string]any{"code": 401, "msg": err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusInternalServerError, map[string]any{"code": -1, "msg": "刷新登录状态失败: " + err.Error()})
+		return
+	}
+	if !a.refreshablePrincipal(r, &principal) {
+		writeJSON(w, http.StatusUnauthorized, map[string]any{"code": 401, "msg": ErrAuthSessionInvalid.Error()})
+		return
+	}
 
+	resp, err := a.accessTokenResponse(principal, next)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]any{"code": -1, "msg": "刷新登录状态失败: " + err.Error()})
+		return
+	}
+	resp["msg"] = "success"
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// refreshablePrincipal 复核会话主体当前是否仍可登录：账号需存在且未停用（并取当前角色），访问码会话需未关闭访问码登录。
+func (a *App) refreshablePrincipal(r *http.Request, principal *AuthPrincipal) bool {
+	if !principal.Account {
+		return !a.accessCodeLoginDisabled(r.Context())
+	}
+	if a.authUsers == nil {
+		return false
+	}
+	user, err := a.authUsers.Lookup(r.Context(), principal.Subject)
+	if err != nil || user == nil || user.Disabled {
+		if err == nil {
+			_ = a.authSessions.Revoke(r.Context(), principal.SessionID, AuthSessionRevokeAccount)
+		}
+		return false
+	}
+	principal.Role = user.Role
+	return true
+}
+
+// handleAuthLogout 吊销当前会话：该会话签发的 access token、refresh token 与已建立的 /ws、/sse 连接同时失效。
+func (a *App) handleAuthLogout(w http.ResponseWriter, r *http.Request) {
+	principal, ok := authPrincipalFromContext(r.Context())
+	if ok && principal.SessionID != "" && a.authSessions != nil {
+		if err := a.authSessions.Revoke(r.Context(), principal.SessionID, AuthSessionRevokeLogout); err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]any{"code": -1, "msg": "退出登录失败: " + err.Error()})
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"code": 0, "msg": "success"})
+}
+
+// handleAuthRevokeAll 吊销全部登录会话（含调用者自身），所有在线客户端需重新登录。
+func (a *App) handleAuthRevokeAll(w http.ResponseWriter, r *http.Request) {
+	if a.authSessions == nil {
+		writeJSON(w, http.StatusOK, map[string]any{"code": -1, "msg": "会话服务未初始化"})
+		return
+	}
+	count, err := a.authSessions.RevokeAll(r.Context(), AuthSessionRevokeAll)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]any{"code": -1, "msg": "吊销会话失败: " + err.Error()})
+		return
+	}
+	principal, _ := authPrincipalFromContext(r.Context())
+	slog.Warn("管理员吊销全部登录会话", "subject", principal.Subject, "revoked", count)
 	writeJSON(w, http.StatusOK, map[string]any{
-		"code":  0,
-		"msg":   "登录成功",
-		"token": token,
+		"code": 0,
+		"msg":  "success",
+		"data": map[string]any{"revoked": count},
 	})
 }
 
@@ -58,13 +297,69 @@ func (a *App) handleAuthVerify(w http.ResponseWriter, r *http.Request) {
 	}
 
 	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
-	valid := a.jwt.ValidateToken(tokenString)
+	principal, valid := a.authenticateToken(r.Context(), tokenString)
 
-	writeJSON(w, http.StatusOK, map[string]any{
+	resp := map[string]any{
 		"code":  ifThenElse(valid, 0, -1),
 		"msg":   ifThenElse(valid, "Token有效", "Token无效"),
 		"valid": valid,
-	})
+	}
+	if valid {
+		resp["role"] = principal.Role
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// handleAuthMe 返回当前调用者身份与角色。
+func (a *App) handleAuthMe(w http.ResponseWriter, r *http.Request) {
+	principal, ok := authPrincipalFromContext(r.Context())
+	if !ok {
+		writeJSON(w, http.StatusUnauthorized, map[string]any{"code": 401, "msg": "未登录或Token缺失"})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"code": 0, "msg": "success", "data": principal})
+}
+
+type authChangePasswordRequest struct {
+	OldPassword string `json:"oldPassword"`
+	NewPassword string `json:"newPassword"`
+}
+
+// handleAuthChangePassword 修改当前账号密码（需校验原密码）；共享访问码登录不支持。
+func (a *App) handleAuthChangePassword(w http.ResponseWriter, r *http.Request) {
+	if a.authUsers == nil {
+		writeJSON(w, http.StatusOK, map[string]any{"code": -1, "msg": "账号服务未初始化"})
+		return
+	}
+	principal, ok := authPrincipalFromContext(r.Context())
+	if !ok || !principal.Account {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"code": 400, "msg": "访问码登录不支持修改密码"})
+		return
+	}
+
+	var req authChangePasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"code": 400, "msg": "参数解析失败"})
+		return
+	}
+	user, err := a.authUsers.Authenticate(r.Context(), principal.Subject, req.OldPassword)
+	if err != nil {
+		if errors.Is(err, ErrAuthInvalidLogin) || errors.Is(err, ErrAuthUserDisabled) {
+			writeJSON(w, http.StatusBadRequest, map[string]any{"code": 400, "msg": "原密码错误"})
+			return
+		}
+		writeJSON(w, http.StatusInternalServerError, map[string]any{"code": -1, "msg": "修改密码失败: " + err.Error()})
+		return
+	}
+	if _, err := a.authUsers.Update(r.Context(), user.ID, AuthUserUpdate{Password: &req.NewPassword}); err != nil {
+		if isBadRequestError(err) {
+			writeJSON(w, http.StatusBadRequest, map[string]any{"code": 400, "msg": err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusInternalServerError, map[string]any{"code": -1, "msg": "修改密码失败: " + err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"code": 0, "msg": "success"})
 }
 
 func ifThenElse[T any](cond bool, a, b T) T {
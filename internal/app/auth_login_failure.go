@@ -0,0 +1,183 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"liao/internal/database"
+)
+
+const (
+	LoginMethodAccessCode = "accessCode"
+	LoginMethodAccount    = "account"
+
+	authLoginFailureQueueSize = 1024
+	// 截断后会追加 "..."，留出余量以不超过列宽（64/255）。
+	authLoginFailureMaxUsernameRunes  = 60
+	authLoginFailureMaxReasonRunes    = 60
+	authLoginFailureMaxUserAgentRunes = 250
+)
+
+// authLoginFailureWriteTimeout 为后台写入单条记录的超时。
+var authLoginFailureWriteTimeout = 5 * time.Second
+
+// AuthLoginFailure 为一次失败的登录尝试；Failures 为该 IP 在统计窗口内的累计失败次数。
+type AuthLoginFailure struct {
+	ID         int64  `json:"id"`
+	RemoteIP   string `json:"remoteIp"`
+	Method     string `json:"method"`
+	Username   string `json:"username,omitempty"`
+	Reason     string `json:"reason"`
+	UserAgent  string `json:"userAgent,omitempty"`
+	Failures   int    `json:"failures"`
+	Locked     bool   `json:"locked"`
+	CreateTime string `json:"createTime"`
+
+	createdAt time.Time
+}
+
+// AuthLoginFailureQuery 为失败记录查询条件；Page 从 1 开始。
+type AuthLoginFailureQuery struct {
+	RemoteIP string
+	Username string
+	Since    time.Time
+	Until    time.Time
+	Page     int
+	PageSize int
+}
+
+// DBAuthLoginFailureService 持久化登录失败记录；记录经有界队列由后台协程写入，爆破期间队列满时丢弃并计数。
+type DBAuthLoginFailureService struct {
+	db     *database.DB
+	writer *asyncWriter[AuthLoginFailure]
+}
+
+// NewDBAuthLoginFailureService 创建登录失败记录服务并启动后台写入协程。
+func NewDBAuthLoginFailureService(db *database.DB) *DBAuthLoginFailureService {
+	if db == nil {
+		return nil
+	}
+	svc := &DBAuthLoginFailureService{db: db}
+	svc.writer = newAsyncWriter("登录失败记录", authLoginFailureQueueSize, authLoginFailureWriteTimeout, svc.Record,
+		func(item AuthLoginFailure) []any {
+			return []any{"remoteIp", item.RemoteIP}
+		})
+	return svc
+}
+
+func (s *DBAuthLoginFailureService) Enqueue(item AuthLoginFailure) {
+	if s == nil {
+		return
+	}
+	if item.createdAt.IsZero() {
+		item.createdAt = time.Now()
+	}
+	s.writer.Enqueue(item)
+}
+
+// Dropped 返回因队列已满或服务已关闭而丢弃的记录数。
+func (s *DBAuthLoginFailureService) Dropped() int64 {
+	if s == nil {
+		return 0
+	}
+	return s.writer.Dropped()
+}
+
+// Record 同步写入一条记录。
+func (s *DBAuthLoginFailureService) Record(ctx context.Context, item AuthLoginFailure) error {
+	if s == nil || s.db == nil {
+		return fmt.Errorf("db not initialized")
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	createdAt := item.createdAt
+	if createdAt.IsZero() {
+		createdAt = time.Now()
+	}
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO auth_login_failure (remote_ip, method, username, reason, user_agent, failures, locked, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`, truncateRunes(item.RemoteIP, 60), item.Method,
+		nullIfEmpty(truncateRunes(item.Username, authLoginFailureMaxUsernameRunes)),
+		truncateRunes(item.Reason, authLoginFailureMaxReasonRunes),
+		nullIfEmpty(truncateRunes(item.UserAgent, authLoginFailureMaxUserAgentRunes)),
+		max(item.Failures, 0), boolToInt(item.Locked), createdAt)
+	return err
+}
+
+// List 按时间倒序分页返回失败记录及满足条件的总数。
+func (s *DBAuthLoginFailureService) List(ctx context.Context, query AuthLoginFailureQuery) ([]AuthLoginFailure, int, error) {
+	if s == nil || s.db == nil {
+		return nil, 0, fmt.Errorf("db not initialized")
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	var where strings.Builder
+	where.WriteString("1 = 1")
+	args := make([]any, 0, 4)
+	if ip := strings.TrimSpace(query.RemoteIP); ip != "" {
+		where.WriteString(" AND remote_ip = ?")
+		args = append(args, ip)
+	}
+	if username := strings.TrimSpace(query.Username); username != "" {
+		where.WriteString(" AND username = ?")
+		args = append(args, username)
+	}
+	if !query.Since.IsZero() {
+		where.WriteString(" AND created_at >= ?")
+		args = append(args, query.Since)
+	}
+	if !query.Until.IsZero() {
+		where.WriteString(" AND created_at < ?")
+		args = append(args, query.Until)
+	}
+
+	var total int
+	if err := s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM auth_login_failure WHERE `+where.String(), args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	page, pageSize := normalizePage(query.Page, query.PageSize)
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, remote_ip, method, username, reason, user_agent, failures, locked, created_at
+		FROM auth_login_failure
+		WHERE `+where.String()+`
+		ORDER BY created_at DESC, id DESC
+		LIMIT ? OFFSET ?
+	`, append(args, pageSize, (page-1)*pageSize)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	out := make([]AuthLoginFailure, 0)
+	for rows.Next() {
+		var item AuthLoginFailure
+		var username, userAgent sql.NullString
+		var locked int
+		var created sql.NullTime
+		if err := rows.Scan(&item.ID, &item.RemoteIP, &item.Method, &username, &item.Reason, &userAgent, &item.Failures, &locked, &created); err != nil {
+			return nil, 0, err
+		}
+		item.Username = username.String
+		item.UserAgent = userAgent.String
+		item.Locked = locked != 0
+		item.CreateTime = formatNullLocalDateTimeISO(created)
+		out = append(out, item)
+	}
+	return out, total, rows.Err()
+}
+
+func (s *DBAuthLoginFailureService) Close() error {
+	if s == nil {
+		return nil
+	}
+	s.writer.Close()
+	return nil
+}
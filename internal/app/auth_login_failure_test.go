@@ -0,0 +1,125 @@
+package app
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+
+	"liao/internal/config"
+)
+
+func TestDBAuthLoginFailureService_RecordAndEnqueue(t *testing.T) {
+	if NewDBAuthLoginFailureService(nil) != nil {
+		t.Fatalf("expected nil service for nil db")
+	}
+	var nilSvc *DBAuthLoginFailureService
+	if err := nilSvc.Record(context.Background(), AuthLoginFailure{}); err == nil {
+		t.Fatalf("expected error for nil service")
+	}
+	nilSvc.Enqueue(AuthLoginFailure{})
+
+	svc, mock := newMockDBService(t, NewDBAuthLoginFailureService)
+	mock.ExpectExec(`INSERT INTO auth_login_failure \(remote_ip, method, username, reason, user_agent, failures, locked, created_at\)`).
+		WithArgs("1.1.1.1", LoginMethodAccount, "alice", "用户名或密码错误", "curl/8", 3, 1, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	if err := svc.Record(context.Background(), AuthLoginFailure{RemoteIP: "1.1.1.1", Method: LoginMethodAccount, Username: "alice", Reason: "用户名或密码错误", UserAgent: "curl/8", Failures: 3, Locked: true}); err != nil {
+		t.Fatalf("Record: %v", err)
+	}
+
+	// 异步入队的记录在 Close 前写完；关闭后入队的记录直接丢弃。
+	mock.ExpectExec(`INSERT INTO auth_login_failure`).
+		WithArgs("2.2.2.2", LoginMethodAccessCode, nil, "访问码错误", nil, 0, 0, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(2, 1))
+	svc.Enqueue(AuthLoginFailure{RemoteIP: "2.2.2.2", Method: LoginMethodAccessCode, Reason: "访问码错误", Failures: -1})
+	if err := svc.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	svc.Enqueue(AuthLoginFailure{RemoteIP: "3.3.3.3"})
+	if svc.Dropped() != 1 {
+		t.Fatalf("dropped=%d, want 1", svc.Dropped())
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("expectations: %v", err)
+	}
+}
+
+func TestDBAuthLoginFailureService_List(t *testing.T) {
+	svc, mock := newMockDBService(t, NewDBAuthLoginFailureService)
+	now := time.Now()
+	since := now.Add(-time.Hour)
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT COUNT(*) FROM auth_login_failure WHERE 1 = 1 AND remote_ip = ? AND username = ? AND created_at >= ? AND created_at < ?")).
+		WithArgs("1.1.1.1", "alice", since, now).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+	mock.ExpectQuery(`FROM auth_login_failure\s+WHERE .+\s+ORDER BY created_at DESC, id DESC\s+LIMIT \? OFFSET \?`).
+		WithArgs("1.1.1.1", "alice", since, now, 20, 0).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "remote_ip", "method", "username", "reason", "user_agent", "failures", "locked", "created_at"}).
+			AddRow(2, "1.1.1.1", LoginMethodAccount, "alice", "账号已停用", nil, 2, 1, now).
+			AddRow(1, "1.1.1.1", LoginMethodAccount, "alice", "用户名或密码错误", "curl/8", 1, 0, now))
+	items, total, err := svc.List(context.Background(), AuthLoginFailureQuery{RemoteIP: " 1.1.1.1 ", Username: "alice", Since: since, Until: now, Page: 1, PageSize: 20})
+	if err != nil || total != 2 || len(items) != 2 || !items[0].Locked || items[0].UserAgent != "" || items[1].UserAgent != "curl/8" || items[0].CreateTime == "" {
+		t.Fatalf("items=%+v total=%d err=%v", items, total, err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("expectations: %v", err)
+	}
+}
+
+func TestHandleListLoginFailures(t *testing.T) {
+	svc, mock := newMockDBService(t, NewDBAuthLoginFailureService)
+	a := &App{loginFailures: svc}
+
+	rr := httptest.NewRecorder()
+	a.handleListLoginFailures(rr, httptest.NewRequest(http.MethodGet, "/api/auth/loginFailure/list?since=bogus", nil))
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("status=%d, want 400", rr.Code)
+	}
+
+	mock.ExpectQuery(`SELECT COUNT\(\*\) FROM auth_login_failure WHERE 1 = 1 AND remote_ip = \?`).
+		WithArgs("1.1.1.1").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	mock.ExpectQuery(`FROM auth_login_failure`).
+		WithArgs("1.1.1.1", 20, 0).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "remote_ip", "method", "username", "reason", "user_agent", "failures", "locked", "created_at"}))
+	rr = httptest.NewRecorder()
+	a.handleListLoginFailures(rr, httptest.NewRequest(http.MethodGet, "/api/auth/loginFailure/list?ip=1.1.1.1", nil))
+	got := decodeJSONBody(t, rr.Body)
+	if data, _ := got["data"].(map[string]any); got["code"] != float64(0) || data["total"] != float64(0) {
+		t.Fatalf("resp=%v", got)
+	}
+}
+
+func TestHandleAuthLogin_RecordsFailure(t *testing.T) {
+	svc, mock := newMockDBService(t, NewDBAuthLoginFailureService)
+	a := &App{
+		cfg:           config.Config{AuthAccessCode: "code-1", AuthLoginTrustForwarded: true},
+		jwt:           NewJWTService("secret-1", 1),
+		loginGuard:    NewLoginGuard(LoginGuardConfig{MaxFailuresPerIP: 1}, nil),
+		loginFailures: svc,
+	}
+
+	mock.ExpectExec(`INSERT INTO auth_login_failure`).
+		WithArgs("203.0.113.9", LoginMethodAccessCode, nil, "访问码错误", "ua-1", 1, 1, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	form := url.Values{}
+	form.Set("accessCode", "wrong")
+	req := newURLEncodedRequest(t, http.MethodPost, "http://example.com/api/auth/login", form)
+	req.Header.Set("X-Forwarded-For", "203.0.113.9")
+	req.Header.Set("User-Agent", "ua-1")
+	rr := httptest.NewRecorder()
+	a.handleAuthLogin(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("status=%d, want 400", rr.Code)
+	}
+
+	_ = svc.Close()
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("expectations: %v", err)
+	}
+}
@@ -0,0 +1,427 @@
+package app
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"liao/internal/database"
+)
+
+const (
+	AuthSessionRevokeLogout  = "logout"
+	AuthSessionRevokeAll     = "revoke all"
+	AuthSessionRevokeReuse   = "refresh reuse"
+	AuthSessionRevokeAccount = "account changed"
+
+	authSessionIDBytes     = 16
+	authRefreshSecretBytes = 32
+)
+
+var (
+	// authSessionSyncInterval 为从数据库增量同步吊销列表的间隔（多副本时其他副本的吊销最多延迟该时长生效）。
+	authSessionSyncInterval = 30 * time.Second
+	// authRevokedRetention 为吊销记录在会话过期后继续保留的时长，覆盖会话末期刷新签发的 access token。
+	authRevokedRetention = 24 * time.Hour
+	// authSessionPruneInterval 为清理过期会话的间隔。
+	authSessionPruneInterval = time.Hour
+
+	ErrAuthSessionInvalid = errors.New("登录状态已失效，请重新登录")
+)
+
+// DBAuthSessionService 持久化登录会话：每个会话持有一个轮换的 refresh token，
+// access token 通过 sid 声明绑定会话；被吊销的会话构成进程内吊销列表，由中间件与 /ws 握手校验。
+type DBAuthSessionService struct {
+	db  *database.DB
+	ttl time.Duration
+
+	mu        sync.Mutex
+	revoked   map[string]time.Time
+	lastSync  time.Time
+	lastPrune time.Time
+	onRevoke  func(sessionIDs []string)
+
+	stopCh    chan struct{}
+	wg        sync.WaitGroup
+	startOnce sync.Once
+	closeOnce sync.Once
+}
+
+// NewDBAuthSessionService 创建会话服务；ttl 为会话（refresh token）有效期。
+func NewDBAuthSessionService(db *database.DB, ttl time.Duration) *DBAuthSessionService {
+	if db == nil {
+		return nil
+	}
+	if ttl <= 0 {
+		ttl = 24 * time.Hour
+	}
+	return &DBAuthSessionService{
+		db:      db,
+		ttl:     ttl,
+		revoked: make(map[string]time.Time),
+		stopCh:  make(chan struct{}),
+	}
+}
+
+// SetOnRevoke 设置会话被吊销（含其他副本吊销后同步到本地）时的回调，用于关闭对应的下游连接。
+func (s *DBAuthSessionService) SetOnRevoke(fn func(sessionIDs []string)) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.onRevoke = fn
+	s.mu.Unlock()
+}
+
+// Start 加载吊销列表并启动后台同步。
+func (s *DBAuthSessionService) Start() {
+	if s == nil {
+		return
+	}
+	s.startOnce.Do(func() {
+		if err := s.sync(context.Background()); err != nil {
+			slog.Warn("加载会话吊销列表失败", "error", err)
+		}
+		s.wg.Add(1)
+		go s.syncLoop()
+	})
+}
+
+func (s *DBAuthSessionService) syncLoop() {
+	defer s.wg.Done()
+	ticker := time.NewTicker(authSessionSyncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stopCh:
+			return
+		case <-ticker.C:
+			if err := s.sync(context.Background()); err != nil {
+				slog.Warn("同步会话吊销列表失败", "error", err)
+			}
+			s.pruneIfDue(context.Background())
+		}
+	}
+}
+
+// Create 为登录主体创建会话，返回 sessionID 与 refresh token（格式 "<sessionID>.<随机串>"）。
+func (s *DBAuthSessionService) Create(ctx context.Context, principal AuthPrincipal, userAgent string, remoteAddr string) (string, string, error) {
+	if s == nil || s.db == nil {
+		return "", "", fmt.Errorf("db not initialized")
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	sessionID, err := randomHex(authSessionIDBytes)
+	if err != nil {
+		return "", "", err
+	}
+	refreshToken, err := newAuthRefreshToken(sessionID)
+	if err != nil {
+		return "", "", err
+	}
+
+	now := time.Now()
+	if _, err := s.db.ExecContext(ctx, `
+		INSERT INTO auth_session (session_id, subject, role, account, refresh_hash, user_agent, remote_addr, expires_at, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, sessionID, principal.Subject, principal.Role, boolToInt(principal.Account), hashAuthRefreshToken(refreshToken),
+		nullIfEmpty(truncateRunes(userAgent, 250)), nullIfEmpty(truncateRunes(remoteAddr, 60)), now.Add(s.ttl), now); err != nil {
+		return "", "", err
+	}
+	return sessionID, refreshToken, nil
+}
+
+// Rotate 校验 refresh token 并换发新的 refresh token，返回会话主体（Role 为登录时角色）。
+// 已被轮换掉的旧 token 再次出现视为泄露，整个会话立即吊销。
+func (s *DBAuthSessionService) Rotate(ctx context.Context, refreshToken string) (AuthPrincipal, string, error) {
+	if s == nil || s.db == nil {
+		return AuthPrincipal{}, "", fmt.Errorf("db not initialized")
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	sessionID, secret, ok := strings.Cut(strings.TrimSpace(refreshToken), ".")
+	if !ok || sessionID == "" || secret == "" {
+		return AuthPrincipal{}, "", ErrAuthSessionInvalid
+	}
+
+	var (
+		principal   = AuthPrincipal{SessionID: sessionID}
+		account     int
+		storedHash  string
+		expiresAt   time.Time
+		revokedAt   sql.NullTime
+		presentHash = hashAuthRefreshToken(strings.TrimSpace(refreshToken))
+	)
+	err := s.db.QueryRowContext(ctx, `
+		SELECT subject, role, account, refresh_hash, expires_at, revoked_at FROM auth_session WHERE session_id = ?
+	`, sessionID).Scan(&principal.Subject, &principal.Role, &account, &storedHash, &expiresAt, &revokedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return AuthPrincipal{}, "", ErrAuthSessionInvalid
+	}
+	if err != nil {
+		return AuthPrincipal{}, "", err
+	}
+	principal.Account = account != 0
+	if revokedAt.Valid || !time.Now().Before(expiresAt) {
+		return AuthPrincipal{}, "", ErrAuthSessionInvalid
+	}
+	if subtle.ConstantTimeCompare([]byte(presentHash), []byte(storedHash)) != 1 {
+		slog.Warn("检测到已轮换的 refresh token 被重复使用，吊销会话", "sessionId", sessionID, "subject", principal.Subject)
+		if err := s.Revoke(ctx, sessionID, AuthSessionRevokeReuse); err != nil {
+			slog.Warn("吊销会话失败", "sessionId", sessionID, "error", err)
+		}
+		return AuthPrincipal{}, "", ErrAuthSessionInvalid
+	}
+
+	next, err := newAuthRefreshToken(sessionID)
+	if err != nil {
+		return AuthPrincipal{}, "", err
+	}
+	res, err := s.db.ExecContext(ctx, `
+		UPDATE auth_session SET refresh_hash = ?, last_used_at = ? WHERE session_id = ? AND refresh_hash = ? AND revoked_at IS NULL
+	`, hashAuthRefreshToken(next), time.Now(), sessionID, storedHash)
+	if err != nil {
+		return AuthPrincipal{}, "", err
+	}
+	// 并发刷新时只有一个请求能换发成功。
+	if affected, err := res.RowsAffected(); err == nil && affected == 0 {
+		return AuthPrincipal{}, "", ErrAuthSessionInvalid
+	}
+	return principal, next, nil
+}
+
+// Revoke 吊销单个会话。
+func (s *DBAuthSessionService) Revoke(ctx context.Context, sessionID string, reason string) error {
+	_, err := s.revokeWhere(ctx, reason, "session_id = ?", sessionID)
+	return err
+}
+
+// RevokeAccount 吊销某个账号的全部会话，返回吊销数量。
+func (s *DBAuthSessionService) RevokeAccount(ctx context.Context, username string, reason string) (int, error) {
+	return s.revokeWhere(ctx, reason, "account = 1 AND subject = ?", username)
+}
+
+// RevokeAll 吊销全部未过期会话，返回吊销数量。
+func (s *DBAuthSessionService) RevokeAll(ctx context.Context, reason string) (int, error) {
+	return s.revokeWhere(ctx, reason, "1 = 1")
+}
+
+func (s *DBAuthSessionService) revokeWhere(ctx context.Context, reason string, cond string, args ...any) (int, error) {
+	if s == nil || s.db == nil {
+		return 0, fmt.Errorf("db not initialized")
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	now := time.Now()
+	res, err := s.db.ExecContext(ctx, `
+		UPDATE auth_session SET revoked_at = ?, revoke_reason = ? WHERE revoked_at IS NULL AND expires_at > ? AND `+cond,
+		append([]any{now, reason, now}, args...)...)
+	if err != nil {
+		return 0, err
+	}
+	affected, _ := res.RowsAffected()
+	if affected > 0 {
+		// 立即同步，使本副本无需等待下一轮即生效并关闭对应连接。
+		if err := s.sync(ctx); err != nil {
+			return int(affected), err
+		}
+	}
+	return int(affected), nil
+}
+
+// IsRevoked 判断会话是否已被吊销（只查进程内吊销列表）。
+func (s *DBAuthSessionService) IsRevoked(sessionID string) bool {
+	if s == nil {
+		return false
+	}
+	s.mu.Lock()
+	_, ok := s.revoked[sessionID]
+	s.mu.Unlock()
+	return ok
+}
+
+// sync 增量加载自上次同步以来被吊销的会话，并对新增项触发 onRevoke。
+func (s *DBAuthSessionService) sync(ctx context.Context) error {
+	s.mu.Lock()
+	since := s.lastSync.Add(-5 * time.Second)
+	if s.lastSync.IsZero() {
+		since = time.Now().Add(-s.ttl - authRevokedRetention)
+	}
+	s.mu.Unlock()
+
+	now := time.Now()
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT session_id, expires_at FROM auth_session WHERE revoked_at IS NOT NULL AND revoked_at >= ? AND expires_at > ?
+	`, since, now.Add(-authRevokedRetention))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	type revokedRow struct {
+		sessionID string
+		expiresAt time.Time
+	}
+	var loaded []revokedRow
+	for rows.Next() {
+		var row revokedRow
+		if err := rows.Scan(&row.sessionID, &row.expiresAt); err != nil {
+			return err
+		}
+		loaded = append(loaded, row)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	var added []string
+	for _, row := range loaded {
+		if _, ok := s.revoked[row.sessionID]; !ok {
+			added = append(added, row.sessionID)
+		}
+		s.revoked[row.sessionID] = row.expiresAt.Add(authRevokedRetention)
+	}
+	for sessionID, until := range s.revoked {
+		if now.After(until) {
+			delete(s.revoked, sessionID)
+		}
+	}
+	s.lastSync = now
+	onRevoke := s.onRevoke
+	s.mu.Unlock()
+
+	if len(added) > 0 && onRevoke != nil {
+		onRevoke(added)
+	}
+	return nil
+}
+
+func (s *DBAuthSessionService) pruneIfDue(ctx context.Context) {
+	s.mu.Lock()
+	due := time.Since(s.lastPrune) >= authSessionPruneInterval
+	if due {
+		s.lastPrune = time.Now()
+	}
+	s.mu.Unlock()
+	if !due {
+		return
+	}
+	if _, err := s.db.ExecContext(ctx, "DELETE FROM auth_session WHERE expires_at < ?", time.Now().Add(-authRevokedRetention)); err != nil {
+		slog.Warn("清理过期会话失败", "error", err)
+	}
+}
+
+func (s *DBAuthSessionService) Close() error {
+	if s == nil {
+		return nil
+	}
+	s.closeOnce.Do(func() {
+		close(s.stopCh)
+		s.wg.Wait()
+	})
+	return nil
+}
+
+func newAuthRefreshToken(sessionID string) (string, error) {
+	secret := make([]byte, authRefreshSecretBytes)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return sessionID + "." + base64.RawURLEncoding.EncodeToString(secret), nil
+}
+
+func hashAuthRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomHex(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// guardKey 返回 /ws、/sse 并发会话上限的计数键：绑定登录会话时按会话计数（续期后的新 access token 仍计入同一会话），否则按 Token。
+func (p AuthPrincipal) guardKey(token string) string {
+	if p.SessionID != "" {
+		return "sid:" + p.SessionID
+	}
+	return token
+}
+
+// authConnRegistry 按登录会话登记 /ws、/sse 下游连接，会话被吊销时关闭对应连接。
+type authConnRegistry struct {
+	mu    sync.Mutex
+	conns map[string]map[*DownstreamSession]struct{}
+}
+
+func newAuthConnRegistry() *authConnRegistry {
+	return &authConnRegistry{conns: make(map[string]map[*DownstreamSession]struct{})}
+}
+
+func (r *authConnRegistry) add(sessionID string, session *DownstreamSession) {
+	if r == nil || sessionID == "" || session == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	set := r.conns[sessionID]
+	if set == nil {
+		set = make(map[*DownstreamSession]struct{})
+		r.conns[sessionID] = set
+	}
+	set[session] = struct{}{}
+}
+
+func (r *authConnRegistry) remove(sessionID string, session *DownstreamSession) {
+	if r == nil || sessionID == "" || session == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if set := r.conns[sessionID]; set != nil {
+		delete(set, session)
+		if len(set) == 0 {
+			delete(r.conns, sessionID)
+		}
+	}
+}
+
+// closeSessions 以 WSCloseTokenRevoked 关闭这些会话下的全部下游连接。
+func (r *authConnRegistry) closeSessions(sessionIDs []string) {
+	if r == nil {
+		return
+	}
+	var targets []*DownstreamSession
+	r.mu.Lock()
+	for _, sessionID := range sessionIDs {
+		for session := range r.conns[sessionID] {
+			targets = append(targets, session)
+		}
+		delete(r.conns, sessionID)
+	}
+	r.mu.Unlock()
+
+	for _, session := range targets {
+		session.closeWithCode(WSCloseTokenRevoked, "登录已失效")
+	}
+	if len(targets) > 0 {
+		slog.Info("会话已吊销，关闭下游连接", "sessions", len(sessionIDs), "connections", len(targets))
+	}
+}
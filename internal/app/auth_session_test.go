@@ -0,0 +1,333 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+
+	"liao/internal/database"
+)
+
+// newHourAuthSessionService 以 1 小时的 refresh token 有效期创建会话服务。
+func newHourAuthSessionService(db *database.DB) *DBAuthSessionService {
+	return NewDBAuthSessionService(db, time.Hour)
+}
+
+var authSessionTestColumns = []string{"subject", "role", "account", "refresh_hash", "expires_at", "revoked_at"}
+
+func expectAuthSessionRevoke(mock sqlmock.Sqlmock, sessionID string, affected int64) {
+	mock.ExpectExec(`UPDATE auth_session SET revoked_at = \?, revoke_reason = \? WHERE revoked_at IS NULL`).
+		WillReturnResult(sqlmock.NewResult(0, affected))
+	if affected > 0 {
+		mock.ExpectQuery(`SELECT session_id, expires_at FROM auth_session WHERE revoked_at IS NOT NULL`).
+			WillReturnRows(sqlmock.NewRows([]string{"session_id", "expires_at"}).AddRow(sessionID, time.Now().Add(time.Hour)))
+	}
+}
+
+func TestNewDBAuthSessionService_NilDB(t *testing.T) {
+	if NewDBAuthSessionService(nil, time.Hour) != nil {
+		t.Fatalf("expected nil service")
+	}
+	var svc *DBAuthSessionService
+	if svc.IsRevoked("x") {
+		t.Fatalf("nil service must not report revoked")
+	}
+	if _, _, err := svc.Create(context.Background(), AuthPrincipal{}, "", ""); err == nil {
+		t.Fatalf("expected error")
+	}
+	if err := svc.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+}
+
+func TestDBAuthSessionService_CreateAndRotate(t *testing.T) {
+	svc, mock := newMockDBService(t, newHourAuthSessionService)
+	ctx := context.Background()
+
+	mock.ExpectExec(`INSERT INTO auth_session`).
+		WithArgs(sqlmock.AnyArg(), "alice", AuthRoleOperator, 1, sqlmock.AnyArg(), "ua", "1.2.3.4:5", sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	sessionID, refresh, err := svc.Create(ctx, AuthPrincipal{Subject: "alice", Role: AuthRoleOperator, Account: true}, "ua", "1.2.3.4:5")
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if len(sessionID) != authSessionIDBytes*2 || !strings.HasPrefix(refresh, sessionID+".") {
+		t.Fatalf("sessionID=%q refresh=%q", sessionID, refresh)
+	}
+
+	expiresAt := time.Now().Add(time.Hour)
+	mock.ExpectQuery(`SELECT subject, role, account, refresh_hash, expires_at, revoked_at FROM auth_session WHERE session_id = \?`).
+		WithArgs(sessionID).
+		WillReturnRows(sqlmock.NewRows(authSessionTestColumns).AddRow("alice", AuthRoleOperator, 1, hashAuthRefreshToken(refresh), expiresAt, nil))
+	mock.ExpectExec(`UPDATE auth_session SET refresh_hash = \?, last_used_at = \? WHERE session_id = \? AND refresh_hash = \?`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	principal, next, err := svc.Rotate(ctx, refresh)
+	if err != nil {
+		t.Fatalf("Rotate: %v", err)
+	}
+	if principal.Subject != "alice" || !principal.Account || principal.SessionID != sessionID || next == refresh || !strings.HasPrefix(next, sessionID+".") {
+		t.Fatalf("principal=%+v next=%q", principal, next)
+	}
+
+	// 旧 refresh token 被重复使用：视为泄露，整个会话吊销并通知回调。
+	var notified []string
+	svc.SetOnRevoke(func(ids []string) { notified = append(notified, ids...) })
+	mock.ExpectQuery(`SELECT subject, role, account, refresh_hash, expires_at, revoked_at FROM auth_session WHERE session_id = \?`).
+		WithArgs(sessionID).
+		WillReturnRows(sqlmock.NewRows(authSessionTestColumns).AddRow("alice", AuthRoleOperator, 1, hashAuthRefreshToken(next), expiresAt, nil))
+	expectAuthSessionRevoke(mock, sessionID, 1)
+	if _, _, err := svc.Rotate(ctx, refresh); !errors.Is(err, ErrAuthSessionInvalid) {
+		t.Fatalf("expected invalid session, got %v", err)
+	}
+	if !svc.IsRevoked(sessionID) || len(notified) != 1 || notified[0] != sessionID {
+		t.Fatalf("revoked=%v notified=%v", svc.IsRevoked(sessionID), notified)
+	}
+}
+
+func TestDBAuthSessionService_RotateRejectsInvalid(t *testing.T) {
+	svc, mock := newMockDBService(t, newHourAuthSessionService)
+	ctx := context.Background()
+
+	for _, token := range []string{"", "abc", ".x", "abc."} {
+		if _, _, err := svc.Rotate(ctx, token); !errors.Is(err, ErrAuthSessionInvalid) {
+			t.Fatalf("token=%q err=%v", token, err)
+		}
+	}
+
+	mock.ExpectQuery(`SELECT .* FROM auth_session WHERE session_id = \?`).WithArgs("s1").
+		WillReturnRows(sqlmock.NewRows(authSessionTestColumns))
+	if _, _, err := svc.Rotate(ctx, "s1.x"); !errors.Is(err, ErrAuthSessionInvalid) {
+		t.Fatalf("missing session: err=%v", err)
+	}
+
+	mock.ExpectQuery(`SELECT .* FROM auth_session WHERE session_id = \?`).WithArgs("s1").
+		WillReturnRows(sqlmock.NewRows(authSessionTestColumns).AddRow("user", AuthRoleAdmin, 0, hashAuthRefreshToken("s1.x"), time.Now().Add(time.Hour), time.Now()))
+	if _, _, err := svc.Rotate(ctx, "s1.x"); !errors.Is(err, ErrAuthSessionInvalid) {
+		t.Fatalf("revoked session: err=%v", err)
+	}
+
+	mock.ExpectQuery(`SELECT .* FROM auth_session WHERE session_id = \?`).WithArgs("s1").
+		WillReturnRows(sqlmock.NewRows(authSessionTestColumns).AddRow("user", AuthRoleAdmin, 0, hashAuthRefreshToken("s1.x"), time.Now().Add(-time.Minute), nil))
+	if _, _, err := svc.Rotate(ctx, "s1.x"); !errors.Is(err, ErrAuthSessionInvalid) {
+		t.Fatalf("expired session: err=%v", err)
+	}
+
+	// 并发刷新：另一请求已先完成轮换。
+	mock.ExpectQuery(`SELECT .* FROM auth_session WHERE session_id = \?`).WithArgs("s1").
+		WillReturnRows(sqlmock.NewRows(authSessionTestColumns).AddRow("user", AuthRoleAdmin, 0, hashAuthRefreshToken("s1.x"), time.Now().Add(time.Hour), nil))
+	mock.ExpectExec(`UPDATE auth_session SET refresh_hash = \?`).WillReturnResult(sqlmock.NewResult(0, 0))
+	if _, _, err := svc.Rotate(ctx, "s1.x"); !errors.Is(err, ErrAuthSessionInvalid) {
+		t.Fatalf("lost race: err=%v", err)
+	}
+}
+
+func TestDBAuthSessionService_RevokeAccountAndAll(t *testing.T) {
+	svc, mock := newMockDBService(t, newHourAuthSessionService)
+	ctx := context.Background()
+
+	mock.ExpectExec(`UPDATE auth_session SET revoked_at = \?, revoke_reason = \? WHERE .* AND account = 1 AND subject = \?`).
+		WithArgs(sqlmock.AnyArg(), AuthSessionRevokeAccount, sqlmock.AnyArg(), "alice").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	if count, err := svc.RevokeAccount(ctx, "alice", AuthSessionRevokeAccount); err != nil || count != 0 {
+		t.Fatalf("count=%d err=%v", count, err)
+	}
+
+	mock.ExpectExec(`UPDATE auth_session SET revoked_at = \?, revoke_reason = \? WHERE .* AND 1 = 1`).
+		WithArgs(sqlmock.AnyArg(), AuthSessionRevokeAll, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectQuery(`SELECT session_id, expires_at FROM auth_session WHERE revoked_at IS NOT NULL`).
+		WillReturnRows(sqlmock.NewRows([]string{"session_id", "expires_at"}).
+			AddRow("s1", time.Now().Add(time.Hour)).
+			AddRow("s2", time.Now().Add(-2*authRevokedRetention)))
+	if count, err := svc.RevokeAll(ctx, AuthSessionRevokeAll); err != nil || count != 2 {
+		t.Fatalf("count=%d err=%v", count, err)
+	}
+	if !svc.IsRevoked("s1") || svc.IsRevoked("s2") || svc.IsRevoked("s3") {
+		t.Fatalf("revoked=%v", svc.revoked)
+	}
+}
+
+func TestAuthConnRegistry_CloseSessions(t *testing.T) {
+	reg := newAuthConnRegistry()
+	rec := httptest.NewRecorder()
+	target := NewDownstreamSession(newSSEDownstreamTransport(rec))
+	other := NewDownstreamSession(newSSEDownstreamTransport(httptest.NewRecorder()))
+	reg.add("s1", target)
+	reg.add("s2", other)
+	reg.add("", other)
+	reg.remove("s2", other)
+
+	reg.closeSessions([]string{"s1", "s2"})
+	if !strings.Contains(rec.Body.String(), `"code":4401`) {
+		t.Fatalf("body=%q", rec.Body.String())
+	}
+	if len(reg.conns) != 0 {
+		t.Fatalf("conns=%v", reg.conns)
+	}
+
+	var nilReg *authConnRegistry
+	nilReg.add("s1", target)
+	nilReg.closeSessions([]string{"s1"})
+}
+
+func TestAuthenticateToken_RejectsRevokedSession(t *testing.T) {
+	jwtService := NewJWTService("secret", 1)
+	sessions := &DBAuthSessionService{revoked: map[string]time.Time{"revoked": time.Now().Add(time.Hour)}}
+	a := &App{jwt: jwtService, authSessions: sessions}
+
+	live, _ := jwtService.GenerateTokenFor(AuthPrincipal{Subject: authLegacySubject, Role: AuthRoleAdmin, SessionID: "live"})
+	if principal, ok := a.authenticateToken(context.Background(), live); !ok || principal.SessionID != "live" {
+		t.Fatalf("principal=%+v ok=%v", principal, ok)
+	}
+	revoked, _ := jwtService.GenerateTokenFor(AuthPrincipal{Subject: authLegacySubject, Role: AuthRoleAdmin, SessionID: "revoked"})
+	if _, ok := a.authenticateToken(context.Background(), revoked); ok {
+		t.Fatalf("expected revoked session rejected")
+	}
+	// 启用会话存储后，不绑定会话的旧版 Token 不再有效。
+	legacy, _ := jwtService.GenerateToken()
+	if _, ok := a.authenticateToken(context.Background(), legacy); ok {
+		t.Fatalf("expected token without sid rejected")
+	}
+}
+
+func TestJWTService_AccessTokenTTL(t *testing.T) {
+	jwtService := NewJWTService("secret", 2)
+	if jwtService.AccessTokenTTL() != 2*time.Hour {
+		t.Fatalf("ttl=%v", jwtService.AccessTokenTTL())
+	}
+	jwtService.SetAccessTokenTTL(5 * time.Minute)
+	token, _ := jwtService.GenerateTokenFor(AuthPrincipal{Subject: "alice", Role: AuthRoleViewer, Account: true, SessionID: "s1"})
+	claims, err := jwtService.ParseToken(token)
+	if err != nil {
+		t.Fatalf("ParseToken: %v", err)
+	}
+	if claims.SessionID != "s1" || claims.ID == "" || time.Until(claims.ExpiresAt.Time) > 5*time.Minute {
+		t.Fatalf("claims=%+v", claims)
+	}
+}
+
+func TestHandleAuthRefresh(t *testing.T) {
+	svc, mock := newMockDBService(t, newHourAuthSessionService)
+	jwtService := NewJWTService("secret", 1)
+	a := &App{jwt: jwtService, authSessions: svc}
+	post := func(form url.Values) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		a.handleAuthRefresh(rr, newURLEncodedRequest(t, http.MethodPost, "http://example.com/api/auth/refresh", form))
+		return rr
+	}
+
+	if rr := post(url.Values{}); rr.Code != http.StatusBadRequest {
+		t.Fatalf("status=%d body=%s", rr.Code, rr.Body.String())
+	}
+	if rr := post(url.Values{"refreshToken": {"bad"}}); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("status=%d body=%s", rr.Code, rr.Body.String())
+	}
+
+	mock.ExpectQuery(`SELECT .* FROM auth_session WHERE session_id = \?`).WithArgs("s1").
+		WillReturnRows(sqlmock.NewRows(authSessionTestColumns).AddRow(authLegacySubject, AuthRoleAdmin, 0, hashAuthRefreshToken("s1.x"), time.Now().Add(time.Hour), nil))
+	mock.ExpectExec(`UPDATE auth_session SET refresh_hash = \?`).WillReturnResult(sqlmock.NewResult(0, 1))
+	rr := post(url.Values{"refreshToken": {"s1.x"}})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("status=%d body=%s", rr.Code, rr.Body.String())
+	}
+	var resp struct {
+		Code         int    `json:"code"`
+		Token        string `json:"token"`
+		RefreshToken string `json:"refreshToken"`
+		ExpiresIn    int64  `json:"expiresIn"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if resp.Code != 0 || resp.Token == "" || !strings.HasPrefix(resp.RefreshToken, "s1.") || resp.ExpiresIn != 3600 {
+		t.Fatalf("resp=%+v", resp)
+	}
+	if claims, err := jwtService.ParseToken(resp.Token); err != nil || claims.SessionID != "s1" {
+		t.Fatalf("claims=%+v err=%v", claims, err)
+	}
+
+	// 关闭访问码登录后，访问码会话无法再续期。
+	a.cfg.AuthAccessCodeDisabled = true
+	mock.ExpectQuery(`SELECT .* FROM auth_session WHERE session_id = \?`).WithArgs("s1").
+		WillReturnRows(sqlmock.NewRows(authSessionTestColumns).AddRow(authLegacySubject, AuthRoleAdmin, 0, hashAuthRefreshToken("s1.y"), time.Now().Add(time.Hour), nil))
+	mock.ExpectExec(`UPDATE auth_session SET refresh_hash = \?`).WillReturnResult(sqlmock.NewResult(0, 1))
+	if rr := post(url.Values{"refreshToken": {"s1.y"}}); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("status=%d body=%s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestHandleAuthLogoutAndRevokeAll(t *testing.T) {
+	svc, mock := newMockDBService(t, newHourAuthSessionService)
+	a := &App{jwt: NewJWTService("secret", 1), authSessions: svc}
+
+	req := httptest.NewRequest(http.MethodPost, "http://example.com/api/auth/logout", nil)
+	req = req.WithContext(withAuthPrincipal(req.Context(), AuthPrincipal{Subject: authLegacySubject, Role: AuthRoleAdmin, SessionID: "s1"}))
+	expectAuthSessionRevoke(mock, "s1", 1)
+	rr := httptest.NewRecorder()
+	a.handleAuthLogout(rr, req)
+	if rr.Code != http.StatusOK || !svc.IsRevoked("s1") {
+		t.Fatalf("status=%d body=%s", rr.Code, rr.Body.String())
+	}
+
+	mock.ExpectExec(`UPDATE auth_session SET revoked_at = \?`).WillReturnResult(sqlmock.NewResult(0, 0))
+	rr = httptest.NewRecorder()
+	a.handleAuthRevokeAll(rr, httptest.NewRequest(http.MethodPost, "http://example.com/api/auth/revokeAll", nil))
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `"revoked":0`) {
+		t.Fatalf("status=%d body=%s", rr.Code, rr.Body.String())
+	}
+
+	a.authSessions = nil
+	rr = httptest.NewRecorder()
+	a.handleAuthRevokeAll(rr, httptest.NewRequest(http.MethodPost, "http://example.com/api/auth/revokeAll", nil))
+	if !strings.Contains(rr.Body.String(), `"code":-1`) {
+		t.Fatalf("body=%s", rr.Body.String())
+	}
+}
+
+func TestDBAuthUserService_CredentialChangeHook(t *testing.T) {
+	lowerAuthPasswordIterations(t)
+	svc, mock := newMockDBService(t, NewDBAuthUserService)
+	ctx := context.Background()
+	var changed []string
+	svc.SetOnCredentialChange(func(_ context.Context, username string) { changed = append(changed, username) })
+
+	// 仅修改角色不触发吊销（中间件按当前角色鉴权）。
+	role := AuthRoleViewer
+	mock.ExpectQuery(`SELECT .* FROM auth_user WHERE id = \?`).WithArgs(int64(7)).
+		WillReturnRows(authUserTestRows(7, "alice", "h", AuthRoleOperator, 0))
+	mock.ExpectExec(`UPDATE auth_user SET role = \?`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`SELECT .* FROM auth_user WHERE id = \?`).WithArgs(int64(7)).
+		WillReturnRows(authUserTestRows(7, "alice", "h", AuthRoleViewer, 0))
+	if _, err := svc.Update(ctx, 7, AuthUserUpdate{Role: &role}); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+
+	password := "password-2"
+	mock.ExpectQuery(`SELECT .* FROM auth_user WHERE id = \?`).WithArgs(int64(7)).
+		WillReturnRows(authUserTestRows(7, "alice", "h", AuthRoleViewer, 0))
+	mock.ExpectExec(`UPDATE auth_user SET role = \?`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`SELECT .* FROM auth_user WHERE id = \?`).WithArgs(int64(7)).
+		WillReturnRows(authUserTestRows(7, "alice", "h2", AuthRoleViewer, 0))
+	if _, err := svc.Update(ctx, 7, AuthUserUpdate{Password: &password}); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+
+	mock.ExpectQuery(`SELECT .* FROM auth_user WHERE id = \?`).WithArgs(int64(7)).
+		WillReturnRows(authUserTestRows(7, "alice", "h2", AuthRoleViewer, 0))
+	mock.ExpectExec(`DELETE FROM auth_user WHERE id = \?`).WithArgs(int64(7)).WillReturnResult(sqlmock.NewResult(0, 1))
+	if err := svc.Delete(ctx, 7); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+
+	if len(changed) != 2 || changed[0] != "alice" || changed[1] != "alice" {
+		t.Fatalf("changed=%v", changed)
+	}
+}
@@ -0,0 +1,508 @@
+package app
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"liao/internal/database"
+	"liao/internal/qrcode"
+)
+
+const (
+	// 参数与主流验证器 App 的默认值一致（RFC 6238：HMAC-SHA1、30 秒、6 位）。
+	authTOTPPeriod      = 30
+	authTOTPDigits      = 6
+	authTOTPModulus     = 1000000 // 10^authTOTPDigits
+	authTOTPSecretBytes = 20
+	// authTOTPSkewSteps 为允许的时钟偏差（前后各 1 个时间步）。
+	authTOTPSkewSteps = 1
+
+	authTOTPRecoveryCodeCount = 10
+	authTOTPRecoveryCodeRunes = 10
+	authTOTPQRCodeScale       = 6
+)
+
+var (
+	authTOTPEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+	ErrAuthTOTPNotSetup       = errors.New("尚未生成两步验证密钥")
+	ErrAuthTOTPAlreadyEnabled = errors.New("两步验证已启用")
+	ErrAuthTOTPNotEnabled     = errors.New("两步验证未启用")
+	ErrAuthTOTPInvalidCode    = errors.New("两步验证码错误")
+)
+
+// AuthTOTPStatus 为账号的两步验证状态；Pending 表示已生成密钥但尚未验证启用。
+type AuthTOTPStatus struct {
+	Enabled           bool   `json:"enabled"`
+	Pending           bool   `json:"pending"`
+	EnabledTime       string `json:"enabledTime,omitempty"`
+	RecoveryCodesLeft int    `json:"recoveryCodesLeft"`
+}
+
+// AuthTOTPSetup 为生成密钥后返回给调用者的绑定信息，QRCode 为 otpauth 地址的 PNG data URL。
+type AuthTOTPSetup struct {
+	Secret     string `json:"secret"`
+	OTPAuthURL string `json:"otpauthUrl"`
+	QRCode     string `json:"qrCode"`
+}
+
+type authTOTPRecord struct {
+	secret       string
+	enabled      bool
+	lastUsedStep int64
+	enabledAt    sql.NullTime
+}
+
+// DBAuthTOTPService 基于数据库管理账号的 TOTP 两步验证密钥与恢复码（恢复码仅保存 SHA-256 哈希）。
+type DBAuthTOTPService struct {
+	db     *database.DB
+	issuer string
+	now    func() time.Time
+
+	// anyEnabledAt 为最近一次确认“已有账号启用两步验证”的时间，零值表示未缓存；只缓存启用结果，
+	// 其他副本刚启用两步验证时本副本下次判断即查库，访问码不会因缓存继续可用。
+	mu           sync.Mutex
+	anyEnabledAt time.Time
+}
+
+// NewDBAuthTOTPService 创建两步验证服务；issuer 为验证器 App 中显示的发行方名称。
+func NewDBAuthTOTPService(db *database.DB, issuer string) *DBAuthTOTPService {
+	if db == nil {
+		return nil
+	}
+	return &DBAuthTOTPService{db: db, issuer: issuer, now: time.Now}
+}
+
+// Status 返回账号的两步验证状态。
+func (s *DBAuthTOTPService) Status(ctx context.Context, userID int64) (*AuthTOTPStatus, error) {
+	if s == nil || s.db == nil {
+		return nil, fmt.Errorf("db not initialized")
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	record, err := s.find(ctx, userID)
+	if errors.Is(err, ErrAuthTOTPNotSetup) {
+		return &AuthTOTPStatus{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	status := &AuthTOTPStatus{Enabled: record.enabled, Pending: !record.enabled, EnabledTime: formatNullLocalDateTimeISO(record.enabledAt)}
+	if record.enabled {
+		if err := s.db.QueryRowContext(ctx, `
+			SELECT COUNT(*) FROM auth_totp_recovery WHERE user_id = ? AND used_at IS NULL
+		`, userID).Scan(&status.RecoveryCodesLeft); err != nil {
+			return nil, err
+		}
+	}
+	return status, nil
+}
+
+// Enabled 判断账号是否已启用两步验证。
+func (s *DBAuthTOTPService) Enabled(ctx context.Context, userID int64) (bool, error) {
+	if s == nil || s.db == nil {
+		return false, fmt.Errorf("db not initialized")
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	record, err := s.find(ctx, userID)
+	if errors.Is(err, ErrAuthTOTPNotSetup) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return record.enabled, nil
+}
+
+// AnyEnabled 判断是否有账号已启用两步验证；结果为 true 时短时缓存（本副本重置时失效），为 false 时每次查库。
+func (s *DBAuthTOTPService) AnyEnabled(ctx context.Context) (bool, error) {
+	if s == nil || s.db == nil {
+		return false, fmt.Errorf("db not initialized")
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	s.mu.Lock()
+	cached := !s.anyEnabledAt.IsZero() && time.Since(s.anyEnabledAt) < authUserCacheTTL
+	s.mu.Unlock()
+	if cached {
+		return true, nil
+	}
+
+	var count int
+	if err := s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM auth_totp WHERE enabled = 1").Scan(&count); err != nil {
+		return false, err
+	}
+	s.setAnyEnabled(count > 0)
+	return count > 0, nil
+}
+
+// setAnyEnabled 记录最新的启用状态：true 时缓存，false 时清除缓存。
+func (s *DBAuthTOTPService) setAnyEnabled(enabled bool) {
+	s.mu.Lock()
+	if enabled {
+		s.anyEnabledAt = time.Now()
+	} else {
+		s.anyEnabledAt = time.Time{}
+	}
+	s.mu.Unlock()
+}
+
+// Setup 为账号生成新的待验证密钥（覆盖之前未启用的密钥）；已启用时需先停用。
+func (s *DBAuthTOTPService) Setup(ctx context.Context, userID int64, username string) (*AuthTOTPSetup, error) {
+	if s == nil || s.db == nil {
+		return nil, fmt.Errorf("db not initialized")
+	}
+	if userID <= 0 {
+		return nil, errBadRequest("账号参数非法")
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	raw := make([]byte, authTOTPSecretBytes)
+	if _, err := rand.Read(raw); err != nil {
+		return nil, err
+	}
+	secret := authTOTPEncoding.EncodeToString(raw)
+	now := s.now()
+
+	// 先覆盖待验证的密钥；没有则插入，唯一键冲突说明该账号已启用。
+	res, err := s.db.ExecContext(ctx, `
+		UPDATE auth_totp SET secret = ?, last_used_step = 0, updated_at = ? WHERE user_id = ? AND enabled = 0
+	`, secret, now, userID)
+	if err != nil {
+		return nil, err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return nil, err
+	} else if n == 0 {
+		if _, err := s.db.ExecContext(ctx, `
+			INSERT INTO auth_totp (user_id, secret, enabled, last_used_step, created_at, updated_at)
+			VALUES (?, ?, 0, 0, ?, ?)
+		`, userID, secret, now, now); err != nil {
+			if s.db.Dialect().IsDuplicateKey(err) {
+				return nil, ErrAuthTOTPAlreadyEnabled
+			}
+			return nil, err
+		}
+	}
+
+	uri := authTOTPURI(s.issuer, username, secret)
+	code, err := qrcode.Encode([]byte(uri))
+	if err != nil {
+		return nil, err
+	}
+	png, err := code.PNG(authTOTPQRCodeScale)
+	if err != nil {
+		return nil, err
+	}
+	return &AuthTOTPSetup{
+		Secret:     secret,
+		OTPAuthURL: uri,
+		QRCode:     "data:image/png;base64," + base64.StdEncoding.EncodeToString(png),
+	}, nil
+}
+
+// Enable 以验证器生成的验证码确认待验证密钥并启用两步验证，返回新生成的恢复码明文（仅此一次）。
+func (s *DBAuthTOTPService) Enable(ctx context.Context, userID int64, code string) ([]string, error) {
+	if s == nil || s.db == nil {
+		return nil, fmt.Errorf("db not initialized")
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	record, err := s.find(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if record.enabled {
+		return nil, ErrAuthTOTPAlreadyEnabled
+	}
+	step, ok := matchAuthTOTPStep(record.secret, code, s.now(), record.lastUsedStep)
+	if !ok {
+		return nil, ErrAuthTOTPInvalidCode
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	now := s.now()
+	res, err := tx.ExecContext(ctx, `
+		UPDATE auth_totp SET enabled = 1, enabled_at = ?, last_used_step = ?, updated_at = ? WHERE user_id = ? AND enabled = 0
+	`, now, step, now, userID)
+	if err != nil {
+		_ = tx.Rollback()
+		return nil, err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		_ = tx.Rollback()
+		if err != nil {
+			return nil, err
+		}
+		return nil, ErrAuthTOTPAlreadyEnabled
+	}
+	codes, err := replaceAuthTOTPRecoveryCodes(ctx, tx, userID, now)
+	if err != nil {
+		_ = tx.Rollback()
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	s.setAnyEnabled(true)
+	return codes, nil
+}
+
+// Verify 校验登录时提交的验证码：6 位数字按 TOTP 校验（同一时间步只能使用一次），其余按恢复码校验并作废该恢复码。
+func (s *DBAuthTOTPService) Verify(ctx context.Context, userID int64, code string) error {
+	if s == nil || s.db == nil {
+		return fmt.Errorf("db not initialized")
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	code = strings.TrimSpace(code)
+	if !isAuthTOTPCode(code) {
+		return s.useRecoveryCode(ctx, userID, code)
+	}
+
+	record, err := s.find(ctx, userID)
+	if errors.Is(err, ErrAuthTOTPNotSetup) {
+		return ErrAuthTOTPNotEnabled
+	}
+	if err != nil {
+		return err
+	}
+	if !record.enabled {
+		return ErrAuthTOTPNotEnabled
+	}
+	step, ok := matchAuthTOTPStep(record.secret, code, s.now(), record.lastUsedStep)
+	if !ok {
+		return ErrAuthTOTPInvalidCode
+	}
+	// 条件更新保证并发请求中同一验证码只有一个能通过。
+	res, err := s.db.ExecContext(ctx, `
+		UPDATE auth_totp SET last_used_step = ? WHERE user_id = ? AND enabled = 1 AND last_used_step < ?
+	`, step, userID, step)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrAuthTOTPInvalidCode
+	}
+	return nil
+}
+
+// RegenerateRecoveryCodes 作废现有恢复码并生成一组新的恢复码；code 需为有效的验证码或恢复码。
+func (s *DBAuthTOTPService) RegenerateRecoveryCodes(ctx context.Context, userID int64, code string) ([]string, error) {
+	if err := s.Verify(ctx, userID, code); err != nil {
+		return nil, err
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	codes, err := replaceAuthTOTPRecoveryCodes(ctx, tx, userID, s.now())
+	if err != nil {
+		_ = tx.Rollback()
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// Reset 删除账号的两步验证密钥与恢复码（用户自行停用、管理员重置或删除账号时调用），返回之前是否已启用。
+func (s *DBAuthTOTPService) Reset(ctx context.Context, userID int64) (bool, error) {
+	if s == nil || s.db == nil {
+		return false, fmt.Errorf("db not initialized")
+	}
+	if userID <= 0 {
+		return false, errBadRequest("id 参数非法")
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	enabled, err := s.Enabled(ctx, userID)
+	if err != nil {
+		return false, err
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	for _, query := range []string{
+		"DELETE FROM auth_totp_recovery WHERE user_id = ?",
+		"DELETE FROM auth_totp WHERE user_id = ?",
+	} {
+		if _, err := tx.ExecContext(ctx, query, userID); err != nil {
+			_ = tx.Rollback()
+			return false, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return false, err
+	}
+	if enabled {
+		s.setAnyEnabled(false)
+	}
+	return enabled, nil
+}
+
+func (s *DBAuthTOTPService) useRecoveryCode(ctx context.Context, userID int64, code string) error {
+	normalized := normalizeAuthTOTPRecoveryCode(code)
+	if len(normalized) != authTOTPRecoveryCodeRunes {
+		return ErrAuthTOTPInvalidCode
+	}
+	res, err := s.db.ExecContext(ctx, `
+		UPDATE auth_totp_recovery SET used_at = ? WHERE user_id = ? AND code_hash = ? AND used_at IS NULL
+	`, s.now(), userID, hashAuthTOTPRecoveryCode(normalized))
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrAuthTOTPInvalidCode
+	}
+	return nil
+}
+
+func (s *DBAuthTOTPService) find(ctx context.Context, userID int64) (*authTOTPRecord, error) {
+	var record authTOTPRecord
+	var enabled int
+	err := s.db.QueryRowContext(ctx, `
+		SELECT secret, enabled, last_used_step, enabled_at FROM auth_totp WHERE user_id = ?
+	`, userID).Scan(&record.secret, &enabled, &record.lastUsedStep, &record.enabledAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrAuthTOTPNotSetup
+	}
+	if err != nil {
+		return nil, err
+	}
+	record.enabled = enabled != 0
+	return &record, nil
+}
+
+// replaceAuthTOTPRecoveryCodes 在事务内删除旧恢复码并写入新生成的恢复码哈希，返回明文。
+func replaceAuthTOTPRecoveryCodes(ctx context.Context, tx *database.Tx, userID int64, now time.Time) ([]string, error) {
+	if _, err := tx.ExecContext(ctx, "DELETE FROM auth_totp_recovery WHERE user_id = ?", userID); err != nil {
+		return nil, err
+	}
+	codes := make([]string, 0, authTOTPRecoveryCodeCount)
+	for i := 0; i < authTOTPRecoveryCodeCount; i++ {
+		code, err := generateAuthTOTPRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO auth_totp_recovery (user_id, code_hash, created_at) VALUES (?, ?, ?)
+		`, userID, hashAuthTOTPRecoveryCode(normalizeAuthTOTPRecoveryCode(code)), now); err != nil {
+			return nil, err
+		}
+		codes = append(codes, code)
+	}
+	return codes, nil
+}
+
+// authTOTPCode 按 RFC 4226/6238 计算 secret 在时间步 step 的验证码。
+func authTOTPCode(secret []byte, step int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", authTOTPDigits, value%authTOTPModulus)
+}
+
+// matchAuthTOTPStep 在允许的时钟偏差内查找与 code 匹配且晚于 lastUsedStep 的时间步。
+func matchAuthTOTPStep(secret string, code string, now time.Time, lastUsedStep int64) (int64, bool) {
+	code = strings.TrimSpace(code)
+	if !isAuthTOTPCode(code) {
+		return 0, false
+	}
+	key, err := authTOTPEncoding.DecodeString(secret)
+	if err != nil {
+		return 0, false
+	}
+	current := now.Unix() / authTOTPPeriod
+	for step := current - authTOTPSkewSteps; step <= current+authTOTPSkewSteps; step++ {
+		if step > lastUsedStep && hmac.Equal([]byte(authTOTPCode(key, step)), []byte(code)) {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+func isAuthTOTPCode(code string) bool {
+	if len(code) != authTOTPDigits {
+		return false
+	}
+	for _, c := range code {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// authTOTPURI 生成验证器 App 识别的 otpauth:// 绑定地址（Key URI Format）。
+func authTOTPURI(issuer string, username string, secret string) string {
+	label := url.PathEscape(username)
+	q := url.Values{}
+	q.Set("secret", secret)
+	if issuer != "" {
+		label = url.PathEscape(issuer) + ":" + label
+		q.Set("issuer", issuer)
+	}
+	q.Set("algorithm", "SHA1")
+	q.Set("digits", fmt.Sprint(authTOTPDigits))
+	q.Set("period", fmt.Sprint(authTOTPPeriod))
+	return "otpauth://totp/" + label + "?" + q.Encode()
+}
+
+// generateAuthTOTPRecoveryCode 生成形如 "ABCDE-FGHIJ" 的恢复码（base32 字符，50 位随机）。
+func generateAuthTOTPRecoveryCode() (string, error) {
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	raw := authTOTPEncoding.EncodeToString(buf)[:authTOTPRecoveryCodeRunes]
+	return raw[:5] + "-" + raw[5:], nil
+}
+
+func normalizeAuthTOTPRecoveryCode(code string) string {
+	return strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(strings.TrimSpace(code)))
+}
+
+func hashAuthTOTPRecoveryCode(normalized string) string {
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:])
+}
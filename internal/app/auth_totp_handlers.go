@@ -0,0 +1,227 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+type authTOTPRequest struct {
+	Password string `json:"password"`
+	TOTPCode string `json:"totpCode"`
+}
+
+// handleAuthTOTPStatus 返回当前账号的两步验证状态。
+func (a *App) handleAuthTOTPStatus(w http.ResponseWriter, r *http.Request) {
+	user, ok := a.currentTOTPAccount(w, r)
+	if !ok {
+		return
+	}
+	status, err := a.authTOTP.Status(r.Context(), user.ID)
+	if err != nil {
+		writeAuthTOTPError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"code": 0, "msg": "success", "data": status})
+}
+
+// handleAuthTOTPSetup 为当前账号生成待验证的 TOTP 密钥，返回密钥、otpauth 地址与二维码；需校验密码，
+// 避免被盗用的 Token 绑定攻击者的验证器。
+func (a *App) handleAuthTOTPSetup(w http.ResponseWriter, r *http.Request) {
+	user, ok := a.currentTOTPAccount(w, r)
+	if !ok {
+		return
+	}
+	var req authTOTPRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"code": 400, "msg": "参数解析失败"})
+		return
+	}
+	if !a.verifyTOTPPassword(w, r, user, req.Password) {
+		return
+	}
+	setup, err := a.authTOTP.Setup(r.Context(), user.ID, user.Username)
+	if err != nil {
+		writeAuthTOTPError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"code": 0, "msg": "success", "data": setup})
+}
+
+// handleAuthTOTPEnable 以验证码确认密钥并启用两步验证，返回一次性恢复码（仅此一次展示）。
+func (a *App) handleAuthTOTPEnable(w http.ResponseWriter, r *http.Request) {
+	user, ok := a.currentTOTPAccount(w, r)
+	if !ok {
+		return
+	}
+	var req authTOTPRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"code": 400, "msg": "参数解析失败"})
+		return
+	}
+	codes, err := a.authTOTP.Enable(r.Context(), user.ID, req.TOTPCode)
+	if err != nil {
+		writeAuthTOTPError(w, err)
+		return
+	}
+	slog.Info("账号已启用两步验证", "username", user.Username)
+	writeJSON(w, http.StatusOK, map[string]any{"code": 0, "msg": "success", "data": map[string]any{"recoveryCodes": codes}})
+}
+
+// handleAuthTOTPDisable 停用当前账号的两步验证，需同时校验密码与验证码（或恢复码）。
+func (a *App) handleAuthTOTPDisable(w http.ResponseWriter, r *http.Request) {
+	user, ok := a.currentTOTPAccount(w, r)
+	if !ok {
+		return
+	}
+	var req authTOTPRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"code": 400, "msg": "参数解析失败"})
+		return
+	}
+	if !a.verifyTOTPPassword(w, r, user, req.Password) {
+		return
+	}
+	if err := a.authTOTP.Verify(r.Context(), user.ID, req.TOTPCode); err != nil {
+		writeAuthTOTPError(w, err)
+		return
+	}
+	if _, err := a.authTOTP.Reset(r.Context(), user.ID); err != nil {
+		writeAuthTOTPError(w, err)
+		return
+	}
+	slog.Warn("账号已停用两步验证", "username", user.Username)
+	writeJSON(w, http.StatusOK, map[string]any{"code": 0, "msg": "success"})
+}
+
+// handleAuthTOTPRecoveryCodes 以验证码换取一组新的恢复码，旧恢复码全部作废。
+func (a *App) handleAuthTOTPRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	user, ok := a.currentTOTPAccount(w, r)
+	if !ok {
+		return
+	}
+	var req authTOTPRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"code": 400, "msg": "参数解析失败"})
+		return
+	}
+	codes, err := a.authTOTP.RegenerateRecoveryCodes(r.Context(), user.ID, req.TOTPCode)
+	if err != nil {
+		writeAuthTOTPError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"code": 0, "msg": "success", "data": map[string]any{"recoveryCodes": codes}})
+}
+
+// handleResetAuthUserTOTP 由管理员清除指定账号的两步验证（用户丢失验证器且恢复码用尽时使用）。
+func (a *App) handleResetAuthUserTOTP(w http.ResponseWriter, r *http.Request) {
+	if a.authTOTP == nil {
+		writeJSON(w, http.StatusOK, map[string]any{"code": -1, "msg": "两步验证服务未初始化"})
+		return
+	}
+
+	var in struct {
+		ID int64 `json:"id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"code": 400, "msg": "参数解析失败"})
+		return
+	}
+	enabled, err := a.authTOTP.Reset(r.Context(), in.ID)
+	if err != nil {
+		writeAuthTOTPError(w, err)
+		return
+	}
+	principal, _ := authPrincipalFromContext(r.Context())
+	slog.Warn("管理员重置账号两步验证", "subject", principal.Subject, "userId", in.ID, "wasEnabled", enabled)
+	writeJSON(w, http.StatusOK, map[string]any{"code": 0, "msg": "success", "data": map[string]any{"wasEnabled": enabled}})
+}
+
+// currentTOTPAccount 返回当前调用者对应的登录账号；共享访问码与 API Key 调用者没有账号，不支持两步验证。
+func (a *App) currentTOTPAccount(w http.ResponseWriter, r *http.Request) (*AuthUser, bool) {
+	if a.authUsers == nil || a.authTOTP == nil {
+		writeJSON(w, http.StatusOK, map[string]any{"code": -1, "msg": "两步验证服务未初始化"})
+		return nil, false
+	}
+	principal, ok := authPrincipalFromContext(r.Context())
+	if !ok || !principal.Account {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"code": 400, "msg": "仅账号登录支持两步验证"})
+		return nil, false
+	}
+	user, err := a.authUsers.Lookup(r.Context(), principal.Subject)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]any{"code": -1, "msg": "查询账号失败: " + err.Error()})
+		return nil, false
+	}
+	if user == nil {
+		writeJSON(w, http.StatusOK, map[string]any{"code": -1, "msg": ErrAuthUserNotFound.Error()})
+		return nil, false
+	}
+	return user, true
+}
+
+// verifyTOTPPassword 校验当前账号的密码；未通过时写入响应并返回 false。
+func (a *App) verifyTOTPPassword(w http.ResponseWriter, r *http.Request, user *AuthUser, password string) bool {
+	if _, err := a.authUsers.Authenticate(r.Context(), user.Username, password); err != nil {
+		if errors.Is(err, ErrAuthInvalidLogin) || errors.Is(err, ErrAuthUserDisabled) {
+			writeJSON(w, http.StatusBadRequest, map[string]any{"code": 400, "msg": "密码错误"})
+			return false
+		}
+		writeAuthTOTPError(w, err)
+		return false
+	}
+	return true
+}
+
+// verifyLoginTOTP 在账号启用两步验证时校验登录请求中的 totpCode；未通过时写入响应并返回 false。
+// 未提交验证码时返回 totpRequired 提示客户端补充输入，不计入登录失败。
+func (a *App) verifyLoginTOTP(w http.ResponseWriter, r *http.Request, user *AuthUser) bool {
+	if a.authTOTP == nil {
+		return true
+	}
+	enabled, err := a.authTOTP.Enabled(r.Context(), user.ID)
+	if err != nil {
+		slog.Error("查询两步验证状态失败", "username", user.Username, "error", err)
+		a.loginGuard.Release(r.Context(), a.loginClientIP(r))
+		writeJSON(w, http.StatusInternalServerError, map[string]any{"code": -1, "msg": "登录失败"})
+		return false
+	}
+	if !enabled {
+		return true
+	}
+
+	code := strings.TrimSpace(r.FormValue("totpCode"))
+	if code == "" {
+		a.loginGuard.Release(r.Context(), a.loginClientIP(r))
+		writeJSON(w, http.StatusOK, map[string]any{"code": -1, "msg": "请输入两步验证码", "totpRequired": true})
+		return false
+	}
+	if err := a.authTOTP.Verify(r.Context(), user.ID, code); err != nil {
+		if errors.Is(err, ErrAuthTOTPInvalidCode) {
+			slog.Warn("两步验证失败", "username", user.Username, "remote", r.RemoteAddr)
+			a.recordLoginFailure(r, LoginMethodAccount, user.Username, err.Error())
+			writeJSON(w, http.StatusBadRequest, map[string]any{"code": -1, "msg": err.Error(), "totpRequired": true})
+			return false
+		}
+		slog.Error("两步验证失败", "username", user.Username, "error", err)
+		a.loginGuard.Release(r.Context(), a.loginClientIP(r))
+		writeJSON(w, http.StatusInternalServerError, map[string]any{"code": -1, "msg": "登录失败"})
+		return false
+	}
+	return true
+}
+
+func writeAuthTOTPError(w http.ResponseWriter, err error) {
+	switch {
+	case isBadRequestError(err),
+		errors.Is(err, ErrAuthTOTPInvalidCode),
+		errors.Is(err, ErrAuthTOTPNotSetup),
+		errors.Is(err, ErrAuthTOTPNotEnabled),
+		errors.Is(err, ErrAuthTOTPAlreadyEnabled):
+		writeJSON(w, http.StatusBadRequest, map[string]any{"code": 400, "msg": err.Error()})
+	default:
+		writeJSON(w, http.StatusInternalServerError, map[string]any{"code": -1, "msg": "两步验证操作失败: " + err.Error()})
+	}
+}
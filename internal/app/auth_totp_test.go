@@ -0,0 +1,383 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+
+	"liao/internal/config"
+	"liao/internal/database"
+)
+
+// authTOTPTestSecret 为 RFC 6238 附录 B 的 SHA1 测试密钥 "12345678901234567890"。
+var authTOTPTestSecret = authTOTPEncoding.EncodeToString([]byte("12345678901234567890"))
+
+var authTOTPTestNow = time.Unix(1111111109, 0)
+
+func authTOTPTestRows(enabled int, lastUsedStep int64) *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"secret", "enabled", "last_used_step", "enabled_at"}).
+		AddRow(authTOTPTestSecret, enabled, lastUsedStep, nil)
+}
+
+func authTOTPTestCode(offset int64) string {
+	key, _ := authTOTPEncoding.DecodeString(authTOTPTestSecret)
+	return authTOTPCode(key, authTOTPTestNow.Unix()/authTOTPPeriod+offset)
+}
+
+func TestAuthTOTPCode_RFC6238Vectors(t *testing.T) {
+	key := []byte("12345678901234567890")
+	// RFC 6238 附录 B 给出 8 位结果，6 位验证码取其后 6 位。
+	cases := map[int64]string{59: "287082", 1111111109: "081804", 1234567890: "005924", 2000000000: "279037"}
+	for ts, want := range cases {
+		if got := authTOTPCode(key, ts/authTOTPPeriod); got != want {
+			t.Fatalf("t=%d: code=%s, want %s", ts, got, want)
+		}
+	}
+
+	step := authTOTPTestNow.Unix() / authTOTPPeriod
+	if got, ok := matchAuthTOTPStep(authTOTPTestSecret, " 081804 ", authTOTPTestNow, 0); !ok || got != step {
+		t.Fatalf("step=%d ok=%v", got, ok)
+	}
+	if got, ok := matchAuthTOTPStep(authTOTPTestSecret, authTOTPTestCode(-1), authTOTPTestNow, 0); !ok || got != step-1 {
+		t.Fatalf("expected previous step accepted, step=%d ok=%v", got, ok)
+	}
+	if _, ok := matchAuthTOTPStep(authTOTPTestSecret, authTOTPTestCode(-2), authTOTPTestNow, 0); ok {
+		t.Fatalf("expected code outside skew rejected")
+	}
+	if _, ok := matchAuthTOTPStep(authTOTPTestSecret, "081804", authTOTPTestNow, step); ok {
+		t.Fatalf("expected used step rejected")
+	}
+	if _, ok := matchAuthTOTPStep(authTOTPTestSecret, "08180x", authTOTPTestNow, 0); ok {
+		t.Fatalf("expected non-numeric code rejected")
+	}
+}
+
+func TestAuthTOTPURIAndRecoveryCode(t *testing.T) {
+	uri := authTOTPURI("Liao Home", "alice@example.com", "JBSWY3DP")
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "otpauth" || u.Host != "totp" || u.Path != "/Liao Home:alice@example.com" {
+		t.Fatalf("uri=%s err=%v", uri, err)
+	}
+	if q := u.Query(); q.Get("secret") != "JBSWY3DP" || q.Get("issuer") != "Liao Home" || q.Get("digits") != "6" || q.Get("period") != "30" {
+		t.Fatalf("query=%v", q)
+	}
+
+	code, err := generateAuthTOTPRecoveryCode()
+	if err != nil || len(code) != 11 || code[5] != '-' {
+		t.Fatalf("code=%q err=%v", code, err)
+	}
+	if normalizeAuthTOTPRecoveryCode(" "+strings.ToLower(code)+" ") != strings.ReplaceAll(code, "-", "") {
+		t.Fatalf("normalize mismatch for %q", code)
+	}
+}
+
+func TestDBAuthTOTPService_SetupEnableVerify(t *testing.T) {
+	if NewDBAuthTOTPService(nil, "Liao") != nil {
+		t.Fatalf("expected nil service for nil db")
+	}
+	svc, mock := newMockDBService(t, func(db *database.DB) *DBAuthTOTPService { return NewDBAuthTOTPService(db, "Liao") })
+	svc.now = func() time.Time { return authTOTPTestNow }
+	ctx := context.Background()
+	step := authTOTPTestNow.Unix() / authTOTPPeriod
+
+	// 首次生成：无待验证记录时插入。
+	mock.ExpectExec(`UPDATE auth_totp SET secret = \?, last_used_step = 0, updated_at = \? WHERE user_id = \? AND enabled = 0`).
+		WithArgs(sqlmock.AnyArg(), authTOTPTestNow, int64(7)).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`INSERT INTO auth_totp \(user_id, secret, enabled, last_used_step, created_at, updated_at\)`).
+		WithArgs(int64(7), sqlmock.AnyArg(), authTOTPTestNow, authTOTPTestNow).WillReturnResult(sqlmock.NewResult(1, 1))
+	setup, err := svc.Setup(ctx, 7, "alice")
+	if err != nil {
+		t.Fatalf("Setup: %v", err)
+	}
+	if len(setup.Secret) != 32 || !strings.Contains(setup.OTPAuthURL, "secret="+setup.Secret) || !strings.HasPrefix(setup.OTPAuthURL, "otpauth://totp/Liao:alice?") {
+		t.Fatalf("setup=%+v", setup)
+	}
+	png, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(setup.QRCode, "data:image/png;base64,"))
+	if err != nil || !bytes.HasPrefix(png, []byte("\x89PNG")) {
+		t.Fatalf("qrCode is not a PNG data URL: err=%v", err)
+	}
+
+	// 已启用时不能重新生成。
+	mock.ExpectExec(`UPDATE auth_totp SET secret = \?`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`INSERT INTO auth_totp`).WillReturnError(duplicateKeyErr())
+	if _, err := svc.Setup(ctx, 7, "alice"); !errors.Is(err, ErrAuthTOTPAlreadyEnabled) {
+		t.Fatalf("err=%v", err)
+	}
+
+	// 启用：校验验证码后在事务内写入 10 个恢复码。
+	mock.ExpectQuery(`SELECT secret, enabled, last_used_step, enabled_at FROM auth_totp WHERE user_id = \?`).WithArgs(int64(7)).
+		WillReturnRows(authTOTPTestRows(0, 0))
+	if _, err := svc.Enable(ctx, 7, "000000"); !errors.Is(err, ErrAuthTOTPInvalidCode) {
+		t.Fatalf("err=%v", err)
+	}
+	mock.ExpectQuery(`FROM auth_totp WHERE user_id = \?`).WithArgs(int64(7)).WillReturnRows(authTOTPTestRows(0, 0))
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE auth_totp SET enabled = 1, enabled_at = \?, last_used_step = \?, updated_at = \? WHERE user_id = \? AND enabled = 0`).
+		WithArgs(authTOTPTestNow, step, authTOTPTestNow, int64(7)).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`DELETE FROM auth_totp_recovery WHERE user_id = \?`).WithArgs(int64(7)).WillReturnResult(sqlmock.NewResult(0, 0))
+	for i := 0; i < authTOTPRecoveryCodeCount; i++ {
+		mock.ExpectExec(`INSERT INTO auth_totp_recovery \(user_id, code_hash, created_at\)`).
+			WithArgs(int64(7), sqlmock.AnyArg(), authTOTPTestNow).WillReturnResult(sqlmock.NewResult(int64(i+1), 1))
+	}
+	mock.ExpectCommit()
+	codes, err := svc.Enable(ctx, 7, authTOTPTestCode(0))
+	if err != nil || len(codes) != authTOTPRecoveryCodeCount {
+		t.Fatalf("codes=%v err=%v", codes, err)
+	}
+	// 启用后直接刷新“已有账号启用”缓存，无需查库。
+	if enabled, err := svc.AnyEnabled(ctx); err != nil || !enabled {
+		t.Fatalf("AnyEnabled: enabled=%v err=%v", enabled, err)
+	}
+
+	// 登录校验：同一时间步只能使用一次。
+	mock.ExpectQuery(`FROM auth_totp WHERE user_id = \?`).WithArgs(int64(7)).WillReturnRows(authTOTPTestRows(1, step-1))
+	mock.ExpectExec(`UPDATE auth_totp SET last_used_step = \? WHERE user_id = \? AND enabled = 1 AND last_used_step < \?`).
+		WithArgs(step, int64(7), step).WillReturnResult(sqlmock.NewResult(0, 1))
+	if err := svc.Verify(ctx, 7, authTOTPTestCode(0)); err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	mock.ExpectQuery(`FROM auth_totp WHERE user_id = \?`).WithArgs(int64(7)).WillReturnRows(authTOTPTestRows(1, step))
+	if err := svc.Verify(ctx, 7, authTOTPTestCode(0)); !errors.Is(err, ErrAuthTOTPInvalidCode) {
+		t.Fatalf("expected replay rejected, err=%v", err)
+	}
+	// 并发请求抢先使用了同一验证码。
+	mock.ExpectQuery(`FROM auth_totp WHERE user_id = \?`).WithArgs(int64(7)).WillReturnRows(authTOTPTestRows(1, step-1))
+	mock.ExpectExec(`UPDATE auth_totp SET last_used_step = \?`).WillReturnResult(sqlmock.NewResult(0, 0))
+	if err := svc.Verify(ctx, 7, authTOTPTestCode(0)); !errors.Is(err, ErrAuthTOTPInvalidCode) {
+		t.Fatalf("expected lost race rejected, err=%v", err)
+	}
+
+	// 恢复码：规范化后按哈希匹配，仅能使用一次。
+	mock.ExpectExec(`UPDATE auth_totp_recovery SET used_at = \? WHERE user_id = \? AND code_hash = \? AND used_at IS NULL`).
+		WithArgs(authTOTPTestNow, int64(7), hashAuthTOTPRecoveryCode(normalizeAuthTOTPRecoveryCode(codes[0]))).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	if err := svc.Verify(ctx, 7, strings.ToLower(codes[0])); err != nil {
+		t.Fatalf("Verify recovery: %v", err)
+	}
+	mock.ExpectExec(`UPDATE auth_totp_recovery SET used_at = \?`).WillReturnResult(sqlmock.NewResult(0, 0))
+	if err := svc.Verify(ctx, 7, codes[0]); !errors.Is(err, ErrAuthTOTPInvalidCode) {
+		t.Fatalf("expected used recovery code rejected, err=%v", err)
+	}
+	if err := svc.Verify(ctx, 7, "short"); !errors.Is(err, ErrAuthTOTPInvalidCode) {
+		t.Fatalf("err=%v", err)
+	}
+
+	mock.ExpectQuery(`FROM auth_totp WHERE user_id = \?`).WithArgs(int64(7)).WillReturnRows(authTOTPTestRows(1, 0))
+	mock.ExpectQuery(`SELECT COUNT\(\*\) FROM auth_totp_recovery WHERE user_id = \? AND used_at IS NULL`).WithArgs(int64(7)).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(9))
+	if status, err := svc.Status(ctx, 7); err != nil || !status.Enabled || status.Pending || status.RecoveryCodesLeft != 9 {
+		t.Fatalf("status=%+v err=%v", status, err)
+	}
+
+	mock.ExpectQuery(`FROM auth_totp WHERE user_id = \?`).WithArgs(int64(7)).WillReturnRows(authTOTPTestRows(1, 0))
+	mock.ExpectBegin()
+	mock.ExpectExec(`DELETE FROM auth_totp_recovery WHERE user_id = \?`).WithArgs(int64(7)).WillReturnResult(sqlmock.NewResult(0, 9))
+	mock.ExpectExec(`DELETE FROM auth_totp WHERE user_id = \?`).WithArgs(int64(7)).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	if enabled, err := svc.Reset(ctx, 7); err != nil || !enabled {
+		t.Fatalf("Reset: enabled=%v err=%v", enabled, err)
+	}
+	// 重置后缓存失效，重新查库。
+	mock.ExpectQuery(`SELECT COUNT\(\*\) FROM auth_totp WHERE enabled = 1`).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	if enabled, err := svc.AnyEnabled(ctx); err != nil || enabled {
+		t.Fatalf("AnyEnabled after reset: enabled=%v err=%v", enabled, err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("expectations: %v", err)
+	}
+}
+
+func TestHandleAuthLogin_TOTP(t *testing.T) {
+	lowerAuthPasswordIterations(t)
+	users, userMock := newMockDBService(t, NewDBAuthUserService)
+	totp, totpMock := newMockDBService(t, func(db *database.DB) *DBAuthTOTPService { return NewDBAuthTOTPService(db, "Liao") })
+	totp.now = func() time.Time { return authTOTPTestNow }
+	a := &App{
+		cfg:       config.Config{AuthAccessCodeDisabled: true},
+		jwt:       NewJWTService("secret-1", 1),
+		authUsers: users,
+		authTOTP:  totp,
+	}
+	hash, _ := hashAuthPassword("password-1")
+	step := authTOTPTestNow.Unix() / authTOTPPeriod
+
+	login := func(code string) *httptest.ResponseRecorder {
+		form := url.Values{"username": {"alice"}, "password": {"password-1"}}
+		if code != "" {
+			form.Set("totpCode", code)
+		}
+		userMock.ExpectQuery(`SELECT .* FROM auth_user WHERE username = \?`).WithArgs("alice").
+			WillReturnRows(authUserTestRows(7, "alice", hash, AuthRoleOperator, 0))
+		userMock.ExpectExec(`UPDATE auth_user SET last_login_at = \? WHERE id = \?`).WillReturnResult(sqlmock.NewResult(0, 1))
+		rr := httptest.NewRecorder()
+		a.handleAuthLogin(rr, newURLEncodedRequest(t, http.MethodPost, "http://example.com/api/auth/login", form))
+		return rr
+	}
+
+	// 缺少验证码：提示客户端补充输入，不签发 Token。
+	totpMock.ExpectQuery(`FROM auth_totp WHERE user_id = \?`).WithArgs(int64(7)).WillReturnRows(authTOTPTestRows(1, 0))
+	rr := login("")
+	body := decodeJSONBody(t, rr.Body)
+	if rr.Code != http.StatusOK || body["code"] != float64(-1) || body["totpRequired"] != true || body["token"] != nil {
+		t.Fatalf("status=%d body=%v", rr.Code, body)
+	}
+
+	totpMock.ExpectQuery(`FROM auth_totp WHERE user_id = \?`).WithArgs(int64(7)).WillReturnRows(authTOTPTestRows(1, 0))
+	totpMock.ExpectQuery(`FROM auth_totp WHERE user_id = \?`).WithArgs(int64(7)).WillReturnRows(authTOTPTestRows(1, 0))
+	rr = login("000000")
+	if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), "两步验证码错误") {
+		t.Fatalf("status=%d body=%s", rr.Code, rr.Body.String())
+	}
+
+	totpMock.ExpectQuery(`FROM auth_totp WHERE user_id = \?`).WithArgs(int64(7)).WillReturnRows(authTOTPTestRows(1, 0))
+	totpMock.ExpectQuery(`FROM auth_totp WHERE user_id = \?`).WithArgs(int64(7)).WillReturnRows(authTOTPTestRows(1, 0))
+	totpMock.ExpectExec(`UPDATE auth_totp SET last_used_step = \?`).WithArgs(step, int64(7), step).WillReturnResult(sqlmock.NewResult(0, 1))
+	rr = login(authTOTPTestCode(0))
+	if body := decodeJSONBody(t, rr.Body); rr.Code != http.StatusOK || body["code"] != float64(0) || body["token"] == nil {
+		t.Fatalf("status=%d body=%v", rr.Code, body)
+	}
+
+	// 未启用两步验证的账号不受影响。
+	totpMock.ExpectQuery(`FROM auth_totp WHERE user_id = \?`).WithArgs(int64(7)).WillReturnRows(authTOTPTestRows(0, 0))
+	if rr = login(""); rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `"token"`) {
+		t.Fatalf("status=%d body=%s", rr.Code, rr.Body.String())
+	}
+
+	if err := totpMock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("expectations: %v", err)
+	}
+}
+
+func TestAccessCodeLoginDisabledByTOTP(t *testing.T) {
+	totp, mock := newMockDBService(t, func(db *database.DB) *DBAuthTOTPService { return NewDBAuthTOTPService(db, "Liao") })
+	jwtService := NewJWTService("secret-1", 1)
+	a := &App{cfg: config.Config{AuthAccessCode: "code-1"}, jwt: jwtService, authTOTP: totp}
+	legacy, _ := jwtService.GenerateToken()
+	ctx := context.Background()
+
+	// 尚无账号启用两步验证：访问码可用；未启用的结果不缓存，每次查库。
+	mock.ExpectQuery(`SELECT COUNT\(\*\) FROM auth_totp WHERE enabled = 1`).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	if _, ok := a.authenticateToken(ctx, legacy); !ok {
+		t.Fatalf("expected access code token accepted")
+	}
+
+	// 其他副本上有账号启用两步验证：下次判断立即查库，访问码登录与已签发的访问码 Token 一并失效。
+	mock.ExpectQuery(`SELECT COUNT\(\*\) FROM auth_totp WHERE enabled = 1`).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	rr := httptest.NewRecorder()
+	a.handleAuthLogin(rr, newURLEncodedRequest(t, http.MethodPost, "http://example.com/api/auth/login", url.Values{"accessCode": {"code-1"}}))
+	if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), "访问码登录已关闭") {
+		t.Fatalf("status=%d body=%s", rr.Code, rr.Body.String())
+	}
+	// 启用结果被缓存，不再查库。
+	if _, ok := a.authenticateToken(ctx, legacy); ok {
+		t.Fatalf("expected access code token rejected")
+	}
+
+	// 显式允许时不查询两步验证状态。
+	a.cfg.AuthAccessCodeAllowWithTOTP = true
+	if _, ok := a.authenticateToken(ctx, legacy); !ok {
+		t.Fatalf("expected access code token accepted when allowed")
+	}
+
+	// 查询失败时按关闭处理。
+	a.cfg.AuthAccessCodeAllowWithTOTP = false
+	totp.setAnyEnabled(false)
+	mock.ExpectQuery(`SELECT COUNT\(\*\) FROM auth_totp WHERE enabled = 1`).WillReturnError(errors.New("db down"))
+	if _, ok := a.authenticateToken(ctx, legacy); ok {
+		t.Fatalf("expected access code token rejected on query error")
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("expectations: %v", err)
+	}
+}
+
+func TestAuthTOTPHandlers(t *testing.T) {
+	rr := httptest.NewRecorder()
+	(&App{}).handleAuthTOTPStatus(rr, httptest.NewRequest(http.MethodGet, "/api/auth/totp/status", nil))
+	if body := decodeJSONBody(t, rr.Body); body["code"] != float64(-1) {
+		t.Fatalf("body=%v", body)
+	}
+
+	lowerAuthPasswordIterations(t)
+	users, userMock := newMockDBService(t, NewDBAuthUserService)
+	totp, totpMock := newMockDBService(t, func(db *database.DB) *DBAuthTOTPService { return NewDBAuthTOTPService(db, "Liao") })
+	totp.now = func() time.Time { return authTOTPTestNow }
+	a := &App{authUsers: users, authTOTP: totp}
+
+	// 共享访问码登录没有账号，不支持两步验证。
+	req := httptest.NewRequest(http.MethodPost, "/api/auth/totp/setup", nil)
+	req = req.WithContext(withAuthPrincipal(req.Context(), AuthPrincipal{Subject: authLegacySubject, Role: AuthRoleAdmin}))
+	rr = httptest.NewRecorder()
+	a.handleAuthTOTPSetup(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("status=%d body=%s", rr.Code, rr.Body.String())
+	}
+
+	accountReq := func(path, body string) *http.Request {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		return req.WithContext(withAuthPrincipal(req.Context(), AuthPrincipal{Subject: "alice", Role: AuthRoleViewer, Account: true}))
+	}
+	hash, _ := hashAuthPassword("password-1")
+	userMock.ExpectQuery(`SELECT .* FROM auth_user WHERE username = \?`).WithArgs("alice").
+		WillReturnRows(authUserTestRows(7, "alice", hash, AuthRoleViewer, 0))
+
+	// 生成密钥同样需校验密码，避免被盗用的 Token 绑定攻击者的验证器。
+	userMock.ExpectQuery(`SELECT .* FROM auth_user WHERE username = \?`).WithArgs("alice").
+		WillReturnRows(authUserTestRows(7, "alice", hash, AuthRoleViewer, 0))
+	rr = httptest.NewRecorder()
+	a.handleAuthTOTPSetup(rr, accountReq("/api/auth/totp/setup", `{}`))
+	if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), "密码错误") {
+		t.Fatalf("status=%d body=%s", rr.Code, rr.Body.String())
+	}
+
+	userMock.ExpectQuery(`SELECT .* FROM auth_user WHERE username = \?`).WithArgs("alice").
+		WillReturnRows(authUserTestRows(7, "alice", hash, AuthRoleViewer, 0))
+	userMock.ExpectExec(`UPDATE auth_user SET last_login_at`).WillReturnResult(sqlmock.NewResult(0, 1))
+	totpMock.ExpectExec(`UPDATE auth_totp SET secret = \?`).WillReturnResult(sqlmock.NewResult(0, 1))
+	rr = httptest.NewRecorder()
+	a.handleAuthTOTPSetup(rr, accountReq("/api/auth/totp/setup", `{"password":"password-1"}`))
+	data, _ := decodeJSONBody(t, rr.Body)["data"].(map[string]any)
+	if rr.Code != http.StatusOK || data["secret"] == "" || !strings.HasPrefix(data["qrCode"].(string), "data:image/png;base64,") {
+		t.Fatalf("status=%d data=%v", rr.Code, data)
+	}
+
+	// 停用需校验密码（账号已缓存，无需再次查询）。
+	rr = httptest.NewRecorder()
+	userMock.ExpectQuery(`SELECT .* FROM auth_user WHERE username = \?`).WithArgs("alice").
+		WillReturnRows(authUserTestRows(7, "alice", "", AuthRoleViewer, 0))
+	a.handleAuthTOTPDisable(rr, accountReq("/api/auth/totp/disable", `{"password":"wrong-pass","totpCode":"123456"}`))
+	if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), "密码错误") {
+		t.Fatalf("status=%d body=%s", rr.Code, rr.Body.String())
+	}
+
+	rr = httptest.NewRecorder()
+	a.handleResetAuthUserTOTP(rr, httptest.NewRequest(http.MethodPost, "/api/authUser/resetTotp", strings.NewReader(`{"id":0}`)))
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("status=%d body=%s", rr.Code, rr.Body.String())
+	}
+	totpMock.ExpectQuery(`FROM auth_totp WHERE user_id = \?`).WithArgs(int64(7)).WillReturnRows(authTOTPTestRows(1, 0))
+	totpMock.ExpectBegin()
+	totpMock.ExpectExec(`DELETE FROM auth_totp_recovery`).WillReturnResult(sqlmock.NewResult(0, 10))
+	totpMock.ExpectExec(`DELETE FROM auth_totp WHERE`).WillReturnResult(sqlmock.NewResult(0, 1))
+	totpMock.ExpectCommit()
+	rr = httptest.NewRecorder()
+	a.handleResetAuthUserTOTP(rr, httptest.NewRequest(http.MethodPost, "/api/authUser/resetTotp", strings.NewReader(`{"id":7}`)))
+	if body := decodeJSONBody(t, rr.Body); body["code"] != float64(0) || body["data"].(map[string]any)["wasEnabled"] != true {
+		t.Fatalf("body=%v", body)
+	}
+
+	if err := totpMock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("expectations: %v", err)
+	}
+}
@@ -0,0 +1,470 @@
+package app
+
+import (
+	"context"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"liao/internal/database"
+)
+
+const (
+	AuthRoleAdmin    = "admin"
+	AuthRoleOperator = "operator"
+	AuthRoleViewer   = "viewer"
+
+	// authLegacySubject 为共享访问码登录签发的 Token 主体（与 Spring 侧 sub=user 兼容）。
+	authLegacySubject = "user"
+
+	authPasswordScheme    = "pbkdf2-sha256"
+	authPasswordSaltBytes = 16
+	authPasswordKeyBytes  = 32
+	authPasswordMinRunes  = 8
+	authPasswordMaxRunes  = 128
+)
+
+var (
+	// authPasswordIterations 为 PBKDF2 迭代次数，已存哈希自带迭代次数，调整后不影响旧密码校验。
+	authPasswordIterations = 310000
+	// authUserCacheTTL 为中间件按用户名查询账号状态的本地缓存时长，账号变更时立即失效。
+	authUserCacheTTL = 30 * time.Second
+
+	authUsernamePattern = regexp.MustCompile(`^[A-Za-z0-9_.@-]{2,64}$`)
+
+	ErrAuthUserNotFound     = errors.New("账号不存在")
+	ErrAuthUserExists       = errors.New("用户名已存在")
+	ErrAuthInvalidLogin     = errors.New("用户名或密码错误")
+	ErrAuthUserDisabled     = errors.New("账号已停用")
+	ErrAuthLastAdminRemoval = errors.New("至少需要保留一个启用的管理员账号")
+)
+
+var authRoleRanks = map[string]int{
+	AuthRoleViewer:   1,
+	AuthRoleOperator: 2,
+	AuthRoleAdmin:    3,
+}
+
+// AuthPrincipal 为已鉴权请求的调用者：账号登录时 Subject 为用户名，共享访问码登录时为 "user"。
+type AuthPrincipal struct {
+	Subject string `json:"subject"`
+	Role    string `json:"role"`
+	Account bool   `json:"account"`
+	// SessionID 为服务端登录会话 ID（未启用会话存储时为空）。
+	SessionID string `json:"sessionId,omitempty"`
+	// APIKeyID 非零时表示调用者以 API Key 鉴权，仅可访问 Scopes 覆盖的接口。
+	APIKeyID int64    `json:"apiKeyId,omitempty"`
+	Scopes   []string `json:"scopes,omitempty"`
+}
+
+// Allows 判断调用者角色是否不低于 role（admin > operator > viewer）。
+func (p AuthPrincipal) Allows(role string) bool {
+	need, ok := authRoleRanks[role]
+	return ok && authRoleRanks[p.Role] >= need
+}
+
+func isValidAuthRole(role string) bool {
+	_, ok := authRoleRanks[role]
+	return ok
+}
+
+// AuthUser 为一个登录账号。
+type AuthUser struct {
+	ID            int64  `json:"id"`
+	Username      string `json:"username"`
+	Role          string `json:"role"`
+	Disabled      bool   `json:"disabled"`
+	LastLoginTime string `json:"lastLoginTime,omitempty"`
+	CreateTime    string `json:"createTime"`
+	UpdateTime    string `json:"updateTime"`
+
+	passwordHash string
+}
+
+// AuthUserUpdate 为账号修改内容；nil 字段保持不变。
+type AuthUserUpdate struct {
+	Role     *string
+	Disabled *bool
+	Password *string
+}
+
+type authUserCacheEntry struct {
+	user     *AuthUser
+	loadedAt time.Time
+}
+
+// DBAuthUserService 基于数据库管理登录账号。
+type DBAuthUserService struct {
+	db *database.DB
+
+	mu    sync.Mutex
+	cache map[string]authUserCacheEntry
+	// onCredentialChange 在账号改密、停用或删除后调用，用于吊销该账号已签发的会话。
+	onCredentialChange func(ctx context.Context, username string)
+}
+
+// NewDBAuthUserService 创建数据库账号服务。
+func NewDBAuthUserService(db *database.DB) *DBAuthUserService {
+	if db == nil {
+		return nil
+	}
+	return &DBAuthUserService{db: db, cache: make(map[string]authUserCacheEntry)}
+}
+
+// SetOnCredentialChange 设置账号改密、停用或删除后的回调。
+func (s *DBAuthUserService) SetOnCredentialChange(fn func(ctx context.Context, username string)) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.onCredentialChange = fn
+	s.mu.Unlock()
+}
+
+// EnsureBootstrapAdmin 在尚无任何账号时创建初始管理员；username 或 password 为空时跳过。
+func (s *DBAuthUserService) EnsureBootstrapAdmin(ctx context.Context, username string, password string) (bool, error) {
+	if s == nil || s.db == nil {
+		return false, fmt.Errorf("db not initialized")
+	}
+	if strings.TrimSpace(username) == "" || password == "" {
+		return false, nil
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	var count int
+	if err := s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM auth_user").Scan(&count); err != nil {
+		return false, err
+	}
+	if count > 0 {
+		return false, nil
+	}
+	if _, err := s.Create(ctx, username, password, AuthRoleAdmin); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (s *DBAuthUserService) Create(ctx context.Context, username string, password string, role string) (*AuthUser, error) {
+	if s == nil || s.db == nil {
+		return nil, fmt.Errorf("db not initialized")
+	}
+	username = strings.TrimSpace(username)
+	if !authUsernamePattern.MatchString(username) {
+		return nil, errBadRequest("用户名需为2-64位字母、数字或 _.@-")
+	}
+	if !isValidAuthRole(role) {
+		return nil, errBadRequest("角色仅支持 admin/operator/viewer")
+	}
+	hash, err := hashAuthPassword(password)
+	if err != nil {
+		return nil, err
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	now := time.Now()
+	id, err := database.InsertReturningID(ctx, s.db, `
+		INSERT INTO auth_user (username, password_hash, role, disabled, created_at, updated_at)
+		VALUES (?, ?, ?, 0, ?, ?)
+	`, username, hash, role, now, now)
+	if err != nil {
+		if s.db.Dialect().IsDuplicateKey(err) {
+			return nil, ErrAuthUserExists
+		}
+		return nil, err
+	}
+	s.invalidate(username)
+	return s.findByID(ctx, id)
+}
+
+func (s *DBAuthUserService) List(ctx context.Context) ([]AuthUser, error) {
+	if s == nil || s.db == nil {
+		return nil, fmt.Errorf("db not initialized")
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	rows, err := s.db.QueryContext(ctx, `SELECT `+authUserColumns+` FROM auth_user ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]AuthUser, 0)
+	for rows.Next() {
+		user, err := scanAuthUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, *user)
+	}
+	return out, rows.Err()
+}
+
+// Update 修改账号角色、停用状态或密码；不允许移除最后一个启用的管理员。
+func (s *DBAuthUserService) Update(ctx context.Context, id int64, update AuthUserUpdate) (*AuthUser, error) {
+	if s == nil || s.db == nil {
+		return nil, fmt.Errorf("db not initialized")
+	}
+	if id <= 0 {
+		return nil, errBadRequest("id 参数非法")
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	current, err := s.findByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	role, disabled, hash := current.Role, current.Disabled, current.passwordHash
+	if update.Role != nil {
+		if !isValidAuthRole(*update.Role) {
+			return nil, errBadRequest("角色仅支持 admin/operator/viewer")
+		}
+		role = *update.Role
+	}
+	if update.Disabled != nil {
+		disabled = *update.Disabled
+	}
+	if update.Password != nil {
+		if hash, err = hashAuthPassword(*update.Password); err != nil {
+			return nil, err
+		}
+	}
+	if current.Role == AuthRoleAdmin && !current.Disabled && (role != AuthRoleAdmin || disabled) {
+		if err := s.ensureOtherActiveAdmin(ctx, id); err != nil {
+			return nil, err
+		}
+	}
+
+	if _, err := s.db.ExecContext(ctx, `
+		UPDATE auth_user SET role = ?, disabled = ?, password_hash = ?, updated_at = ? WHERE id = ?
+	`, role, boolToInt(disabled), hash, time.Now(), id); err != nil {
+		return nil, err
+	}
+	s.invalidate(current.Username)
+	if update.Password != nil || (disabled && !current.Disabled) {
+		s.credentialChanged(ctx, current.Username)
+	}
+	return s.findByID(ctx, id)
+}
+
+func (s *DBAuthUserService) Delete(ctx context.Context, id int64) error {
+	if s == nil || s.db == nil {
+		return fmt.Errorf("db not initialized")
+	}
+	if id <= 0 {
+		return errBadRequest("id 参数非法")
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	current, err := s.findByID(ctx, id)
+	if err != nil {
+		return err
+	}
+	if current.Role == AuthRoleAdmin && !current.Disabled {
+		if err := s.ensureOtherActiveAdmin(ctx, id); err != nil {
+			return err
+		}
+	}
+	if _, err := s.db.ExecContext(ctx, "DELETE FROM auth_user WHERE id = ?", id); err != nil {
+		return err
+	}
+	s.invalidate(current.Username)
+	s.credentialChanged(ctx, current.Username)
+	return nil
+}
+
+// Authenticate 校验用户名与密码，成功时记录最近登录时间并返回账号。
+func (s *DBAuthUserService) Authenticate(ctx context.Context, username string, password string) (*AuthUser, error) {
+	if s == nil || s.db == nil {
+		return nil, fmt.Errorf("db not initialized")
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	user, err := s.findByUsername(ctx, strings.TrimSpace(username))
+	if errors.Is(err, ErrAuthUserNotFound) {
+		// 仍计算一次哈希，避免通过响应时间探测用户名是否存在。
+		_ = verifyAuthPassword(password, "")
+		return nil, ErrAuthInvalidLogin
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !verifyAuthPassword(password, user.passwordHash) {
+		return nil, ErrAuthInvalidLogin
+	}
+	if user.Disabled {
+		return nil, ErrAuthUserDisabled
+	}
+	if _, err := s.db.ExecContext(ctx, "UPDATE auth_user SET last_login_at = ? WHERE id = ?", time.Now(), user.ID); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// Lookup 返回用户名对应的账号（带短时缓存），不存在时返回 nil。
+func (s *DBAuthUserService) Lookup(ctx context.Context, username string) (*AuthUser, error) {
+	if s == nil || s.db == nil {
+		return nil, fmt.Errorf("db not initialized")
+	}
+	s.mu.Lock()
+	entry, ok := s.cache[username]
+	s.mu.Unlock()
+	if ok && time.Since(entry.loadedAt) < authUserCacheTTL {
+		return entry.user, nil
+	}
+
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	user, err := s.findByUsername(ctx, username)
+	if errors.Is(err, ErrAuthUserNotFound) {
+		user, err = nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	s.cache[username] = authUserCacheEntry{user: user, loadedAt: time.Now()}
+	s.mu.Unlock()
+	return user, nil
+}
+
+func (s *DBAuthUserService) invalidate(username string) {
+	s.mu.Lock()
+	delete(s.cache, username)
+	s.mu.Unlock()
+}
+
+func (s *DBAuthUserService) credentialChanged(ctx context.Context, username string) {
+	s.mu.Lock()
+	fn := s.onCredentialChange
+	s.mu.Unlock()
+	if fn != nil {
+		fn(ctx, username)
+	}
+}
+
+func (s *DBAuthUserService) ensureOtherActiveAdmin(ctx context.Context, exceptID int64) error {
+	var count int
+	if err := s.db.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM auth_user WHERE role = ? AND disabled = 0 AND id <> ?
+	`, AuthRoleAdmin, exceptID).Scan(&count); err != nil {
+		return err
+	}
+	if count == 0 {
+		return ErrAuthLastAdminRemoval
+	}
+	return nil
+}
+
+const authUserColumns = `id, username, password_hash, role, disabled, last_login_at, created_at, updated_at`
+
+func (s *DBAuthUserService) findByID(ctx context.Context, id int64) (*AuthUser, error) {
+	return scanAuthUserRow(s.db.QueryRowContext(ctx, `SELECT `+authUserColumns+` FROM auth_user WHERE id = ?`, id))
+}
+
+func (s *DBAuthUserService) findByUsername(ctx context.Context, username string) (*AuthUser, error) {
+	return scanAuthUserRow(s.db.QueryRowContext(ctx, `SELECT `+authUserColumns+` FROM auth_user WHERE username = ?`, username))
+}
+
+type authUserScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanAuthUserRow(row *sql.Row) (*AuthUser, error) {
+	user, err := scanAuthUser(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrAuthUserNotFound
+	}
+	return user, err
+}
+
+func scanAuthUser(row authUserScanner) (*AuthUser, error) {
+	var user AuthUser
+	var disabled int
+	var lastLogin, created, updated sql.NullTime
+	if err := row.Scan(&user.ID, &user.Username, &user.passwordHash, &user.Role, &disabled, &lastLogin, &created, &updated); err != nil {
+		return nil, err
+	}
+	user.Disabled = disabled != 0
+	user.LastLoginTime = formatNullLocalDateTimeISO(lastLogin)
+	user.CreateTime = formatNullLocalDateTimeISO(created)
+	user.UpdateTime = formatNullLocalDateTimeISO(updated)
+	return &user, nil
+}
+
+// hashAuthPassword 以 PBKDF2-SHA256 + 随机盐生成密码哈希，格式为 "pbkdf2-sha256$迭代次数$盐$哈希"（base64）。
+func hashAuthPassword(password string) (string, error) {
+	if n := len([]rune(password)); n < authPasswordMinRunes || n > authPasswordMaxRunes {
+		return "", errBadRequest(fmt.Sprintf("密码长度需为%d-%d个字符", authPasswordMinRunes, authPasswordMaxRunes))
+	}
+	salt := make([]byte, authPasswordSaltBytes)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key, err := pbkdf2.Key(sha256.New, password, salt, authPasswordIterations, authPasswordKeyBytes)
+	if err != nil {
+		return "", err
+	}
+	return strings.Join([]string{
+		authPasswordScheme,
+		strconv.Itoa(authPasswordIterations),
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	}, "$"), nil
+}
+
+// verifyAuthPassword 校验密码；encoded 为空或格式非法时仍按默认参数计算一次以保持耗时一致。
+func verifyAuthPassword(password string, encoded string) bool {
+	iterations := authPasswordIterations
+	var salt, want []byte
+	parts := strings.Split(encoded, "$")
+	valid := len(parts) == 4 && parts[0] == authPasswordScheme
+	if valid {
+		n, err1 := strconv.Atoi(parts[1])
+		s, err2 := base64.RawStdEncoding.DecodeString(parts[2])
+		k, err3 := base64.RawStdEncoding.DecodeString(parts[3])
+		valid = err1 == nil && err2 == nil && err3 == nil && n > 0 && len(k) > 0
+		if valid {
+			iterations, salt, want = n, s, k
+		}
+	}
+	if !valid {
+		salt, want = make([]byte, authPasswordSaltBytes), make([]byte, authPasswordKeyBytes)
+	}
+	got, err := pbkdf2.Key(sha256.New, password, salt, iterations, len(want))
+	if err != nil {
+		return false
+	}
+	return subtle.ConstantTimeCompare(got, want) == 1 && valid
+}
+
+type authPrincipalContextKey struct{}
+
+func withAuthPrincipal(ctx context.Context, principal AuthPrincipal) context.Context {
+	return context.WithValue(ctx, authPrincipalContextKey{}, principal)
+}
+
+// authPrincipalFromContext 返回 jwtMiddleware 写入的调用者；白名单接口无调用者。
+func authPrincipalFromContext(ctx context.Context) (AuthPrincipal, bool) {
+	principal, ok := ctx.Value(authPrincipalContextKey{}).(AuthPrincipal)
+	return principal, ok
+}
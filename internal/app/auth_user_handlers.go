@@ -0,0 +1,119 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+func (a *App) handleListAuthUsers(w http.ResponseWriter, r *http.Request) {
+	if a.authUsers == nil {
+		writeJSON(w, http.StatusOK, map[string]any{"code": -1, "msg": "账号服务未初始化"})
+		return
+	}
+
+	items, err := a.authUsers.List(r.Context())
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]any{"code": -1, "msg": "查询账号失败: " + err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"code": 0,
+		"msg":  "success",
+		"data": items,
+	})
+}
+
+func (a *App) handleCreateAuthUser(w http.ResponseWriter, r *http.Request) {
+	if a.authUsers == nil {
+		writeJSON(w, http.StatusOK, map[string]any{"code": -1, "msg": "账号服务未初始化"})
+		return
+	}
+
+	var in struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+		Role     string `json:"role"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"code": 400, "msg": "参数解析失败"})
+		return
+	}
+	user, err := a.authUsers.Create(r.Context(), in.Username, in.Password, strings.TrimSpace(in.Role))
+	if err != nil {
+		writeAuthUserError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"code": 0,
+		"msg":  "success",
+		"data": user,
+	})
+}
+
+func (a *App) handleUpdateAuthUser(w http.ResponseWriter, r *http.Request) {
+	if a.authUsers == nil {
+		writeJSON(w, http.StatusOK, map[string]any{"code": -1, "msg": "账号服务未初始化"})
+		return
+	}
+
+	var in struct {
+		ID       int64   `json:"id"`
+		Role     *string `json:"role"`
+		Disabled *bool   `json:"disabled"`
+		Password *string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"code": 400, "msg": "参数解析失败"})
+		return
+	}
+	user, err := a.authUsers.Update(r.Context(), in.ID, AuthUserUpdate{Role: in.Role, Disabled: in.Disabled, Password: in.Password})
+	if err != nil {
+		writeAuthUserError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"code": 0,
+		"msg":  "success",
+		"data": user,
+	})
+}
+
+func (a *App) handleDeleteAuthUser(w http.ResponseWriter, r *http.Request) {
+	if a.authUsers == nil {
+		writeJSON(w, http.StatusOK, map[string]any{"code": -1, "msg": "账号服务未初始化"})
+		return
+	}
+
+	var in struct {
+		ID int64 `json:"id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"code": 400, "msg": "参数解析失败"})
+		return
+	}
+	if err := a.authUsers.Delete(r.Context(), in.ID); err != nil {
+		writeAuthUserError(w, err)
+		return
+	}
+	// 清除两步验证数据，避免残留密钥与恢复码。
+	if a.authTOTP != nil {
+		if _, err := a.authTOTP.Reset(r.Context(), in.ID); err != nil {
+			slog.Warn("清除已删除账号的两步验证失败", "userId", in.ID, "error", err)
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"code": 0, "msg": "success"})
+}
+
+func writeAuthUserError(w http.ResponseWriter, err error) {
+	switch {
+	case isBadRequestError(err), errors.Is(err, ErrAuthUserExists), errors.Is(err, ErrAuthLastAdminRemoval):
+		writeJSON(w, http.StatusBadRequest, map[string]any{"code": 400, "msg": err.Error()})
+	case errors.Is(err, ErrAuthUserNotFound):
+		writeJSON(w, http.StatusOK, map[string]any{"code": -1, "msg": err.Error()})
+	default:
+		writeJSON(w, http.StatusInternalServerError, map[string]any{"code": -1, "msg": "保存账号失败: " + err.Error()})
+	}
+}
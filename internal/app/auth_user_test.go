@@ -0,0 +1,328 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+
+	"liao/internal/config"
+)
+
+func lowerAuthPasswordIterations(t *testing.T) {
+	t.Helper()
+	old := authPasswordIterations
+	authPasswordIterations = 1000
+	t.Cleanup(func() { authPasswordIterations = old })
+}
+
+var authUserTestColumns = []string{"id", "username", "password_hash", "role", "disabled", "last_login_at", "created_at", "updated_at"}
+
+func authUserTestRows(id int64, username string, hash string, role string, disabled int) *sqlmock.Rows {
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.Local)
+	return sqlmock.NewRows(authUserTestColumns).AddRow(id, username, hash, role, disabled, nil, now, now)
+}
+
+func TestAuthPassword_HashAndVerify(t *testing.T) {
+	lowerAuthPasswordIterations(t)
+
+	if _, err := hashAuthPassword("short"); !isBadRequestError(err) {
+		t.Fatalf("expected length error, got %v", err)
+	}
+	hash, err := hashAuthPassword("correct horse")
+	if err != nil {
+		t.Fatalf("hashAuthPassword: %v", err)
+	}
+	if !strings.HasPrefix(hash, "pbkdf2-sha256$1000$") {
+		t.Fatalf("hash=%q", hash)
+	}
+	other, _ := hashAuthPassword("correct horse")
+	if other == hash {
+		t.Fatalf("expected random salt")
+	}
+	if !verifyAuthPassword("correct horse", hash) {
+		t.Fatalf("expected password to verify")
+	}
+	if verifyAuthPassword("wrong horse", hash) || verifyAuthPassword("correct horse", "") || verifyAuthPassword("correct horse", "md5$1$a$b") {
+		t.Fatalf("expected verification failure")
+	}
+
+	// 迭代次数随哈希保存：调整默认值后旧哈希仍可校验。
+	authPasswordIterations = 2000
+	if !verifyAuthPassword("correct horse", hash) {
+		t.Fatalf("expected stored iterations to be used")
+	}
+}
+
+func TestAuthPrincipal_Allows(t *testing.T) {
+	admin := AuthPrincipal{Role: AuthRoleAdmin}
+	operator := AuthPrincipal{Role: AuthRoleOperator}
+	viewer := AuthPrincipal{Role: AuthRoleViewer}
+	if !admin.Allows(AuthRoleAdmin) || !admin.Allows(AuthRoleViewer) {
+		t.Fatalf("admin should allow everything")
+	}
+	if operator.Allows(AuthRoleAdmin) || !operator.Allows(AuthRoleOperator) {
+		t.Fatalf("operator ranks below admin")
+	}
+	if viewer.Allows(AuthRoleOperator) || !viewer.Allows(AuthRoleViewer) {
+		t.Fatalf("viewer ranks lowest")
+	}
+	if (AuthPrincipal{Role: "root"}).Allows(AuthRoleViewer) || admin.Allows("root") {
+		t.Fatalf("unknown roles never match")
+	}
+}
+
+func TestDBAuthUserService_CreateAndAuthenticate(t *testing.T) {
+	if NewDBAuthUserService(nil) != nil {
+		t.Fatalf("expected nil service for nil db")
+	}
+	lowerAuthPasswordIterations(t)
+	svc, mock := newMockDBService(t, NewDBAuthUserService)
+	ctx := context.Background()
+
+	if _, err := svc.Create(ctx, "a b", "password-1", AuthRoleViewer); !isBadRequestError(err) {
+		t.Fatalf("expected username error, got %v", err)
+	}
+	if _, err := svc.Create(ctx, "alice", "password-1", "root"); !isBadRequestError(err) {
+		t.Fatalf("expected role error, got %v", err)
+	}
+
+	expectInsertReturningID(mock, `INSERT INTO auth_user \(username, password_hash, role, disabled, created_at, updated_at\)`, 7,
+		"alice", sqlmock.AnyArg(), AuthRoleOperator, sqlmock.AnyArg(), sqlmock.AnyArg())
+	hash, _ := hashAuthPassword("password-1")
+	mock.ExpectQuery(`SELECT .* FROM auth_user WHERE id = \?`).WithArgs(int64(7)).
+		WillReturnRows(authUserTestRows(7, "alice", hash, AuthRoleOperator, 0))
+	user, err := svc.Create(ctx, " alice ", "password-1", AuthRoleOperator)
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if user.ID != 7 || user.Username != "alice" || user.Role != AuthRoleOperator || user.CreateTime == "" {
+		t.Fatalf("user=%+v", user)
+	}
+
+	mock.ExpectQuery(`SELECT .* FROM auth_user WHERE username = \?`).WithArgs("alice").
+		WillReturnRows(authUserTestRows(7, "alice", hash, AuthRoleOperator, 0))
+	mock.ExpectExec(`UPDATE auth_user SET last_login_at = \? WHERE id = \?`).WithArgs(sqlmock.AnyArg(), int64(7)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	if got, err := svc.Authenticate(ctx, "alice", "password-1"); err != nil || got.ID != 7 {
+		t.Fatalf("Authenticate: user=%+v err=%v", got, err)
+	}
+
+	mock.ExpectQuery(`SELECT .* FROM auth_user WHERE username = \?`).WithArgs("alice").
+		WillReturnRows(authUserTestRows(7, "alice", hash, AuthRoleOperator, 0))
+	if _, err := svc.Authenticate(ctx, "alice", "password-2"); !errors.Is(err, ErrAuthInvalidLogin) {
+		t.Fatalf("expected invalid login, got %v", err)
+	}
+
+	mock.ExpectQuery(`SELECT .* FROM auth_user WHERE username = \?`).WithArgs("bob").
+		WillReturnRows(sqlmock.NewRows(authUserTestColumns))
+	if _, err := svc.Authenticate(ctx, "bob", "password-1"); !errors.Is(err, ErrAuthInvalidLogin) {
+		t.Fatalf("expected invalid login for unknown user, got %v", err)
+	}
+
+	mock.ExpectQuery(`SELECT .* FROM auth_user WHERE username = \?`).WithArgs("alice").
+		WillReturnRows(authUserTestRows(7, "alice", hash, AuthRoleOperator, 1))
+	if _, err := svc.Authenticate(ctx, "alice", "password-1"); !errors.Is(err, ErrAuthUserDisabled) {
+		t.Fatalf("expected disabled, got %v", err)
+	}
+}
+
+func TestDBAuthUserService_UpdateKeepsLastAdmin(t *testing.T) {
+	lowerAuthPasswordIterations(t)
+	svc, mock := newMockDBService(t, NewDBAuthUserService)
+	ctx := context.Background()
+	viewer := AuthRoleViewer
+
+	mock.ExpectQuery(`SELECT .* FROM auth_user WHERE id = \?`).WithArgs(int64(1)).
+		WillReturnRows(authUserTestRows(1, "root", "h", AuthRoleAdmin, 0))
+	mock.ExpectQuery(`SELECT COUNT\(\*\) FROM auth_user WHERE role = \? AND disabled = 0 AND id <> \?`).
+		WithArgs(AuthRoleAdmin, int64(1)).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	if _, err := svc.Update(ctx, 1, AuthUserUpdate{Role: &viewer}); !errors.Is(err, ErrAuthLastAdminRemoval) {
+		t.Fatalf("expected last admin error, got %v", err)
+	}
+
+	mock.ExpectQuery(`SELECT .* FROM auth_user WHERE id = \?`).WithArgs(int64(2)).
+		WillReturnRows(authUserTestRows(2, "ops", "h", AuthRoleOperator, 0))
+	mock.ExpectExec(`UPDATE auth_user SET role = \?, disabled = \?, password_hash = \?, updated_at = \? WHERE id = \?`).
+		WithArgs(AuthRoleViewer, 1, "h", sqlmock.AnyArg(), int64(2)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`SELECT .* FROM auth_user WHERE id = \?`).WithArgs(int64(2)).
+		WillReturnRows(authUserTestRows(2, "ops", "h", AuthRoleViewer, 1))
+	disabled := true
+	user, err := svc.Update(ctx, 2, AuthUserUpdate{Role: &viewer, Disabled: &disabled})
+	if err != nil || user.Role != AuthRoleViewer || !user.Disabled {
+		t.Fatalf("Update: user=%+v err=%v", user, err)
+	}
+
+	mock.ExpectQuery(`SELECT .* FROM auth_user WHERE id = \?`).WithArgs(int64(9)).
+		WillReturnRows(sqlmock.NewRows(authUserTestColumns))
+	if err := svc.Delete(ctx, 9); !errors.Is(err, ErrAuthUserNotFound) {
+		t.Fatalf("expected not found, got %v", err)
+	}
+}
+
+func TestDBAuthUserService_LookupCachesAndBootstrap(t *testing.T) {
+	lowerAuthPasswordIterations(t)
+	svc, mock := newMockDBService(t, NewDBAuthUserService)
+	ctx := context.Background()
+
+	mock.ExpectQuery(`SELECT .* FROM auth_user WHERE username = \?`).WithArgs("alice").
+		WillReturnRows(authUserTestRows(7, "alice", "h", AuthRoleViewer, 0))
+	for i := 0; i < 2; i++ {
+		user, err := svc.Lookup(ctx, "alice")
+		if err != nil || user == nil || user.Role != AuthRoleViewer {
+			t.Fatalf("Lookup: user=%+v err=%v", user, err)
+		}
+	}
+	mock.ExpectQuery(`SELECT .* FROM auth_user WHERE username = \?`).WithArgs("ghost").
+		WillReturnRows(sqlmock.NewRows(authUserTestColumns))
+	if user, err := svc.Lookup(ctx, "ghost"); err != nil || user != nil {
+		t.Fatalf("Lookup ghost: user=%+v err=%v", user, err)
+	}
+
+	if created, err := svc.EnsureBootstrapAdmin(ctx, "", ""); created || err != nil {
+		t.Fatalf("expected skip without credentials, created=%v err=%v", created, err)
+	}
+	mock.ExpectQuery(`SELECT COUNT\(\*\) FROM auth_user`).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(3))
+	if created, err := svc.EnsureBootstrapAdmin(ctx, "root", "password-1"); created || err != nil {
+		t.Fatalf("expected skip when accounts exist, created=%v err=%v", created, err)
+	}
+}
+
+func TestRoleMiddleware_EnforcesRoles(t *testing.T) {
+	jwtService := NewJWTService("secret", 1)
+	a := &App{jwt: jwtService}
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) })
+	h := a.jwtMiddleware(a.roleMiddleware(ok))
+	adminOnly := a.jwtMiddleware(a.roleMiddleware(a.requireRole(AuthRoleAdmin)(ok)))
+
+	tokenFor := func(role string) string {
+		token, err := jwtService.GenerateTokenFor(AuthPrincipal{Subject: authLegacySubject, Role: role})
+		if err != nil {
+			t.Fatalf("GenerateTokenFor: %v", err)
+		}
+		return token
+	}
+	do := func(handler http.Handler, method, path, role string) int {
+		req := httptest.NewRequest(method, "http://api.local"+path, nil)
+		req.Header.Set("Authorization", "Bearer "+tokenFor(role))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	cases := []struct {
+		handler http.Handler
+		method  string
+		path    string
+		role    string
+		want    int
+	}{
+		{h, http.MethodGet, "/api/getSystemConfig", AuthRoleViewer, http.StatusOK},
+		{h, http.MethodPost, "/api/getMessageHistory", AuthRoleViewer, http.StatusOK},
+		{h, http.MethodPost, "/api/createIdentity", AuthRoleViewer, http.StatusForbidden},
+		{h, http.MethodPost, "/api/createIdentity", AuthRoleOperator, http.StatusOK},
+		{adminOnly, http.MethodPost, "/api/disconnectAllConnections", AuthRoleOperator, http.StatusForbidden},
+		{adminOnly, http.MethodPost, "/api/disconnectAllConnections", AuthRoleAdmin, http.StatusOK},
+		{adminOnly, http.MethodGet, "/api/authUser/list", AuthRoleViewer, http.StatusForbidden},
+	}
+	for _, tc := range cases {
+		if got := do(tc.handler, tc.method, tc.path, tc.role); got != tc.want {
+			t.Fatalf("%s %s as %s: status=%d, want %d", tc.method, tc.path, tc.role, got, tc.want)
+		}
+	}
+
+	// 旧版 Token 无 role 声明时视为 admin；关闭访问码登录后旧版 Token 失效。
+	legacy, _ := jwtService.GenerateToken()
+	if principal, ok := a.authenticateToken(context.Background(), legacy); !ok || principal.Role != AuthRoleAdmin {
+		t.Fatalf("principal=%+v ok=%v", principal, ok)
+	}
+	a.cfg.AuthAccessCodeDisabled = true
+	if _, ok := a.authenticateToken(context.Background(), legacy); ok {
+		t.Fatalf("expected legacy token rejected")
+	}
+}
+
+func TestAuthenticateToken_AccountUsesCurrentRole(t *testing.T) {
+	lowerAuthPasswordIterations(t)
+	svc, mock := newMockDBService(t, NewDBAuthUserService)
+	jwtService := NewJWTService("secret", 1)
+	a := &App{jwt: jwtService, authUsers: svc}
+
+	token, _ := jwtService.GenerateTokenFor(AuthPrincipal{Subject: "alice", Role: AuthRoleAdmin, Account: true})
+	mock.ExpectQuery(`SELECT .* FROM auth_user WHERE username = \?`).WithArgs("alice").
+		WillReturnRows(authUserTestRows(7, "alice", "h", AuthRoleViewer, 0))
+	principal, ok := a.authenticateToken(context.Background(), token)
+	if !ok || principal.Role != AuthRoleViewer || !principal.Account || principal.Subject != "alice" {
+		t.Fatalf("principal=%+v ok=%v", principal, ok)
+	}
+
+	svc.invalidate("alice")
+	mock.ExpectQuery(`SELECT .* FROM auth_user WHERE username = \?`).WithArgs("alice").
+		WillReturnRows(authUserTestRows(7, "alice", "h", AuthRoleViewer, 1))
+	if _, ok := a.authenticateToken(context.Background(), token); ok {
+		t.Fatalf("expected disabled account rejected")
+	}
+}
+
+func TestHandleAuthLogin_Account(t *testing.T) {
+	lowerAuthPasswordIterations(t)
+	svc, mock := newMockDBService(t, NewDBAuthUserService)
+	a := &App{
+		cfg:       config.Config{AuthAccessCode: "code-1", AuthAccessCodeDisabled: true},
+		jwt:       NewJWTService("secret-1", 1),
+		authUsers: svc,
+	}
+	hash, _ := hashAuthPassword("password-1")
+
+	form := url.Values{}
+	form.Set("accessCode", "code-1")
+	rr := httptest.NewRecorder()
+	a.handleAuthLogin(rr, newURLEncodedRequest(t, http.MethodPost, "http://example.com/api/auth/login", form))
+	if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), "访问码登录已关闭") {
+		t.Fatalf("status=%d body=%s", rr.Code, rr.Body.String())
+	}
+
+	form = url.Values{}
+	form.Set("username", "alice")
+	form.Set("password", "password-1")
+	mock.ExpectQuery(`SELECT .* FROM auth_user WHERE username = \?`).WithArgs("alice").
+		WillReturnRows(authUserTestRows(7, "alice", hash, AuthRoleOperator, 0))
+	mock.ExpectExec(`UPDATE auth_user SET last_login_at = \? WHERE id = \?`).WillReturnResult(sqlmock.NewResult(0, 1))
+	rr = httptest.NewRecorder()
+	a.handleAuthLogin(rr, newURLEncodedRequest(t, http.MethodPost, "http://example.com/api/auth/login", form))
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `"role":"operator"`) {
+		t.Fatalf("status=%d body=%s", rr.Code, rr.Body.String())
+	}
+
+	form.Set("password", "password-2")
+	mock.ExpectQuery(`SELECT .* FROM auth_user WHERE username = \?`).WithArgs("alice").
+		WillReturnRows(authUserTestRows(7, "alice", hash, AuthRoleOperator, 0))
+	rr = httptest.NewRecorder()
+	a.handleAuthLogin(rr, newURLEncodedRequest(t, http.MethodPost, "http://example.com/api/auth/login", form))
+	if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), "用户名或密码错误") {
+		t.Fatalf("status=%d body=%s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestDownstreamInbound_ReadOnlyOnlyObserves(t *testing.T) {
+	a := &App{wsGuard: NewWSGuard(WSGuardConfig{})}
+	session := NewDownstreamSession(nil)
+	in := a.newDownstreamInbound(session, "remote", AuthPrincipal{Role: AuthRoleViewer})
+
+	in.handleFrame([]byte(`{"act":"sign","id":"u1"}`))
+	if in.registeredUserID != "" {
+		t.Fatalf("viewer must not sign, registered=%q", in.registeredUserID)
+	}
+	in.handleFrame([]byte(`{"act":"observe","id":"u1"}`))
+	if in.observedUserID != "u1" {
+		t.Fatalf("viewer should observe, observed=%q", in.observedUserID)
+	}
+}
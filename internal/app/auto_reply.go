@@ -0,0 +1,610 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"liao/internal/database"
+	"liao/internal/protocol"
+)
+
+// UpstreamStageAutoReply 为自动回复处理阶段名称（在内置阶段之后注册，只观察 code=7 不拦截）。
+const UpstreamStageAutoReply = "auto-reply"
+
+const (
+	autoReplyDefaultCooldownSeconds = 60
+	autoReplyMaxCooldownSeconds     = 86400
+	autoReplyMaxTemplateRunes       = 1000
+	autoReplyMaxRulesPerIdentity    = 100
+	autoReplyLimiterPruneThreshold  = 1024
+)
+
+var (
+	// 启用规则的缓存时长；规则增删改时立即失效。
+	autoReplyRuleCacheTTL = 30 * time.Second
+
+	autoReplyTimeOfDayPattern = regexp.MustCompile(`^([01]\d|2[0-3]):[0-5]\d$`)
+
+	// ErrAutoReplyRuleNotFound 表示规则不存在或不属于该身份。
+	ErrAutoReplyRuleNotFound = errors.New("自动回复规则不存在")
+)
+
+// AutoReplyRule 为某身份的一条自动回复规则；各匹配条件为空时表示不限制，全部满足才命中。
+type AutoReplyRule struct {
+	ID              int64    `json:"id"`
+	UserID          string   `json:"userId"`
+	Name            string   `json:"name"`
+	Enabled         bool     `json:"enabled"`
+	Priority        int      `json:"priority"`
+	Senders         []string `json:"senders"`
+	Keyword         string   `json:"keyword"`
+	Regex           string   `json:"regex"`
+	MsgType         string   `json:"msgType"`
+	TimeStart       string   `json:"timeStart"`
+	TimeEnd         string   `json:"timeEnd"`
+	ReplyTemplate   string   `json:"replyTemplate"`
+	CooldownSeconds int      `json:"cooldownSeconds"`
+	CreateTime      string   `json:"createTime,omitempty"`
+	UpdateTime      string   `json:"updateTime,omitempty"`
+
+	re *regexp.Regexp
+}
+
+// normalizeAutoReplyRule 校验并规范化规则字段，同时预编译正则。
+func normalizeAutoReplyRule(rule *AutoReplyRule) error {
+	if rule == nil {
+		return errBadRequest("规则不能为空")
+	}
+	rule.UserID = strings.TrimSpace(rule.UserID)
+	rule.Name = strings.TrimSpace(rule.Name)
+	rule.Keyword = strings.TrimSpace(rule.Keyword)
+	rule.Regex = strings.TrimSpace(rule.Regex)
+	rule.MsgType = strings.ToLower(strings.TrimSpace(rule.MsgType))
+	rule.TimeStart = strings.TrimSpace(rule.TimeStart)
+	rule.TimeEnd = strings.TrimSpace(rule.TimeEnd)
+	rule.ReplyTemplate = strings.TrimSpace(rule.ReplyTemplate)
+
+	senders := make([]string, 0, len(rule.Senders))
+	seen := make(map[string]struct{}, len(rule.Senders))
+	for _, sender := range rule.Senders {
+		sender = strings.TrimSpace(sender)
+		if sender == "" {
+			continue
+		}
+		if _, ok := seen[sender]; ok {
+			continue
+		}
+		seen[sender] = struct{}{}
+		senders = append(senders, sender)
+	}
+	rule.Senders = senders
+
+	if rule.UserID == "" {
+		return errBadRequest("userId不能为空")
+	}
+	if rule.Name == "" {
+		rule.Name = "未命名规则"
+	}
+	if utf8.RuneCountInString(rule.Name) > 128 {
+		return errBadRequest("规则名称不能超过128个字符")
+	}
+	if rule.ReplyTemplate == "" {
+		return errBadRequest("回复模板不能为空")
+	}
+	if utf8.RuneCountInString(rule.ReplyTemplate) > autoReplyMaxTemplateRunes {
+		return errBadRequest(fmt.Sprintf("回复模板不能超过%d个字符", autoReplyMaxTemplateRunes))
+	}
+	if len(strings.Join(rule.Senders, ",")) > 1024 {
+		return errBadRequest("发送者列表过长")
+	}
+	if (rule.TimeStart == "") != (rule.TimeEnd == "") {
+		return errBadRequest("生效时间需同时设置开始与结束")
+	}
+	if rule.TimeStart != "" && (!autoReplyTimeOfDayPattern.MatchString(rule.TimeStart) || !autoReplyTimeOfDayPattern.MatchString(rule.TimeEnd)) {
+		return errBadRequest("生效时间格式应为 HH:MM")
+	}
+	if rule.CooldownSeconds < 0 || rule.CooldownSeconds > autoReplyMaxCooldownSeconds {
+		return errBadRequest(fmt.Sprintf("回复间隔需在0~%d秒之间", autoReplyMaxCooldownSeconds))
+	}
+	if rule.CooldownSeconds == 0 {
+		rule.CooldownSeconds = autoReplyDefaultCooldownSeconds
+	}
+
+	rule.re = nil
+	if rule.Regex != "" {
+		re, err := regexp.Compile(rule.Regex)
+		if err != nil {
+			return errBadRequest("正则表达式非法: " + err.Error())
+		}
+		rule.re = re
+	}
+	return nil
+}
+
+// AutoReplyMessage 为参与规则匹配的一条收到的私聊消息。
+type AutoReplyMessage struct {
+	UserID     string    `json:"userId"`
+	FromUserID string    `json:"fromUserId"`
+	Nickname   string    `json:"nickname"`
+	Content    string    `json:"content"`
+	Type       string    `json:"type"`
+	At         time.Time `json:"-"`
+}
+
+// Match 判断规则是否命中消息；未命中时返回原因（用于试运行说明）。
+func (r *AutoReplyRule) Match(msg AutoReplyMessage) (bool, string) {
+	if !r.Enabled {
+		return false, "规则未启用"
+	}
+	if len(r.Senders) > 0 {
+		found := false
+		for _, sender := range r.Senders {
+			if sender == msg.FromUserID {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false, "发送者不匹配"
+		}
+	}
+	if r.MsgType != "" && !strings.EqualFold(r.MsgType, msg.Type) {
+		return false, "消息类型不匹配"
+	}
+	if r.Keyword != "" && !strings.Contains(strings.ToLower(msg.Content), strings.ToLower(r.Keyword)) {
+		return false, "关键字不匹配"
+	}
+	if r.re != nil && !r.re.MatchString(msg.Content) {
+		return false, "正则不匹配"
+	}
+	if !autoReplyInTimeWindow(r.TimeStart, r.TimeEnd, msg.At) {
+		return false, "不在生效时间内"
+	}
+	return true, ""
+}
+
+// autoReplyInTimeWindow 判断时刻是否落在 [start, end) 内；start>end 表示跨零点，start==end 表示全天。
+func autoReplyInTimeWindow(start, end string, at time.Time) bool {
+	if start == "" || end == "" || start == end {
+		return true
+	}
+	now := at.Format("15:04")
+	if start < end {
+		return now >= start && now < end
+	}
+	return now >= start || now < end
+}
+
+// renderAutoReplyTemplate 替换模板占位符：{{nickname}}、{{senderId}}、{{content}}、{{time}}、{{date}}。
+func renderAutoReplyTemplate(template string, msg AutoReplyMessage) string {
+	nickname := strings.TrimSpace(msg.Nickname)
+	if nickname == "" {
+		nickname = msg.FromUserID
+	}
+	return strings.NewReplacer(
+		"{{nickname}}", nickname,
+		"{{senderId}}", msg.FromUserID,
+		"{{content}}", msg.Content,
+		"{{time}}", msg.At.Format("15:04"),
+		"{{date}}", msg.At.Format("2006-01-02"),
+	).Replace(template)
+}
+
+// buildAutoReplyPayload 构造回复给发送者的私聊帧。
+func buildAutoReplyPayload(msg AutoReplyMessage, reply string) string {
+	return buildTouserPayload(msg.UserID, msg.FromUserID, msg.Nickname, reply)
+}
+
+// AutoReplyService 定义自动回复规则的持久化能力。
+type AutoReplyService interface {
+	List(ctx context.Context, userID string) ([]AutoReplyRule, error)
+	EnabledRules(ctx context.Context, userID string) ([]AutoReplyRule, error)
+	Create(ctx context.Context, rule AutoReplyRule) (*AutoReplyRule, error)
+	Update(ctx context.Context, rule AutoReplyRule) (*AutoReplyRule, error)
+	Delete(ctx context.Context, userID string, id int64) error
+}
+
+type autoReplyCachedRules struct {
+	rules    []AutoReplyRule
+	loadedAt time.Time
+}
+
+// DBAutoReplyService 基于数据库实现 AutoReplyService，并按身份缓存启用的规则。
+type DBAutoReplyService struct {
+	db *database.DB
+
+	mu    sync.Mutex
+	cache map[string]autoReplyCachedRules
+}
+
+// NewDBAutoReplyService 创建数据库自动回复规则服务。
+func NewDBAutoReplyService(db *database.DB) *DBAutoReplyService {
+	if db == nil {
+		return nil
+	}
+	return &DBAutoReplyService{db: db, cache: make(map[string]autoReplyCachedRules)}
+}
+
+const autoReplyRuleColumns = `id, user_id, name, enabled, priority, match_senders, match_keyword, match_regex, match_type,
+		time_start, time_end, reply_template, cooldown_seconds, created_at, updated_at`
+
+// List 按优先级降序返回身份的全部规则。
+func (s *DBAutoReplyService) List(ctx context.Context, userID string) ([]AutoReplyRule, error) {
+	if s == nil || s.db == nil {
+		return nil, fmt.Errorf("db not initialized")
+	}
+	userID = strings.TrimSpace(userID)
+	if userID == "" {
+		return nil, fmt.Errorf("userId 不能为空")
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT `+autoReplyRuleColumns+`
+		FROM auto_reply_rule
+		WHERE user_id = ?
+		ORDER BY priority DESC, id ASC
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]AutoReplyRule, 0)
+	for rows.Next() {
+		rule, err := scanAutoReplyRule(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, *rule)
+	}
+	return out, rows.Err()
+}
+
+// EnabledRules 返回身份已启用的规则（按优先级降序），结果缓存 autoReplyRuleCacheTTL。
+func (s *DBAutoReplyService) EnabledRules(ctx context.Context, userID string) ([]AutoReplyRule, error) {
+	if s == nil || s.db == nil {
+		return nil, fmt.Errorf("db not initialized")
+	}
+	userID = strings.TrimSpace(userID)
+
+	s.mu.Lock()
+	cached, ok := s.cache[userID]
+	s.mu.Unlock()
+	if ok && time.Since(cached.loadedAt) < autoReplyRuleCacheTTL {
+		return cached.rules, nil
+	}
+
+	all, err := s.List(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	enabled := make([]AutoReplyRule, 0, len(all))
+	for _, rule := range all {
+		if rule.Enabled {
+			enabled = append(enabled, rule)
+		}
+	}
+
+	s.mu.Lock()
+	s.cache[userID] = autoReplyCachedRules{rules: enabled, loadedAt: time.Now()}
+	s.mu.Unlock()
+	return enabled, nil
+}
+
+func (s *DBAutoReplyService) Create(ctx context.Context, rule AutoReplyRule) (*AutoReplyRule, error) {
+	if s == nil || s.db == nil {
+		return nil, fmt.Errorf("db not initialized")
+	}
+	if err := normalizeAutoReplyRule(&rule); err != nil {
+		return nil, err
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	var count int
+	if err := s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM auto_reply_rule WHERE user_id = ?", rule.UserID).Scan(&count); err != nil {
+		return nil, err
+	}
+	if count >= autoReplyMaxRulesPerIdentity {
+		return nil, errBadRequest(fmt.Sprintf("每个身份最多%d条规则", autoReplyMaxRulesPerIdentity))
+	}
+
+	now := time.Now()
+	id, err := database.InsertReturningID(ctx, s.db, `
+		INSERT INTO auto_reply_rule (user_id, name, enabled, priority, match_senders, match_keyword, match_regex, match_type,
+			time_start, time_end, reply_template, cooldown_seconds, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, rule.UserID, rule.Name, boolToInt(rule.Enabled), rule.Priority, nullIfEmpty(strings.Join(rule.Senders, ",")),
+		nullIfEmpty(rule.Keyword), nullIfEmpty(rule.Regex), nullIfEmpty(rule.MsgType), nullIfEmpty(rule.TimeStart), nullIfEmpty(rule.TimeEnd),
+		rule.ReplyTemplate, rule.CooldownSeconds, now, now)
+	if err != nil {
+		return nil, err
+	}
+	s.invalidate(rule.UserID)
+	return s.find(ctx, rule.UserID, id)
+}
+
+func (s *DBAutoReplyService) Update(ctx context.Context, rule AutoReplyRule) (*AutoReplyRule, error) {
+	if s == nil || s.db == nil {
+		return nil, fmt.Errorf("db not initialized")
+	}
+	if rule.ID <= 0 {
+		return nil, errBadRequest("id 参数非法")
+	}
+	if err := normalizeAutoReplyRule(&rule); err != nil {
+		return nil, err
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	res, err := s.db.ExecContext(ctx, `
+		UPDATE auto_reply_rule
+		SET name = ?, enabled = ?, priority = ?, match_senders = ?, match_keyword = ?, match_regex = ?, match_type = ?,
+			time_start = ?, time_end = ?, reply_template = ?, cooldown_seconds = ?, updated_at = ?
+		WHERE id = ? AND user_id = ?
+	`, rule.Name, boolToInt(rule.Enabled), rule.Priority, nullIfEmpty(strings.Join(rule.Senders, ",")),
+		nullIfEmpty(rule.Keyword), nullIfEmpty(rule.Regex), nullIfEmpty(rule.MsgType), nullIfEmpty(rule.TimeStart), nullIfEmpty(rule.TimeEnd),
+		rule.ReplyTemplate, rule.CooldownSeconds, time.Now(), rule.ID, rule.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if affected, err := res.RowsAffected(); err == nil && affected == 0 {
+		// MySQL 在内容未变化时也返回 0，需再确认规则是否存在。
+		if _, err := s.find(ctx, rule.UserID, rule.ID); err != nil {
+			return nil, err
+		}
+	}
+	s.invalidate(rule.UserID)
+	return s.find(ctx, rule.UserID, rule.ID)
+}
+
+func (s *DBAutoReplyService) Delete(ctx context.Context, userID string, id int64) error {
+	if s == nil || s.db == nil {
+		return fmt.Errorf("db not initialized")
+	}
+	userID = strings.TrimSpace(userID)
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	res, err := s.db.ExecContext(ctx, "DELETE FROM auto_reply_rule WHERE id = ? AND user_id = ?", id, userID)
+	if err != nil {
+		return err
+	}
+	if affected, err := res.RowsAffected(); err == nil && affected == 0 {
+		return ErrAutoReplyRuleNotFound
+	}
+	s.invalidate(userID)
+	return nil
+}
+
+func (s *DBAutoReplyService) find(ctx context.Context, userID string, id int64) (*AutoReplyRule, error) {
+	rule, err := scanAutoReplyRule(s.db.QueryRowContext(ctx, `
+		SELECT `+autoReplyRuleColumns+`
+		FROM auto_reply_rule
+		WHERE id = ? AND user_id = ?
+	`, id, userID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrAutoReplyRuleNotFound
+	}
+	return rule, err
+}
+
+func (s *DBAutoReplyService) invalidate(userID string) {
+	s.mu.Lock()
+	delete(s.cache, userID)
+	s.mu.Unlock()
+}
+
+type autoReplyRowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanAutoReplyRule(row autoReplyRowScanner) (*AutoReplyRule, error) {
+	var rule AutoReplyRule
+	var enabled int
+	var senders, keyword, pattern, msgType, timeStart, timeEnd sql.NullString
+	var created, updated sql.NullTime
+	if err := row.Scan(&rule.ID, &rule.UserID, &rule.Name, &enabled, &rule.Priority, &senders, &keyword, &pattern, &msgType,
+		&timeStart, &timeEnd, &rule.ReplyTemplate, &rule.CooldownSeconds, &created, &updated); err != nil {
+		return nil, err
+	}
+	rule.Enabled = enabled != 0
+	rule.Senders = make([]string, 0)
+	for _, sender := range strings.Split(senders.String, ",") {
+		if sender = strings.TrimSpace(sender); sender != "" {
+			rule.Senders = append(rule.Senders, sender)
+		}
+	}
+	rule.Keyword = keyword.String
+	rule.Regex = pattern.String
+	rule.MsgType = msgType.String
+	rule.TimeStart = timeStart.String
+	rule.TimeEnd = timeEnd.String
+	rule.CreateTime = formatNullLocalDateTimeISO(created)
+	rule.UpdateTime = formatNullLocalDateTimeISO(updated)
+	if rule.Regex != "" {
+		// 入库前已校验；历史脏数据编译失败时该规则视为不可命中。
+		re, err := regexp.Compile(rule.Regex)
+		if err != nil {
+			slog.Warn("自动回复规则正则非法", "ruleId", rule.ID, "error", err)
+			rule.Enabled = false
+		}
+		rule.re = re
+	}
+	return &rule, nil
+}
+
+func boolToInt(v bool) int {
+	if v {
+		return 1
+	}
+	return 0
+}
+
+// AutoReplyDecision 为一次规则评估结果。
+type AutoReplyDecision struct {
+	Matched           bool                    `json:"matched"`
+	RuleID            int64                   `json:"ruleId,omitempty"`
+	RuleName          string                  `json:"ruleName,omitempty"`
+	Reply             string                  `json:"reply,omitempty"`
+	Payload           string                  `json:"payload,omitempty"`
+	RateLimited       bool                    `json:"rateLimited"`
+	RetryAfterSeconds int64                   `json:"retryAfterSeconds,omitempty"`
+	Rules             []AutoReplyRuleEvaluate `json:"rules"`
+}
+
+// AutoReplyRuleEvaluate 为单条规则的评估说明。
+type AutoReplyRuleEvaluate struct {
+	RuleID   int64  `json:"ruleId"`
+	RuleName string `json:"ruleName"`
+	Matched  bool   `json:"matched"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+// AutoReplyEngine 在收到私聊消息时按优先级匹配规则，并按会话限流发送模板回复。
+type AutoReplyEngine struct {
+	service AutoReplyService
+	send    func(userID string, message string) OutboundFilterDecision
+
+	mu          sync.Mutex
+	nextAllowed map[string]time.Time
+}
+
+// NewAutoReplyEngine 创建自动回复引擎；send 通常为 UpstreamWebSocketManager.SendToUpstream。
+func NewAutoReplyEngine(service AutoReplyService, send func(userID string, message string) OutboundFilterDecision) *AutoReplyEngine {
+	if service == nil {
+		return nil
+	}
+	return &AutoReplyEngine{service: service, send: send, nextAllowed: make(map[string]time.Time)}
+}
+
+// Evaluate 按优先级评估规则（已排序），返回首个命中规则及限流状态；consume=true 时命中且未限流会占用会话冷却。
+func (e *AutoReplyEngine) Evaluate(rules []AutoReplyRule, msg AutoReplyMessage, consume bool) AutoReplyDecision {
+	decision := AutoReplyDecision{Rules: make([]AutoReplyRuleEvaluate, 0, len(rules))}
+	sorted := append([]AutoReplyRule(nil), rules...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Priority > sorted[j].Priority })
+
+	for i := range sorted {
+		rule := &sorted[i]
+		if decision.Matched {
+			decision.Rules = append(decision.Rules, AutoReplyRuleEvaluate{RuleID: rule.ID, RuleName: rule.Name, Reason: "已由更高优先级规则命中"})
+			continue
+		}
+		ok, reason := rule.Match(msg)
+		decision.Rules = append(decision.Rules, AutoReplyRuleEvaluate{RuleID: rule.ID, RuleName: rule.Name, Matched: ok, Reason: reason})
+		if !ok {
+			continue
+		}
+		decision.Matched = true
+		decision.RuleID = rule.ID
+		decision.RuleName = rule.Name
+		decision.Reply = renderAutoReplyTemplate(rule.ReplyTemplate, msg)
+		decision.Payload = buildAutoReplyPayload(msg, decision.Reply)
+		if wait := e.reserve(msg, time.Duration(rule.CooldownSeconds)*time.Second, consume); wait > 0 {
+			decision.RateLimited = true
+			decision.RetryAfterSeconds = int64((wait + time.Second - 1) / time.Second)
+		}
+	}
+	return decision
+}
+
+// reserve 检查会话冷却，返回剩余等待时间；未限流且 consume=true 时记录下次允许时间。
+func (e *AutoReplyEngine) reserve(msg AutoReplyMessage, cooldown time.Duration, consume bool) time.Duration {
+	key := msg.UserID + "|" + msg.FromUserID
+	now := msg.At
+	if now.IsZero() {
+		now = time.Now()
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if next, ok := e.nextAllowed[key]; ok && now.Before(next) {
+		return next.Sub(now)
+	}
+	if consume {
+		if len(e.nextAllowed) >= autoReplyLimiterPruneThreshold {
+			for k, next := range e.nextAllowed {
+				if !now.Before(next) {
+					delete(e.nextAllowed, k)
+				}
+			}
+		}
+		e.nextAllowed[key] = now.Add(cooldown)
+	}
+	return 0
+}
+
+// KeepsAlive 返回身份是否有启用的自动回复规则；用作上游连接保活判定，使规则在没有下游页面时仍能生效。
+// 查询失败时返回 false，按普通身份处理关闭延迟。
+func (e *AutoReplyEngine) KeepsAlive(userID string) bool {
+	if e == nil {
+		return false
+	}
+	rules, err := e.service.EnabledRules(context.Background(), userID)
+	if err != nil {
+		slog.Warn("加载自动回复规则失败", "userID", userID, "error", err)
+		return false
+	}
+	return len(rules) > 0
+}
+
+// HandleUpstreamMessage 作为上游处理阶段：命中规则时异步发送回复，帧始终继续广播。
+func (e *AutoReplyEngine) HandleUpstreamMessage(frame *UpstreamFrame) UpstreamFrameVerdict {
+	chat, ok := frame.Message().(*protocol.Chat)
+	if !ok {
+		return UpstreamFramePass
+	}
+	fromUserID := strings.TrimSpace(chat.From.ID)
+	// 跳过自己发出的消息（上游回显），避免自动回复互相触发。
+	if fromUserID == "" || fromUserID == frame.UserID || strings.EqualFold(fromUserID, md5HexLower(frame.UserID)) {
+		return UpstreamFramePass
+	}
+
+	rules, err := e.service.EnabledRules(context.Background(), frame.UserID)
+	if err != nil {
+		slog.Warn("加载自动回复规则失败", "userID", frame.UserID, "error", err)
+		return UpstreamFramePass
+	}
+	if len(rules) == 0 {
+		return UpstreamFramePass
+	}
+
+	msg := AutoReplyMessage{
+		UserID:     frame.UserID,
+		FromUserID: fromUserID,
+		Nickname:   chat.SenderNickname(),
+		Content:    chat.Text(),
+		Type:       chat.MessageType(),
+		At:         time.Now(),
+	}
+	decision := e.Evaluate(rules, msg, true)
+	if !decision.Matched {
+		return UpstreamFramePass
+	}
+	if decision.RateLimited {
+		slog.Debug("自动回复被会话限流", "userID", frame.UserID, "fromUserID", fromUserID, "ruleId", decision.RuleID, "retryAfterSeconds", decision.RetryAfterSeconds)
+		return UpstreamFramePass
+	}
+
+	slog.Info("触发自动回复", "userID", frame.UserID, "fromUserID", fromUserID, "ruleId", decision.RuleID, "ruleName", decision.RuleName)
+	if e.send != nil {
+		// 异步发送：SendToUpstream 会执行出站过滤（被拦截时只记日志，不通知下游）并写发送队列，避免阻塞上游读循环。
+		go e.send(frame.UserID, decision.Payload)
+	}
+	return UpstreamFramePass
+}
@@ -0,0 +1,178 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+)
+
+func (a *App) handleListAutoReplyRules(w http.ResponseWriter, r *http.Request) {
+	if a.autoReply == nil {
+		writeJSON(w, http.StatusOK, map[string]any{"code": -1, "msg": "自动回复服务未初始化"})
+		return
+	}
+
+	userID := strings.TrimSpace(r.URL.Query().Get("userId"))
+	if userID == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"code": 400, "msg": "userId不能为空"})
+		return
+	}
+	items, err := a.autoReply.List(r.Context(), userID)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]any{"code": -1, "msg": "查询自动回复规则失败: " + err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"code": 0,
+		"msg":  "success",
+		"data": items,
+	})
+}
+
+func (a *App) handleCreateAutoReplyRule(w http.ResponseWriter, r *http.Request) {
+	a.saveAutoReplyRule(w, r, false)
+}
+
+func (a *App) handleUpdateAutoReplyRule(w http.ResponseWriter, r *http.Request) {
+	a.saveAutoReplyRule(w, r, true)
+}
+
+func (a *App) saveAutoReplyRule(w http.ResponseWriter, r *http.Request, update bool) {
+	if a.autoReply == nil {
+		writeJSON(w, http.StatusOK, map[string]any{"code": -1, "msg": "自动回复服务未初始化"})
+		return
+	}
+
+	var in AutoReplyRule
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"code": 400, "msg": "参数解析失败"})
+		return
+	}
+
+	var (
+		rule *AutoReplyRule
+		err  error
+	)
+	if update {
+		rule, err = a.autoReply.Update(r.Context(), in)
+	} else {
+		rule, err = a.autoReply.Create(r.Context(), in)
+	}
+	if err != nil {
+		writeAutoReplyError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"code": 0,
+		"msg":  "success",
+		"data": rule,
+	})
+}
+
+func (a *App) handleDeleteAutoReplyRule(w http.ResponseWriter, r *http.Request) {
+	if a.autoReply == nil {
+		writeJSON(w, http.StatusOK, map[string]any{"code": -1, "msg": "自动回复服务未初始化"})
+		return
+	}
+
+	var in struct {
+		UserID string `json:"userId"`
+		ID     int64  `json:"id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"code": 400, "msg": "参数解析失败"})
+		return
+	}
+	if strings.TrimSpace(in.UserID) == "" || in.ID <= 0 {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"code": 400, "msg": "userId和id不能为空"})
+		return
+	}
+	if err := a.autoReply.Delete(r.Context(), in.UserID, in.ID); err != nil {
+		writeAutoReplyError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"code": 0, "msg": "success"})
+}
+
+// handleDryRunAutoReply 用模拟消息评估规则（不发送、不占用会话冷却）；body 带 rule 时只评估该未保存规则。
+func (a *App) handleDryRunAutoReply(w http.ResponseWriter, r *http.Request) {
+	if a.autoReply == nil || a.autoReplyEngine == nil {
+		writeJSON(w, http.StatusOK, map[string]any{"code": -1, "msg": "自动回复服务未初始化"})
+		return
+	}
+
+	var in struct {
+		AutoReplyMessage
+		Time string         `json:"time"`
+		Rule *AutoReplyRule `json:"rule"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"code": 400, "msg": "参数解析失败"})
+		return
+	}
+	msg := in.AutoReplyMessage
+	msg.UserID = strings.TrimSpace(msg.UserID)
+	msg.FromUserID = strings.TrimSpace(msg.FromUserID)
+	msg.Type = strings.TrimSpace(msg.Type)
+	if msg.Type == "" {
+		msg.Type = "text"
+	}
+	if msg.UserID == "" || msg.FromUserID == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"code": 400, "msg": "userId和fromUserId不能为空"})
+		return
+	}
+	at, err := parseAutoReplyDryRunTime(in.Time)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"code": 400, "msg": "time 格式应为 HH:MM 或 RFC3339"})
+		return
+	}
+	msg.At = at
+
+	var rules []AutoReplyRule
+	if in.Rule != nil {
+		in.Rule.UserID = msg.UserID
+		if err := normalizeAutoReplyRule(in.Rule); err != nil {
+			writeAutoReplyError(w, err)
+			return
+		}
+		rules = []AutoReplyRule{*in.Rule}
+	} else {
+		rules, err = a.autoReply.List(r.Context(), msg.UserID)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]any{"code": -1, "msg": "查询自动回复规则失败: " + err.Error()})
+			return
+		}
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"code": 0,
+		"msg":  "success",
+		"data": a.autoReplyEngine.Evaluate(rules, msg, false),
+	})
+}
+
+func parseAutoReplyDryRunTime(raw string) (time.Time, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return time.Now(), nil
+	}
+	if autoReplyTimeOfDayPattern.MatchString(raw) {
+		clock, _ := time.ParseInLocation("15:04", raw, time.Local)
+		now := time.Now()
+		return time.Date(now.Year(), now.Month(), now.Day(), clock.Hour(), clock.Minute(), 0, 0, time.Local), nil
+	}
+	return time.Parse(time.RFC3339, raw)
+}
+
+func writeAutoReplyError(w http.ResponseWriter, err error) {
+	switch {
+	case isBadRequestError(err):
+		writeJSON(w, http.StatusBadRequest, map[string]any{"code": 400, "msg": err.Error()})
+	case errors.Is(err, ErrAutoReplyRuleNotFound):
+		writeJSON(w, http.StatusOK, map[string]any{"code": -1, "msg": err.Error()})
+	default:
+		writeJSON(w, http.StatusInternalServerError, map[string]any{"code": -1, "msg": "保存自动回复规则失败: " + err.Error()})
+	}
+}
@@ -0,0 +1,422 @@
+package app
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+
+	"liao/internal/fakeupstream"
+)
+
+var autoReplyRuleTestColumns = []string{"id", "user_id", "name", "enabled", "priority", "match_senders", "match_keyword", "match_regex", "match_type",
+	"time_start", "time_end", "reply_template", "cooldown_seconds", "created_at", "updated_at"}
+
+func TestNormalizeAutoReplyRule(t *testing.T) {
+	rule := AutoReplyRule{UserID: " u1 ", Senders: []string{" a ", "", "a", "b"}, Regex: `^hi`, MsgType: " TEXT ", ReplyTemplate: " ok "}
+	if err := normalizeAutoReplyRule(&rule); err != nil {
+		t.Fatalf("normalize: %v", err)
+	}
+	if rule.UserID != "u1" || rule.Name == "" || len(rule.Senders) != 2 || rule.MsgType != "text" || rule.CooldownSeconds != autoReplyDefaultCooldownSeconds || rule.re == nil {
+		t.Fatalf("rule=%+v", rule)
+	}
+
+	for name, bad := range map[string]AutoReplyRule{
+		"userId":    {ReplyTemplate: "x"},
+		"template":  {UserID: "u1"},
+		"regex":     {UserID: "u1", ReplyTemplate: "x", Regex: "("},
+		"halfTime":  {UserID: "u1", ReplyTemplate: "x", TimeStart: "08:00"},
+		"badTime":   {UserID: "u1", ReplyTemplate: "x", TimeStart: "8:00", TimeEnd: "24:00"},
+		"cooldown":  {UserID: "u1", ReplyTemplate: "x", CooldownSeconds: -1},
+		"longReply": {UserID: "u1", ReplyTemplate: strings.Repeat("字", autoReplyMaxTemplateRunes+1)},
+	} {
+		if err := normalizeAutoReplyRule(&bad); err == nil || !isBadRequestError(err) {
+			t.Fatalf("%s: err=%v, want bad request", name, err)
+		}
+	}
+}
+
+func TestAutoReplyRule_MatchAndTemplate(t *testing.T) {
+	at := time.Date(2026, 1, 2, 23, 30, 0, 0, time.Local)
+	msg := AutoReplyMessage{UserID: "me", FromUserID: "peer", Nickname: "小王", Content: "在吗 Hello", Type: "text", At: at}
+
+	rule := AutoReplyRule{UserID: "me", Enabled: true, Senders: []string{"peer"}, Keyword: "hello", Regex: `^在`, MsgType: "text", TimeStart: "22:00", TimeEnd: "08:00", ReplyTemplate: "x"}
+	if err := normalizeAutoReplyRule(&rule); err != nil {
+		t.Fatalf("normalize: %v", err)
+	}
+	if ok, reason := rule.Match(msg); !ok {
+		t.Fatalf("expected match, reason=%s", reason)
+	}
+
+	cases := map[string]func(r *AutoReplyRule){
+		"规则未启用":   func(r *AutoReplyRule) { r.Enabled = false },
+		"发送者不匹配":  func(r *AutoReplyRule) { r.Senders = []string{"other"} },
+		"消息类型不匹配": func(r *AutoReplyRule) { r.MsgType = "image" },
+		"关键字不匹配":  func(r *AutoReplyRule) { r.Keyword = "bye" },
+		"不在生效时间内": func(r *AutoReplyRule) { r.TimeStart, r.TimeEnd = "09:00", "18:00" },
+	}
+	for want, mutate := range cases {
+		r := rule
+		mutate(&r)
+		if ok, reason := r.Match(msg); ok || reason != want {
+			t.Fatalf("ok=%v reason=%q, want %q", ok, reason, want)
+		}
+	}
+
+	if !autoReplyInTimeWindow("08:00", "08:00", at) || autoReplyInTimeWindow("00:00", "23:30", at) {
+		t.Fatalf("unexpected time window result")
+	}
+
+	got := renderAutoReplyTemplate("你好 {{nickname}}({{senderId}})，{{time}} 收到：{{content}}", msg)
+	if got != "你好 小王(peer)，23:30 收到：在吗 Hello" {
+		t.Fatalf("rendered=%q", got)
+	}
+	if payload := buildAutoReplyPayload(msg, "hi"); payload != `{"act":"touser_peer_小王","id":"me","msg":"hi"}` {
+		t.Fatalf("payload=%s", payload)
+	}
+}
+
+func TestAutoReplyEngine_EvaluatePriorityAndRateLimit(t *testing.T) {
+	e := NewAutoReplyEngine(newStubAutoReplyService(), nil)
+	low := AutoReplyRule{ID: 1, UserID: "me", Name: "low", Enabled: true, ReplyTemplate: "low", CooldownSeconds: 60}
+	high := AutoReplyRule{ID: 2, UserID: "me", Name: "high", Enabled: true, Priority: 10, Keyword: "hi", ReplyTemplate: "high", CooldownSeconds: 60}
+	now := time.Now()
+	msg := AutoReplyMessage{UserID: "me", FromUserID: "peer", Content: "hi", At: now}
+
+	// 试运行不占用冷却。
+	for i := 0; i < 2; i++ {
+		d := e.Evaluate([]AutoReplyRule{low, high}, msg, false)
+		if !d.Matched || d.RuleID != 2 || d.Reply != "high" || d.RateLimited || len(d.Rules) != 2 || d.Rules[1].Reason == "" {
+			t.Fatalf("decision=%+v", d)
+		}
+	}
+
+	if d := e.Evaluate([]AutoReplyRule{low, high}, msg, true); d.RateLimited {
+		t.Fatalf("first reply should not be limited")
+	}
+	msg.At = now.Add(10 * time.Second)
+	if d := e.Evaluate([]AutoReplyRule{low, high}, msg, true); !d.RateLimited || d.RetryAfterSeconds != 50 {
+		t.Fatalf("decision=%+v, want limited", d)
+	}
+	// 不同会话互不影响；冷却结束后再次允许。
+	if d := e.Evaluate([]AutoReplyRule{low}, AutoReplyMessage{UserID: "me", FromUserID: "other", At: msg.At}, true); d.RateLimited {
+		t.Fatalf("other conversation should not be limited")
+	}
+	msg.At = now.Add(61 * time.Second)
+	if d := e.Evaluate([]AutoReplyRule{low, high}, msg, true); d.RateLimited {
+		t.Fatalf("cooldown should have expired")
+	}
+
+	if d := e.Evaluate(nil, msg, true); d.Matched {
+		t.Fatalf("no rules should not match")
+	}
+}
+
+func TestDBAutoReplyService_CRUDAndCache(t *testing.T) {
+	if NewDBAutoReplyService(nil) != nil {
+		t.Fatalf("expected nil service for nil db")
+	}
+	rawDB, mock, cleanup := newSQLMock(t)
+	t.Cleanup(cleanup)
+	svc := NewDBAutoReplyService(wrapMySQLDB(rawDB))
+	ctx := context.Background()
+	now := time.Now()
+
+	if _, err := svc.Create(ctx, AutoReplyRule{UserID: "u1"}); !isBadRequestError(err) {
+		t.Fatalf("err=%v, want bad request", err)
+	}
+
+	mock.ExpectQuery(`SELECT COUNT\(\*\) FROM auto_reply_rule WHERE user_id = \?`).WithArgs("u1").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	expectInsertReturningID(mock, `INSERT INTO auto_reply_rule`, 5,
+		"u1", "away", 1, 0, "a,b", "hi", nil, nil, "22:00", "08:00", "稍后回复", autoReplyDefaultCooldownSeconds, sqlmock.AnyArg(), sqlmock.AnyArg())
+	mock.ExpectQuery(`FROM auto_reply_rule\s+WHERE id = \? AND user_id = \?`).WithArgs(int64(5), "u1").
+		WillReturnRows(sqlmock.NewRows(autoReplyRuleTestColumns).AddRow(5, "u1", "away", 1, 0, "a,b", "hi", nil, nil, "22:00", "08:00", "稍后回复", 60, now, now))
+	created, err := svc.Create(ctx, AutoReplyRule{UserID: "u1", Name: "away", Enabled: true, Senders: []string{"a", "b"}, Keyword: "hi", TimeStart: "22:00", TimeEnd: "08:00", ReplyTemplate: "稍后回复"})
+	if err != nil || created.ID != 5 || len(created.Senders) != 2 || !created.Enabled {
+		t.Fatalf("created=%+v err=%v", created, err)
+	}
+
+	// 启用规则只查询一次，之后命中缓存。
+	mock.ExpectQuery(`FROM auto_reply_rule\s+WHERE user_id = \?\s+ORDER BY priority DESC, id ASC`).WithArgs("u1").
+		WillReturnRows(sqlmock.NewRows(autoReplyRuleTestColumns).
+			AddRow(5, "u1", "away", 1, 0, "a,b", "hi", nil, nil, "22:00", "08:00", "稍后回复", 60, now, now).
+			AddRow(6, "u1", "off", 0, 0, nil, nil, `^x`, nil, nil, nil, "x", 60, now, now))
+	for i := 0; i < 2; i++ {
+		rules, err := svc.EnabledRules(ctx, "u1")
+		if err != nil || len(rules) != 1 || rules[0].ID != 5 {
+			t.Fatalf("rules=%+v err=%v", rules, err)
+		}
+	}
+
+	mock.ExpectExec(`UPDATE auto_reply_rule\s+SET name = \?`).
+		WithArgs("away", 0, 3, nil, nil, `^x`, "image", nil, nil, "x", 30, sqlmock.AnyArg(), int64(5), "u1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`WHERE id = \? AND user_id = \?`).WithArgs(int64(5), "u1").
+		WillReturnRows(sqlmock.NewRows(autoReplyRuleTestColumns).AddRow(5, "u1", "away", 0, 3, nil, nil, `^x`, "image", nil, nil, "x", 30, now, now))
+	updated, err := svc.Update(ctx, AutoReplyRule{ID: 5, UserID: "u1", Name: "away", Priority: 3, Regex: `^x`, MsgType: "image", ReplyTemplate: "x", CooldownSeconds: 30})
+	if err != nil || updated.Enabled || updated.Regex != `^x` || updated.re == nil {
+		t.Fatalf("updated=%+v err=%v", updated, err)
+	}
+
+	// 更新后缓存失效，重新加载。
+	mock.ExpectQuery(`WHERE user_id = \?\s+ORDER BY`).WithArgs("u1").WillReturnRows(sqlmock.NewRows(autoReplyRuleTestColumns))
+	if rules, err := svc.EnabledRules(ctx, "u1"); err != nil || len(rules) != 0 {
+		t.Fatalf("rules=%+v err=%v", rules, err)
+	}
+
+	mock.ExpectExec(`UPDATE auto_reply_rule`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(`WHERE id = \? AND user_id = \?`).WithArgs(int64(9), "u1").WillReturnRows(sqlmock.NewRows(autoReplyRuleTestColumns))
+	if _, err := svc.Update(ctx, AutoReplyRule{ID: 9, UserID: "u1", ReplyTemplate: "x"}); err != ErrAutoReplyRuleNotFound {
+		t.Fatalf("err=%v, want not found", err)
+	}
+
+	mock.ExpectExec(`DELETE FROM auto_reply_rule WHERE id = \? AND user_id = \?`).WithArgs(int64(5), "u1").WillReturnResult(sqlmock.NewResult(0, 1))
+	if err := svc.Delete(ctx, "u1", 5); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	mock.ExpectExec(`DELETE FROM auto_reply_rule`).WillReturnResult(sqlmock.NewResult(0, 0))
+	if err := svc.Delete(ctx, "u1", 5); err != ErrAutoReplyRuleNotFound {
+		t.Fatalf("err=%v, want not found", err)
+	}
+}
+
+type stubAutoReplyService struct {
+	rules []AutoReplyRule
+}
+
+func newStubAutoReplyService(rules ...AutoReplyRule) *stubAutoReplyService {
+	for i := range rules {
+		_ = normalizeAutoReplyRule(&rules[i])
+	}
+	return &stubAutoReplyService{rules: rules}
+}
+
+func (s *stubAutoReplyService) List(ctx context.Context, userID string) ([]AutoReplyRule, error) {
+	return s.rules, nil
+}
+
+func (s *stubAutoReplyService) EnabledRules(ctx context.Context, userID string) ([]AutoReplyRule, error) {
+	return s.rules, nil
+}
+
+func (s *stubAutoReplyService) Create(ctx context.Context, rule AutoReplyRule) (*AutoReplyRule, error) {
+	if err := normalizeAutoReplyRule(&rule); err != nil {
+		return nil, err
+	}
+	rule.ID = int64(len(s.rules) + 1)
+	s.rules = append(s.rules, rule)
+	return &rule, nil
+}
+
+func (s *stubAutoReplyService) Update(ctx context.Context, rule AutoReplyRule) (*AutoReplyRule, error) {
+	return nil, ErrAutoReplyRuleNotFound
+}
+
+func (s *stubAutoReplyService) Delete(ctx context.Context, userID string, id int64) error {
+	return nil
+}
+
+func TestAutoReplyEngine_E2E_RepliesOncePerCooldown(t *testing.T) {
+	fake := startFakeUpstream(t)
+	bot := fakeupstream.DefaultBots()[0]
+
+	m := NewUpstreamWebSocketManager(&http.Client{Timeout: 2 * time.Second}, "ws://unused", nil, nil, nil)
+	t.Cleanup(m.CloseAllConnections)
+	engine := NewAutoReplyEngine(newStubAutoReplyService(AutoReplyRule{UserID: "u1", Enabled: true, Keyword: "在吗", ReplyTemplate: "{{nickname}} 我暂时不在"}), m.SendToUpstream)
+	if err := m.Pipeline().Register(UpstreamStageAutoReply, engine, 7); err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+
+	session, client := newDownstreamPair(t)
+	m.RegisterDownstream("u1", session, `{"act":"sign","id":"u1","name":"Me"}`)
+	waitFor(t, "upstream sign", func() bool { return fake.Connected("u1") })
+
+	if err := fake.SendChat(bot, "u1", "在吗？"); err != nil {
+		t.Fatalf("SendChat: %v", err)
+	}
+	readDownstreamCode(t, client, 7)
+
+	isReply := func(raw string) bool {
+		return strings.Contains(raw, "touser_"+bot.ID) && strings.Contains(raw, "我暂时不在")
+	}
+	waitFor(t, "auto reply", func() bool {
+		for _, raw := range fake.Received("u1") {
+			if isReply(raw) {
+				return true
+			}
+		}
+		return false
+	})
+
+	// 同一会话冷却期内再次来信不重复回复（机器人对自动回复的回信也不会触发循环）。
+	if err := fake.SendChat(bot, "u1", "在吗在吗"); err != nil {
+		t.Fatalf("SendChat: %v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	count := 0
+	for _, raw := range fake.Received("u1") {
+		if isReply(raw) {
+			count++
+		}
+	}
+	if count != 1 {
+		t.Fatalf("auto replies=%d, want 1", count)
+	}
+}
+
+func TestAutoReplyEngine_KeepsUpstreamWithoutDownstream(t *testing.T) {
+	fake := startFakeUpstream(t)
+	bot := fakeupstream.DefaultBots()[0]
+
+	newManager := func(engine *AutoReplyEngine) *UpstreamWebSocketManager {
+		m := NewUpstreamWebSocketManager(&http.Client{Timeout: 2 * time.Second}, "ws://unused", nil, nil, nil)
+		t.Cleanup(m.CloseAllConnections)
+		m.ConfigureScheduler(IdentitySchedulerConfig{CloseDelay: 20 * time.Millisecond})
+		engine.send = m.SendToUpstream
+		if err := m.Pipeline().Register(UpstreamStageAutoReply, engine, 7); err != nil {
+			t.Fatalf("Register: %v", err)
+		}
+		m.SetKeepAlive(engine.KeepsAlive)
+		return m
+	}
+
+	// 没有启用规则的身份：最后一个下游离开后按关闭延迟断开上游。
+	idle := newManager(NewAutoReplyEngine(newStubAutoReplyService(), nil))
+	session, _ := newDownstreamPair(t)
+	idle.RegisterDownstream("u2", session, `{"act":"sign","id":"u2","name":"Idle"}`)
+	waitFor(t, "idle sign", func() bool { return fake.Connected("u2") })
+	idle.UnregisterDownstream("u2", session)
+	waitFor(t, "idle upstream closed", func() bool { return !fake.Connected("u2") })
+
+	// 启用了规则的身份：下游全部离开后仍保持上游连接并继续自动回复。
+	engine := NewAutoReplyEngine(newStubAutoReplyService(AutoReplyRule{UserID: "u1", Enabled: true, Keyword: "在吗", ReplyTemplate: "我暂时不在"}), nil)
+	m := newManager(engine)
+	session, _ = newDownstreamPair(t)
+	m.RegisterDownstream("u1", session, `{"act":"sign","id":"u1","name":"Me"}`)
+	waitFor(t, "upstream sign", func() bool { return fake.Connected("u1") })
+	m.UnregisterDownstream("u1", session)
+
+	time.Sleep(150 * time.Millisecond)
+	m.mu.Lock()
+	_, hasClient := m.upstreamClients["u1"]
+	m.mu.Unlock()
+	if !hasClient || !fake.Connected("u1") {
+		t.Fatalf("expected upstream kept alive for identity with enabled rules")
+	}
+
+	if err := fake.SendChat(bot, "u1", "在吗？"); err != nil {
+		t.Fatalf("SendChat: %v", err)
+	}
+	waitFor(t, "auto reply without downstream", func() bool {
+		for _, raw := range fake.Received("u1") {
+			if strings.Contains(raw, "touser_"+bot.ID) && strings.Contains(raw, "我暂时不在") {
+				return true
+			}
+		}
+		return false
+	})
+
+	// 上游断开后处于重连退避、且没有下游会话时，自动回复用缓存的 sign 立即重连并送达，而不是被丢弃。
+	oldDelayFn := wsReconnectDelayFn
+	wsReconnectDelayFn = func(int) time.Duration { return time.Hour }
+	t.Cleanup(func() { wsReconnectDelayFn = oldDelayFn })
+	if err := fake.Drop("u1"); err != nil {
+		t.Fatalf("Drop: %v", err)
+	}
+	waitFor(t, "reconnect backoff", func() bool {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		_, hasClient := m.upstreamClients["u1"]
+		_, waiting := m.reconnectTasks["u1"]
+		return !hasClient && waiting
+	})
+	if decision := engine.send("u1", buildTouserPayload("u1", bot.ID, bot.Nickname, "稍后回复")); decision.Blocked() {
+		t.Fatalf("reply blocked: %v", decision.Reason())
+	}
+	waitFor(t, "auto reply during backoff", func() bool {
+		if fake.SignCount("u1") != 2 {
+			return false
+		}
+		for _, raw := range fake.Received("u1") {
+			if strings.Contains(raw, "稍后回复") {
+				return true
+			}
+		}
+		return false
+	})
+	m.mu.Lock()
+	_, waiting := m.reconnectTasks["u1"]
+	m.mu.Unlock()
+	if waiting {
+		t.Fatalf("pending reconnect should be cancelled by the immediate reconnect")
+	}
+}
+
+func TestAutoReplyHandlers(t *testing.T) {
+	a := &App{}
+	rec := httptest.NewRecorder()
+	a.handleListAutoReplyRules(rec, httptest.NewRequest(http.MethodGet, "/api/autoReply/list?userId=u1", nil))
+	if got := decodeJSONBody(t, rec.Body); toInt(got["code"]) != -1 {
+		t.Fatalf("got=%v", got)
+	}
+
+	svc := newStubAutoReplyService(AutoReplyRule{UserID: "u1", Name: "night", Enabled: true, TimeStart: "22:00", TimeEnd: "08:00", ReplyTemplate: "晚安 {{nickname}}"})
+	svc.rules[0].ID = 1
+	a.autoReply = svc
+	a.autoReplyEngine = NewAutoReplyEngine(svc, nil)
+
+	rec = httptest.NewRecorder()
+	a.handleListAutoReplyRules(rec, httptest.NewRequest(http.MethodGet, "/api/autoReply/list", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("status=%d, want 400", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	a.handleCreateAutoReplyRule(rec, httptest.NewRequest(http.MethodPost, "/api/autoReply/create", strings.NewReader(`{"userId":"u1","replyTemplate":"x","regex":"("}`)))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("status=%d, want 400", rec.Code)
+	}
+	rec = httptest.NewRecorder()
+	a.handleCreateAutoReplyRule(rec, httptest.NewRequest(http.MethodPost, "/api/autoReply/create", strings.NewReader(`{"userId":"u1","replyTemplate":"x","enabled":true}`)))
+	if got := decodeJSONBody(t, rec.Body); toInt(got["code"]) != 0 {
+		t.Fatalf("got=%v", got)
+	}
+	rec = httptest.NewRecorder()
+	a.handleUpdateAutoReplyRule(rec, httptest.NewRequest(http.MethodPost, "/api/autoReply/update", strings.NewReader(`{"id":9,"userId":"u1","replyTemplate":"x"}`)))
+	if got := decodeJSONBody(t, rec.Body); toInt(got["code"]) != -1 {
+		t.Fatalf("got=%v", got)
+	}
+	rec = httptest.NewRecorder()
+	a.handleDeleteAutoReplyRule(rec, httptest.NewRequest(http.MethodPost, "/api/autoReply/delete", strings.NewReader(`{"userId":"u1"}`)))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("status=%d, want 400", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	a.handleDryRunAutoReply(rec, httptest.NewRequest(http.MethodPost, "/api/autoReply/dryRun", strings.NewReader(`{"userId":"u1","fromUserId":"p","nickname":"小王","content":"hi","time":"23:00"}`)))
+	got := decodeJSONBody(t, rec.Body)
+	data, _ := got["data"].(map[string]any)
+	if toInt(got["code"]) != 0 || data["matched"] != true || data["reply"] != "晚安 小王" || toInt(data["ruleId"]) != 1 {
+		t.Fatalf("got=%v", got)
+	}
+
+	// 携带未保存规则时只评估该规则。
+	rec = httptest.NewRecorder()
+	a.handleDryRunAutoReply(rec, httptest.NewRequest(http.MethodPost, "/api/autoReply/dryRun", strings.NewReader(`{"userId":"u1","fromUserId":"p","content":"hi","time":"12:00","rule":{"enabled":true,"keyword":"bye","replyTemplate":"x"}}`)))
+	got = decodeJSONBody(t, rec.Body)
+	data, _ = got["data"].(map[string]any)
+	if toInt(got["code"]) != 0 || data["matched"] != false || len(data["rules"].([]any)) != 1 {
+		t.Fatalf("got=%v", got)
+	}
+
+	rec = httptest.NewRecorder()
+	a.handleDryRunAutoReply(rec, httptest.NewRequest(http.MethodPost, "/api/autoReply/dryRun", strings.NewReader(`{"userId":"u1","fromUserId":"p","time":"noon"}`)))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("status=%d, want 400", rec.Code)
+	}
+}
@@ -203,6 +203,9 @@ type DouyinDownloaderService struct {
 
 	cookieProvider DouyinCookieProvider
 
+	// urlSigner 非空时为生成的 /api/douyin/cover、/api/douyin/download 地址追加签名。
+	urlSigner *MediaURLSigner
+
 	// Prevent thundering herd when many requests hit an expired CDN URL at the same time.
 	refreshDetailGroup singleflight.Group
 }
@@ -342,6 +345,21 @@ func (s *DouyinDownloaderService) SetCookieProvider(p DouyinCookieProvider) {
 	s.cookieProvider = p
 }
 
+// SetURLSigner 设置为封面与下载代理地址签名的签名器；nil 时返回未签名地址。
+func (s *DouyinDownloaderService) SetURLSigner(signer *MediaURLSigner) {
+	if s == nil {
+		return
+	}
+	s.urlSigner = signer
+}
+
+func (s *DouyinDownloaderService) signURL(rawURL string) string {
+	if s == nil {
+		return rawURL
+	}
+	return s.urlSigner.SignURL(rawURL)
+}
+
 func (s *DouyinDownloaderService) effectiveCookie(ctx context.Context, cookie string) (string, error) {
 	if v := strings.TrimSpace(cookie); v != "" {
 		return v, nil
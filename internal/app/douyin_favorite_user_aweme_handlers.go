@@ -228,7 +228,7 @@ func (a *App) handleDouyinFavoriteUserAwemeList(w http.ResponseWriter, r *http.R
 			if key != "" {
 				it.Key = key
 				if strings.TrimSpace(it.CoverURL) != "" {
-					it.CoverDownloadURL = fmt.Sprintf("/api/douyin/cover?key=%s", url.QueryEscape(key))
+					it.CoverDownloadURL = a.signMediaURL(fmt.Sprintf("/api/douyin/cover?key=%s", url.QueryEscape(key)))
 				}
 
 				previewItems := make([]douyinMediaItem, 0, len(downloads))
@@ -250,7 +250,7 @@ func (a *App) handleDouyinFavoriteUserAwemeList(w http.ResponseWriter, r *http.R
 						Index:            i,
 						Type:             mediaType,
 						URL:              u,
-						DownloadURL:      fmt.Sprintf("/api/douyin/download?key=%s&index=%d", url.QueryEscape(key), i),
+						DownloadURL:      a.signMediaURL(fmt.Sprintf("/api/douyin/download?key=%s&index=%d", url.QueryEscape(key), i)),
 						OriginalFilename: buildDouyinOriginalFilename(strings.TrimSpace(it.Desc), it.DetailID, i, len(downloads), ext),
 					})
 				}
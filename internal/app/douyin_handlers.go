@@ -1002,7 +1002,7 @@ func extractDouyinAccountItems(s *DouyinDownloaderService, secUserID string, dat
 			key = s.CacheDetail(cached)
 			if key != "" {
 				if strings.TrimSpace(cover) != "" {
-					coverDownloadURL = fmt.Sprintf("/api/douyin/cover?key=%s", url.QueryEscape(key))
+					coverDownloadURL = s.signURL(fmt.Sprintf("/api/douyin/cover?key=%s", url.QueryEscape(key)))
 				}
 
 				previewItems = make([]douyinMediaItem, 0, len(downloads))
@@ -1023,7 +1023,7 @@ func extractDouyinAccountItems(s *DouyinDownloaderService, secUserID string, dat
 						Index:            i,
 						Type:             mediaType,
 						URL:              u,
-						DownloadURL:      fmt.Sprintf("/api/douyin/download?key=%s&index=%d", url.QueryEscape(key), i),
+						DownloadURL:      s.signURL(fmt.Sprintf("/api/douyin/download?key=%s&index=%d", url.QueryEscape(key), i)),
 						OriginalFilename: buildDouyinOriginalFilename(desc, id, i, len(downloads), ext),
 					})
 				}
@@ -1216,7 +1216,7 @@ func (a *App) handleDouyinDetail(w http.ResponseWriter, r *http.Request) {
 			Index:            i,
 			Type:             mediaType,
 			URL:              u,
-			DownloadURL:      fmt.Sprintf("/api/douyin/download?key=%s&index=%d", url.QueryEscape(key), i),
+			DownloadURL:      a.signMediaURL(fmt.Sprintf("/api/douyin/download?key=%s&index=%d", url.QueryEscape(key), i)),
 			OriginalFilename: buildDouyinOriginalFilename(detail.Title, detail.DetailID, i, len(detail.Downloads), ext),
 		})
 	}
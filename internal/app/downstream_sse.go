@@ -0,0 +1,257 @@
+package app
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// sseKeepAliveInterval 为 SSE 注释行保活间隔，避免代理因空闲断开长连接。
+	sseKeepAliveInterval = 15 * time.Second
+	// sseRetryMillis 为告知浏览器 EventSource 的重连间隔。
+	sseRetryMillis = 3000
+	// sseMaxFrameBytes 为 POST 上行单帧大小上限。
+	sseMaxFrameBytes = 64 << 10
+)
+
+var errSSEClosed = errors.New("sse: stream closed")
+
+// sseDownstreamTransport 为基于 Server-Sent Events 的下游传输：下行帧以 data 事件推送，上行帧经 POST /sse/send 提交。
+type sseDownstreamTransport struct {
+	mu     sync.Mutex
+	w      http.ResponseWriter
+	rc     *http.ResponseController
+	closed bool
+	done   chan struct{}
+}
+
+func newSSEDownstreamTransport(w http.ResponseWriter) *sseDownstreamTransport {
+	return &sseDownstreamTransport{w: w, rc: http.NewResponseController(w), done: make(chan struct{})}
+}
+
+func (t *sseDownstreamTransport) WriteText(message string) error {
+	return t.writeEvent("", message)
+}
+
+// writeEvent 写出一个 SSE 事件；event 为空时为默认 message 事件，多行数据拆成多条 data 行。
+func (t *sseDownstreamTransport) writeEvent(event string, data string) error {
+	var b strings.Builder
+	if event != "" {
+		b.WriteString("event: " + event + "\n")
+	}
+	for _, line := range strings.Split(data, "\n") {
+		b.WriteString("data: " + strings.TrimSuffix(line, "\r") + "\n")
+	}
+	b.WriteString("\n")
+	return t.write(b.String())
+}
+
+func (t *sseDownstreamTransport) write(chunk string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		return errSSEClosed
+	}
+	_ = t.rc.SetWriteDeadline(time.Now().Add(wsDownstreamWriteDeadline))
+	if _, err := io.WriteString(t.w, chunk); err != nil {
+		return err
+	}
+	return t.rc.Flush()
+}
+
+func (t *sseDownstreamTransport) CloseWithCode(code int, reason string) error {
+	raw, _ := json.Marshal(map[string]any{"code": code, "reason": reason})
+	err := t.writeEvent("close", string(raw))
+	_ = t.Close()
+	return err
+}
+
+// Close 标记流结束并通知 handleSSE 返回；之后的写入均返回 errSSEClosed。
+func (t *sseDownstreamTransport) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.closed {
+		t.closed = true
+		close(t.done)
+	}
+	return nil
+}
+
+func (t *sseDownstreamTransport) Kind() string {
+	return "sse"
+}
+
+// sseSession 为一条 SSE 下行流及其上行帧处理器；token/loginSessionID 用于校验 POST 提交者与建流者一致。
+type sseSession struct {
+	token string
+	// loginSessionID 为建流时的登录会话；access token 刷新后仍属同一会话，因此优先按会话比对。
+	loginSessionID string
+	inbound        *downstreamInbound
+}
+
+func (s *sseSession) ownedBy(token string, principal AuthPrincipal) bool {
+	if s.loginSessionID != "" {
+		return principal.SessionID == s.loginSessionID
+	}
+	return s.token == token
+}
+
+// sseSessionRegistry 按 sessionId 索引活跃的 SSE 会话。
+type sseSessionRegistry struct {
+	mu       sync.Mutex
+	sessions map[string]*sseSession
+}
+
+func newSSESessionRegistry() *sseSessionRegistry {
+	return &sseSessionRegistry{sessions: make(map[string]*sseSession)}
+}
+
+func (r *sseSessionRegistry) add(s *sseSession) (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	id := hex.EncodeToString(buf)
+	r.mu.Lock()
+	r.sessions[id] = s
+	r.mu.Unlock()
+	return id, nil
+}
+
+func (r *sseSessionRegistry) get(id string) *sseSession {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.sessions[id]
+}
+
+func (r *sseSessionRegistry) remove(id string) {
+	r.mu.Lock()
+	delete(r.sessions, id)
+	r.mu.Unlock()
+}
+
+// requestBearerToken 从 Authorization: Bearer 或查询参数 token 中取出 JWT（EventSource 无法自定义请求头）。
+func requestBearerToken(r *http.Request) string {
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimSpace(strings.TrimPrefix(auth, "Bearer "))
+	}
+	return strings.TrimSpace(r.URL.Query().Get("token"))
+}
+
+// handleSSE 为无法使用 WebSocket 的客户端建立 SSE 下行流：首个 session 事件返回 sessionId，
+// 之后推送与 /ws 相同的帧；上行帧通过 handleSSESend 提交，sign/observe/过滤等语义与 /ws 一致。
+func (a *App) handleSSE(w http.ResponseWriter, r *http.Request) {
+	if a.sseSessions == nil {
+		http.Error(w, "SSE服务未初始化", http.StatusServiceUnavailable)
+		return
+	}
+	if !a.wsGuard.CheckOrigin(r) {
+		http.Error(w, "SSE连接来源不允许", http.StatusForbidden)
+		return
+	}
+	token := requestBearerToken(r)
+	principal, ok := a.authenticateToken(r.Context(), token)
+	if token == "" || !ok {
+		http.Error(w, "SSE连接Token无效", http.StatusUnauthorized)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	transport := newSSEDownstreamTransport(w)
+	defer transport.Close()
+	if err := transport.write(fmt.Sprintf("retry: %d\n\n", sseRetryMillis)); err != nil {
+		return
+	}
+	if !a.wsGuard.acquire(principal.guardKey(token)) {
+		slog.Warn("同一Token下游会话数超限，拒绝SSE连接", "remote", r.RemoteAddr)
+		_ = transport.CloseWithCode(WSCloseTooManySessions, "会话数超限")
+		return
+	}
+	defer a.wsGuard.release(principal.guardKey(token))
+
+	session := NewDownstreamSession(transport)
+	a.authConns.add(principal.SessionID, session)
+	defer a.authConns.remove(principal.SessionID, session)
+	inbound := a.newDownstreamInbound(session, r.RemoteAddr, principal)
+	id, err := a.sseSessions.add(&sseSession{token: token, loginSessionID: principal.SessionID, inbound: inbound})
+	if err != nil {
+		slog.Error("创建SSE会话失败", "error", err)
+		return
+	}
+	defer func() {
+		a.sseSessions.remove(id)
+		inbound.detach()
+		_ = session.Close()
+	}()
+
+	raw, _ := json.Marshal(map[string]string{"sessionId": id})
+	session.writeMu.Lock()
+	err = transport.writeEvent("session", string(raw))
+	session.writeMu.Unlock()
+	if err != nil {
+		return
+	}
+
+	ticker := time.NewTicker(sseKeepAliveInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-transport.done:
+			return
+		case <-ticker.C:
+			if err := transport.write(": ping\n\n"); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// handleSSESend 接收 SSE 会话的上行帧（请求体为与 /ws 相同的 JSON 文本帧）。
+func (a *App) handleSSESend(w http.ResponseWriter, r *http.Request) {
+	if a.sseSessions == nil {
+		writeJSON(w, http.StatusOK, map[string]any{"code": -1, "msg": "SSE服务未初始化"})
+		return
+	}
+	if !a.wsGuard.CheckOrigin(r) {
+		writeJSON(w, http.StatusForbidden, map[string]any{"code": 403, "msg": "SSE连接来源不允许"})
+		return
+	}
+	token := requestBearerToken(r)
+	principal, ok := a.authenticateToken(r.Context(), token)
+	if token == "" || !ok {
+		writeJSON(w, http.StatusUnauthorized, map[string]any{"code": 401, "msg": "SSE连接Token无效"})
+		return
+	}
+	session := a.sseSessions.get(strings.TrimSpace(r.URL.Query().Get("sessionId")))
+	if session == nil || !session.ownedBy(token, principal) {
+		writeJSON(w, http.StatusNotFound, map[string]any{"code": 404, "msg": "SSE会话不存在或已关闭"})
+		return
+	}
+
+	payload, err := io.ReadAll(http.MaxBytesReader(w, r.Body, sseMaxFrameBytes))
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"code": 400, "msg": "读取消息失败"})
+		return
+	}
+	if !session.inbound.allow() {
+		writeJSON(w, http.StatusTooManyRequests, map[string]any{"code": 429, "msg": "消息过于频繁"})
+		return
+	}
+	session.inbound.handleFrame(payload)
+	writeJSON(w, http.StatusOK, map[string]any{"code": 0, "msg": "success"})
+}
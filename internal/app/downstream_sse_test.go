@@ -0,0 +1,183 @@
+package app
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// readSSEEvent 读取下一个带 data 的 SSE 事件，跳过注释与 retry 行。
+func readSSEEvent(t *testing.T, reader *bufio.Reader) (string, string) {
+	t.Helper()
+	type result struct {
+		event, data string
+		err         error
+	}
+	ch := make(chan result, 1)
+	go func() {
+		var event string
+		var data []string
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				ch <- result{err: err}
+				return
+			}
+			line = strings.TrimRight(line, "\r\n")
+			switch {
+			case line == "":
+				if len(data) > 0 {
+					ch <- result{event: event, data: strings.Join(data, "\n")}
+					return
+				}
+				event = ""
+			case strings.HasPrefix(line, "event: "):
+				event = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "data: "):
+				data = append(data, strings.TrimPrefix(line, "data: "))
+			}
+		}
+	}()
+	select {
+	case r := <-ch:
+		if r.err != nil {
+			t.Fatalf("read sse: %v", r.err)
+		}
+		return r.event, r.data
+	case <-time.After(3 * time.Second):
+		t.Fatalf("timeout waiting sse event")
+		return "", ""
+	}
+}
+
+func TestSSEDownstreamTransport_WriteAndClose(t *testing.T) {
+	rec := httptest.NewRecorder()
+	transport := newSSEDownstreamTransport(rec)
+	if err := transport.WriteText("a\nb"); err != nil {
+		t.Fatalf("WriteText: %v", err)
+	}
+	if err := transport.CloseWithCode(WSCloseRateLimited, "slow down"); err != nil {
+		t.Fatalf("CloseWithCode: %v", err)
+	}
+	want := "data: a\ndata: b\n\nevent: close\ndata: {\"code\":4429,\"reason\":\"slow down\"}\n\n"
+	if rec.Body.String() != want || !rec.Flushed {
+		t.Fatalf("body=%q flushed=%v", rec.Body.String(), rec.Flushed)
+	}
+	if err := transport.WriteText("x"); err != errSSEClosed {
+		t.Fatalf("err=%v", err)
+	}
+	select {
+	case <-transport.done:
+	default:
+		t.Fatalf("done not closed")
+	}
+}
+
+func TestHandleSSE_RoundTrip(t *testing.T) {
+	received := make(chan string, 10)
+	tracker := &wsConnTracker{}
+	upstream := newUpstreamWSServer(t, func(conn *websocket.Conn) {
+		tracker.add(conn)
+		for {
+			_, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			received <- string(data)
+			_ = conn.WriteMessage(websocket.TextMessage, []byte(`{"code":12,"content":"echo"}`))
+		}
+	})
+	t.Cleanup(func() {
+		tracker.closeAll()
+		upstream.Close()
+	})
+
+	wsManager := NewUpstreamWebSocketManager(nil, toWSURL(upstream.URL), nil, nil, nil)
+	t.Cleanup(wsManager.CloseAllConnections)
+	jwtService := NewJWTService("secret-1", 1)
+	token, err := jwtService.GenerateToken()
+	if err != nil {
+		t.Fatalf("GenerateToken failed: %v", err)
+	}
+	app := &App{jwt: jwtService, wsManager: wsManager, sseSessions: newSSESessionRegistry()}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /sse", app.handleSSE)
+	mux.HandleFunc("POST /sse/send", app.handleSSESend)
+	backend := httptest.NewServer(mux)
+	t.Cleanup(backend.Close)
+
+	if resp, err := http.Get(backend.URL + "/sse?token=bad"); err != nil || resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected 401, resp=%v err=%v", resp, err)
+	}
+
+	resp, err := http.Get(backend.URL + "/sse?token=" + url.QueryEscape(token))
+	if err != nil {
+		t.Fatalf("GET /sse: %v", err)
+	}
+	t.Cleanup(func() { _ = resp.Body.Close() })
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("content-type=%q", ct)
+	}
+	reader := bufio.NewReader(resp.Body)
+	event, data := readSSEEvent(t, reader)
+	var hello struct {
+		SessionID string `json:"sessionId"`
+	}
+	if err := json.Unmarshal([]byte(data), &hello); event != "session" || err != nil || hello.SessionID == "" {
+		t.Fatalf("event=%s data=%s", event, data)
+	}
+
+	send := func(sessionID, authToken, frame string) int {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodPost, backend.URL+"/sse/send?sessionId="+sessionID, strings.NewReader(frame))
+		req.Header.Set("Authorization", "Bearer "+authToken)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("POST /sse/send: %v", err)
+		}
+		_ = resp.Body.Close()
+		return resp.StatusCode
+	}
+	otherToken, _ := NewJWTService("secret-1", 2).GenerateToken()
+	if code := send("missing", token, `{"act":"sign","id":"u1"}`); code != http.StatusNotFound {
+		t.Fatalf("missing session code=%d", code)
+	}
+	if otherToken != token {
+		if code := send(hello.SessionID, otherToken, `{"act":"sign","id":"u1"}`); code != http.StatusNotFound {
+			t.Fatalf("foreign token code=%d", code)
+		}
+	}
+
+	if code := send(hello.SessionID, token, `{"act":"sign","id":"u1"}`); code != http.StatusOK {
+		t.Fatalf("sign code=%d", code)
+	}
+	select {
+	case got := <-received:
+		if got != `{"act":"sign","id":"u1"}` {
+			t.Fatalf("upstream got %s", got)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("timeout waiting sign")
+	}
+	for {
+		_, data = readSSEEvent(t, reader)
+		if strings.Contains(data, `"code":12`) {
+			break
+		}
+	}
+	if transports := wsManager.GetConnectionStats()["transports"].(map[string]int); transports["sse"] != 1 {
+		t.Fatalf("transports=%v", transports)
+	}
+
+	_ = resp.Body.Close()
+	waitFor(t, "sse session detached", func() bool {
+		return wsManager.GetConnectionStats()["downstream"] == 0 && app.sseSessions.get(hello.SessionID) == nil
+	})
+}
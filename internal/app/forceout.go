@@ -1,21 +1,77 @@
 package app
 
 import (
+	"context"
+	"log/slog"
 	"sync"
 	"time"
 )
 
 type ForceoutManager struct {
-	mu    sync.Mutex
-	users map[string]time.Time
+	mu       sync.Mutex
+	users    map[string]time.Time
+	duration time.Duration
+	store    ForceoutEventStore
 }
 
 func NewForceoutManager() *ForceoutManager {
-	return &ForceoutManager{users: make(map[string]time.Time)}
+	return &ForceoutManager{users: make(map[string]time.Time), duration: forceoutDuration}
 }
 
 const forceoutDuration = 5 * time.Minute
 
+var forceoutStoreTimeout = 3 * time.Second
+
+// SetDuration 设置 forceout 禁止时长；非正数时恢复默认 5 分钟。
+func (m *ForceoutManager) SetDuration(d time.Duration) {
+	if d <= 0 {
+		d = forceoutDuration
+	}
+	m.mu.Lock()
+	m.duration = d
+	m.mu.Unlock()
+}
+
+// Duration 返回当前 forceout 禁止时长。
+func (m *ForceoutManager) Duration() time.Duration {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.duration
+}
+
+// SetStore 设置持久化存储，并加载仍在禁止期内的身份（重启后恢复禁止状态）。
+func (m *ForceoutManager) SetStore(store ForceoutEventStore) error {
+	m.mu.Lock()
+	m.store = store
+	m.mu.Unlock()
+	if store == nil {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), forceoutStoreTimeout)
+	defer cancel()
+	bans, err := store.ActiveBans(ctx)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	for userID, until := range bans {
+		if until.After(m.users[userID]) {
+			m.users[userID] = until
+		}
+	}
+	m.mu.Unlock()
+	return nil
+}
+
+// Store 返回持久化存储（未配置时为 nil）。
+func (m *ForceoutManager) Store() ForceoutEventStore {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.store
+}
+
 func (m *ForceoutManager) IsForbidden(userID string) bool {
 	m.mu.Lock()
 	defer m.mu.Unlock()
@@ -32,9 +88,25 @@ func (m *ForceoutManager) IsForbidden(userID string) bool {
 }
 
 func (m *ForceoutManager) AddForceoutUser(userID string) {
+	m.RecordForceout(userID, "")
+}
+
+// RecordForceout 记录一次 forceout：先更新内存禁止期，再写入持久化存储（失败仅记录日志）。
+func (m *ForceoutManager) RecordForceout(userID string, rawMessage string) {
 	m.mu.Lock()
-	m.users[userID] = time.Now().Add(forceoutDuration)
+	until := time.Now().Add(m.duration)
+	m.users[userID] = until
+	store := m.store
 	m.mu.Unlock()
+
+	if store == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), forceoutStoreTimeout)
+	defer cancel()
+	if err := store.Record(ctx, userID, rawMessage, until); err != nil {
+		slog.Warn("记录 forceout 事件失败", "userId", userID, "error", err)
+	}
 }
 
 func (m *ForceoutManager) RemainingSeconds(userID string) int64 {
@@ -55,9 +127,18 @@ func (m *ForceoutManager) RemainingSeconds(userID string) int64 {
 
 func (m *ForceoutManager) ClearAllForceout() int {
 	m.mu.Lock()
-	defer m.mu.Unlock()
 	count := len(m.users)
 	m.users = make(map[string]time.Time)
+	store := m.store
+	m.mu.Unlock()
+
+	if store != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), forceoutStoreTimeout)
+		defer cancel()
+		if _, err := store.ClearActive(ctx); err != nil {
+			slog.Warn("解除持久化 forceout 禁止失败", "error", err)
+		}
+	}
 	return count
 }
 
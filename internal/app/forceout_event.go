@@ -0,0 +1,278 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"liao/internal/database"
+)
+
+const (
+	forceoutEventDefaultListLimit = 100
+	forceoutEventMaxListLimit     = 500
+)
+
+// ForceoutEvent 为一次上游 forceout 记录。
+type ForceoutEvent struct {
+	ID          int64  `json:"id"`
+	UserID      string `json:"userId"`
+	RawMessage  string `json:"rawMessage"`
+	BannedUntil string `json:"bannedUntil"`
+	ClearedTime string `json:"clearedTime,omitempty"`
+	CreateTime  string `json:"createTime"`
+}
+
+// ForceoutUserSummary 为某身份的 forceout 汇总。
+type ForceoutUserSummary struct {
+	UserID           string `json:"userId"`
+	Count            int64  `json:"count"`
+	FirstTime        string `json:"firstTime"`
+	LastTime         string `json:"lastTime"`
+	BannedUntil      string `json:"bannedUntil,omitempty"`
+	RemainingSeconds int64  `json:"remainingSeconds"`
+	Active           bool   `json:"active"`
+}
+
+// ForceoutUserQuery 为 forceout 汇总查询条件；UserID 为空时返回全部身份，ActiveOnly 只返回禁止期内的身份。
+type ForceoutUserQuery struct {
+	UserID     string
+	ActiveOnly bool
+	Since      time.Time
+	Limit      int
+}
+
+// ForceoutEventStore 定义 forceout 事件与禁止期的持久化能力；禁止期由最近一条未解除事件的 banned_until 决定。
+type ForceoutEventStore interface {
+	Record(ctx context.Context, userID string, rawMessage string, bannedUntil time.Time) error
+	ActiveBans(ctx context.Context) (map[string]time.Time, error)
+	ActiveUntil(ctx context.Context, userID string) (time.Time, bool, error)
+	ClearActive(ctx context.Context) (int64, error)
+	ListUsers(ctx context.Context, query ForceoutUserQuery) ([]ForceoutUserSummary, error)
+	ListEvents(ctx context.Context, userID string, limit int) ([]ForceoutEvent, error)
+}
+
+// DBForceoutEventService 基于数据库实现 ForceoutEventStore。
+type DBForceoutEventService struct {
+	db *database.DB
+}
+
+// NewDBForceoutEventService 创建数据库 forceout 事件服务。
+func NewDBForceoutEventService(db *database.DB) *DBForceoutEventService {
+	if db == nil {
+		return nil
+	}
+	return &DBForceoutEventService{db: db}
+}
+
+func (s *DBForceoutEventService) Record(ctx context.Context, userID string, rawMessage string, bannedUntil time.Time) error {
+	if s == nil || s.db == nil {
+		return fmt.Errorf("db not initialized")
+	}
+	userID = strings.TrimSpace(userID)
+	if userID == "" {
+		return fmt.Errorf("userId 不能为空")
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO forceout_event (user_id, raw_message, banned_until, created_at)
+		VALUES (?, ?, ?, ?)
+	`, userID, nullIfEmpty(rawMessage), bannedUntil, time.Now())
+	return err
+}
+
+// ActiveBans 返回当前仍在禁止期内的身份及其截止时间。
+func (s *DBForceoutEventService) ActiveBans(ctx context.Context) (map[string]time.Time, error) {
+	if s == nil || s.db == nil {
+		return nil, fmt.Errorf("db not initialized")
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT user_id, MAX(banned_until)
+		FROM forceout_event
+		WHERE banned_until > ? AND cleared_at IS NULL
+		GROUP BY user_id
+	`, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make(map[string]time.Time)
+	for rows.Next() {
+		var userID string
+		var until sql.NullTime
+		if err := rows.Scan(&userID, &until); err != nil {
+			return nil, err
+		}
+		if until.Valid {
+			out[userID] = until.Time
+		}
+	}
+	return out, rows.Err()
+}
+
+// ActiveUntil 返回身份的禁止截止时间；不在禁止期时 ok=false。
+func (s *DBForceoutEventService) ActiveUntil(ctx context.Context, userID string) (time.Time, bool, error) {
+	if s == nil || s.db == nil {
+		return time.Time{}, false, fmt.Errorf("db not initialized")
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	var until sql.NullTime
+	err := s.db.QueryRowContext(ctx, `
+		SELECT MAX(banned_until)
+		FROM forceout_event
+		WHERE user_id = ? AND banned_until > ? AND cleared_at IS NULL
+	`, strings.TrimSpace(userID), time.Now()).Scan(&until)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	if !until.Valid {
+		return time.Time{}, false, nil
+	}
+	return until.Time, true, nil
+}
+
+// ClearActive 解除全部禁止期（保留事件记录），返回受影响的事件数。
+func (s *DBForceoutEventService) ClearActive(ctx context.Context) (int64, error) {
+	if s == nil || s.db == nil {
+		return 0, fmt.Errorf("db not initialized")
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	now := time.Now()
+	res, err := s.db.ExecContext(ctx, `
+		UPDATE forceout_event SET cleared_at = ? WHERE banned_until > ? AND cleared_at IS NULL
+	`, now, now)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// ListUsers 按最近一次 forceout 时间倒序返回各身份的汇总。
+func (s *DBForceoutEventService) ListUsers(ctx context.Context, query ForceoutUserQuery) ([]ForceoutUserSummary, error) {
+	if s == nil || s.db == nil {
+		return nil, fmt.Errorf("db not initialized")
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	now := time.Now()
+	var where strings.Builder
+	where.WriteString("1 = 1")
+	args := []any{now}
+	if userID := strings.TrimSpace(query.UserID); userID != "" {
+		where.WriteString(" AND user_id = ?")
+		args = append(args, userID)
+	}
+	if !query.Since.IsZero() {
+		where.WriteString(" AND created_at >= ?")
+		args = append(args, query.Since)
+	}
+	having := ""
+	if query.ActiveOnly {
+		having = "HAVING MAX(CASE WHEN cleared_at IS NULL AND banned_until > ? THEN 1 ELSE 0 END) = 1"
+		args = append(args, now)
+	}
+	args = append(args, normalizeForceoutEventLimit(query.Limit))
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT user_id, COUNT(*), MIN(created_at), MAX(created_at),
+			MAX(CASE WHEN cleared_at IS NULL AND banned_until > ? THEN banned_until END)
+		FROM forceout_event
+		WHERE `+where.String()+`
+		GROUP BY user_id
+		`+having+`
+		ORDER BY MAX(created_at) DESC
+		LIMIT ?
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]ForceoutUserSummary, 0)
+	for rows.Next() {
+		var item ForceoutUserSummary
+		var first, last, until sql.NullTime
+		if err := rows.Scan(&item.UserID, &item.Count, &first, &last, &until); err != nil {
+			return nil, err
+		}
+		item.FirstTime = formatNullLocalDateTimeISO(first)
+		item.LastTime = formatNullLocalDateTimeISO(last)
+		if until.Valid {
+			item.Active = true
+			item.BannedUntil = formatNullLocalDateTimeISO(until)
+			item.RemainingSeconds = int64(until.Time.Sub(now).Seconds())
+		}
+		out = append(out, item)
+	}
+	return out, rows.Err()
+}
+
+// ListEvents 按时间倒序返回某身份的 forceout 事件。
+func (s *DBForceoutEventService) ListEvents(ctx context.Context, userID string, limit int) ([]ForceoutEvent, error) {
+	if s == nil || s.db == nil {
+		return nil, fmt.Errorf("db not initialized")
+	}
+	userID = strings.TrimSpace(userID)
+	if userID == "" {
+		return nil, fmt.Errorf("userId 不能为空")
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, user_id, raw_message, banned_until, cleared_at, created_at
+		FROM forceout_event
+		WHERE user_id = ?
+		ORDER BY created_at DESC, id DESC
+		LIMIT ?
+	`, userID, normalizeForceoutEventLimit(limit))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]ForceoutEvent, 0)
+	for rows.Next() {
+		var item ForceoutEvent
+		var raw sql.NullString
+		var until, cleared, created sql.NullTime
+		if err := rows.Scan(&item.ID, &item.UserID, &raw, &until, &cleared, &created); err != nil {
+			return nil, err
+		}
+		item.RawMessage = raw.String
+		item.BannedUntil = formatNullLocalDateTimeISO(until)
+		item.ClearedTime = formatNullLocalDateTimeISO(cleared)
+		item.CreateTime = formatNullLocalDateTimeISO(created)
+		out = append(out, item)
+	}
+	return out, rows.Err()
+}
+
+func normalizeForceoutEventLimit(limit int) int {
+	if limit <= 0 {
+		return forceoutEventDefaultListLimit
+	}
+	if limit > forceoutEventMaxListLimit {
+		return forceoutEventMaxListLimit
+	}
+	return limit
+}
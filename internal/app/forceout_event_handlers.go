@@ -0,0 +1,64 @@
+package app
+
+import (
+	"net/http"
+	"strings"
+)
+
+func (a *App) handleListForceoutUsers(w http.ResponseWriter, r *http.Request) {
+	if a.forceoutManager == nil || a.forceoutManager.Store() == nil {
+		writeJSON(w, http.StatusOK, map[string]any{"code": -1, "msg": "Forceout事件存储未初始化"})
+		return
+	}
+
+	query := ForceoutUserQuery{
+		UserID:     strings.TrimSpace(r.URL.Query().Get("userId")),
+		ActiveOnly: strings.EqualFold(strings.TrimSpace(r.URL.Query().Get("activeOnly")), "true"),
+		Limit:      parseIntDefault(r.URL.Query().Get("limit"), forceoutEventDefaultListLimit),
+	}
+	items, err := a.forceoutManager.Store().ListUsers(r.Context(), query)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]any{"code": -1, "msg": "查询Forceout记录失败: " + err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"code": 0,
+		"msg":  "success",
+		"data": items,
+	})
+}
+
+func (a *App) handleGetForceoutDetail(w http.ResponseWriter, r *http.Request) {
+	if a.forceoutManager == nil {
+		writeJSON(w, http.StatusOK, map[string]any{"code": -1, "msg": "Forceout管理器未初始化"})
+		return
+	}
+
+	userID := strings.TrimSpace(r.URL.Query().Get("userId"))
+	if userID == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"code": 400, "msg": "userId不能为空"})
+		return
+	}
+
+	events := make([]ForceoutEvent, 0)
+	if store := a.forceoutManager.Store(); store != nil {
+		limit := parseIntDefault(r.URL.Query().Get("limit"), forceoutEventDefaultListLimit)
+		items, err := store.ListEvents(r.Context(), userID, limit)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]any{"code": -1, "msg": "查询Forceout记录失败: " + err.Error()})
+			return
+		}
+		events = items
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"code": 0,
+		"msg":  "success",
+		"data": map[string]any{
+			"userId":           userID,
+			"forbidden":        a.forceoutManager.IsForbidden(userID),
+			"remainingSeconds": a.forceoutManager.RemainingSeconds(userID),
+			"banSeconds":       int64(a.forceoutManager.Duration().Seconds()),
+			"events":           events,
+		},
+	})
+}
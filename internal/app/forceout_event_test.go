@@ -0,0 +1,211 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestDBForceoutEventService_RecordAndActive(t *testing.T) {
+	if NewDBForceoutEventService(nil) != nil {
+		t.Fatalf("expected nil service for nil db")
+	}
+	svc, mock := newMockDBService(t, NewDBForceoutEventService)
+
+	if err := svc.Record(context.Background(), " ", "x", time.Now()); err == nil {
+		t.Fatalf("expected userId validation error")
+	}
+
+	until := time.Now().Add(5 * time.Minute)
+	mock.ExpectExec(`INSERT INTO forceout_event \(user_id, raw_message, banned_until, created_at\)`).
+		WithArgs("u1", `{"code":-3}`, until, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	if err := svc.Record(context.Background(), " u1 ", `{"code":-3}`, until); err != nil {
+		t.Fatalf("Record: %v", err)
+	}
+
+	mock.ExpectQuery(`SELECT user_id, MAX\(banned_until\)\s+FROM forceout_event\s+WHERE banned_until > \? AND cleared_at IS NULL\s+GROUP BY user_id`).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "banned_until"}).AddRow("u1", until).AddRow("u2", nil))
+	bans, err := svc.ActiveBans(context.Background())
+	if err != nil || len(bans) != 1 || !bans["u1"].Equal(until) {
+		t.Fatalf("bans=%v err=%v", bans, err)
+	}
+
+	mock.ExpectQuery(`WHERE user_id = \? AND banned_until > \? AND cleared_at IS NULL`).
+		WithArgs("u1", sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"banned_until"}).AddRow(until))
+	if got, ok, err := svc.ActiveUntil(context.Background(), "u1"); err != nil || !ok || !got.Equal(until) {
+		t.Fatalf("until=%v ok=%v err=%v", got, ok, err)
+	}
+	mock.ExpectQuery(`WHERE user_id = \? AND banned_until > \? AND cleared_at IS NULL`).
+		WithArgs("u2", sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"banned_until"}).AddRow(nil))
+	if _, ok, err := svc.ActiveUntil(context.Background(), "u2"); err != nil || ok {
+		t.Fatalf("ok=%v err=%v", ok, err)
+	}
+
+	mock.ExpectExec(`UPDATE forceout_event SET cleared_at = \? WHERE banned_until > \? AND cleared_at IS NULL`).
+		WillReturnResult(sqlmock.NewResult(0, 3))
+	if n, err := svc.ClearActive(context.Background()); err != nil || n != 3 {
+		t.Fatalf("n=%d err=%v", n, err)
+	}
+}
+
+func TestDBForceoutEventService_ListUsersAndEvents(t *testing.T) {
+	svc, mock := newMockDBService(t, NewDBForceoutEventService)
+
+	now := time.Now()
+	until := now.Add(time.Minute)
+	mock.ExpectQuery(regexp.QuoteMeta("WHERE 1 = 1 AND user_id = ?")+`\s+GROUP BY user_id\s+HAVING .+\s+ORDER BY MAX\(created_at\) DESC\s+LIMIT \?`).
+		WithArgs(sqlmock.AnyArg(), "u1", sqlmock.AnyArg(), forceoutEventMaxListLimit).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "count", "first", "last", "until"}).AddRow("u1", 2, now, now, until))
+	users, err := svc.ListUsers(context.Background(), ForceoutUserQuery{UserID: "u1", ActiveOnly: true, Limit: 9999})
+	if err != nil || len(users) != 1 || !users[0].Active || users[0].Count != 2 || users[0].RemainingSeconds <= 0 {
+		t.Fatalf("users=%+v err=%v", users, err)
+	}
+
+	mock.ExpectQuery(regexp.QuoteMeta("WHERE 1 = 1")+`\s+GROUP BY user_id\s+ORDER BY`).
+		WithArgs(sqlmock.AnyArg(), forceoutEventDefaultListLimit).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "count", "first", "last", "until"}).AddRow("u2", 1, now, now, nil))
+	users, err = svc.ListUsers(context.Background(), ForceoutUserQuery{})
+	if err != nil || len(users) != 1 || users[0].Active || users[0].BannedUntil != "" {
+		t.Fatalf("users=%+v err=%v", users, err)
+	}
+
+	if _, err := svc.ListEvents(context.Background(), "", 10); err == nil {
+		t.Fatalf("expected userId validation error")
+	}
+	mock.ExpectQuery(`FROM forceout_event\s+WHERE user_id = \?\s+ORDER BY created_at DESC, id DESC\s+LIMIT \?`).
+		WithArgs("u1", 10).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "raw_message", "banned_until", "cleared_at", "created_at"}).
+			AddRow(2, "u1", "raw", until, now, now).
+			AddRow(1, "u1", nil, until, nil, now))
+	events, err := svc.ListEvents(context.Background(), "u1", 10)
+	if err != nil || len(events) != 2 || events[0].RawMessage != "raw" || events[0].ClearedTime == "" || events[1].ClearedTime != "" {
+		t.Fatalf("events=%+v err=%v", events, err)
+	}
+}
+
+type stubForceoutEventStore struct {
+	bans      map[string]time.Time
+	bansErr   error
+	recorded  []string
+	recordErr error
+	cleared   int
+	events    []ForceoutEvent
+}
+
+func (s *stubForceoutEventStore) Record(ctx context.Context, userID string, rawMessage string, bannedUntil time.Time) error {
+	s.recorded = append(s.recorded, userID+":"+rawMessage)
+	return s.recordErr
+}
+
+func (s *stubForceoutEventStore) ActiveBans(ctx context.Context) (map[string]time.Time, error) {
+	return s.bans, s.bansErr
+}
+
+func (s *stubForceoutEventStore) ActiveUntil(ctx context.Context, userID string) (time.Time, bool, error) {
+	until, ok := s.bans[userID]
+	return until, ok, nil
+}
+
+func (s *stubForceoutEventStore) ClearActive(ctx context.Context) (int64, error) {
+	s.cleared++
+	return int64(len(s.bans)), nil
+}
+
+func (s *stubForceoutEventStore) ListUsers(ctx context.Context, query ForceoutUserQuery) ([]ForceoutUserSummary, error) {
+	return []ForceoutUserSummary{{UserID: "u1", Count: int64(len(s.recorded))}}, nil
+}
+
+func (s *stubForceoutEventStore) ListEvents(ctx context.Context, userID string, limit int) ([]ForceoutEvent, error) {
+	return s.events, nil
+}
+
+func TestForceoutManager_StoreRestoresAndRecords(t *testing.T) {
+	m := NewForceoutManager()
+	m.SetDuration(time.Hour)
+	if m.Duration() != time.Hour {
+		t.Fatalf("duration=%v", m.Duration())
+	}
+
+	if err := m.SetStore(&stubForceoutEventStore{bansErr: errors.New("boom")}); err == nil {
+		t.Fatalf("expected load error")
+	}
+
+	// 重启后从存储恢复仍在禁止期内的身份。
+	store := &stubForceoutEventStore{bans: map[string]time.Time{"u1": time.Now().Add(time.Minute)}}
+	if err := m.SetStore(store); err != nil {
+		t.Fatalf("SetStore: %v", err)
+	}
+	if !m.IsForbidden("u1") || m.RemainingSeconds("u1") <= 0 {
+		t.Fatalf("expected restored ban")
+	}
+
+	store.recordErr = errors.New("db down")
+	m.RecordForceout("u2", "raw")
+	m.AddForceoutUser("u3")
+	if len(store.recorded) != 2 || store.recorded[0] != "u2:raw" || store.recorded[1] != "u3:" {
+		t.Fatalf("recorded=%v", store.recorded)
+	}
+	if got := m.RemainingSeconds("u2"); got < 3590 {
+		t.Fatalf("remaining=%d, want configured duration", got)
+	}
+
+	if got := m.ClearAllForceout(); got != 3 || store.cleared != 1 || m.IsForbidden("u1") {
+		t.Fatalf("cleared=%d store=%d", got, store.cleared)
+	}
+
+	m.SetDuration(0)
+	if m.Duration() != forceoutDuration {
+		t.Fatalf("duration=%v, want default", m.Duration())
+	}
+}
+
+func TestForceoutEventHandlers(t *testing.T) {
+	a := &App{}
+	rec := httptest.NewRecorder()
+	a.handleListForceoutUsers(rec, httptest.NewRequest(http.MethodGet, "/api/forceout/list", nil))
+	if got := decodeJSONBody(t, rec.Body); toInt(got["code"]) != -1 {
+		t.Fatalf("got=%v", got)
+	}
+	rec = httptest.NewRecorder()
+	a.handleGetForceoutDetail(rec, httptest.NewRequest(http.MethodGet, "/api/forceout/detail?userId=u1", nil))
+	if got := decodeJSONBody(t, rec.Body); toInt(got["code"]) != -1 {
+		t.Fatalf("got=%v", got)
+	}
+
+	a.forceoutManager = NewForceoutManager()
+	rec = httptest.NewRecorder()
+	a.handleGetForceoutDetail(rec, httptest.NewRequest(http.MethodGet, "/api/forceout/detail", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("status=%d, want 400", rec.Code)
+	}
+
+	store := &stubForceoutEventStore{events: []ForceoutEvent{{ID: 1, UserID: "u1", RawMessage: "raw"}}}
+	if err := a.forceoutManager.SetStore(store); err != nil {
+		t.Fatalf("SetStore: %v", err)
+	}
+	a.forceoutManager.RecordForceout("u1", "raw")
+
+	rec = httptest.NewRecorder()
+	a.handleListForceoutUsers(rec, httptest.NewRequest(http.MethodGet, "/api/forceout/list?activeOnly=true", nil))
+	got := decodeJSONBody(t, rec.Body)
+	if toInt(got["code"]) != 0 || len(got["data"].([]any)) != 1 {
+		t.Fatalf("got=%v", got)
+	}
+
+	rec = httptest.NewRecorder()
+	a.handleGetForceoutDetail(rec, httptest.NewRequest(http.MethodGet, "/api/forceout/detail?userId=u1", nil))
+	got = decodeJSONBody(t, rec.Body)
+	data, _ := got["data"].(map[string]any)
+	if toInt(got["code"]) != 0 || data["forbidden"] != true || toInt(data["banSeconds"]) != 300 || len(data["events"].([]any)) != 1 {
+		t.Fatalf("got=%v", got)
+	}
+}
@@ -11,7 +11,7 @@ var jwtSignedStringFn = func(token *jwt.Token, key any) (string, error) {
 	return token.SignedString(key)
 }
 
-// JWTService 提供与 Spring 侧兼容的 JWT 生成与校验（HS256，sub=user）。
+// JWTService 提供与 Spring 侧兼容的 JWT 生成与校验（HS256，sub=user），并在声明中携带账号角色。
 type JWTService struct {
 	secret []byte
 	expire time.Duration
@@ -27,16 +27,53 @@ func NewJWTService(secret string, expireHours int) *JWTService {
 	}
 }
 
+// SetAccessTokenTTL 设置 access token 有效期；配合 refresh token 使用时应远短于会话有效期。
+func (s *JWTService) SetAccessTokenTTL(ttl time.Duration) {
+	if ttl > 0 {
+		s.expire = ttl
+	}
+}
+
+// AccessTokenTTL 返回 access token 有效期。
+func (s *JWTService) AccessTokenTTL() time.Duration {
+	return s.expire
+}
+
+// AuthClaims 为本服务签发的 Token 声明：acct=true 表示账号登录（sub 为用户名），否则为共享访问码登录；
+// sid 为服务端登录会话 ID，会话被吊销后该会话签发的全部 access token 失效。
+type AuthClaims struct {
+	Role      string `json:"role,omitempty"`
+	Account   bool   `json:"acct,omitempty"`
+	SessionID string `json:"sid,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// GenerateToken 为共享访问码登录签发 Token（sub=user，角色 admin）。
 func (s *JWTService) GenerateToken() (string, error) {
+	return s.GenerateTokenFor(AuthPrincipal{Subject: authLegacySubject, Role: AuthRoleAdmin})
+}
+
+// GenerateTokenFor 为指定调用者签发 access token。
+func (s *JWTService) GenerateTokenFor(principal AuthPrincipal) (string, error) {
 	if len(s.secret) == 0 {
 		return "", fmt.Errorf("JWT_SECRET 不能为空")
 	}
 
 	now := time.Now()
-	claims := jwt.RegisteredClaims{
-		Subject:   "user",
-		IssuedAt:  jwt.NewNumericDate(now),
-		ExpiresAt: jwt.NewNumericDate(now.Add(s.expire)),
+	jti, err := randomHex(8)
+	if err != nil {
+		return "", fmt.Errorf("签发 Token 失败: %w", err)
+	}
+	claims := AuthClaims{
+		Role:      principal.Role,
+		Account:   principal.Account,
+		SessionID: principal.SessionID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        jti,
+			Subject:   principal.Subject,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(s.expire)),
+		},
 	}
 
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
@@ -48,18 +85,28 @@ func (s *JWTService) GenerateToken() (string, error) {
 }
 
 func (s *JWTService) ValidateToken(tokenString string) bool {
+	_, err := s.ParseToken(tokenString)
+	return err == nil
+}
+
+// ParseToken 校验签名与有效期并返回声明。
+func (s *JWTService) ParseToken(tokenString string) (*AuthClaims, error) {
 	if tokenString == "" {
-		return false
+		return nil, fmt.Errorf("Token为空")
 	}
 	if len(s.secret) == 0 {
-		return false
+		return nil, fmt.Errorf("JWT_SECRET 不能为空")
 	}
 
-	_, err := jwt.Parse(tokenString, func(token *jwt.Token) (any, error) {
+	claims := &AuthClaims{}
+	_, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (any, error) {
 		if token.Method != jwt.SigningMethodHS256 {
 			return nil, fmt.Errorf("不支持的签名算法: %v", token.Header["alg"])
 		}
 		return s.secret, nil
 	})
-	return err == nil
+	if err != nil {
+		return nil, err
+	}
+	return claims, nil
 }
@@ -0,0 +1,441 @@
+package app
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"liao/internal/config"
+)
+
+const (
+	LoginLockoutScopeIP     = "ip"
+	LoginLockoutScopeGlobal = "global"
+
+	loginGuardGlobalKey = LoginLockoutScopeGlobal
+	loginGuardIPPrefix  = LoginLockoutScopeIP + ":"
+)
+
+var (
+	// loginGuardMaxDelay 为失败后指数退避间隔的上限。
+	loginGuardMaxDelay = 30 * time.Second
+	// loginGuardGlobalInterval 为全局限速期间同一 IP 两次尝试的最小间隔。
+	loginGuardGlobalInterval = 30 * time.Second
+)
+
+// LoginGuardConfig 为登录防爆破参数；MaxFailuresPerIP/MaxFailuresGlobal 为 0 表示不启用对应锁定/限速，BaseDelay 为 0 表示不限制尝试间隔。
+type LoginGuardConfig struct {
+	MaxFailuresPerIP  int
+	MaxFailuresGlobal int
+	Window            time.Duration
+	Lockout           time.Duration
+	BaseDelay         time.Duration
+}
+
+// loginAttemptState 为某个计数键（ip:<地址> 或 global）的失败计数与锁定状态。
+// ip 键的 Failures 在预占尝试时即累加，登录成功后清除；LastAttempt 为最近一次预占（global 为最近一次失败）的时间。
+type loginAttemptState struct {
+	Failures    int
+	LastAttempt time.Time
+	LockedUntil time.Time
+}
+
+// loginAttemptPolicy 为预占一次尝试时的校验参数；MaxFailures 为 0 表示不限制次数。
+type loginAttemptPolicy struct {
+	Window      time.Duration
+	MaxFailures int
+	BaseDelay   time.Duration
+	MinInterval time.Duration
+}
+
+// wait 返回 state 下本次尝试需要等待的时长；state 的计数须已按窗口重置。
+func (p loginAttemptPolicy) wait(state loginAttemptState, now time.Time) time.Duration {
+	if state.LockedUntil.After(now) {
+		return state.LockedUntil.Sub(now)
+	}
+	if state.LastAttempt.IsZero() {
+		return 0
+	}
+	if p.MaxFailures > 0 && state.Failures >= p.MaxFailures {
+		// 已预占的尝试尚未结束：等它们失败触发锁定或成功清除计数。
+		return max(state.LastAttempt.Add(p.Window).Sub(now), time.Second)
+	}
+	next := state.LastAttempt.Add(max(loginBackoffDelay(p.BaseDelay, state.Failures), p.MinInterval))
+	if next.After(now) {
+		return next.Sub(now)
+	}
+	return 0
+}
+
+// LoginLockout 为一条生效中的锁定。
+type LoginLockout struct {
+	Key               string `json:"key"`
+	Scope             string `json:"scope"`
+	IP                string `json:"ip,omitempty"`
+	Failures          int    `json:"failures"`
+	LockedUntil       string `json:"lockedUntil"`
+	RetryAfterSeconds int    `json:"retryAfterSeconds"`
+}
+
+// LoginAttemptStore 保存登录失败计数与锁定状态；CACHE_TYPE=redis 时由 Redis 实现以便多副本共享。
+type LoginAttemptStore interface {
+	Get(ctx context.Context, key string) (loginAttemptState, error)
+	// Reserve 原子地校验 policy 并预占一次尝试（失败计数加 1）；需要等待时不预占，返回等待时长。
+	Reserve(ctx context.Context, key string, now time.Time, policy loginAttemptPolicy) (loginAttemptState, time.Duration, error)
+	// Release 撤销一次未产生登录结果的预占。
+	Release(ctx context.Context, key string) error
+	// RecordFailure 累加一次失败并返回更新后的状态；距上次失败超过 window 时计数从 1 重新开始。
+	RecordFailure(ctx context.Context, key string, now time.Time, window time.Duration) (loginAttemptState, error)
+	Lock(ctx context.Context, key string, until time.Time) error
+	// Clear 清除计数与锁定。
+	Clear(ctx context.Context, key string) error
+	// Locked 返回 now 时仍生效的锁定。
+	Locked(ctx context.Context, now time.Time) (map[string]loginAttemptState, error)
+}
+
+// LoginGuard 按来源 IP 与全局两个维度限制登录尝试：
+// 每次尝试先原子地预占计数，下一次尝试需等待 BaseDelay*2^(计数-1)（上限 loginGuardMaxDelay），
+// 窗口内 IP 失败次数达到阈值后锁定该 IP Lockout 时长；全局失败次数达到阈值后在 Lockout 时长内对所有 IP
+// 限速（每 IP 每 loginGuardGlobalInterval 一次），而不是拒绝全部登录，正确凭证仍可登录。
+// 存储出错时放行，避免缓存故障导致无法登录。
+type LoginGuard struct {
+	cfg   LoginGuardConfig
+	store LoginAttemptStore
+	now   func() time.Time
+}
+
+func NewLoginGuard(cfg LoginGuardConfig, store LoginAttemptStore) *LoginGuard {
+	if store == nil {
+		store = NewMemoryLoginAttemptStore()
+	}
+	if cfg.Window <= 0 {
+		cfg.Window = 15 * time.Minute
+	}
+	if cfg.Lockout <= 0 {
+		cfg.Lockout = 15 * time.Minute
+	}
+	return &LoginGuard{cfg: cfg, store: store, now: time.Now}
+}
+
+// Check 原子地为该 IP 预占一次尝试：返回 0 表示允许并已计入失败次数（成功后由 Succeed 清除，
+// 未产生登录结果时由 Release 撤销）；返回值大于 0 表示需要等待的时长，此时不计数。
+func (g *LoginGuard) Check(ctx context.Context, ip string) time.Duration {
+	if g == nil {
+		return 0
+	}
+	now := g.now()
+	policy := loginAttemptPolicy{Window: g.cfg.Window, MaxFailures: g.cfg.MaxFailuresPerIP, BaseDelay: g.cfg.BaseDelay}
+	if global, err := g.store.Get(ctx, loginGuardGlobalKey); err != nil {
+		slog.Warn("读取登录尝试状态失败", "key", loginGuardGlobalKey, "error", err)
+	} else if global.LockedUntil.After(now) {
+		policy.MinInterval = loginGuardGlobalInterval
+	}
+
+	ipKey := loginGuardIPPrefix + ip
+	_, wait, err := g.store.Reserve(ctx, ipKey, now, policy)
+	if err != nil {
+		slog.Warn("预占登录尝试失败", "key", ipKey, "error", err)
+		return 0
+	}
+	return wait
+}
+
+// Release 撤销 Check 预占的一次尝试，用于未校验出结果的请求（如等待补充两步验证码）。
+func (g *LoginGuard) Release(ctx context.Context, ip string) {
+	if g == nil {
+		return
+	}
+	if err := g.store.Release(ctx, loginGuardIPPrefix+ip); err != nil {
+		slog.Warn("撤销登录尝试失败", "ip", ip, "error", err)
+	}
+}
+
+// Fail 记录一次登录失败（该 IP 的计数已在 Check 时累加），返回该 IP 当前窗口内的失败次数以及本次是否触发了锁定。
+func (g *LoginGuard) Fail(ctx context.Context, ip string) (int, bool) {
+	if g == nil {
+		return 0, false
+	}
+	now := g.now()
+	var (
+		failures int
+		locked   bool
+	)
+	ipKey := loginGuardIPPrefix + ip
+	if state, err := g.store.Get(ctx, ipKey); err != nil {
+		slog.Warn("读取登录尝试状态失败", "key", ipKey, "error", err)
+	} else {
+		failures = state.Failures
+		if g.cfg.MaxFailuresPerIP > 0 && state.Failures >= g.cfg.MaxFailuresPerIP && !state.LockedUntil.After(now) {
+			if err := g.store.Lock(ctx, ipKey, now.Add(g.cfg.Lockout)); err != nil {
+				slog.Warn("锁定登录来源失败", "ip", ip, "error", err)
+			} else {
+				locked = true
+				slog.Warn("登录失败次数过多，锁定来源IP", "ip", ip, "failures", state.Failures, "lockout", g.cfg.Lockout)
+			}
+		}
+	}
+
+	if state, err := g.store.RecordFailure(ctx, loginGuardGlobalKey, now, g.cfg.Window); err != nil {
+		slog.Warn("记录登录失败次数失败", "key", loginGuardGlobalKey, "error", err)
+	} else if g.cfg.MaxFailuresGlobal > 0 && state.Failures >= g.cfg.MaxFailuresGlobal && !state.LockedUntil.After(now) {
+		if err := g.store.Lock(ctx, loginGuardGlobalKey, now.Add(g.cfg.Lockout)); err != nil {
+			slog.Warn("启用全局登录限速失败", "error", err)
+		} else {
+			locked = true
+			slog.Error("全局登录失败次数过多，所有来源限速", "failures", state.Failures, "lockout", g.cfg.Lockout, "interval", loginGuardGlobalInterval)
+		}
+	}
+	return failures, locked
+}
+
+// Succeed 在登录成功后清除该 IP 的失败计数。
+func (g *LoginGuard) Succeed(ctx context.Context, ip string) {
+	if g == nil {
+		return
+	}
+	if err := g.store.Clear(ctx, loginGuardIPPrefix+ip); err != nil {
+		slog.Warn("清除登录失败次数失败", "ip", ip, "error", err)
+	}
+}
+
+// Lockouts 返回生效中的锁定（全局锁定排在最前）。
+func (g *LoginGuard) Lockouts(ctx context.Context) ([]LoginLockout, error) {
+	if g == nil {
+		return []LoginLockout{}, nil
+	}
+	now := g.now()
+	locked, err := g.store.Locked(ctx, now)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]LoginLockout, 0, len(locked))
+	for key, state := range locked {
+		item := LoginLockout{
+			Key:               key,
+			Scope:             LoginLockoutScopeGlobal,
+			Failures:          state.Failures,
+			LockedUntil:       formatLocalDateTimeISO(state.LockedUntil),
+			RetryAfterSeconds: retryAfterSeconds(state.LockedUntil.Sub(now)),
+		}
+		if ip, ok := strings.CutPrefix(key, loginGuardIPPrefix); ok {
+			item.Scope, item.IP = LoginLockoutScopeIP, ip
+		}
+		out = append(out, item)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if (out[i].Scope == LoginLockoutScopeGlobal) != (out[j].Scope == LoginLockoutScopeGlobal) {
+			return out[i].Scope == LoginLockoutScopeGlobal
+		}
+		return out[i].Key < out[j].Key
+	})
+	return out, nil
+}
+
+// ClearLockout 解除指定键（ip:<地址> 或 global）的锁定与失败计数。
+func (g *LoginGuard) ClearLockout(ctx context.Context, key string) error {
+	if g == nil {
+		return nil
+	}
+	key = strings.TrimSpace(key)
+	if key != loginGuardGlobalKey && (!strings.HasPrefix(key, loginGuardIPPrefix) || key == loginGuardIPPrefix) {
+		return errBadRequest("key 仅支持 global 或 ip:<地址>")
+	}
+	return g.store.Clear(ctx, key)
+}
+
+// ClearAllLockouts 解除全部生效中的锁定，返回解除数量。
+func (g *LoginGuard) ClearAllLockouts(ctx context.Context) (int, error) {
+	if g == nil {
+		return 0, nil
+	}
+	locked, err := g.store.Locked(ctx, g.now())
+	if err != nil {
+		return 0, err
+	}
+	for key := range locked {
+		if err := g.store.Clear(ctx, key); err != nil {
+			return 0, err
+		}
+	}
+	return len(locked), nil
+}
+
+// loginBackoffDelay 返回第 failures 次失败后的退避间隔：base*2^(failures-1)，上限 loginGuardMaxDelay。
+func loginBackoffDelay(base time.Duration, failures int) time.Duration {
+	if base <= 0 || failures <= 0 {
+		return 0
+	}
+	delay := base
+	for i := 1; i < failures && delay < loginGuardMaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > loginGuardMaxDelay {
+		return loginGuardMaxDelay
+	}
+	return delay
+}
+
+func retryAfterSeconds(d time.Duration) int {
+	if d <= 0 {
+		return 0
+	}
+	return int((d + time.Second - 1) / time.Second)
+}
+
+// loginClientIP 返回登录请求的来源 IP；trustForwarded 时优先取 X-Forwarded-For 第一跳或 X-Real-IP。
+func loginClientIP(r *http.Request, trustForwarded bool) string {
+	if trustForwarded {
+		if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+			first, _, _ := strings.Cut(forwarded, ",")
+			if ip := net.ParseIP(strings.TrimSpace(first)); ip != nil {
+				return ip.String()
+			}
+		}
+		if ip := net.ParseIP(strings.TrimSpace(r.Header.Get("X-Real-IP"))); ip != nil {
+			return ip.String()
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return strings.TrimSpace(host)
+}
+
+// MemoryLoginAttemptStore 为单副本使用的内存实现，过期条目在写入时顺带清理。
+type MemoryLoginAttemptStore struct {
+	mu        sync.Mutex
+	states    map[string]loginAttemptState
+	lastSweep time.Time
+}
+
+func NewMemoryLoginAttemptStore() *MemoryLoginAttemptStore {
+	return &MemoryLoginAttemptStore{states: make(map[string]loginAttemptState)}
+}
+
+func (s *MemoryLoginAttemptStore) Get(_ context.Context, key string) (loginAttemptState, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.states[key], nil
+}
+
+func (s *MemoryLoginAttemptStore) Reserve(_ context.Context, key string, now time.Time, policy loginAttemptPolicy) (loginAttemptState, time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sweepLocked(now, policy.Window)
+
+	state := s.states[key]
+	if now.Sub(state.LastAttempt) > policy.Window {
+		state.Failures = 0
+	}
+	if wait := policy.wait(state, now); wait > 0 {
+		return state, wait, nil
+	}
+	state.Failures++
+	state.LastAttempt = now
+	s.states[key] = state
+	return state, 0, nil
+}
+
+func (s *MemoryLoginAttemptStore) Release(_ context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	state, ok := s.states[key]
+	if !ok {
+		return nil
+	}
+	if state.Failures--; state.Failures <= 0 {
+		state.Failures, state.LastAttempt = 0, time.Time{}
+	}
+	s.states[key] = state
+	return nil
+}
+
+func (s *MemoryLoginAttemptStore) RecordFailure(_ context.Context, key string, now time.Time, window time.Duration) (loginAttemptState, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sweepLocked(now, window)
+
+	state := s.states[key]
+	if now.Sub(state.LastAttempt) > window {
+		state.Failures = 0
+	}
+	state.Failures++
+	state.LastAttempt = now
+	s.states[key] = state
+	return state, nil
+}
+
+// sweepLocked 每隔一个窗口清理过期且未锁定的条目，调用方需持有 s.mu。
+func (s *MemoryLoginAttemptStore) sweepLocked(now time.Time, window time.Duration) {
+	if now.Sub(s.lastSweep) < window {
+		return
+	}
+	for k, state := range s.states {
+		if now.Sub(state.LastAttempt) > window && !state.LockedUntil.After(now) {
+			delete(s.states, k)
+		}
+	}
+	s.lastSweep = now
+}
+
+func (s *MemoryLoginAttemptStore) Lock(_ context.Context, key string, until time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	state := s.states[key]
+	state.LockedUntil = until
+	s.states[key] = state
+	return nil
+}
+
+func (s *MemoryLoginAttemptStore) Clear(_ context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.states, key)
+	return nil
+}
+
+func (s *MemoryLoginAttemptStore) Locked(_ context.Context, now time.Time) (map[string]loginAttemptState, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make(map[string]loginAttemptState)
+	for key, state := range s.states {
+		if state.LockedUntil.After(now) {
+			out[key] = state
+		}
+	}
+	return out, nil
+}
+
+// newLoginGuardFromConfig 按配置创建登录防护；CACHE_TYPE=redis 时使用 Redis 共享状态，连接失败则退回内存。
+func newLoginGuardFromConfig(cfg config.Config) *LoginGuard {
+	var store LoginAttemptStore
+	if cfg.CacheType == "redis" {
+		redisStore, err := NewRedisLoginAttemptStore(
+			cfg.RedisURL,
+			cfg.RedisHost,
+			cfg.RedisPort,
+			cfg.RedisPassword,
+			cfg.RedisDB,
+			"liao:login:",
+			cfg.RedisTimeoutSeconds,
+		)
+		if err != nil {
+			slog.Warn("登录防护连接 Redis 失败，使用内存存储", "error", err)
+		} else {
+			store = redisStore
+		}
+	}
+	return NewLoginGuard(LoginGuardConfig{
+		MaxFailuresPerIP:  cfg.AuthLoginMaxFailures,
+		MaxFailuresGlobal: cfg.AuthLoginGlobalMaxFailures,
+		Window:            time.Duration(cfg.AuthLoginWindowSeconds) * time.Second,
+		Lockout:           time.Duration(cfg.AuthLoginLockoutSeconds) * time.Second,
+		BaseDelay:         time.Duration(cfg.AuthLoginBaseDelayMillis) * time.Millisecond,
+	}, store)
+}
@@ -0,0 +1,112 @@
+package app
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+)
+
+func (a *App) handleListLoginLockouts(w http.ResponseWriter, r *http.Request) {
+	if a.loginGuard == nil {
+		writeJSON(w, http.StatusOK, map[string]any{"code": -1, "msg": "登录防护未初始化"})
+		return
+	}
+
+	items, err := a.loginGuard.Lockouts(r.Context())
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]any{"code": -1, "msg": "查询登录锁定失败: " + err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"code": 0,
+		"msg":  "success",
+		"data": items,
+	})
+}
+
+// handleClearLoginLockout 解除单个锁定（key 为 global 或 ip:<地址>），all=true 时解除全部。
+func (a *App) handleClearLoginLockout(w http.ResponseWriter, r *http.Request) {
+	if a.loginGuard == nil {
+		writeJSON(w, http.StatusOK, map[string]any{"code": -1, "msg": "登录防护未初始化"})
+		return
+	}
+
+	var in struct {
+		Key string `json:"key"`
+		All bool   `json:"all"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"code": 400, "msg": "参数解析失败"})
+		return
+	}
+
+	cleared := 1
+	var err error
+	if in.All {
+		cleared, err = a.loginGuard.ClearAllLockouts(r.Context())
+	} else {
+		err = a.loginGuard.ClearLockout(r.Context(), in.Key)
+	}
+	if err != nil {
+		if isBadRequestError(err) {
+			writeJSON(w, http.StatusBadRequest, map[string]any{"code": 400, "msg": err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusInternalServerError, map[string]any{"code": -1, "msg": "解除登录锁定失败: " + err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"code": 0,
+		"msg":  "success",
+		"data": map[string]any{"cleared": cleared},
+	})
+}
+
+func (a *App) handleListLoginFailures(w http.ResponseWriter, r *http.Request) {
+	if a.loginFailures == nil {
+		writeJSON(w, http.StatusOK, map[string]any{"code": -1, "msg": "登录失败记录存储未初始化"})
+		return
+	}
+
+	q := r.URL.Query()
+	query := AuthLoginFailureQuery{
+		RemoteIP: strings.TrimSpace(q.Get("ip")),
+		Username: strings.TrimSpace(q.Get("username")),
+		Page:     parseIntDefault(q.Get("page"), 1),
+		PageSize: parseIntDefault(q.Get("pageSize"), wsConnectionEventDefaultPageSize),
+	}
+	for _, item := range []struct {
+		name   string
+		target *time.Time
+	}{{"since", &query.Since}, {"until", &query.Until}} {
+		raw := strings.TrimSpace(q.Get(item.name))
+		if raw == "" {
+			continue
+		}
+		t := parseOptionalLocalDateTimeISO(raw)
+		if t == nil {
+			writeJSON(w, http.StatusBadRequest, map[string]any{"code": 400, "msg": item.name + "时间格式非法"})
+			return
+		}
+		*item.target = *t
+	}
+
+	items, total, err := a.loginFailures.List(r.Context(), query)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]any{"code": -1, "msg": "查询登录失败记录失败: " + err.Error()})
+		return
+	}
+	page, pageSize := normalizePage(query.Page, query.PageSize)
+	writeJSON(w, http.StatusOK, map[string]any{
+		"code": 0,
+		"msg":  "success",
+		"data": map[string]any{
+			"items":      items,
+			"total":      total,
+			"page":       page,
+			"pageSize":   pageSize,
+			"totalPages": calcTotalPages(total, pageSize),
+		},
+	})
+}
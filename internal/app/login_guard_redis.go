@@ -0,0 +1,258 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// loginAttemptReserveRetries 为预占事务因并发修改失败时的最大重试次数。
+	loginAttemptReserveRetries = 8
+	loginAttemptConflictWait   = time.Second
+)
+
+var (
+	// 窗口内累加失败次数（超出窗口从 1 重新计数），返回 {failures, lockedUntilMs}。
+	loginAttemptRecordScript = redis.NewScript(`
+local now = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local last = tonumber(redis.call("HGET", KEYS[1], "last") or "0")
+local failures = tonumber(redis.call("HGET", KEYS[1], "failures") or "0")
+if now - last > window then
+	failures = 0
+end
+failures = failures + 1
+redis.call("HSET", KEYS[1], "failures", failures, "last", now)
+if redis.call("PTTL", KEYS[1]) < window then
+	redis.call("PEXPIRE", KEYS[1], window)
+end
+return {failures, tonumber(redis.call("HGET", KEYS[1], "locked") or "0")}`)
+	// 撤销一次预占：计数减 1，归零时同时清除最近尝试时间，保留锁定。
+	loginAttemptReleaseScript = redis.NewScript(`
+if redis.call("EXISTS", KEYS[1]) == 0 then
+	return 0
+end
+local failures = tonumber(redis.call("HGET", KEYS[1], "failures") or "0")
+if failures <= 1 then
+	redis.call("HSET", KEYS[1], "failures", 0)
+	redis.call("HDEL", KEYS[1], "last")
+else
+	redis.call("HSET", KEYS[1], "failures", failures - 1)
+end
+return 1`)
+	// 写入锁定截止时间并登记到锁定集合，TTL 至少覆盖锁定期。
+	loginAttemptLockScript = redis.NewScript(`
+local ttl = tonumber(ARGV[1]) - tonumber(ARGV[2])
+redis.call("HSET", KEYS[1], "locked", ARGV[1])
+if ttl > 0 and redis.call("PTTL", KEYS[1]) < ttl then
+	redis.call("PEXPIRE", KEYS[1], ttl)
+end
+redis.call("ZADD", KEYS[2], ARGV[1], ARGV[3])
+return 1`)
+)
+
+// RedisLoginAttemptStore 基于 Redis 在多副本间共享登录失败计数与锁定：
+//
+// - {prefix}{key} -> HASH（failures/last/locked，时间为毫秒时间戳），TTL 覆盖统计窗口与锁定期
+// - {prefix}locked -> ZSET（member=key，score=锁定截止毫秒时间戳），用于列出生效中的锁定
+type RedisLoginAttemptStore struct {
+	client    *redis.Client
+	keyPrefix string
+	timeout   time.Duration
+}
+
+func NewRedisLoginAttemptStore(
+	redisURL string,
+	host string,
+	port int,
+	password string,
+	db int,
+	keyPrefix string,
+	timeoutSeconds int,
+) (*RedisLoginAttemptStore, error) {
+	if strings.TrimSpace(keyPrefix) == "" {
+		keyPrefix = "liao:login:"
+	}
+	timeout := time.Duration(timeoutSeconds) * time.Second
+	if timeout <= 0 {
+		timeout = 15 * time.Second
+	}
+
+	opts, err := buildRedisOptions(redisURL, host, port, password, db, timeout)
+	if err != nil {
+		return nil, err
+	}
+	client := redis.NewClient(opts)
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		_ = client.Close()
+		return nil, fmt.Errorf("连接 Redis 失败: %w", err)
+	}
+	return &RedisLoginAttemptStore{client: client, keyPrefix: keyPrefix, timeout: timeout}, nil
+}
+
+func (s *RedisLoginAttemptStore) Get(ctx context.Context, key string) (loginAttemptState, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	fields, err := s.client.HGetAll(ctx, s.keyPrefix+key).Result()
+	if err != nil {
+		return loginAttemptState{}, err
+	}
+	return loginAttemptStateFromFields(fields), nil
+}
+
+// Reserve 以 WATCH/MULTI 乐观事务原子地校验并预占，并发修改导致事务失败时重试。
+func (s *RedisLoginAttemptStore) Reserve(ctx context.Context, key string, now time.Time, policy loginAttemptPolicy) (loginAttemptState, time.Duration, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	redisKey := s.keyPrefix + key
+	for attempt := 0; attempt < loginAttemptReserveRetries; attempt++ {
+		var (
+			state loginAttemptState
+			wait  time.Duration
+		)
+		err := s.client.Watch(ctx, func(tx *redis.Tx) error {
+			fields, err := tx.HGetAll(ctx, redisKey).Result()
+			if err != nil {
+				return err
+			}
+			state = loginAttemptStateFromFields(fields)
+			if now.Sub(state.LastAttempt) > policy.Window {
+				state.Failures = 0
+			}
+			if wait = policy.wait(state, now); wait > 0 {
+				return nil
+			}
+			ttl, err := tx.PTTL(ctx, redisKey).Result()
+			if err != nil {
+				return err
+			}
+			state.Failures++
+			state.LastAttempt = now
+			_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+				pipe.HSet(ctx, redisKey, "failures", state.Failures, "last", now.UnixMilli())
+				if ttl < policy.Window {
+					pipe.PExpire(ctx, redisKey, policy.Window)
+				}
+				return nil
+			})
+			return err
+		}, redisKey)
+		if errors.Is(err, redis.TxFailedErr) {
+			continue
+		}
+		return state, wait, err
+	}
+	// 同一 IP 的并发尝试持续冲突：按需要等待处理，不能放行。
+	return loginAttemptState{}, loginAttemptConflictWait, nil
+}
+
+func (s *RedisLoginAttemptStore) Release(ctx context.Context, key string) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	return loginAttemptReleaseScript.Run(ctx, s.client, []string{s.keyPrefix + key}).Err()
+}
+
+func (s *RedisLoginAttemptStore) RecordFailure(ctx context.Context, key string, now time.Time, window time.Duration) (loginAttemptState, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	res, err := loginAttemptRecordScript.Run(ctx, s.client, []string{s.keyPrefix + key}, now.UnixMilli(), window.Milliseconds()).Int64Slice()
+	if err != nil {
+		return loginAttemptState{}, err
+	}
+	if len(res) != 2 {
+		return loginAttemptState{}, fmt.Errorf("登录失败计数脚本返回值非法: %v", res)
+	}
+	state := loginAttemptState{Failures: int(res[0]), LastAttempt: now}
+	if res[1] > 0 {
+		state.LockedUntil = time.UnixMilli(res[1])
+	}
+	return state, nil
+}
+
+func (s *RedisLoginAttemptStore) Lock(ctx context.Context, key string, until time.Time) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	return loginAttemptLockScript.Run(ctx, s.client, []string{s.keyPrefix + key, s.lockedKey()},
+		until.UnixMilli(), time.Now().UnixMilli(), key).Err()
+}
+
+func (s *RedisLoginAttemptStore) Clear(ctx context.Context, key string) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	pipe := s.client.TxPipeline()
+	pipe.Del(ctx, s.keyPrefix+key)
+	pipe.ZRem(ctx, s.lockedKey(), key)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func (s *RedisLoginAttemptStore) Locked(ctx context.Context, now time.Time) (map[string]loginAttemptState, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	nowMs := strconv.FormatInt(now.UnixMilli(), 10)
+	if err := s.client.ZRemRangeByScore(ctx, s.lockedKey(), "-inf", nowMs).Err(); err != nil {
+		return nil, err
+	}
+	keys, err := s.client.ZRangeByScore(ctx, s.lockedKey(), &redis.ZRangeBy{Min: "(" + nowMs, Max: "+inf"}).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	out := make(map[string]loginAttemptState, len(keys))
+	for _, key := range keys {
+		fields, err := s.client.HGetAll(ctx, s.keyPrefix+key).Result()
+		if err != nil {
+			return nil, err
+		}
+		state := loginAttemptStateFromFields(fields)
+		// 已被 Clear 或过期的键可能仍残留在集合中。
+		if state.LockedUntil.After(now) {
+			out[key] = state
+		}
+	}
+	return out, nil
+}
+
+func (s *RedisLoginAttemptStore) Close() error {
+	if s == nil || s.client == nil {
+		return nil
+	}
+	return s.client.Close()
+}
+
+func (s *RedisLoginAttemptStore) lockedKey() string {
+	return s.keyPrefix + "locked"
+}
+
+func (s *RedisLoginAttemptStore) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return context.WithTimeout(ctx, s.timeout)
+}
+
+func loginAttemptStateFromFields(fields map[string]string) loginAttemptState {
+	failures, _ := strconv.Atoi(fields["failures"])
+	return loginAttemptState{
+		Failures:    failures,
+		LastAttempt: unixMilliOrZero(fields["last"]),
+		LockedUntil: unixMilliOrZero(fields["locked"]),
+	}
+}
+
+func unixMilliOrZero(raw string) time.Time {
+	ms, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil || ms <= 0 {
+		return time.Time{}
+	}
+	return time.UnixMilli(ms)
+}
@@ -0,0 +1,466 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+
+	"liao/internal/config"
+)
+
+func newTestLoginGuard(store LoginAttemptStore, now *time.Time) *LoginGuard {
+	g := NewLoginGuard(LoginGuardConfig{
+		MaxFailuresPerIP:  3,
+		MaxFailuresGlobal: 5,
+		Window:            time.Minute,
+		Lockout:           10 * time.Minute,
+		BaseDelay:         time.Second,
+	}, store)
+	g.now = func() time.Time { return *now }
+	return g
+}
+
+// failAttempt 模拟一次完整的失败登录：预占尝试后记录失败。
+func failAttempt(t *testing.T, g *LoginGuard, ip string) (int, bool) {
+	t.Helper()
+	ctx := context.Background()
+	if wait := g.Check(ctx, ip); wait != 0 {
+		t.Fatalf("ip=%s wait=%v, want 0", ip, wait)
+	}
+	return g.Fail(ctx, ip)
+}
+
+func TestLoginGuard_DelayAndIPLockout(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	g := newTestLoginGuard(nil, &now)
+
+	if failures, locked := failAttempt(t, g, "1.1.1.1"); failures != 1 || locked {
+		t.Fatalf("failures=%d locked=%v", failures, locked)
+	}
+	if wait := g.Check(ctx, "1.1.1.1"); wait != time.Second {
+		t.Fatalf("wait=%v, want 1s", wait)
+	}
+	// 退避只作用于失败的来源。
+	if wait := g.Check(ctx, "2.2.2.2"); wait != 0 {
+		t.Fatalf("other ip wait=%v, want 0", wait)
+	}
+
+	now = now.Add(time.Second)
+	failAttempt(t, g, "1.1.1.1")
+	if wait := g.Check(ctx, "1.1.1.1"); wait != 2*time.Second {
+		t.Fatalf("wait=%v, want 2s", wait)
+	}
+
+	now = now.Add(2 * time.Second)
+	if failures, locked := failAttempt(t, g, "1.1.1.1"); failures != 3 || !locked {
+		t.Fatalf("failures=%d locked=%v, want 3 true", failures, locked)
+	}
+	if wait := g.Check(ctx, "1.1.1.1"); wait != 10*time.Minute {
+		t.Fatalf("wait=%v, want lockout", wait)
+	}
+
+	lockouts, err := g.Lockouts(ctx)
+	if err != nil || len(lockouts) != 1 || lockouts[0].Key != "ip:1.1.1.1" || lockouts[0].Scope != LoginLockoutScopeIP || lockouts[0].IP != "1.1.1.1" || lockouts[0].RetryAfterSeconds != 600 {
+		t.Fatalf("lockouts=%+v err=%v", lockouts, err)
+	}
+
+	if err := g.ClearLockout(ctx, "bogus"); !isBadRequestError(err) {
+		t.Fatalf("expected bad request, got %v", err)
+	}
+	if err := g.ClearLockout(ctx, "ip:"); !isBadRequestError(err) {
+		t.Fatalf("expected bad request for empty ip, got %v", err)
+	}
+	if err := g.ClearLockout(ctx, " ip:1.1.1.1 "); err != nil {
+		t.Fatalf("ClearLockout: %v", err)
+	}
+	if wait := g.Check(ctx, "1.1.1.1"); wait != 0 {
+		t.Fatalf("wait=%v after clear, want 0", wait)
+	}
+}
+
+func TestLoginGuard_CheckReservesConcurrentAttempts(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	g := newTestLoginGuard(nil, &now)
+
+	var allowed atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if g.Check(ctx, "1.1.1.1") == 0 {
+				allowed.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	if got := allowed.Load(); got != 1 {
+		t.Fatalf("allowed=%d, want 1 concurrent attempt", got)
+	}
+
+	// 无退避时并发尝试也不能超过失败阈值。
+	g = NewLoginGuard(LoginGuardConfig{MaxFailuresPerIP: 3, Window: time.Minute}, nil)
+	for i := 0; i < 3; i++ {
+		if wait := g.Check(ctx, "2.2.2.2"); wait != 0 {
+			t.Fatalf("attempt %d wait=%v", i, wait)
+		}
+	}
+	if wait := g.Check(ctx, "2.2.2.2"); wait <= 0 {
+		t.Fatalf("expected 4th in-flight attempt rejected")
+	}
+	g.Succeed(ctx, "2.2.2.2")
+	if wait := g.Check(ctx, "2.2.2.2"); wait != 0 {
+		t.Fatalf("wait=%v after success, want 0", wait)
+	}
+}
+
+func TestLoginGuard_ReleaseUndoesReservation(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	g := newTestLoginGuard(nil, &now)
+
+	if wait := g.Check(ctx, "1.1.1.1"); wait != 0 {
+		t.Fatalf("wait=%v", wait)
+	}
+	g.Release(ctx, "1.1.1.1")
+	// 撤销后可立即再次尝试（如补充两步验证码），也不留下失败计数。
+	if failures, _ := failAttempt(t, g, "1.1.1.1"); failures != 1 {
+		t.Fatalf("failures=%d, want 1", failures)
+	}
+	g.Release(ctx, "9.9.9.9")
+
+	var nilGuard *LoginGuard
+	nilGuard.Release(ctx, "1.1.1.1")
+}
+
+func TestLoginGuard_WindowResetAndSucceed(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	g := newTestLoginGuard(nil, &now)
+
+	failAttempt(t, g, "1.1.1.1")
+	now = now.Add(time.Second)
+	failAttempt(t, g, "1.1.1.1")
+	now = now.Add(2 * time.Minute)
+	if failures, _ := failAttempt(t, g, "1.1.1.1"); failures != 1 {
+		t.Fatalf("failures=%d after window, want 1", failures)
+	}
+
+	g.Succeed(ctx, "1.1.1.1")
+	if wait := g.Check(ctx, "1.1.1.1"); wait != 0 {
+		t.Fatalf("wait=%v after success, want 0", wait)
+	}
+}
+
+func TestLoginGuard_GlobalThrottle(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	g := newTestLoginGuard(nil, &now)
+
+	var locked bool
+	for i := 0; i < 5; i++ {
+		_, locked = failAttempt(t, g, "10.0.0."+string(rune('1'+i)))
+	}
+	if !locked {
+		t.Fatalf("expected global throttle on 5th failure")
+	}
+	// 全局限速不拒绝首次尝试，正确凭证仍可登录；同一 IP 的后续尝试需间隔 loginGuardGlobalInterval。
+	if wait := g.Check(ctx, "9.9.9.9"); wait != 0 {
+		t.Fatalf("wait=%v for unrelated ip, want 0", wait)
+	}
+	if wait := g.Check(ctx, "9.9.9.9"); wait != loginGuardGlobalInterval {
+		t.Fatalf("wait=%v, want global interval", wait)
+	}
+	g.Succeed(ctx, "9.9.9.9")
+	if wait := g.Check(ctx, "9.9.9.9"); wait != 0 {
+		t.Fatalf("wait=%v after success, want 0", wait)
+	}
+	g.Succeed(ctx, "9.9.9.9")
+
+	for i := 0; i < 2; i++ {
+		now = now.Add(loginGuardGlobalInterval)
+		failAttempt(t, g, "10.0.0.1")
+	}
+	lockouts, err := g.Lockouts(ctx)
+	if err != nil || len(lockouts) != 2 || lockouts[0].Scope != LoginLockoutScopeGlobal || lockouts[1].Key != "ip:10.0.0.1" {
+		t.Fatalf("lockouts=%+v err=%v", lockouts, err)
+	}
+
+	cleared, err := g.ClearAllLockouts(ctx)
+	if err != nil || cleared != 2 {
+		t.Fatalf("cleared=%d err=%v", cleared, err)
+	}
+	failAttempt(t, g, "9.9.9.9")
+	if wait := g.Check(ctx, "9.9.9.9"); wait != time.Second {
+		t.Fatalf("wait=%v after clearing, want base delay", wait)
+	}
+}
+
+func TestLoginGuard_DelayCappedAndDisabled(t *testing.T) {
+	g := NewLoginGuard(LoginGuardConfig{BaseDelay: time.Second}, nil)
+	if got := loginBackoffDelay(g.cfg.BaseDelay, 20); got != loginGuardMaxDelay {
+		t.Fatalf("delay=%v, want cap %v", got, loginGuardMaxDelay)
+	}
+
+	ctx := context.Background()
+	g = NewLoginGuard(LoginGuardConfig{}, nil)
+	for i := 0; i < 10; i++ {
+		if _, locked := failAttempt(t, g, "1.1.1.1"); locked {
+			t.Fatalf("lockout should be disabled when thresholds are 0")
+		}
+	}
+
+	var nilGuard *LoginGuard
+	if nilGuard.Check(ctx, "x") != 0 {
+		t.Fatalf("nil guard should allow")
+	}
+	nilGuard.Succeed(ctx, "x")
+	if items, err := nilGuard.Lockouts(ctx); err != nil || len(items) != 0 {
+		t.Fatalf("items=%v err=%v", items, err)
+	}
+}
+
+func TestRedisLoginAttemptStore(t *testing.T) {
+	mr := miniredis.RunT(t)
+	store, err := NewRedisLoginAttemptStore("redis://"+mr.Addr(), "", 0, "", 0, "", 1)
+	if err != nil {
+		t.Fatalf("NewRedisLoginAttemptStore: %v", err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+
+	ctx := context.Background()
+	now := time.Now()
+	g := newTestLoginGuard(store, &now)
+
+	failAttempt(t, g, "1.1.1.1")
+	if wait := g.Check(ctx, "1.1.1.1"); wait <= 0 || wait > time.Second {
+		t.Fatalf("wait=%v, want (0,1s]", wait)
+	}
+	if mr.TTL("liao:login:ip:1.1.1.1") <= 0 {
+		t.Fatalf("expected ttl on attempt key")
+	}
+	now = now.Add(time.Second)
+	failAttempt(t, g, "1.1.1.1")
+	now = now.Add(2 * time.Second)
+	if failures, locked := failAttempt(t, g, "1.1.1.1"); failures != 3 || !locked {
+		t.Fatalf("failures=%d locked=%v, want 3 true", failures, locked)
+	}
+	if wait := g.Check(ctx, "1.1.1.1"); wait < 9*time.Minute {
+		t.Fatalf("wait=%v, want lockout", wait)
+	}
+
+	// 另一个实例共享同一份状态。
+	other, err := NewRedisLoginAttemptStore("redis://"+mr.Addr(), "", 0, "", 0, "liao:login:", 1)
+	if err != nil {
+		t.Fatalf("NewRedisLoginAttemptStore: %v", err)
+	}
+	t.Cleanup(func() { _ = other.Close() })
+	lockouts, err := newTestLoginGuard(other, &now).Lockouts(ctx)
+	if err != nil || len(lockouts) != 1 || lockouts[0].IP != "1.1.1.1" || lockouts[0].Failures != 3 {
+		t.Fatalf("lockouts=%+v err=%v", lockouts, err)
+	}
+
+	if err := g.ClearLockout(ctx, "ip:1.1.1.1"); err != nil {
+		t.Fatalf("ClearLockout: %v", err)
+	}
+	if mr.Exists("liao:login:ip:1.1.1.1") {
+		t.Fatalf("expected attempt key removed")
+	}
+	if lockouts, err := g.Lockouts(ctx); err != nil || len(lockouts) != 0 {
+		t.Fatalf("lockouts=%+v err=%v", lockouts, err)
+	}
+
+	// 并发预占只放行一次；撤销后计数归零。
+	var allowed atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if g.Check(ctx, "5.5.5.5") == 0 {
+				allowed.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	if got := allowed.Load(); got != 1 {
+		t.Fatalf("allowed=%d, want 1", got)
+	}
+	g.Release(ctx, "5.5.5.5")
+	if got := mr.HGet("liao:login:ip:5.5.5.5", "failures"); got != "0" || mr.HGet("liao:login:ip:5.5.5.5", "last") != "" {
+		t.Fatalf("failures=%q after release", got)
+	}
+	if err := store.Release(ctx, "missing"); err != nil {
+		t.Fatalf("Release missing: %v", err)
+	}
+
+	// 过期的锁定不再列出。
+	if err := store.Lock(ctx, "global", now.Add(-time.Second)); err != nil {
+		t.Fatalf("Lock: %v", err)
+	}
+	if locked, err := store.Locked(ctx, now); err != nil || len(locked) != 0 {
+		t.Fatalf("locked=%v err=%v", locked, err)
+	}
+
+	if _, err := NewRedisLoginAttemptStore("redis://127.0.0.1:1", "", 0, "", 0, "", 1); err == nil {
+		t.Fatalf("expected ping error")
+	}
+}
+
+func TestNewLoginGuardFromConfig(t *testing.T) {
+	mr := miniredis.RunT(t)
+	g := newLoginGuardFromConfig(config.Config{
+		CacheType:                "redis",
+		RedisURL:                 "redis://" + mr.Addr(),
+		RedisTimeoutSeconds:      1,
+		AuthLoginMaxFailures:     2,
+		AuthLoginWindowSeconds:   60,
+		AuthLoginLockoutSeconds:  30,
+		AuthLoginBaseDelayMillis: 500,
+	})
+	store, ok := g.store.(*RedisLoginAttemptStore)
+	if !ok {
+		t.Fatalf("store=%T, want redis", g.store)
+	}
+	_ = store.Close()
+	if g.cfg.MaxFailuresPerIP != 2 || g.cfg.Window != time.Minute || g.cfg.Lockout != 30*time.Second || g.cfg.BaseDelay != 500*time.Millisecond {
+		t.Fatalf("cfg=%+v", g.cfg)
+	}
+
+	g = newLoginGuardFromConfig(config.Config{CacheType: "redis", RedisURL: "redis://127.0.0.1:1", RedisTimeoutSeconds: 1})
+	if _, ok := g.store.(*MemoryLoginAttemptStore); !ok {
+		t.Fatalf("store=%T, want memory fallback", g.store)
+	}
+}
+
+func TestLoginClientIP(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "http://example.com/api/auth/login", nil)
+	req.RemoteAddr = "10.0.0.1:5555"
+	req.Header.Set("X-Forwarded-For", "203.0.113.9, 10.0.0.2")
+	req.Header.Set("X-Real-IP", "198.51.100.1")
+
+	if got := loginClientIP(req, false); got != "10.0.0.1" {
+		t.Fatalf("ip=%q, want remote addr", got)
+	}
+	if got := loginClientIP(req, true); got != "203.0.113.9" {
+		t.Fatalf("ip=%q, want first forwarded hop", got)
+	}
+	req.Header.Set("X-Forwarded-For", "garbage")
+	if got := loginClientIP(req, true); got != "198.51.100.1" {
+		t.Fatalf("ip=%q, want X-Real-IP", got)
+	}
+	req.Header.Del("X-Real-IP")
+	req.RemoteAddr = "10.0.0.3"
+	if got := loginClientIP(req, true); got != "10.0.0.3" {
+		t.Fatalf("ip=%q, want bare remote addr", got)
+	}
+}
+
+func TestHandleAuthLogin_ThrottlesFailedAttempts(t *testing.T) {
+	now := time.Now()
+	a := &App{
+		cfg:        config.Config{AuthAccessCode: "code-1"},
+		jwt:        NewJWTService("secret-1", 1),
+		loginGuard: newTestLoginGuard(nil, &now),
+	}
+	login := func(code string) *httptest.ResponseRecorder {
+		form := url.Values{}
+		form.Set("accessCode", code)
+		req := newURLEncodedRequest(t, http.MethodPost, "http://example.com/api/auth/login", form)
+		req.RemoteAddr = "1.1.1.1:1234"
+		rr := httptest.NewRecorder()
+		a.handleAuthLogin(rr, req)
+		return rr
+	}
+
+	if rr := login("wrong"); rr.Code != http.StatusBadRequest {
+		t.Fatalf("status=%d, want 400", rr.Code)
+	}
+	rr := login("code-1")
+	if rr.Code != http.StatusTooManyRequests || rr.Header().Get("Retry-After") != "1" {
+		t.Fatalf("status=%d retryAfter=%q body=%s", rr.Code, rr.Header().Get("Retry-After"), rr.Body.String())
+	}
+	var resp map[string]any
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("unmarshal failed: %v", err)
+	}
+	if resp["code"] != float64(429) || resp["retryAfter"] != float64(1) {
+		t.Fatalf("resp=%v", resp)
+	}
+
+	now = now.Add(time.Second)
+	if rr := login("code-1"); rr.Code != http.StatusOK {
+		t.Fatalf("status=%d, want 200 after delay, body=%s", rr.Code, rr.Body.String())
+	}
+	// 登录成功后清除该来源的失败计数。
+	if wait := a.loginGuard.Check(context.Background(), "1.1.1.1"); wait != 0 {
+		t.Fatalf("wait=%v after success, want 0", wait)
+	}
+}
+
+func TestLoginLockoutHandlers(t *testing.T) {
+	a := &App{}
+	rr := httptest.NewRecorder()
+	a.handleListLoginLockouts(rr, httptest.NewRequest(http.MethodGet, "/api/auth/lockout/list", nil))
+	if got := decodeJSONBody(t, rr.Body); got["code"] != float64(-1) {
+		t.Fatalf("resp=%v", got)
+	}
+	rr = httptest.NewRecorder()
+	a.handleListLoginFailures(rr, httptest.NewRequest(http.MethodGet, "/api/auth/loginFailure/list", nil))
+	if got := decodeJSONBody(t, rr.Body); got["code"] != float64(-1) {
+		t.Fatalf("resp=%v", got)
+	}
+
+	now := time.Now()
+	a.loginGuard = newTestLoginGuard(nil, &now)
+	for i := 0; i < 3; i++ {
+		now = now.Add(loginGuardGlobalInterval)
+		failAttempt(t, a.loginGuard, "1.1.1.1")
+	}
+
+	rr = httptest.NewRecorder()
+	a.handleListLoginLockouts(rr, httptest.NewRequest(http.MethodGet, "/api/auth/lockout/list", nil))
+	var list struct {
+		Code int            `json:"code"`
+		Data []LoginLockout `json:"data"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &list); err != nil || list.Code != 0 || len(list.Data) != 1 || list.Data[0].Key != "ip:1.1.1.1" {
+		t.Fatalf("list=%+v err=%v", list, err)
+	}
+
+	clear := func(body string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		a.handleClearLoginLockout(rr, httptest.NewRequest(http.MethodPost, "/api/auth/lockout/clear", bytes.NewBufferString(body)))
+		return rr
+	}
+	if rr := clear("{"); rr.Code != http.StatusBadRequest {
+		t.Fatalf("status=%d, want 400", rr.Code)
+	}
+	if rr := clear(`{"key":"nope"}`); rr.Code != http.StatusBadRequest {
+		t.Fatalf("status=%d, want 400", rr.Code)
+	}
+	if rr := clear(`{"key":"ip:1.1.1.1"}`); rr.Code != http.StatusOK {
+		t.Fatalf("status=%d body=%s", rr.Code, rr.Body.String())
+	}
+	// 再失败 3 次：锁定 2.2.2.2，同时累计 6 次失败触发全局限速。
+	for i := 0; i < 3; i++ {
+		now = now.Add(loginGuardGlobalInterval)
+		failAttempt(t, a.loginGuard, "2.2.2.2")
+	}
+	rr = clear(`{"all":true}`)
+	got := decodeJSONBody(t, rr.Body)
+	if data, _ := got["data"].(map[string]any); got["code"] != float64(0) || data["cleared"] != float64(2) {
+		t.Fatalf("resp=%v", got)
+	}
+}
@@ -72,6 +72,8 @@ type MediaUploadService struct {
 	fileStore  *FileStorageService
 	imageSrv   *ImageServerService
 	httpClient *http.Client
+	// urlSigner 非空时为返回的 /upload 地址追加签名。
+	urlSigner *MediaURLSigner
 }
 
 func NewMediaUploadService(db *database.DB, serverPort int, fileStore *FileStorageService, imageSrv *ImageServerService, httpClient *http.Client) *MediaUploadService {
@@ -789,7 +791,7 @@ func (s *MediaUploadService) GetAllUploadImagesWithDetailsBySource(ctx context.C
 			if posterLocalPath != "" {
 				if abs, err := s.fileStore.resolveUploadAbsPath(posterLocalPath); err == nil {
 					if fi, err := os.Stat(abs); err == nil && !fi.IsDir() && fi.Size() > 0 {
-						dto.PosterURL = "/upload" + posterLocalPath
+						dto.PosterURL = s.urlSigner.SignURL("/upload" + posterLocalPath)
 					}
 				}
 			}
@@ -978,6 +980,14 @@ func (s *MediaUploadService) BatchDeleteMedia(ctx context.Context, userID string
 
 // --- internal helpers ---
 
+// SetURLSigner 设置上传文件地址与视频封面地址使用的签名器。
+func (s *MediaUploadService) SetURLSigner(signer *MediaURLSigner) {
+	if s == nil {
+		return
+	}
+	s.urlSigner = signer
+}
+
 func (s *MediaUploadService) convertToLocalURL(localPath string, hostHeader string) string {
 	if strings.TrimSpace(localPath) == "" {
 		return ""
@@ -991,7 +1001,7 @@ func (s *MediaUploadService) convertToLocalURL(localPath string, hostHeader stri
 	if host == "" {
 		host = fmt.Sprintf("localhost:%d", s.serverPort)
 	}
-	return "http://" + host + "/upload" + path
+	return s.urlSigner.SignURL("http://" + host + "/upload" + path)
 }
 
 func normalizeUploadLocalPathInput(localPath string) string {
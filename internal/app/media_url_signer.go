@@ -0,0 +1,220 @@
+package app
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	mediaURLExpParam = "exp"
+	mediaURLSigParam = "sig"
+	// mediaURLSignBatchMax 为 /api/signMediaUrls 单次可签发的地址数上限。
+	mediaURLSignBatchMax = 200
+)
+
+var (
+	errMediaURLSignatureMissing = errors.New("缺少媒体地址签名")
+	errMediaURLSignatureExpired = errors.New("媒体地址签名已过期")
+	errMediaURLSignatureInvalid = errors.New("媒体地址签名无效")
+)
+
+// 浏览器通过 <img>/<video> 直接请求、无法附带 Authorization 头的地址：静态目录按前缀匹配，
+// 接口按完整路径匹配（与 jwtMiddleware 的放行列表一致），避免 /api/douyin/downloadX 之类的无关路由被视为媒体地址。
+var (
+	mediaURLProtectedPrefixes = []string{"/upload/", "/lsp/"}
+	mediaURLProtectedPaths    = map[string]bool{
+		"/api/getMtPhotoThumb": true,
+		"/api/douyin/download": true,
+		"/api/douyin/cover":    true,
+	}
+)
+
+// MediaURLSigner 为媒体地址签发与校验 ?exp=&sig= 签名：sig 为 HMAC-SHA256(secret, 路径 + "\n" + 规范化查询串 + "\n" + exp)，
+// 规范化查询串为去掉 exp/sig 后按键排序编码的其余参数，改动任一参数（如抖音 key、缩略图 md5）都会使签名失效。
+// exp 向上取整到 TTL/4 的边界，同一时段内同一地址的签名保持不变，便于浏览器缓存。
+type MediaURLSigner struct {
+	secret []byte
+	ttl    time.Duration
+	now    func() time.Time
+}
+
+// NewMediaURLSigner 创建签名器；secret 为空时返回 nil（nil 签名器不改写地址）。
+func NewMediaURLSigner(secret string, ttl time.Duration) *MediaURLSigner {
+	if strings.TrimSpace(secret) == "" {
+		return nil
+	}
+	if ttl < time.Minute {
+		ttl = time.Minute
+	}
+	return &MediaURLSigner{secret: []byte(secret), ttl: ttl, now: time.Now}
+}
+
+// SignURL 为受保护路径追加 exp/sig 参数，支持绝对地址与相对地址；其余地址原样返回。
+func (s *MediaURLSigner) SignURL(rawURL string) string {
+	if s == nil {
+		return rawURL
+	}
+	return s.signURL(rawURL, s.expiry())
+}
+
+func (s *MediaURLSigner) signURL(rawURL string, exp int64) string {
+	if strings.TrimSpace(rawURL) == "" {
+		return rawURL
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil || !isMediaURLProtectedPath(u.Path) {
+		return rawURL
+	}
+	q := u.Query()
+	q.Set(mediaURLExpParam, strconv.FormatInt(exp, 10))
+	q.Set(mediaURLSigParam, s.mac(u.Path, q, exp))
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+// Verify 校验请求携带的签名是否覆盖请求路径与全部其余查询参数。
+func (s *MediaURLSigner) Verify(r *http.Request) error {
+	q := r.URL.Query()
+	sig := q.Get(mediaURLSigParam)
+	rawExp := q.Get(mediaURLExpParam)
+	if sig == "" || rawExp == "" {
+		return errMediaURLSignatureMissing
+	}
+	exp, err := strconv.ParseInt(rawExp, 10, 64)
+	if err != nil {
+		return errMediaURLSignatureInvalid
+	}
+	if !hmac.Equal([]byte(sig), []byte(s.mac(r.URL.Path, q, exp))) {
+		return errMediaURLSignatureInvalid
+	}
+	if s.now().Unix() > exp {
+		return errMediaURLSignatureExpired
+	}
+	return nil
+}
+
+func (s *MediaURLSigner) expiry() int64 {
+	bucket := int64(s.ttl / time.Second / 4)
+	if bucket <= 0 {
+		bucket = 1
+	}
+	exp := s.now().Add(s.ttl).Unix()
+	return (exp + bucket - 1) / bucket * bucket
+}
+
+func (s *MediaURLSigner) mac(path string, query url.Values, exp int64) string {
+	h := hmac.New(sha256.New, s.secret)
+	h.Write([]byte(path))
+	h.Write([]byte{'\n'})
+	h.Write([]byte(canonicalMediaURLQuery(query)))
+	h.Write([]byte{'\n'})
+	h.Write([]byte(strconv.FormatInt(exp, 10)))
+	return base64.RawURLEncoding.EncodeToString(h.Sum(nil))
+}
+
+// canonicalMediaURLQuery 返回去掉 exp/sig 后按键排序编码的查询串，签发与校验两侧参数顺序、编码差异不影响结果。
+func canonicalMediaURLQuery(query url.Values) string {
+	rest := make(url.Values, len(query))
+	for key, values := range query {
+		if key == mediaURLExpParam || key == mediaURLSigParam {
+			continue
+		}
+		rest[key] = values
+	}
+	return rest.Encode()
+}
+
+func isMediaURLProtectedPath(p string) bool {
+	if mediaURLProtectedPaths[p] {
+		return true
+	}
+	for _, prefix := range mediaURLProtectedPrefixes {
+		if strings.HasPrefix(p, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// signMediaURL 在启用媒体地址签名时为地址追加签名。
+func (a *App) signMediaURL(rawURL string) string {
+	if a == nil {
+		return rawURL
+	}
+	return a.mediaURLSigner.SignURL(rawURL)
+}
+
+// mediaSignatureMiddleware 要求 /upload、/lsp 请求携带有效签名或 Bearer Token；未启用签名时直接放行。
+func (a *App) mediaSignatureMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !a.verifyMediaRequest(w, r) {
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// verifyMediaRequest 校验免 Token 媒体请求的签名，失败时写入 403 并返回 false。
+// 携带有效 Bearer Token 的请求（如前端 fetch 下载）同样放行。
+func (a *App) verifyMediaRequest(w http.ResponseWriter, r *http.Request) bool {
+	if a.mediaURLSigner == nil {
+		return true
+	}
+	if authHeader := r.Header.Get("Authorization"); strings.HasPrefix(authHeader, "Bearer ") {
+		if _, ok := a.authenticateToken(r.Context(), strings.TrimPrefix(authHeader, "Bearer ")); ok {
+			return true
+		}
+	}
+	if err := a.mediaURLSigner.Verify(r); err != nil {
+		writeJSON(w, http.StatusForbidden, map[string]any{"code": 403, "msg": err.Error()})
+		return false
+	}
+	return true
+}
+
+// handleSignMediaURLs 为前端自行构造的媒体地址（如缩略图、抽帧结果）逐个签发签名，只签受保护的本站路径，不提供通配授权。
+func (a *App) handleSignMediaURLs(w http.ResponseWriter, r *http.Request) {
+	var in struct {
+		URLs []string `json:"urls"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"code": 400, "msg": "请求体格式错误"})
+		return
+	}
+	if len(in.URLs) > mediaURLSignBatchMax {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"code": 400, "msg": fmt.Sprintf("单次最多签发 %d 个地址", mediaURLSignBatchMax)})
+		return
+	}
+	if a.mediaURLSigner == nil {
+		writeJSON(w, http.StatusOK, map[string]any{
+			"code": 0,
+			"msg":  "success",
+			"data": map[string]any{"enabled": false, "urls": in.URLs},
+		})
+		return
+	}
+
+	exp := a.mediaURLSigner.expiry()
+	urls := make([]string, len(in.URLs))
+	for i, raw := range in.URLs {
+		urls[i] = a.mediaURLSigner.signURL(raw, exp)
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"code": 0,
+		"msg":  "success",
+		"data": map[string]any{
+			"enabled":   true,
+			"urls":      urls,
+			"expiresAt": exp,
+		},
+	})
+}
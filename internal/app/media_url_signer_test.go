@@ -0,0 +1,274 @@
+package app
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"liao/internal/config"
+)
+
+func newTestMediaURLSigner(now *time.Time) *MediaURLSigner {
+	s := NewMediaURLSigner("media-secret", time.Hour)
+	s.now = func() time.Time { return *now }
+	return s
+}
+
+func TestMediaURLSigner_SignAndVerify(t *testing.T) {
+	if NewMediaURLSigner(" ", time.Hour) != nil {
+		t.Fatalf("expected nil signer for empty secret")
+	}
+	var nilSigner *MediaURLSigner
+	if got := nilSigner.SignURL("/upload/a.jpg"); got != "/upload/a.jpg" {
+		t.Fatalf("nil signer should not rewrite, got %q", got)
+	}
+
+	now := time.Unix(1_700_000_000, 0)
+	s := newTestMediaURLSigner(&now)
+
+	signed := s.SignURL("http://example.com/upload/images/a%20b.jpg")
+	u, err := url.Parse(signed)
+	if err != nil || u.Host != "example.com" || u.Path != "/upload/images/a b.jpg" || u.Query().Get("sig") == "" {
+		t.Fatalf("signed=%q err=%v", signed, err)
+	}
+	if exp, _ := strconv.ParseInt(u.Query().Get("exp"), 10, 64); exp < now.Add(time.Hour).Unix() {
+		t.Fatalf("exp=%d, want at least now+ttl", exp)
+	}
+
+	verify := func(target string) error {
+		return s.Verify(httptest.NewRequest(http.MethodGet, target, nil))
+	}
+	// 接口按完整路径匹配，前缀相同的无关路由与非媒体地址不签名。
+	for _, raw := range []string{"/api/douyin/downloadX?key=k", "/api/douyin/coverList", "/api/getMtPhotoThumbs", "/uploadMedia"} {
+		if got := s.SignURL(raw); got != raw {
+			t.Fatalf("SignURL(%q)=%q, want unchanged", raw, got)
+		}
+	}
+	if got := s.SignURL("/api/douyin/download?key=k"); !strings.Contains(got, "sig=") {
+		t.Fatalf("expected media API signed, got %q", got)
+	}
+	if err := verify(signed); err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	// 签名绑定路径，换到其他文件无效。
+	if err := verify(strings.Replace(signed, "a%20b.jpg", "c.jpg", 1)); err != errMediaURLSignatureInvalid {
+		t.Fatalf("err=%v, want invalid", err)
+	}
+	if err := verify("/upload/images/c.jpg"); err != errMediaURLSignatureMissing {
+		t.Fatalf("err=%v, want missing", err)
+	}
+	if err := verify("/upload/images/c.jpg?exp=x&sig=y"); err != errMediaURLSignatureInvalid {
+		t.Fatalf("err=%v, want invalid", err)
+	}
+
+	// 同一时段内签名稳定，便于浏览器缓存。
+	now = now.Add(time.Minute)
+	if again := s.SignURL("http://example.com/upload/images/a%20b.jpg"); again != signed {
+		t.Fatalf("signature changed within bucket: %q vs %q", again, signed)
+	}
+
+	now = now.Add(2 * time.Hour)
+	if err := verify(signed); err != errMediaURLSignatureExpired {
+		t.Fatalf("err=%v, want expired", err)
+	}
+
+	// 已有查询参数保留，非受保护路径不改写。
+	douyin := s.SignURL("/api/douyin/download?key=k1&index=2")
+	if q, _ := url.ParseQuery(strings.SplitN(douyin, "?", 2)[1]); q.Get("key") != "k1" || q.Get("index") != "2" || q.Get("sig") == "" {
+		t.Fatalf("douyin=%q", douyin)
+	}
+	if err := verify(douyin); err != nil {
+		t.Fatalf("Verify douyin: %v", err)
+	}
+	if got := s.SignURL("/api/getHistoryUserList"); got != "/api/getHistoryUserList" {
+		t.Fatalf("unprotected path rewritten: %q", got)
+	}
+}
+
+func TestMediaURLSigner_SignsCanonicalQuery(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	s := newTestMediaURLSigner(&now)
+	verify := func(target string) error {
+		return s.Verify(httptest.NewRequest(http.MethodGet, target, nil))
+	}
+
+	signed := s.SignURL("/api/douyin/download?key=k1&index=2")
+	if err := verify(signed); err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	// 签名覆盖其余查询参数：换 key、改 index、追加或删除参数都无效。
+	for _, tampered := range []string{
+		strings.Replace(signed, "key=k1", "key=k2", 1),
+		strings.Replace(signed, "index=2", "index=3", 1),
+		signed + "&extra=1",
+		strings.Replace(signed, "index=2&", "", 1),
+	} {
+		if err := verify(tampered); err != errMediaURLSignatureInvalid {
+			t.Fatalf("%s: err=%v, want invalid", tampered, err)
+		}
+	}
+
+	// 参数顺序与编码方式不影响校验。
+	u, _ := url.Parse(signed)
+	q := u.Query()
+	reordered := "/api/douyin/download?sig=" + url.QueryEscape(q.Get("sig")) + "&index=2&exp=" + q.Get("exp") + "&key=k1"
+	if err := verify(reordered); err != nil {
+		t.Fatalf("Verify reordered: %v", err)
+	}
+
+	thumb := s.SignURL("/api/getMtPhotoThumb?size=s260&md5=m1")
+	if err := verify(strings.Replace(thumb, "md5=m1", "md5=m2", 1)); err != errMediaURLSignatureInvalid {
+		t.Fatalf("err=%v, want invalid md5", err)
+	}
+	// 旧版通配授权参数不再被接受。
+	if err := verify("/lsp/a.jpg?scope=media&exp=" + q.Get("exp") + "&sig=" + q.Get("sig")); err != errMediaURLSignatureInvalid {
+		t.Fatalf("err=%v, want invalid wildcard", err)
+	}
+}
+
+func TestMediaSignatureMiddleware(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNoContent) })
+
+	a := &App{}
+	rr := httptest.NewRecorder()
+	a.mediaSignatureMiddleware(next).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/upload/a.jpg", nil))
+	if rr.Code != http.StatusNoContent {
+		t.Fatalf("status=%d, want pass-through when signing disabled", rr.Code)
+	}
+
+	now := time.Now()
+	a = &App{jwt: NewJWTService("secret-1", 1), mediaURLSigner: newTestMediaURLSigner(&now)}
+	serve := func(req *http.Request) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		a.mediaSignatureMiddleware(next).ServeHTTP(rr, req)
+		return rr
+	}
+	if rr := serve(httptest.NewRequest(http.MethodGet, "/upload/a.jpg", nil)); rr.Code != http.StatusForbidden {
+		t.Fatalf("status=%d, want 403", rr.Code)
+	}
+	if rr := serve(httptest.NewRequest(http.MethodGet, a.signMediaURL("/upload/a.jpg"), nil)); rr.Code != http.StatusNoContent {
+		t.Fatalf("status=%d, want signed url allowed", rr.Code)
+	}
+
+	token, err := a.jwt.GenerateToken()
+	if err != nil {
+		t.Fatalf("GenerateToken: %v", err)
+	}
+	req := httptest.NewRequest(http.MethodGet, "/lsp/a.jpg", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	if rr := serve(req); rr.Code != http.StatusNoContent {
+		t.Fatalf("status=%d, want bearer token allowed", rr.Code)
+	}
+	req = httptest.NewRequest(http.MethodGet, "/lsp/a.jpg", nil)
+	req.Header.Set("Authorization", "Bearer bogus")
+	if rr := serve(req); rr.Code != http.StatusForbidden {
+		t.Fatalf("status=%d, want 403 for bad token", rr.Code)
+	}
+}
+
+func TestJWTMiddleware_MediaEndpointsRequireSignature(t *testing.T) {
+	now := time.Now()
+	a := &App{jwt: NewJWTService("secret-1", 1), mediaURLSigner: newTestMediaURLSigner(&now)}
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNoContent) })
+
+	for _, path := range []string{"/api/getMtPhotoThumb?size=s260&md5=x", "/api/douyin/download?key=k&index=0", "/api/douyin/cover?key=k"} {
+		rr := httptest.NewRecorder()
+		a.jwtMiddleware(next).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, path, nil))
+		if rr.Code != http.StatusForbidden {
+			t.Fatalf("%s: status=%d, want 403", path, rr.Code)
+		}
+		rr = httptest.NewRecorder()
+		a.jwtMiddleware(next).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, a.signMediaURL(path), nil))
+		if rr.Code != http.StatusNoContent {
+			t.Fatalf("%s: status=%d, want signed request allowed", path, rr.Code)
+		}
+	}
+
+	// 登录接口不受影响。
+	rr := httptest.NewRecorder()
+	a.jwtMiddleware(next).ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/api/auth/login", nil))
+	if rr.Code != http.StatusNoContent {
+		t.Fatalf("status=%d, want login passthrough", rr.Code)
+	}
+}
+
+func TestHandleSignMediaURLs(t *testing.T) {
+	post := func(a *App, body string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		a.handleSignMediaURLs(rr, httptest.NewRequest(http.MethodPost, "/api/signMediaUrls", strings.NewReader(body)))
+		return rr
+	}
+	decode := func(rr *httptest.ResponseRecorder) map[string]any {
+		var resp struct {
+			Code int            `json:"code"`
+			Data map[string]any `json:"data"`
+		}
+		if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil || resp.Code != 0 {
+			t.Fatalf("resp=%s err=%v", rr.Body.String(), err)
+		}
+		return resp.Data
+	}
+
+	if data := decode(post(&App{}, `{"urls":["/upload/a.jpg"]}`)); data["enabled"] != false || data["urls"].([]any)[0] != "/upload/a.jpg" {
+		t.Fatalf("data=%v", data)
+	}
+
+	now := time.Now()
+	a := &App{mediaURLSigner: newTestMediaURLSigner(&now)}
+	if rr := post(a, "{"); rr.Code != http.StatusBadRequest {
+		t.Fatalf("status=%d, want 400", rr.Code)
+	}
+	if rr := post(a, `{"urls":[`+strings.Repeat(`"/upload/a.jpg",`, mediaURLSignBatchMax)+`"/upload/a.jpg"]}`); rr.Code != http.StatusBadRequest {
+		t.Fatalf("status=%d, want 400 for oversized batch", rr.Code)
+	}
+
+	data := decode(post(a, `{"urls":["/upload/x.png","/api/getMtPhotoThumb?size=s260&md5=m","/api/getHistoryUserList"]}`))
+	urls, _ := data["urls"].([]any)
+	if data["enabled"] != true || len(urls) != 3 || data["expiresAt"].(float64) <= float64(now.Unix()) {
+		t.Fatalf("data=%v", data)
+	}
+	for _, raw := range urls[:2] {
+		if err := a.mediaURLSigner.Verify(httptest.NewRequest(http.MethodGet, raw.(string), nil)); err != nil {
+			t.Fatalf("Verify(%v): %v", raw, err)
+		}
+	}
+	if urls[2] != "/api/getHistoryUserList" {
+		t.Fatalf("unprotected url rewritten: %v", urls[2])
+	}
+	// 每个签名只对应自身地址。
+	other := strings.Replace(urls[0].(string), "/upload/x.png", "/upload/y.png", 1)
+	if err := a.mediaURLSigner.Verify(httptest.NewRequest(http.MethodGet, other, nil)); err != errMediaURLSignatureInvalid {
+		t.Fatalf("err=%v, want invalid", err)
+	}
+}
+
+func TestMediaURLSigning_ServiceURLs(t *testing.T) {
+	now := time.Now()
+	signer := newTestMediaURLSigner(&now)
+
+	media := NewMediaUploadService(nil, 8080, nil, nil, nil)
+	if got := media.convertToLocalURL("/images/a.jpg", "host:1"); got != "http://host:1/upload/images/a.jpg" {
+		t.Fatalf("unsigned=%q", got)
+	}
+	media.SetURLSigner(signer)
+	if got := media.convertToLocalURL("/images/a.jpg", "host:1"); !strings.HasPrefix(got, "http://host:1/upload/images/a.jpg?exp=") || !strings.Contains(got, "&sig=") {
+		t.Fatalf("signed=%q", got)
+	}
+
+	extract := &VideoExtractService{cfg: config.Config{ServerPort: 8080}}
+	extract.SetURLSigner(signer)
+	if got := extract.toUploadURL("extract/t1", ""); !strings.HasPrefix(got, "http://localhost:8080/upload/extract/t1?exp=") {
+		t.Fatalf("signed=%q", got)
+	}
+
+	var downloader *DouyinDownloaderService
+	downloader.SetURLSigner(signer)
+	if got := downloader.signURL("/api/douyin/cover?key=k"); got != "/api/douyin/cover?key=k" {
+		t.Fatalf("nil downloader should not sign, got %q", got)
+	}
+}
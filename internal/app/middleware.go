@@ -1,6 +1,7 @@
 package app
 
 import (
+	"context"
 	"log/slog"
 	"net/http"
 	"strings"
@@ -44,14 +45,44 @@ func (a *App) jwtMiddleware(next http.Handler) http.Handler {
 		}
 
 		switch r.URL.Path {
+		case "/api/auth/login", "/api/auth/refresh", "/api/auth/verify":
+			next.ServeHTTP(w, r)
+			return
 		// 说明：/api/getMtPhotoThumb 由 <img> 直接请求，浏览器无法附带 Authorization 头；
 		// 因此该接口需要放行，具体安全约束由 handler 内部的 size 白名单等策略兜底。
 		//
 		// 说明：/api/douyin/download 与 /api/douyin/cover 会被 <img>/<video> 直接请求用于预览；
 		// 抖音 CDN 对跨站媒体子资源有校验，必须经由本服务代请求（Referer/User-Agent 等）才能稳定预览，因此需要放行。
 		// 安全性依赖：key 为随机值且有过期时间，且只能通过已鉴权的 detail 接口生成。
-		case "/api/auth/login", "/api/auth/verify", "/api/getMtPhotoThumb", "/api/douyin/download", "/api/douyin/cover":
-			next.ServeHTTP(w, r)
+		//
+		// 开启 MEDIA_URL_SIGNING 后，以上媒体接口需携带有效的 exp/sig 签名（或 Bearer Token）。
+		case "/api/getMtPhotoThumb", "/api/douyin/download", "/api/douyin/cover":
+			if a.verifyMediaRequest(w, r) {
+				next.ServeHTTP(w, r)
+			}
+			return
+		}
+
+		// API Key（X-API-Key 头或 Bearer lk_...）：供脚本长期使用，仅可访问其授权范围内的接口。
+		if apiKey := requestAPIKey(r); apiKey != "" {
+			principal, ok := a.authenticateAPIKey(r, apiKey)
+			if !ok {
+				slog.Warn("API Key验证失败", "method", r.Method, "path", r.URL.Path)
+				writeJSON(w, http.StatusUnauthorized, map[string]any{
+					"code": 401,
+					"msg":  ErrAuthAPIKeyInvalid.Error(),
+				})
+				return
+			}
+			if !principal.AllowsPath(r.URL.Path) {
+				slog.Warn("API Key授权范围不足", "method", r.Method, "path", r.URL.Path, "subject", principal.Subject, "scopes", principal.Scopes)
+				writeJSON(w, http.StatusForbidden, map[string]any{
+					"code": 403,
+					"msg":  "API Key 未授权访问该接口",
+				})
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(withAuthPrincipal(r.Context(), principal)))
 			return
 		}
 
@@ -66,7 +97,8 @@ func (a *App) jwtMiddleware(next http.Handler) http.Handler {
 		}
 
 		tokenString := strings.TrimPrefix(authHeader, "Bearer ")
-		if !a.jwt.ValidateToken(tokenString) {
+		principal, ok := a.authenticateToken(r.Context(), tokenString)
+		if !ok {
 			slog.Warn("Token验证失败", "method", r.Method, "path", r.URL.Path)
 			writeJSON(w, http.StatusUnauthorized, map[string]any{
 				"code": 401,
@@ -75,6 +107,97 @@ func (a *App) jwtMiddleware(next http.Handler) http.Handler {
 			return
 		}
 
+		next.ServeHTTP(w, r.WithContext(withAuthPrincipal(r.Context(), principal)))
+	})
+}
+
+// authenticateToken 校验 Token 并解析调用者：启用会话存储时 Token 必须绑定未吊销的会话；
+// 账号 Token 以数据库中的当前角色为准（停用/删除后立即失效），共享访问码 Token 默认视为 admin，访问码登录关闭（含因账号启用两步验证而自动关闭）后一律拒绝。
+func (a *App) authenticateToken(ctx context.Context, tokenString string) (AuthPrincipal, bool) {
+	if a.jwt == nil {
+		return AuthPrincipal{}, false
+	}
+	claims, err := a.jwt.ParseToken(tokenString)
+	if err != nil {
+		return AuthPrincipal{}, false
+	}
+	if a.authSessions != nil && (claims.SessionID == "" || a.authSessions.IsRevoked(claims.SessionID)) {
+		return AuthPrincipal{}, false
+	}
+	if !claims.Account {
+		if a.accessCodeLoginDisabled(ctx) {
+			return AuthPrincipal{}, false
+		}
+		role := claims.Role
+		if !isValidAuthRole(role) {
+			role = AuthRoleAdmin
+		}
+		return AuthPrincipal{Subject: claims.Subject, Role: role, SessionID: claims.SessionID}, true
+	}
+
+	if a.authUsers == nil {
+		return AuthPrincipal{}, false
+	}
+	user, err := a.authUsers.Lookup(ctx, claims.Subject)
+	if err != nil {
+		slog.Warn("查询账号失败", "username", claims.Subject, "error", err)
+		return AuthPrincipal{}, false
+	}
+	if user == nil || user.Disabled {
+		return AuthPrincipal{}, false
+	}
+	return AuthPrincipal{Subject: user.Username, Role: user.Role, Account: true, SessionID: claims.SessionID}, true
+}
+
+// authViewerPostPaths 为只读角色也可调用的 POST 接口（仅查询或只影响调用者自身）。
+var authViewerPostPaths = map[string]bool{
+	"/api/auth/changePassword":     true,
+	"/api/auth/logout":             true,
+	"/api/auth/totp/setup":         true,
+	"/api/auth/totp/enable":        true,
+	"/api/auth/totp/disable":       true,
+	"/api/auth/totp/recoveryCodes": true,
+	"/api/getHistoryUserList":      true,
+	"/api/getFavoriteUserList":     true,
+	"/api/getMessageHistory":       true,
+	"/api/checkDuplicateMedia":     true,
+	"/api/resolveImagePort":        true,
+	"/api/douyin/account":          true,
+	"/api/douyin/detail":           true,
+	"/api/autoReply/dryRun":        true,
+	"/api/signMediaUrls":           true,
+}
+
+// roleMiddleware 为 /api 下所有接口设置默认角色要求：GET/HEAD 需 viewer，其余方法需 operator；
+// 更高要求由路由上的 requireRole 追加。白名单接口（无调用者）直接放行。
+func (a *App) roleMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		role := AuthRoleOperator
+		if r.Method == http.MethodGet || r.Method == http.MethodHead || authViewerPostPaths[r.URL.Path] {
+			role = AuthRoleViewer
+		}
+		a.enforceRole(w, r, next, role)
+	})
+}
+
+// requireRole 返回要求调用者角色不低于 role 的中间件，用于 buildRouter 中的管理类路由。
+func (a *App) requireRole(role string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			a.enforceRole(w, r, next, role)
+		})
+	}
+}
+
+func (a *App) enforceRole(w http.ResponseWriter, r *http.Request, next http.Handler, role string) {
+	principal, ok := authPrincipalFromContext(r.Context())
+	if !ok || r.Method == http.MethodOptions || principal.Allows(role) {
 		next.ServeHTTP(w, r)
+		return
+	}
+	slog.Warn("角色权限不足", "method", r.Method, "path", r.URL.Path, "subject", principal.Subject, "role", principal.Role, "required", role)
+	writeJSON(w, http.StatusForbidden, map[string]any{
+		"code": 403,
+		"msg":  "权限不足",
 	})
 }
@@ -0,0 +1,544 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"unicode/utf8"
+
+	"liao/internal/config"
+	"liao/internal/protocol"
+)
+
+// OutboundFilterAction 为出站过滤规则命中后的处理方式。
+type OutboundFilterAction string
+
+const (
+	// OutboundFilterPass 未命中，原样发送。
+	OutboundFilterPass OutboundFilterAction = "pass"
+	// OutboundFilterRewrite 按配置替换词语后发送。
+	OutboundFilterRewrite OutboundFilterAction = "rewrite"
+	// OutboundFilterRedact 将命中内容替换为 * 后发送。
+	OutboundFilterRedact OutboundFilterAction = "redact"
+	// OutboundFilterBlock 拦截，不发往上游并通知发送方。
+	OutboundFilterBlock OutboundFilterAction = "block"
+)
+
+// 内置出站过滤规则名称，按注册顺序执行（先改写再检查）。
+const (
+	OutboundFilterRuleRewrite    = "rewrite"
+	OutboundFilterRuleBannedWord = "banned-word"
+	OutboundFilterRuleDomain     = "domain"
+	OutboundFilterRulePhone      = "phone"
+)
+
+// ParseOutboundFilterAction 解析内置规则的处理方式；空值或 off 表示关闭该规则（返回空串）。
+func ParseOutboundFilterAction(raw string) (OutboundFilterAction, error) {
+	switch a := OutboundFilterAction(strings.ToLower(strings.TrimSpace(raw))); a {
+	case "", "off":
+		return "", nil
+	case OutboundFilterRedact, OutboundFilterBlock:
+		return a, nil
+	default:
+		return "", fmt.Errorf("未知的出站过滤处理方式: %s", raw)
+	}
+}
+
+// OutboundFilterConfig 为内置出站过滤规则配置；处理方式为空时关闭对应规则。
+type OutboundFilterConfig struct {
+	PhoneAction      OutboundFilterAction
+	BannedWords      []string
+	BannedWordAction OutboundFilterAction
+	BlockedDomains   []string
+	DomainAction     OutboundFilterAction
+	// Rewrites 为词语替换表（原词 -> 替换词，忽略大小写）。
+	Rewrites map[string]string
+}
+
+// configureOutboundFilter 按应用配置启用内置出站过滤规则；非法的处理方式关闭对应规则。
+func configureOutboundFilter(f *OutboundFilter, cfg config.Config) error {
+	var errs []error
+	parse := func(raw string) OutboundFilterAction {
+		action, err := ParseOutboundFilterAction(raw)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		return action
+	}
+	filterCfg := OutboundFilterConfig{
+		PhoneAction:      parse(cfg.WSFilterPhoneAction),
+		BannedWords:      cfg.WSFilterBannedWords,
+		BannedWordAction: parse(cfg.WSFilterBannedWordAction),
+		BlockedDomains:   cfg.WSFilterBlockedDomains,
+		DomainAction:     parse(cfg.WSFilterDomainAction),
+		Rewrites:         cfg.WSFilterRewrites,
+	}
+	if err := f.Configure(filterCfg); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// OutboundMessage 为一条待发往上游的消息；规则只检查和修改 Content（帧中的 msg 字段）。
+type OutboundMessage struct {
+	UserID  string
+	Act     string
+	Content string
+}
+
+// OutboundFilterRule 检查（必要时改写 msg.Content）一条出站消息，返回处理方式与原因；未命中返回 OutboundFilterPass。
+type OutboundFilterRule interface {
+	FilterOutbound(msg *OutboundMessage) (OutboundFilterAction, string)
+}
+
+// OutboundFilterRuleFunc 允许直接以函数注册过滤规则。
+type OutboundFilterRuleFunc func(msg *OutboundMessage) (OutboundFilterAction, string)
+
+func (f OutboundFilterRuleFunc) FilterOutbound(msg *OutboundMessage) (OutboundFilterAction, string) {
+	return f(msg)
+}
+
+// OutboundFilterHit 为单条规则的命中记录。
+type OutboundFilterHit struct {
+	Rule   string               `json:"rule"`
+	Action OutboundFilterAction `json:"action"`
+	Reason string               `json:"reason"`
+}
+
+// OutboundFilterDecision 为一次过滤的结论；Action 取命中规则中最严重的处理方式。
+type OutboundFilterDecision struct {
+	Action OutboundFilterAction `json:"action"`
+	Hits   []OutboundFilterHit  `json:"hits,omitempty"`
+}
+
+// Blocked 返回消息是否被拦截。
+func (d OutboundFilterDecision) Blocked() bool {
+	return d.Action == OutboundFilterBlock
+}
+
+// Reason 返回拦截原因（最后一条命中规则的原因）。
+func (d OutboundFilterDecision) Reason() string {
+	if len(d.Hits) == 0 {
+		return ""
+	}
+	return d.Hits[len(d.Hits)-1].Reason
+}
+
+type outboundFilterStage struct {
+	name string
+	rule OutboundFilterRule
+}
+
+// OutboundFilter 在下游消息发往上游前按注册顺序执行过滤规则；任一规则拦截即终止。
+type OutboundFilter struct {
+	mu     sync.RWMutex
+	stages []outboundFilterStage
+
+	passed    atomic.Int64
+	rewritten atomic.Int64
+	redacted  atomic.Int64
+	blocked   atomic.Int64
+}
+
+func NewOutboundFilter() *OutboundFilter {
+	return &OutboundFilter{}
+}
+
+// Register 在末尾追加过滤规则；同名规则已存在时原位替换。
+func (f *OutboundFilter) Register(name string, rule OutboundFilterRule) error {
+	if f == nil {
+		return fmt.Errorf("outbound filter not initialized")
+	}
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return fmt.Errorf("过滤规则名称不能为空")
+	}
+	if rule == nil {
+		return fmt.Errorf("过滤规则不能为空")
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i := range f.stages {
+		if f.stages[i].name == name {
+			f.stages[i].rule = rule
+			return nil
+		}
+	}
+	f.stages = append(f.stages, outboundFilterStage{name: name, rule: rule})
+	return nil
+}
+
+// Unregister 移除过滤规则，返回是否存在。
+func (f *OutboundFilter) Unregister(name string) bool {
+	if f == nil {
+		return false
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i := range f.stages {
+		if f.stages[i].name == name {
+			f.stages = append(f.stages[:i], f.stages[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Rules 返回按执行顺序排列的规则名称。
+func (f *OutboundFilter) Rules() []string {
+	if f == nil {
+		return nil
+	}
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	names := make([]string, 0, len(f.stages))
+	for _, stage := range f.stages {
+		names = append(names, stage.name)
+	}
+	return names
+}
+
+// Configure 按配置注册或移除内置规则；自定义规则不受影响。
+func (f *OutboundFilter) Configure(cfg OutboundFilterConfig) error {
+	if f == nil {
+		return fmt.Errorf("outbound filter not initialized")
+	}
+	builtins := []struct {
+		name string
+		rule OutboundFilterRule
+	}{
+		{OutboundFilterRuleRewrite, newOutboundRewriteRule(cfg.Rewrites)},
+		{OutboundFilterRuleBannedWord, newOutboundBannedWordRule(cfg.BannedWords, cfg.BannedWordAction)},
+		{OutboundFilterRuleDomain, newOutboundDomainRule(cfg.BlockedDomains, cfg.DomainAction)},
+		{OutboundFilterRulePhone, newOutboundPhoneRule(cfg.PhoneAction)},
+	}
+	for _, b := range builtins {
+		if b.rule == nil {
+			f.Unregister(b.name)
+			continue
+		}
+		if err := f.Register(b.name, b.rule); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Apply 过滤一条发往上游的原始帧，返回应发送的帧与结论；改写时只替换 msg 的值，其余字节保持不变。
+// 只检查私聊发送帧（act=touser_*）；其他帧（sign、匹配、ShowUserLoginInfo 等控制指令）原样放行。
+func (f *OutboundFilter) Apply(userID string, raw string) (string, OutboundFilterDecision) {
+	decision := OutboundFilterDecision{Action: OutboundFilterPass}
+	if f == nil {
+		return raw, decision
+	}
+	f.mu.RLock()
+	stages := append([]outboundFilterStage(nil), f.stages...)
+	f.mu.RUnlock()
+	if len(stages) == 0 {
+		return raw, decision
+	}
+
+	act, content, start, end, ok := locateOutboundChatMsg(raw)
+	if !ok || strings.TrimSpace(content) == "" {
+		return raw, decision
+	}
+
+	msg := &OutboundMessage{UserID: userID, Act: act, Content: content}
+	for _, stage := range stages {
+		action, reason := runOutboundFilterRule(stage, msg)
+		if action == OutboundFilterPass || action == "" {
+			continue
+		}
+		decision.Hits = append(decision.Hits, OutboundFilterHit{Rule: stage.name, Action: action, Reason: reason})
+		if outboundFilterSeverity(action) > outboundFilterSeverity(decision.Action) {
+			decision.Action = action
+		}
+		if action == OutboundFilterBlock {
+			break
+		}
+	}
+
+	switch decision.Action {
+	case OutboundFilterPass:
+		f.passed.Add(1)
+		return raw, decision
+	case OutboundFilterBlock:
+		f.blocked.Add(1)
+		slog.Warn("出站消息被拦截", "userID", userID, "act", msg.Act, "hits", decision.Hits)
+		return raw, decision
+	case OutboundFilterRedact:
+		f.redacted.Add(1)
+	default:
+		f.rewritten.Add(1)
+	}
+	slog.Info("出站消息已改写", "userID", userID, "act", msg.Act, "action", decision.Action, "hits", decision.Hits)
+	if msg.Content == content {
+		return raw, decision
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(msg.Content); err != nil {
+		return raw, decision
+	}
+	return raw[:start] + strings.TrimSuffix(buf.String(), "\n") + raw[end:], decision
+}
+
+// locateOutboundChatMsg 在私聊发送帧中定位顶层 msg 字符串值，返回 act、msg 内容及其在 raw 中的字节区间 [start, end)。
+// 非 JSON 对象、非私聊帧或 msg 不是字符串时 ok=false；重复字段按最后一次出现为准（与 json.Unmarshal 一致）。
+func locateOutboundChatMsg(raw string) (act string, content string, start int, end int, ok bool) {
+	dec := json.NewDecoder(strings.NewReader(raw))
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+		return "", "", 0, 0, false
+	}
+	start = -1
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return "", "", 0, 0, false
+		}
+		key, _ := tok.(string)
+		var value json.RawMessage
+		if err := dec.Decode(&value); err != nil {
+			return "", "", 0, 0, false
+		}
+		switch key {
+		case "act":
+			act = ""
+			_ = json.Unmarshal(value, &act)
+		case "msg":
+			start = -1
+			if json.Unmarshal(value, &content) == nil {
+				end = int(dec.InputOffset())
+				start = end - len(value)
+			}
+		}
+	}
+	if start < 0 || !isOutboundChatAct(act) {
+		return "", "", 0, 0, false
+	}
+	return act, content, start, end, true
+}
+
+// runOutboundFilterRule 执行单条规则；panic 视为拦截，避免异常规则让消息绕过过滤。
+func runOutboundFilterRule(stage outboundFilterStage, msg *OutboundMessage) (action OutboundFilterAction, reason string) {
+	defer func() {
+		if r := recover(); r != nil {
+			slog.Error("出站过滤规则异常", "rule", stage.name, "panic", r)
+			action, reason = OutboundFilterBlock, "出站过滤规则异常"
+		}
+	}()
+	return stage.rule.FilterOutbound(msg)
+}
+
+func outboundFilterSeverity(action OutboundFilterAction) int {
+	switch action {
+	case OutboundFilterRewrite:
+		return 1
+	case OutboundFilterRedact:
+		return 2
+	case OutboundFilterBlock:
+		return 3
+	default:
+		return 0
+	}
+}
+
+func (f *OutboundFilter) stats() map[string]any {
+	return map[string]any{
+		"rules":     f.Rules(),
+		"passed":    f.passed.Load(),
+		"rewritten": f.rewritten.Load(),
+		"redacted":  f.redacted.Load(),
+		"blocked":   f.blocked.Load(),
+	}
+}
+
+// buildOutboundFilterRejectMessage 构造发回发送方的拦截帧（code=-11）。
+func buildOutboundFilterRejectMessage(decision OutboundFilterDecision) string {
+	rule := ""
+	if len(decision.Hits) > 0 {
+		rule = decision.Hits[len(decision.Hits)-1].Rule
+	}
+	return encodeLocalFrame(&protocol.FilterReject{
+		Content:  decision.Reason() + "，消息未发送",
+		Rejected: true,
+		Rule:     rule,
+	})
+}
+
+// outboundPatternRule 为基于正则匹配的内置规则：拦截或把命中片段替换为 *。
+type outboundPatternRule struct {
+	re     *regexp.Regexp
+	action OutboundFilterAction
+	// accept 过滤误命中（如手机号前后紧跟数字）；为 nil 时全部命中有效。
+	accept func(content string, start, end int) bool
+	reason func(match string) string
+}
+
+func (r *outboundPatternRule) FilterOutbound(msg *OutboundMessage) (OutboundFilterAction, string) {
+	var (
+		out     strings.Builder
+		last    int
+		matched string
+	)
+	for _, loc := range r.re.FindAllStringIndex(msg.Content, -1) {
+		if r.accept != nil && !r.accept(msg.Content, loc[0], loc[1]) {
+			continue
+		}
+		if matched == "" {
+			matched = msg.Content[loc[0]:loc[1]]
+		}
+		if r.action == OutboundFilterBlock {
+			break
+		}
+		out.WriteString(msg.Content[last:loc[0]])
+		out.WriteString(strings.Repeat("*", utf8.RuneCountInString(msg.Content[loc[0]:loc[1]])))
+		last = loc[1]
+	}
+	if matched == "" {
+		return OutboundFilterPass, ""
+	}
+	if r.action == OutboundFilterRedact {
+		out.WriteString(msg.Content[last:])
+		msg.Content = out.String()
+	}
+	return r.action, r.reason(matched)
+}
+
+// mainlandMobilePattern 匹配大陆手机号（可带 +86/86 前缀及分隔符）。
+var mainlandMobilePattern = regexp.MustCompile(`(?:\+?86[-\s]?)?1[3-9]\d{9}`)
+
+func newOutboundPhoneRule(action OutboundFilterAction) OutboundFilterRule {
+	if action == "" {
+		return nil
+	}
+	return &outboundPatternRule{
+		re:     mainlandMobilePattern,
+		action: action,
+		accept: func(content string, start, end int) bool {
+			return !isASCIIDigitAt(content, start-1) && !isASCIIDigitAt(content, end)
+		},
+		reason: func(string) string { return "消息包含手机号" },
+	}
+}
+
+func newOutboundBannedWordRule(words []string, action OutboundFilterAction) OutboundFilterRule {
+	re := compileOutboundWordPattern(words)
+	if re == nil || action == "" {
+		return nil
+	}
+	return &outboundPatternRule{
+		re:     re,
+		action: action,
+		reason: func(match string) string { return "消息包含违禁词「" + match + "」" },
+	}
+}
+
+func newOutboundDomainRule(domains []string, action OutboundFilterAction) OutboundFilterRule {
+	quoted := make([]string, 0, len(domains))
+	for _, d := range domains {
+		d = strings.Trim(strings.ToLower(strings.TrimSpace(d)), ".")
+		if d != "" {
+			quoted = append(quoted, regexp.QuoteMeta(d))
+		}
+	}
+	if len(quoted) == 0 || action == "" {
+		return nil
+	}
+	sort.Slice(quoted, func(i, j int) bool { return len(quoted[i]) > len(quoted[j]) })
+	// 命中域名本身及其子域名，连同协议、端口和路径一起处理。
+	re := regexp.MustCompile(`(?i)(?:https?://)?(?:[a-z0-9-]+\.)*(?:` + strings.Join(quoted, "|") + `)(?::\d+)?(?:/\S*)?`)
+	return &outboundPatternRule{
+		re:     re,
+		action: action,
+		accept: func(content string, start, end int) bool {
+			return !isDomainCharAt(content, start-1) && !isDomainLabelCharAt(content, end)
+		},
+		reason: func(match string) string { return "消息包含被屏蔽的链接（" + match + "）" },
+	}
+}
+
+// outboundRewriteRule 按替换表改写词语（忽略大小写，长词优先）。
+type outboundRewriteRule struct {
+	re           *regexp.Regexp
+	replacements map[string]string
+}
+
+func newOutboundRewriteRule(rewrites map[string]string) OutboundFilterRule {
+	replacements := make(map[string]string, len(rewrites))
+	words := make([]string, 0, len(rewrites))
+	for from, to := range rewrites {
+		from = strings.TrimSpace(from)
+		if from == "" {
+			continue
+		}
+		replacements[strings.ToLower(from)] = to
+		words = append(words, from)
+	}
+	re := compileOutboundWordPattern(words)
+	if re == nil {
+		return nil
+	}
+	return &outboundRewriteRule{re: re, replacements: replacements}
+}
+
+func (r *outboundRewriteRule) FilterOutbound(msg *OutboundMessage) (OutboundFilterAction, string) {
+	var hits []string
+	out := r.re.ReplaceAllStringFunc(msg.Content, func(match string) string {
+		hits = append(hits, match)
+		return r.replacements[strings.ToLower(match)]
+	})
+	if len(hits) == 0 {
+		return OutboundFilterPass, ""
+	}
+	msg.Content = out
+	return OutboundFilterRewrite, "已替换词语「" + strings.Join(hits, "、") + "」"
+}
+
+// compileOutboundWordPattern 将词表编译为忽略大小写的正则（长词优先）；词表为空返回 nil。
+func compileOutboundWordPattern(words []string) *regexp.Regexp {
+	quoted := make([]string, 0, len(words))
+	seen := make(map[string]struct{}, len(words))
+	for _, w := range words {
+		w = strings.TrimSpace(w)
+		key := strings.ToLower(w)
+		if w == "" {
+			continue
+		}
+		if _, ok := seen[key]; ok {
+			continue
+		}
+		seen[key] = struct{}{}
+		quoted = append(quoted, regexp.QuoteMeta(w))
+	}
+	if len(quoted) == 0 {
+		return nil
+	}
+	sort.Slice(quoted, func(i, j int) bool { return len(quoted[i]) > len(quoted[j]) })
+	return regexp.MustCompile(`(?i)(?:` + strings.Join(quoted, "|") + `)`)
+}
+
+func isASCIIDigitAt(s string, i int) bool {
+	return i >= 0 && i < len(s) && s[i] >= '0' && s[i] <= '9'
+}
+
+func isDomainLabelCharAt(s string, i int) bool {
+	if i < 0 || i >= len(s) {
+		return false
+	}
+	c := s[i]
+	return c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '-'
+}
+
+func isDomainCharAt(s string, i int) bool {
+	return isDomainLabelCharAt(s, i) || i >= 0 && i < len(s) && s[i] == '.'
+}
@@ -0,0 +1,286 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"liao/internal/config"
+	"liao/internal/protocol"
+)
+
+func touserFrame(content string) string {
+	return buildTouserPayload("u1", "t1", "Bob", content)
+}
+
+func outboundContent(t *testing.T, raw string) string {
+	t.Helper()
+	var node map[string]any
+	if err := json.Unmarshal([]byte(raw), &node); err != nil {
+		t.Fatalf("decode %q: %v", raw, err)
+	}
+	return toString(node["msg"])
+}
+
+func TestParseOutboundFilterAction(t *testing.T) {
+	for raw, want := range map[string]OutboundFilterAction{"": "", " OFF ": "", "redact": OutboundFilterRedact, "Block": OutboundFilterBlock} {
+		if got, err := ParseOutboundFilterAction(raw); err != nil || got != want {
+			t.Fatalf("%q: got=%q err=%v", raw, got, err)
+		}
+	}
+	if _, err := ParseOutboundFilterAction("rewrite"); err == nil {
+		t.Fatalf("expected error")
+	}
+}
+
+func TestOutboundFilter_BuiltinRules(t *testing.T) {
+	f := NewOutboundFilter()
+	if err := f.Configure(OutboundFilterConfig{
+		PhoneAction:      OutboundFilterRedact,
+		BannedWords:      []string{"代练", "", "代练"},
+		BannedWordAction: OutboundFilterBlock,
+		BlockedDomains:   []string{"Example.com"},
+		DomainAction:     OutboundFilterRedact,
+		Rewrites:         map[string]string{"微信": "V", " ": "x"},
+	}); err != nil {
+		t.Fatalf("Configure: %v", err)
+	}
+	if got := strings.Join(f.Rules(), ","); got != "rewrite,banned-word,domain,phone" {
+		t.Fatalf("rules=%s", got)
+	}
+
+	cases := []struct {
+		name    string
+		content string
+		action  OutboundFilterAction
+		want    string
+	}{
+		{"clean", "你好", OutboundFilterPass, "你好"},
+		{"phone", "电话 13812345678 或 +86 13912345678", OutboundFilterRedact, "电话 *********** 或 ***************"},
+		{"longDigits", "订单号 813812345678", OutboundFilterPass, "订单号 813812345678"},
+		{"rewrite", "加微信吧", OutboundFilterRewrite, "加V吧"},
+		{"domain", "看 https://m.EXAMPLE.com/a?b=1 和 example.community", OutboundFilterRedact, "看 *************************** 和 example.community"},
+		{"notSubdomain", "badexample.com", OutboundFilterPass, "badexample.com"},
+		{"banned", "找代练吗 13812345678", OutboundFilterBlock, ""},
+	}
+	for _, tc := range cases {
+		raw := touserFrame(tc.content)
+		out, decision := f.Apply("u1", raw)
+		if decision.Action != tc.action {
+			t.Fatalf("%s: decision=%+v", tc.name, decision)
+		}
+		if tc.action == OutboundFilterBlock {
+			if out != raw || !strings.Contains(decision.Reason(), "代练") {
+				t.Fatalf("%s: out=%s reason=%s", tc.name, out, decision.Reason())
+			}
+			continue
+		}
+		if got := outboundContent(t, out); got != tc.want {
+			t.Fatalf("%s: content=%q, want %q", tc.name, got, tc.want)
+		}
+	}
+
+	// 非私聊帧原样放行：不带 msg 的指令帧，以及 msg 为用户 ID 等非聊天内容的控制帧。
+	for _, raw := range []string{
+		`{"act":"random","id":"u1"}`,
+		`{"act":"ShowUserLoginInfo","id":"u1","msg":"13812345678"}`,
+	} {
+		if out, decision := f.Apply("u1", raw); out != raw || decision.Action != OutboundFilterPass {
+			t.Fatalf("out=%s decision=%+v", out, decision)
+		}
+	}
+	stats := f.stats()
+	if stats["blocked"] != int64(1) || stats["redacted"] != int64(2) || stats["rewritten"] != int64(1) {
+		t.Fatalf("stats=%v", stats)
+	}
+
+	// 关闭后移除对应内置规则。
+	if err := f.Configure(OutboundFilterConfig{PhoneAction: OutboundFilterBlock}); err != nil {
+		t.Fatalf("Configure: %v", err)
+	}
+	if got := strings.Join(f.Rules(), ","); got != "phone" {
+		t.Fatalf("rules=%s", got)
+	}
+}
+
+func TestOutboundFilter_CustomRules(t *testing.T) {
+	var nilFilter *OutboundFilter
+	if out, decision := nilFilter.Apply("u1", "x"); out != "x" || decision.Blocked() {
+		t.Fatalf("nil filter should pass")
+	}
+
+	f := NewOutboundFilter()
+	if err := f.Register("", OutboundFilterRuleFunc(nil)); err == nil {
+		t.Fatalf("expected error for empty name")
+	}
+	if err := f.Register("x", nil); err == nil {
+		t.Fatalf("expected error for nil rule")
+	}
+	_ = f.Register("panic", OutboundFilterRuleFunc(func(*OutboundMessage) (OutboundFilterAction, string) { panic("boom") }))
+	_ = f.Register("upper", OutboundFilterRuleFunc(func(msg *OutboundMessage) (OutboundFilterAction, string) {
+		msg.Content = strings.ToUpper(msg.Content)
+		return OutboundFilterRewrite, "upper"
+	}))
+	// 异常规则视为拦截，不能让消息绕过过滤。
+	raw := touserFrame("hi")
+	out, decision := f.Apply("u1", raw)
+	if !decision.Blocked() || out != raw || decision.Hits[0].Rule != "panic" {
+		t.Fatalf("out=%s decision=%+v", out, decision)
+	}
+	if !f.Unregister("panic") || f.Unregister("panic") {
+		t.Fatalf("Unregister mismatch")
+	}
+	out, decision = f.Apply("u1", raw)
+	if decision.Action != OutboundFilterRewrite || len(decision.Hits) != 1 || outboundContent(t, out) != "HI" {
+		t.Fatalf("out=%s decision=%+v", out, decision)
+	}
+}
+
+func TestOutboundFilter_RewritePreservesFrame(t *testing.T) {
+	f := NewOutboundFilter()
+	_ = f.Configure(OutboundFilterConfig{PhoneAction: OutboundFilterRedact})
+
+	// 只替换 msg 的值：大整数不变成浮点数、字段顺序与空白保持原样、HTML 字符不被转义。
+	raw := `{"id":"u1", "act":"touser_t1_Bob","msg":"<b>13812345678</b> & \u4f60","tid":12345678901234567890}`
+	want := `{"id":"u1", "act":"touser_t1_Bob","msg":"<b>***********</b> & 你","tid":12345678901234567890}`
+	if out, decision := f.Apply("u1", raw); out != want || decision.Action != OutboundFilterRedact {
+		t.Fatalf("out=%s decision=%+v", out, decision)
+	}
+
+	for _, raw := range []string{`[1,2]`, `{"act":"touser_t1_Bob","msg":1380000000000}`, `{"act":"touser_t1_Bob","msg":"13812345678"`} {
+		if out, decision := f.Apply("u1", raw); out != raw || decision.Action != OutboundFilterPass {
+			t.Fatalf("raw=%s out=%s decision=%+v", raw, out, decision)
+		}
+	}
+}
+
+func TestUpstreamWebSocketManager_SendToUpstream_Filtered(t *testing.T) {
+	m := NewUpstreamWebSocketManager(nil, "ws://unused", nil, nil, nil)
+	outbox := newSpyUpstreamOutbox()
+	m.SetOutbox(outbox)
+	_ = m.OutboundFilter().Configure(OutboundFilterConfig{BannedWords: []string{"代练"}, BannedWordAction: OutboundFilterBlock})
+
+	// 同身份的其他下游会话与旁观者不会收到拦截通知，只由调用方决定是否回复发送方。
+	tab := httptest.NewRecorder()
+	observer := httptest.NewRecorder()
+	m.mu.Lock()
+	m.downstreamSessions["u1"] = map[*DownstreamSession]struct{}{NewDownstreamSession(newSSEDownstreamTransport(tab)): {}}
+	m.observerSessions["u1"] = map[*DownstreamSession]struct{}{NewDownstreamSession(newSSEDownstreamTransport(observer)): {}}
+	m.mu.Unlock()
+
+	// 自动回复等服务端发送方同样经过出站过滤：被拦截的消息既不发送也不入队。
+	decision := m.SendToUpstream("u1", touserFrame("要代练吗"))
+	if !decision.Blocked() || decision.Reason() == "" {
+		t.Fatalf("decision=%+v", decision)
+	}
+	if tab.Body.Len() != 0 || observer.Body.Len() != 0 {
+		t.Fatalf("reject broadcast: tab=%q observer=%q", tab.Body.String(), observer.Body.String())
+	}
+	if stats := m.outboundFilter.stats(); stats["blocked"] != int64(1) {
+		t.Fatalf("stats=%v", stats)
+	}
+	if outbox.nextID != 0 {
+		t.Fatalf("blocked message enqueued")
+	}
+}
+
+func TestConfigureOutboundFilter_FromConfig(t *testing.T) {
+	f := NewOutboundFilter()
+	err := configureOutboundFilter(f, config.Config{WSFilterPhoneAction: "bad", WSFilterBannedWords: []string{"x"}, WSFilterBannedWordAction: "redact"})
+	if err == nil {
+		t.Fatalf("expected error for bad phone action")
+	}
+	if got := strings.Join(f.Rules(), ","); got != "banned-word" {
+		t.Fatalf("rules=%s", got)
+	}
+}
+
+func TestHandleWebSocket_OutboundFilter(t *testing.T) {
+	received := make(chan string, 10)
+	tracker := &wsConnTracker{}
+	upstream := newUpstreamWSServer(t, func(conn *websocket.Conn) {
+		tracker.add(conn)
+		for {
+			_, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			received <- string(data)
+		}
+	})
+	t.Cleanup(func() {
+		tracker.closeAll()
+		upstream.Close()
+	})
+
+	wsManager := NewUpstreamWebSocketManager(nil, toWSURL(upstream.URL), nil, nil, nil)
+	t.Cleanup(wsManager.CloseAllConnections)
+	if err := wsManager.OutboundFilter().Configure(OutboundFilterConfig{
+		PhoneAction:      OutboundFilterRedact,
+		BannedWords:      []string{"代练"},
+		BannedWordAction: OutboundFilterBlock,
+	}); err != nil {
+		t.Fatalf("Configure: %v", err)
+	}
+
+	jwtService := NewJWTService("secret-1", 1)
+	token, err := jwtService.GenerateToken()
+	if err != nil {
+		t.Fatalf("GenerateToken failed: %v", err)
+	}
+	app := &App{jwt: jwtService, wsManager: wsManager}
+	backend := httptest.NewServer(http.HandlerFunc(app.handleWebSocket))
+	t.Cleanup(backend.Close)
+
+	downstream, _, err := websocket.DefaultDialer.Dial(toWSURL(backend.URL)+"/ws?token="+url.QueryEscape(token), nil)
+	if err != nil {
+		t.Fatalf("dial downstream failed: %v", err)
+	}
+	t.Cleanup(func() { _ = downstream.Close() })
+
+	readUpstream := func() string {
+		t.Helper()
+		select {
+		case got := <-received:
+			return got
+		case <-time.After(2 * time.Second):
+			t.Fatalf("timeout waiting upstream frame")
+			return ""
+		}
+	}
+
+	_ = downstream.WriteMessage(websocket.TextMessage, []byte(`{"act":"sign","id":"u1"}`))
+	readUpstream()
+
+	_ = downstream.WriteMessage(websocket.TextMessage, []byte(touserFrame("要代练吗")))
+	reject := readDownstreamCode(t, downstream, protocol.CodeFiltered)
+	if reject["rejected"] != true || reject["rule"] != OutboundFilterRuleBannedWord || !strings.Contains(toString(reject["content"]), "代练") {
+		t.Fatalf("reject=%v", reject)
+	}
+
+	_ = downstream.WriteMessage(websocket.TextMessage, []byte(touserFrame("call 13812345678")))
+	if got := outboundContent(t, readUpstream()); got != "call ***********" {
+		t.Fatalf("upstream content=%q", got)
+	}
+	if stats := wsManager.GetConnectionStats()["outboundFilter"].(map[string]any); stats["blocked"] != int64(1) {
+		t.Fatalf("stats=%v", stats)
+	}
+}
+
+func TestUpstreamWebSocketManager_SendScheduled_Filtered(t *testing.T) {
+	m := NewUpstreamWebSocketManager(&http.Client{Timeout: time.Second}, "ws://unused", nil, nil, nil)
+	t.Cleanup(m.CloseAllConnections)
+	_ = m.OutboundFilter().Configure(OutboundFilterConfig{BlockedDomains: []string{"example.com"}, DomainAction: OutboundFilterBlock})
+
+	err := m.SendScheduled(context.Background(), "u1", scheduledTestSign, touserFrame("见 example.com"))
+	if err == nil || !strings.Contains(err.Error(), "出站过滤") {
+		t.Fatalf("err=%v", err)
+	}
+}
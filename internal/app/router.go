@@ -15,22 +15,68 @@ func (a *App) buildRouter() http.Handler {
 
 	// WebSocket 入口（握手 token 通过 query 参数校验）
 	r.Get("/ws", a.handleWebSocket)
+	// SSE 降级通道：网络剥离 WebSocket 升级时使用，下行 GET /sse，上行 POST /sse/send
+	r.Get("/sse", a.handleSSE)
+	r.Post("/sse/send", a.handleSSESend)
 
 	// 静态上传文件
-	r.Handle("/upload/*", a.uploadFileServer())
-	r.Handle("/lsp/*", a.lspFileServer())
+	r.With(a.mediaSignatureMiddleware).Handle("/upload/*", a.uploadFileServer())
+	r.With(a.mediaSignatureMiddleware).Handle("/lsp/*", a.lspFileServer())
 
 	// API
 	r.Route("/api", func(api chi.Router) {
 		api.Use(a.jwtMiddleware)
+		// 审计：记录写操作（含被角色拦截的调用），需在 roleMiddleware 之前。
+		api.Use(a.auditMiddleware)
+		// 角色：GET 需 viewer、写操作需 operator；管理类接口额外挂 admin 要求。
+		api.Use(a.roleMiddleware)
+		admin := a.requireRole(AuthRoleAdmin)
 
 		api.Route("/auth", func(ar chi.Router) {
 			ar.Post("/login", a.handleAuthLogin)
+			ar.Post("/refresh", a.handleAuthRefresh)
+			ar.Post("/logout", a.handleAuthLogout)
+			ar.With(admin).Post("/revokeAll", a.handleAuthRevokeAll)
 			ar.Get("/verify", a.handleAuthVerify)
+			ar.Get("/me", a.handleAuthMe)
+			ar.Post("/changePassword", a.handleAuthChangePassword)
+			ar.With(admin).Get("/lockout/list", a.handleListLoginLockouts)
+			ar.With(admin).Post("/lockout/clear", a.handleClearLoginLockout)
+			ar.With(admin).Get("/loginFailure/list", a.handleListLoginFailures)
+			// 两步验证（仅账号登录，作用于调用者自身）
+			ar.Get("/totp/status", a.handleAuthTOTPStatus)
+			ar.Post("/totp/setup", a.handleAuthTOTPSetup)
+			ar.Post("/totp/enable", a.handleAuthTOTPEnable)
+			ar.Post("/totp/disable", a.handleAuthTOTPDisable)
+			ar.Post("/totp/recoveryCodes", a.handleAuthTOTPRecoveryCodes)
 		})
 
+		// 登录账号管理（仅 admin）
+		api.Route("/authUser", func(ur chi.Router) {
+			ur.Use(admin)
+			ur.Get("/list", a.handleListAuthUsers)
+			ur.Post("/create", a.handleCreateAuthUser)
+			ur.Post("/update", a.handleUpdateAuthUser)
+			ur.Post("/delete", a.handleDeleteAuthUser)
+			ur.Post("/resetTotp", a.handleResetAuthUserTOTP)
+		})
+
+		// API Key 管理（仅 admin；API Key 本身不能调用）
+		api.Route("/apiKey", func(kr chi.Router) {
+			kr.Use(admin)
+			kr.Get("/list", a.handleListAuthAPIKeys)
+			kr.Post("/create", a.handleCreateAuthAPIKey)
+			kr.Post("/update", a.handleUpdateAuthAPIKey)
+			kr.Post("/delete", a.handleDeleteAuthAPIKey)
+		})
+
+		// 写操作审计记录（仅 admin）
+		api.With(admin).Get("/audit/list", a.handleListAuditLogs)
+
 		// Runtime public config（登录后客户端运行时读取，支持 Docker -e 注入）
 		api.Get("/runtimeConfig", a.handleRuntimeConfig)
+		// 媒体地址签名（MEDIA_URL_SIGNING 开启时为前端自行构造的媒体地址逐个签发）
+		api.Post("/signMediaUrls", a.handleSignMediaURLs)
 
 		// Identity
 		api.Get("/getIdentityList", a.handleGetIdentityList)
@@ -58,7 +104,7 @@ func (a *App) buildRouter() http.Handler {
 		api.Post("/reportReferrer", a.handleReportReferrer)
 		api.Post("/getMessageHistory", a.handleGetMessageHistory)
 		api.Get("/getImgServer", a.handleGetImgServer)
-		api.Post("/updateImgServer", a.handleUpdateImgServer)
+		api.With(admin).Post("/updateImgServer", a.handleUpdateImgServer)
 		api.Get("/downloadImgUpload", a.handleDownloadImgUpload)
 		api.Post("/uploadMedia", a.handleUploadMedia)
 		api.Post("/uploadImage", a.handleUploadImage)
@@ -76,10 +122,10 @@ func (a *App) buildRouter() http.Handler {
 		api.Post("/reuploadHistoryImage", a.handleReuploadHistoryImage)
 		api.Get("/getAllUploadImages", a.handleGetAllUploadImages)
 		api.Post("/deleteMedia", a.handleDeleteMedia)
-		api.Post("/batchDeleteMedia", a.handleBatchDeleteMedia)
-		api.Post("/repairMediaHistory", a.handleRepairMediaHistory)
-		api.Post("/repairVideoPosters", a.handleRepairVideoPosters)
-		api.Post("/repairMediaDimensions", a.handleRepairMediaDimensions)
+		api.With(admin).Post("/batchDeleteMedia", a.handleBatchDeleteMedia)
+		api.With(admin).Post("/repairMediaHistory", a.handleRepairMediaHistory)
+		api.With(admin).Post("/repairVideoPosters", a.handleRepairVideoPosters)
+		api.With(admin).Post("/repairMediaDimensions", a.handleRepairMediaDimensions)
 
 		// Video extract（视频抽帧任务）
 		api.Post("/uploadVideoExtractInput", a.handleUploadVideoExtractInput)
@@ -144,16 +190,56 @@ func (a *App) buildRouter() http.Handler {
 
 		// System config（全局配置：所有用户共用）
 		api.Get("/getSystemConfig", a.handleGetSystemConfig)
-		api.Post("/updateSystemConfig", a.handleUpdateSystemConfig)
+		api.With(admin).Post("/updateSystemConfig", a.handleUpdateSystemConfig)
 		api.Post("/resolveImagePort", a.handleResolveImagePort)
 
 		// System（依赖 WebSocket 管理器，在后续阶段补齐实现）
-		api.Post("/deleteUpstreamUser", a.handleDeleteUpstreamUser)
-		api.Post("/batchDeleteUpstreamUsers", a.handleBatchDeleteUpstreamUsers)
+		api.With(admin).Post("/deleteUpstreamUser", a.handleDeleteUpstreamUser)
+		api.With(admin).Post("/batchDeleteUpstreamUsers", a.handleBatchDeleteUpstreamUsers)
 		api.Get("/getConnectionStats", a.handleGetConnectionStats)
-		api.Post("/disconnectAllConnections", a.handleDisconnectAllConnections)
+		api.With(admin).Post("/disconnectAllConnections", a.handleDisconnectAllConnections)
 		api.Get("/getForceoutUserCount", a.handleGetForceoutUserCount)
-		api.Post("/clearForceoutUsers", a.handleClearForceoutUsers)
+		api.With(admin).Post("/clearForceoutUsers", a.handleClearForceoutUsers)
+		api.Route("/forceout", func(fr chi.Router) {
+			fr.Get("/list", a.handleListForceoutUsers)
+			fr.Get("/detail", a.handleGetForceoutDetail)
+		})
+
+		// 上游发送队列（未送达消息查询/重试）
+		api.Route("/outbox", func(or chi.Router) {
+			or.Get("/list", a.handleListUpstreamOutbox)
+			or.Post("/retry", a.handleRetryUpstreamOutbox)
+		})
+
+		// 自动回复规则（收到私聊消息时按规则回复）
+		api.Route("/autoReply", func(ar chi.Router) {
+			ar.Get("/list", a.handleListAutoReplyRules)
+			ar.Post("/create", a.handleCreateAutoReplyRule)
+			ar.Post("/update", a.handleUpdateAutoReplyRule)
+			ar.Post("/delete", a.handleDeleteAutoReplyRule)
+			ar.Post("/dryRun", a.handleDryRunAutoReply)
+		})
+
+		// 定时私聊消息（到期时由服务端建立/复用上游连接发送）
+		api.Route("/scheduledMessage", func(sr chi.Router) {
+			sr.Get("/list", a.handleListScheduledMessages)
+			sr.Post("/create", a.handleCreateScheduledMessage)
+			sr.Post("/update", a.handleUpdateScheduledMessage)
+			sr.Post("/cancel", a.handleCancelScheduledMessage)
+		})
+
+		// WebSocket 会话录制（按身份开关）
+		api.Route("/wsRecord", func(rr chi.Router) {
+			rr.Get("/list", a.handleListWSRecordings)
+			rr.With(admin).Post("/start", a.handleStartWSRecording)
+			rr.With(admin).Post("/stop", a.handleStopWSRecording)
+		})
+
+		// 上游连接生命周期事件（connect/disconnect/evict/forceout/reconnect）
+		api.Route("/wsConnectionEvent", func(er chi.Router) {
+			er.Get("/list", a.handleListWSConnectionEvents)
+			er.Get("/stats", a.handleGetWSConnectionEventStats)
+		})
 	})
 
 	// 前端静态资源 + SPA 回退
@@ -0,0 +1,485 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"liao/internal/database"
+	"liao/internal/protocol"
+)
+
+const (
+	ScheduledMessageStatusPending  = "pending"
+	ScheduledMessageStatusSending  = "sending"
+	ScheduledMessageStatusSent     = "sent"
+	ScheduledMessageStatusFailed   = "failed"
+	ScheduledMessageStatusCanceled = "canceled"
+
+	scheduledMessageMaxContentRunes  = 2000
+	scheduledMessageMaxErrorRunes    = 500
+	scheduledMessageDefaultListLimit = 100
+	scheduledMessageMaxListLimit     = 500
+	scheduledMessageDispatchBatch    = 50
+)
+
+var (
+	// 到期扫描周期、单条发送（含建立上游连接）超时，以及失败后的重试间隔与最大尝试次数。
+	scheduledMessagePollInterval = 5 * time.Second
+	scheduledMessageSendTimeout  = 20 * time.Second
+	scheduledMessageRetryDelay   = time.Minute
+	scheduledMessageMaxAttempts  = 3
+	// scheduledMessageStaleSending 为 sending 状态视为发送方已退出的时长：超过单条发送超时（留出回写结果的余量）
+	// 仍未回写结果的消息恢复为 pending，正在其他副本上发送的消息不受影响。
+	scheduledMessageStaleSending = 2 * scheduledMessageSendTimeout
+
+	ErrScheduledMessageNotFound   = errors.New("定时消息不存在")
+	ErrScheduledMessageNotPending = errors.New("定时消息已发送或已取消，无法修改")
+)
+
+// ScheduledMessage 为一条定时发送的私聊消息。
+type ScheduledMessage struct {
+	ID             int64  `json:"id"`
+	UserID         string `json:"userId"`
+	TargetUserID   string `json:"targetUserId"`
+	TargetNickname string `json:"targetNickname"`
+	Content        string `json:"content"`
+	SendAt         string `json:"sendAt"`
+	Status         string `json:"status"`
+	Attempts       int    `json:"attempts"`
+	LastError      string `json:"lastError,omitempty"`
+	SentTime       string `json:"sentTime,omitempty"`
+	CreateTime     string `json:"createTime"`
+	UpdateTime     string `json:"updateTime"`
+
+	signMessage string
+}
+
+// ScheduledMessageInput 为创建/编辑定时消息的参数；编辑时 SignMessage 为空表示沿用原值。
+type ScheduledMessageInput struct {
+	UserID         string
+	TargetUserID   string
+	TargetNickname string
+	Content        string
+	SendAt         time.Time
+	SignMessage    string
+}
+
+// ScheduledMessageQuery 为定时消息列表查询条件；Status 为空时返回全部状态。
+type ScheduledMessageQuery struct {
+	UserID string
+	Status string
+	Limit  int
+}
+
+// ScheduledMessageSender 在没有下游会话时发送一条上游消息（通常为 UpstreamWebSocketManager.SendScheduled）。
+type ScheduledMessageSender func(ctx context.Context, userID string, signMessage string, message string) error
+
+// ScheduledMessageService 定义定时消息的持久化与管理能力。
+type ScheduledMessageService interface {
+	Create(ctx context.Context, in ScheduledMessageInput) (*ScheduledMessage, error)
+	Update(ctx context.Context, id int64, in ScheduledMessageInput) (*ScheduledMessage, error)
+	Cancel(ctx context.Context, userID string, id int64) error
+	List(ctx context.Context, query ScheduledMessageQuery) ([]ScheduledMessage, error)
+}
+
+// DBScheduledMessageService 基于数据库实现 ScheduledMessageService，并在 Start 后周期性发送到期消息。
+type DBScheduledMessageService struct {
+	db   *database.DB
+	send ScheduledMessageSender
+
+	stopCh    chan struct{}
+	wg        sync.WaitGroup
+	startOnce sync.Once
+	closeOnce sync.Once
+}
+
+// NewDBScheduledMessageService 创建数据库定时消息服务。
+func NewDBScheduledMessageService(db *database.DB) *DBScheduledMessageService {
+	if db == nil {
+		return nil
+	}
+	return &DBScheduledMessageService{db: db, stopCh: make(chan struct{})}
+}
+
+// validateScheduledSignMessage 校验 sign 消息属于该身份。
+func validateScheduledSignMessage(userID string, signMessage string) error {
+	msg, err := protocol.DecodeLenient([]byte(signMessage))
+	if err != nil {
+		return errBadRequest("sign 消息格式非法")
+	}
+	sign, ok := msg.(*protocol.Sign)
+	if !ok || strings.TrimSpace(sign.ID) != userID {
+		return errBadRequest("sign 消息与 userId 不一致")
+	}
+	return nil
+}
+
+func normalizeScheduledMessageInput(in *ScheduledMessageInput, requireSign bool) error {
+	in.UserID = strings.TrimSpace(in.UserID)
+	in.TargetUserID = strings.TrimSpace(in.TargetUserID)
+	in.TargetNickname = strings.TrimSpace(in.TargetNickname)
+	in.Content = strings.TrimSpace(in.Content)
+	in.SignMessage = strings.TrimSpace(in.SignMessage)
+
+	if in.UserID == "" || in.TargetUserID == "" {
+		return errBadRequest("userId和targetUserId不能为空")
+	}
+	if in.Content == "" {
+		return errBadRequest("消息内容不能为空")
+	}
+	if utf8.RuneCountInString(in.Content) > scheduledMessageMaxContentRunes {
+		return errBadRequest(fmt.Sprintf("消息内容不能超过%d个字符", scheduledMessageMaxContentRunes))
+	}
+	if utf8.RuneCountInString(in.TargetNickname) > 128 {
+		return errBadRequest("targetNickname不能超过128个字符")
+	}
+	if in.SendAt.IsZero() {
+		return errBadRequest("sendAt不能为空")
+	}
+	if !in.SendAt.After(time.Now()) {
+		return errBadRequest("sendAt必须晚于当前时间")
+	}
+	if in.SignMessage == "" {
+		if requireSign {
+			return errBadRequest("缺少 sign 消息")
+		}
+		return nil
+	}
+	return validateScheduledSignMessage(in.UserID, in.SignMessage)
+}
+
+// buildTouserPayload 构造与前端一致的私聊发送帧（act=touser_{对方ID}_{对方昵称}）。
+func buildTouserPayload(userID, targetUserID, targetNickname, content string) string {
+	b, _ := json.Marshal(map[string]any{
+		"act": "touser_" + targetUserID + "_" + strings.TrimSpace(targetNickname),
+		"id":  userID,
+		"msg": content,
+	})
+	return string(b)
+}
+
+func (s *DBScheduledMessageService) Create(ctx context.Context, in ScheduledMessageInput) (*ScheduledMessage, error) {
+	if s == nil || s.db == nil {
+		return nil, fmt.Errorf("db not initialized")
+	}
+	if err := normalizeScheduledMessageInput(&in, true); err != nil {
+		return nil, err
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	now := time.Now()
+	id, err := database.InsertReturningID(ctx, s.db, `
+		INSERT INTO scheduled_message (user_id, target_user_id, target_nickname, content, sign_message, send_at, next_attempt_at, status, attempts, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, 0, ?, ?)
+	`, in.UserID, in.TargetUserID, nullIfEmpty(in.TargetNickname), in.Content, in.SignMessage, in.SendAt, in.SendAt, ScheduledMessageStatusPending, now, now)
+	if err != nil {
+		return nil, err
+	}
+	return s.find(ctx, in.UserID, id)
+}
+
+// Update 编辑仍处于 pending 状态的定时消息。
+func (s *DBScheduledMessageService) Update(ctx context.Context, id int64, in ScheduledMessageInput) (*ScheduledMessage, error) {
+	if s == nil || s.db == nil {
+		return nil, fmt.Errorf("db not initialized")
+	}
+	if id <= 0 {
+		return nil, errBadRequest("id 参数非法")
+	}
+	if err := normalizeScheduledMessageInput(&in, false); err != nil {
+		return nil, err
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	set := "target_user_id = ?, target_nickname = ?, content = ?, send_at = ?, next_attempt_at = ?, attempts = 0, last_error = NULL, updated_at = ?"
+	args := []any{in.TargetUserID, nullIfEmpty(in.TargetNickname), in.Content, in.SendAt, in.SendAt, time.Now()}
+	if in.SignMessage != "" {
+		set += ", sign_message = ?"
+		args = append(args, in.SignMessage)
+	}
+	args = append(args, id, in.UserID, ScheduledMessageStatusPending)
+	res, err := s.db.ExecContext(ctx, `
+		UPDATE scheduled_message SET `+set+`
+		WHERE id = ? AND user_id = ? AND status = ?
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.checkPendingAffected(ctx, res, in.UserID, id); err != nil {
+		return nil, err
+	}
+	return s.find(ctx, in.UserID, id)
+}
+
+// Cancel 取消仍处于 pending 状态的定时消息。
+func (s *DBScheduledMessageService) Cancel(ctx context.Context, userID string, id int64) error {
+	if s == nil || s.db == nil {
+		return fmt.Errorf("db not initialized")
+	}
+	userID = strings.TrimSpace(userID)
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	res, err := s.db.ExecContext(ctx, `
+		UPDATE scheduled_message SET status = ?, updated_at = ?
+		WHERE id = ? AND user_id = ? AND status = ?
+	`, ScheduledMessageStatusCanceled, time.Now(), id, userID, ScheduledMessageStatusPending)
+	if err != nil {
+		return err
+	}
+	return s.checkPendingAffected(ctx, res, userID, id)
+}
+
+// checkPendingAffected 在条件更新未命中时区分消息不存在与状态不允许修改。
+func (s *DBScheduledMessageService) checkPendingAffected(ctx context.Context, res sql.Result, userID string, id int64) error {
+	affected, err := res.RowsAffected()
+	if err != nil || affected > 0 {
+		return nil
+	}
+	if _, err := s.find(ctx, userID, id); err != nil {
+		return err
+	}
+	return ErrScheduledMessageNotPending
+}
+
+// List 按计划发送时间倒序返回身份的定时消息。
+func (s *DBScheduledMessageService) List(ctx context.Context, query ScheduledMessageQuery) ([]ScheduledMessage, error) {
+	if s == nil || s.db == nil {
+		return nil, fmt.Errorf("db not initialized")
+	}
+	userID := strings.TrimSpace(query.UserID)
+	if userID == "" {
+		return nil, fmt.Errorf("userId 不能为空")
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	where := "user_id = ?"
+	args := []any{userID}
+	if status := strings.TrimSpace(query.Status); status != "" {
+		where += " AND status = ?"
+		args = append(args, status)
+	}
+	args = append(args, normalizeScheduledMessageLimit(query.Limit))
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT `+scheduledMessageColumns+`
+		FROM scheduled_message
+		WHERE `+where+`
+		ORDER BY send_at DESC, id DESC
+		LIMIT ?
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]ScheduledMessage, 0)
+	for rows.Next() {
+		item, err := scanScheduledMessage(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, *item)
+	}
+	return out, rows.Err()
+}
+
+const scheduledMessageColumns = `id, user_id, target_user_id, target_nickname, content, sign_message, send_at, status, attempts, last_error, sent_at, created_at, updated_at`
+
+func (s *DBScheduledMessageService) find(ctx context.Context, userID string, id int64) (*ScheduledMessage, error) {
+	item, err := scanScheduledMessage(s.db.QueryRowContext(ctx, `
+		SELECT `+scheduledMessageColumns+`
+		FROM scheduled_message
+		WHERE id = ? AND user_id = ?
+	`, id, userID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrScheduledMessageNotFound
+	}
+	return item, err
+}
+
+func scanScheduledMessage(row interface{ Scan(dest ...any) error }) (*ScheduledMessage, error) {
+	var item ScheduledMessage
+	var nickname, lastError sql.NullString
+	var sendAt, sentAt, created, updated sql.NullTime
+	if err := row.Scan(&item.ID, &item.UserID, &item.TargetUserID, &nickname, &item.Content, &item.signMessage, &sendAt,
+		&item.Status, &item.Attempts, &lastError, &sentAt, &created, &updated); err != nil {
+		return nil, err
+	}
+	item.TargetNickname = nickname.String
+	item.LastError = lastError.String
+	item.SendAt = formatNullLocalDateTimeISO(sendAt)
+	item.SentTime = formatNullLocalDateTimeISO(sentAt)
+	item.CreateTime = formatNullLocalDateTimeISO(created)
+	item.UpdateTime = formatNullLocalDateTimeISO(updated)
+	return &item, nil
+}
+
+func normalizeScheduledMessageLimit(limit int) int {
+	if limit <= 0 {
+		return scheduledMessageDefaultListLimit
+	}
+	if limit > scheduledMessageMaxListLimit {
+		return scheduledMessageMaxListLimit
+	}
+	return limit
+}
+
+// Start 设置发送函数并启动到期扫描；发送方在发送中退出的消息由每次扫描按 scheduledMessageStaleSending 恢复。
+func (s *DBScheduledMessageService) Start(send ScheduledMessageSender) {
+	if s == nil || send == nil {
+		return
+	}
+	s.startOnce.Do(func() {
+		s.send = send
+		if scheduledMessagePollInterval > 0 {
+			s.wg.Add(1)
+			go s.dispatchLoop(scheduledMessagePollInterval)
+		}
+	})
+}
+
+func (s *DBScheduledMessageService) Close() error {
+	if s == nil {
+		return nil
+	}
+	s.closeOnce.Do(func() {
+		close(s.stopCh)
+		s.wg.Wait()
+	})
+	return nil
+}
+
+func (s *DBScheduledMessageService) dispatchLoop(interval time.Duration) {
+	defer s.wg.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stopCh:
+			return
+		case <-ticker.C:
+			if n, err := s.DispatchDue(context.Background(), time.Now()); err != nil {
+				slog.Warn("扫描到期定时消息失败", "error", err)
+			} else if n > 0 {
+				slog.Info("定时消息已处理", "count", n)
+			}
+		}
+	}
+}
+
+// DispatchDue 发送 now 之前到期（按 next_attempt_at）的 pending 消息，返回本次处理（成功或失败）的条数。
+// 每条消息先以条件更新抢占为 sending，避免多副本或并发扫描重复发送。
+func (s *DBScheduledMessageService) DispatchDue(ctx context.Context, now time.Time) (int, error) {
+	if s == nil || s.db == nil || s.send == nil {
+		return 0, fmt.Errorf("scheduled message dispatcher not started")
+	}
+
+	// 发送方已退出（超过 scheduledMessageStaleSending 未回写结果）的消息恢复为 pending（至少发送一次）。
+	res, err := s.db.ExecContext(ctx, `
+		UPDATE scheduled_message SET status = ?, updated_at = ? WHERE status = ? AND updated_at < ?
+	`, ScheduledMessageStatusPending, now, ScheduledMessageStatusSending, now.Add(-scheduledMessageStaleSending))
+	if err != nil {
+		return 0, err
+	}
+	if n, err := res.RowsAffected(); err == nil && n > 0 {
+		slog.Warn("恢复发送中断的定时消息", "count", n)
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT `+scheduledMessageColumns+`
+		FROM scheduled_message
+		WHERE status = ? AND next_attempt_at <= ?
+		ORDER BY next_attempt_at ASC, id ASC
+		LIMIT ?
+	`, ScheduledMessageStatusPending, now, scheduledMessageDispatchBatch)
+	if err != nil {
+		return 0, err
+	}
+	due := make([]ScheduledMessage, 0)
+	for rows.Next() {
+		item, err := scanScheduledMessage(rows)
+		if err != nil {
+			_ = rows.Close()
+			return 0, err
+		}
+		due = append(due, *item)
+	}
+	_ = rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	processed := 0
+	for _, item := range due {
+		select {
+		case <-s.stopCh:
+			return processed, nil
+		default:
+		}
+		res, err := s.db.ExecContext(ctx, `
+			UPDATE scheduled_message SET status = ?, attempts = attempts + 1, updated_at = ?
+			WHERE id = ? AND status = ?
+		`, ScheduledMessageStatusSending, time.Now(), item.ID, ScheduledMessageStatusPending)
+		if err != nil {
+			return processed, err
+		}
+		if affected, err := res.RowsAffected(); err == nil && affected == 0 {
+			continue
+		}
+
+		sendCtx, cancel := context.WithTimeout(ctx, scheduledMessageSendTimeout)
+		sendErr := s.send(sendCtx, item.UserID, item.signMessage, buildTouserPayload(item.UserID, item.TargetUserID, item.TargetNickname, item.Content))
+		cancel()
+		s.recordOutcome(ctx, item, sendErr)
+		processed++
+	}
+	return processed, nil
+}
+
+// recordOutcome 回写发送结果：成功标记 sent；失败未达重试上限时延后 next_attempt_at 重新排队（保留用户设定的 send_at），否则标记 failed。
+func (s *DBScheduledMessageService) recordOutcome(ctx context.Context, item ScheduledMessage, sendErr error) {
+	now := time.Now()
+	var err error
+	if sendErr == nil {
+		slog.Info("定时消息发送成功", "id", item.ID, "userID", item.UserID, "targetUserID", item.TargetUserID)
+		_, err = s.db.ExecContext(ctx, `
+			UPDATE scheduled_message SET status = ?, last_error = NULL, sent_at = ?, updated_at = ? WHERE id = ?
+		`, ScheduledMessageStatusSent, now, now, item.ID)
+	} else {
+		reason := strings.TrimSpace(sendErr.Error())
+		if utf8.RuneCountInString(reason) > scheduledMessageMaxErrorRunes {
+			reason = string([]rune(reason)[:scheduledMessageMaxErrorRunes])
+		}
+		attempts := item.Attempts + 1
+		if attempts < scheduledMessageMaxAttempts {
+			slog.Warn("定时消息发送失败，稍后重试", "id", item.ID, "userID", item.UserID, "attempts", attempts, "error", sendErr)
+			_, err = s.db.ExecContext(ctx, `
+				UPDATE scheduled_message SET status = ?, last_error = ?, next_attempt_at = ?, updated_at = ? WHERE id = ?
+			`, ScheduledMessageStatusPending, reason, now.Add(scheduledMessageRetryDelay), now, item.ID)
+		} else {
+			slog.Warn("定时消息发送失败", "id", item.ID, "userID", item.UserID, "attempts", attempts, "error", sendErr)
+			_, err = s.db.ExecContext(ctx, `
+				UPDATE scheduled_message SET status = ?, last_error = ?, updated_at = ? WHERE id = ?
+			`, ScheduledMessageStatusFailed, reason, now, item.ID)
+		}
+	}
+	if err != nil {
+		slog.Warn("回写定时消息发送结果失败", "id", item.ID, "error", err)
+	}
+}
@@ -0,0 +1,166 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"liao/internal/protocol"
+)
+
+type scheduledMessageRequest struct {
+	ID             int64  `json:"id"`
+	UserID         string `json:"userId"`
+	TargetUserID   string `json:"targetUserId"`
+	TargetNickname string `json:"targetNickname"`
+	Content        string `json:"content"`
+	SendAt         string `json:"sendAt"`
+	Sign           string `json:"sign"`
+}
+
+func (a *App) handleListScheduledMessages(w http.ResponseWriter, r *http.Request) {
+	if a.scheduledMessages == nil {
+		writeJSON(w, http.StatusOK, map[string]any{"code": -1, "msg": "定时消息服务未初始化"})
+		return
+	}
+
+	q := r.URL.Query()
+	userID := strings.TrimSpace(q.Get("userId"))
+	if userID == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"code": 400, "msg": "userId不能为空"})
+		return
+	}
+	items, err := a.scheduledMessages.List(r.Context(), ScheduledMessageQuery{
+		UserID: userID,
+		Status: strings.TrimSpace(q.Get("status")),
+		Limit:  parseIntDefault(q.Get("limit"), scheduledMessageDefaultListLimit),
+	})
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]any{"code": -1, "msg": "查询定时消息失败: " + err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"code": 0,
+		"msg":  "success",
+		"data": items,
+	})
+}
+
+func (a *App) handleCreateScheduledMessage(w http.ResponseWriter, r *http.Request) {
+	a.saveScheduledMessage(w, r, false)
+}
+
+func (a *App) handleUpdateScheduledMessage(w http.ResponseWriter, r *http.Request) {
+	a.saveScheduledMessage(w, r, true)
+}
+
+func (a *App) saveScheduledMessage(w http.ResponseWriter, r *http.Request, update bool) {
+	if a.scheduledMessages == nil {
+		writeJSON(w, http.StatusOK, map[string]any{"code": -1, "msg": "定时消息服务未初始化"})
+		return
+	}
+
+	var req scheduledMessageRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"code": 400, "msg": "参数解析失败"})
+		return
+	}
+	in := ScheduledMessageInput{
+		UserID:         req.UserID,
+		TargetUserID:   req.TargetUserID,
+		TargetNickname: req.TargetNickname,
+		Content:        req.Content,
+		SignMessage:    req.Sign,
+	}
+	if sendAt := parseOptionalLocalDateTimeISO(req.SendAt); sendAt != nil {
+		in.SendAt = *sendAt
+	} else if strings.TrimSpace(req.SendAt) != "" {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"code": 400, "msg": "sendAt 格式非法"})
+		return
+	}
+
+	var (
+		item *ScheduledMessage
+		err  error
+	)
+	if update {
+		item, err = a.scheduledMessages.Update(r.Context(), req.ID, in)
+	} else {
+		if strings.TrimSpace(in.SignMessage) == "" {
+			in.SignMessage = a.resolveScheduledSignMessage(r.Context(), in.UserID)
+		}
+		item, err = a.scheduledMessages.Create(r.Context(), in)
+	}
+	if err != nil {
+		writeScheduledMessageError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"code": 0,
+		"msg":  "success",
+		"data": item,
+	})
+}
+
+func (a *App) handleCancelScheduledMessage(w http.ResponseWriter, r *http.Request) {
+	if a.scheduledMessages == nil {
+		writeJSON(w, http.StatusOK, map[string]any{"code": -1, "msg": "定时消息服务未初始化"})
+		return
+	}
+
+	var in struct {
+		UserID string `json:"userId"`
+		ID     int64  `json:"id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"code": 400, "msg": "参数解析失败"})
+		return
+	}
+	if strings.TrimSpace(in.UserID) == "" || in.ID <= 0 {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"code": 400, "msg": "userId和id不能为空"})
+		return
+	}
+	if err := a.scheduledMessages.Cancel(r.Context(), in.UserID, in.ID); err != nil {
+		writeScheduledMessageError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"code": 0, "msg": "success"})
+}
+
+// resolveScheduledSignMessage 为未携带 sign 的定时消息补全登录消息：优先沿用该身份最近一次的 sign，否则按身份表构造。
+func (a *App) resolveScheduledSignMessage(ctx context.Context, userID string) string {
+	userID = strings.TrimSpace(userID)
+	if userID == "" {
+		return ""
+	}
+	if a.wsManager != nil {
+		if sign := a.wsManager.SignMessage(userID); sign != "" {
+			return sign
+		}
+	}
+	if a.identityService == nil {
+		return ""
+	}
+	identity, err := a.identityService.GetByID(ctx, userID)
+	if err != nil || identity == nil {
+		return ""
+	}
+	raw, err := protocol.Encode(&protocol.Sign{ID: identity.ID, Name: identity.Name, UserSex: identity.Sex})
+	if err != nil {
+		return ""
+	}
+	return string(raw)
+}
+
+func writeScheduledMessageError(w http.ResponseWriter, err error) {
+	switch {
+	case isBadRequestError(err):
+		writeJSON(w, http.StatusBadRequest, map[string]any{"code": 400, "msg": err.Error()})
+	case errors.Is(err, ErrScheduledMessageNotFound), errors.Is(err, ErrScheduledMessageNotPending):
+		writeJSON(w, http.StatusOK, map[string]any{"code": -1, "msg": err.Error()})
+	default:
+		writeJSON(w, http.StatusInternalServerError, map[string]any{"code": -1, "msg": "保存定时消息失败: " + err.Error()})
+	}
+}
@@ -0,0 +1,276 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+
+	"liao/internal/fakeupstream"
+)
+
+var scheduledMessageTestColumns = []string{"id", "user_id", "target_user_id", "target_nickname", "content", "sign_message", "send_at", "status",
+	"attempts", "last_error", "sent_at", "created_at", "updated_at"}
+
+const scheduledTestSign = `{"act":"sign","id":"u1","name":"Me"}`
+
+func TestNormalizeScheduledMessageInput(t *testing.T) {
+	future := time.Now().Add(time.Hour)
+	in := ScheduledMessageInput{UserID: " u1 ", TargetUserID: " t1 ", Content: " hi ", SendAt: future, SignMessage: scheduledTestSign}
+	if err := normalizeScheduledMessageInput(&in, true); err != nil || in.UserID != "u1" || in.Content != "hi" {
+		t.Fatalf("in=%+v err=%v", in, err)
+	}
+
+	for name, bad := range map[string]ScheduledMessageInput{
+		"target":   {UserID: "u1", Content: "x", SendAt: future, SignMessage: scheduledTestSign},
+		"content":  {UserID: "u1", TargetUserID: "t1", SendAt: future, SignMessage: scheduledTestSign},
+		"long":     {UserID: "u1", TargetUserID: "t1", Content: strings.Repeat("字", scheduledMessageMaxContentRunes+1), SendAt: future, SignMessage: scheduledTestSign},
+		"past":     {UserID: "u1", TargetUserID: "t1", Content: "x", SendAt: time.Now().Add(-time.Minute), SignMessage: scheduledTestSign},
+		"noSign":   {UserID: "u1", TargetUserID: "t1", Content: "x", SendAt: future},
+		"badSign":  {UserID: "u1", TargetUserID: "t1", Content: "x", SendAt: future, SignMessage: "not json"},
+		"otherId":  {UserID: "u1", TargetUserID: "t1", Content: "x", SendAt: future, SignMessage: `{"act":"sign","id":"u2"}`},
+		"notASign": {UserID: "u1", TargetUserID: "t1", Content: "x", SendAt: future, SignMessage: `{"act":"chat","id":"u1"}`},
+	} {
+		if err := normalizeScheduledMessageInput(&bad, true); err == nil || !isBadRequestError(err) {
+			t.Fatalf("%s: err=%v, want bad request", name, err)
+		}
+	}
+
+	edit := ScheduledMessageInput{UserID: "u1", TargetUserID: "t1", Content: "x", SendAt: future}
+	if err := normalizeScheduledMessageInput(&edit, false); err != nil {
+		t.Fatalf("edit without sign: %v", err)
+	}
+}
+
+func TestBuildTouserPayload(t *testing.T) {
+	got := buildTouserPayload("u1", "t1", " 小明 ", "你好")
+	for _, want := range []string{`"act":"touser_t1_小明"`, `"id":"u1"`, `"msg":"你好"`} {
+		if !strings.Contains(got, want) {
+			t.Fatalf("payload=%s, missing %s", got, want)
+		}
+	}
+}
+
+func TestDBScheduledMessageService_CRUD(t *testing.T) {
+	if NewDBScheduledMessageService(nil) != nil {
+		t.Fatalf("expected nil service for nil db")
+	}
+	rawDB, mock, cleanup := newSQLMock(t)
+	t.Cleanup(cleanup)
+	svc := NewDBScheduledMessageService(wrapMySQLDB(rawDB))
+	ctx := context.Background()
+	now := time.Now()
+	sendAt := now.Add(time.Hour)
+
+	expectInsertReturningID(mock, `INSERT INTO scheduled_message`, 3,
+		"u1", "t1", "小明", "hi", scheduledTestSign, sendAt, sendAt, ScheduledMessageStatusPending, sqlmock.AnyArg(), sqlmock.AnyArg())
+	mock.ExpectQuery(`FROM scheduled_message\s+WHERE id = \? AND user_id = \?`).WithArgs(int64(3), "u1").
+		WillReturnRows(sqlmock.NewRows(scheduledMessageTestColumns).AddRow(3, "u1", "t1", "小明", "hi", scheduledTestSign, sendAt, "pending", 0, nil, nil, now, now))
+	created, err := svc.Create(ctx, ScheduledMessageInput{UserID: "u1", TargetUserID: "t1", TargetNickname: "小明", Content: "hi", SendAt: sendAt, SignMessage: scheduledTestSign})
+	if err != nil || created.ID != 3 || created.Status != ScheduledMessageStatusPending || created.signMessage != scheduledTestSign {
+		t.Fatalf("created=%+v err=%v", created, err)
+	}
+
+	// 编辑未携带 sign 时不覆盖原值。
+	mock.ExpectExec(`UPDATE scheduled_message SET target_user_id = \?.*updated_at = \?\s+WHERE id = \? AND user_id = \? AND status = \?`).
+		WithArgs("t2", nil, "later", sendAt, sendAt, sqlmock.AnyArg(), int64(3), "u1", ScheduledMessageStatusPending).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`WHERE id = \? AND user_id = \?`).WithArgs(int64(3), "u1").
+		WillReturnRows(sqlmock.NewRows(scheduledMessageTestColumns).AddRow(3, "u1", "t2", nil, "later", scheduledTestSign, sendAt, "pending", 0, nil, nil, now, now))
+	updated, err := svc.Update(ctx, 3, ScheduledMessageInput{UserID: "u1", TargetUserID: "t2", Content: "later", SendAt: sendAt})
+	if err != nil || updated.TargetUserID != "t2" || updated.TargetNickname != "" {
+		t.Fatalf("updated=%+v err=%v", updated, err)
+	}
+
+	// 已发送的消息不可编辑；不存在的消息返回 not found。
+	mock.ExpectExec(`UPDATE scheduled_message`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(`WHERE id = \? AND user_id = \?`).WithArgs(int64(3), "u1").
+		WillReturnRows(sqlmock.NewRows(scheduledMessageTestColumns).AddRow(3, "u1", "t2", nil, "later", scheduledTestSign, sendAt, "sent", 1, nil, now, now, now))
+	if _, err := svc.Update(ctx, 3, ScheduledMessageInput{UserID: "u1", TargetUserID: "t2", Content: "later", SendAt: sendAt}); !errors.Is(err, ErrScheduledMessageNotPending) {
+		t.Fatalf("err=%v, want not pending", err)
+	}
+	mock.ExpectExec(`UPDATE scheduled_message SET status = \?`).WithArgs(ScheduledMessageStatusCanceled, sqlmock.AnyArg(), int64(9), "u1", ScheduledMessageStatusPending).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(`WHERE id = \? AND user_id = \?`).WithArgs(int64(9), "u1").WillReturnRows(sqlmock.NewRows(scheduledMessageTestColumns))
+	if err := svc.Cancel(ctx, "u1", 9); !errors.Is(err, ErrScheduledMessageNotFound) {
+		t.Fatalf("err=%v, want not found", err)
+	}
+	mock.ExpectExec(`UPDATE scheduled_message SET status = \?`).WillReturnResult(sqlmock.NewResult(0, 1))
+	if err := svc.Cancel(ctx, "u1", 3); err != nil {
+		t.Fatalf("Cancel: %v", err)
+	}
+
+	mock.ExpectQuery(`FROM scheduled_message\s+WHERE user_id = \? AND status = \?\s+ORDER BY send_at DESC, id DESC\s+LIMIT \?`).
+		WithArgs("u1", "canceled", scheduledMessageDefaultListLimit).
+		WillReturnRows(sqlmock.NewRows(scheduledMessageTestColumns).AddRow(3, "u1", "t2", nil, "later", scheduledTestSign, sendAt, "canceled", 0, nil, nil, now, now))
+	items, err := svc.List(ctx, ScheduledMessageQuery{UserID: "u1", Status: "canceled"})
+	if err != nil || len(items) != 1 || items[0].Status != ScheduledMessageStatusCanceled {
+		t.Fatalf("items=%+v err=%v", items, err)
+	}
+}
+
+func TestDBScheduledMessageService_DispatchDue(t *testing.T) {
+	oldMax := scheduledMessageMaxAttempts
+	scheduledMessageMaxAttempts = 2
+	t.Cleanup(func() { scheduledMessageMaxAttempts = oldMax })
+
+	rawDB, mock, cleanup := newSQLMock(t)
+	t.Cleanup(cleanup)
+	svc := NewDBScheduledMessageService(wrapMySQLDB(rawDB))
+	if _, err := svc.DispatchDue(context.Background(), time.Now()); err == nil {
+		t.Fatalf("expected error before Start")
+	}
+
+	var sent []string
+	svc.send = func(ctx context.Context, userID, sign, message string) error {
+		sent = append(sent, userID+"|"+message)
+		if strings.Contains(message, "boom") {
+			return errors.New("upstream down")
+		}
+		return nil
+	}
+
+	now := time.Now()
+	// 仅恢复超过 scheduledMessageStaleSending 仍处于 sending 的消息，其他副本正在发送的不受影响。
+	mock.ExpectExec(`UPDATE scheduled_message SET status = \?, updated_at = \? WHERE status = \? AND updated_at < \?`).
+		WithArgs(ScheduledMessageStatusPending, now, ScheduledMessageStatusSending, now.Add(-scheduledMessageStaleSending)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`FROM scheduled_message\s+WHERE status = \? AND next_attempt_at <= \?\s+ORDER BY next_attempt_at ASC`).WithArgs(ScheduledMessageStatusPending, now, scheduledMessageDispatchBatch).
+		WillReturnRows(sqlmock.NewRows(scheduledMessageTestColumns).
+			AddRow(1, "u1", "t1", "A", "hi", scheduledTestSign, now, "pending", 0, nil, nil, now, now).
+			AddRow(2, "u1", "t1", "A", "boom", scheduledTestSign, now, "pending", 0, nil, nil, now, now).
+			AddRow(3, "u1", "t1", "A", "boom", scheduledTestSign, now, "pending", 1, nil, nil, now, now).
+			AddRow(4, "u1", "t1", "A", "taken", scheduledTestSign, now, "pending", 0, nil, nil, now, now))
+
+	claim := `UPDATE scheduled_message SET status = \?, attempts = attempts \+ 1`
+	// 1: 发送成功。
+	mock.ExpectExec(claim).WithArgs(ScheduledMessageStatusSending, sqlmock.AnyArg(), int64(1), ScheduledMessageStatusPending).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`UPDATE scheduled_message SET status = \?, last_error = NULL, sent_at = \?`).
+		WithArgs(ScheduledMessageStatusSent, sqlmock.AnyArg(), sqlmock.AnyArg(), int64(1)).WillReturnResult(sqlmock.NewResult(0, 1))
+	// 2: 首次失败，延后 next_attempt_at 重试，send_at 保持用户设定值。
+	mock.ExpectExec(claim).WithArgs(ScheduledMessageStatusSending, sqlmock.AnyArg(), int64(2), ScheduledMessageStatusPending).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`UPDATE scheduled_message SET status = \?, last_error = \?, next_attempt_at = \?, updated_at = \? WHERE id = \?`).
+		WithArgs(ScheduledMessageStatusPending, "upstream down", sqlmock.AnyArg(), sqlmock.AnyArg(), int64(2)).WillReturnResult(sqlmock.NewResult(0, 1))
+	// 3: 达到最大尝试次数，标记失败。
+	mock.ExpectExec(claim).WithArgs(ScheduledMessageStatusSending, sqlmock.AnyArg(), int64(3), ScheduledMessageStatusPending).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`UPDATE scheduled_message SET status = \?, last_error = \?, updated_at = \?`).
+		WithArgs(ScheduledMessageStatusFailed, "upstream down", sqlmock.AnyArg(), int64(3)).WillReturnResult(sqlmock.NewResult(0, 1))
+	// 4: 已被其他副本抢占，跳过。
+	mock.ExpectExec(claim).WithArgs(ScheduledMessageStatusSending, sqlmock.AnyArg(), int64(4), ScheduledMessageStatusPending).WillReturnResult(sqlmock.NewResult(0, 0))
+
+	n, err := svc.DispatchDue(context.Background(), now)
+	if err != nil || n != 3 {
+		t.Fatalf("n=%d err=%v", n, err)
+	}
+	if len(sent) != 3 || !strings.Contains(sent[0], `"act":"touser_t1_A"`) {
+		t.Fatalf("sent=%v", sent)
+	}
+}
+
+func TestUpstreamWebSocketManager_SendScheduled_WithoutDownstream(t *testing.T) {
+	fake := startFakeUpstream(t)
+	bot := fakeupstream.DefaultBots()[0]
+
+	m := NewUpstreamWebSocketManager(&http.Client{Timeout: 2 * time.Second}, "ws://unused", nil, nil, nil)
+	t.Cleanup(m.CloseAllConnections)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	if err := m.SendScheduled(ctx, "u1", "", "x"); err == nil {
+		t.Fatalf("expected error without sign")
+	}
+	payload := buildTouserPayload("u1", bot.ID, bot.Nickname, "定时问候")
+	if err := m.SendScheduled(ctx, "u1", scheduledTestSign, payload); err != nil {
+		t.Fatalf("SendScheduled: %v", err)
+	}
+
+	// fake 只记录 sign 之后的帧：收到即说明 sign 先于消息写出。
+	waitFor(t, "scheduled message", func() bool {
+		for _, raw := range fake.Received("u1") {
+			if strings.Contains(raw, "touser_"+bot.ID) && strings.Contains(raw, "定时问候") {
+				return true
+			}
+		}
+		return false
+	})
+	if !fake.Connected("u1") {
+		t.Fatalf("upstream not connected")
+	}
+}
+
+func TestIdentityScheduler_HasFreeSlot(t *testing.T) {
+	s := newIdentityScheduler(IdentitySchedulerConfig{Capacity: 1})
+	upstream := map[string]*UpstreamWebSocketClient{}
+	if !s.hasFreeSlot("u1", upstream) {
+		t.Fatalf("empty pool should have a free slot")
+	}
+	upstream["u1"] = &UpstreamWebSocketClient{}
+	if !s.hasFreeSlot("u1", upstream) || s.hasFreeSlot("u2", upstream) {
+		t.Fatalf("full pool should only admit the existing identity")
+	}
+	s.evicting["u1"] = struct{}{}
+	if !s.hasFreeSlot("u2", upstream) {
+		t.Fatalf("identity being evicted should not occupy a slot")
+	}
+}
+
+func TestScheduledMessageHandlers(t *testing.T) {
+	a := &App{}
+	rec := httptest.NewRecorder()
+	a.handleListScheduledMessages(rec, httptest.NewRequest(http.MethodGet, "/api/scheduledMessage/list?userId=u1", nil))
+	if got := decodeJSONBody(t, rec.Body); toInt(got["code"]) != -1 {
+		t.Fatalf("got=%v", got)
+	}
+
+	rawDB, mock, cleanup := newSQLMock(t)
+	t.Cleanup(cleanup)
+	a.scheduledMessages = NewDBScheduledMessageService(wrapMySQLDB(rawDB))
+	a.wsManager = NewUpstreamWebSocketManager(&http.Client{Timeout: time.Second}, "ws://unused", nil, nil, nil)
+	a.identityService = NewIdentityService(wrapMySQLDB(rawDB))
+	now := time.Now()
+
+	rec = httptest.NewRecorder()
+	a.handleListScheduledMessages(rec, httptest.NewRequest(http.MethodGet, "/api/scheduledMessage/list", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("status=%d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	a.handleCreateScheduledMessage(rec, httptest.NewRequest(http.MethodPost, "/api/scheduledMessage/create", strings.NewReader(`{"userId":"u1","targetUserId":"t1","content":"hi","sendAt":"bad"}`)))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("status=%d", rec.Code)
+	}
+
+	// 未携带 sign 时按身份表构造。
+	sendAt := now.Add(time.Hour).Truncate(time.Second)
+	mock.ExpectQuery(`SELECT name, sex, created_at, last_used_at FROM identity WHERE id = \?`).WithArgs("u1").
+		WillReturnRows(sqlmock.NewRows([]string{"name", "sex", "created_at", "last_used_at"}).AddRow("Me", "女", now, now))
+	expectInsertReturningID(mock, `INSERT INTO scheduled_message`, 7,
+		"u1", "t1", nil, "hi", sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), ScheduledMessageStatusPending, sqlmock.AnyArg(), sqlmock.AnyArg())
+	mock.ExpectQuery(`WHERE id = \? AND user_id = \?`).WithArgs(int64(7), "u1").
+		WillReturnRows(sqlmock.NewRows(scheduledMessageTestColumns).AddRow(7, "u1", "t1", nil, "hi", scheduledTestSign, sendAt, "pending", 0, nil, nil, now, now))
+	rec = httptest.NewRecorder()
+	body := `{"userId":"u1","targetUserId":"t1","content":"hi","sendAt":"` + sendAt.Format("2006-01-02 15:04:05") + `"}`
+	a.handleCreateScheduledMessage(rec, httptest.NewRequest(http.MethodPost, "/api/scheduledMessage/create", strings.NewReader(body)))
+	got := decodeJSONBody(t, rec.Body)
+	if toInt(got["code"]) != 0 {
+		t.Fatalf("got=%v", got)
+	}
+	if data, _ := got["data"].(map[string]any); data["status"] != ScheduledMessageStatusPending || data["signMessage"] != nil {
+		t.Fatalf("data=%v", got["data"])
+	}
+
+	mock.ExpectExec(`UPDATE scheduled_message SET status = \?`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(`WHERE id = \? AND user_id = \?`).WithArgs(int64(7), "u1").
+		WillReturnRows(sqlmock.NewRows(scheduledMessageTestColumns).AddRow(7, "u1", "t1", nil, "hi", scheduledTestSign, sendAt, "sent", 1, nil, now, now, now))
+	rec = httptest.NewRecorder()
+	a.handleCancelScheduledMessage(rec, httptest.NewRequest(http.MethodPost, "/api/scheduledMessage/cancel", strings.NewReader(`{"userId":"u1","id":7}`)))
+	if got := decodeJSONBody(t, rec.Body); toInt(got["code"]) != -1 || got["msg"] != ErrScheduledMessageNotPending.Error() {
+		t.Fatalf("got=%v", got)
+	}
+}
@@ -170,6 +170,9 @@ func (a *App) handleGetConnectionStats(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	stats := a.wsManager.GetConnectionStats()
+	if a.wsGuard != nil {
+		stats["guard"] = a.wsGuard.stats()
+	}
 	writeJSON(w, http.StatusOK, map[string]any{
 		"code": 0,
 		"msg":  "success",
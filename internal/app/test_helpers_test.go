@@ -131,6 +131,21 @@ func wrapMySQLDB(db *sql.DB) *database.DB {
 	return database.Wrap(db, testDialect())
 }
 
+// newMockDBService 基于 sqlmock 创建数据库服务；服务实现 Close 时在测试结束时先关闭再校验 sqlmock 期望。
+func newMockDBService[S any](t *testing.T, newService func(*database.DB) S) (S, sqlmock.Sqlmock) {
+	t.Helper()
+
+	rawDB, mock, cleanup := newSQLMock(t)
+	svc := newService(wrapMySQLDB(rawDB))
+	t.Cleanup(func() {
+		if closer, ok := any(svc).(interface{ Close() error }); ok {
+			_ = closer.Close()
+		}
+		cleanup()
+	})
+	return svc, mock
+}
+
 func expectInsertReturningID(mock sqlmock.Sqlmock, query string, id int64, args ...driver.Value) {
 	if testDialect().Name() == "postgres" {
 		rows := sqlmock.NewRows([]string{"id"}).AddRow(id)
@@ -0,0 +1,397 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"liao/internal/database"
+)
+
+const (
+	UpstreamOutboxStatusQueued  = "queued"
+	UpstreamOutboxStatusSending = "sending"
+	UpstreamOutboxStatusSent    = "sent"
+	UpstreamOutboxStatusFailed  = "failed"
+
+	upstreamOutboxMaxErrorRunes    = 500
+	upstreamOutboxDefaultListLimit = 100
+	upstreamOutboxMaxListLimit     = 500
+)
+
+var (
+	// 连接建立后仅自动补发该时间窗口内入队（或手动重新排队）、且未超过重试上限的消息，避免重启后补发过期消息。
+	upstreamOutboxRedeliverWindow      = 2 * time.Minute
+	upstreamOutboxRedeliverMaxAttempts = 3
+	// sending 状态超过该时长未回写结果（如进程在发送中途退出）时视为未送达，可被重新认领。
+	upstreamOutboxSendingLease = time.Minute
+
+	// 已发送消息的保留时长与清理周期。
+	upstreamOutboxSentRetention = 72 * time.Hour
+	upstreamOutboxPurgeInterval = time.Hour
+)
+
+// UpstreamOutboxMessage 表示一条经 SendToUpstream 发往上游的私聊消息记录。
+// 注意：Payload 为原始帧明文（含聊天内容），数据库访问权限需按敏感数据管控；已发送记录按保留期清理。
+type UpstreamOutboxMessage struct {
+	ID         int64  `json:"id"`
+	UserID     string `json:"userId"`
+	Payload    string `json:"payload"`
+	Status     string `json:"status"`
+	Attempts   int    `json:"attempts"`
+	LastError  string `json:"lastError,omitempty"`
+	CreateTime string `json:"createTime"`
+	UpdateTime string `json:"updateTime"`
+	SentTime   string `json:"sentTime,omitempty"`
+}
+
+// UpstreamOutboxQuery 为未送达消息查询条件；Since/Before 按最近更新时间过滤，零值时不限制。
+type UpstreamOutboxQuery struct {
+	UserID      string
+	Since       time.Time
+	Before      time.Time
+	MaxAttempts int
+	Limit       int
+}
+
+// UpstreamOutboxService 定义上游发送队列的持久化能力。
+// 说明：发送链路对其为 best-effort，持久化失败不应阻断消息转发。
+// Enqueue 写入的消息即处于 sending（由实时发送链路持有）；补发与手动重试前须先 Claim，避免与实时发送重复。
+type UpstreamOutboxService interface {
+	Enqueue(ctx context.Context, userID string, payload string) (int64, error)
+	Claim(ctx context.Context, id int64) (bool, error)
+	MarkSent(ctx context.Context, id int64)
+	MarkFailed(ctx context.Context, id int64, reason string)
+	ListUndelivered(ctx context.Context, query UpstreamOutboxQuery) ([]UpstreamOutboxMessage, error)
+	Requeue(ctx context.Context, userID string, ids []int64) ([]UpstreamOutboxMessage, error)
+}
+
+// DBUpstreamOutboxService 基于数据库实现 UpstreamOutboxService，并定期清理过期的已发送记录。
+type DBUpstreamOutboxService struct {
+	db *database.DB
+
+	stopCh    chan struct{}
+	wg        sync.WaitGroup
+	closeOnce sync.Once
+}
+
+// NewDBUpstreamOutboxService 创建数据库发送队列服务。
+func NewDBUpstreamOutboxService(db *database.DB) *DBUpstreamOutboxService {
+	if db == nil {
+		return nil
+	}
+	svc := &DBUpstreamOutboxService{db: db, stopCh: make(chan struct{})}
+	if upstreamOutboxPurgeInterval > 0 {
+		svc.wg.Add(1)
+		go svc.purgeLoop(upstreamOutboxPurgeInterval)
+	}
+	return svc
+}
+
+func (s *DBUpstreamOutboxService) Enqueue(ctx context.Context, userID string, payload string) (int64, error) {
+	if s == nil || s.db == nil {
+		return 0, fmt.Errorf("db not initialized")
+	}
+	userID = strings.TrimSpace(userID)
+	if userID == "" {
+		return 0, fmt.Errorf("userId 不能为空")
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	now := time.Now()
+	return database.InsertReturningID(ctx, s.db, `
+		INSERT INTO upstream_outbox (user_id, payload, status, attempts, created_at, updated_at)
+		VALUES (?, ?, ?, 0, ?, ?)
+	`, userID, payload, UpstreamOutboxStatusSending, now, now)
+}
+
+// Claim 原子地将 queued/failed（或租约已过期的 sending）消息置为 sending，返回是否认领成功；
+// 返回 false 表示消息已被其他发送方认领或已送达，调用方不应再发送。
+func (s *DBUpstreamOutboxService) Claim(ctx context.Context, id int64) (bool, error) {
+	if s == nil || s.db == nil {
+		return false, fmt.Errorf("db not initialized")
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	now := time.Now()
+	res, err := s.db.ExecContext(ctx, `
+		UPDATE upstream_outbox SET status = ?, updated_at = ?
+		WHERE id = ? AND `+upstreamOutboxUndeliveredCond+`
+	`, UpstreamOutboxStatusSending, now, id, UpstreamOutboxStatusQueued, UpstreamOutboxStatusFailed, UpstreamOutboxStatusSending, now.Add(-upstreamOutboxSendingLease))
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
+}
+
+func (s *DBUpstreamOutboxService) MarkSent(ctx context.Context, id int64) {
+	if s == nil || s.db == nil || id <= 0 {
+		return
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	now := time.Now()
+	if _, err := s.db.ExecContext(ctx, `
+		UPDATE upstream_outbox
+		SET status = ?, attempts = attempts + 1, last_error = NULL, updated_at = ?, sent_at = ?
+		WHERE id = ?
+	`, UpstreamOutboxStatusSent, now, now, id); err != nil {
+		slog.Warn("标记上游消息已发送失败", "id", id, "error", err)
+	}
+}
+
+func (s *DBUpstreamOutboxService) MarkFailed(ctx context.Context, id int64, reason string) {
+	if s == nil || s.db == nil || id <= 0 {
+		return
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	reason = strings.TrimSpace(reason)
+	if utf8.RuneCountInString(reason) > upstreamOutboxMaxErrorRunes {
+		reason = string([]rune(reason)[:upstreamOutboxMaxErrorRunes])
+	}
+	if _, err := s.db.ExecContext(ctx, `
+		UPDATE upstream_outbox
+		SET status = ?, attempts = attempts + 1, last_error = ?, updated_at = ?
+		WHERE id = ?
+	`, UpstreamOutboxStatusFailed, nullIfEmpty(reason), time.Now(), id); err != nil {
+		slog.Warn("标记上游消息发送失败出错", "id", id, "error", err)
+	}
+}
+
+// upstreamOutboxUndeliveredCond 匹配未送达消息：queued/failed，或租约已过期的 sending；参数依次为三个状态与租约截止时间。
+const upstreamOutboxUndeliveredCond = "(status IN (?, ?) OR (status = ? AND updated_at < ?))"
+
+// ListUndelivered 按创建时间正序返回某身份未送达（queued/failed/租约过期的 sending）的消息。
+func (s *DBUpstreamOutboxService) ListUndelivered(ctx context.Context, query UpstreamOutboxQuery) ([]UpstreamOutboxMessage, error) {
+	if s == nil || s.db == nil {
+		return nil, fmt.Errorf("db not initialized")
+	}
+	userID := strings.TrimSpace(query.UserID)
+	if userID == "" {
+		return nil, fmt.Errorf("userId 不能为空")
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	var where strings.Builder
+	where.WriteString("user_id = ? AND " + upstreamOutboxUndeliveredCond)
+	args := []any{userID, UpstreamOutboxStatusQueued, UpstreamOutboxStatusFailed, UpstreamOutboxStatusSending, time.Now().Add(-upstreamOutboxSendingLease)}
+	if !query.Since.IsZero() {
+		where.WriteString(" AND updated_at >= ?")
+		args = append(args, query.Since)
+	}
+	if !query.Before.IsZero() {
+		where.WriteString(" AND updated_at < ?")
+		args = append(args, query.Before)
+	}
+	if query.MaxAttempts > 0 {
+		where.WriteString(" AND attempts < ?")
+		args = append(args, query.MaxAttempts)
+	}
+	args = append(args, normalizeUpstreamOutboxLimit(query.Limit))
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, user_id, payload, status, attempts, last_error, created_at, updated_at, sent_at
+		FROM upstream_outbox
+		WHERE `+where.String()+`
+		ORDER BY created_at ASC, id ASC
+		LIMIT ?
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanUpstreamOutboxRows(rows)
+}
+
+// Requeue 将指定消息（ids 为空时为该身份全部未送达消息）重置为 queued 并清零重试计数，用于手动重试；
+// 重置只作用于仍未送达的记录，之后由调用方逐条 Claim 再发送。
+func (s *DBUpstreamOutboxService) Requeue(ctx context.Context, userID string, ids []int64) ([]UpstreamOutboxMessage, error) {
+	if s == nil || s.db == nil {
+		return nil, fmt.Errorf("db not initialized")
+	}
+	userID = strings.TrimSpace(userID)
+	if userID == "" {
+		return nil, fmt.Errorf("userId 不能为空")
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	var items []UpstreamOutboxMessage
+	if len(ids) == 0 {
+		list, err := s.ListUndelivered(ctx, UpstreamOutboxQuery{UserID: userID, Limit: upstreamOutboxMaxListLimit})
+		if err != nil {
+			return nil, err
+		}
+		items = list
+	} else {
+		q, args, err := database.ExpandIn(`
+			SELECT id, user_id, payload, status, attempts, last_error, created_at, updated_at, sent_at
+			FROM upstream_outbox
+			WHERE user_id = ? AND `+upstreamOutboxUndeliveredCond+` AND id IN (?)
+			ORDER BY created_at ASC, id ASC
+		`, userID, UpstreamOutboxStatusQueued, UpstreamOutboxStatusFailed, UpstreamOutboxStatusSending, time.Now().Add(-upstreamOutboxSendingLease), ids)
+		if err != nil {
+			return nil, err
+		}
+		rows, err := s.db.QueryContext(ctx, q, args...)
+		if err != nil {
+			return nil, err
+		}
+		list, err := scanUpstreamOutboxRows(rows)
+		_ = rows.Close()
+		if err != nil {
+			return nil, err
+		}
+		items = list
+	}
+	if len(items) == 0 {
+		return items, nil
+	}
+
+	requeueIDs := make([]int64, 0, len(items))
+	for i := range items {
+		requeueIDs = append(requeueIDs, items[i].ID)
+		items[i].Status = UpstreamOutboxStatusQueued
+		items[i].Attempts = 0
+	}
+	now := time.Now()
+	q, args, err := database.ExpandIn(`
+		UPDATE upstream_outbox SET status = ?, attempts = 0, updated_at = ? WHERE id IN (?) AND `+upstreamOutboxUndeliveredCond+`
+	`, UpstreamOutboxStatusQueued, now, requeueIDs, UpstreamOutboxStatusQueued, UpstreamOutboxStatusFailed, UpstreamOutboxStatusSending, now.Add(-upstreamOutboxSendingLease))
+	if err != nil {
+		return nil, err
+	}
+	if _, err := s.db.ExecContext(ctx, q, args...); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// PurgeSent 删除 before 之前已发送成功的记录，返回删除行数。
+func (s *DBUpstreamOutboxService) PurgeSent(ctx context.Context, before time.Time) (int64, error) {
+	if s == nil || s.db == nil {
+		return 0, fmt.Errorf("db not initialized")
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	res, err := s.db.ExecContext(ctx, "DELETE FROM upstream_outbox WHERE status = ? AND updated_at < ?", UpstreamOutboxStatusSent, before)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func (s *DBUpstreamOutboxService) purgeLoop(interval time.Duration) {
+	defer s.wg.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stopCh:
+			return
+		case <-ticker.C:
+			n, err := s.PurgeSent(context.Background(), time.Now().Add(-upstreamOutboxSentRetention))
+			if err != nil {
+				slog.Warn("清理上游发送队列失败", "error", err)
+				continue
+			}
+			if n > 0 {
+				slog.Info("清理上游发送队列", "deleted", n)
+			}
+		}
+	}
+}
+
+func (s *DBUpstreamOutboxService) Close() error {
+	if s == nil {
+		return nil
+	}
+	s.closeOnce.Do(func() {
+		close(s.stopCh)
+		s.wg.Wait()
+	})
+	return nil
+}
+
+func scanUpstreamOutboxRows(rows *sql.Rows) ([]UpstreamOutboxMessage, error) {
+	out := make([]UpstreamOutboxMessage, 0)
+	for rows.Next() {
+		var item UpstreamOutboxMessage
+		var lastError sql.NullString
+		var createdAt, updatedAt, sentAt sql.NullTime
+		if err := rows.Scan(
+			&item.ID,
+			&item.UserID,
+			&item.Payload,
+			&item.Status,
+			&item.Attempts,
+			&lastError,
+			&createdAt,
+			&updatedAt,
+			&sentAt,
+		); err != nil {
+			return nil, err
+		}
+		if lastError.Valid {
+			item.LastError = strings.TrimSpace(lastError.String)
+		}
+		item.CreateTime = formatNullLocalDateTimeISO(createdAt)
+		item.UpdateTime = formatNullLocalDateTimeISO(updatedAt)
+		item.SentTime = formatNullLocalDateTimeISO(sentAt)
+		out = append(out, item)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func normalizeUpstreamOutboxLimit(limit int) int {
+	if limit <= 0 {
+		return upstreamOutboxDefaultListLimit
+	}
+	if limit > upstreamOutboxMaxListLimit {
+		return upstreamOutboxMaxListLimit
+	}
+	return limit
+}
+
+// isOutboundChatFrame 判断下游帧是否为私聊发送帧（act=touser_*）；只有这类帧进入发送队列，
+// sign、匹配、资料修改等控制指令即时有效，断线后重放反而有害。
+func isOutboundChatFrame(raw string) bool {
+	var head struct {
+		Act string `json:"act"`
+	}
+	if err := json.Unmarshal([]byte(raw), &head); err != nil {
+		return false
+	}
+	return isOutboundChatAct(head.Act)
+}
+
+// isOutboundChatAct 判断 act 是否为私聊发送指令（touser_{对方ID}_{对方昵称}）。
+func isOutboundChatAct(act string) bool {
+	return strings.HasPrefix(strings.TrimSpace(act), "touser_")
+}
@@ -0,0 +1,85 @@
+package app
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+func (a *App) handleListUpstreamOutbox(w http.ResponseWriter, r *http.Request) {
+	if a.upstreamOutbox == nil {
+		writeJSON(w, http.StatusOK, map[string]any{"code": -1, "msg": "发送队列未初始化"})
+		return
+	}
+
+	userID := strings.TrimSpace(r.URL.Query().Get("userId"))
+	if userID == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"code": 400, "msg": "userId不能为空"})
+		return
+	}
+	limit := parseIntDefault(r.URL.Query().Get("limit"), upstreamOutboxDefaultListLimit)
+
+	items, err := a.upstreamOutbox.ListUndelivered(r.Context(), UpstreamOutboxQuery{UserID: userID, Limit: limit})
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]any{"code": -1, "msg": "查询发送队列失败: " + err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"code": 0,
+		"msg":  "success",
+		"data": items,
+	})
+}
+
+func (a *App) handleRetryUpstreamOutbox(w http.ResponseWriter, r *http.Request) {
+	if a.wsManager == nil {
+		writeJSON(w, http.StatusOK, map[string]any{"code": -1, "msg": "WebSocket管理器未初始化"})
+		return
+	}
+
+	var in struct {
+		UserID string  `json:"userId"`
+		IDs    []int64 `json:"ids"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"code": 400, "msg": "参数解析失败"})
+		return
+	}
+	userID := strings.TrimSpace(in.UserID)
+	if userID == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"code": 400, "msg": "userId不能为空"})
+		return
+	}
+	ids := make([]int64, 0, len(in.IDs))
+	for _, id := range in.IDs {
+		if id > 0 {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) > upstreamOutboxMaxListLimit {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"code": 400, "msg": "单次最多重试500条消息"})
+		return
+	}
+
+	items, delivered, err := a.wsManager.RetryOutbox(r.Context(), userID, ids)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]any{"code": -1, "msg": "重试失败: " + err.Error()})
+		return
+	}
+
+	msg := "已重新发送"
+	if len(items) == 0 {
+		msg = "没有待重试的消息"
+	} else if !delivered {
+		msg = "该身份当前没有活跃连接，消息已重新排队"
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"code": 0,
+		"msg":  msg,
+		"data": map[string]any{
+			"requeued":  len(items),
+			"delivered": delivered,
+			"items":     items,
+		},
+	})
+}
@@ -0,0 +1,396 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gorilla/websocket"
+)
+
+var upstreamOutboxColumns = []string{"id", "user_id", "payload", "status", "attempts", "last_error", "created_at", "updated_at", "sent_at"}
+
+func TestDBUpstreamOutboxService_EnqueueAndMark(t *testing.T) {
+	svc, mock := newMockDBService(t, NewDBUpstreamOutboxService)
+
+	if _, err := svc.Enqueue(context.Background(), " ", "x"); err == nil {
+		t.Fatalf("expected userId validation error")
+	}
+
+	expectInsertReturningID(mock, `INSERT INTO upstream_outbox`, 7, "u1", `{"act":"x"}`, UpstreamOutboxStatusSending, sqlmock.AnyArg(), sqlmock.AnyArg())
+	id, err := svc.Enqueue(context.Background(), " u1 ", `{"act":"x"}`)
+	if err != nil || id != 7 {
+		t.Fatalf("id=%d err=%v", id, err)
+	}
+
+	mock.ExpectExec(`UPDATE upstream_outbox\s+SET status = \?, attempts = attempts \+ 1, last_error = NULL`).
+		WithArgs(UpstreamOutboxStatusSent, sqlmock.AnyArg(), sqlmock.AnyArg(), int64(7)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	svc.MarkSent(context.Background(), 7)
+
+	longReason := strings.Repeat("错", upstreamOutboxMaxErrorRunes+10)
+	mock.ExpectExec(`UPDATE upstream_outbox\s+SET status = \?, attempts = attempts \+ 1, last_error = \?`).
+		WithArgs(UpstreamOutboxStatusFailed, strings.Repeat("错", upstreamOutboxMaxErrorRunes), sqlmock.AnyArg(), int64(7)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	svc.MarkFailed(context.Background(), 7, longReason)
+
+	// id 非法时不访问数据库
+	svc.MarkSent(context.Background(), 0)
+	svc.MarkFailed(context.Background(), -1, "x")
+}
+
+func TestDBUpstreamOutboxService_ListUndelivered_Filters(t *testing.T) {
+	svc, mock := newMockDBService(t, NewDBUpstreamOutboxService)
+
+	since := time.Now().Add(-time.Minute)
+	before := time.Now()
+	now := time.Now()
+	mock.ExpectQuery(regexp.QuoteMeta("WHERE user_id = ? AND (status IN (?, ?) OR (status = ? AND updated_at < ?)) AND updated_at >= ? AND updated_at < ? AND attempts < ?")+`\s+ORDER BY created_at ASC, id ASC\s+LIMIT \?`).
+		WithArgs("u1", UpstreamOutboxStatusQueued, UpstreamOutboxStatusFailed, UpstreamOutboxStatusSending, sqlmock.AnyArg(), since, before, 3, upstreamOutboxMaxListLimit).
+		WillReturnRows(sqlmock.NewRows(upstreamOutboxColumns).
+			AddRow(1, "u1", "a", UpstreamOutboxStatusQueued, 0, nil, now, now, nil).
+			AddRow(2, "u1", "b", UpstreamOutboxStatusFailed, 2, "write: broken pipe", now, now, nil))
+
+	items, err := svc.ListUndelivered(context.Background(), UpstreamOutboxQuery{
+		UserID:      "u1",
+		Since:       since,
+		Before:      before,
+		MaxAttempts: 3,
+		Limit:       9999,
+	})
+	if err != nil {
+		t.Fatalf("ListUndelivered: %v", err)
+	}
+	if len(items) != 2 || items[1].LastError != "write: broken pipe" || items[0].SentTime != "" {
+		t.Fatalf("items=%+v", items)
+	}
+
+	if _, err := svc.ListUndelivered(context.Background(), UpstreamOutboxQuery{}); err == nil {
+		t.Fatalf("expected userId validation error")
+	}
+}
+
+func TestDBUpstreamOutboxService_Requeue(t *testing.T) {
+	svc, mock := newMockDBService(t, NewDBUpstreamOutboxService)
+
+	now := time.Now()
+	mock.ExpectQuery(regexp.QuoteMeta("WHERE user_id = ? AND (status IN (?, ?) OR (status = ? AND updated_at < ?)) AND id IN (?,?)")).
+		WithArgs("u1", UpstreamOutboxStatusQueued, UpstreamOutboxStatusFailed, UpstreamOutboxStatusSending, sqlmock.AnyArg(), int64(3), int64(4)).
+		WillReturnRows(sqlmock.NewRows(upstreamOutboxColumns).
+			AddRow(3, "u1", "c", UpstreamOutboxStatusFailed, 3, "x", now, now, nil))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE upstream_outbox SET status = ?, attempts = 0, updated_at = ? WHERE id IN (?) AND (status IN (?, ?) OR (status = ? AND updated_at < ?))")).
+		WithArgs(UpstreamOutboxStatusQueued, sqlmock.AnyArg(), int64(3), UpstreamOutboxStatusQueued, UpstreamOutboxStatusFailed, UpstreamOutboxStatusSending, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	items, err := svc.Requeue(context.Background(), "u1", []int64{3, 4})
+	if err != nil {
+		t.Fatalf("Requeue: %v", err)
+	}
+	if len(items) != 1 || items[0].Status != UpstreamOutboxStatusQueued || items[0].Attempts != 0 {
+		t.Fatalf("items=%+v", items)
+	}
+
+	// ids 为空：按身份查询全部未送达消息，结果为空时不执行更新
+	mock.ExpectQuery(`FROM upstream_outbox\s+WHERE user_id = \? AND \(status IN \(\?, \?\) OR \(status = \? AND updated_at < \?\)\)\s+ORDER BY`).
+		WithArgs("u1", UpstreamOutboxStatusQueued, UpstreamOutboxStatusFailed, UpstreamOutboxStatusSending, sqlmock.AnyArg(), upstreamOutboxMaxListLimit).
+		WillReturnRows(sqlmock.NewRows(upstreamOutboxColumns))
+	items, err = svc.Requeue(context.Background(), "u1", nil)
+	if err != nil || len(items) != 0 {
+		t.Fatalf("items=%v err=%v", items, err)
+	}
+}
+
+func TestDBUpstreamOutboxService_Claim(t *testing.T) {
+	svc, mock := newMockDBService(t, NewDBUpstreamOutboxService)
+
+	claim := regexp.QuoteMeta("UPDATE upstream_outbox SET status = ?, updated_at = ?") + `\s+` +
+		regexp.QuoteMeta("WHERE id = ? AND (status IN (?, ?) OR (status = ? AND updated_at < ?))")
+	mock.ExpectExec(claim).
+		WithArgs(UpstreamOutboxStatusSending, sqlmock.AnyArg(), int64(5), UpstreamOutboxStatusQueued, UpstreamOutboxStatusFailed, UpstreamOutboxStatusSending, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	if ok, err := svc.Claim(context.Background(), 5); err != nil || !ok {
+		t.Fatalf("ok=%v err=%v", ok, err)
+	}
+
+	// 已被其他发送方认领或已送达：条件更新不命中任何行。
+	mock.ExpectExec(claim).WillReturnResult(sqlmock.NewResult(0, 0))
+	if ok, err := svc.Claim(context.Background(), 5); err != nil || ok {
+		t.Fatalf("ok=%v err=%v, want not claimed", ok, err)
+	}
+
+	mock.ExpectExec(claim).WillReturnError(errors.New("boom"))
+	if _, err := svc.Claim(context.Background(), 5); err == nil {
+		t.Fatalf("expected error")
+	}
+}
+
+func TestIsOutboundChatFrame(t *testing.T) {
+	cases := map[string]bool{
+		`{"act":"touser_u2_Bob","id":"u1","msg":"hi"}`: true,
+		`{"act":"sign","id":"u1"}`:                     false,
+		`{"act":"ShowUserLoginInfo","msg":"u2"}`:       false,
+		`{"act":"random","id":"u1"}`:                   false,
+		`not json`:                                     false,
+	}
+	for raw, want := range cases {
+		if got := isOutboundChatFrame(raw); got != want {
+			t.Fatalf("isOutboundChatFrame(%s)=%v, want %v", raw, got, want)
+		}
+	}
+}
+
+func TestDBUpstreamOutboxService_PurgeSent(t *testing.T) {
+	svc, mock := newMockDBService(t, NewDBUpstreamOutboxService)
+
+	before := time.Now()
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM upstream_outbox WHERE status = ? AND updated_at < ?")).
+		WithArgs(UpstreamOutboxStatusSent, before).
+		WillReturnResult(sqlmock.NewResult(0, 5))
+	n, err := svc.PurgeSent(context.Background(), before)
+	if err != nil || n != 5 {
+		t.Fatalf("n=%d err=%v", n, err)
+	}
+
+	mock.ExpectExec(`DELETE FROM upstream_outbox`).WillReturnError(errors.New("boom"))
+	if _, err := svc.PurgeSent(context.Background(), before); err == nil {
+		t.Fatalf("expected error")
+	}
+}
+
+func TestNewDBUpstreamOutboxService_NilDB(t *testing.T) {
+	if svc := NewDBUpstreamOutboxService(nil); svc != nil {
+		t.Fatalf("expected nil service")
+	}
+}
+
+type spyUpstreamOutbox struct {
+	mu      sync.Mutex
+	nextID  int64
+	records map[int64]*UpstreamOutboxMessage
+	sent    chan int64
+}
+
+func newSpyUpstreamOutbox() *spyUpstreamOutbox {
+	return &spyUpstreamOutbox{records: make(map[int64]*UpstreamOutboxMessage), sent: make(chan int64, 16)}
+}
+
+func (s *spyUpstreamOutbox) Enqueue(_ context.Context, userID string, payload string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nextID++
+	s.records[s.nextID] = &UpstreamOutboxMessage{ID: s.nextID, UserID: userID, Payload: payload, Status: UpstreamOutboxStatusSending}
+	return s.nextID, nil
+}
+
+func (s *spyUpstreamOutbox) Claim(_ context.Context, id int64) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rec := s.records[id]
+	if rec == nil || (rec.Status != UpstreamOutboxStatusQueued && rec.Status != UpstreamOutboxStatusFailed) {
+		return false, nil
+	}
+	rec.Status = UpstreamOutboxStatusSending
+	return true, nil
+}
+
+func (s *spyUpstreamOutbox) MarkSent(_ context.Context, id int64) {
+	s.mu.Lock()
+	if rec := s.records[id]; rec != nil {
+		rec.Status = UpstreamOutboxStatusSent
+		rec.Attempts++
+	}
+	s.mu.Unlock()
+	s.sent <- id
+}
+
+func (s *spyUpstreamOutbox) MarkFailed(_ context.Context, id int64, reason string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if rec := s.records[id]; rec != nil {
+		rec.Status = UpstreamOutboxStatusFailed
+		rec.Attempts++
+		rec.LastError = reason
+	}
+}
+
+func (s *spyUpstreamOutbox) ListUndelivered(_ context.Context, query UpstreamOutboxQuery) ([]UpstreamOutboxMessage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]UpstreamOutboxMessage, 0)
+	for id := int64(1); id <= s.nextID; id++ {
+		rec := s.records[id]
+		if rec == nil || rec.UserID != query.UserID || rec.Status == UpstreamOutboxStatusSent || rec.Status == UpstreamOutboxStatusSending {
+			continue
+		}
+		out = append(out, *rec)
+	}
+	return out, nil
+}
+
+func (s *spyUpstreamOutbox) Requeue(ctx context.Context, userID string, _ []int64) ([]UpstreamOutboxMessage, error) {
+	items, err := s.ListUndelivered(ctx, UpstreamOutboxQuery{UserID: userID})
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range items {
+		s.records[items[i].ID].Status = UpstreamOutboxStatusQueued
+		s.records[items[i].ID].Attempts = 0
+		items[i].Status = UpstreamOutboxStatusQueued
+	}
+	return items, err
+}
+
+func (s *spyUpstreamOutbox) status(id int64) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if rec := s.records[id]; rec != nil {
+		return rec.Status
+	}
+	return ""
+}
+
+func TestUpstreamWebSocketManager_Outbox_MarksSentAndRedeliversOnConnect(t *testing.T) {
+	received := make(chan string, 8)
+	tracker := &wsConnTracker{}
+	srv := newUpstreamWSServer(t, func(conn *websocket.Conn) {
+		tracker.add(conn)
+		for {
+			_, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			received <- string(data)
+		}
+	})
+	t.Cleanup(func() {
+		tracker.closeAll()
+		srv.Close()
+	})
+
+	outbox := newSpyUpstreamOutbox()
+	m := NewUpstreamWebSocketManager(nil, toWSURL(srv.URL), nil, nil, nil)
+	m.SetOutbox(outbox)
+	t.Cleanup(m.CloseAllConnections)
+
+	// 无下游会话时消息无法投递，以 failed 状态保留在发送队列中；控制帧不入队。
+	m.SendToUpstream("u1", `{"act":"touser_u2_early","id":"u1","msg":"early"}`)
+	m.SendToUpstream("u1", `{"act":"random","id":"u1"}`)
+	if got := outbox.status(1); got != UpstreamOutboxStatusFailed {
+		t.Fatalf("status=%q, want failed", got)
+	}
+	if got := outbox.status(2); got != "" {
+		t.Fatalf("control frame enqueued with status %q", got)
+	}
+
+	m.RegisterDownstream("u1", &DownstreamSession{}, `{"act":"sign","id":"u1"}`)
+	want := []string{`{"act":"sign","id":"u1"}`, `{"act":"touser_u2_early","id":"u1","msg":"early"}`}
+	for _, w := range want {
+		select {
+		case got := <-received:
+			if got != w {
+				t.Fatalf("got=%q, want %q", got, w)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("timeout waiting %q", w)
+		}
+	}
+	select {
+	case id := <-outbox.sent:
+		if id != 1 {
+			t.Fatalf("sent id=%d, want 1", id)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("timeout waiting redelivery mark")
+	}
+
+	m.SendToUpstream("u1", `{"act":"touser_u2_chat","id":"u1","msg":"chat"}`)
+	select {
+	case id := <-outbox.sent:
+		if id != 2 {
+			t.Fatalf("sent id=%d, want 2", id)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("timeout waiting sent mark")
+	}
+	if got := outbox.status(2); got != UpstreamOutboxStatusSent {
+		t.Fatalf("status=%q, want sent", got)
+	}
+}
+
+func TestUpstreamWebSocketManager_RetryOutbox(t *testing.T) {
+	m := NewUpstreamWebSocketManager(nil, "ws://unused", nil, nil, nil)
+	if _, _, err := m.RetryOutbox(context.Background(), "u1", nil); err == nil {
+		t.Fatalf("expected error without outbox")
+	}
+
+	outbox := newSpyUpstreamOutbox()
+	m.SetOutbox(outbox)
+	if _, _, err := m.RetryOutbox(context.Background(), " ", nil); err == nil {
+		t.Fatalf("expected userId validation error")
+	}
+
+	id, _ := outbox.Enqueue(context.Background(), "u1", "x")
+	outbox.MarkFailed(context.Background(), id, "boom")
+	items, delivered, err := m.RetryOutbox(context.Background(), "u1", nil)
+	if err != nil || len(items) != 1 || delivered {
+		t.Fatalf("items=%v delivered=%v err=%v", items, delivered, err)
+	}
+}
+
+func TestHandleUpstreamOutbox_Handlers(t *testing.T) {
+	a := &App{}
+	rec := httptest.NewRecorder()
+	a.handleListUpstreamOutbox(rec, httptest.NewRequest(http.MethodGet, "/api/outbox/list?userId=u1", nil))
+	if got := decodeJSONBody(t, rec.Body); toInt(got["code"]) != -1 {
+		t.Fatalf("got=%v", got)
+	}
+
+	svc, mock := newMockDBService(t, NewDBUpstreamOutboxService)
+	a.upstreamOutbox = svc
+
+	rec = httptest.NewRecorder()
+	a.handleListUpstreamOutbox(rec, httptest.NewRequest(http.MethodGet, "/api/outbox/list", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("status=%d, want 400", rec.Code)
+	}
+
+	now := time.Now()
+	mock.ExpectQuery(`FROM upstream_outbox`).
+		WithArgs("u1", UpstreamOutboxStatusQueued, UpstreamOutboxStatusFailed, UpstreamOutboxStatusSending, sqlmock.AnyArg(), 20).
+		WillReturnRows(sqlmock.NewRows(upstreamOutboxColumns).AddRow(1, "u1", "a", UpstreamOutboxStatusQueued, 0, nil, now, now, nil))
+	rec = httptest.NewRecorder()
+	a.handleListUpstreamOutbox(rec, httptest.NewRequest(http.MethodGet, "/api/outbox/list?userId=u1&limit=20", nil))
+	got := decodeJSONBody(t, rec.Body)
+	if toInt(got["code"]) != 0 || len(got["data"].([]any)) != 1 {
+		t.Fatalf("got=%v", got)
+	}
+
+	rec = httptest.NewRecorder()
+	a.handleRetryUpstreamOutbox(rec, httptest.NewRequest(http.MethodPost, "/api/outbox/retry", strings.NewReader(`{"userId":"u1"}`)))
+	if got := decodeJSONBody(t, rec.Body); toInt(got["code"]) != -1 {
+		t.Fatalf("got=%v", got)
+	}
+
+	a.wsManager = NewUpstreamWebSocketManager(nil, "ws://unused", nil, nil, nil)
+	a.wsManager.SetOutbox(newSpyUpstreamOutbox())
+	for _, body := range []string{`{`, `{"userId":" "}`} {
+		rec = httptest.NewRecorder()
+		a.handleRetryUpstreamOutbox(rec, httptest.NewRequest(http.MethodPost, "/api/outbox/retry", strings.NewReader(body)))
+		if rec.Code != http.StatusBadRequest {
+			t.Fatalf("body=%s status=%d, want 400", body, rec.Code)
+		}
+	}
+
+	rec = httptest.NewRecorder()
+	a.handleRetryUpstreamOutbox(rec, httptest.NewRequest(http.MethodPost, "/api/outbox/retry", strings.NewReader(`{"userId":"u1","ids":[1,0]}`)))
+	got = decodeJSONBody(t, rec.Body)
+	if toInt(got["code"]) != 0 || got["msg"] != "没有待重试的消息" {
+		t.Fatalf("got=%v", got)
+	}
+}
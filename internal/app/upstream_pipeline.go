@@ -0,0 +1,217 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+
+	"liao/internal/protocol"
+)
+
+// UpstreamFrameVerdict 为处理阶段对上游帧的处理结论。
+type UpstreamFrameVerdict int
+
+const (
+	// UpstreamFramePass 继续交给后续阶段，最终广播给下游。
+	UpstreamFramePass UpstreamFrameVerdict = iota
+	// UpstreamFrameDrop 终止后续阶段且不广播（帧已被消费或需要过滤）。
+	UpstreamFrameDrop
+)
+
+// UpstreamFrame 表示一条从上游收到、尚未广播给下游的消息。
+// Node 为解析后的 JSON 对象，非 JSON 帧时为 nil、Code 为 0。
+type UpstreamFrame struct {
+	UserID string
+	Raw    string
+	Node   map[string]any
+	Code   int
+
+	typed protocol.Message
+}
+
+func newUpstreamFrame(userID string, raw string) *UpstreamFrame {
+	frame := &UpstreamFrame{UserID: userID, Raw: raw}
+	var node map[string]any
+	if err := json.Unmarshal([]byte(raw), &node); err == nil && node != nil {
+		frame.Node = node
+		frame.Code = toInt(node["code"])
+	}
+	return frame
+}
+
+// Rewrite 用新的 JSON 对象替换帧内容，保证后续阶段看到的 Raw/Node/Code 一致。
+func (f *UpstreamFrame) Rewrite(node map[string]any) error {
+	b, err := json.Marshal(node)
+	if err != nil {
+		return err
+	}
+	f.Raw = string(b)
+	f.Node = node
+	f.Code = toInt(node["code"])
+	f.typed = nil
+	return nil
+}
+
+// Message 返回宽松解码后的类型化消息（结果缓存，Rewrite 后重新解码）；非 JSON 对象帧返回 nil。
+func (f *UpstreamFrame) Message() protocol.Message {
+	if f.typed == nil && f.Node != nil {
+		if msg, err := protocol.DecodeLenient([]byte(f.Raw)); err == nil {
+			f.typed = msg
+		}
+	}
+	return f.typed
+}
+
+// UpstreamMessageHandler 处理（观察、改写或丢弃）一条上游帧。
+type UpstreamMessageHandler interface {
+	HandleUpstreamMessage(frame *UpstreamFrame) UpstreamFrameVerdict
+}
+
+// UpstreamMessageHandlerFunc 允许直接以函数注册处理阶段。
+type UpstreamMessageHandlerFunc func(frame *UpstreamFrame) UpstreamFrameVerdict
+
+func (f UpstreamMessageHandlerFunc) HandleUpstreamMessage(frame *UpstreamFrame) UpstreamFrameVerdict {
+	return f(frame)
+}
+
+type upstreamPipelineStage struct {
+	name    string
+	codes   map[int]struct{}
+	handler UpstreamMessageHandler
+}
+
+func (s upstreamPipelineStage) matches(code int) bool {
+	if len(s.codes) == 0 {
+		return true
+	}
+	_, ok := s.codes[code]
+	return ok
+}
+
+// UpstreamMessagePipeline 按注册顺序依次执行订阅了帧 code 的处理阶段，未订阅 code 的阶段接收全部帧。
+type UpstreamMessagePipeline struct {
+	mu     sync.RWMutex
+	stages []upstreamPipelineStage
+}
+
+func NewUpstreamMessagePipeline() *UpstreamMessagePipeline {
+	return &UpstreamMessagePipeline{}
+}
+
+// Register 在末尾追加处理阶段；同名阶段已存在时原位替换。
+func (p *UpstreamMessagePipeline) Register(name string, handler UpstreamMessageHandler, codes ...int) error {
+	return p.register(name, handler, codes, false)
+}
+
+// RegisterFirst 在最前面插入处理阶段（先于内置阶段执行），适合过滤类处理；同名阶段已存在时先移除。
+func (p *UpstreamMessagePipeline) RegisterFirst(name string, handler UpstreamMessageHandler, codes ...int) error {
+	return p.register(name, handler, codes, true)
+}
+
+func (p *UpstreamMessagePipeline) register(name string, handler UpstreamMessageHandler, codes []int, first bool) error {
+	if p == nil {
+		return fmt.Errorf("pipeline not initialized")
+	}
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return fmt.Errorf("处理阶段名称不能为空")
+	}
+	if handler == nil {
+		return fmt.Errorf("处理阶段不能为空")
+	}
+
+	stage := upstreamPipelineStage{name: name, handler: handler}
+	if len(codes) > 0 {
+		stage.codes = make(map[int]struct{}, len(codes))
+		for _, code := range codes {
+			stage.codes[code] = struct{}{}
+		}
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i := range p.stages {
+		if p.stages[i].name != name {
+			continue
+		}
+		if !first {
+			p.stages[i] = stage
+			return nil
+		}
+		p.stages = append(p.stages[:i], p.stages[i+1:]...)
+		break
+	}
+	if first {
+		p.stages = append([]upstreamPipelineStage{stage}, p.stages...)
+	} else {
+		p.stages = append(p.stages, stage)
+	}
+	return nil
+}
+
+// Unregister 移除指定名称的处理阶段，返回是否存在。
+func (p *UpstreamMessagePipeline) Unregister(name string) bool {
+	if p == nil {
+		return false
+	}
+	name = strings.TrimSpace(name)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i := range p.stages {
+		if p.stages[i].name == name {
+			p.stages = append(p.stages[:i], p.stages[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Names 按执行顺序返回已注册的处理阶段名称。
+func (p *UpstreamMessagePipeline) Names() []string {
+	if p == nil {
+		return nil
+	}
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	out := make([]string, 0, len(p.stages))
+	for _, s := range p.stages {
+		out = append(out, s.name)
+	}
+	return out
+}
+
+// Process 依次执行处理阶段，返回帧是否仍需广播给下游。
+// 单个阶段 panic 只记录日志并视为放行，避免影响上游读循环。
+func (p *UpstreamMessagePipeline) Process(frame *UpstreamFrame) bool {
+	if p == nil || frame == nil {
+		return frame != nil
+	}
+	p.mu.RLock()
+	stages := make([]upstreamPipelineStage, len(p.stages))
+	copy(stages, p.stages)
+	p.mu.RUnlock()
+
+	for _, stage := range stages {
+		if !stage.matches(frame.Code) {
+			continue
+		}
+		if runUpstreamPipelineStage(stage, frame) == UpstreamFrameDrop {
+			slog.Debug("上游消息被处理阶段拦截", "userID", frame.UserID, "code", frame.Code, "stage", stage.name)
+			return false
+		}
+	}
+	return true
+}
+
+func runUpstreamPipelineStage(stage upstreamPipelineStage, frame *UpstreamFrame) (verdict UpstreamFrameVerdict) {
+	defer func() {
+		if r := recover(); r != nil {
+			slog.Error("上游消息处理阶段异常", "stage", stage.name, "userID", frame.UserID, "code", frame.Code, "panic", r)
+			verdict = UpstreamFramePass
+		}
+	}()
+	return stage.handler.HandleUpstreamMessage(frame)
+}
@@ -0,0 +1,196 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+
+	"liao/internal/protocol"
+)
+
+// 内置处理阶段名称，可通过 Pipeline().Unregister 移除或同名 Register 替换。
+const (
+	UpstreamStageProtocol    = "protocol-check"
+	UpstreamStageForceout    = "forceout"
+	UpstreamStageUserInfo    = "user-info-cache"
+	UpstreamStageUserArchive = "user-archive"
+	UpstreamStageLastMessage = "last-message-cache"
+	UpstreamStageChatHistory = "chat-history"
+)
+
+// registerBuiltinUpstreamStages 注册内置阶段：协议校验，以及原 onMessage 中的 forceout、用户信息缓存、匹配归档、最后消息缓存与聊天记录。
+func (m *UpstreamWebSocketManager) registerBuiltinUpstreamStages() {
+	_ = m.pipeline.Register(UpstreamStageProtocol, UpstreamMessageHandlerFunc(m.handleProtocolCheckFrame), protocol.CodeChat, protocol.CodeUserInfo, protocol.CodeForceout)
+	_ = m.pipeline.Register(UpstreamStageForceout, UpstreamMessageHandlerFunc(m.handleForceoutFrame), -3)
+	_ = m.pipeline.Register(UpstreamStageUserInfo, UpstreamMessageHandlerFunc(m.handleUserInfoFrame), 15)
+	_ = m.pipeline.Register(UpstreamStageUserArchive, UpstreamMessageHandlerFunc(m.handleUserArchiveFrame), 15)
+	_ = m.pipeline.Register(UpstreamStageLastMessage, UpstreamMessageHandlerFunc(m.handleLastMessageFrame), 7)
+	_ = m.pipeline.Register(UpstreamStageChatHistory, UpstreamMessageHandlerFunc(m.handleChatHistoryFrame), 7)
+}
+
+func (m *UpstreamWebSocketManager) handleForceoutFrame(frame *UpstreamFrame) UpstreamFrameVerdict {
+	forceout, ok := frame.Message().(*protocol.Forceout)
+	if !ok || !forceout.Forceout {
+		return UpstreamFramePass
+	}
+	logMessage := frame.Raw
+	if len(logMessage) > 500 {
+		logMessage = logMessage[:500] + "...(截断)"
+	}
+	slog.Warn("收到forceout消息", "userID", frame.UserID, "message", logMessage)
+	// HandleForceout 自行广播并关闭连接，不再走普通广播。
+	m.HandleForceout(frame.UserID, frame.Raw)
+	return UpstreamFrameDrop
+}
+
+func (m *UpstreamWebSocketManager) handleUserInfoFrame(frame *UpstreamFrame) UpstreamFrameVerdict {
+	info, ok := frame.Message().(*protocol.UserInfo)
+	if !ok {
+		return UpstreamFramePass
+	}
+	target := strings.TrimSpace(info.UserID)
+	slog.Debug("收到用户信息消息(code=15)", "userID", frame.UserID, "targetUserID", target)
+	if target != "" && m.cache != nil {
+		m.cache.SaveUserInfo(CachedUserInfo{
+			UserID:   target,
+			Nickname: info.Nickname,
+			Gender:   info.Sex,
+			Age:      info.Age,
+			Address:  info.Address,
+		})
+	}
+	return UpstreamFramePass
+}
+
+func (m *UpstreamWebSocketManager) handleUserArchiveFrame(frame *UpstreamFrame) UpstreamFrameVerdict {
+	info, ok := frame.Message().(*protocol.UserInfo)
+	if !ok {
+		return UpstreamFramePass
+	}
+	target := strings.TrimSpace(info.UserID)
+	if target != "" && target != frame.UserID && m.archive != nil {
+		m.archive.PersistUserList(context.Background(), frame.UserID, []map[string]any{
+			buildMatchedUserArchiveSnapshot(frame.Node, target),
+		}, UserArchiveListSourceHistory)
+	}
+	return UpstreamFramePass
+}
+
+func (m *UpstreamWebSocketManager) handleLastMessageFrame(frame *UpstreamFrame) UpstreamFrameVerdict {
+	chat, ok := frame.Message().(*protocol.Chat)
+	if !ok {
+		return UpstreamFramePass
+	}
+	fromUserID := strings.TrimSpace(chat.From.ID)
+	toUserID := strings.TrimSpace(chat.To.ID)
+	content := chat.Text()
+	tm := chat.Timestamp()
+	msgType := chat.MessageType()
+
+	// 记录聊天消息（截断内容以避免日志过大）
+	logContent := content
+	if len(logContent) > 100 {
+		logContent = logContent[:100] + "...(截断)"
+	}
+	slog.Info("收到聊天消息(code=7)", "userID", frame.UserID, "fromUserID", fromUserID, "toUserID", toUserID, "msgType", msgType, "tid", chat.MessageTID(), "content", logContent)
+
+	if fromUserID == "" || toUserID == "" || content == "" || tm == "" || m.cache == nil {
+		return UpstreamFramePass
+	}
+	m.cache.SaveLastMessage(CachedLastMessage{
+		FromUserID: fromUserID,
+		ToUserID:   toUserID,
+		Content:    content,
+		Type:       msgType,
+		Time:       tm,
+	})
+
+	// 兼容：上游返回的 toUserId 有时不是本地身份 userId，补写会话 key，保证列表可命中。
+	if frame.UserID != fromUserID && frame.UserID != toUserID {
+		m.cache.SaveLastMessage(CachedLastMessage{
+			FromUserID: fromUserID,
+			ToUserID:   frame.UserID,
+			Content:    content,
+			Type:       msgType,
+			Time:       tm,
+		})
+		m.cache.SaveLastMessage(CachedLastMessage{
+			FromUserID: frame.UserID,
+			ToUserID:   toUserID,
+			Content:    content,
+			Type:       msgType,
+			Time:       tm,
+		})
+	}
+	return UpstreamFramePass
+}
+
+func (m *UpstreamWebSocketManager) handleChatHistoryFrame(frame *UpstreamFrame) UpstreamFrameVerdict {
+	chat, ok := frame.Message().(*protocol.Chat)
+	if !ok {
+		return UpstreamFramePass
+	}
+	tid := chat.MessageTID()
+	content := chat.Text()
+	tm := chat.Timestamp()
+	if tid == "" || content == "" || tm == "" || m.history == nil {
+		return UpstreamFramePass
+	}
+
+	userID := frame.UserID
+	normalizedFrom := strings.TrimSpace(chat.From.ID)
+	normalizedTo := strings.TrimSpace(chat.To.ID)
+	localMD5 := md5HexLower(userID)
+	if localMD5 != "" {
+		if strings.EqualFold(normalizedFrom, localMD5) {
+			normalizedFrom = userID
+		}
+		if strings.EqualFold(normalizedTo, localMD5) {
+			normalizedTo = userID
+		}
+	}
+
+	historyMsg := map[string]any{
+		"Tid":     tid,
+		"id":      normalizedFrom,
+		"toid":    normalizedTo,
+		"content": content,
+		"time":    tm,
+	}
+	if nickname := chat.SenderNickname(); nickname != "" {
+		historyMsg["nickname"] = nickname
+	}
+
+	conversationKeys := make(map[string]struct{}, 3)
+	if key := generateConversationKey(normalizedFrom, normalizedTo); key != "" {
+		conversationKeys[key] = struct{}{}
+	}
+
+	// 兼容：上游返回的 toUserId 有时不是本地身份 userId，补写会话 key，保证历史查询可命中。
+	if strings.TrimSpace(userID) != "" && userID != normalizedFrom && userID != normalizedTo {
+		if key := generateConversationKey(normalizedFrom, userID); key != "" {
+			conversationKeys[key] = struct{}{}
+		}
+		if key := generateConversationKey(userID, normalizedTo); key != "" {
+			conversationKeys[key] = struct{}{}
+		}
+	}
+
+	for key := range conversationKeys {
+		m.history.SaveMessages(context.Background(), key, []map[string]any{historyMsg})
+	}
+	return UpstreamFramePass
+}
+
+// handleProtocolCheckFrame 对已建模的帧做严格解码，不符合协议时记录告警（不拦截），用于及时发现上游字段变更。
+func (m *UpstreamWebSocketManager) handleProtocolCheckFrame(frame *UpstreamFrame) UpstreamFrameVerdict {
+	if _, err := protocol.Decode([]byte(frame.Raw)); err != nil {
+		var decodeErr *protocol.DecodeError
+		if errors.As(err, &decodeErr) {
+			m.protocolErrors.Add(1)
+			slog.Warn("上游消息不符合协议", "userID", frame.UserID, "code", frame.Code, "kind", decodeErr.Kind, "field", decodeErr.Field, "reason", decodeErr.Reason)
+		}
+	}
+	return UpstreamFramePass
+}
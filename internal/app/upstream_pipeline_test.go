@@ -0,0 +1,160 @@
+package app
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestUpstreamMessagePipeline_RegisterOrderAndCodes(t *testing.T) {
+	p := NewUpstreamMessagePipeline()
+	var calls []string
+	record := func(name string) UpstreamMessageHandler {
+		return UpstreamMessageHandlerFunc(func(*UpstreamFrame) UpstreamFrameVerdict {
+			calls = append(calls, name)
+			return UpstreamFramePass
+		})
+	}
+
+	if err := p.Register(" ", record("x")); err == nil {
+		t.Fatalf("expected empty name error")
+	}
+	if err := p.Register("x", nil); err == nil {
+		t.Fatalf("expected nil handler error")
+	}
+
+	_ = p.Register("all", record("all"))
+	_ = p.Register("chat", record("chat"), 7)
+	_ = p.Register("info", record("info"), 15)
+	_ = p.RegisterFirst("first", record("first"), 7, 15)
+
+	if !p.Process(newUpstreamFrame("u1", `{"code":7}`)) {
+		t.Fatalf("expected frame kept")
+	}
+	if want := []string{"first", "all", "chat"}; !reflect.DeepEqual(calls, want) {
+		t.Fatalf("calls=%v, want %v", calls, want)
+	}
+
+	calls = nil
+	p.Process(newUpstreamFrame("u1", "not-json"))
+	if want := []string{"all"}; !reflect.DeepEqual(calls, want) {
+		t.Fatalf("calls=%v, want %v", calls, want)
+	}
+
+	// 同名 Register 原位替换，同名 RegisterFirst 移到最前。
+	_ = p.Register("chat", record("chat2"), 7)
+	_ = p.RegisterFirst("all", record("all"))
+	if want := []string{"all", "first", "chat", "info"}; !reflect.DeepEqual(p.Names(), want) {
+		t.Fatalf("names=%v, want %v", p.Names(), want)
+	}
+	calls = nil
+	p.Process(newUpstreamFrame("u1", `{"code":7}`))
+	if want := []string{"all", "first", "chat2"}; !reflect.DeepEqual(calls, want) {
+		t.Fatalf("calls=%v, want %v", calls, want)
+	}
+
+	if !p.Unregister("first") || p.Unregister("first") {
+		t.Fatalf("unexpected Unregister result")
+	}
+}
+
+func TestUpstreamMessagePipeline_DropRewriteAndPanic(t *testing.T) {
+	p := NewUpstreamMessagePipeline()
+	_ = p.Register("panic", UpstreamMessageHandlerFunc(func(*UpstreamFrame) UpstreamFrameVerdict {
+		panic("boom")
+	}))
+	_ = p.Register("rewrite", UpstreamMessageHandlerFunc(func(f *UpstreamFrame) UpstreamFrameVerdict {
+		f.Node["content"] = "***"
+		if err := f.Rewrite(f.Node); err != nil {
+			t.Fatalf("Rewrite: %v", err)
+		}
+		return UpstreamFramePass
+	}), 7)
+	_ = p.Register("drop-typing", UpstreamMessageHandlerFunc(func(f *UpstreamFrame) UpstreamFrameVerdict {
+		if toString(f.Node["act"]) == "typing" {
+			return UpstreamFrameDrop
+		}
+		return UpstreamFramePass
+	}))
+
+	frame := newUpstreamFrame("u1", `{"code":7,"content":"secret"}`)
+	if !p.Process(frame) {
+		t.Fatalf("expected frame kept")
+	}
+	if frame.Raw != `{"code":7,"content":"***"}` {
+		t.Fatalf("raw=%s", frame.Raw)
+	}
+	if p.Process(newUpstreamFrame("u1", `{"act":"typing"}`)) {
+		t.Fatalf("expected frame dropped")
+	}
+
+	var nilPipeline *UpstreamMessagePipeline
+	if !nilPipeline.Process(newUpstreamFrame("u1", `{}`)) || nilPipeline.Names() != nil {
+		t.Fatalf("nil pipeline should pass frames")
+	}
+}
+
+func TestUpstreamWebSocketManager_Pipeline_CustomStageAndBuiltins(t *testing.T) {
+	cache := &spyUserInfoCache{}
+	m := NewUpstreamWebSocketManager(nil, "ws://unused", nil, cache, nil)
+	t.Cleanup(m.CloseAllConnections)
+
+	want := []string{UpstreamStageProtocol, UpstreamStageForceout, UpstreamStageUserInfo, UpstreamStageUserArchive, UpstreamStageLastMessage, UpstreamStageChatHistory}
+	if got := m.Pipeline().Names(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("names=%v, want %v", got, want)
+	}
+
+	session, client := newDownstreamPair(t)
+	m.mu.Lock()
+	m.downstreamSessions["u1"] = map[*DownstreamSession]struct{}{session: {}}
+	m.mu.Unlock()
+
+	// 前置拦截阶段丢弃的帧既不触发内置缓存，也不广播。
+	_ = m.Pipeline().RegisterFirst("block-u9", UpstreamMessageHandlerFunc(func(f *UpstreamFrame) UpstreamFrameVerdict {
+		if toString(f.Node["sel_userid"]) == "u9" {
+			return UpstreamFrameDrop
+		}
+		return UpstreamFramePass
+	}), 15)
+
+	c := NewUpstreamWebSocketClient("u1", "ws://unused", m)
+	c.onMessage(`{"code":15,"sel_userid":"u9"}`)
+	c.onMessage(`{"code":15,"sel_userid":"u8","sel_userNikename":"N"}`)
+
+	got := readDownstreamCode(t, client, 15)
+	if got["sel_userid"] != "u8" {
+		t.Fatalf("unexpected frame: %v", got)
+	}
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	if len(cache.userInfos) != 1 || cache.userInfos[0].UserID != "u8" {
+		t.Fatalf("userInfos=%+v", cache.userInfos)
+	}
+}
+
+func TestUpstreamWebSocketManager_Pipeline_ProtocolDriftStillBroadcasts(t *testing.T) {
+	cache := &spyUserInfoCache{}
+	m := NewUpstreamWebSocketManager(nil, "ws://unused", nil, cache, nil)
+	t.Cleanup(m.CloseAllConnections)
+
+	session, client := newDownstreamPair(t)
+	m.mu.Lock()
+	m.downstreamSessions["u1"] = map[*DownstreamSession]struct{}{session: {}}
+	m.mu.Unlock()
+
+	// sel_userAge 变成数字：严格解码失败计数，但宽松解码仍可写缓存并广播。
+	c := NewUpstreamWebSocketClient("u1", "ws://unused", m)
+	c.onMessage(`{"code":15,"sel_userid":"u8","sel_userAge":20}`)
+
+	got := readDownstreamCode(t, client, 15)
+	if got["sel_userid"] != "u8" {
+		t.Fatalf("unexpected frame: %v", got)
+	}
+	if n := m.GetConnectionStats()["protocolErrors"].(int64); n != 1 {
+		t.Fatalf("protocolErrors=%d, want 1", n)
+	}
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	if len(cache.userInfos) != 1 || cache.userInfos[0].Age != "20" {
+		t.Fatalf("userInfos=%+v", cache.userInfos)
+	}
+}
@@ -991,6 +991,7 @@ func (a *App) handleUploadMedia(w http.ResponseWriter, r *http.Request) {
 	posterURL := ""
 	if strings.HasPrefix(strings.ToLower(strings.TrimSpace(contentType)), "video/") && a.fileStorage != nil {
 		posterLocalPath, posterURL = a.fileStorage.EnsureVideoPosterLogged(r.Context(), a.cfg.FFmpegPath, a.cfg.FFprobePath, localPath, false)
+		posterURL = a.signMediaURL(posterURL)
 	}
 
 	imgServerHost := a.imageServer.GetImgServerHost()
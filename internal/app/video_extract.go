@@ -246,6 +246,8 @@ type VideoExtractService struct {
 	cfg       config.Config
 	fileStore *FileStorageService
 	mtPhoto   mtPhotoFilePathResolver
+	// urlSigner 非空时为返回的 /upload 地址追加签名。
+	urlSigner *MediaURLSigner
 
 	queue    chan string
 	closing  chan struct{}
@@ -1647,6 +1649,14 @@ func (s *VideoExtractService) GetTaskDetail(ctx context.Context, taskID string,
 	return task, frames, nil
 }
 
+// SetURLSigner 设置抽帧任务返回的 /upload 地址使用的签名器。
+func (s *VideoExtractService) SetURLSigner(signer *MediaURLSigner) {
+	if s == nil {
+		return
+	}
+	s.urlSigner = signer
+}
+
 func (s *VideoExtractService) toUploadURL(localPath string, hostHeader string) string {
 	localPath = strings.TrimSpace(localPath)
 	if localPath == "" {
@@ -1659,5 +1669,5 @@ func (s *VideoExtractService) toUploadURL(localPath string, hostHeader string) s
 	if host == "" {
 		host = fmt.Sprintf("localhost:%d", s.cfg.ServerPort)
 	}
-	return "http://" + host + "/upload" + localPath
+	return s.urlSigner.SignURL("http://" + host + "/upload" + localPath)
 }
@@ -41,7 +41,7 @@ func (a *App) handleUploadVideoExtractInput(w http.ResponseWriter, r *http.Reque
 	hostHeader := requestHostHeader(r)
 	url := ""
 	if strings.TrimSpace(hostHeader) != "" {
-		url = "http://" + strings.TrimSpace(hostHeader) + "/upload" + localPath
+		url = a.signMediaURL("http://" + strings.TrimSpace(hostHeader) + "/upload" + localPath)
 	}
 
 	writeJSON(w, http.StatusOK, map[string]any{
@@ -0,0 +1,574 @@
+package app
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// 集群消息类型：frame 为 owner 广播给各副本下游的帧，send/sign 为非 owner 副本转发给 owner 的上游消息。
+const (
+	wsClusterFrame = "frame"
+	wsClusterSend  = "send"
+	wsClusterSign  = "sign"
+)
+
+// 仅当租约仍属于本副本时续期/释放，避免误删其他副本已接管的租约。
+var (
+	wsClusterRenewScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0`)
+	wsClusterReleaseScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0`)
+)
+
+type wsClusterEnvelope struct {
+	Type     string `json:"type"`
+	Origin   string `json:"origin"`
+	UserID   string `json:"userId"`
+	Frame    string `json:"frame"`
+	Seq      int64  `json:"seq,omitempty"`
+	Epoch    string `json:"epoch,omitempty"`
+	OutboxID int64  `json:"outboxId,omitempty"`
+}
+
+// WSCluster 基于 Redis 协调多个副本的上游连接：
+//
+// Key / 频道:
+// - {prefix}owner:{userId} -> 持有该身份上游连接的副本 ID（带 TTL 的租约，owner 定期续期）
+// - {prefix}interest:{userId} -> ZSET（member=副本 ID，score=过期毫秒时间戳），记录哪些副本上有该身份的下游会话
+// - {prefix}fanout -> 所有副本订阅，owner 发布下游帧
+// - {prefix}replica:{replicaId} -> 单个副本订阅，接收转发来的 sign/send
+//
+// Redis 不可用时各方法退化为单机行为（视为本副本持有租约），只记录告警日志。
+type WSCluster struct {
+	client    *redis.Client
+	replicaID string
+	keyPrefix string
+	leaseTTL  time.Duration
+	timeout   time.Duration
+
+	// maintainInterval 为续租、刷新会话归属与接管检查的周期，默认租约时长的 1/3。
+	maintainInterval time.Duration
+
+	manager   *UpstreamWebSocketManager
+	pubsub    *redis.PubSub
+	stopCh    chan struct{}
+	wg        sync.WaitGroup
+	startOnce sync.Once
+	closeOnce sync.Once
+
+	published atomic.Int64
+	received  atomic.Int64
+	forwarded atomic.Int64
+	takeovers atomic.Int64
+}
+
+func NewWSCluster(
+	redisURL string,
+	host string,
+	port int,
+	password string,
+	db int,
+	replicaID string,
+	keyPrefix string,
+	leaseSeconds int,
+	timeoutSeconds int,
+) (*WSCluster, error) {
+	if strings.TrimSpace(keyPrefix) == "" {
+		keyPrefix = "liao:ws:"
+	}
+	if leaseSeconds <= 0 {
+		leaseSeconds = 30
+	}
+	replicaID = strings.TrimSpace(replicaID)
+	if replicaID == "" {
+		replicaID = generateWSReplicaID()
+	}
+
+	timeout := time.Duration(timeoutSeconds) * time.Second
+	if timeout <= 0 {
+		timeout = 15 * time.Second
+	}
+
+	opts, err := buildRedisOptions(redisURL, host, port, password, db, timeout)
+	if err != nil {
+		return nil, err
+	}
+	client := redis.NewClient(opts)
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		_ = client.Close()
+		return nil, fmt.Errorf("连接 Redis 失败: %w", err)
+	}
+
+	leaseTTL := time.Duration(leaseSeconds) * time.Second
+	return &WSCluster{
+		client:           client,
+		replicaID:        replicaID,
+		keyPrefix:        keyPrefix,
+		leaseTTL:         leaseTTL,
+		timeout:          timeout,
+		maintainInterval: leaseTTL / 3,
+		stopCh:           make(chan struct{}),
+	}, nil
+}
+
+func generateWSReplicaID() string {
+	host, _ := os.Hostname()
+	if strings.TrimSpace(host) == "" {
+		host = "liao"
+	}
+	b := make([]byte, 4)
+	_, _ = rand.Read(b)
+	return host + "-" + hex.EncodeToString(b)
+}
+
+// ReplicaID 返回本副本标识。
+func (c *WSCluster) ReplicaID() string {
+	if c == nil {
+		return ""
+	}
+	return c.replicaID
+}
+
+func (c *WSCluster) leaseKey(userID string) string    { return c.keyPrefix + "owner:" + userID }
+func (c *WSCluster) interestKey(userID string) string { return c.keyPrefix + "interest:" + userID }
+func (c *WSCluster) fanoutChannel() string            { return c.keyPrefix + "fanout" }
+func (c *WSCluster) replicaChannel(replicaID string) string {
+	return c.keyPrefix + "replica:" + replicaID
+}
+
+func (c *WSCluster) ctx() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), c.timeout)
+}
+
+// acquire 尝试为本副本取得身份租约；返回 owner 与是否由本副本持有。Redis 出错时按本副本持有处理。
+func (c *WSCluster) acquire(userID string) (string, bool) {
+	if c == nil {
+		return "", true
+	}
+	ctx, cancel := c.ctx()
+	defer cancel()
+
+	ok, err := c.client.SetNX(ctx, c.leaseKey(userID), c.replicaID, c.leaseTTL).Result()
+	if err != nil {
+		slog.Warn("获取身份租约失败，按单机处理", "userID", userID, "error", err)
+		return c.replicaID, true
+	}
+	if ok {
+		return c.replicaID, true
+	}
+	owner, err := c.client.Get(ctx, c.leaseKey(userID)).Result()
+	if err == redis.Nil {
+		// 租约恰好过期，下次调用再抢占。
+		return "", false
+	}
+	if err != nil {
+		slog.Warn("读取身份租约失败，按单机处理", "userID", userID, "error", err)
+		return c.replicaID, true
+	}
+	if owner == c.replicaID {
+		c.renew(userID)
+		return owner, true
+	}
+	return owner, false
+}
+
+// remoteOwner 返回持有身份租约的其他副本；无人持有、由本副本持有或 Redis 出错时返回空。
+func (c *WSCluster) remoteOwner(userID string) string {
+	if c == nil {
+		return ""
+	}
+	ctx, cancel := c.ctx()
+	defer cancel()
+	owner, err := c.client.Get(ctx, c.leaseKey(userID)).Result()
+	if err != nil && err != redis.Nil {
+		slog.Warn("读取身份租约失败", "userID", userID, "error", err)
+		return ""
+	}
+	if owner == c.replicaID {
+		return ""
+	}
+	return owner
+}
+
+func (c *WSCluster) renew(userID string) bool {
+	if c == nil {
+		return true
+	}
+	ctx, cancel := c.ctx()
+	defer cancel()
+	n, err := wsClusterRenewScript.Run(ctx, c.client, []string{c.leaseKey(userID)}, c.replicaID, c.leaseTTL.Milliseconds()).Int64()
+	if err != nil {
+		slog.Warn("续期身份租约失败", "userID", userID, "error", err)
+		return true
+	}
+	return n == 1
+}
+
+func (c *WSCluster) release(userID string) {
+	if c == nil {
+		return
+	}
+	ctx, cancel := c.ctx()
+	defer cancel()
+	if err := wsClusterReleaseScript.Run(ctx, c.client, []string{c.leaseKey(userID)}, c.replicaID).Err(); err != nil {
+		slog.Warn("释放身份租约失败", "userID", userID, "error", err)
+	}
+}
+
+// setInterest 登记/注销本副本上存在该身份的下游会话；登记在租约时长后自动过期，由维护循环刷新。
+func (c *WSCluster) setInterest(userID string, active bool) {
+	if c == nil {
+		return
+	}
+	ctx, cancel := c.ctx()
+	defer cancel()
+	key := c.interestKey(userID)
+	var err error
+	if active {
+		_, err = c.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.ZAdd(ctx, key, redis.Z{Score: float64(time.Now().Add(c.leaseTTL).UnixMilli()), Member: c.replicaID})
+			pipe.PExpire(ctx, key, c.leaseTTL)
+			return nil
+		})
+	} else {
+		err = c.client.ZRem(ctx, key, c.replicaID).Err()
+	}
+	if err != nil {
+		slog.Warn("更新身份会话归属失败", "userID", userID, "active", active, "error", err)
+	}
+}
+
+// hasRemoteInterest 返回其他副本上是否仍有该身份的下游会话。
+func (c *WSCluster) hasRemoteInterest(userID string) bool {
+	if c == nil {
+		return false
+	}
+	ctx, cancel := c.ctx()
+	defer cancel()
+	members, err := c.client.ZRangeByScore(ctx, c.interestKey(userID), &redis.ZRangeBy{
+		Min: strconv.FormatInt(time.Now().UnixMilli(), 10),
+		Max: "+inf",
+	}).Result()
+	if err != nil {
+		slog.Warn("读取身份会话归属失败", "userID", userID, "error", err)
+		return false
+	}
+	for _, member := range members {
+		if member != c.replicaID {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *WSCluster) publish(ctx context.Context, channel string, env wsClusterEnvelope) (int64, error) {
+	env.Origin = c.replicaID
+	payload, err := json.Marshal(env)
+	if err != nil {
+		return 0, err
+	}
+	return c.client.Publish(ctx, channel, payload).Result()
+}
+
+// publishFrame 把广播给下游的帧发布给其他副本；seq 为上游帧序号（本地状态帧为 0），epoch 为 owner 的续传纪元。
+func (c *WSCluster) publishFrame(userID string, frame string, seq int64, epoch string) {
+	if c == nil {
+		return
+	}
+	ctx, cancel := c.ctx()
+	defer cancel()
+	if _, err := c.publish(ctx, c.fanoutChannel(), wsClusterEnvelope{Type: wsClusterFrame, UserID: userID, Frame: frame, Seq: seq, Epoch: epoch}); err != nil {
+		slog.Warn("发布下游帧失败", "userID", userID, "error", err)
+		return
+	}
+	c.published.Add(1)
+}
+
+// forward 把上游消息转发给 owner 副本；owner 未订阅（已宕机）时返回错误。
+func (c *WSCluster) forward(owner string, env wsClusterEnvelope) error {
+	if c == nil {
+		return fmt.Errorf("集群未启用")
+	}
+	ctx, cancel := c.ctx()
+	defer cancel()
+	receivers, err := c.publish(ctx, c.replicaChannel(owner), env)
+	if err != nil {
+		return err
+	}
+	if receivers == 0 {
+		return fmt.Errorf("副本 %s 不在线", owner)
+	}
+	c.forwarded.Add(1)
+	return nil
+}
+
+// start 订阅集群频道并启动维护循环，由 UpstreamWebSocketManager.SetCluster 调用。
+func (c *WSCluster) start(m *UpstreamWebSocketManager) error {
+	var err error
+	c.startOnce.Do(func() {
+		c.manager = m
+		c.pubsub = c.client.Subscribe(context.Background(), c.fanoutChannel(), c.replicaChannel(c.replicaID))
+		ctx, cancel := c.ctx()
+		defer cancel()
+		// 等待订阅确认，保证返回后不会漏掉其他副本的消息。
+		if _, err = c.pubsub.Receive(ctx); err != nil {
+			_ = c.pubsub.Close()
+			c.pubsub = nil
+			err = fmt.Errorf("订阅集群频道失败: %w", err)
+			return
+		}
+		c.wg.Add(2)
+		go c.receiveLoop(c.pubsub.Channel())
+		go c.maintainLoop()
+		slog.Info("WebSocket 集群协调已启用", "replicaID", c.replicaID, "leaseTTL", c.leaseTTL)
+	})
+	return err
+}
+
+func (c *WSCluster) receiveLoop(ch <-chan *redis.Message) {
+	defer c.wg.Done()
+	for msg := range ch {
+		var env wsClusterEnvelope
+		if err := json.Unmarshal([]byte(msg.Payload), &env); err != nil || env.Origin == c.replicaID || env.UserID == "" {
+			continue
+		}
+		c.received.Add(1)
+		switch env.Type {
+		case wsClusterFrame:
+			c.manager.deliverClusterFrame(env.UserID, env.Frame, env.Seq, env.Epoch)
+		case wsClusterSend:
+			c.manager.handleClusterSend(env.UserID, env.Frame, env.OutboxID)
+		case wsClusterSign:
+			c.manager.handleClusterSign(env.UserID, env.Frame)
+		}
+	}
+}
+
+func (c *WSCluster) maintainLoop() {
+	defer c.wg.Done()
+
+	interval := c.maintainInterval
+	if interval <= 0 {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			c.manager.maintainCluster()
+		case <-c.stopCh:
+			return
+		}
+	}
+}
+
+func (c *WSCluster) stats() map[string]any {
+	return map[string]any{
+		"replicaId": c.replicaID,
+		"published": c.published.Load(),
+		"received":  c.received.Load(),
+		"forwarded": c.forwarded.Load(),
+		"takeovers": c.takeovers.Load(),
+	}
+}
+
+// Close 停止订阅与维护循环并关闭 Redis 连接；应在 CloseAllConnections（释放租约）之后调用。
+func (c *WSCluster) Close() error {
+	if c == nil || c.client == nil {
+		return nil
+	}
+	var err error
+	c.closeOnce.Do(func() {
+		close(c.stopCh)
+		if c.pubsub != nil {
+			_ = c.pubsub.Close()
+		}
+		c.wg.Wait()
+		err = c.client.Close()
+	})
+	return err
+}
+
+// SetCluster 启用多副本协调；需在处理任何下游连接之前调用。
+func (m *UpstreamWebSocketManager) SetCluster(cluster *WSCluster) error {
+	if cluster == nil {
+		return nil
+	}
+	if err := cluster.start(m); err != nil {
+		return err
+	}
+	m.cluster = cluster
+	return nil
+}
+
+// Cluster 返回多副本协调器（未启用时为 nil）。
+func (m *UpstreamWebSocketManager) Cluster() *WSCluster {
+	return m.cluster
+}
+
+// forwardToOwner 在身份的上游连接由其他副本持有时把消息转发过去，返回是否已转发。
+func (m *UpstreamWebSocketManager) forwardToOwner(userID string, message string, outboxID int64) bool {
+	owner := m.cluster.remoteOwner(userID)
+	if owner == "" {
+		return false
+	}
+	if err := m.cluster.forward(owner, wsClusterEnvelope{Type: wsClusterSend, UserID: userID, Frame: message, OutboxID: outboxID}); err != nil {
+		slog.Warn("转发上游消息到 owner 副本失败", "userID", userID, "owner", owner, "error", err)
+		return false
+	}
+	return true
+}
+
+// deliverClusterFrame 把 owner 发布的帧写给本副本的下游会话；上游帧按 owner 的纪元与原 seq 写入续传缓冲，
+// owner 换人（纪元变化）时丢弃旧缓冲重建，不同纪元的 seq 不混在同一缓冲里比较。
+func (m *UpstreamWebSocketManager) deliverClusterFrame(userID string, frame string, seq int64, epoch string) {
+	m.mu.Lock()
+	_, hasSessions := m.downstreamSessions[userID]
+	_, hasObservers := m.observerSessions[userID]
+	if hasSessions && seq > 0 {
+		ring := m.replayBuffers[userID]
+		if ring == nil || ring.epoch != epoch {
+			ring = newDownstreamReplayRing(wsReplayBufferSize, epoch, seq-1)
+			m.replayBuffers[userID] = ring
+		}
+		ring.push(seq, frame)
+	}
+	m.mu.Unlock()
+
+	if hasSessions || hasObservers {
+		m.deliverDownstream(userID, frame)
+	}
+}
+
+// handleClusterSign 处理其他副本转发来的 sign：缓存 sign，本副本尚无上游连接时建立连接。
+// 本副本没有该身份的下游会话时照常安排延迟关闭，关闭前会检查其他副本是否仍有会话。
+func (m *UpstreamWebSocketManager) handleClusterSign(userID string, signMessage string) {
+	if m.forceout != nil && m.forceout.IsForbidden(userID) {
+		m.BroadcastToDownstream(userID, buildForceoutRejectMessage(m.forceout.RemainingSeconds(userID)))
+		return
+	}
+
+	m.mu.Lock()
+	if strings.TrimSpace(signMessage) != "" {
+		m.signMessages[userID] = signMessage
+	}
+	_, hasClient := m.upstreamClients[userID]
+	_, reconnecting := m.reconnectTasks[userID]
+	m.mu.Unlock()
+	if hasClient || reconnecting {
+		return
+	}
+	m.ensureClusterUpstream(userID, signMessage)
+}
+
+// handleClusterSend 处理其他副本转发来的上游消息。
+func (m *UpstreamWebSocketManager) handleClusterSend(userID string, message string, outboxID int64) {
+	m.mu.Lock()
+	client := m.upstreamClients[userID]
+	signMessage := m.signMessages[userID]
+	m.mu.Unlock()
+
+	if client == nil {
+		client = m.ensureClusterUpstream(userID, signMessage)
+	}
+	if client == nil {
+		slog.Warn("转发的上游消息无可用连接", "userID", userID)
+		m.markOutboxDelivery(outboxID, fmt.Errorf("无可用上游连接"))
+		return
+	}
+	m.touchIdentity(userID)
+	client.sendTracked(message, outboxID)
+}
+
+func (m *UpstreamWebSocketManager) ensureClusterUpstream(userID string, signMessage string) *UpstreamWebSocketClient {
+	client := m.createUpstreamConnection(userID, signMessage)
+	if client == nil {
+		return nil
+	}
+	m.mu.Lock()
+	if _, hasSessions := m.downstreamSessions[userID]; !hasSessions {
+		if _, pending := m.pendingCloseTasks[userID]; !pending {
+			m.scheduleCloseUpstreamLocked(userID)
+		}
+	}
+	m.mu.Unlock()
+	return client
+}
+
+func (m *UpstreamWebSocketManager) touchIdentity(userID string) {
+	m.mu.Lock()
+	m.touchIdentityLocked(userID)
+	m.mu.Unlock()
+}
+
+// maintainCluster 周期性续期本副本持有的租约、刷新下游会话归属，并接管 owner 已失效的身份。
+func (m *UpstreamWebSocketManager) maintainCluster() {
+	m.mu.Lock()
+	owned := make([]string, 0, len(m.upstreamClients)+len(m.reconnectTasks))
+	for userID := range m.upstreamClients {
+		owned = append(owned, userID)
+	}
+	for userID := range m.reconnectTasks {
+		if _, ok := m.upstreamClients[userID]; !ok {
+			owned = append(owned, userID)
+		}
+	}
+	local := make([]string, 0, len(m.downstreamSessions))
+	orphans := make(map[string]string)
+	for userID := range m.downstreamSessions {
+		local = append(local, userID)
+		_, hasClient := m.upstreamClients[userID]
+		_, reconnecting := m.reconnectTasks[userID]
+		if !hasClient && !reconnecting && strings.TrimSpace(m.signMessages[userID]) != "" {
+			orphans[userID] = m.signMessages[userID]
+		}
+	}
+	m.mu.Unlock()
+
+	for _, userID := range owned {
+		if m.cluster.renew(userID) {
+			continue
+		}
+		if _, ok := m.cluster.acquire(userID); ok {
+			continue
+		}
+		// 租约已被其他副本接管：关闭本地上游连接，避免同一身份出现两条上游连接触发 forceout。
+		slog.Warn("身份租约已被其他副本接管，关闭本地上游连接", "userID", userID)
+		m.closeUpstreamConnection(userID, WSConnectionEventDisconnect, "cluster lease lost")
+	}
+	for _, userID := range local {
+		m.cluster.setInterest(userID, true)
+	}
+	for userID, signMessage := range orphans {
+		if m.cluster.remoteOwner(userID) != "" {
+			continue
+		}
+		if m.createUpstreamConnection(userID, signMessage) != nil {
+			m.cluster.takeovers.Add(1)
+			slog.Info("owner 副本失效，接管身份上游连接", "userID", userID, "replicaID", m.cluster.ReplicaID())
+		}
+	}
+}
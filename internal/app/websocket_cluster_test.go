@@ -0,0 +1,233 @@
+package app
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/alicebob/miniredis/v2"
+
+	"liao/internal/config"
+	"liao/internal/database"
+)
+
+func newTestWSCluster(t *testing.T, mr *miniredis.Miniredis, replicaID string) *WSCluster {
+	t.Helper()
+	c, err := NewWSCluster("redis://"+mr.Addr(), "", 0, "", 0, replicaID, "test:ws:", 30, 5)
+	if err != nil {
+		t.Fatalf("NewWSCluster: %v", err)
+	}
+	c.maintainInterval = 30 * time.Millisecond
+	t.Cleanup(func() { _ = c.Close() })
+	return c
+}
+
+func newClusterManager(t *testing.T, cluster *WSCluster) *UpstreamWebSocketManager {
+	t.Helper()
+	m := NewUpstreamWebSocketManager(&http.Client{Timeout: 2 * time.Second}, "ws://unused", NewForceoutManager(), nil, nil)
+	if err := m.SetCluster(cluster); err != nil {
+		t.Fatalf("SetCluster: %v", err)
+	}
+	t.Cleanup(m.CloseAllConnections)
+	return m
+}
+
+func TestWSCluster_LeaseAndInterest(t *testing.T) {
+	mr := miniredis.RunT(t)
+	a := newTestWSCluster(t, mr, "a")
+	b := newTestWSCluster(t, mr, "b")
+
+	if owner, ok := a.acquire("u1"); !ok || owner != "a" {
+		t.Fatalf("a acquire owner=%q ok=%v", owner, ok)
+	}
+	if owner, ok := b.acquire("u1"); ok || owner != "a" {
+		t.Fatalf("b acquire owner=%q ok=%v", owner, ok)
+	}
+	if b.remoteOwner("u1") != "a" || a.remoteOwner("u1") != "" {
+		t.Fatalf("unexpected remote owner")
+	}
+	if !a.renew("u1") || b.renew("u1") {
+		t.Fatalf("only the owner may renew")
+	}
+
+	// 非 owner 释放不生效；owner 释放后其他副本可以接管。
+	b.release("u1")
+	if b.remoteOwner("u1") != "a" {
+		t.Fatalf("non-owner release should be ignored")
+	}
+	a.release("u1")
+	if _, ok := b.acquire("u1"); !ok || a.remoteOwner("u1") != "b" {
+		t.Fatalf("b should take over after release")
+	}
+
+	// 租约过期后原 owner 续期失败。
+	mr.FastForward(31 * time.Second)
+	if b.renew("u1") {
+		t.Fatalf("renew after expiry should fail")
+	}
+
+	b.setInterest("u1", true)
+	if !a.hasRemoteInterest("u1") || b.hasRemoteInterest("u1") {
+		t.Fatalf("unexpected interest")
+	}
+	b.setInterest("u1", false)
+	if a.hasRemoteInterest("u1") {
+		t.Fatalf("interest should be cleared")
+	}
+
+	if err := a.forward("nobody", wsClusterEnvelope{Type: wsClusterSend, UserID: "u1"}); err == nil {
+		t.Fatalf("expected error when owner is offline")
+	}
+}
+
+func TestWSCluster_NilIsSingleReplica(t *testing.T) {
+	var c *WSCluster
+	if owner, ok := c.acquire("u1"); owner != "" || !ok {
+		t.Fatalf("nil acquire owner=%q ok=%v", owner, ok)
+	}
+	if c.remoteOwner("u1") != "" || c.hasRemoteInterest("u1") || !c.renew("u1") || c.ReplicaID() != "" || c.Close() != nil {
+		t.Fatalf("nil cluster should behave as a single replica")
+	}
+	c.release("u1")
+	c.setInterest("u1", true)
+	c.publishFrame("u1", "x", 1, "e")
+	if err := c.forward("a", wsClusterEnvelope{}); err == nil {
+		t.Fatalf("expected error for nil cluster")
+	}
+
+	m := NewUpstreamWebSocketManager(nil, "ws://unused", nil, nil, nil)
+	if err := m.SetCluster(nil); err != nil || m.Cluster() != nil {
+		t.Fatalf("SetCluster(nil) err=%v", err)
+	}
+	if _, ok := m.GetConnectionStats()["cluster"]; ok {
+		t.Fatalf("stats should not include cluster when disabled")
+	}
+}
+
+func TestUpstreamWebSocketManager_Cluster_SingleUpstreamAcrossReplicas(t *testing.T) {
+	fake := startFakeUpstream(t)
+	mr := miniredis.RunT(t)
+
+	ca := newTestWSCluster(t, mr, "a")
+	cb := newTestWSCluster(t, mr, "b")
+	ma := newClusterManager(t, ca)
+	mb := newClusterManager(t, cb)
+
+	sa, clientA := newDownstreamPair(t)
+	ma.RegisterDownstream("u1", sa, `{"act":"sign","id":"u1","name":"Me"}`)
+	waitFor(t, "owner sign", func() bool { return fake.Connected("u1") })
+
+	// 副本 b 上的同一身份不再建立第二条上游连接，消息转发给 owner。
+	sb, clientB := newDownstreamPair(t)
+	mb.RegisterDownstream("u1", sb, `{"act":"sign","id":"u1","name":"Me"}`)
+	mb.SendToUpstream("u1", `{"act":"random","id":"u1"}`)
+
+	infoA := readDownstreamCode(t, clientA, 15)
+	infoB := readDownstreamCode(t, clientB, 15)
+	if infoA["seq"] == nil || infoA["seq"] != infoB["seq"] {
+		t.Fatalf("seq a=%v b=%v", infoA["seq"], infoB["seq"])
+	}
+	if got := fake.SignCount("u1"); got != 1 {
+		t.Fatalf("sign count=%d, want 1", got)
+	}
+
+	mb.mu.Lock()
+	_, bHasUpstream := mb.upstreamClients["u1"]
+	replay := mb.replayFramesLocked("u1", ma.replayEpoch, 0)
+	mb.mu.Unlock()
+	if bHasUpstream || len(replay.messages) != 1 || replay.truncated || replay.reset || replay.epoch != ma.replayEpoch {
+		t.Fatalf("b upstream=%v replay=%+v", bHasUpstream, replay)
+	}
+	if infoB["epoch"] != ma.replayEpoch {
+		t.Fatalf("frame epoch=%v, want owner epoch %s", infoB["epoch"], ma.replayEpoch)
+	}
+	ownerSeq := int64(toInt(infoB["seq"]))
+
+	// owner 下游全部断开后，因 b 仍有会话而保持上游连接。
+	oldDelay := wsCloseDelay
+	wsCloseDelay = 20 * time.Millisecond
+	t.Cleanup(func() { wsCloseDelay = oldDelay })
+	ma.UnregisterDownstream("u1", sa)
+	time.Sleep(100 * time.Millisecond)
+	if !fake.Connected("u1") {
+		t.Fatalf("owner should keep upstream while other replicas have sessions")
+	}
+
+	stats, _ := ma.GetConnectionStats()["cluster"].(map[string]any)
+	if stats["replicaId"] != "a" || stats["received"].(int64) < 2 {
+		t.Fatalf("cluster stats=%v", stats)
+	}
+
+	// owner 副本下线：释放租约后 b 用缓存的 sign 接管。
+	ma.CloseAllConnections()
+	_ = ca.Close()
+	waitFor(t, "takeover", func() bool { return fake.SignCount("u1") == 2 && fake.Connected("u1") })
+	if cb.remoteOwner("u1") != "" || cb.takeovers.Load() != 1 {
+		t.Fatalf("b should own u1 after takeover")
+	}
+
+	// 接管后 b 以自己的纪元重新编号，seq 可能不大于客户端持有的 lastSeq；
+	// 携带旧纪元续传时必须整体补发并标记 reset，而不是按 lastSeq 过滤掉新帧。
+	mb.SendToUpstream("u1", `{"act":"random","id":"u1"}`)
+	takeover := readDownstreamCode(t, clientB, 15)
+	if takeover["epoch"] != mb.replayEpoch || int64(toInt(takeover["seq"])) > ownerSeq {
+		t.Fatalf("unexpected takeover frame: %v (owner seq=%d)", takeover, ownerSeq)
+	}
+	resumeSession, resumeClient := newDownstreamPair(t)
+	mb.ResumeDownstream("u1", resumeSession, `{"act":"sign","id":"u1","name":"Me"}`, ma.replayEpoch, ownerSeq)
+	replayed := readDownstreamCode(t, resumeClient, 15)
+	if replayed["epoch"] != mb.replayEpoch {
+		t.Fatalf("unexpected replay frame: %v", replayed)
+	}
+	resumed := readDownstreamCode(t, resumeClient, -9)
+	if toInt(resumed["replayed"]) != 1 || !toBool(resumed["reset"]) || !toBool(resumed["truncated"]) || resumed["epoch"] != mb.replayEpoch {
+		t.Fatalf("unexpected resume frame: %v", resumed)
+	}
+}
+
+func TestNew_WSClusterEnabled(t *testing.T) {
+	oldOpen := openDBFn
+	oldEnsure := ensureSchemaFn
+	oldStatic := resolveStaticDirFn
+	oldMkdirAll := mkdirAllFn
+	t.Cleanup(func() {
+		openDBFn = oldOpen
+		ensureSchemaFn = oldEnsure
+		resolveStaticDirFn = oldStatic
+		mkdirAllFn = oldMkdirAll
+	})
+
+	useMockDB := func() {
+		db, mock, cleanup := newSQLMock(t)
+		t.Cleanup(cleanup)
+		for i := 0; i < 4; i++ {
+			mock.ExpectExec(`INSERT (IGNORE )?INTO system_config`).WillReturnResult(sqlmock.NewResult(1, 1))
+		}
+		openDBFn = func(cfg config.Config) (*database.DB, error) { return database.Wrap(db, database.MySQLDialect{}), nil }
+	}
+	ensureSchemaFn = func(db *database.DB) error { return nil }
+	resolveStaticDirFn = func() string { return "static" }
+	mkdirAllFn = func(path string, perm os.FileMode) error { return nil }
+
+	useMockDB()
+	mr := miniredis.RunT(t)
+	a, err := New(config.Config{JWTSecret: "s", CacheType: "memory", WSClusterEnabled: true, RedisURL: "redis://" + mr.Addr(), WSClusterReplicaID: "r1", WSClusterLeaseSeconds: 30})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	if a.wsManager.Cluster().ReplicaID() != "r1" {
+		t.Fatalf("cluster not configured")
+	}
+	a.Shutdown(context.Background())
+
+	addr := mr.Addr()
+	mr.Close()
+	useMockDB()
+	if _, err := New(config.Config{JWTSecret: "s", CacheType: "memory", WSClusterEnabled: true, RedisURL: "redis://" + addr, RedisTimeoutSeconds: 1}); err == nil || !strings.Contains(err.Error(), "Redis") {
+		t.Fatalf("err=%v, want redis error", err)
+	}
+}
@@ -0,0 +1,238 @@
+package app
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"liao/internal/config"
+)
+
+const (
+	// WSSubprotocol 为通过 Sec-WebSocket-Protocol 传递 token 时服务端回应的子协议名。
+	WSSubprotocol = "liao"
+	// wsSubprotocolTokenPrefix 为携带 JWT 的子协议前缀：客户端发送 ["liao", "bearer.<jwt>"]。
+	wsSubprotocolTokenPrefix = "bearer."
+
+	// WSCloseTooManySessions 为同一 token 并发会话数超限时的关闭码。
+	WSCloseTooManySessions = 4409
+	// WSCloseRateLimited 为下游发帧速率超限时的关闭码。
+	WSCloseRateLimited = 4429
+	// WSCloseTokenRevoked 为登录会话被吊销（登出、管理员吊销、账号变更）时的关闭码。
+	WSCloseTokenRevoked = 4401
+)
+
+// WSGuardConfig 为 /ws 入口的防滥用配置；零值表示不做任何限制（与旧行为一致）。
+type WSGuardConfig struct {
+	// AllowedOrigins 为允许的 Origin（scheme://host[:port]），为空或包含 "*" 时不校验；同源请求始终放行。
+	AllowedOrigins []string
+	// MaxSessionsPerToken 为同一 token 同时保持的下游会话上限，<=0 表示不限制。
+	MaxSessionsPerToken int
+	// RateLimit 为单个会话每秒允许的入站帧数，<=0 表示不限速；RateBurst 为令牌桶容量。
+	RateLimit float64
+	RateBurst int
+}
+
+// WSGuardConfigFromConfig 从应用配置构造 WSGuardConfig。
+func WSGuardConfigFromConfig(cfg config.Config) WSGuardConfig {
+	return WSGuardConfig{
+		AllowedOrigins:      cfg.WSAllowedOrigins,
+		MaxSessionsPerToken: cfg.WSMaxSessionsPerToken,
+		RateLimit:           cfg.WSRateLimitPerSecond,
+		RateBurst:           cfg.WSRateLimitBurst,
+	}
+}
+
+// WSGuard 负责 /ws 握手前的 Origin 校验、按 token 的并发会话计数以及会话级令牌桶限速。
+type WSGuard struct {
+	anyOrigin bool
+	origins   map[string]struct{}
+	maxPer    int
+	rate      float64
+	burst     int
+
+	mu       sync.Mutex
+	sessions map[string]int
+
+	rejectedOrigins  atomic.Int64
+	rejectedSessions atomic.Int64
+	rateLimited      atomic.Int64
+}
+
+func NewWSGuard(cfg WSGuardConfig) *WSGuard {
+	g := &WSGuard{
+		origins:  make(map[string]struct{}),
+		maxPer:   cfg.MaxSessionsPerToken,
+		rate:     cfg.RateLimit,
+		burst:    cfg.RateBurst,
+		sessions: make(map[string]int),
+	}
+	for _, origin := range cfg.AllowedOrigins {
+		origin = strings.TrimSpace(origin)
+		if origin == "*" {
+			g.anyOrigin = true
+			continue
+		}
+		if normalized := normalizeWSOrigin(origin); normalized != "" {
+			g.origins[normalized] = struct{}{}
+		}
+	}
+	if len(g.origins) == 0 {
+		g.anyOrigin = true
+	}
+	if g.rate > 0 && g.burst <= 0 {
+		g.burst = max(int(g.rate), 1)
+	}
+	return g
+}
+
+func normalizeWSOrigin(origin string) string {
+	u, err := url.Parse(strings.TrimSpace(origin))
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return ""
+	}
+	return strings.ToLower(u.Scheme + "://" + u.Host)
+}
+
+// CheckOrigin 判断请求的 Origin 是否允许：未配置白名单、未携带 Origin（非浏览器客户端）或同源时放行。
+func (g *WSGuard) CheckOrigin(r *http.Request) bool {
+	if g == nil || g.anyOrigin {
+		return true
+	}
+	origin := strings.TrimSpace(r.Header.Get("Origin"))
+	if origin == "" {
+		return true
+	}
+	normalized := normalizeWSOrigin(origin)
+	if _, ok := g.origins[normalized]; ok {
+		return true
+	}
+	if u, err := url.Parse(origin); err == nil && strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	g.rejectedOrigins.Add(1)
+	return false
+}
+
+// acquire 为 token 占用一个会话名额，超出上限时返回 false；成功时调用方需在会话结束后 release。
+func (g *WSGuard) acquire(token string) bool {
+	if g == nil || g.maxPer <= 0 {
+		return true
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.sessions[token] >= g.maxPer {
+		g.rejectedSessions.Add(1)
+		return false
+	}
+	g.sessions[token]++
+	return true
+}
+
+func (g *WSGuard) release(token string) {
+	if g == nil || g.maxPer <= 0 {
+		return
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.sessions[token] <= 1 {
+		delete(g.sessions, token)
+		return
+	}
+	g.sessions[token]--
+}
+
+// newLimiter 为一个会话创建入站帧令牌桶，未启用限速时返回 nil。
+func (g *WSGuard) newLimiter() *wsTokenBucket {
+	if g == nil || g.rate <= 0 {
+		return nil
+	}
+	return newWSTokenBucket(g.rate, g.burst)
+}
+
+func (g *WSGuard) stats() map[string]any {
+	g.mu.Lock()
+	sessions := 0
+	for _, n := range g.sessions {
+		sessions += n
+	}
+	tokens := len(g.sessions)
+	g.mu.Unlock()
+	return map[string]any{
+		"originCheck":         !g.anyOrigin,
+		"maxSessionsPerToken": g.maxPer,
+		"rateLimit":           g.rate,
+		"rateBurst":           g.burst,
+		"trackedTokens":       tokens,
+		"trackedSessions":     sessions,
+		"rejectedOrigins":     g.rejectedOrigins.Load(),
+		"rejectedSessions":    g.rejectedSessions.Load(),
+		"rateLimited":         g.rateLimited.Load(),
+	}
+}
+
+// wsRequestToken 从查询参数 token 或 Sec-WebSocket-Protocol 中的 "bearer.<jwt>" 取出 JWT；
+// 使用子协议传递时返回需要回应的子协议（浏览器要求服务端选中客户端提供的某一项）。
+// 子协议传递 token 时客户端必须同时提供 "liao"，否则视为未携带 token：回应头只能选 "liao"，绝不回显 token。
+func wsRequestToken(r *http.Request) (token string, subprotocol string) {
+	if token = strings.TrimSpace(r.URL.Query().Get("token")); token != "" {
+		return token, ""
+	}
+	protocols := websocket.Subprotocols(r)
+	for _, p := range protocols {
+		if strings.HasPrefix(p, wsSubprotocolTokenPrefix) {
+			token = strings.TrimSpace(strings.TrimPrefix(p, wsSubprotocolTokenPrefix))
+			break
+		}
+	}
+	if token == "" {
+		return "", ""
+	}
+	for _, p := range protocols {
+		if p == WSSubprotocol {
+			return token, WSSubprotocol
+		}
+	}
+	return "", ""
+}
+
+// wsTokenBucket 为单个会话的入站帧令牌桶，仅由该会话的读循环访问，无需加锁。
+type wsTokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newWSTokenBucket(rate float64, burst int) *wsTokenBucket {
+	return &wsTokenBucket{rate: rate, burst: float64(burst), tokens: float64(burst), last: time.Now()}
+}
+
+func (b *wsTokenBucket) allow(now time.Time) bool {
+	if b == nil {
+		return true
+	}
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens += elapsed * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+		b.last = now
+	}
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// closeWSWithCode 发送带关闭码的 close 帧后断开连接。
+func closeWSWithCode(conn *websocket.Conn, code int, reason string) {
+	_ = conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), time.Now().Add(wsDownstreamWriteDeadline))
+	_ = conn.Close()
+}
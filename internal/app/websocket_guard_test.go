@@ -0,0 +1,164 @@
+package app
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestWSGuard_CheckOrigin(t *testing.T) {
+	var nilGuard *WSGuard
+	if !nilGuard.CheckOrigin(httptest.NewRequest(http.MethodGet, "/ws", nil)) {
+		t.Fatalf("nil guard should allow")
+	}
+	if g := NewWSGuard(WSGuardConfig{AllowedOrigins: []string{"https://a.example", "*"}}); !g.anyOrigin {
+		t.Fatalf("* should disable origin check")
+	}
+
+	g := NewWSGuard(WSGuardConfig{AllowedOrigins: []string{"HTTPS://Chat.Example.com", "bad"}})
+	cases := []struct {
+		origin string
+		host   string
+		want   bool
+	}{
+		{"", "svc.internal", true},
+		{"https://chat.example.com", "svc.internal", true},
+		{"http://chat.example.com", "svc.internal", false},
+		{"https://evil.example", "svc.internal", false},
+		{"http://svc.internal:8080", "svc.internal:8080", true},
+	}
+	for _, tc := range cases {
+		r := httptest.NewRequest(http.MethodGet, "http://"+tc.host+"/ws", nil)
+		if tc.origin != "" {
+			r.Header.Set("Origin", tc.origin)
+		}
+		if got := g.CheckOrigin(r); got != tc.want {
+			t.Fatalf("origin=%q host=%q got=%v", tc.origin, tc.host, got)
+		}
+	}
+	if got := g.stats()["rejectedOrigins"]; got != int64(2) {
+		t.Fatalf("rejectedOrigins=%v", got)
+	}
+}
+
+func TestWSTokenBucket_Allow(t *testing.T) {
+	var nilBucket *wsTokenBucket
+	if !nilBucket.allow(time.Now()) {
+		t.Fatalf("nil bucket should allow")
+	}
+	b := newWSTokenBucket(2, 2)
+	now := b.last
+	if !b.allow(now) || !b.allow(now) || b.allow(now) {
+		t.Fatalf("burst mismatch")
+	}
+	if !b.allow(now.Add(500*time.Millisecond)) || b.allow(now.Add(500*time.Millisecond)) {
+		t.Fatalf("refill mismatch")
+	}
+	if !b.allow(now.Add(time.Hour)) || !b.allow(now.Add(time.Hour)) || b.allow(now.Add(time.Hour)) {
+		t.Fatalf("refill should cap at burst")
+	}
+	if g := NewWSGuard(WSGuardConfig{RateLimit: 0.5}); g.burst != 1 || g.newLimiter() == nil {
+		t.Fatalf("burst=%d", g.burst)
+	}
+}
+
+func TestWSRequestToken(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/ws?token=+q1+", nil)
+	r.Header.Set("Sec-WebSocket-Protocol", "liao, bearer.p1")
+	if token, sub := wsRequestToken(r); token != "q1" || sub != "" {
+		t.Fatalf("token=%q sub=%q", token, sub)
+	}
+
+	r = httptest.NewRequest(http.MethodGet, "/ws", nil)
+	r.Header.Set("Sec-WebSocket-Protocol", "liao, bearer.p1")
+	if token, sub := wsRequestToken(r); token != "p1" || sub != WSSubprotocol {
+		t.Fatalf("token=%q sub=%q", token, sub)
+	}
+	r.Header.Set("Sec-WebSocket-Protocol", "bearer.p2")
+	// 未同时提供 liao 子协议：拒绝，不能把 token 回显到响应头。
+	if token, sub := wsRequestToken(r); token != "" || sub != "" {
+		t.Fatalf("token=%q sub=%q", token, sub)
+	}
+	r.Header.Set("Sec-WebSocket-Protocol", "liao")
+	if token, sub := wsRequestToken(r); token != "" || sub != "" {
+		t.Fatalf("token=%q sub=%q", token, sub)
+	}
+}
+
+func TestHandleWebSocket_Guard(t *testing.T) {
+	jwtService := NewJWTService("secret-1", 1)
+	token, err := jwtService.GenerateToken()
+	if err != nil {
+		t.Fatalf("GenerateToken failed: %v", err)
+	}
+	app := &App{jwt: jwtService, wsGuard: NewWSGuard(WSGuardConfig{
+		AllowedOrigins:      []string{"https://chat.example.com"},
+		MaxSessionsPerToken: 1,
+		RateLimit:           1,
+		RateBurst:           2,
+	})}
+	backend := httptest.NewServer(http.HandlerFunc(app.handleWebSocket))
+	t.Cleanup(backend.Close)
+	wsURL := toWSURL(backend.URL) + "/ws"
+
+	header := http.Header{"Origin": {"https://evil.example"}}
+	if _, resp, err := websocket.DefaultDialer.Dial(wsURL+"?token="+url.QueryEscape(token), header); err == nil || resp == nil || resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("expected 403, err=%v resp=%v", err, resp)
+	}
+
+	header = http.Header{"Origin": {"https://chat.example.com"}}
+	bare := websocket.Dialer{Subprotocols: []string{"bearer." + token}}
+	if _, resp, err := bare.Dial(wsURL, header); err == nil || resp == nil || resp.StatusCode != http.StatusUnauthorized || strings.Contains(resp.Header.Get("Sec-WebSocket-Protocol"), token) {
+		t.Fatalf("expected 401 without liao subprotocol, err=%v resp=%v", err, resp)
+	}
+
+	dialer := websocket.Dialer{Subprotocols: []string{WSSubprotocol, "bearer." + token}}
+	first, _, err := dialer.Dial(wsURL, header)
+	if err != nil {
+		t.Fatalf("dial with subprotocol failed: %v", err)
+	}
+	t.Cleanup(func() { _ = first.Close() })
+	if first.Subprotocol() != WSSubprotocol {
+		t.Fatalf("subprotocol=%q", first.Subprotocol())
+	}
+
+	expectClose := func(conn *websocket.Conn, code int) {
+		t.Helper()
+		_ = conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+		for {
+			_, _, err := conn.ReadMessage()
+			if err == nil {
+				continue
+			}
+			var closeErr *websocket.CloseError
+			if !errors.As(err, &closeErr) || closeErr.Code != code {
+				t.Fatalf("want close code %d, got %v", code, err)
+			}
+			return
+		}
+	}
+
+	second, _, err := websocket.DefaultDialer.Dial(wsURL+"?token="+url.QueryEscape(token), nil)
+	if err != nil {
+		t.Fatalf("dial second failed: %v", err)
+	}
+	t.Cleanup(func() { _ = second.Close() })
+	expectClose(second, WSCloseTooManySessions)
+
+	for i := 0; i < 3; i++ {
+		_ = first.WriteMessage(websocket.TextMessage, []byte(`{"act":"random","id":"u1"}`))
+	}
+	expectClose(first, WSCloseRateLimited)
+
+	stats := app.wsGuard.stats()
+	if stats["rejectedOrigins"] != int64(1) || stats["rejectedSessions"] != int64(1) || stats["rateLimited"] != int64(1) {
+		t.Fatalf("stats=%v", stats)
+	}
+	waitFor(t, "session released", func() bool { return app.wsGuard.stats()["trackedSessions"] == 0 })
+}
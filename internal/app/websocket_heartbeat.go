@@ -0,0 +1,114 @@
+package app
+
+import (
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+const wsHeartbeatDefaultMaxMissed = 3
+
+// WSHeartbeatConfig 为上下游 WebSocket 心跳配置；间隔 <=0 表示该方向不主动 ping。
+type WSHeartbeatConfig struct {
+	// UpstreamInterval 为向上游发送 ping 的间隔；默认关闭（部分上游节点收到 ping 会 reset 连接）。
+	UpstreamInterval time.Duration
+	// DownstreamInterval 为向下游浏览器发送 ping 的间隔。
+	DownstreamInterval time.Duration
+	// MaxMissed 为判定对端失活前允许错过的心跳次数：超过 interval*MaxMissed 未收到任何帧（含 pong）即关闭连接。
+	MaxMissed int
+}
+
+func (c WSHeartbeatConfig) normalized() WSHeartbeatConfig {
+	if c.MaxMissed <= 0 {
+		c.MaxMissed = wsHeartbeatDefaultMaxMissed
+	}
+	return c
+}
+
+// WSHeartbeatSnapshot 为单个连接的心跳与延迟状态；时间戳为毫秒，未测得 RTT 时为 -1。
+type WSHeartbeatSnapshot struct {
+	RTTMs          float64 `json:"rttMs"`
+	LastActivityAt int64   `json:"lastActivityAt"`
+	LastPongAt     int64   `json:"lastPongAt,omitempty"`
+	PingsSent      int64   `json:"pingsSent"`
+}
+
+// wsHeartbeat 记录一个 WebSocket 连接最近的活动时间与 ping/pong 往返延迟，可并发访问。
+type wsHeartbeat struct {
+	lastActivity atomic.Int64
+	lastPong     atomic.Int64
+	rttNanos     atomic.Int64
+	pingsSent    atomic.Int64
+}
+
+func newWSHeartbeat() *wsHeartbeat {
+	h := &wsHeartbeat{}
+	h.rttNanos.Store(-1)
+	h.touch()
+	return h
+}
+
+// touch 记录收到对端任意帧。
+func (h *wsHeartbeat) touch() {
+	h.lastActivity.Store(time.Now().UnixMilli())
+}
+
+// pingPayload 以发送时刻（纳秒）作为 ping 负载，对端原样回 pong 时据此计算 RTT。
+func (h *wsHeartbeat) pingPayload() []byte {
+	h.pingsSent.Add(1)
+	return strconv.AppendInt(nil, time.Now().UnixNano(), 10)
+}
+
+func (h *wsHeartbeat) onPong(appData string) {
+	now := time.Now()
+	h.touch()
+	h.lastPong.Store(now.UnixMilli())
+	if sent, err := strconv.ParseInt(appData, 10, 64); err == nil && sent > 0 && sent <= now.UnixNano() {
+		h.rttNanos.Store(now.UnixNano() - sent)
+	}
+}
+
+func (h *wsHeartbeat) idle(now time.Time) time.Duration {
+	return now.Sub(time.UnixMilli(h.lastActivity.Load()))
+}
+
+func (h *wsHeartbeat) snapshot() WSHeartbeatSnapshot {
+	s := WSHeartbeatSnapshot{
+		RTTMs:          -1,
+		LastActivityAt: h.lastActivity.Load(),
+		LastPongAt:     h.lastPong.Load(),
+		PingsSent:      h.pingsSent.Load(),
+	}
+	if rtt := h.rttNanos.Load(); rtt >= 0 {
+		s.RTTMs = float64(rtt) / float64(time.Millisecond)
+	}
+	return s
+}
+
+// runWSPingLoop 每 interval 发送一次 ping，直到 stop 关闭或 ping 写入失败（连接已断开，由读循环收尾）。
+// 超过 interval*maxMissed 未收到对端任何帧时调用 onDead 并退出。
+func runWSPingLoop(h *wsHeartbeat, interval time.Duration, maxMissed int, stop <-chan struct{}, ping func(payload []byte) error, onDead func(idle time.Duration)) {
+	if interval <= 0 || h == nil {
+		return
+	}
+	if maxMissed <= 0 {
+		maxMissed = wsHeartbeatDefaultMaxMissed
+	}
+	timeout := interval * time.Duration(maxMissed)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			if idle := h.idle(now); idle > timeout {
+				onDead(idle)
+				return
+			}
+			if err := ping(h.pingPayload()); err != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func heartbeatStats(m *UpstreamWebSocketManager) map[string]any {
+	return m.GetConnectionStats()["heartbeat"].(map[string]any)
+}
+
+func heartbeatIdentity(m *UpstreamWebSocketManager, userID string) map[string]any {
+	identity, _ := heartbeatStats(m)["identities"].(map[string]any)[userID].(map[string]any)
+	return identity
+}
+
+func TestWSHeartbeat_RTTAndSnapshot(t *testing.T) {
+	h := newWSHeartbeat()
+	if s := h.snapshot(); s.RTTMs != -1 || s.LastActivityAt <= 0 || s.LastPongAt != 0 {
+		t.Fatalf("snapshot=%+v", s)
+	}
+
+	payload := h.pingPayload()
+	time.Sleep(2 * time.Millisecond)
+	h.onPong(string(payload))
+	s := h.snapshot()
+	if s.RTTMs < 1 || s.LastPongAt <= 0 || s.PingsSent != 1 {
+		t.Fatalf("snapshot=%+v", s)
+	}
+	// 对端返回的非本端负载只刷新活动时间，不覆盖 RTT。
+	h.onPong("not-a-timestamp")
+	if got := h.snapshot().RTTMs; got != s.RTTMs {
+		t.Fatalf("rtt=%v, want %v", got, s.RTTMs)
+	}
+}
+
+func TestRunWSPingLoop_DeadPeerAndStop(t *testing.T) {
+	h := newWSHeartbeat()
+	var pings atomic.Int64
+	dead := make(chan time.Duration, 1)
+	go runWSPingLoop(h, 10*time.Millisecond, 2, make(chan struct{}), func([]byte) error {
+		pings.Add(1)
+		return nil
+	}, func(idle time.Duration) { dead <- idle })
+	select {
+	case idle := <-dead:
+		if idle <= 20*time.Millisecond || pings.Load() == 0 {
+			t.Fatalf("idle=%v pings=%d", idle, pings.Load())
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("timeout waiting dead peer")
+	}
+
+	stop := make(chan struct{})
+	exited := make(chan struct{})
+	go func() {
+		runWSPingLoop(newWSHeartbeat(), time.Hour, 1, stop, nil, nil)
+		close(exited)
+	}()
+	close(stop)
+	select {
+	case <-exited:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("ping loop did not stop")
+	}
+}
+
+func TestUpstreamHeartbeat_RTTAndDeadPeer(t *testing.T) {
+	setFastReconnect(t, 1)
+	var silent atomic.Bool
+	release := make(chan struct{})
+	tracker := &wsConnTracker{}
+	upstream := newUpstreamWSServer(t, func(conn *websocket.Conn) {
+		tracker.add(conn)
+		if silent.Load() {
+			// 不读取即不会回应 ping，模拟假死的上游。
+			<-release
+			return
+		}
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	})
+	t.Cleanup(func() {
+		close(release)
+		tracker.closeAll()
+		upstream.Close()
+	})
+
+	m := NewUpstreamWebSocketManager(nil, toWSURL(upstream.URL), nil, nil, nil)
+	t.Cleanup(m.CloseAllConnections)
+	if got := m.HeartbeatConfig(); got.UpstreamInterval != 0 || got.MaxMissed != wsHeartbeatDefaultMaxMissed {
+		t.Fatalf("default heartbeat=%+v", got)
+	}
+	m.ConfigureHeartbeat(WSHeartbeatConfig{UpstreamInterval: 20 * time.Millisecond, MaxMissed: 3})
+
+	session, _ := newDownstreamPair(t)
+	m.RegisterDownstream("u1", session, `{"act":"sign","id":"u1"}`)
+	waitFor(t, "upstream rtt", func() bool {
+		up, ok := heartbeatIdentity(m, "u1")["upstream"].(WSHeartbeatSnapshot)
+		return ok && up.RTTMs >= 0 && up.LastPongAt > 0
+	})
+
+	silent.Store(true)
+	tracker.closeAll()
+	waitFor(t, "dead upstream", func() bool { return heartbeatStats(m)["deadUpstream"].(int64) >= 1 })
+}
+
+func TestHandleWebSocket_DownstreamHeartbeat(t *testing.T) {
+	tracker := &wsConnTracker{}
+	upstream := newUpstreamWSServer(t, func(conn *websocket.Conn) {
+		tracker.add(conn)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	})
+	t.Cleanup(func() {
+		tracker.closeAll()
+		upstream.Close()
+	})
+
+	wsManager := NewUpstreamWebSocketManager(nil, toWSURL(upstream.URL), nil, nil, nil)
+	t.Cleanup(wsManager.CloseAllConnections)
+	wsManager.ConfigureHeartbeat(WSHeartbeatConfig{DownstreamInterval: 20 * time.Millisecond, MaxMissed: 3})
+
+	jwtService := NewJWTService("secret-1", 1)
+	token, err := jwtService.GenerateToken()
+	if err != nil {
+		t.Fatalf("GenerateToken failed: %v", err)
+	}
+	app := &App{jwt: jwtService, wsManager: wsManager}
+	backend := httptest.NewServer(http.HandlerFunc(app.handleWebSocket))
+	t.Cleanup(backend.Close)
+	dial := func() *websocket.Conn {
+		t.Helper()
+		conn, _, err := websocket.DefaultDialer.Dial(toWSURL(backend.URL)+"/ws?token="+url.QueryEscape(token), nil)
+		if err != nil {
+			t.Fatalf("dial downstream failed: %v", err)
+		}
+		t.Cleanup(func() { _ = conn.Close() })
+		return conn
+	}
+
+	// 持续读取的客户端会自动回应 pong，统计中出现下游 RTT。
+	alive := dial()
+	go func() {
+		for {
+			if _, _, err := alive.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+	_ = alive.WriteMessage(websocket.TextMessage, []byte(`{"act":"sign","id":"u1"}`))
+	waitFor(t, "downstream rtt", func() bool {
+		downs, _ := heartbeatIdentity(wsManager, "u1")["downstream"].([]WSHeartbeatSnapshot)
+		return len(downs) == 1 && downs[0].RTTMs >= 0
+	})
+
+	// 从不读取的客户端无法回应 pong，超时后被服务端关闭。
+	stale := dial()
+	_ = stale.WriteMessage(websocket.TextMessage, []byte(`{"act":"sign","id":"u1"}`))
+	waitFor(t, "dead downstream", func() bool { return heartbeatStats(wsManager)["deadDownstream"].(int64) == 1 })
+	waitFor(t, "stale session removed", func() bool {
+		downs, _ := heartbeatIdentity(wsManager, "u1")["downstream"].([]WSHeartbeatSnapshot)
+		return len(downs) == 1
+	})
+}
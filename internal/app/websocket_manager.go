@@ -8,6 +8,8 @@ import (
 	"fmt"
 	"io"
 	"log/slog"
+	"math/rand/v2"
+	"net"
 	"net/http"
 	"strconv"
 	"strings"
@@ -16,6 +18,8 @@ import (
 	"time"
 
 	"github.com/gorilla/websocket"
+
+	"liao/internal/protocol"
 )
 
 const (
@@ -28,6 +32,9 @@ const (
 	wsUpstreamCloseWait       = 5 * time.Second
 	wsEvictionCloseDelay      = 1 * time.Second
 	wsForceoutDownstreamDelay = 1 * time.Second
+	wsReconnectInitialDelay   = 1 * time.Second
+	wsReconnectMaxBackoff     = 30 * time.Second
+	wsReconnectAttemptLimit   = 6
 )
 
 var (
@@ -35,6 +42,12 @@ var (
 	wsEvictionDelay = wsEvictionCloseDelay
 	wsForceoutDelay = wsForceoutDownstreamDelay
 
+	// 上游异常断开后的自动重连参数（指数退避 + 抖动）；wsReconnectMaxAttempts<=0 时关闭自动重连。
+	wsReconnectBaseDelay   = wsReconnectInitialDelay
+	wsReconnectMaxDelay    = wsReconnectMaxBackoff
+	wsReconnectMaxAttempts = wsReconnectAttemptLimit
+	wsReconnectDelayFn     = reconnectBackoffDelay
+
 	wsWebServiceRandServerBase = "http://v1.chat2019.cn/Act/WebService.asmx/getRandServer?ServerInfo=serversdeskry&_="
 )
 
@@ -46,12 +59,51 @@ type UpstreamWebSocketManager struct {
 	cache    UserInfoCacheService
 	history  ChatHistoryCacheService
 	archive  UserArchiveService
+	outbox   UpstreamOutboxService
 
 	mu                    sync.Mutex
 	upstreamClients       map[string]*UpstreamWebSocketClient
 	downstreamSessions    map[string]map[*DownstreamSession]struct{}
 	pendingCloseTasks     map[string]*time.Timer
 	connectionCreateMilli map[string]int64
+	// observerSessions 为只读旁观会话：接收该身份的下游帧，但不发往上游，也不计入保活。
+	observerSessions map[string]map[*DownstreamSession]struct{}
+
+	// signMessages 缓存每个身份最近一次 sign 原文，用于上游断线重连后自动重新 sign。
+	signMessages      map[string]string
+	reconnectTasks    map[string]*time.Timer
+	reconnectAttempts map[string]int
+
+	reconnectMaxAttempts int
+	reconnectDelayFn     func(attempt int) time.Duration
+
+	// pipeline 为上游消息处理链，内置阶段见 upstream_pipeline_builtin.go。
+	pipeline *UpstreamMessagePipeline
+	// outboundFilter 为发往上游前的出站过滤链，见 outbound_filter.go。
+	outboundFilter *OutboundFilter
+	// protocolErrors 统计严格解码失败（上游协议漂移）的帧数。
+	protocolErrors atomic.Int64
+	// recorder 为可选的会话录制器，仅对开启录制的身份写入帧。
+	recorder *WSSessionRecorder
+	// connectionEvents 为可选的连接生命周期事件存储，见 ws_connection_event.go。
+	connectionEvents WSConnectionEventStore
+	// scheduler 决定身份连接的准入、驱逐与关闭延迟，见 websocket_scheduler.go。
+	scheduler *identityScheduler
+	// cluster 为可选的多副本协调器，见 websocket_cluster.go。
+	cluster *WSCluster
+	// keepAlive 为可选的保活判定：返回 true 的身份在没有下游会话时也保持上游连接并自动重连（如启用了自动回复的身份）。
+	// 调用时不持有 m.mu。
+	keepAlive func(userID string) bool
+	// heartbeat 为上下游心跳配置，deadUpstream/deadDownstream 统计因心跳超时被关闭的连接数，见 websocket_heartbeat.go。
+	heartbeat      WSHeartbeatConfig
+	deadUpstream   atomic.Int64
+	deadDownstream atomic.Int64
+
+	// replayBuffers 按身份缓存最近广播的上游帧，replaySeq 为进程内全局单调递增的帧序号；
+	// replayEpoch 为本进程的续传纪元，随 seq 一起下发，seq 只在同一纪元内可比较。
+	replayBuffers map[string]*downstreamReplayRing
+	replaySeq     int64
+	replayEpoch   string
 }
 
 func NewUpstreamWebSocketManager(httpClient *http.Client, fallbackWS string, forceout *ForceoutManager, cache UserInfoCacheService, history ChatHistoryCacheService, archives ...UserArchiveService) *UpstreamWebSocketManager {
@@ -62,7 +114,7 @@ func NewUpstreamWebSocketManager(httpClient *http.Client, fallbackWS string, for
 	if len(archives) > 0 {
 		archive = archives[0]
 	}
-	return &UpstreamWebSocketManager{
+	m := &UpstreamWebSocketManager{
 		httpClient:            httpClient,
 		fallbackWS:            fallbackWS,
 		forceout:              forceout,
@@ -71,36 +123,194 @@ func NewUpstreamWebSocketManager(httpClient *http.Client, fallbackWS string, for
 		archive:               archive,
 		upstreamClients:       make(map[string]*UpstreamWebSocketClient),
 		downstreamSessions:    make(map[string]map[*DownstreamSession]struct{}),
+		observerSessions:      make(map[string]map[*DownstreamSession]struct{}),
 		pendingCloseTasks:     make(map[string]*time.Timer),
 		connectionCreateMilli: make(map[string]int64),
+		signMessages:          make(map[string]string),
+		reconnectTasks:        make(map[string]*time.Timer),
+		reconnectAttempts:     make(map[string]int),
+		reconnectMaxAttempts:  wsReconnectMaxAttempts,
+		reconnectDelayFn:      wsReconnectDelayFn,
+		replayBuffers:         make(map[string]*downstreamReplayRing),
+		replayEpoch:           newReplayEpoch(),
+		pipeline:              NewUpstreamMessagePipeline(),
+		outboundFilter:        NewOutboundFilter(),
+		scheduler:             newIdentityScheduler(IdentitySchedulerConfig{}),
+		heartbeat:             WSHeartbeatConfig{}.normalized(),
+	}
+	m.registerBuiltinUpstreamStages()
+	return m
+}
+
+// Pipeline 返回上游消息处理链，可注册自定义阶段观察、改写或拦截上游帧。
+func (m *UpstreamWebSocketManager) Pipeline() *UpstreamMessagePipeline {
+	return m.pipeline
+}
+
+// OutboundFilter 返回出站过滤链，可注册自定义规则拦截或改写下游发往上游的消息。
+func (m *UpstreamWebSocketManager) OutboundFilter() *OutboundFilter {
+	return m.outboundFilter
+}
+
+// ConfigureHeartbeat 设置上下游心跳间隔与失活判定次数，仅影响之后建立的连接。
+func (m *UpstreamWebSocketManager) ConfigureHeartbeat(cfg WSHeartbeatConfig) {
+	m.mu.Lock()
+	m.heartbeat = cfg.normalized()
+	m.mu.Unlock()
+}
+
+// HeartbeatConfig 返回当前心跳配置。
+func (m *UpstreamWebSocketManager) HeartbeatConfig() WSHeartbeatConfig {
+	if m == nil {
+		return WSHeartbeatConfig{}.normalized()
 	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.heartbeat
+}
+
+// SetKeepAlive 设置保活判定；需在启动阶段调用。
+func (m *UpstreamWebSocketManager) SetKeepAlive(keepAlive func(userID string) bool) {
+	m.keepAlive = keepAlive
+}
+
+// keptAlive 返回身份是否需要在没有下游会话时保持连接；调用方不能持有 m.mu。
+func (m *UpstreamWebSocketManager) keptAlive(userID string) bool {
+	return m.keepAlive != nil && m.keepAlive(userID)
+}
+
+// SetOutbox 设置上游发送队列；为 nil 时 SendToUpstream 不做持久化。
+func (m *UpstreamWebSocketManager) SetOutbox(outbox UpstreamOutboxService) {
+	m.outbox = outbox
+}
+
+// SignMessage 返回身份最近缓存的 sign 消息（没有时为空）。
+func (m *UpstreamWebSocketManager) SignMessage(userID string) string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.signMessages[strings.TrimSpace(userID)]
+}
+
+// SetRecorder 设置会话录制器（nil 表示不录制）。
+func (m *UpstreamWebSocketManager) SetRecorder(recorder *WSSessionRecorder) {
+	m.recorder = recorder
+}
+
+// Recorder 返回会话录制器，未配置时为 nil。
+func (m *UpstreamWebSocketManager) Recorder() *WSSessionRecorder {
+	if m == nil {
+		return nil
+	}
+	return m.recorder
+}
+
+func (m *UpstreamWebSocketManager) recordFrame(userID string, direction string, frame string) {
+	if m == nil || m.recorder == nil {
+		return
+	}
+	m.recorder.Record(userID, direction, frame)
+}
+
+// SetConnectionEventStore 设置连接生命周期事件存储（nil 表示不记录）。
+func (m *UpstreamWebSocketManager) SetConnectionEventStore(store WSConnectionEventStore) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.connectionEvents = store
+}
+
+// recordConnectionEventLocked 记录一条连接事件，下游会话数取当前值；调用方需持有 m.mu。
+func (m *UpstreamWebSocketManager) recordConnectionEventLocked(eventType string, userID string, upstreamURL string, reason string, duration time.Duration) {
+	if m.connectionEvents == nil {
+		return
+	}
+	m.connectionEvents.Enqueue(WSConnectionEvent{
+		UserID:          userID,
+		EventType:       eventType,
+		UpstreamURL:     upstreamURL,
+		Reason:          reason,
+		DurationMs:      duration.Milliseconds(),
+		DownstreamCount: len(m.downstreamSessions[userID]),
+		createdAt:       time.Now(),
+	})
+}
+
+// connectionAgeLocked 返回身份当前上游连接自创建以来的时长，未知时为 0；调用方需持有 m.mu。
+func (m *UpstreamWebSocketManager) connectionAgeLocked(userID string) time.Duration {
+	created, ok := m.connectionCreateMilli[userID]
+	if !ok || created <= 0 {
+		return 0
+	}
+	return time.Since(time.UnixMilli(created))
 }
 
 func (m *UpstreamWebSocketManager) RegisterDownstream(userID string, session *DownstreamSession, signMessage string) {
+	m.registerDownstream(userID, session, signMessage, "", -1)
+}
+
+// ResumeDownstream 与 RegisterDownstream 相同，但会在注册后先向该会话补发 seq 大于 lastSeq 的缓存帧，
+// 并以 code=-9 帧告知补发数量、是否有帧已超出缓存以及当前纪元与最新 seq。
+// lastEpoch 与当前缓冲的纪元不一致时 lastSeq 不可比较，补发全部缓存并标记 reset。
+func (m *UpstreamWebSocketManager) ResumeDownstream(userID string, session *DownstreamSession, signMessage string, lastEpoch string, lastSeq int64) {
+	if lastSeq < 0 {
+		lastSeq = 0
+	}
+	m.registerDownstream(userID, session, signMessage, lastEpoch, lastSeq)
+}
+
+func (m *UpstreamWebSocketManager) registerDownstream(userID string, session *DownstreamSession, signMessage string, lastEpoch string, lastSeq int64) {
 	userID = strings.TrimSpace(userID)
 	if userID == "" || session == nil {
 		return
 	}
 
 	if m.forceout != nil && m.forceout.IsForbidden(userID) {
-		remaining := int64(0)
-		if m.forceout != nil {
-			remaining = m.forceout.RemainingSeconds(userID)
-		}
-		reject := fmt.Sprintf("{\"code\":-4,\"content\":\"由于重复登录，您的连接被暂时禁止，请%d秒后再试\",\"forceout\":true}", remaining)
-		_ = session.SendText(reject)
+		_ = session.SendText(buildForceoutRejectMessage(m.forceout.RemainingSeconds(userID)))
 		_ = session.Close()
 		return
 	}
 
+	// 其他副本持有该身份的上游连接时，本副本只登记下游会话并把 sign 转发给 owner。
+	remoteOwner := m.cluster.remoteOwner(userID)
+
 	var shouldCreate bool
 	var evictUserID string
+	var replay replayResult
+
+	// 续传时先占住该会话的写锁，保证补发帧先于注册后新到达的广播帧写出。
+	resume := lastSeq >= 0
+	if resume {
+		session.writeMu.Lock()
+	}
 
 	m.mu.Lock()
+	if _, ok := m.upstreamClients[userID]; !ok && remoteOwner == "" {
+		shouldCreate = true
+		var reject bool
+		evictUserID, reject = m.scheduler.admit(userID, m.upstreamClients, m.connectionCreateMilli)
+		if reject {
+			capacity := m.scheduler.capacity
+			m.mu.Unlock()
+			if resume {
+				session.writeMu.Unlock()
+			}
+			slog.Warn("上游身份连接已达上限，拒绝新身份", "userID", userID, "capacity", capacity)
+			_ = session.SendText(buildCapacityRejectMessage(capacity))
+			_ = session.Close()
+			return
+		}
+	}
 	if t := m.pendingCloseTasks[userID]; t != nil {
 		t.Stop()
 		delete(m.pendingCloseTasks, userID)
 	}
+	if strings.TrimSpace(signMessage) != "" {
+		m.signMessages[userID] = signMessage
+	}
+	// 正在等待重连时有新的 sign，直接立即重连（保留重试计数，连接成功后仍会通知 reconnected）。
+	if t := m.reconnectTasks[userID]; t != nil {
+		t.Stop()
+		delete(m.reconnectTasks, userID)
+	}
 
 	sessions := m.downstreamSessions[userID]
 	if sessions == nil {
@@ -108,34 +318,38 @@ func (m *UpstreamWebSocketManager) RegisterDownstream(userID string, session *Do
 		m.downstreamSessions[userID] = sessions
 	}
 	sessions[session] = struct{}{}
+	if resume {
+		replay = m.replayFramesLocked(userID, lastEpoch, lastSeq)
+	}
 
-	if _, ok := m.upstreamClients[userID]; !ok {
-		shouldCreate = true
+	m.mu.Unlock()
 
-		if len(m.upstreamClients) >= wsMaxConcurrentIdentities {
-			oldestUserID := ""
-			oldestTs := int64(0)
-			for uid, ts := range m.connectionCreateMilli {
-				if uid == userID {
-					continue
-				}
-				if oldestUserID == "" || ts < oldestTs {
-					oldestUserID = uid
-					oldestTs = ts
-				}
-			}
-			if oldestUserID != "" {
-				evictUserID = oldestUserID
+	if resume {
+		err := m.writeReplayLocked(session, replay)
+		session.writeMu.Unlock()
+		if err != nil {
+			_ = session.Close()
+			m.UnregisterDownstream(userID, session)
+			return
+		}
+		slog.Info("下游续传补发上游消息", "userID", userID, "lastSeq", lastSeq, "replayed", len(replay.messages), "truncated", replay.truncated, "reset", replay.reset)
+	}
+
+	if m.cluster != nil {
+		m.cluster.setInterest(userID, true)
+		if remoteOwner != "" {
+			if err := m.cluster.forward(remoteOwner, wsClusterEnvelope{Type: wsClusterSign, UserID: userID, Frame: signMessage}); err != nil {
+				// owner 已失效：等待租约过期后由维护循环接管。
+				slog.Warn("转发 sign 到 owner 副本失败", "userID", userID, "owner", remoteOwner, "error", err)
 			}
 		}
 	}
-	m.mu.Unlock()
 
-	if evictUserID != "" && evictUserID != userID {
-		evictMessage := "{\"code\":-6,\"content\":\"由于新身份连接，您已被自动断开\",\"evicted\":true}"
-		m.BroadcastToDownstream(evictUserID, evictMessage)
+	if evictUserID != "" {
+		slog.Info("上游身份连接已达上限，驱逐旧身份", "userID", userID, "evicted", evictUserID)
+		m.BroadcastToDownstream(evictUserID, buildEvictMessage())
 		time.AfterFunc(wsEvictionDelay, func() {
-			m.CloseUpstreamConnection(evictUserID)
+			m.closeUpstreamConnection(evictUserID, WSConnectionEventEvict, "capacity: admitted "+userID)
 
 			sessions := m.snapshotDownstream(evictUserID)
 			for _, s := range sessions {
@@ -144,6 +358,8 @@ func (m *UpstreamWebSocketManager) RegisterDownstream(userID string, session *Do
 
 			m.mu.Lock()
 			delete(m.downstreamSessions, evictUserID)
+			delete(m.signMessages, evictUserID)
+			delete(m.replayBuffers, evictUserID)
 			if t := m.pendingCloseTasks[evictUserID]; t != nil {
 				t.Stop()
 				delete(m.pendingCloseTasks, evictUserID)
@@ -165,40 +381,344 @@ func (m *UpstreamWebSocketManager) UnregisterDownstream(userID string, session *
 
 	m.mu.Lock()
 	sessions := m.downstreamSessions[userID]
+	lastSession := false
 	if sessions != nil {
 		delete(sessions, session)
 		if len(sessions) == 0 {
+			lastSession = true
 			delete(m.downstreamSessions, userID)
+			m.stopReconnectLocked(userID)
+			delete(m.reconnectAttempts, userID)
 			m.scheduleCloseUpstreamLocked(userID)
 		}
 	}
 	m.mu.Unlock()
+
+	if lastSession {
+		m.cluster.setInterest(userID, false)
+	}
 }
 
-func (m *UpstreamWebSocketManager) SendToUpstream(userID string, message string) {
+// RegisterObserver 以只读方式旁观身份的下游帧：不 sign、不建立或保活上游连接，仅在该身份已有上游连接时收到广播。
+func (m *UpstreamWebSocketManager) RegisterObserver(userID string, session *DownstreamSession) {
 	userID = strings.TrimSpace(userID)
-	if userID == "" {
+	if userID == "" || session == nil {
 		return
 	}
+	m.mu.Lock()
+	sessions := m.observerSessions[userID]
+	if sessions == nil {
+		sessions = make(map[*DownstreamSession]struct{})
+		m.observerSessions[userID] = sessions
+	}
+	sessions[session] = struct{}{}
+	m.mu.Unlock()
+}
 
+func (m *UpstreamWebSocketManager) UnregisterObserver(userID string, session *DownstreamSession) {
+	userID = strings.TrimSpace(userID)
+	if userID == "" || session == nil {
+		return
+	}
+	m.mu.Lock()
+	if sessions := m.observerSessions[userID]; sessions != nil {
+		delete(sessions, session)
+		if len(sessions) == 0 {
+			delete(m.observerSessions, userID)
+		}
+	}
+	m.mu.Unlock()
+}
+
+// SendToUpstream 经出站过滤后把消息发往身份的上游连接；下游转发、自动回复等发送方都走这里。
+// 返回过滤结果：被拦截时消息未发送，由调用方决定是否通知发送方（下游转发回复发起会话，自动回复只记日志）。
+func (m *UpstreamWebSocketManager) SendToUpstream(userID string, message string) OutboundFilterDecision {
+	userID = strings.TrimSpace(userID)
+	if userID == "" {
+		return OutboundFilterDecision{}
+	}
+	message, decision := m.filterOutbound(userID, message)
+	if decision.Blocked() {
+		return decision
+	}
+
+	outboxID := m.enqueueOutbox(userID, message)
+	if !m.sendToUpstreamTracked(userID, message, outboxID) {
+		m.markOutboxDelivery(outboxID, fmt.Errorf("无可用上游连接"))
+	}
+	return decision
+}
+
+// filterOutbound 对发往上游的帧执行出站过滤，是 SendToUpstream 与 SendScheduled 共用的唯一过滤入口；
+// 被拦截时调用方不得再发送（拦截已由过滤器记录日志）。
+func (m *UpstreamWebSocketManager) filterOutbound(userID string, message string) (string, OutboundFilterDecision) {
+	return m.outboundFilter.Apply(userID, message)
+}
+
+// enqueueOutbox 将私聊发送帧写入发送队列并返回记录 ID；未启用队列、非私聊帧或写入失败时返回 0。
+func (m *UpstreamWebSocketManager) enqueueOutbox(userID string, message string) int64 {
+	if m.outbox == nil || !isOutboundChatFrame(message) {
+		return 0
+	}
+	id, err := m.outbox.Enqueue(context.Background(), userID, message)
+	if err != nil {
+		slog.Warn("上游消息写入发送队列失败", "userID", userID, "error", err)
+	}
+	return id
+}
+
+// claimOutbox 在补发或重试前认领发送队列记录；认领失败（已被其他发送方持有或已送达）时返回 false。
+func (m *UpstreamWebSocketManager) claimOutbox(item UpstreamOutboxMessage) bool {
+	ok, err := m.outbox.Claim(context.Background(), item.ID)
+	if err != nil {
+		slog.Warn("认领上游发送队列消息失败", "userID", item.UserID, "id", item.ID, "error", err)
+		return false
+	}
+	return ok
+}
+
+// sendToUpstreamTracked 将消息交给上游客户端发送，返回是否已交付给某个客户端（发送或排队）。
+// outboxID>0 时发送结果会回写发送队列；返回 false 时由调用方回写失败，等待下次连接建立后补发或手动重试。
+func (m *UpstreamWebSocketManager) sendToUpstreamTracked(userID string, message string, outboxID int64) bool {
 	m.mu.Lock()
 	client := m.upstreamClients[userID]
 	_, hasDownstream := m.downstreamSessions[userID]
+	signMessage := ""
+	if client != nil {
+		m.touchIdentityLocked(userID)
+	} else {
+		// 上游已断开（例如正在退避等待重连）：立即重建并补发缓存的 sign，保证消息不会发到未登录的连接上。
+		signMessage = m.signMessages[userID]
+		if hasDownstream {
+			m.stopReconnectLocked(userID)
+		}
+	}
 	m.mu.Unlock()
 
+	if client == nil && m.forwardToOwner(userID, message, outboxID) {
+		return true
+	}
 	if client == nil || !client.IsOpen() {
+		// 没有下游会话时，只有保活身份（如启用了自动回复）才用缓存的 sign 立即重连，不等退避结束。
 		if !hasDownstream {
-			return
+			if (client == nil && strings.TrimSpace(signMessage) == "") || !m.keptAlive(userID) {
+				return false
+			}
+			m.mu.Lock()
+			m.stopReconnectLocked(userID)
+			m.mu.Unlock()
+		}
+		client = m.createUpstreamConnection(userID, signMessage)
+	}
+	if client == nil {
+		return false
+	}
+	_ = client.sendTracked(message, outboxID)
+	return true
+}
+
+// SendScheduled 在没有下游会话时发送一条消息（定时消息使用）：复用已有上游连接，
+// 没有连接时用 signMessage 建立连接并等待 sign 发出后再写入；返回写入结果。
+// 发送完成后若该身份没有下游会话，按关闭延迟安排关闭上游连接。
+func (m *UpstreamWebSocketManager) SendScheduled(ctx context.Context, userID string, signMessage string, message string) error {
+	userID = strings.TrimSpace(userID)
+	if userID == "" || strings.TrimSpace(message) == "" {
+		return fmt.Errorf("userId 和消息不能为空")
+	}
+	if m.forceout != nil && m.forceout.IsForbidden(userID) {
+		return fmt.Errorf("身份处于 forceout 禁止期（剩余 %d 秒）", m.forceout.RemainingSeconds(userID))
+	}
+	message, decision := m.filterOutbound(userID, message)
+	if decision.Blocked() {
+		return fmt.Errorf("消息被出站过滤拦截: %s", decision.Reason())
+	}
+
+	outboxID := m.enqueueOutbox(userID, message)
+
+	m.mu.Lock()
+	client := m.upstreamClients[userID]
+	if client == nil && m.cluster.remoteOwner(userID) == "" {
+		// 后台发送不驱逐在线身份：没有空闲名额时直接失败，由调用方稍后重试。
+		if !m.scheduler.hasFreeSlot(userID, m.upstreamClients) {
+			capacity := m.scheduler.capacity
+			m.mu.Unlock()
+			m.markOutboxDelivery(outboxID, fmt.Errorf("上游身份连接已达上限"))
+			return fmt.Errorf("上游身份连接已达上限（%d）", capacity)
 		}
-		client = m.createUpstreamConnection(userID, "")
 	}
 	if client != nil {
-		client.SendMessage(message)
+		m.touchIdentityLocked(userID)
+	}
+	m.mu.Unlock()
+
+	if client == nil {
+		if m.forwardToOwner(userID, message, outboxID) {
+			return nil
+		}
+		if strings.TrimSpace(signMessage) == "" {
+			m.markOutboxDelivery(outboxID, fmt.Errorf("缺少 sign 消息"))
+			return fmt.Errorf("身份未连接且缺少 sign 消息")
+		}
+		client = m.createUpstreamConnection(userID, signMessage)
+		if client == nil {
+			// 其他副本抢先持有租约并已收到 sign：转发给 owner。
+			if m.forwardToOwner(userID, message, outboxID) {
+				return nil
+			}
+			m.markOutboxDelivery(outboxID, fmt.Errorf("建立上游连接失败"))
+			return fmt.Errorf("建立上游连接失败")
+		}
+	}
+
+	if err := waitUpstreamClientReady(ctx, client); err != nil {
+		m.markOutboxDelivery(outboxID, err)
+		m.scheduleCloseIfIdle(userID)
+		return err
+	}
+	err := client.sendTracked(message, outboxID)
+	m.scheduleCloseIfIdle(userID)
+	return err
+}
+
+// waitUpstreamClientReady 等待上游连接建立且 sign 等排队消息已写出。
+func waitUpstreamClientReady(ctx context.Context, client *UpstreamWebSocketClient) error {
+	ticker := time.NewTicker(20 * time.Millisecond)
+	defer ticker.Stop()
+	for !client.ready() {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("等待上游连接超时: %w", ctx.Err())
+		case <-client.done:
+			return fmt.Errorf("上游连接已关闭")
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
+
+// scheduleCloseIfIdle 在身份没有下游会话且未安排关闭时，按关闭延迟安排关闭上游连接。
+func (m *UpstreamWebSocketManager) scheduleCloseIfIdle(userID string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, hasSessions := m.downstreamSessions[userID]
+	_, hasClient := m.upstreamClients[userID]
+	if !hasSessions && hasClient && m.pendingCloseTasks[userID] == nil {
+		m.scheduleCloseUpstreamLocked(userID)
+	}
+}
+
+// RetryOutbox 将某身份的未送达消息（ids 为空表示全部）重新排队并尝试发送。
+// 返回重新排队的消息以及是否已交付给上游连接；无活跃连接时首条消息标记为 failed，其余保持 queued。
+func (m *UpstreamWebSocketManager) RetryOutbox(ctx context.Context, userID string, ids []int64) ([]UpstreamOutboxMessage, bool, error) {
+	userID = strings.TrimSpace(userID)
+	if userID == "" {
+		return nil, false, fmt.Errorf("userId 不能为空")
+	}
+	if m.outbox == nil {
+		return nil, false, fmt.Errorf("发送队列未启用")
+	}
+
+	items, err := m.outbox.Requeue(ctx, userID, ids)
+	if err != nil {
+		return nil, false, err
+	}
+	delivered := len(items) > 0
+	for _, item := range items {
+		if !m.claimOutbox(item) {
+			continue
+		}
+		if !m.sendToUpstreamTracked(userID, item.Payload, item.ID) {
+			m.markOutboxDelivery(item.ID, fmt.Errorf("无可用上游连接"))
+			delivered = false
+			break
+		}
+	}
+	return items, delivered, nil
+}
+
+func (m *UpstreamWebSocketManager) markOutboxDelivery(outboxID int64, err error) {
+	if m == nil || m.outbox == nil || outboxID <= 0 {
+		return
+	}
+	if err != nil {
+		m.outbox.MarkFailed(context.Background(), outboxID, err.Error())
+		return
+	}
+	m.outbox.MarkSent(context.Background(), outboxID)
+}
+
+// redeliverOutbox 在上游连接建立后补发 before 之前仍未送达的近期消息（连接关闭前未 flush 或进程重启丢失的消息）。
+func (m *UpstreamWebSocketManager) redeliverOutbox(userID string, before time.Time) {
+	if m.outbox == nil {
+		return
+	}
+	items, err := m.outbox.ListUndelivered(context.Background(), UpstreamOutboxQuery{
+		UserID:      userID,
+		Since:       before.Add(-upstreamOutboxRedeliverWindow),
+		Before:      before,
+		MaxAttempts: upstreamOutboxRedeliverMaxAttempts,
+		Limit:       upstreamOutboxMaxListLimit,
+	})
+	if err != nil {
+		slog.Warn("查询待补发上游消息失败", "userID", userID, "error", err)
+		return
+	}
+	if len(items) == 0 {
+		return
+	}
+
+	m.mu.Lock()
+	client := m.upstreamClients[userID]
+	m.mu.Unlock()
+	if client == nil {
+		return
+	}
+	slog.Info("补发未送达的上游消息", "userID", userID, "count", len(items))
+	for _, item := range items {
+		// 先认领再发送：实时发送中的记录处于 sending，不会被重复补发。
+		if m.claimOutbox(item) {
+			_ = client.sendTracked(item.Payload, item.ID)
+		}
+	}
+}
+
+// writeReplayLocked 在调用方已持有 session.writeMu 时写出补发帧与续传结果帧。
+func (m *UpstreamWebSocketManager) writeReplayLocked(session *DownstreamSession, replay replayResult) error {
+	for _, message := range replay.messages {
+		if err := session.writeTextLocked(message); err != nil {
+			return err
+		}
 	}
+	return session.writeTextLocked(buildResumeMessage(replay))
 }
 
+// broadcastUpstreamFrame 为上游帧附加 seq、写入续传缓冲后广播给下游；本地状态帧不经过此路径。
+func (m *UpstreamWebSocketManager) broadcastUpstreamFrame(userID string, message string) {
+	m.mu.Lock()
+	var seq int64
+	if isJSONObjectFrame(message) {
+		message = m.recordReplayFrameLocked(userID, message)
+		seq = m.replaySeq
+	}
+	m.touchIdentityLocked(userID)
+	m.mu.Unlock()
+	m.cluster.publishFrame(userID, message, seq, m.replayEpoch)
+	m.deliverDownstream(userID, message)
+}
+
+// BroadcastToDownstream 把帧写给该身份的全部下游会话；启用集群时同时发布给其他副本。
 func (m *UpstreamWebSocketManager) BroadcastToDownstream(userID string, message string) {
-	sessions := m.snapshotDownstream(userID)
+	m.cluster.publishFrame(userID, message, 0, "")
+	m.deliverDownstream(userID, message)
+}
+
+// deliverDownstream 只写给本副本的下游会话。
+func (m *UpstreamWebSocketManager) deliverDownstream(userID string, message string) {
+	m.recordFrame(userID, WSRecordDownstreamOut, message)
+	m.mu.Lock()
+	sessions := m.snapshotDownstreamLocked(userID)
+	observers := snapshotSessionSet(m.observerSessions[userID])
+	m.mu.Unlock()
 	for _, session := range sessions {
 		if session == nil {
 			continue
@@ -208,6 +728,12 @@ func (m *UpstreamWebSocketManager) BroadcastToDownstream(userID string, message
 			m.UnregisterDownstream(userID, session)
 		}
 	}
+	for _, observer := range observers {
+		if err := observer.SendText(message); err != nil {
+			_ = observer.Close()
+			m.UnregisterObserver(userID, observer)
+		}
+	}
 }
 
 func (m *UpstreamWebSocketManager) HandleForceout(userID string, message string) {
@@ -217,11 +743,11 @@ func (m *UpstreamWebSocketManager) HandleForceout(userID string, message string)
 	}
 
 	if m.forceout != nil {
-		m.forceout.AddForceoutUser(userID)
+		m.forceout.RecordForceout(userID, message)
 	}
 
 	m.BroadcastToDownstream(userID, message)
-	m.CloseUpstreamConnection(userID)
+	m.closeUpstreamConnection(userID, WSConnectionEventForceout, "upstream forceout")
 
 	time.AfterFunc(wsForceoutDelay, func() {
 		sessions := m.snapshotDownstream(userID)
@@ -230,36 +756,173 @@ func (m *UpstreamWebSocketManager) HandleForceout(userID string, message string)
 		}
 		m.mu.Lock()
 		delete(m.downstreamSessions, userID)
+		delete(m.signMessages, userID)
+		delete(m.replayBuffers, userID)
 		m.mu.Unlock()
 	})
 }
 
 func (m *UpstreamWebSocketManager) HandleUpstreamDisconnect(userID string) {
+	m.handleUpstreamDisconnect(userID, "upstream closed")
+}
+
+// handleUpstreamDisconnect 处理上游连接非预期断开（含建连失败），reason 写入连接事件。
+func (m *UpstreamWebSocketManager) handleUpstreamDisconnect(userID string, reason string) {
 	userID = strings.TrimSpace(userID)
 	if userID == "" {
 		return
 	}
+	keep := m.keptAlive(userID)
 
 	m.mu.Lock()
+	if client := m.upstreamClients[userID]; client != nil {
+		m.recordConnectionEventLocked(WSConnectionEventDisconnect, userID, client.wsURL, reason, m.connectionAgeLocked(userID))
+	}
 	delete(m.upstreamClients, userID)
 	delete(m.connectionCreateMilli, userID)
+	m.scheduler.forget(userID)
+	if attempt, delay, ok := m.scheduleReconnectLocked(userID, keep); ok {
+		m.recordConnectionEventLocked(WSConnectionEventReconnect, userID, "", fmt.Sprintf("scheduled attempt %d/%d", attempt, m.reconnectMaxAttempts), delay)
+		m.mu.Unlock()
+		slog.Warn("上游连接断开，计划自动重连", "userID", userID, "attempt", attempt, "delay", delay)
+		m.BroadcastToDownstream(userID, buildReconnectingMessage(attempt, delay))
+		return
+	}
+	attempts := m.reconnectAttempts[userID]
+	if attempts > 0 {
+		m.recordConnectionEventLocked(WSConnectionEventReconnect, userID, "", fmt.Sprintf("gave up after %d attempts", attempts), 0)
+	}
 	sessions := m.snapshotDownstreamLocked(userID)
 	delete(m.downstreamSessions, userID)
+	delete(m.signMessages, userID)
+	delete(m.reconnectAttempts, userID)
+	delete(m.replayBuffers, userID)
 	m.mu.Unlock()
 
+	m.cluster.release(userID)
 	if len(sessions) == 0 {
 		return
 	}
+	if attempts > 0 {
+		slog.Warn("上游自动重连失败，关闭下游连接", "userID", userID, "attempts", attempts)
+	}
 	for _, s := range sessions {
 		_ = s.Close()
 	}
 }
 
+// HandleUpstreamConnected 在上游连接建立（并已补发排队消息）后调用；若处于重连流程中则通知下游已恢复。
+func (m *UpstreamWebSocketManager) HandleUpstreamConnected(userID string) {
+	userID = strings.TrimSpace(userID)
+	if userID == "" {
+		return
+	}
+
+	// 重连计数不在此清零：上游可能接受连接后立即关闭，需等收到首个有效帧再确认恢复，见 confirmUpstreamAlive。
+	m.mu.Lock()
+	attempts := m.reconnectAttempts[userID]
+	if client := m.upstreamClients[userID]; client != nil {
+		reason := "sign"
+		if attempts > 0 {
+			reason = fmt.Sprintf("reconnected after %d attempts", attempts)
+		}
+		m.recordConnectionEventLocked(WSConnectionEventConnect, userID, client.wsURL, reason, m.connectionAgeLocked(userID))
+	}
+	m.mu.Unlock()
+
+	if m.outbox != nil {
+		go m.redeliverOutbox(userID, time.Now())
+	}
+	if attempts <= 0 {
+		return
+	}
+	slog.Info("上游自动重连成功", "userID", userID, "attempts", attempts)
+	m.BroadcastToDownstream(userID, buildReconnectedMessage(attempts))
+}
+
+// confirmUpstreamAlive 在 client 收到首个有效上游帧后清零该身份的重连计数。
+func (m *UpstreamWebSocketManager) confirmUpstreamAlive(userID string, client *UpstreamWebSocketClient) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.upstreamClients[userID] != client {
+		return
+	}
+	delete(m.reconnectAttempts, userID)
+}
+
+// scheduleReconnectLocked 在满足条件时为 userID 安排一次延迟重连，返回本次重试序号与延迟。
+// 条件：开启重连、仍有下游会话（或 keep 为 true）、已缓存 sign、未被 forceout 禁止、未超过最大重试次数。
+func (m *UpstreamWebSocketManager) scheduleReconnectLocked(userID string, keep bool) (int, time.Duration, bool) {
+	if m.reconnectMaxAttempts <= 0 || m.reconnectDelayFn == nil {
+		return 0, 0, false
+	}
+	if len(m.downstreamSessions[userID]) == 0 && !keep {
+		return 0, 0, false
+	}
+	if strings.TrimSpace(m.signMessages[userID]) == "" {
+		return 0, 0, false
+	}
+	if m.forceout != nil && m.forceout.IsForbidden(userID) {
+		return 0, 0, false
+	}
+	attempt := m.reconnectAttempts[userID] + 1
+	if attempt > m.reconnectMaxAttempts {
+		return 0, 0, false
+	}
+
+	m.stopReconnectLocked(userID)
+	delay := m.reconnectDelayFn(attempt)
+	m.reconnectAttempts[userID] = attempt
+	m.reconnectTasks[userID] = time.AfterFunc(delay, func() {
+		m.runReconnect(userID)
+	})
+	return attempt, delay, true
+}
+
+func (m *UpstreamWebSocketManager) stopReconnectLocked(userID string) {
+	if t := m.reconnectTasks[userID]; t != nil {
+		t.Stop()
+		delete(m.reconnectTasks, userID)
+	}
+}
+
+func (m *UpstreamWebSocketManager) runReconnect(userID string) {
+	keep := m.keptAlive(userID)
+
+	m.mu.Lock()
+	delete(m.reconnectTasks, userID)
+	_, hasSessions := m.downstreamSessions[userID]
+	hasSessions = hasSessions || keep
+	_, hasUpstream := m.upstreamClients[userID]
+	signMessage := m.signMessages[userID]
+	if !hasSessions {
+		delete(m.reconnectAttempts, userID)
+	}
+	m.mu.Unlock()
+
+	if !hasSessions || hasUpstream {
+		return
+	}
+
+	// 等待期间被 forceout：按 sign 被拒绝的方式通知下游并关闭。
+	if m.forceout != nil && m.forceout.IsForbidden(userID) {
+		m.BroadcastToDownstream(userID, buildForceoutRejectMessage(m.forceout.RemainingSeconds(userID)))
+		m.handleUpstreamDisconnect(userID, "forceout forbidden")
+		return
+	}
+
+	slog.Info("上游自动重连", "userID", userID)
+	m.createUpstreamConnection(userID, signMessage)
+}
+
 func (m *UpstreamWebSocketManager) CloseAllConnections() {
 	m.mu.Lock()
 	upstream := make([]*UpstreamWebSocketClient, 0, len(m.upstreamClients))
-	for _, c := range m.upstreamClients {
+	owned := make([]string, 0, len(m.upstreamClients))
+	for userID, c := range m.upstreamClients {
 		upstream = append(upstream, c)
+		owned = append(owned, userID)
+		m.recordConnectionEventLocked(WSConnectionEventDisconnect, userID, c.wsURL, "close all", m.connectionAgeLocked(userID))
 	}
 	downstream := make([]*DownstreamSession, 0)
 	for _, sessions := range m.downstreamSessions {
@@ -267,18 +930,36 @@ func (m *UpstreamWebSocketManager) CloseAllConnections() {
 			downstream = append(downstream, s)
 		}
 	}
+	for _, sessions := range m.observerSessions {
+		for s := range sessions {
+			downstream = append(downstream, s)
+		}
+	}
 	for _, t := range m.pendingCloseTasks {
 		t.Stop()
 	}
+	for _, t := range m.reconnectTasks {
+		t.Stop()
+	}
 	m.upstreamClients = make(map[string]*UpstreamWebSocketClient)
 	m.downstreamSessions = make(map[string]map[*DownstreamSession]struct{})
+	m.observerSessions = make(map[string]map[*DownstreamSession]struct{})
 	m.pendingCloseTasks = make(map[string]*time.Timer)
 	m.connectionCreateMilli = make(map[string]int64)
+	m.scheduler.lastActive = make(map[string]int64)
+	m.scheduler.evicting = make(map[string]struct{})
+	m.signMessages = make(map[string]string)
+	m.reconnectTasks = make(map[string]*time.Timer)
+	m.reconnectAttempts = make(map[string]int)
+	m.replayBuffers = make(map[string]*downstreamReplayRing)
 	m.mu.Unlock()
 
 	for _, c := range upstream {
 		c.CloseExpected()
 	}
+	for _, userID := range owned {
+		m.cluster.release(userID)
+	}
 	for _, s := range downstream {
 		if s == nil {
 			continue
@@ -294,14 +975,80 @@ func (m *UpstreamWebSocketManager) GetConnectionStats() map[string]any {
 	for _, sessions := range m.downstreamSessions {
 		downstreamCount += len(sessions)
 	}
+	capacity := m.scheduler.capacity
+	scheduler := m.scheduler.stats(m.upstreamClients, m.connectionCreateMilli, m.downstreamSessions)
+	heartbeat := m.heartbeatStatsLocked()
+	observerCount := 0
+	observed := make(map[string]int, len(m.observerSessions))
+	transports := make(map[string]int)
+	for userID, sessions := range m.observerSessions {
+		observerCount += len(sessions)
+		observed[userID] = len(sessions)
+	}
+	for _, group := range []map[string]map[*DownstreamSession]struct{}{m.downstreamSessions, m.observerSessions} {
+		for _, sessions := range group {
+			for session := range sessions {
+				if kind := session.kind(); kind != "" {
+					transports[kind]++
+				}
+			}
+		}
+	}
 	m.mu.Unlock()
 
-	return map[string]any{
+	stats := map[string]any{
 		"active":         upstreamCount + downstreamCount,
 		"upstream":       upstreamCount,
 		"downstream":     downstreamCount,
-		"maxIdentities":  wsMaxConcurrentIdentities,
-		"availableSlots": wsMaxConcurrentIdentities - upstreamCount,
+		"maxIdentities":  capacity,
+		"availableSlots": max(capacity-upstreamCount, 0),
+		"protocolErrors": m.protocolErrors.Load(),
+		"recording":      len(m.recorder.List()),
+		"scheduler":      scheduler,
+		"outboundFilter": m.outboundFilter.stats(),
+		"heartbeat":      heartbeat,
+		"observers":      map[string]any{"total": observerCount, "identities": observed},
+		"transports":     transports,
+	}
+	if m.cluster != nil {
+		stats["cluster"] = m.cluster.stats()
+	}
+	return stats
+}
+
+// heartbeatStatsLocked 汇总心跳配置与各身份上下游连接的延迟、最近活动时间；调用方需持有 m.mu。
+func (m *UpstreamWebSocketManager) heartbeatStatsLocked() map[string]any {
+	identities := make(map[string]any, len(m.upstreamClients)+len(m.downstreamSessions))
+	entry := func(userID string) map[string]any {
+		if e, ok := identities[userID].(map[string]any); ok {
+			return e
+		}
+		e := map[string]any{"downstream": []WSHeartbeatSnapshot{}}
+		identities[userID] = e
+		return e
+	}
+	for userID, client := range m.upstreamClients {
+		if hb := client.heartbeat.Load(); hb != nil {
+			entry(userID)["upstream"] = hb.snapshot()
+		}
+	}
+	for userID, sessions := range m.downstreamSessions {
+		e := entry(userID)
+		snapshots := e["downstream"].([]WSHeartbeatSnapshot)
+		for session := range sessions {
+			if session != nil && session.heartbeat != nil {
+				snapshots = append(snapshots, session.heartbeat.snapshot())
+			}
+		}
+		e["downstream"] = snapshots
+	}
+	return map[string]any{
+		"upstreamIntervalSeconds":   m.heartbeat.UpstreamInterval.Seconds(),
+		"downstreamIntervalSeconds": m.heartbeat.DownstreamInterval.Seconds(),
+		"maxMissed":                 m.heartbeat.MaxMissed,
+		"deadUpstream":              m.deadUpstream.Load(),
+		"deadDownstream":            m.deadDownstream.Load(),
+		"identities":                identities,
 	}
 }
 
@@ -309,7 +1056,7 @@ func (m *UpstreamWebSocketManager) scheduleCloseUpstreamLocked(userID string) {
 	if t := m.pendingCloseTasks[userID]; t != nil {
 		t.Stop()
 	}
-	m.pendingCloseTasks[userID] = time.AfterFunc(wsCloseDelay, func() {
+	m.pendingCloseTasks[userID] = time.AfterFunc(m.scheduler.closeDelayFor(userID), func() {
 		m.mu.Lock()
 		_, hasSessions := m.downstreamSessions[userID]
 		if hasSessions {
@@ -319,11 +1066,27 @@ func (m *UpstreamWebSocketManager) scheduleCloseUpstreamLocked(userID string) {
 		}
 		delete(m.pendingCloseTasks, userID)
 		m.mu.Unlock()
-		m.CloseUpstreamConnection(userID)
+		// 其他副本上仍有该身份的下游会话，或身份需要保活：继续保持上游连接，稍后再检查。
+		if m.cluster.hasRemoteInterest(userID) || m.keptAlive(userID) {
+			m.mu.Lock()
+			_, hasSessions := m.downstreamSessions[userID]
+			_, hasClient := m.upstreamClients[userID]
+			if !hasSessions && hasClient {
+				m.scheduleCloseUpstreamLocked(userID)
+			}
+			m.mu.Unlock()
+			return
+		}
+		m.closeUpstreamConnection(userID, WSConnectionEventDisconnect, "idle")
 	})
 }
 
 func (m *UpstreamWebSocketManager) CloseUpstreamConnection(userID string) {
+	m.closeUpstreamConnection(userID, WSConnectionEventDisconnect, "closed")
+}
+
+// closeUpstreamConnection 主动关闭身份的上游连接，存在连接时按 eventType/reason 记录连接事件。
+func (m *UpstreamWebSocketManager) closeUpstreamConnection(userID string, eventType string, reason string) {
 	userID = strings.TrimSpace(userID)
 	if userID == "" {
 		return
@@ -331,12 +1094,23 @@ func (m *UpstreamWebSocketManager) CloseUpstreamConnection(userID string) {
 
 	m.mu.Lock()
 	client := m.upstreamClients[userID]
+	if client != nil {
+		m.recordConnectionEventLocked(eventType, userID, client.wsURL, reason, m.connectionAgeLocked(userID))
+	}
 	delete(m.upstreamClients, userID)
 	delete(m.connectionCreateMilli, userID)
+	m.scheduler.forget(userID)
+	m.stopReconnectLocked(userID)
+	delete(m.reconnectAttempts, userID)
+	if _, hasSessions := m.downstreamSessions[userID]; !hasSessions {
+		delete(m.signMessages, userID)
+		delete(m.replayBuffers, userID)
+	}
 	m.mu.Unlock()
 
 	if client != nil {
 		client.CloseExpected()
+		m.cluster.release(userID)
 	}
 }
 
@@ -347,7 +1121,10 @@ func (m *UpstreamWebSocketManager) snapshotDownstream(userID string) []*Downstre
 }
 
 func (m *UpstreamWebSocketManager) snapshotDownstreamLocked(userID string) []*DownstreamSession {
-	sessions := m.downstreamSessions[userID]
+	return snapshotSessionSet(m.downstreamSessions[userID])
+}
+
+func snapshotSessionSet(sessions map[*DownstreamSession]struct{}) []*DownstreamSession {
 	if sessions == nil {
 		return nil
 	}
@@ -366,6 +1143,15 @@ func (m *UpstreamWebSocketManager) createUpstreamConnection(userID string, signM
 	if existing != nil {
 		return existing
 	}
+	if owner, ok := m.cluster.acquire(userID); !ok {
+		// 租约已被其他副本持有：由 owner 建立连接，本副本不重复 sign（否则会触发上游 forceout）。
+		if owner != "" {
+			if err := m.cluster.forward(owner, wsClusterEnvelope{Type: wsClusterSign, UserID: userID, Frame: signMessage}); err != nil {
+				slog.Warn("转发 sign 到 owner 副本失败", "userID", userID, "owner", owner, "error", err)
+			}
+		}
+		return nil
+	}
 
 	upstreamURL := m.getUpstreamWebSocketURL(context.Background())
 	client := NewUpstreamWebSocketClient(userID, upstreamURL, m)
@@ -381,6 +1167,7 @@ func (m *UpstreamWebSocketManager) createUpstreamConnection(userID string, signM
 	}
 	m.upstreamClients[userID] = client
 	m.connectionCreateMilli[userID] = time.Now().UnixMilli()
+	m.touchIdentityLocked(userID)
 	m.mu.Unlock()
 
 	client.ConnectAsync()
@@ -435,11 +1222,17 @@ type UpstreamWebSocketClient struct {
 	conn          *websocket.Conn
 	connected     bool
 	pending       []string
+	pendingOutbox []int64 // 与 pending 按下标对应的发送队列 ID（0 表示未持久化）
+	flushing      bool    // 连接建立后正在补发 pending，期间新消息继续排队以保证 sign 先于业务消息
 	expectedClose atomic.Bool
+	// alive 标记本连接已收到有效上游帧，首次置位时清零重连计数。
+	alive atomic.Bool
 
 	writeMu   sync.Mutex
 	closeOnce sync.Once
 	done      chan struct{}
+	// heartbeat 记录当前连接最近活动时间与 ping RTT，连接建立后才非空。
+	heartbeat atomic.Pointer[wsHeartbeat]
 }
 
 func NewUpstreamWebSocketClient(userID string, wsURL string, manager *UpstreamWebSocketManager) *UpstreamWebSocketClient {
@@ -468,7 +1261,7 @@ func (c *UpstreamWebSocketClient) connect() {
 	conn, _, err := websocket.DefaultDialer.DialContext(ctx, c.wsURL, nil)
 	if err != nil {
 		if c.manager != nil {
-			c.manager.HandleUpstreamDisconnect(c.userID)
+			c.manager.handleUpstreamDisconnect(c.userID, "dial failed: "+err.Error())
 		}
 		return
 	}
@@ -476,22 +1269,62 @@ func (c *UpstreamWebSocketClient) connect() {
 	c.mu.Lock()
 	c.conn = conn
 	c.connected = true
+	c.flushing = true
 	c.mu.Unlock()
 
+	hb := newWSHeartbeat()
+	c.heartbeat.Store(hb)
 	_ = conn.SetReadDeadline(time.Now().Add(wsUpstreamConnectionLost))
-	conn.SetPongHandler(func(string) error {
+	conn.SetPongHandler(func(appData string) error {
+		hb.onPong(appData)
 		return conn.SetReadDeadline(time.Now().Add(wsUpstreamConnectionLost))
 	})
+	conn.SetPingHandler(func(appData string) error {
+		hb.touch()
+		_ = conn.SetReadDeadline(time.Now().Add(wsUpstreamConnectionLost))
+		err := conn.WriteControl(websocket.PongMessage, []byte(appData), time.Now().Add(wsUpstreamWriteDeadline))
+		if err == websocket.ErrCloseSent {
+			return nil
+		}
+		if ne, ok := err.(net.Error); ok && ne.Timeout() {
+			return nil
+		}
+		return err
+	})
+
+	stopPing := make(chan struct{})
+	var heartbeatDead atomic.Bool
+	if c.manager != nil {
+		if hbCfg := c.manager.HeartbeatConfig(); hbCfg.UpstreamInterval > 0 {
+			go runWSPingLoop(hb, hbCfg.UpstreamInterval, hbCfg.MaxMissed, stopPing, func(payload []byte) error {
+				return conn.WriteControl(websocket.PingMessage, payload, time.Now().Add(wsUpstreamWriteDeadline))
+			}, func(idle time.Duration) {
+				c.manager.deadUpstream.Add(1)
+				heartbeatDead.Store(true)
+				slog.Warn("上游连接心跳超时，主动断开", "userId", c.userID, "idle", idle)
+				c.CloseUnexpected()
+			})
+		}
+	}
 
 	c.flushPending()
+	if c.manager != nil {
+		c.manager.HandleUpstreamConnected(c.userID)
+	}
 
+	var readErr error
 	for {
 		_, data, err := conn.ReadMessage()
 		if err != nil {
+			readErr = err
 			break
 		}
+		// 任意业务帧都视为连接存活，刷新读超时以免长时间只收不发的连接被误判断开。
+		hb.touch()
+		_ = conn.SetReadDeadline(time.Now().Add(wsUpstreamConnectionLost))
 		c.onMessage(string(data))
 	}
+	close(stopPing)
 
 	c.mu.Lock()
 	c.connected = false
@@ -501,22 +1334,40 @@ func (c *UpstreamWebSocketClient) connect() {
 		return
 	}
 	if c.manager != nil {
-		c.manager.HandleUpstreamDisconnect(c.userID)
+		reason := "upstream closed: " + readErr.Error()
+		if heartbeatDead.Load() {
+			reason = "heartbeat timeout"
+		}
+		c.manager.handleUpstreamDisconnect(c.userID, reason)
 	}
 }
 
 func (c *UpstreamWebSocketClient) SendMessage(message string) {
+	_ = c.sendTracked(message, 0)
+}
+
+// sendTracked 发送消息；连接未就绪时排队等待连接后补发（返回 nil），直接写出时返回写入结果。
+func (c *UpstreamWebSocketClient) sendTracked(message string, outboxID int64) error {
 	if strings.TrimSpace(message) == "" {
-		return
+		return nil
+	}
+
+	select {
+	case <-c.done:
+		err := fmt.Errorf("上游连接已关闭")
+		c.reportDelivery(outboxID, err)
+		return err
+	default:
 	}
 
 	c.mu.Lock()
 	conn := c.conn
 	connected := c.connected
-	if !connected || conn == nil {
+	if !connected || conn == nil || c.flushing {
 		c.pending = append(c.pending, message)
+		c.pendingOutbox = append(c.pendingOutbox, outboxID)
 		c.mu.Unlock()
-		return
+		return nil
 	}
 	c.mu.Unlock()
 
@@ -524,33 +1375,75 @@ func (c *UpstreamWebSocketClient) SendMessage(message string) {
 	_ = conn.SetWriteDeadline(time.Now().Add(wsUpstreamWriteDeadline))
 	err := conn.WriteMessage(websocket.TextMessage, []byte(message))
 	c.writeMu.Unlock()
+	c.reportDelivery(outboxID, err)
 	if err != nil {
 		c.CloseUnexpected()
+		return err
 	}
+	c.recordSent(message)
+	return nil
 }
 
-func (c *UpstreamWebSocketClient) flushPending() {
+// ready 返回连接是否已建立且排队消息（含 sign）已全部补发。
+func (c *UpstreamWebSocketClient) ready() bool {
 	c.mu.Lock()
-	conn := c.conn
-	queue := append([]string(nil), c.pending...)
-	c.pending = nil
-	c.mu.Unlock()
-	if conn == nil {
-		return
-	}
+	defer c.mu.Unlock()
+	return c.connected && c.conn != nil && !c.flushing
+}
 
-	for _, msg := range queue {
-		c.writeMu.Lock()
-		_ = conn.SetWriteDeadline(time.Now().Add(wsUpstreamWriteDeadline))
-		err := conn.WriteMessage(websocket.TextMessage, []byte(msg))
-		c.writeMu.Unlock()
-		if err != nil {
-			c.CloseUnexpected()
+func (c *UpstreamWebSocketClient) flushPending() {
+	for {
+		c.mu.Lock()
+		conn := c.conn
+		queue := append([]string(nil), c.pending...)
+		outboxIDs := append([]int64(nil), c.pendingOutbox...)
+		c.pending = nil
+		c.pendingOutbox = nil
+		if conn == nil || len(queue) == 0 {
+			c.flushing = false
+			c.mu.Unlock()
 			return
 		}
+		c.mu.Unlock()
+
+		for i, msg := range queue {
+			var outboxID int64
+			if i < len(outboxIDs) {
+				outboxID = outboxIDs[i]
+			}
+			c.writeMu.Lock()
+			_ = conn.SetWriteDeadline(time.Now().Add(wsUpstreamWriteDeadline))
+			err := conn.WriteMessage(websocket.TextMessage, []byte(msg))
+			c.writeMu.Unlock()
+			c.reportDelivery(outboxID, err)
+			if err != nil {
+				for j := i + 1; j < len(outboxIDs); j++ {
+					c.reportDelivery(outboxIDs[j], fmt.Errorf("上游连接关闭，消息未发送"))
+				}
+				c.mu.Lock()
+				c.flushing = false
+				c.mu.Unlock()
+				c.CloseUnexpected()
+				return
+			}
+			c.recordSent(msg)
+		}
 	}
 }
 
+func (c *UpstreamWebSocketClient) recordSent(message string) {
+	if c.manager != nil {
+		c.manager.recordFrame(c.userID, WSRecordUpstreamOut, message)
+	}
+}
+
+func (c *UpstreamWebSocketClient) reportDelivery(outboxID int64, err error) {
+	if outboxID <= 0 || c.manager == nil {
+		return
+	}
+	c.manager.markOutboxDelivery(outboxID, err)
+}
+
 func (c *UpstreamWebSocketClient) onMessage(message string) {
 	// 记录收到的上游消息（截断过长消息以避免日志过大）
 	logMessage := message
@@ -559,137 +1452,23 @@ func (c *UpstreamWebSocketClient) onMessage(message string) {
 	}
 	slog.Debug("收到上游WS消息", "userID", c.userID, "message", logMessage)
 
-	var node map[string]any
-	if err := json.Unmarshal([]byte(message), &node); err == nil {
-		code := toInt(node["code"])
-		slog.Debug("解析上游消息", "userID", c.userID, "code", code)
-
-		if code == -3 && toBool(node["forceout"]) {
-			slog.Warn("收到forceout消息", "userID", c.userID, "message", logMessage)
-			if c.manager != nil {
-				c.manager.HandleForceout(c.userID, message)
-			}
-			return
-		}
-
-		if code == 15 {
-			target := strings.TrimSpace(toString(node["sel_userid"]))
-			slog.Debug("收到用户信息消息(code=15)", "userID", c.userID, "targetUserID", target)
-			if target != "" && c.manager != nil && c.manager.cache != nil {
-				c.manager.cache.SaveUserInfo(CachedUserInfo{
-					UserID:   target,
-					Nickname: toString(node["sel_userNikename"]),
-					Gender:   toString(node["sel_userSex"]),
-					Age:      toString(node["sel_userAge"]),
-					Address:  toString(node["sel_userAddress"]),
-				})
-			}
-			if target != "" && target != c.userID && c.manager != nil && c.manager.archive != nil {
-				c.manager.archive.PersistUserList(context.Background(), c.userID, []map[string]any{
-					buildMatchedUserArchiveSnapshot(node, target),
-				}, UserArchiveListSourceHistory)
-			}
-		}
-
-		if code == 7 {
-			fromUser := mapGetMap(node, "fromuser")
-			toUser := mapGetMap(node, "touser")
-			fromUserID := strings.TrimSpace(toString(fromUser["id"]))
-			toUserID := strings.TrimSpace(toString(toUser["id"]))
-			content := strings.TrimSpace(toString(firstNonNil(fromUser["content"], node["content"])))
-			tm := strings.TrimSpace(toString(firstNonNil(fromUser["time"], node["time"])))
-			msgType := strings.TrimSpace(toString(firstNonNil(fromUser["type"], node["type"])))
-			tid := strings.TrimSpace(toString(firstNonNil(fromUser["Tid"], fromUser["tid"], node["Tid"], node["tid"])))
-			if msgType == "" {
-				msgType = "text"
-			}
-
-			// 记录聊天消息（截断内容以避免日志过大）
-			logContent := content
-			if len(logContent) > 100 {
-				logContent = logContent[:100] + "...(截断)"
-			}
-			slog.Info("收到聊天消息(code=7)", "userID", c.userID, "fromUserID", fromUserID, "toUserID", toUserID, "msgType", msgType, "tid", tid, "content", logContent)
-
-			if fromUserID != "" && toUserID != "" && content != "" && tm != "" && c.manager != nil && c.manager.cache != nil {
-				last := CachedLastMessage{
-					FromUserID: fromUserID,
-					ToUserID:   toUserID,
-					Content:    content,
-					Type:       msgType,
-					Time:       tm,
-				}
-				c.manager.cache.SaveLastMessage(last)
-
-				// 兼容：上游返回的 toUserId 有时不是本地身份 userId，补写会话 key，保证列表可命中。
-				if c.userID != fromUserID && c.userID != toUserID {
-					c.manager.cache.SaveLastMessage(CachedLastMessage{
-						FromUserID: fromUserID,
-						ToUserID:   c.userID,
-						Content:    content,
-						Type:       msgType,
-						Time:       tm,
-					})
-					c.manager.cache.SaveLastMessage(CachedLastMessage{
-						FromUserID: c.userID,
-						ToUserID:   toUserID,
-						Content:    content,
-						Type:       msgType,
-						Time:       tm,
-					})
-				}
-			}
-
-			if tid != "" && content != "" && tm != "" && c.manager != nil && c.manager.history != nil {
-				normalizedFrom := fromUserID
-				normalizedTo := toUserID
-				localMD5 := md5HexLower(c.userID)
-				if localMD5 != "" {
-					if strings.EqualFold(normalizedFrom, localMD5) {
-						normalizedFrom = c.userID
-					}
-					if strings.EqualFold(normalizedTo, localMD5) {
-						normalizedTo = c.userID
-					}
-				}
-
-				historyMsg := map[string]any{
-					"Tid":     tid,
-					"id":      normalizedFrom,
-					"toid":    normalizedTo,
-					"content": content,
-					"time":    tm,
-				}
-				if nickname := strings.TrimSpace(toString(firstNonNil(fromUser["nickname"], fromUser["name"], node["nickname"], node["name"]))); nickname != "" {
-					historyMsg["nickname"] = nickname
-				}
-
-				conversationKeys := make(map[string]struct{}, 3)
-				if key := generateConversationKey(normalizedFrom, normalizedTo); key != "" {
-					conversationKeys[key] = struct{}{}
-				}
-
-				// 兼容：上游返回的 toUserId 有时不是本地身份 userId，补写会话 key，保证历史查询可命中。
-				if strings.TrimSpace(c.userID) != "" && c.userID != normalizedFrom && c.userID != normalizedTo {
-					if key := generateConversationKey(normalizedFrom, c.userID); key != "" {
-						conversationKeys[key] = struct{}{}
-					}
-					if key := generateConversationKey(c.userID, normalizedTo); key != "" {
-						conversationKeys[key] = struct{}{}
-					}
-				}
-
-				for key := range conversationKeys {
-					c.manager.history.SaveMessages(context.Background(), key, []map[string]any{historyMsg})
-				}
-			}
+	if c.manager == nil {
+		return
+	}
+	c.manager.recordFrame(c.userID, WSRecordUpstreamIn, message)
+	frame := newUpstreamFrame(c.userID, message)
+	if frame.Node != nil {
+		slog.Debug("解析上游消息", "userID", c.userID, "code", frame.Code)
+		if c.alive.CompareAndSwap(false, true) {
+			c.manager.confirmUpstreamAlive(c.userID, c)
 		}
 	}
-
-	if c.manager != nil {
-		slog.Debug("广播上游消息到下游", "userID", c.userID)
-		c.manager.BroadcastToDownstream(c.userID, message)
+	if !c.manager.pipeline.Process(frame) {
+		return
 	}
+
+	slog.Debug("广播上游消息到下游", "userID", c.userID)
+	c.manager.broadcastUpstreamFrame(c.userID, frame.Raw)
 }
 
 func (c *UpstreamWebSocketClient) CloseExpected() {
@@ -709,8 +1488,16 @@ func (c *UpstreamWebSocketClient) Close() {
 		conn := c.conn
 		c.conn = nil
 		c.connected = false
+		dropped := c.pendingOutbox
+		c.pending = nil
+		c.pendingOutbox = nil
 		c.mu.Unlock()
 
+		// 未来得及写出的排队消息回写失败，等待下次连接建立后补发。
+		for _, id := range dropped {
+			c.reportDelivery(id, fmt.Errorf("上游连接关闭，消息未发送"))
+		}
+
 		if conn == nil {
 			return
 		}
@@ -721,6 +1508,51 @@ func (c *UpstreamWebSocketClient) Close() {
 	})
 }
 
+// reconnectBackoffDelay 计算第 attempt 次重连的等待时间：base*2^(attempt-1)，封顶 max，并在 [d/2, d] 内随机抖动。
+func reconnectBackoffDelay(attempt int) time.Duration {
+	delay := wsReconnectBaseDelay
+	if delay <= 0 {
+		delay = wsReconnectInitialDelay
+	}
+	for i := 1; i < attempt && delay < wsReconnectMaxDelay; i++ {
+		delay *= 2
+	}
+	if wsReconnectMaxDelay > 0 && delay > wsReconnectMaxDelay {
+		delay = wsReconnectMaxDelay
+	}
+	half := delay / 2
+	return half + time.Duration(rand.Int64N(int64(delay-half)+1))
+}
+
+func buildForceoutRejectMessage(remainingSeconds int64) string {
+	return encodeLocalFrame(&protocol.Reject{
+		Content:  fmt.Sprintf("由于重复登录，您的连接被暂时禁止，请%d秒后再试", remainingSeconds),
+		Forceout: true,
+	})
+}
+
+func buildEvictMessage() string {
+	return encodeLocalFrame(&protocol.Evict{Content: "由于新身份连接，您已被自动断开", Evicted: true})
+}
+
+// encodeLocalFrame 编码本地下游状态帧；类型由代码构造，编码失败属于编程错误，返回空对象兜底。
+func encodeLocalFrame(msg protocol.Message) string {
+	b, err := protocol.Encode(msg)
+	if err != nil {
+		slog.Error("编码本地状态帧失败", "kind", msg.Kind(), "error", err)
+		return "{}"
+	}
+	return string(b)
+}
+
+func buildReconnectingMessage(attempt int, delay time.Duration) string {
+	return fmt.Sprintf("{\"code\":-7,\"content\":\"上游连接已断开，正在第%d次重连\",\"reconnecting\":true,\"attempt\":%d,\"delayMs\":%d}", attempt, attempt, delay.Milliseconds())
+}
+
+func buildReconnectedMessage(attempts int) string {
+	return fmt.Sprintf("{\"code\":-8,\"content\":\"上游连接已恢复\",\"reconnected\":true,\"attempts\":%d}", attempts)
+}
+
 func toInt(v any) int {
 	switch t := v.(type) {
 	case int:
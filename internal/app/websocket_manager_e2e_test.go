@@ -0,0 +1,97 @@
+package app
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"liao/internal/fakeupstream"
+)
+
+func startFakeUpstream(t *testing.T) *fakeupstream.Server {
+	t.Helper()
+
+	fake, err := fakeupstream.Start("127.0.0.1:0", fakeupstream.DefaultBots()...)
+	if err != nil {
+		t.Fatalf("start fake upstream: %v", err)
+	}
+	t.Cleanup(func() { _ = fake.Close() })
+
+	oldBase := wsWebServiceRandServerBase
+	wsWebServiceRandServerBase = fake.RandServerBase()
+	t.Cleanup(func() { wsWebServiceRandServerBase = oldBase })
+	return fake
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(3 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestUpstreamWebSocketManager_E2E_FakeUpstream(t *testing.T) {
+	fake := startFakeUpstream(t)
+	bot := fakeupstream.DefaultBots()[0]
+
+	cache := &spyUserInfoCache{}
+	forceout := NewForceoutManager()
+	m := NewUpstreamWebSocketManager(&http.Client{Timeout: 2 * time.Second}, "ws://unused", forceout, cache, nil)
+	t.Cleanup(m.CloseAllConnections)
+
+	session, client := newDownstreamPair(t)
+	m.RegisterDownstream("u1", session, `{"act":"sign","id":"u1","name":"Me","userSex":"男"}`)
+	waitFor(t, "upstream sign", func() bool { return fake.Connected("u1") })
+
+	// 匹配：上游返回 code=15，内置阶段写入用户信息缓存并广播给下游。
+	m.SendToUpstream("u1", `{"act":"random","id":"u1","userAge":"0"}`)
+	if got := readDownstreamCode(t, client, 15); got["sel_userid"] != bot.ID {
+		t.Fatalf("unexpected match frame: %v", got)
+	}
+
+	// 私聊：上游回显自己的消息并由机器人回复，均为 code=7。
+	m.SendToUpstream("u1", `{"act":"touser_`+bot.ID+`_`+bot.Nickname+`","id":"u1","msg":"hello"}`)
+	readDownstreamCode(t, client, 7)
+	reply := readDownstreamCode(t, client, 7)
+	if from, _ := reply["fromuser"].(map[string]any); from["id"] != bot.ID {
+		t.Fatalf("unexpected reply frame: %v", reply)
+	}
+
+	cache.mu.Lock()
+	userInfos, messages := len(cache.userInfos), len(cache.messages)
+	cache.mu.Unlock()
+	if userInfos != 1 || messages < 2 {
+		t.Fatalf("userInfos=%d messages=%d", userInfos, messages)
+	}
+
+	// 历史消息接口经 HTTP 访问同一个 fake。
+	a := &App{httpClient: fake.Client()}
+	form := url.Values{"myUserID": {"u1"}, "UserToID": {bot.ID}, "isFirst": {"1"}, "firstTid": {"0"}}
+	req := httptest.NewRequest(http.MethodPost, "/api/getMessageHistory", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr := httptest.NewRecorder()
+	a.handleGetMessageHistory(rr, req)
+	var page struct {
+		Contents []map[string]any `json:"contents_list"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &page); err != nil || len(page.Contents) != 2 {
+		t.Fatalf("status=%d body=%s err=%v", rr.Code, rr.Body.String(), err)
+	}
+
+	// forceout：上游推送 code=-3 后本地进入禁止期并通知下游。
+	if err := fake.Forceout("u1"); err != nil {
+		t.Fatalf("Forceout: %v", err)
+	}
+	if got := readDownstreamCode(t, client, -3); got["forceout"] != true {
+		t.Fatalf("unexpected forceout frame: %v", got)
+	}
+	waitFor(t, "forceout ban", func() bool { return forceout.IsForbidden("u1") })
+}
@@ -0,0 +1,327 @@
+package app
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// newDownstreamPair 创建一对真实 WebSocket 连接：服务端一侧包装为 DownstreamSession，客户端一侧用于读取下游收到的帧。
+func newDownstreamPair(t *testing.T) (*DownstreamSession, *websocket.Conn) {
+	t.Helper()
+
+	serverConns := make(chan *websocket.Conn, 1)
+	upgrader := websocket.Upgrader{CheckOrigin: func(*http.Request) bool { return true }}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		serverConns <- conn
+	}))
+	t.Cleanup(srv.Close)
+
+	client, _, err := websocket.DefaultDialer.Dial(toWSURL(srv.URL), nil)
+	if err != nil {
+		t.Fatalf("dial downstream: %v", err)
+	}
+	t.Cleanup(func() { _ = client.Close() })
+
+	select {
+	case conn := <-serverConns:
+		t.Cleanup(func() { _ = conn.Close() })
+		return NewDownstreamSession(&wsDownstreamTransport{conn: conn}), client
+	case <-time.After(2 * time.Second):
+		t.Fatalf("timeout waiting downstream upgrade")
+	}
+	return nil, nil
+}
+
+func readDownstreamCode(t *testing.T, conn *websocket.Conn, want int) map[string]any {
+	t.Helper()
+
+	_ = conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("read downstream (want code=%d): %v", want, err)
+		}
+		var node map[string]any
+		if err := json.Unmarshal(data, &node); err != nil {
+			continue
+		}
+		if toInt(node["code"]) == want {
+			return node
+		}
+	}
+}
+
+func setFastReconnect(t *testing.T, maxAttempts int) {
+	t.Helper()
+
+	oldAttempts := wsReconnectMaxAttempts
+	oldDelayFn := wsReconnectDelayFn
+	wsReconnectMaxAttempts = maxAttempts
+	wsReconnectDelayFn = func(int) time.Duration { return 10 * time.Millisecond }
+	t.Cleanup(func() {
+		wsReconnectMaxAttempts = oldAttempts
+		wsReconnectDelayFn = oldDelayFn
+	})
+}
+
+func TestReconnectBackoffDelay_GrowsAndCaps(t *testing.T) {
+	oldBase, oldMax := wsReconnectBaseDelay, wsReconnectMaxDelay
+	wsReconnectBaseDelay = 100 * time.Millisecond
+	wsReconnectMaxDelay = 1 * time.Second
+	t.Cleanup(func() {
+		wsReconnectBaseDelay = oldBase
+		wsReconnectMaxDelay = oldMax
+	})
+
+	cases := []struct {
+		attempt  int
+		min, max time.Duration
+	}{
+		{1, 50 * time.Millisecond, 100 * time.Millisecond},
+		{2, 100 * time.Millisecond, 200 * time.Millisecond},
+		{4, 400 * time.Millisecond, 800 * time.Millisecond},
+		{10, 500 * time.Millisecond, 1 * time.Second},
+	}
+	for _, tc := range cases {
+		for i := 0; i < 20; i++ {
+			got := reconnectBackoffDelay(tc.attempt)
+			if got < tc.min || got > tc.max {
+				t.Fatalf("attempt=%d delay=%v, want in [%v,%v]", tc.attempt, got, tc.min, tc.max)
+			}
+		}
+	}
+}
+
+func TestUpstreamWebSocketManager_Reconnect_ReplaysSignAndNotifies(t *testing.T) {
+	setFastReconnect(t, 3)
+
+	var connCount atomic.Int32
+	signs := make(chan string, 4)
+	tracker := &wsConnTracker{}
+	srv := newUpstreamWSServer(t, func(conn *websocket.Conn) {
+		tracker.add(conn)
+		n := connCount.Add(1)
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		signs <- string(data)
+		if n == 1 {
+			// 第一条连接在收到 sign 后异常断开，触发自动重连。
+			_ = conn.Close()
+			return
+		}
+		// 重连后的连接推送一条有效帧，重连计数才会清零。
+		_ = conn.WriteMessage(websocket.TextMessage, []byte(`{"code":15,"msg":"ok"}`))
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	})
+	t.Cleanup(func() {
+		tracker.closeAll()
+		srv.Close()
+	})
+
+	m := NewUpstreamWebSocketManager(nil, toWSURL(srv.URL), nil, nil, nil)
+	t.Cleanup(m.CloseAllConnections)
+
+	session, client := newDownstreamPair(t)
+	sign := `{"act":"sign","id":"u1"}`
+	m.RegisterDownstream("u1", session, sign)
+
+	reconnecting := readDownstreamCode(t, client, -7)
+	if !toBool(reconnecting["reconnecting"]) || toInt(reconnecting["attempt"]) != 1 {
+		t.Fatalf("unexpected reconnecting frame: %v", reconnecting)
+	}
+	reconnected := readDownstreamCode(t, client, -8)
+	if !toBool(reconnected["reconnected"]) {
+		t.Fatalf("unexpected reconnected frame: %v", reconnected)
+	}
+
+	for i := 0; i < 2; i++ {
+		select {
+		case got := <-signs:
+			if got != sign {
+				t.Fatalf("sign[%d]=%q, want %q", i, got, sign)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("timeout waiting sign %d", i)
+		}
+	}
+
+	readDownstreamCode(t, client, 15)
+
+	m.mu.Lock()
+	_, hasUpstream := m.upstreamClients["u1"]
+	_, hasSessions := m.downstreamSessions["u1"]
+	attempts := m.reconnectAttempts["u1"]
+	m.mu.Unlock()
+	if !hasUpstream || !hasSessions {
+		t.Fatalf("expected upstream and downstream kept after reconnect")
+	}
+	if attempts != 0 {
+		t.Fatalf("attempts=%d, want reset to 0", attempts)
+	}
+}
+
+func TestUpstreamWebSocketManager_Reconnect_AcceptThenCloseStopsAtMax(t *testing.T) {
+	setFastReconnect(t, 2)
+
+	var connCount atomic.Int32
+	tracker := &wsConnTracker{}
+	srv := newUpstreamWSServer(t, func(conn *websocket.Conn) {
+		tracker.add(conn)
+		connCount.Add(1)
+		// 接受连接、读取 sign 后立即关闭，不推送任何业务帧。
+		_, _, _ = conn.ReadMessage()
+		_ = conn.Close()
+	})
+	t.Cleanup(func() {
+		tracker.closeAll()
+		srv.Close()
+	})
+
+	m := NewUpstreamWebSocketManager(nil, toWSURL(srv.URL), nil, nil, nil)
+	t.Cleanup(m.CloseAllConnections)
+
+	session, _ := newDownstreamPair(t)
+	m.RegisterDownstream("u1", session, `{"act":"sign","id":"u1"}`)
+
+	deadline := time.Now().Add(3 * time.Second)
+	for time.Now().Before(deadline) {
+		m.mu.Lock()
+		_, hasSessions := m.downstreamSessions["u1"]
+		_, hasTask := m.reconnectTasks["u1"]
+		m.mu.Unlock()
+		if !hasSessions && !hasTask {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if got := connCount.Load(); got != 3 {
+		t.Fatalf("connections=%d, want 3 (initial + 2 attempts)", got)
+	}
+	m.mu.Lock()
+	_, hasSessions := m.downstreamSessions["u1"]
+	m.mu.Unlock()
+	if hasSessions {
+		t.Fatalf("expected downstream closed after reconnect attempts exhausted")
+	}
+}
+
+func TestUpstreamWebSocketManager_Reconnect_GivesUpAfterMaxAttempts(t *testing.T) {
+	setFastReconnect(t, 2)
+
+	m := NewUpstreamWebSocketManager(nil, "ws://127.0.0.1:0", nil, nil, nil)
+	t.Cleanup(m.CloseAllConnections)
+
+	session, client := newDownstreamPair(t)
+	m.RegisterDownstream("u1", session, `{"act":"sign","id":"u1"}`)
+
+	readDownstreamCode(t, client, -7)
+	second := readDownstreamCode(t, client, -7)
+	if toInt(second["attempt"]) != 2 {
+		t.Fatalf("unexpected second attempt frame: %v", second)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		m.mu.Lock()
+		_, hasSessions := m.downstreamSessions["u1"]
+		_, hasSign := m.signMessages["u1"]
+		m.mu.Unlock()
+		if !hasSessions && !hasSign {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("expected downstream closed after reconnect attempts exhausted")
+}
+
+func TestUpstreamWebSocketManager_Reconnect_RespectsForceoutBan(t *testing.T) {
+	setFastReconnect(t, 3)
+
+	forceout := NewForceoutManager()
+	m := NewUpstreamWebSocketManager(nil, "ws://unused", forceout, nil, nil)
+	t.Cleanup(m.CloseAllConnections)
+
+	session, client := newDownstreamPair(t)
+	m.mu.Lock()
+	m.downstreamSessions["u1"] = map[*DownstreamSession]struct{}{session: {}}
+	m.signMessages["u1"] = `{"act":"sign","id":"u1"}`
+	m.mu.Unlock()
+
+	forceout.AddForceoutUser("u1")
+	m.HandleUpstreamDisconnect("u1")
+
+	m.mu.Lock()
+	_, hasTask := m.reconnectTasks["u1"]
+	_, hasSessions := m.downstreamSessions["u1"]
+	m.mu.Unlock()
+	if hasTask || hasSessions {
+		t.Fatalf("expected no reconnect for forbidden user")
+	}
+
+	_ = client.SetReadDeadline(time.Now().Add(time.Second))
+	if _, data, err := client.ReadMessage(); err == nil && strings.Contains(string(data), `"code":-7`) {
+		t.Fatalf("unexpected reconnecting frame: %s", data)
+	}
+}
+
+func TestUpstreamWebSocketManager_Reconnect_StopsWhenDownstreamGone(t *testing.T) {
+	oldAttempts := wsReconnectMaxAttempts
+	oldDelayFn := wsReconnectDelayFn
+	wsReconnectMaxAttempts = 3
+	wsReconnectDelayFn = func(int) time.Duration { return time.Hour }
+	t.Cleanup(func() {
+		wsReconnectMaxAttempts = oldAttempts
+		wsReconnectDelayFn = oldDelayFn
+	})
+
+	m := NewUpstreamWebSocketManager(nil, "ws://unused", nil, nil, nil)
+	session := &DownstreamSession{}
+	m.mu.Lock()
+	m.downstreamSessions["u1"] = map[*DownstreamSession]struct{}{session: {}}
+	m.signMessages["u1"] = `{"act":"sign","id":"u1"}`
+	m.mu.Unlock()
+
+	m.HandleUpstreamDisconnect("u1")
+	m.mu.Lock()
+	_, hasTask := m.reconnectTasks["u1"]
+	m.mu.Unlock()
+	if !hasTask {
+		t.Fatalf("expected reconnect scheduled")
+	}
+
+	m.UnregisterDownstream("u1", session)
+	m.runReconnect("u1")
+
+	m.mu.Lock()
+	_, hasUpstream := m.upstreamClients["u1"]
+	_, hasAttempts := m.reconnectAttempts["u1"]
+	m.mu.Unlock()
+	if hasUpstream || hasAttempts {
+		t.Fatalf("expected reconnect abandoned without downstream")
+	}
+
+	m.CloseAllConnections()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.reconnectTasks) != 0 || len(m.signMessages) != 0 {
+		t.Fatalf("expected reconnect state reset")
+	}
+}
@@ -460,7 +460,7 @@ func TestUpstreamWebSocketManager_BroadcastToDownstream_RemovesZombieSession(t *
 	}
 	_ = conn.Close()
 
-	session := &DownstreamSession{conn: conn}
+	session := NewDownstreamSession(&wsDownstreamTransport{conn: conn})
 	manager := NewUpstreamWebSocketManager(nil, "ws://unused", nil, nil, nil)
 	t.Cleanup(manager.CloseAllConnections)
 
@@ -0,0 +1,125 @@
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestUpstreamWebSocketManager_ObserverDoesNotKeepUpstream(t *testing.T) {
+	m := NewUpstreamWebSocketManager(&http.Client{Timeout: time.Second}, "ws://unused", nil, nil, nil)
+	t.Cleanup(m.CloseAllConnections)
+
+	observer, observerClient := newDownstreamPair(t)
+	m.RegisterObserver("u1", observer)
+	stats := m.GetConnectionStats()
+	if stats["upstream"] != 0 || stats["downstream"] != 0 {
+		t.Fatalf("observer should not create upstream: %v", stats)
+	}
+	if observers := stats["observers"].(map[string]any); observers["total"] != 1 || observers["identities"].(map[string]int)["u1"] != 1 {
+		t.Fatalf("observers=%v", observers)
+	}
+
+	m.BroadcastToDownstream("u1", `{"code":7,"content":"hi"}`)
+	_ = observerClient.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, data, err := observerClient.ReadMessage(); err != nil || string(data) != `{"code":7,"content":"hi"}` {
+		t.Fatalf("observer read=%s err=%v", data, err)
+	}
+
+	// 最后一个 sign 会话离开后照常安排关闭上游，旁观者不影响保活。
+	session, _ := newDownstreamPair(t)
+	m.mu.Lock()
+	m.downstreamSessions["u1"] = map[*DownstreamSession]struct{}{session: {}}
+	m.mu.Unlock()
+	m.UnregisterDownstream("u1", session)
+	m.mu.Lock()
+	_, scheduled := m.pendingCloseTasks["u1"]
+	m.mu.Unlock()
+	if !scheduled {
+		t.Fatalf("expected close task while only observers remain")
+	}
+
+	m.UnregisterObserver("u1", observer)
+	if total := m.GetConnectionStats()["observers"].(map[string]any)["total"]; total != 0 {
+		t.Fatalf("total=%v", total)
+	}
+}
+
+func TestHandleWebSocket_ObserveIsReadOnly(t *testing.T) {
+	received := make(chan string, 10)
+	tracker := &wsConnTracker{}
+	upstream := newUpstreamWSServer(t, func(conn *websocket.Conn) {
+		tracker.add(conn)
+		for {
+			_, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			received <- string(data)
+			_ = conn.WriteMessage(websocket.TextMessage, []byte(`{"code":12,"content":"echo"}`))
+		}
+	})
+	t.Cleanup(func() {
+		tracker.closeAll()
+		upstream.Close()
+	})
+
+	wsManager := NewUpstreamWebSocketManager(nil, toWSURL(upstream.URL), nil, nil, nil)
+	t.Cleanup(wsManager.CloseAllConnections)
+	jwtService := NewJWTService("secret-1", 1)
+	token, err := jwtService.GenerateToken()
+	if err != nil {
+		t.Fatalf("GenerateToken failed: %v", err)
+	}
+	app := &App{jwt: jwtService, wsManager: wsManager}
+	backend := httptest.NewServer(http.HandlerFunc(app.handleWebSocket))
+	t.Cleanup(backend.Close)
+	dial := func() *websocket.Conn {
+		t.Helper()
+		conn, _, err := websocket.DefaultDialer.Dial(toWSURL(backend.URL)+"/ws?token="+url.QueryEscape(token), nil)
+		if err != nil {
+			t.Fatalf("dial downstream failed: %v", err)
+		}
+		t.Cleanup(func() { _ = conn.Close() })
+		return conn
+	}
+
+	observer := dial()
+	_ = observer.WriteMessage(websocket.TextMessage, []byte(`{"act":"observe","id":"u1"}`))
+	_ = observer.WriteMessage(websocket.TextMessage, []byte(`{"act":"sign","id":"u1"}`))
+	_ = observer.WriteMessage(websocket.TextMessage, []byte(touserFrame("from observer")))
+	waitFor(t, "observer registered", func() bool {
+		return wsManager.GetConnectionStats()["observers"].(map[string]any)["total"] == 1
+	})
+	if stats := wsManager.GetConnectionStats(); stats["upstream"] != 0 || stats["downstream"] != 0 {
+		t.Fatalf("stats=%v", stats)
+	}
+
+	signer := dial()
+	_ = signer.WriteMessage(websocket.TextMessage, []byte(`{"act":"sign","id":"u1"}`))
+	select {
+	case got := <-received:
+		if got != `{"act":"sign","id":"u1"}` {
+			t.Fatalf("upstream got %s", got)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("timeout waiting sign")
+	}
+	readDownstreamCode(t, signer, 12)
+	readDownstreamCode(t, observer, 12)
+
+	select {
+	case got := <-received:
+		t.Fatalf("observer frame reached upstream: %s", got)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	_ = observer.Close()
+	waitFor(t, "observer removed", func() bool {
+		return wsManager.GetConnectionStats()["observers"].(map[string]any)["total"] == 0
+	})
+}
@@ -2,6 +2,7 @@ package app
 
 import (
 	"encoding/json"
+	"log/slog"
 	"net/http"
 	"strings"
 	"sync"
@@ -10,98 +11,290 @@ import (
 	"github.com/gorilla/websocket"
 )
 
+// DownstreamTransport 为下游会话的底层传输（WebSocket/SSE），管理器只通过 DownstreamSession 读写，不关心具体类型。
+type DownstreamTransport interface {
+	// WriteText 写出一帧文本，调用方（DownstreamSession）保证串行。
+	WriteText(message string) error
+	// CloseWithCode 携带关闭码结束会话：WebSocket 发送 close 帧，SSE 发送 close 事件。
+	CloseWithCode(code int, reason string) error
+	Close() error
+	// Kind 返回传输类型（websocket/sse），用于连接统计。
+	Kind() string
+}
+
 type DownstreamSession struct {
-	conn    *websocket.Conn
-	writeMu sync.Mutex
+	transport DownstreamTransport
+	writeMu   sync.Mutex
+	// heartbeat 记录下游最近活动时间与 ping RTT，测试构造的会话可能为 nil。
+	heartbeat *wsHeartbeat
+}
+
+func NewDownstreamSession(transport DownstreamTransport) *DownstreamSession {
+	return &DownstreamSession{transport: transport, heartbeat: newWSHeartbeat()}
 }
 
 func (s *DownstreamSession) SendText(message string) error {
-	if s == nil || s.conn == nil {
+	if s == nil || s.transport == nil {
 		return nil
 	}
 	s.writeMu.Lock()
 	defer s.writeMu.Unlock()
-	_ = s.conn.SetWriteDeadline(time.Now().Add(wsDownstreamWriteDeadline))
-	return s.conn.WriteMessage(websocket.TextMessage, []byte(message))
+	return s.writeTextLocked(message)
+}
+
+// writeTextLocked 要求调用方已持有 writeMu。
+func (s *DownstreamSession) writeTextLocked(message string) error {
+	if s == nil || s.transport == nil {
+		return nil
+	}
+	return s.transport.WriteText(message)
 }
 
 func (s *DownstreamSession) Close() error {
-	if s == nil || s.conn == nil {
+	if s == nil || s.transport == nil {
 		return nil
 	}
 	s.writeMu.Lock()
 	defer s.writeMu.Unlock()
-	return s.conn.Close()
+	return s.transport.Close()
+}
+
+func (s *DownstreamSession) closeWithCode(code int, reason string) {
+	if s == nil || s.transport == nil {
+		return
+	}
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	_ = s.transport.CloseWithCode(code, reason)
+}
+
+func (s *DownstreamSession) kind() string {
+	if s == nil || s.transport == nil {
+		return ""
+	}
+	return s.transport.Kind()
+}
+
+// wsDownstreamTransport 为基于 gorilla/websocket 的下游传输。
+type wsDownstreamTransport struct {
+	conn *websocket.Conn
+}
+
+func (t *wsDownstreamTransport) WriteText(message string) error {
+	_ = t.conn.SetWriteDeadline(time.Now().Add(wsDownstreamWriteDeadline))
+	return t.conn.WriteMessage(websocket.TextMessage, []byte(message))
+}
+
+func (t *wsDownstreamTransport) CloseWithCode(code int, reason string) error {
+	closeWSWithCode(t.conn, code, reason)
+	return nil
+}
+
+func (t *wsDownstreamTransport) Close() error {
+	return t.conn.Close()
+}
+
+func (t *wsDownstreamTransport) Kind() string {
+	return "websocket"
 }
 
 var wsUpgrader = websocket.Upgrader{
 	ReadBufferSize:  1024,
 	WriteBufferSize: 1024,
 	CheckOrigin: func(r *http.Request) bool {
-		// Spring 侧 setAllowedOrigins("*")，这里保持一致。
+		// Spring 侧 setAllowedOrigins("*")，这里保持一致；配置 WS_ALLOWED_ORIGINS 后由 WSGuard 在升级前校验。
 		return true
 	},
 }
 
 func (a *App) handleWebSocket(w http.ResponseWriter, r *http.Request) {
-	token := strings.TrimSpace(r.URL.Query().Get("token"))
-	if token == "" || !a.jwt.ValidateToken(token) {
+	if !a.wsGuard.CheckOrigin(r) {
+		http.Error(w, "WebSocket连接来源不允许", http.StatusForbidden)
+		return
+	}
+	token, subprotocol := wsRequestToken(r)
+	principal, ok := a.authenticateToken(r.Context(), token)
+	if token == "" || !ok {
 		http.Error(w, "WebSocket连接Token无效", http.StatusUnauthorized)
 		return
 	}
 
-	conn, err := wsUpgrader.Upgrade(w, r, nil)
+	var responseHeader http.Header
+	if subprotocol != "" {
+		responseHeader = http.Header{"Sec-WebSocket-Protocol": {subprotocol}}
+	}
+	conn, err := wsUpgrader.Upgrade(w, r, responseHeader)
 	if err != nil {
 		return
 	}
-	session := &DownstreamSession{conn: conn}
+	// 升级后再拒绝，浏览器才能拿到关闭码。
+	if !a.wsGuard.acquire(principal.guardKey(token)) {
+		slog.Warn("同一Token下游会话数超限，拒绝连接", "remote", r.RemoteAddr)
+		closeWSWithCode(conn, WSCloseTooManySessions, "会话数超限")
+		return
+	}
+	defer a.wsGuard.release(principal.guardKey(token))
+	session := NewDownstreamSession(&wsDownstreamTransport{conn: conn})
+	a.authConns.add(principal.SessionID, session)
+	defer a.authConns.remove(principal.SessionID, session)
+	conn.SetPongHandler(func(appData string) error {
+		session.heartbeat.onPong(appData)
+		return nil
+	})
+	stopPing := make(chan struct{})
+	if hbCfg := a.wsManager.HeartbeatConfig(); hbCfg.DownstreamInterval > 0 {
+		go runWSPingLoop(session.heartbeat, hbCfg.DownstreamInterval, hbCfg.MaxMissed, stopPing, func(payload []byte) error {
+			return conn.WriteControl(websocket.PingMessage, payload, time.Now().Add(wsDownstreamWriteDeadline))
+		}, func(idle time.Duration) {
+			if a.wsManager != nil {
+				a.wsManager.deadDownstream.Add(1)
+			}
+			slog.Warn("下游连接心跳超时，主动断开", "remote", r.RemoteAddr, "idle", idle)
+			_ = session.Close()
+		})
+	}
 
-	var registeredUserID string
+	inbound := a.newDownstreamInbound(session, r.RemoteAddr, principal)
 	for {
 		msgType, payload, readErr := conn.ReadMessage()
 		if readErr != nil {
 			break
 		}
+		if !inbound.allow() {
+			break
+		}
 		if msgType != websocket.TextMessage {
 			continue
 		}
+		inbound.handleFrame(payload)
+	}
 
-		var node map[string]any
-		if err := json.Unmarshal(payload, &node); err != nil {
-			continue
-		}
+	close(stopPing)
+	inbound.detach()
+	_ = session.Close()
+}
 
-		act := strings.TrimSpace(toString(node["act"]))
-		userID := strings.TrimSpace(toString(node["id"]))
-		if userID == "" {
-			continue
-		}
+// downstreamInbound 处理单个下游会话发来的帧（sign/observe/业务消息），WebSocket 与 SSE 共用同一套语义。
+type downstreamInbound struct {
+	app     *App
+	session *DownstreamSession
+	remote  string
+	limiter *wsTokenBucket
+	// readOnly 为 viewer 角色的会话：只允许 observe 旁观，不能 sign 或发消息。
+	readOnly bool
 
-		raw := string(payload)
-		if act == "sign" {
-			if registeredUserID != "" && registeredUserID != userID && a.wsManager != nil {
-				a.wsManager.UnregisterDownstream(registeredUserID, session)
-			}
-			registeredUserID = userID
-			if a.wsManager != nil {
-				a.wsManager.RegisterDownstream(userID, session, raw)
-			}
-			continue
-		}
+	mu sync.Mutex
+	// registeredUserID 为 sign 后的身份，observedUserID 为 observe 旁观的身份；一个会话只能处于其中一种模式。
+	registeredUserID string
+	observedUserID   string
+}
 
-		if registeredUserID == "" {
-			continue
+func (a *App) newDownstreamInbound(session *DownstreamSession, remote string, principal AuthPrincipal) *downstreamInbound {
+	return &downstreamInbound{
+		app:      a,
+		session:  session,
+		remote:   remote,
+		limiter:  a.wsGuard.newLimiter(),
+		readOnly: !principal.Allows(AuthRoleOperator),
+	}
+}
+
+// allow 记录一次入站活动并按令牌桶限速；超限时以 WSCloseRateLimited 关闭会话并返回 false。
+func (in *downstreamInbound) allow() bool {
+	in.session.heartbeat.touch()
+	in.mu.Lock()
+	ok := in.limiter.allow(time.Now())
+	userID := in.registeredUserID
+	in.mu.Unlock()
+	if ok {
+		return true
+	}
+	in.app.wsGuard.rateLimited.Add(1)
+	slog.Warn("下游发帧过于频繁，关闭连接", "remote", in.remote, "userId", userID, "transport", in.session.kind())
+	in.session.closeWithCode(WSCloseRateLimited, "消息过于频繁")
+	return false
+}
+
+func (in *downstreamInbound) handleFrame(payload []byte) {
+	var node map[string]any
+	if err := json.Unmarshal(payload, &node); err != nil {
+		return
+	}
+
+	act := strings.TrimSpace(toString(node["act"]))
+	userID := strings.TrimSpace(toString(node["id"]))
+	if userID == "" {
+		return
+	}
+
+	a := in.app
+	session := in.session
+	raw := string(payload)
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	// 旁观会话只读：除切换旁观对象外的任何帧都丢弃，不会到达 SendToUpstream。
+	if act == "observe" || in.observedUserID != "" || in.readOnly {
+		if act != "observe" || in.registeredUserID != "" {
+			return
 		}
-		if userID != registeredUserID {
-			continue
+		if a.wsManager != nil {
+			if in.observedUserID != "" {
+				a.wsManager.UnregisterObserver(in.observedUserID, session)
+			}
+			a.wsManager.RegisterObserver(userID, session)
+		}
+		in.observedUserID = userID
+		return
+	}
+	if a.wsManager != nil {
+		a.wsManager.recordFrame(userID, WSRecordDownstreamIn, raw)
+	}
+	if act == "sign" {
+		if in.registeredUserID != "" && in.registeredUserID != userID && a.wsManager != nil {
+			a.wsManager.UnregisterDownstream(in.registeredUserID, session)
 		}
+		in.registeredUserID = userID
+		// 携带 lastSeq（及 lastEpoch）的 sign 表示断线续传：补发缓冲中同纪元 seq 更大的上游帧。
+		lastSeq, lastEpoch, signRaw, resume := parseSignLastSeq(node, raw)
 		if a.wsManager != nil {
-			a.wsManager.SendToUpstream(registeredUserID, raw)
+			if resume {
+				a.wsManager.ResumeDownstream(userID, session, signRaw, lastEpoch, lastSeq)
+			} else {
+				a.wsManager.RegisterDownstream(userID, session, signRaw)
+			}
 		}
+		return
 	}
 
-	if registeredUserID != "" && a.wsManager != nil {
-		a.wsManager.UnregisterDownstream(registeredUserID, session)
+	if in.registeredUserID == "" {
+		return
+	}
+	if userID != in.registeredUserID {
+		return
+	}
+	if a.wsManager == nil {
+		return
+	}
+	// 被出站过滤拦截时只通知发起的会话（code=-11），不广播给同身份的其他标签页、旁观者或其他副本。
+	if decision := a.wsManager.SendToUpstream(in.registeredUserID, raw); decision.Blocked() {
+		if err := in.session.SendText(buildOutboundFilterRejectMessage(decision)); err != nil {
+			slog.Debug("回复出站拦截失败", "userID", in.registeredUserID, "error", err)
+		}
+	}
+}
+
+// detach 在会话结束时注销 sign/旁观登记。
+func (in *downstreamInbound) detach() {
+	in.mu.Lock()
+	registeredUserID, observedUserID := in.registeredUserID, in.observedUserID
+	in.mu.Unlock()
+	if in.app.wsManager == nil {
+		return
+	}
+	if registeredUserID != "" {
+		in.app.wsManager.UnregisterDownstream(registeredUserID, in.session)
+	}
+	if observedUserID != "" {
+		in.app.wsManager.UnregisterObserver(observedUserID, in.session)
 	}
-	_ = session.Close()
 }
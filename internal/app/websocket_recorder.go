@@ -0,0 +1,262 @@
+package app
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 录制帧方向：以本服务为视角，up/down 表示与上游/下游之间的收发。
+const (
+	WSRecordUpstreamIn    = "upstream_in"
+	WSRecordUpstreamOut   = "upstream_out"
+	WSRecordDownstreamIn  = "downstream_in"
+	WSRecordDownstreamOut = "downstream_out"
+)
+
+// wsRecordMaxLineBytes 为读取录制文件时单行的最大长度。
+var wsRecordMaxLineBytes = 4 << 20
+
+var wsRecordUnsafeChars = regexp.MustCompile(`[^A-Za-z0-9_.-]+`)
+
+// WSRecordEntry 为录制文件（JSONL）中的一行。
+type WSRecordEntry struct {
+	Time      time.Time `json:"ts"`
+	OffsetMs  int64     `json:"offsetMs"`
+	UserID    string    `json:"userId"`
+	Direction string    `json:"dir"`
+	Frame     string    `json:"frame"`
+}
+
+// WSRecordingInfo 描述一个正在进行的录制。
+type WSRecordingInfo struct {
+	UserID    string    `json:"userId"`
+	Path      string    `json:"path"`
+	StartedAt time.Time `json:"startedAt"`
+	Frames    int64     `json:"frames"`
+}
+
+type wsRecording struct {
+	file      *os.File
+	path      string
+	startedAt time.Time
+	frames    int64
+}
+
+// WSSessionRecorder 按身份把上下游 WebSocket 帧追加写入 JSONL 文件，仅对显式开启的身份生效。
+type WSSessionRecorder struct {
+	dir   string
+	nowFn func() time.Time
+
+	mu         sync.Mutex
+	recordings map[string]*wsRecording
+}
+
+// NewWSSessionRecorder 返回写入 dir 的录制器；dir 为空时返回 nil（不启用录制）。
+func NewWSSessionRecorder(dir string) *WSSessionRecorder {
+	dir = strings.TrimSpace(dir)
+	if dir == "" {
+		return nil
+	}
+	return &WSSessionRecorder{
+		dir:        dir,
+		nowFn:      time.Now,
+		recordings: make(map[string]*wsRecording),
+	}
+}
+
+// Dir 返回录制文件目录。
+func (r *WSSessionRecorder) Dir() string {
+	if r == nil {
+		return ""
+	}
+	return r.dir
+}
+
+// Enable 为身份开启录制并返回文件路径；已在录制时返回现有文件。
+func (r *WSSessionRecorder) Enable(userID string) (string, error) {
+	if r == nil {
+		return "", fmt.Errorf("录制未启用")
+	}
+	userID = strings.TrimSpace(userID)
+	if userID == "" {
+		return "", fmt.Errorf("userId不能为空")
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if rec := r.recordings[userID]; rec != nil {
+		return rec.path, nil
+	}
+	if err := os.MkdirAll(r.dir, 0o755); err != nil {
+		return "", err
+	}
+	now := r.nowFn()
+	name := fmt.Sprintf("%s-%s.jsonl", wsRecordUnsafeChars.ReplaceAllString(userID, "_"), now.Format("20060102-150405.000"))
+	path := filepath.Join(r.dir, name)
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return "", err
+	}
+	r.recordings[userID] = &wsRecording{file: file, path: path, startedAt: now}
+	slog.Info("开始录制WebSocket会话", "userID", userID, "path", path)
+	return path, nil
+}
+
+// Disable 停止身份的录制，返回是否存在录制。
+func (r *WSSessionRecorder) Disable(userID string) bool {
+	if r == nil {
+		return false
+	}
+	userID = strings.TrimSpace(userID)
+
+	r.mu.Lock()
+	rec := r.recordings[userID]
+	delete(r.recordings, userID)
+	r.mu.Unlock()
+	if rec == nil {
+		return false
+	}
+	_ = rec.file.Close()
+	slog.Info("停止录制WebSocket会话", "userID", userID, "path", rec.path, "frames", rec.frames)
+	return true
+}
+
+// Enabled 返回身份是否正在录制。
+func (r *WSSessionRecorder) Enabled(userID string) bool {
+	if r == nil {
+		return false
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.recordings[userID] != nil
+}
+
+// List 按 userId 排序返回正在进行的录制。
+func (r *WSSessionRecorder) List() []WSRecordingInfo {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	out := make([]WSRecordingInfo, 0, len(r.recordings))
+	for userID, rec := range r.recordings {
+		out = append(out, WSRecordingInfo{UserID: userID, Path: rec.path, StartedAt: rec.startedAt, Frames: rec.frames})
+	}
+	r.mu.Unlock()
+	sort.Slice(out, func(i, j int) bool { return out[i].UserID < out[j].UserID })
+	return out
+}
+
+// Record 追加一帧；身份未开启录制时直接返回。写入失败只记录日志并停止该身份的录制。
+func (r *WSSessionRecorder) Record(userID string, direction string, frame string) {
+	if r == nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	rec := r.recordings[userID]
+	if rec == nil {
+		return
+	}
+	now := r.nowFn()
+	line, err := json.Marshal(WSRecordEntry{
+		Time:      now,
+		OffsetMs:  now.Sub(rec.startedAt).Milliseconds(),
+		UserID:    userID,
+		Direction: direction,
+		Frame:     frame,
+	})
+	if err == nil {
+		_, err = rec.file.Write(append(line, '\n'))
+	}
+	if err != nil {
+		slog.Error("写入WebSocket录制失败", "userID", userID, "path", rec.path, "error", err)
+		_ = rec.file.Close()
+		delete(r.recordings, userID)
+		return
+	}
+	rec.frames++
+}
+
+// Close 结束全部录制。
+func (r *WSSessionRecorder) Close() error {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	recordings := r.recordings
+	r.recordings = make(map[string]*wsRecording)
+	r.mu.Unlock()
+	for _, rec := range recordings {
+		_ = rec.file.Close()
+	}
+	return nil
+}
+
+// ReadWSRecording 解析 JSONL 录制内容，忽略空行。
+func ReadWSRecording(reader io.Reader) ([]WSRecordEntry, error) {
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 0, 64*1024), wsRecordMaxLineBytes)
+
+	var entries []WSRecordEntry
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		var entry WSRecordEntry
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			return nil, fmt.Errorf("第%d行解析失败: %w", lineNo, err)
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// ReplayUpstreamRecording 把录制中的 upstream_in 帧依次送入 client.onMessage，复现上游消息处理链与广播。
+// 帧间隔按录制的 offsetMs 除以 speed 等待（speed=1 为原速）；speed<=0 时不等待。返回已回放的帧数。
+func ReplayUpstreamRecording(ctx context.Context, client *UpstreamWebSocketClient, entries []WSRecordEntry, speed float64) (int, error) {
+	if client == nil {
+		return 0, fmt.Errorf("client不能为空")
+	}
+
+	replayed := 0
+	var lastOffset int64
+	for _, entry := range entries {
+		if entry.Direction != WSRecordUpstreamIn {
+			continue
+		}
+		if speed > 0 && replayed > 0 && entry.OffsetMs > lastOffset {
+			wait := time.Duration(float64(entry.OffsetMs-lastOffset)/speed) * time.Millisecond
+			timer := time.NewTimer(wait)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return replayed, ctx.Err()
+			case <-timer.C:
+			}
+		} else if err := ctx.Err(); err != nil {
+			return replayed, err
+		}
+		lastOffset = entry.OffsetMs
+		client.onMessage(entry.Frame)
+		replayed++
+	}
+	return replayed, nil
+}
@@ -0,0 +1,85 @@
+package app
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+func (a *App) wsRecorder() *WSSessionRecorder {
+	if a.wsManager == nil {
+		return nil
+	}
+	return a.wsManager.Recorder()
+}
+
+func (a *App) handleListWSRecordings(w http.ResponseWriter, r *http.Request) {
+	recorder := a.wsRecorder()
+	if recorder == nil {
+		writeJSON(w, http.StatusOK, map[string]any{"code": -1, "msg": "未配置录制目录 WS_RECORD_DIR"})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"code": 0,
+		"msg":  "success",
+		"data": map[string]any{
+			"dir":        recorder.Dir(),
+			"recordings": recorder.List(),
+		},
+	})
+}
+
+func (a *App) handleStartWSRecording(w http.ResponseWriter, r *http.Request) {
+	recorder := a.wsRecorder()
+	if recorder == nil {
+		writeJSON(w, http.StatusOK, map[string]any{"code": -1, "msg": "未配置录制目录 WS_RECORD_DIR"})
+		return
+	}
+	userID, ok := decodeWSRecordUserID(w, r)
+	if !ok {
+		return
+	}
+	path, err := recorder.Enable(userID)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]any{"code": -1, "msg": "开启录制失败: " + err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"code": 0,
+		"msg":  "已开启录制",
+		"data": map[string]any{"userId": userID, "path": path},
+	})
+}
+
+func (a *App) handleStopWSRecording(w http.ResponseWriter, r *http.Request) {
+	recorder := a.wsRecorder()
+	if recorder == nil {
+		writeJSON(w, http.StatusOK, map[string]any{"code": -1, "msg": "未配置录制目录 WS_RECORD_DIR"})
+		return
+	}
+	userID, ok := decodeWSRecordUserID(w, r)
+	if !ok {
+		return
+	}
+	msg := "已停止录制"
+	if !recorder.Disable(userID) {
+		msg = "该身份未在录制"
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"code": 0, "msg": msg})
+}
+
+func decodeWSRecordUserID(w http.ResponseWriter, r *http.Request) (string, bool) {
+	var in struct {
+		UserID string `json:"userId"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"code": 400, "msg": "参数解析失败"})
+		return "", false
+	}
+	userID := strings.TrimSpace(in.UserID)
+	if userID == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"code": 400, "msg": "userId不能为空"})
+		return "", false
+	}
+	return userID, true
+}
@@ -0,0 +1,228 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"liao/internal/fakeupstream"
+)
+
+func readRecordingFile(t *testing.T, path string) []WSRecordEntry {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("open recording: %v", err)
+	}
+	defer f.Close()
+	entries, err := ReadWSRecording(f)
+	if err != nil {
+		t.Fatalf("ReadWSRecording: %v", err)
+	}
+	return entries
+}
+
+func TestWSSessionRecorder_EnableRecordDisable(t *testing.T) {
+	if NewWSSessionRecorder(" ") != nil {
+		t.Fatalf("expected nil recorder for empty dir")
+	}
+	var nilRecorder *WSSessionRecorder
+	nilRecorder.Record("u1", WSRecordUpstreamIn, "x")
+	if nilRecorder.Enabled("u1") || nilRecorder.List() != nil || nilRecorder.Close() != nil {
+		t.Fatalf("nil recorder should be a no-op")
+	}
+
+	r := NewWSSessionRecorder(t.TempDir())
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	now := start
+	r.nowFn = func() time.Time { return now }
+
+	if _, err := r.Enable(" "); err == nil {
+		t.Fatalf("expected error for empty userId")
+	}
+	path, err := r.Enable("u/1")
+	if err != nil {
+		t.Fatalf("Enable: %v", err)
+	}
+	if again, _ := r.Enable("u/1"); again != path || strings.Contains(path[len(r.Dir()):], "u/1") {
+		t.Fatalf("path=%q again=%q", path, again)
+	}
+
+	r.Record("other", WSRecordUpstreamIn, "ignored")
+	now = start.Add(150 * time.Millisecond)
+	r.Record("u/1", WSRecordUpstreamIn, `{"code":7}`)
+	r.Record("u/1", WSRecordDownstreamOut, `{"code":7,"seq":1}`)
+
+	list := r.List()
+	if len(list) != 1 || list[0].UserID != "u/1" || list[0].Frames != 2 {
+		t.Fatalf("list=%+v", list)
+	}
+	if !r.Disable("u/1") || r.Disable("u/1") || r.Enabled("u/1") {
+		t.Fatalf("unexpected disable result")
+	}
+
+	entries := readRecordingFile(t, path)
+	if len(entries) != 2 || entries[0].OffsetMs != 150 || entries[0].Direction != WSRecordUpstreamIn || entries[1].Frame != `{"code":7,"seq":1}` {
+		t.Fatalf("entries=%+v", entries)
+	}
+
+	if _, err := ReadWSRecording(strings.NewReader("\n{bad\n")); err == nil || !strings.Contains(err.Error(), "第2行") {
+		t.Fatalf("err=%v", err)
+	}
+}
+
+func TestReplayUpstreamRecording_SpeedAndCancel(t *testing.T) {
+	m := NewUpstreamWebSocketManager(nil, "ws://unused", nil, nil, nil)
+	var seen []string
+	_ = m.Pipeline().RegisterFirst("spy", UpstreamMessageHandlerFunc(func(f *UpstreamFrame) UpstreamFrameVerdict {
+		seen = append(seen, f.Raw)
+		return UpstreamFrameDrop
+	}))
+	client := NewUpstreamWebSocketClient("u1", "ws://unused", m)
+
+	entries := []WSRecordEntry{
+		{OffsetMs: 0, Direction: WSRecordUpstreamOut, Frame: "sign"},
+		{OffsetMs: 100, Direction: WSRecordUpstreamIn, Frame: "a"},
+		{OffsetMs: 300, Direction: WSRecordUpstreamIn, Frame: "b"},
+		{OffsetMs: 500, Direction: WSRecordUpstreamIn, Frame: "c"},
+	}
+
+	start := time.Now()
+	n, err := ReplayUpstreamRecording(context.Background(), client, entries, 10)
+	if err != nil || n != 3 {
+		t.Fatalf("n=%d err=%v", n, err)
+	}
+	if elapsed := time.Since(start); elapsed < 35*time.Millisecond || elapsed > time.Second {
+		t.Fatalf("elapsed=%v, want ~40ms at 10x", elapsed)
+	}
+	if !reflect.DeepEqual(seen, []string{"a", "b", "c"}) {
+		t.Fatalf("seen=%v", seen)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if n, err := ReplayUpstreamRecording(ctx, client, entries, 1); !errors.Is(err, context.Canceled) || n != 0 {
+		t.Fatalf("n=%d err=%v", n, err)
+	}
+	if _, err := ReplayUpstreamRecording(context.Background(), nil, entries, 0); err == nil {
+		t.Fatalf("expected error for nil client")
+	}
+}
+
+func TestWSSessionRecorder_RecordAndReplayReproducesSession(t *testing.T) {
+	fake := startFakeUpstream(t)
+	bot := fakeupstream.DefaultBots()[0]
+
+	recorder := NewWSSessionRecorder(t.TempDir())
+	path, err := recorder.Enable("u1")
+	if err != nil {
+		t.Fatalf("Enable: %v", err)
+	}
+
+	liveCache := &spyUserInfoCache{}
+	live := NewUpstreamWebSocketManager(&http.Client{Timeout: 2 * time.Second}, "ws://unused", nil, liveCache, nil)
+	live.SetRecorder(recorder)
+	t.Cleanup(live.CloseAllConnections)
+
+	session, client := newDownstreamPair(t)
+	live.RegisterDownstream("u1", session, `{"act":"sign","id":"u1","name":"Me"}`)
+	waitFor(t, "upstream sign", func() bool { return fake.Connected("u1") })
+	live.SendToUpstream("u1", `{"act":"random","id":"u1"}`)
+	readDownstreamCode(t, client, 15)
+	live.SendToUpstream("u1", `{"act":"touser_`+bot.ID+`_`+bot.Nickname+`","id":"u1","msg":"hello"}`)
+	readDownstreamCode(t, client, 7)
+	readDownstreamCode(t, client, 7)
+	recorder.Disable("u1")
+
+	entries := readRecordingFile(t, path)
+	counts := map[string]int{}
+	for _, e := range entries {
+		counts[e.Direction]++
+	}
+	if counts[WSRecordUpstreamOut] != 3 || counts[WSRecordUpstreamIn] != 3 || counts[WSRecordDownstreamOut] != 3 {
+		t.Fatalf("counts=%v entries=%+v", counts, entries)
+	}
+
+	// 回放到一个全新的管理器，缓存写入结果与现场一致。
+	replayCache := &spyUserInfoCache{}
+	replay := NewUpstreamWebSocketManager(nil, "ws://unused", nil, replayCache, nil)
+	t.Cleanup(replay.CloseAllConnections)
+	replaySession, replayClient := newDownstreamPair(t)
+	replay.mu.Lock()
+	replay.downstreamSessions["u1"] = map[*DownstreamSession]struct{}{replaySession: {}}
+	replay.mu.Unlock()
+
+	n, err := ReplayUpstreamRecording(context.Background(), NewUpstreamWebSocketClient("u1", "ws://unused", replay), entries, 0)
+	if err != nil || n != 3 {
+		t.Fatalf("n=%d err=%v", n, err)
+	}
+	readDownstreamCode(t, replayClient, 15)
+
+	liveCache.mu.Lock()
+	defer liveCache.mu.Unlock()
+	replayCache.mu.Lock()
+	defer replayCache.mu.Unlock()
+	if !reflect.DeepEqual(liveCache.userInfos, replayCache.userInfos) || !reflect.DeepEqual(liveCache.messages, replayCache.messages) {
+		t.Fatalf("live=%+v/%+v replay=%+v/%+v", liveCache.userInfos, liveCache.messages, replayCache.userInfos, replayCache.messages)
+	}
+}
+
+func TestHandleWSRecording_Handlers(t *testing.T) {
+	a := &App{}
+	rec := httptest.NewRecorder()
+	a.handleListWSRecordings(rec, httptest.NewRequest(http.MethodGet, "/api/wsRecord/list", nil))
+	if got := decodeJSONBody(t, rec.Body); toInt(got["code"]) != -1 {
+		t.Fatalf("got=%v", got)
+	}
+
+	a.wsManager = NewUpstreamWebSocketManager(nil, "ws://unused", nil, nil, nil)
+	rec = httptest.NewRecorder()
+	a.handleStartWSRecording(rec, httptest.NewRequest(http.MethodPost, "/api/wsRecord/start", strings.NewReader(`{"userId":"u1"}`)))
+	if got := decodeJSONBody(t, rec.Body); toInt(got["code"]) != -1 {
+		t.Fatalf("got=%v", got)
+	}
+
+	a.wsManager.SetRecorder(NewWSSessionRecorder(t.TempDir()))
+	t.Cleanup(func() { _ = a.wsManager.Recorder().Close() })
+	for _, body := range []string{`{`, `{"userId":" "}`} {
+		rec = httptest.NewRecorder()
+		a.handleStartWSRecording(rec, httptest.NewRequest(http.MethodPost, "/api/wsRecord/start", strings.NewReader(body)))
+		if rec.Code != http.StatusBadRequest {
+			t.Fatalf("body=%s status=%d, want 400", body, rec.Code)
+		}
+	}
+
+	rec = httptest.NewRecorder()
+	a.handleStartWSRecording(rec, httptest.NewRequest(http.MethodPost, "/api/wsRecord/start", strings.NewReader(`{"userId":"u1"}`)))
+	got := decodeJSONBody(t, rec.Body)
+	if data, _ := got["data"].(map[string]any); toInt(got["code"]) != 0 || !strings.HasSuffix(toString(data["path"]), ".jsonl") {
+		t.Fatalf("got=%v", got)
+	}
+	if stats := a.wsManager.GetConnectionStats(); stats["recording"] != 1 {
+		t.Fatalf("stats=%v", stats)
+	}
+
+	rec = httptest.NewRecorder()
+	a.handleListWSRecordings(rec, httptest.NewRequest(http.MethodGet, "/api/wsRecord/list", nil))
+	got = decodeJSONBody(t, rec.Body)
+	if data, _ := got["data"].(map[string]any); len(data["recordings"].([]any)) != 1 {
+		t.Fatalf("got=%v", got)
+	}
+
+	rec = httptest.NewRecorder()
+	a.handleStopWSRecording(rec, httptest.NewRequest(http.MethodPost, "/api/wsRecord/stop", strings.NewReader(`{"userId":"u1"}`)))
+	if got := decodeJSONBody(t, rec.Body); got["msg"] != "已停止录制" {
+		t.Fatalf("got=%v", got)
+	}
+	rec = httptest.NewRecorder()
+	a.handleStopWSRecording(rec, httptest.NewRequest(http.MethodPost, "/api/wsRecord/stop", strings.NewReader(`{"userId":"u1"}`)))
+	if got := decodeJSONBody(t, rec.Body); got["msg"] != "该身份未在录制" {
+		t.Fatalf("got=%v", got)
+	}
+}
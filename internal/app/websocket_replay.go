@@ -0,0 +1,181 @@
+package app
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const wsReplayBufferFrames = 200
+
+// 每个身份保留的最近上游帧数量；<=0 时不缓存，续传只返回 truncated。
+var wsReplayBufferSize = wsReplayBufferFrames
+
+// newReplayEpoch 生成本进程的续传纪元。seq 只在同一纪元内可比较：
+// 其他副本接管身份或服务端重启后纪元随之改变，客户端持有的旧 lastSeq 不再参与过滤。
+func newReplayEpoch() string {
+	b := make([]byte, 6)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+type replayFrame struct {
+	seq     int64
+	message string
+}
+
+// downstreamReplayRing 保存某身份最近广播给下游的上游帧，用于下游断线续传。
+// epoch 为产生这些帧的 owner 纪元；floor 为该缓冲“已无法补发”的最大 seq：
+// 创建时为当时的 seq，之后为最近被挤出的帧 seq；latest 为最近写入的帧 seq。
+type downstreamReplayRing struct {
+	epoch  string
+	frames []replayFrame
+	start  int
+	size   int
+	floor  int64
+	latest int64
+}
+
+func newDownstreamReplayRing(capacity int, epoch string, floor int64) *downstreamReplayRing {
+	if capacity < 0 {
+		capacity = 0
+	}
+	return &downstreamReplayRing{epoch: epoch, frames: make([]replayFrame, capacity), floor: floor, latest: floor}
+}
+
+func (r *downstreamReplayRing) push(seq int64, message string) {
+	r.latest = seq
+	if len(r.frames) == 0 {
+		r.floor = seq
+		return
+	}
+	if r.size == len(r.frames) {
+		r.floor = r.frames[r.start].seq
+		r.frames[r.start] = replayFrame{seq: seq, message: message}
+		r.start = (r.start + 1) % len(r.frames)
+		return
+	}
+	r.frames[(r.start+r.size)%len(r.frames)] = replayFrame{seq: seq, message: message}
+	r.size++
+}
+
+// since 返回 seq 大于 lastSeq 的帧；truncated 表示 (lastSeq, floor] 区间的帧已无法补发。
+func (r *downstreamReplayRing) since(lastSeq int64) (messages []string, truncated bool) {
+	truncated = lastSeq < r.floor
+	for i := 0; i < r.size; i++ {
+		frame := r.frames[(r.start+i)%len(r.frames)]
+		if frame.seq > lastSeq {
+			messages = append(messages, frame.message)
+		}
+	}
+	return messages, truncated
+}
+
+// recordReplayFrameLocked 为上游帧分配 seq 并写入该身份的续传缓冲，返回附带 seq 与纪元的帧。
+// 缓冲来自其他 owner 纪元（本副本刚接管该身份）时丢弃重建。非 JSON 对象帧无法附加 seq，原样返回且不缓存。
+func (m *UpstreamWebSocketManager) recordReplayFrameLocked(userID string, message string) string {
+	if !isJSONObjectFrame(message) {
+		return message
+	}
+	ring := m.replayBuffers[userID]
+	if ring == nil || ring.epoch != m.replayEpoch {
+		ring = newDownstreamReplayRing(wsReplayBufferSize, m.replayEpoch, m.replaySeq)
+		m.replayBuffers[userID] = ring
+	}
+	m.replaySeq++
+	tagged := appendFrameSeq(message, m.replaySeq, m.replayEpoch)
+	ring.push(m.replaySeq, tagged)
+	return tagged
+}
+
+// replayResult 为一次续传的补发结果；reset 表示客户端的纪元与当前缓冲不一致，lastSeq 已不可比较。
+type replayResult struct {
+	messages  []string
+	truncated bool
+	reset     bool
+	epoch     string
+	latestSeq int64
+}
+
+// replayFramesLocked 返回 (lastEpoch, lastSeq) 之后的缓存帧。
+// 缓冲不存在（上游连接已关闭重建）或 lastSeq 超前时视为缓冲已失效；
+// 纪元不一致（其他副本接管或服务端重启）时补发全部缓存并标记 reset。
+func (m *UpstreamWebSocketManager) replayFramesLocked(userID string, lastEpoch string, lastSeq int64) replayResult {
+	ring := m.replayBuffers[userID]
+	if ring == nil {
+		return replayResult{truncated: true, reset: lastEpoch != m.replayEpoch, epoch: m.replayEpoch, latestSeq: m.replaySeq}
+	}
+	result := replayResult{epoch: ring.epoch, latestSeq: ring.latest}
+	if lastEpoch != ring.epoch {
+		result.reset = true
+		lastSeq = -1
+	} else if lastSeq > ring.latest {
+		lastSeq = -1
+	}
+	result.messages, result.truncated = ring.since(lastSeq)
+	result.truncated = result.truncated || lastSeq < 0
+	return result
+}
+
+// parseSignLastSeq 从下游 sign 消息中取出续传令牌 lastSeq 与 lastEpoch，
+// 并返回去掉这两个字段后的 sign 原文（避免转发给上游）。
+func parseSignLastSeq(node map[string]any, raw string) (int64, string, string, bool) {
+	value, ok := node["lastSeq"]
+	if !ok {
+		return 0, "", raw, false
+	}
+	epoch, _ := node["lastEpoch"].(string)
+	epoch = strings.TrimSpace(epoch)
+	delete(node, "lastSeq")
+	delete(node, "lastEpoch")
+	if b, err := json.Marshal(node); err == nil {
+		raw = string(b)
+	}
+
+	var lastSeq int64
+	switch v := value.(type) {
+	case float64:
+		lastSeq = int64(v)
+	case string:
+		n, err := strconv.ParseInt(strings.TrimSpace(v), 10, 64)
+		if err != nil {
+			return 0, "", raw, false
+		}
+		lastSeq = n
+	default:
+		return 0, "", raw, false
+	}
+	if lastSeq < 0 {
+		return 0, "", raw, false
+	}
+	return lastSeq, epoch, raw, true
+}
+
+func isJSONObjectFrame(message string) bool {
+	trimmed := strings.TrimSpace(message)
+	return strings.HasPrefix(trimmed, "{") && strings.HasSuffix(trimmed, "}") && json.Valid([]byte(trimmed))
+}
+
+// appendFrameSeq 在 JSON 对象末尾追加 seq 与 epoch 字段，保持上游原有字段顺序与内容不变。
+func appendFrameSeq(message string, seq int64, epoch string) string {
+	trimmed := strings.TrimSpace(message)
+	body := strings.TrimSpace(trimmed[1 : len(trimmed)-1])
+	if body == "" {
+		return fmt.Sprintf("{\"seq\":%d,\"epoch\":%q}", seq, epoch)
+	}
+	return fmt.Sprintf("{%s,\"seq\":%d,\"epoch\":%q}", body, seq, epoch)
+}
+
+func buildResumeMessage(result replayResult) string {
+	content := "已补发离线期间的消息"
+	if result.reset {
+		content = "服务端已切换，离线消息可能不完整，请刷新聊天记录"
+	} else if result.truncated {
+		content = "部分离线消息已超出缓存，请刷新聊天记录"
+	}
+	return fmt.Sprintf("{\"code\":-9,\"content\":\"%s\",\"resumed\":true,\"replayed\":%d,\"truncated\":%t,\"reset\":%t,\"epoch\":%q,\"latestSeq\":%d}",
+		content, len(result.messages), result.truncated, result.reset, result.epoch, result.latestSeq)
+}
@@ -0,0 +1,241 @@
+package app
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestDownstreamReplayRing_PushAndSince(t *testing.T) {
+	r := newDownstreamReplayRing(3, "e1", 10)
+	for seq := int64(11); seq <= 14; seq++ {
+		r.push(seq, "m"+string(rune('0'+seq-10)))
+	}
+
+	got, truncated := r.since(11)
+	if truncated {
+		t.Fatalf("expected complete replay from 11 (floor=%d)", r.floor)
+	}
+	if len(got) != 3 || got[0] != "m2" || got[2] != "m4" {
+		t.Fatalf("got=%v", got)
+	}
+
+	got, truncated = r.since(10)
+	if !truncated || len(got) != 3 {
+		t.Fatalf("got=%v truncated=%v, want 3 frames and truncated", got, truncated)
+	}
+
+	got, truncated = r.since(14)
+	if truncated || len(got) != 0 {
+		t.Fatalf("got=%v truncated=%v, want nothing", got, truncated)
+	}
+
+	empty := newDownstreamReplayRing(0, "e1", 0)
+	empty.push(5, "x")
+	if got, truncated := empty.since(4); !truncated || len(got) != 0 {
+		t.Fatalf("got=%v truncated=%v", got, truncated)
+	}
+}
+
+func TestAppendFrameSeqAndParseSignLastSeq(t *testing.T) {
+	if got := appendFrameSeq(`{"code":7,"content":"hi"}`, 3, "e1"); got != `{"code":7,"content":"hi","seq":3,"epoch":"e1"}` {
+		t.Fatalf("got=%q", got)
+	}
+	if got := appendFrameSeq(` { } `, 1, "e1"); got != `{"seq":1,"epoch":"e1"}` {
+		t.Fatalf("got=%q", got)
+	}
+	for _, msg := range []string{"not-json", `[1,2]`, `{"a":`} {
+		if isJSONObjectFrame(msg) {
+			t.Fatalf("%q should not be treated as object frame", msg)
+		}
+	}
+
+	cases := []struct {
+		raw       string
+		wantSeq   int64
+		wantEpoch string
+		resume    bool
+	}{
+		{`{"act":"sign","id":"u1"}`, 0, "", false},
+		{`{"act":"sign","id":"u1","lastSeq":42}`, 42, "", true},
+		{`{"act":"sign","id":"u1","lastSeq":42,"lastEpoch":" e1 "}`, 42, "e1", true},
+		{`{"act":"sign","id":"u1","lastSeq":"7"}`, 7, "", true},
+		{`{"act":"sign","id":"u1","lastSeq":"x","lastEpoch":"e1"}`, 0, "", false},
+		{`{"act":"sign","id":"u1","lastSeq":-1}`, 0, "", false},
+	}
+	for _, tc := range cases {
+		var node map[string]any
+		if err := json.Unmarshal([]byte(tc.raw), &node); err != nil {
+			t.Fatalf("unmarshal: %v", err)
+		}
+		seq, epoch, raw, resume := parseSignLastSeq(node, tc.raw)
+		if seq != tc.wantSeq || epoch != tc.wantEpoch || resume != tc.resume {
+			t.Fatalf("raw=%s seq=%d epoch=%q resume=%v", tc.raw, seq, epoch, resume)
+		}
+		var out map[string]any
+		if err := json.Unmarshal([]byte(raw), &out); err != nil {
+			t.Fatalf("unmarshal stripped: %v", err)
+		}
+		if _, ok := out["lastSeq"]; ok {
+			t.Fatalf("lastSeq should be stripped: %s", raw)
+		}
+		if _, ok := out["lastEpoch"]; ok {
+			t.Fatalf("lastEpoch should be stripped: %s", raw)
+		}
+	}
+}
+
+func TestUpstreamWebSocketManager_ResumeDownstream_ReplaysMissedFrames(t *testing.T) {
+	m := NewUpstreamWebSocketManager(nil, "ws://unused", nil, nil, nil)
+	t.Cleanup(m.CloseAllConnections)
+
+	// 占位上游连接，避免注册下游时真正建连。
+	m.mu.Lock()
+	m.upstreamClients["u1"] = NewUpstreamWebSocketClient("u1", "ws://unused", m)
+	m.mu.Unlock()
+
+	first, firstClient := newDownstreamPair(t)
+	m.RegisterDownstream("u1", first, `{"act":"sign","id":"u1"}`)
+
+	m.broadcastUpstreamFrame("u1", `{"code":7,"content":"a"}`)
+	frame := readDownstreamCode(t, firstClient, 7)
+	lastSeq := int64(toInt(frame["seq"]))
+	if lastSeq <= 0 {
+		t.Fatalf("expected seq on broadcast frame, got %v", frame)
+	}
+
+	// 下游断开期间继续收到上游帧（含无法附加 seq 的非 JSON 帧）。
+	m.UnregisterDownstream("u1", first)
+	m.broadcastUpstreamFrame("u1", `{"code":7,"content":"b"}`)
+	m.broadcastUpstreamFrame("u1", "plain-text")
+	m.broadcastUpstreamFrame("u1", `{"code":15,"sel_userid":"u2"}`)
+
+	second, secondClient := newDownstreamPair(t)
+	epoch, _ := frame["epoch"].(string)
+	if epoch != m.replayEpoch {
+		t.Fatalf("frame epoch=%q, want %q", epoch, m.replayEpoch)
+	}
+	m.ResumeDownstream("u1", second, `{"act":"sign","id":"u1"}`, epoch, lastSeq)
+
+	b := readDownstreamCode(t, secondClient, 7)
+	if b["content"] != "b" || int64(toInt(b["seq"])) != lastSeq+1 {
+		t.Fatalf("unexpected replay frame: %v", b)
+	}
+	matched := readDownstreamCode(t, secondClient, 15)
+	if int64(toInt(matched["seq"])) != lastSeq+2 {
+		t.Fatalf("unexpected replay frame: %v", matched)
+	}
+	resumed := readDownstreamCode(t, secondClient, -9)
+	if toInt(resumed["replayed"]) != 2 || toBool(resumed["truncated"]) || toBool(resumed["reset"]) || resumed["epoch"] != epoch || int64(toInt(resumed["latestSeq"])) != lastSeq+2 {
+		t.Fatalf("unexpected resume frame: %v", resumed)
+	}
+
+	// 注册后新到达的帧正常推送。
+	m.broadcastUpstreamFrame("u1", `{"code":7,"content":"c"}`)
+	if c := readDownstreamCode(t, secondClient, 7); c["content"] != "c" {
+		t.Fatalf("unexpected live frame: %v", c)
+	}
+}
+
+func TestUpstreamWebSocketManager_ResumeDownstream_ReportsTruncated(t *testing.T) {
+	oldSize := wsReplayBufferSize
+	wsReplayBufferSize = 2
+	t.Cleanup(func() { wsReplayBufferSize = oldSize })
+
+	m := NewUpstreamWebSocketManager(nil, "ws://unused", nil, nil, nil)
+	t.Cleanup(m.CloseAllConnections)
+	m.mu.Lock()
+	m.upstreamClients["u1"] = NewUpstreamWebSocketClient("u1", "ws://unused", m)
+	m.mu.Unlock()
+
+	for i := 0; i < 4; i++ {
+		m.broadcastUpstreamFrame("u1", `{"code":7}`)
+	}
+
+	session, client := newDownstreamPair(t)
+	m.ResumeDownstream("u1", session, `{"act":"sign","id":"u1"}`, m.replayEpoch, 1)
+	resumed := readDownstreamCode(t, client, -9)
+	if toInt(resumed["replayed"]) != 2 || !toBool(resumed["truncated"]) {
+		t.Fatalf("unexpected resume frame: %v", resumed)
+	}
+
+	// lastSeq 超前时补发全部缓存并标记 truncated。
+	other, otherClient := newDownstreamPair(t)
+	m.ResumeDownstream("u1", other, `{"act":"sign","id":"u1"}`, m.replayEpoch, 999)
+	resumed = readDownstreamCode(t, otherClient, -9)
+	if toInt(resumed["replayed"]) != 2 || !toBool(resumed["truncated"]) || toBool(resumed["reset"]) {
+		t.Fatalf("unexpected resume frame: %v", resumed)
+	}
+
+	// 纪元不一致（服务端重启或其他副本接管）时 lastSeq 不可比较：即使 lastSeq 更小也整体补发并标记 reset。
+	stale, staleClient := newDownstreamPair(t)
+	m.ResumeDownstream("u1", stale, `{"act":"sign","id":"u1"}`, "old-epoch", 0)
+	resumed = readDownstreamCode(t, staleClient, -9)
+	if toInt(resumed["replayed"]) != 2 || !toBool(resumed["truncated"]) || !toBool(resumed["reset"]) || resumed["epoch"] != m.replayEpoch {
+		t.Fatalf("unexpected resume frame: %v", resumed)
+	}
+}
+
+func TestHandleWebSocket_SignWithLastSeqStripsFieldUpstream(t *testing.T) {
+	received := make(chan string, 4)
+	tracker := &wsConnTracker{}
+	upstream := newUpstreamWSServer(t, func(conn *websocket.Conn) {
+		tracker.add(conn)
+		for {
+			_, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			received <- string(data)
+		}
+	})
+	t.Cleanup(func() {
+		tracker.closeAll()
+		upstream.Close()
+	})
+
+	wsManager := NewUpstreamWebSocketManager(nil, toWSURL(upstream.URL), nil, nil, nil)
+	t.Cleanup(wsManager.CloseAllConnections)
+
+	jwtService := NewJWTService("secret-1", 1)
+	token, err := jwtService.GenerateToken()
+	if err != nil {
+		t.Fatalf("GenerateToken failed: %v", err)
+	}
+	app := &App{jwt: jwtService, wsManager: wsManager}
+	backend := httptest.NewServer(http.HandlerFunc(app.handleWebSocket))
+	t.Cleanup(backend.Close)
+
+	downstream, _, err := websocket.DefaultDialer.Dial(toWSURL(backend.URL)+"/ws?token="+url.QueryEscape(token), nil)
+	if err != nil {
+		t.Fatalf("dial downstream failed: %v", err)
+	}
+	t.Cleanup(func() { _ = downstream.Close() })
+
+	if err := downstream.WriteMessage(websocket.TextMessage, []byte(`{"act":"sign","id":"u1","lastSeq":0}`)); err != nil {
+		t.Fatalf("send sign failed: %v", err)
+	}
+
+	resumed := readDownstreamCode(t, downstream, -9)
+	if !toBool(resumed["resumed"]) || toInt(resumed["replayed"]) != 0 {
+		t.Fatalf("unexpected resume frame: %v", resumed)
+	}
+
+	select {
+	case got := <-received:
+		var node map[string]any
+		if err := json.Unmarshal([]byte(got), &node); err != nil {
+			t.Fatalf("unmarshal upstream sign: %v", err)
+		}
+		if _, ok := node["lastSeq"]; ok || node["act"] != "sign" || node["id"] != "u1" {
+			t.Fatalf("unexpected upstream sign: %s", got)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("timeout waiting upstream sign")
+	}
+}
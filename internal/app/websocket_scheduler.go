@@ -0,0 +1,242 @@
+package app
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"liao/internal/protocol"
+)
+
+// IdentityEvictionPolicy 为上游身份连接达到上限时的处理策略。
+type IdentityEvictionPolicy string
+
+const (
+	// IdentityEvictOldest 驱逐最早建立上游连接的身份（原固定行为）。
+	IdentityEvictOldest IdentityEvictionPolicy = "oldest"
+	// IdentityEvictLeastActive 驱逐最久没有收发消息的身份。
+	IdentityEvictLeastActive IdentityEvictionPolicy = "lru"
+	// IdentityEvictNever 不驱逐，直接拒绝新身份。
+	IdentityEvictNever IdentityEvictionPolicy = "never"
+)
+
+// ParseIdentityEvictionPolicy 解析策略名称，空值视为 oldest。
+func ParseIdentityEvictionPolicy(raw string) (IdentityEvictionPolicy, error) {
+	switch p := IdentityEvictionPolicy(strings.ToLower(strings.TrimSpace(raw))); p {
+	case "":
+		return IdentityEvictOldest, nil
+	case IdentityEvictOldest, IdentityEvictLeastActive, IdentityEvictNever:
+		return p, nil
+	default:
+		return "", fmt.Errorf("未知的驱逐策略: %s", raw)
+	}
+}
+
+// IdentitySchedulerConfig 为身份连接调度配置。
+type IdentitySchedulerConfig struct {
+	// Capacity 为同时保持的上游身份连接数上限，<=0 时使用默认值 2。
+	Capacity int
+	Policy   IdentityEvictionPolicy
+	// Pinned 中的身份永不被驱逐（仍计入容量）。
+	Pinned []string
+	// CloseDelay 为下游全部断开后延迟关闭上游的默认时长，<=0 时沿用 wsCloseDelay。
+	CloseDelay time.Duration
+	// CloseDelays 按身份覆盖 CloseDelay（可为 0，表示立即关闭）。
+	CloseDelays map[string]time.Duration
+}
+
+// identityScheduler 决定新身份能否建立上游连接以及需要驱逐谁；所有方法由调用方持有 manager.mu。
+type identityScheduler struct {
+	capacity    int
+	policy      IdentityEvictionPolicy
+	pinned      map[string]struct{}
+	closeDelay  time.Duration
+	closeDelays map[string]time.Duration
+
+	// lastActive 记录身份最近一次收发上游消息的毫秒时间戳（lru 策略依据）。
+	lastActive map[string]int64
+	// evicting 为已选中驱逐、正在等待关闭的身份，不再计入容量也不会被重复选中。
+	evicting map[string]struct{}
+
+	evictions  int64
+	rejections int64
+}
+
+func newIdentityScheduler(cfg IdentitySchedulerConfig) *identityScheduler {
+	s := &identityScheduler{
+		lastActive: make(map[string]int64),
+		evicting:   make(map[string]struct{}),
+	}
+	s.apply(cfg)
+	return s
+}
+
+func (s *identityScheduler) apply(cfg IdentitySchedulerConfig) {
+	s.capacity = cfg.Capacity
+	if s.capacity <= 0 {
+		s.capacity = wsMaxConcurrentIdentities
+	}
+	s.policy = cfg.Policy
+	if s.policy == "" {
+		s.policy = IdentityEvictOldest
+	}
+	s.pinned = make(map[string]struct{}, len(cfg.Pinned))
+	for _, id := range cfg.Pinned {
+		if id = strings.TrimSpace(id); id != "" {
+			s.pinned[id] = struct{}{}
+		}
+	}
+	s.closeDelay = cfg.CloseDelay
+	s.closeDelays = make(map[string]time.Duration, len(cfg.CloseDelays))
+	for id, d := range cfg.CloseDelays {
+		if id = strings.TrimSpace(id); id != "" && d >= 0 {
+			s.closeDelays[id] = d
+		}
+	}
+}
+
+func (s *identityScheduler) isPinned(userID string) bool {
+	_, ok := s.pinned[userID]
+	return ok
+}
+
+// closeDelayFor 返回身份的关闭延迟：按身份配置 > 默认配置 > wsCloseDelay。
+func (s *identityScheduler) closeDelayFor(userID string) time.Duration {
+	if d, ok := s.closeDelays[userID]; ok {
+		return d
+	}
+	if s.closeDelay > 0 {
+		return s.closeDelay
+	}
+	return wsCloseDelay
+}
+
+func (s *identityScheduler) touch(userID string, nowMilli int64) {
+	s.lastActive[userID] = nowMilli
+}
+
+func (s *identityScheduler) forget(userID string) {
+	delete(s.lastActive, userID)
+	delete(s.evicting, userID)
+}
+
+// admit 在新身份需要建立上游连接时调用：未满返回空；已满时按策略选出驱逐对象，无可驱逐对象则拒绝。
+func (s *identityScheduler) admit(userID string, upstream map[string]*UpstreamWebSocketClient, createdMilli map[string]int64) (evict string, reject bool) {
+	active := 0
+	for uid := range upstream {
+		if _, leaving := s.evicting[uid]; !leaving && uid != userID {
+			active++
+		}
+	}
+	if active < s.capacity {
+		return "", false
+	}
+	if s.policy == IdentityEvictNever {
+		s.rejections++
+		return "", true
+	}
+
+	victim := ""
+	victimTs := int64(0)
+	for uid := range upstream {
+		if uid == userID || s.isPinned(uid) {
+			continue
+		}
+		if _, leaving := s.evicting[uid]; leaving {
+			continue
+		}
+		ts := createdMilli[uid]
+		if s.policy == IdentityEvictLeastActive {
+			if last, ok := s.lastActive[uid]; ok && last > ts {
+				ts = last
+			}
+		}
+		if victim == "" || ts < victimTs || (ts == victimTs && uid < victim) {
+			victim, victimTs = uid, ts
+		}
+	}
+	if victim == "" {
+		s.rejections++
+		return "", true
+	}
+	s.evicting[victim] = struct{}{}
+	s.evictions++
+	return victim, false
+}
+
+// hasFreeSlot 判断不驱逐任何身份时是否还能为 userID 建立上游连接。
+func (s *identityScheduler) hasFreeSlot(userID string, upstream map[string]*UpstreamWebSocketClient) bool {
+	if _, ok := upstream[userID]; ok {
+		return true
+	}
+	active := 0
+	for uid := range upstream {
+		if _, leaving := s.evicting[uid]; !leaving {
+			active++
+		}
+	}
+	return active < s.capacity
+}
+
+// IdentitySchedulerEntry 为统计中的单个身份状态。
+type IdentitySchedulerEntry struct {
+	UserID            string `json:"userId"`
+	CreatedAt         int64  `json:"createdAt"`
+	LastActiveAt      int64  `json:"lastActiveAt"`
+	Pinned            bool   `json:"pinned"`
+	Evicting          bool   `json:"evicting"`
+	Downstream        int    `json:"downstream"`
+	CloseDelaySeconds int64  `json:"closeDelaySeconds"`
+}
+
+func (s *identityScheduler) stats(upstream map[string]*UpstreamWebSocketClient, createdMilli map[string]int64, downstream map[string]map[*DownstreamSession]struct{}) map[string]any {
+	entries := make([]IdentitySchedulerEntry, 0, len(upstream))
+	for uid := range upstream {
+		_, leaving := s.evicting[uid]
+		entries = append(entries, IdentitySchedulerEntry{
+			UserID:            uid,
+			CreatedAt:         createdMilli[uid],
+			LastActiveAt:      s.lastActive[uid],
+			Pinned:            s.isPinned(uid),
+			Evicting:          leaving,
+			Downstream:        len(downstream[uid]),
+			CloseDelaySeconds: int64(s.closeDelayFor(uid) / time.Second),
+		})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].UserID < entries[j].UserID })
+
+	pinned := make([]string, 0, len(s.pinned))
+	for uid := range s.pinned {
+		pinned = append(pinned, uid)
+	}
+	sort.Strings(pinned)
+
+	return map[string]any{
+		"capacity":   s.capacity,
+		"policy":     string(s.policy),
+		"pinned":     pinned,
+		"evictions":  s.evictions,
+		"rejections": s.rejections,
+		"identities": entries,
+	}
+}
+
+// ConfigureScheduler 替换身份连接调度配置；已建立的连接不受影响，下次准入判断时生效。
+func (m *UpstreamWebSocketManager) ConfigureScheduler(cfg IdentitySchedulerConfig) {
+	m.mu.Lock()
+	m.scheduler.apply(cfg)
+	m.mu.Unlock()
+}
+
+func (m *UpstreamWebSocketManager) touchIdentityLocked(userID string) {
+	m.scheduler.touch(userID, time.Now().UnixMilli())
+}
+
+func buildCapacityRejectMessage(capacity int) string {
+	return encodeLocalFrame(&protocol.CapacityReject{
+		Content:  fmt.Sprintf("当前在线身份已达上限（%d个），请稍后再试", capacity),
+		Rejected: true,
+		Capacity: capacity,
+	})
+}
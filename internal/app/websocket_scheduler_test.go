@@ -0,0 +1,159 @@
+package app
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestParseIdentityEvictionPolicy(t *testing.T) {
+	for raw, want := range map[string]IdentityEvictionPolicy{"": IdentityEvictOldest, " LRU ": IdentityEvictLeastActive, "never": IdentityEvictNever} {
+		if got, err := ParseIdentityEvictionPolicy(raw); err != nil || got != want {
+			t.Fatalf("raw=%q got=%q err=%v", raw, got, err)
+		}
+	}
+	if _, err := ParseIdentityEvictionPolicy("random"); err == nil {
+		t.Fatalf("expected error for unknown policy")
+	}
+}
+
+func TestIdentityScheduler_AdmitPolicies(t *testing.T) {
+	upstream := map[string]*UpstreamWebSocketClient{"u1": nil, "u2": nil}
+	created := map[string]int64{"u1": 1, "u2": 2}
+
+	s := newIdentityScheduler(IdentitySchedulerConfig{Capacity: 3})
+	if evict, reject := s.admit("u3", upstream, created); evict != "" || reject {
+		t.Fatalf("below capacity: evict=%q reject=%v", evict, reject)
+	}
+
+	// oldest：按建立时间驱逐，已在驱逐中的身份不计入容量也不会被重复选中。
+	s = newIdentityScheduler(IdentitySchedulerConfig{Capacity: 2})
+	if evict, _ := s.admit("u3", upstream, created); evict != "u1" {
+		t.Fatalf("oldest evict=%q", evict)
+	}
+	if evict, reject := s.admit("u4", upstream, created); evict != "" || reject {
+		t.Fatalf("evicting slot should be free: evict=%q reject=%v", evict, reject)
+	}
+
+	// lru：u1 最近有消息，驱逐更久未活跃的 u2。
+	s = newIdentityScheduler(IdentitySchedulerConfig{Capacity: 2, Policy: IdentityEvictLeastActive})
+	s.touch("u1", 10)
+	if evict, _ := s.admit("u3", upstream, created); evict != "u2" {
+		t.Fatalf("lru evict=%q", evict)
+	}
+
+	// pinned：唯一可驱逐对象被固定时拒绝新身份。
+	s = newIdentityScheduler(IdentitySchedulerConfig{Capacity: 2, Pinned: []string{"u1", " u2 "}})
+	if evict, reject := s.admit("u3", upstream, created); evict != "" || !reject || s.rejections != 1 {
+		t.Fatalf("pinned evict=%q reject=%v", evict, reject)
+	}
+
+	s = newIdentityScheduler(IdentitySchedulerConfig{Capacity: 2, Policy: IdentityEvictNever})
+	if evict, reject := s.admit("u3", upstream, created); evict != "" || !reject {
+		t.Fatalf("never evict=%q reject=%v", evict, reject)
+	}
+	// 已有上游连接的身份重新注册不占新名额。
+	if _, reject := s.admit("u1", upstream, created); reject {
+		t.Fatalf("existing identity should not be rejected")
+	}
+}
+
+func TestIdentityScheduler_CloseDelayFor(t *testing.T) {
+	oldDelay := wsCloseDelay
+	wsCloseDelay = 7 * time.Second
+	t.Cleanup(func() { wsCloseDelay = oldDelay })
+
+	s := newIdentityScheduler(IdentitySchedulerConfig{})
+	if got := s.closeDelayFor("u1"); got != 7*time.Second {
+		t.Fatalf("default delay=%v", got)
+	}
+	s.apply(IdentitySchedulerConfig{CloseDelay: time.Minute, CloseDelays: map[string]time.Duration{"u1": 0, "u2": time.Hour, "u3": -1}})
+	if s.closeDelayFor("u1") != 0 || s.closeDelayFor("u2") != time.Hour || s.closeDelayFor("u3") != time.Minute {
+		t.Fatalf("delays=%v", s.closeDelays)
+	}
+}
+
+func TestUpstreamWebSocketManager_Scheduler_RejectsWhenFull(t *testing.T) {
+	fake := startFakeUpstream(t)
+
+	m := NewUpstreamWebSocketManager(&http.Client{Timeout: 2 * time.Second}, "ws://unused", nil, nil, nil)
+	m.ConfigureScheduler(IdentitySchedulerConfig{Capacity: 1, Policy: IdentityEvictNever})
+	t.Cleanup(m.CloseAllConnections)
+
+	s1, _ := newDownstreamPair(t)
+	m.RegisterDownstream("u1", s1, `{"act":"sign","id":"u1","name":"A"}`)
+	waitFor(t, "u1 sign", func() bool { return fake.Connected("u1") })
+
+	s2, c2 := newDownstreamPair(t)
+	m.RegisterDownstream("u2", s2, `{"act":"sign","id":"u2","name":"B"}`)
+	got := readDownstreamCode(t, c2, -10)
+	if got["rejected"] != true || toInt(got["capacity"]) != 1 {
+		t.Fatalf("unexpected reject frame: %v", got)
+	}
+
+	m.mu.Lock()
+	_, hasSessions := m.downstreamSessions["u2"]
+	_, hasSign := m.signMessages["u2"]
+	m.mu.Unlock()
+	if hasSessions || hasSign || fake.Connected("u2") {
+		t.Fatalf("rejected identity should leave no state")
+	}
+
+	stats := m.GetConnectionStats()
+	scheduler, _ := stats["scheduler"].(map[string]any)
+	if stats["maxIdentities"] != 1 || stats["availableSlots"] != 0 || scheduler["rejections"] != int64(1) || scheduler["policy"] != "never" {
+		t.Fatalf("stats=%v", stats)
+	}
+	if entries, _ := scheduler["identities"].([]IdentitySchedulerEntry); len(entries) != 1 || entries[0].UserID != "u1" || entries[0].Downstream != 1 {
+		t.Fatalf("identities=%v", scheduler["identities"])
+	}
+}
+
+func TestUpstreamWebSocketManager_Scheduler_PinnedAndPerIdentityCloseDelay(t *testing.T) {
+	fake := startFakeUpstream(t)
+
+	oldEviction := wsEvictionDelay
+	wsEvictionDelay = 10 * time.Millisecond
+	t.Cleanup(func() { wsEvictionDelay = oldEviction })
+
+	m := NewUpstreamWebSocketManager(&http.Client{Timeout: 2 * time.Second}, "ws://unused", nil, nil, nil)
+	m.ConfigureScheduler(IdentitySchedulerConfig{
+		Capacity:    2,
+		Pinned:      []string{"u1"},
+		CloseDelay:  time.Hour,
+		CloseDelays: map[string]time.Duration{"u3": 0},
+	})
+	t.Cleanup(m.CloseAllConnections)
+
+	s1, _ := newDownstreamPair(t)
+	m.RegisterDownstream("u1", s1, `{"act":"sign","id":"u1","name":"A"}`)
+	s2, c2 := newDownstreamPair(t)
+	m.RegisterDownstream("u2", s2, `{"act":"sign","id":"u2","name":"B"}`)
+	waitFor(t, "u1/u2 sign", func() bool { return fake.Connected("u1") && fake.Connected("u2") })
+
+	// u1 更早建立但被固定，驱逐 u2。
+	m.mu.Lock()
+	m.connectionCreateMilli["u1"] = 1
+	m.mu.Unlock()
+	s3, _ := newDownstreamPair(t)
+	m.RegisterDownstream("u3", s3, `{"act":"sign","id":"u3","name":"C"}`)
+	readDownstreamCode(t, c2, -6)
+	waitFor(t, "u2 evicted", func() bool { return !fake.Connected("u2") })
+	if !fake.Connected("u1") {
+		t.Fatalf("pinned identity should stay connected")
+	}
+	waitFor(t, "u3 sign", func() bool { return fake.Connected("u3") })
+
+	// u3 的关闭延迟为 0：下游断开后立即关闭上游；u1 使用默认 1 小时。
+	m.UnregisterDownstream("u3", s3)
+	m.UnregisterDownstream("u1", s1)
+	waitFor(t, "u3 closed", func() bool { return !fake.Connected("u3") })
+	if !fake.Connected("u1") {
+		t.Fatalf("u1 should wait for default close delay")
+	}
+
+	scheduler, _ := m.GetConnectionStats()["scheduler"].(map[string]any)
+	if scheduler["evictions"] != int64(1) {
+		t.Fatalf("scheduler=%v", scheduler)
+	}
+}
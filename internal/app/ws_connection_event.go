@@ -0,0 +1,302 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"liao/internal/database"
+)
+
+const (
+	WSConnectionEventConnect    = "connect"
+	WSConnectionEventDisconnect = "disconnect"
+	WSConnectionEventEvict      = "evict"
+	WSConnectionEventForceout   = "forceout"
+	WSConnectionEventReconnect  = "reconnect"
+
+	WSConnectionEventGroupByType = "eventType"
+	WSConnectionEventGroupByUser = "userId"
+
+	wsConnectionEventDefaultPageSize  = 20
+	wsConnectionEventMaxPageSize      = 200
+	wsConnectionEventMaxAggregateRows = 500
+	wsConnectionEventQueueSize        = 1024
+	// 截断后会追加 "..."，留出余量以不超过列宽（512/255）。
+	wsConnectionEventMaxURLRunes    = 500
+	wsConnectionEventMaxReasonRunes = 250
+)
+
+// wsConnectionEventWriteTimeout 为后台写入单条事件的超时。
+var wsConnectionEventWriteTimeout = 5 * time.Second
+
+var wsConnectionEventTypes = map[string]struct{}{
+	WSConnectionEventConnect:    {},
+	WSConnectionEventDisconnect: {},
+	WSConnectionEventEvict:      {},
+	WSConnectionEventForceout:   {},
+	WSConnectionEventReconnect:  {},
+}
+
+// WSConnectionEvent 为一条上游连接生命周期事件。
+// DurationMs：connect 为建连耗时，disconnect/evict/forceout 为连接存活时长，reconnect 为本次退避延迟。
+type WSConnectionEvent struct {
+	ID              int64  `json:"id"`
+	UserID          string `json:"userId"`
+	EventType       string `json:"eventType"`
+	UpstreamURL     string `json:"upstreamUrl,omitempty"`
+	Reason          string `json:"reason,omitempty"`
+	DurationMs      int64  `json:"durationMs"`
+	DownstreamCount int    `json:"downstreamCount"`
+	CreateTime      string `json:"createTime"`
+
+	// createdAt 为事件发生时间，由管理器在入队时填充，避免异步写入带来的时间偏差。
+	createdAt time.Time
+}
+
+// WSConnectionEventQuery 为事件查询条件；Page 从 1 开始，聚合查询忽略分页参数。
+type WSConnectionEventQuery struct {
+	UserID    string
+	EventType string
+	Since     time.Time
+	Until     time.Time
+	Page      int
+	PageSize  int
+}
+
+// WSConnectionEventAggregate 为按事件类型或身份分组的汇总。
+type WSConnectionEventAggregate struct {
+	Key                string  `json:"key"`
+	Count              int64   `json:"count"`
+	AvgDurationMs      float64 `json:"avgDurationMs"`
+	MaxDurationMs      int64   `json:"maxDurationMs"`
+	MaxDownstreamCount int     `json:"maxDownstreamCount"`
+	FirstTime          string  `json:"firstTime"`
+	LastTime           string  `json:"lastTime"`
+}
+
+// WSConnectionEventStore 定义连接生命周期事件的持久化能力。
+type WSConnectionEventStore interface {
+	// Enqueue 异步写入事件，不得阻塞调用方（管理器可能持有锁）。
+	Enqueue(event WSConnectionEvent)
+	List(ctx context.Context, query WSConnectionEventQuery) ([]WSConnectionEvent, int, error)
+	Aggregate(ctx context.Context, query WSConnectionEventQuery, groupBy string) ([]WSConnectionEventAggregate, error)
+}
+
+// DBWSConnectionEventService 基于数据库实现 WSConnectionEventStore，事件经有界队列由后台协程写入，队列满时丢弃并计数。
+type DBWSConnectionEventService struct {
+	db     *database.DB
+	writer *asyncWriter[WSConnectionEvent]
+}
+
+// NewDBWSConnectionEventService 创建数据库连接事件服务并启动后台写入协程。
+func NewDBWSConnectionEventService(db *database.DB) *DBWSConnectionEventService {
+	if db == nil {
+		return nil
+	}
+	svc := &DBWSConnectionEventService{db: db}
+	svc.writer = newAsyncWriter("连接事件", wsConnectionEventQueueSize, wsConnectionEventWriteTimeout, svc.Record,
+		func(event WSConnectionEvent) []any {
+			return []any{"userId", event.UserID, "eventType", event.EventType}
+		})
+	return svc
+}
+
+func (s *DBWSConnectionEventService) Enqueue(event WSConnectionEvent) {
+	if s == nil {
+		return
+	}
+	if event.createdAt.IsZero() {
+		event.createdAt = time.Now()
+	}
+	s.writer.Enqueue(event)
+}
+
+// Dropped 返回因队列已满或服务已关闭而丢弃的事件数。
+func (s *DBWSConnectionEventService) Dropped() int64 {
+	if s == nil {
+		return 0
+	}
+	return s.writer.Dropped()
+}
+
+// Record 同步写入一条事件。
+func (s *DBWSConnectionEventService) Record(ctx context.Context, event WSConnectionEvent) error {
+	if s == nil || s.db == nil {
+		return fmt.Errorf("db not initialized")
+	}
+	userID := strings.TrimSpace(event.UserID)
+	if userID == "" {
+		return errBadRequest("userId 不能为空")
+	}
+	if _, ok := wsConnectionEventTypes[event.EventType]; !ok {
+		return errBadRequest("未知的连接事件类型: " + event.EventType)
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	createdAt := event.createdAt
+	if createdAt.IsZero() {
+		createdAt = time.Now()
+	}
+
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO ws_connection_event (user_id, event_type, upstream_url, reason, duration_ms, downstream_count, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, userID, event.EventType,
+		nullIfEmpty(truncateRunes(event.UpstreamURL, wsConnectionEventMaxURLRunes)),
+		nullIfEmpty(truncateRunes(event.Reason, wsConnectionEventMaxReasonRunes)),
+		max(event.DurationMs, 0), max(event.DownstreamCount, 0), createdAt)
+	return err
+}
+
+// List 按时间倒序分页返回事件及满足条件的总数。
+func (s *DBWSConnectionEventService) List(ctx context.Context, query WSConnectionEventQuery) ([]WSConnectionEvent, int, error) {
+	if s == nil || s.db == nil {
+		return nil, 0, fmt.Errorf("db not initialized")
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	where, args, err := buildWSConnectionEventWhere(query)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var total int
+	if err := s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM ws_connection_event WHERE `+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	page, pageSize := normalizePage(query.Page, query.PageSize)
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, user_id, event_type, upstream_url, reason, duration_ms, downstream_count, created_at
+		FROM ws_connection_event
+		WHERE `+where+`
+		ORDER BY created_at DESC, id DESC
+		LIMIT ? OFFSET ?
+	`, append(args, pageSize, (page-1)*pageSize)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	out := make([]WSConnectionEvent, 0)
+	for rows.Next() {
+		var item WSConnectionEvent
+		var upstreamURL, reason sql.NullString
+		var created sql.NullTime
+		if err := rows.Scan(&item.ID, &item.UserID, &item.EventType, &upstreamURL, &reason, &item.DurationMs, &item.DownstreamCount, &created); err != nil {
+			return nil, 0, err
+		}
+		item.UpstreamURL = upstreamURL.String
+		item.Reason = reason.String
+		item.CreateTime = formatNullLocalDateTimeISO(created)
+		out = append(out, item)
+	}
+	return out, total, rows.Err()
+}
+
+// Aggregate 按事件类型（默认）或身份分组统计次数、耗时与下游会话数，按次数倒序返回。
+func (s *DBWSConnectionEventService) Aggregate(ctx context.Context, query WSConnectionEventQuery, groupBy string) ([]WSConnectionEventAggregate, error) {
+	if s == nil || s.db == nil {
+		return nil, fmt.Errorf("db not initialized")
+	}
+	var column string
+	switch strings.TrimSpace(groupBy) {
+	case "", WSConnectionEventGroupByType:
+		column = "event_type"
+	case WSConnectionEventGroupByUser:
+		column = "user_id"
+	default:
+		return nil, errBadRequest("groupBy 仅支持 eventType 或 userId")
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	where, args, err := buildWSConnectionEventWhere(query)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT `+column+`, COUNT(*), AVG(duration_ms), MAX(duration_ms), MAX(downstream_count), MIN(created_at), MAX(created_at)
+		FROM ws_connection_event
+		WHERE `+where+`
+		GROUP BY `+column+`
+		ORDER BY COUNT(*) DESC
+		LIMIT ?
+	`, append(args, wsConnectionEventMaxAggregateRows)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]WSConnectionEventAggregate, 0)
+	for rows.Next() {
+		var item WSConnectionEventAggregate
+		var avg sql.NullFloat64
+		var maxDuration, maxDownstream sql.NullInt64
+		var first, last sql.NullTime
+		if err := rows.Scan(&item.Key, &item.Count, &avg, &maxDuration, &maxDownstream, &first, &last); err != nil {
+			return nil, err
+		}
+		item.AvgDurationMs = avg.Float64
+		item.MaxDurationMs = maxDuration.Int64
+		item.MaxDownstreamCount = int(maxDownstream.Int64)
+		item.FirstTime = formatNullLocalDateTimeISO(first)
+		item.LastTime = formatNullLocalDateTimeISO(last)
+		out = append(out, item)
+	}
+	return out, rows.Err()
+}
+
+func (s *DBWSConnectionEventService) Close() error {
+	if s == nil {
+		return nil
+	}
+	s.writer.Close()
+	return nil
+}
+
+func buildWSConnectionEventWhere(query WSConnectionEventQuery) (string, []any, error) {
+	var where strings.Builder
+	where.WriteString("1 = 1")
+	args := make([]any, 0, 4)
+	if userID := strings.TrimSpace(query.UserID); userID != "" {
+		where.WriteString(" AND user_id = ?")
+		args = append(args, userID)
+	}
+	if eventType := strings.TrimSpace(query.EventType); eventType != "" {
+		if _, ok := wsConnectionEventTypes[eventType]; !ok {
+			return "", nil, errBadRequest("未知的连接事件类型: " + eventType)
+		}
+		where.WriteString(" AND event_type = ?")
+		args = append(args, eventType)
+	}
+	if !query.Since.IsZero() {
+		where.WriteString(" AND created_at >= ?")
+		args = append(args, query.Since)
+	}
+	if !query.Until.IsZero() {
+		where.WriteString(" AND created_at < ?")
+		args = append(args, query.Until)
+	}
+	return where.String(), args, nil
+}
+
+// normalizePage 规范化分页参数：page 从 1 开始，pageSize 默认 wsConnectionEventDefaultPageSize、最大 wsConnectionEventMaxPageSize；各记录列表接口共用。
+func normalizePage(page, pageSize int) (int, int) {
+	if page <= 0 {
+		page = 1
+	}
+	if pageSize <= 0 {
+		pageSize = wsConnectionEventDefaultPageSize
+	}
+	if pageSize > wsConnectionEventMaxPageSize {
+		pageSize = wsConnectionEventMaxPageSize
+	}
+	return page, pageSize
+}
@@ -0,0 +1,102 @@
+package app
+
+import (
+	"net/http"
+	"strings"
+	"time"
+)
+
+// parseWSConnectionEventQuery 解析 userId/eventType/since/until 过滤条件；时间格式非法时返回 badRequest 错误。
+func parseWSConnectionEventQuery(r *http.Request) (WSConnectionEventQuery, error) {
+	q := r.URL.Query()
+	query := WSConnectionEventQuery{
+		UserID:    strings.TrimSpace(q.Get("userId")),
+		EventType: strings.TrimSpace(q.Get("eventType")),
+		Page:      parseIntDefault(q.Get("page"), 1),
+		PageSize:  parseIntDefault(q.Get("pageSize"), wsConnectionEventDefaultPageSize),
+	}
+	for _, item := range []struct {
+		name   string
+		target *time.Time
+	}{{"since", &query.Since}, {"until", &query.Until}} {
+		raw := strings.TrimSpace(q.Get(item.name))
+		if raw == "" {
+			continue
+		}
+		t := parseOptionalLocalDateTimeISO(raw)
+		if t == nil {
+			return query, errBadRequest(item.name + "时间格式非法")
+		}
+		*item.target = *t
+	}
+	return query, nil
+}
+
+func (a *App) handleListWSConnectionEvents(w http.ResponseWriter, r *http.Request) {
+	if a.wsConnectionEvents == nil {
+		writeJSON(w, http.StatusOK, map[string]any{"code": -1, "msg": "连接事件存储未初始化"})
+		return
+	}
+
+	query, err := parseWSConnectionEventQuery(r)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"code": 400, "msg": err.Error()})
+		return
+	}
+	items, total, err := a.wsConnectionEvents.List(r.Context(), query)
+	if err != nil {
+		if isBadRequestError(err) {
+			writeJSON(w, http.StatusBadRequest, map[string]any{"code": 400, "msg": err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusInternalServerError, map[string]any{"code": -1, "msg": "查询连接事件失败: " + err.Error()})
+		return
+	}
+	page, pageSize := normalizePage(query.Page, query.PageSize)
+	writeJSON(w, http.StatusOK, map[string]any{
+		"code": 0,
+		"msg":  "success",
+		"data": map[string]any{
+			"items":      items,
+			"total":      total,
+			"page":       page,
+			"pageSize":   pageSize,
+			"totalPages": calcTotalPages(total, pageSize),
+		},
+	})
+}
+
+func (a *App) handleGetWSConnectionEventStats(w http.ResponseWriter, r *http.Request) {
+	if a.wsConnectionEvents == nil {
+		writeJSON(w, http.StatusOK, map[string]any{"code": -1, "msg": "连接事件存储未初始化"})
+		return
+	}
+
+	query, err := parseWSConnectionEventQuery(r)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"code": 400, "msg": err.Error()})
+		return
+	}
+	groupBy := strings.TrimSpace(r.URL.Query().Get("groupBy"))
+	if groupBy == "" {
+		groupBy = WSConnectionEventGroupByType
+	}
+	items, err := a.wsConnectionEvents.Aggregate(r.Context(), query, groupBy)
+	if err != nil {
+		if isBadRequestError(err) {
+			writeJSON(w, http.StatusBadRequest, map[string]any{"code": 400, "msg": err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusInternalServerError, map[string]any{"code": -1, "msg": "统计连接事件失败: " + err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"code": 0,
+		"msg":  "success",
+		"data": map[string]any{
+			"groupBy": groupBy,
+			"items":   items,
+			"dropped": a.wsConnectionEvents.Dropped(),
+		},
+	})
+}
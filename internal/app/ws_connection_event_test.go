@@ -0,0 +1,229 @@
+package app
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestDBWSConnectionEventService_RecordAndEnqueue(t *testing.T) {
+	if NewDBWSConnectionEventService(nil) != nil {
+		t.Fatalf("expected nil service for nil db")
+	}
+	svc, mock := newMockDBService(t, NewDBWSConnectionEventService)
+
+	if err := svc.Record(context.Background(), WSConnectionEvent{UserID: " ", EventType: WSConnectionEventConnect}); !isBadRequestError(err) {
+		t.Fatalf("expected userId validation error, got %v", err)
+	}
+	if err := svc.Record(context.Background(), WSConnectionEvent{UserID: "u1", EventType: "bogus"}); !isBadRequestError(err) {
+		t.Fatalf("expected eventType validation error, got %v", err)
+	}
+
+	mock.ExpectExec(`INSERT INTO ws_connection_event \(user_id, event_type, upstream_url, reason, duration_ms, downstream_count, created_at\)`).
+		WithArgs("u1", WSConnectionEventDisconnect, "ws://up:1", "idle", int64(1500), 2, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	if err := svc.Record(context.Background(), WSConnectionEvent{UserID: " u1 ", EventType: WSConnectionEventDisconnect, UpstreamURL: "ws://up:1", Reason: "idle", DurationMs: 1500, DownstreamCount: 2}); err != nil {
+		t.Fatalf("Record: %v", err)
+	}
+
+	// 异步入队的事件在 Close 前写完；关闭后入队的事件直接丢弃。
+	mock.ExpectExec(`INSERT INTO ws_connection_event`).
+		WithArgs("u2", WSConnectionEventConnect, nil, nil, int64(0), 0, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(2, 1))
+	svc.Enqueue(WSConnectionEvent{UserID: "u2", EventType: WSConnectionEventConnect, DurationMs: -5})
+	if err := svc.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	svc.Enqueue(WSConnectionEvent{UserID: "u3", EventType: WSConnectionEventConnect})
+	if svc.Dropped() != 1 {
+		t.Fatalf("dropped=%d, want 1", svc.Dropped())
+	}
+}
+
+func TestDBWSConnectionEventService_ListAndAggregate(t *testing.T) {
+	svc, mock := newMockDBService(t, NewDBWSConnectionEventService)
+	now := time.Now()
+	since := now.Add(-time.Hour)
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT COUNT(*) FROM ws_connection_event WHERE 1 = 1 AND user_id = ? AND event_type = ? AND created_at >= ? AND created_at < ?")).
+		WithArgs("u1", WSConnectionEventDisconnect, since, now).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(45))
+	mock.ExpectQuery(`FROM ws_connection_event\s+WHERE .+\s+ORDER BY created_at DESC, id DESC\s+LIMIT \? OFFSET \?`).
+		WithArgs("u1", WSConnectionEventDisconnect, since, now, wsConnectionEventMaxPageSize, wsConnectionEventMaxPageSize).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "event_type", "upstream_url", "reason", "duration_ms", "downstream_count", "created_at"}).
+			AddRow(2, "u1", WSConnectionEventDisconnect, "ws://up:1", "idle", 1200, 0, now).
+			AddRow(1, "u1", WSConnectionEventDisconnect, nil, nil, 0, 1, now))
+	items, total, err := svc.List(context.Background(), WSConnectionEventQuery{UserID: "u1", EventType: WSConnectionEventDisconnect, Since: since, Until: now, Page: 2, PageSize: 9999})
+	if err != nil || total != 45 || len(items) != 2 || items[0].UpstreamURL != "ws://up:1" || items[1].Reason != "" || items[0].CreateTime == "" {
+		t.Fatalf("items=%+v total=%d err=%v", items, total, err)
+	}
+
+	if _, _, err := svc.List(context.Background(), WSConnectionEventQuery{EventType: "bogus"}); !isBadRequestError(err) {
+		t.Fatalf("expected eventType validation error, got %v", err)
+	}
+	if _, err := svc.Aggregate(context.Background(), WSConnectionEventQuery{}, "bogus"); !isBadRequestError(err) {
+		t.Fatalf("expected groupBy validation error, got %v", err)
+	}
+
+	mock.ExpectQuery(`SELECT event_type, COUNT\(\*\), AVG\(duration_ms\).+\s+WHERE 1 = 1\s+GROUP BY event_type\s+ORDER BY COUNT\(\*\) DESC\s+LIMIT \?`).
+		WithArgs(wsConnectionEventMaxAggregateRows).
+		WillReturnRows(sqlmock.NewRows([]string{"key", "count", "avg", "max", "maxDownstream", "first", "last"}).
+			AddRow(WSConnectionEventConnect, 3, 120.5, 300, 2, since, now))
+	aggs, err := svc.Aggregate(context.Background(), WSConnectionEventQuery{}, "")
+	if err != nil || len(aggs) != 1 || aggs[0].Key != WSConnectionEventConnect || aggs[0].Count != 3 || aggs[0].AvgDurationMs != 120.5 || aggs[0].MaxDownstreamCount != 2 {
+		t.Fatalf("aggs=%+v err=%v", aggs, err)
+	}
+
+	mock.ExpectQuery(`SELECT user_id, COUNT\(\*\).+GROUP BY user_id`).
+		WithArgs(WSConnectionEventReconnect, wsConnectionEventMaxAggregateRows).
+		WillReturnRows(sqlmock.NewRows([]string{"key", "count", "avg", "max", "maxDownstream", "first", "last"}).
+			AddRow("u1", 1, nil, nil, nil, nil, nil))
+	aggs, err = svc.Aggregate(context.Background(), WSConnectionEventQuery{EventType: WSConnectionEventReconnect}, WSConnectionEventGroupByUser)
+	if err != nil || len(aggs) != 1 || aggs[0].Key != "u1" || aggs[0].AvgDurationMs != 0 || aggs[0].LastTime != "" {
+		t.Fatalf("aggs=%+v err=%v", aggs, err)
+	}
+}
+
+type stubWSConnectionEventStore struct {
+	mu     sync.Mutex
+	events []WSConnectionEvent
+}
+
+func (s *stubWSConnectionEventStore) Enqueue(event WSConnectionEvent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events = append(s.events, event)
+}
+
+func (s *stubWSConnectionEventStore) List(ctx context.Context, query WSConnectionEventQuery) ([]WSConnectionEvent, int, error) {
+	return nil, 0, nil
+}
+
+func (s *stubWSConnectionEventStore) Aggregate(ctx context.Context, query WSConnectionEventQuery, groupBy string) ([]WSConnectionEventAggregate, error) {
+	return nil, nil
+}
+
+func (s *stubWSConnectionEventStore) snapshot() []WSConnectionEvent {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]WSConnectionEvent(nil), s.events...)
+}
+
+func TestUpstreamWebSocketManager_RecordsConnectionEvents(t *testing.T) {
+	m := NewUpstreamWebSocketManager(nil, "ws://fallback", NewForceoutManager(), nil, nil)
+	store := &stubWSConnectionEventStore{}
+	m.SetConnectionEventStore(store)
+	m.reconnectMaxAttempts = 1
+	m.reconnectDelayFn = func(int) time.Duration { return time.Hour }
+	t.Cleanup(m.CloseAllConnections)
+
+	attach := func(userID string, age time.Duration) {
+		m.mu.Lock()
+		m.upstreamClients[userID] = NewUpstreamWebSocketClient(userID, "ws://up-"+userID, m)
+		m.connectionCreateMilli[userID] = time.Now().Add(-age).UnixMilli()
+		m.downstreamSessions[userID] = map[*DownstreamSession]struct{}{{}: {}, {}: {}}
+		m.signMessages[userID] = `{"act":"sign","id":"` + userID + `"}`
+		m.mu.Unlock()
+	}
+
+	attach("u1", 200*time.Millisecond)
+	m.HandleUpstreamConnected("u1")
+	m.HandleUpstreamDisconnect("u1")
+	attach("u1", time.Second)
+	m.HandleUpstreamConnected("u1")
+	m.HandleForceout("u1", `{"code":-3}`)
+	attach("u2", time.Minute)
+	m.CloseUpstreamConnection("u2")
+	m.HandleUpstreamDisconnect("u3")
+
+	events := store.snapshot()
+	want := []struct{ eventType, userID, url string }{
+		{WSConnectionEventConnect, "u1", "ws://up-u1"},
+		{WSConnectionEventDisconnect, "u1", "ws://up-u1"},
+		{WSConnectionEventReconnect, "u1", ""},
+		{WSConnectionEventConnect, "u1", "ws://up-u1"},
+		{WSConnectionEventForceout, "u1", "ws://up-u1"},
+		{WSConnectionEventDisconnect, "u2", "ws://up-u2"},
+	}
+	if len(events) != len(want) {
+		t.Fatalf("events=%+v", events)
+	}
+	for i, w := range want {
+		e := events[i]
+		if e.EventType != w.eventType || e.UserID != w.userID || e.UpstreamURL != w.url || e.DownstreamCount != 2 || e.createdAt.IsZero() {
+			t.Fatalf("event[%d]=%+v, want %+v", i, e, w)
+		}
+	}
+	if events[0].Reason != "sign" || events[3].Reason != "reconnected after 1 attempts" {
+		t.Fatalf("connect reasons=%q/%q", events[0].Reason, events[3].Reason)
+	}
+	if events[2].DurationMs != time.Hour.Milliseconds() || events[5].DurationMs < time.Minute.Milliseconds() {
+		t.Fatalf("durations=%d/%d", events[2].DurationMs, events[5].DurationMs)
+	}
+}
+
+func TestWSConnectionEventHandlers(t *testing.T) {
+	a := &App{}
+	rec := httptest.NewRecorder()
+	a.handleListWSConnectionEvents(rec, httptest.NewRequest(http.MethodGet, "/api/wsConnectionEvent/list", nil))
+	if got := decodeJSONBody(t, rec.Body); toInt(got["code"]) != -1 {
+		t.Fatalf("got=%v", got)
+	}
+	rec = httptest.NewRecorder()
+	a.handleGetWSConnectionEventStats(rec, httptest.NewRequest(http.MethodGet, "/api/wsConnectionEvent/stats", nil))
+	if got := decodeJSONBody(t, rec.Body); toInt(got["code"]) != -1 {
+		t.Fatalf("got=%v", got)
+	}
+
+	svc, mock := newMockDBService(t, NewDBWSConnectionEventService)
+	a.wsConnectionEvents = svc
+
+	rec = httptest.NewRecorder()
+	a.handleListWSConnectionEvents(rec, httptest.NewRequest(http.MethodGet, "/api/wsConnectionEvent/list?since=yesterday", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("status=%d, want 400", rec.Code)
+	}
+	rec = httptest.NewRecorder()
+	a.handleListWSConnectionEvents(rec, httptest.NewRequest(http.MethodGet, "/api/wsConnectionEvent/list?eventType=bogus", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("status=%d, want 400", rec.Code)
+	}
+
+	mock.ExpectQuery(`SELECT COUNT\(\*\) FROM ws_connection_event WHERE 1 = 1 AND user_id = \? AND created_at >= \?`).
+		WithArgs("u1", sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(3))
+	mock.ExpectQuery(`LIMIT \? OFFSET \?`).
+		WithArgs("u1", sqlmock.AnyArg(), 2, 0).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "event_type", "upstream_url", "reason", "duration_ms", "downstream_count", "created_at"}).
+			AddRow(1, "u1", WSConnectionEventConnect, "ws://up", "sign", 10, 1, time.Now()))
+	rec = httptest.NewRecorder()
+	a.handleListWSConnectionEvents(rec, httptest.NewRequest(http.MethodGet, "/api/wsConnectionEvent/list?userId=u1&since=2026-01-02T03:04:05&pageSize=2", nil))
+	got := decodeJSONBody(t, rec.Body)
+	data, _ := got["data"].(map[string]any)
+	if toInt(got["code"]) != 0 || toInt(data["total"]) != 3 || toInt(data["totalPages"]) != 2 || len(data["items"].([]any)) != 1 {
+		t.Fatalf("got=%v", got)
+	}
+
+	rec = httptest.NewRecorder()
+	a.handleGetWSConnectionEventStats(rec, httptest.NewRequest(http.MethodGet, "/api/wsConnectionEvent/stats?groupBy=host", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("status=%d, want 400", rec.Code)
+	}
+	mock.ExpectQuery(`GROUP BY user_id`).
+		WithArgs(wsConnectionEventMaxAggregateRows).
+		WillReturnRows(sqlmock.NewRows([]string{"key", "count", "avg", "max", "maxDownstream", "first", "last"}).
+			AddRow("u1", 4, 10.0, 20, 1, time.Now(), time.Now()))
+	rec = httptest.NewRecorder()
+	a.handleGetWSConnectionEventStats(rec, httptest.NewRequest(http.MethodGet, "/api/wsConnectionEvent/stats?groupBy=userId", nil))
+	got = decodeJSONBody(t, rec.Body)
+	data, _ = got["data"].(map[string]any)
+	if toInt(got["code"]) != 0 || data["groupBy"] != WSConnectionEventGroupByUser || len(data["items"].([]any)) != 1 {
+		t.Fatalf("got=%v", got)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"unicode/utf8"
 )
 
 // Config 表示服务运行所需配置。
@@ -38,11 +39,22 @@ type Config struct {
 	// 默认 15 秒；可通过环境变量 REDIS_TIMEOUT_SECONDS 覆盖。
 	RedisTimeoutSeconds int
 
-	AuthAccessCode    string
+	AuthAccessCode string
+	// AuthAccessCodeDisabled 关闭共享访问码登录（AUTH_ACCESS_CODE_DISABLED，默认 false），开启后仅允许账号登录，已签发的访问码 Token 同时失效。
+	AuthAccessCodeDisabled bool
+	// AuthAccessCodeAllowWithTOTP 允许在已有账号启用两步验证后继续使用共享访问码（AUTH_ACCESS_CODE_ALLOW_WITH_TOTP，默认 false）；
+	// 访问码没有第二因素，默认在任一账号启用两步验证后自动关闭。
+	AuthAccessCodeAllowWithTOTP bool
+	// AuthAdminUsername/AuthAdminPassword 为数据库中尚无任何账号时自动创建的初始管理员（AUTH_ADMIN_USERNAME/AUTH_ADMIN_PASSWORD）。
+	AuthAdminUsername string
+	AuthAdminPassword string
 	RandomVIPCode     string
 	JWTSecret         string
-	TokenExpireHours  int
-	WebSocketFallback string
+	// TokenExpireHours 为登录会话（refresh token）有效期（TOKEN_EXPIRE_HOURS，默认 24）。
+	TokenExpireHours int
+	// AccessTokenExpireMinutes 为 access token 有效期（ACCESS_TOKEN_EXPIRE_MINUTES，默认 15），过期后客户端用 refresh token 换发。
+	AccessTokenExpireMinutes int
+	WebSocketFallback        string
 
 	CacheType                   string
 	CacheRedisKeyPrefix         string
@@ -101,6 +113,87 @@ type Config struct {
 	VideoExtractWorkers     int
 	VideoExtractQueueSize   int
 	VideoExtractFramePageSz int
+
+	// WSMaxIdentities 为同时保持的上游身份连接数上限（WS_MAX_IDENTITIES，默认 2）。
+	WSMaxIdentities int
+	// WSEvictionPolicy 为达到上限时的驱逐策略：oldest（最早建立）/lru（最久未活跃）/never（拒绝新身份）。
+	WSEvictionPolicy string
+	// WSPinnedIdentities 为永不被驱逐的身份列表（WS_PINNED_IDENTITIES，逗号分隔）。
+	WSPinnedIdentities []string
+	// WSCloseDelaySeconds 为下游全部断开后延迟关闭上游连接的默认秒数（WS_CLOSE_DELAY_SECONDS，默认 80）。
+	WSCloseDelaySeconds int
+	// WSIdentityCloseDelaySeconds 为按身份覆盖的关闭延迟（WS_IDENTITY_CLOSE_DELAYS，格式 "id=秒,id=秒"，0 表示立即关闭）。
+	WSIdentityCloseDelaySeconds map[string]int
+
+	// ForceoutBanSeconds 为收到上游 forceout 后禁止该身份重连的秒数（FORCEOUT_BAN_SECONDS，默认 300）。
+	ForceoutBanSeconds int
+
+	// WSClusterEnabled 开启多副本协调（WS_CLUSTER_ENABLED）：通过 Redis（REDIS_* 连接参数）为每个身份选出唯一持有上游连接的副本。
+	WSClusterEnabled bool
+	// WSClusterReplicaID 为本副本标识（WS_CLUSTER_REPLICA_ID）；为空时按主机名生成。
+	WSClusterReplicaID string
+	// WSClusterKeyPrefix 为协调用 key/频道前缀（WS_CLUSTER_KEY_PREFIX，默认 liao:ws:）。
+	WSClusterKeyPrefix string
+	// WSClusterLeaseSeconds 为身份租约时长（WS_CLUSTER_LEASE_SECONDS，默认 30）；副本宕机后其他副本最多等待该时长接管。
+	WSClusterLeaseSeconds int
+
+	// WSRecordDir 为 WebSocket 会话录制（JSONL）目录；为空时不启用录制。
+	WSRecordDir string
+	// WSRecordUserIDs 为启动时即开启录制的身份列表（WS_RECORD_USER_IDS，逗号分隔）；运行期也可通过 API 开关。
+	WSRecordUserIDs []string
+
+	// WSFilterPhoneAction 为出站消息包含手机号时的处理方式（WS_FILTER_PHONE_ACTION：off/redact/block，默认 off）。
+	WSFilterPhoneAction string
+	// WSFilterBannedWords 为出站违禁词（WS_FILTER_BANNED_WORDS，逗号分隔，忽略大小写）。
+	WSFilterBannedWords []string
+	// WSFilterBannedWordAction 为命中违禁词时的处理方式（WS_FILTER_BANNED_WORD_ACTION：redact/block，默认 block）。
+	WSFilterBannedWordAction string
+	// WSFilterBlockedDomains 为禁止发送的链接域名，含子域名（WS_FILTER_BLOCKED_DOMAINS，逗号分隔）。
+	WSFilterBlockedDomains []string
+	// WSFilterDomainAction 为命中屏蔽域名时的处理方式（WS_FILTER_DOMAIN_ACTION：redact/block，默认 block）。
+	WSFilterDomainAction string
+	// WSFilterRewrites 为出站词语替换表（WS_FILTER_REWRITES，格式 "原词=替换词,原词=替换词"）。
+	WSFilterRewrites map[string]string
+
+	// WSUpstreamPingSeconds 为向上游发送 ping 的间隔秒数（WS_UPSTREAM_PING_SECONDS，默认 0 即不主动 ping）。
+	WSUpstreamPingSeconds int
+	// WSDownstreamPingSeconds 为向下游浏览器发送 ping 的间隔秒数（WS_DOWNSTREAM_PING_SECONDS，默认 30，0 表示关闭）。
+	WSDownstreamPingSeconds int
+	// WSPingMaxMissed 为判定对端失活前允许错过的心跳次数（WS_PING_MAX_MISSED，默认 3）。
+	WSPingMaxMissed int
+
+	// WSAllowedOrigins 为 /ws 允许的浏览器 Origin（WS_ALLOWED_ORIGINS，逗号分隔，如 https://chat.example.com）；为空不校验，同源始终放行。
+	WSAllowedOrigins []string
+	// WSMaxSessionsPerToken 为同一 token 同时保持的下游会话上限（WS_MAX_SESSIONS_PER_TOKEN，默认 8，0 表示不限制）。
+	WSMaxSessionsPerToken int
+	// WSRateLimitPerSecond 为单个下游会话每秒允许的入站帧数（WS_RATE_LIMIT_PER_SECOND，默认 20，0 表示不限速）。
+	WSRateLimitPerSecond float64
+	// WSRateLimitBurst 为入站帧令牌桶容量（WS_RATE_LIMIT_BURST，默认 40）。
+	WSRateLimitBurst int
+
+	// AuthLoginMaxFailures 为单个 IP 在统计窗口内允许的登录失败次数，达到后锁定该 IP（AUTH_LOGIN_MAX_FAILURES，默认 5，0 表示不按 IP 锁定）。
+	AuthLoginMaxFailures int
+	// AuthLoginGlobalMaxFailures 为统计窗口内全部来源的登录失败总数上限，达到后对所有来源限速（AUTH_LOGIN_GLOBAL_MAX_FAILURES，默认 100，0 表示不限制）。
+	AuthLoginGlobalMaxFailures int
+	// AuthLoginWindowSeconds 为登录失败计数窗口（AUTH_LOGIN_WINDOW_SECONDS，默认 900）。
+	AuthLoginWindowSeconds int
+	// AuthLoginLockoutSeconds 为触发锁定后的锁定时长（AUTH_LOGIN_LOCKOUT_SECONDS，默认 900）。
+	AuthLoginLockoutSeconds int
+	// AuthLoginBaseDelayMillis 为首次失败后下一次尝试的最小间隔，之后每次失败翻倍（AUTH_LOGIN_BASE_DELAY_MS，默认 1000，0 表示不限制间隔）。
+	AuthLoginBaseDelayMillis int
+	// AuthLoginTrustForwarded 为 true 时按 X-Forwarded-For/X-Real-IP 识别登录来源 IP（AUTH_LOGIN_TRUST_FORWARDED，默认 false，仅在可信反向代理后开启）。
+	AuthLoginTrustForwarded bool
+
+	// MediaURLSigning 为 true 时为媒体地址签发 ?exp=&sig= 并要求 /upload、/lsp 及免 Token 的媒体接口携带有效签名或 Token（MEDIA_URL_SIGNING，默认 false）。
+	MediaURLSigning bool
+	// MediaURLSecret 为媒体地址签名专用密钥（MEDIA_URL_SECRET），开启 MEDIA_URL_SIGNING 时必填且不得与 JWT_SECRET 相同。
+	MediaURLSecret string
+	// MediaURLTTLSeconds 为签名地址有效期（MEDIA_URL_TTL_SECONDS，默认 21600）。
+	MediaURLTTLSeconds int
+	// AuditLogRetentionDays 为写操作审计记录的保留天数，超期记录定期清理（AUDIT_LOG_RETENTION_DAYS，默认 90，0 表示永久保留）。
+	AuditLogRetentionDays int
+	// AuthTOTPIssuer 为两步验证绑定时写入验证器 App 的发行方名称（AUTH_TOTP_ISSUER，默认 Liao，不能包含冒号）。
+	AuthTOTPIssuer string
 }
 
 func Load() (Config, error) {
@@ -125,10 +218,15 @@ func Load() (Config, error) {
 		RedisDB:             getEnvInt("REDIS_DB", 0),
 		RedisTimeoutSeconds: getEnvInt("REDIS_TIMEOUT_SECONDS", 15),
 
-		AuthAccessCode:   getEnv("AUTH_ACCESS_CODE", "Aa305512775."),
-		RandomVIPCode:    getEnv("RANDOM_VIP_CODE", ""),
-		JWTSecret:        getEnv("JWT_SECRET", "your-jwt-secret-key-at-least-256-bits-long-please-change-this-to-random-string"),
-		TokenExpireHours: getEnvInt("TOKEN_EXPIRE_HOURS", 24),
+		AuthAccessCode:              getEnv("AUTH_ACCESS_CODE", "Aa305512775."),
+		AuthAccessCodeDisabled:      getEnvBool("AUTH_ACCESS_CODE_DISABLED", false),
+		AuthAccessCodeAllowWithTOTP: getEnvBool("AUTH_ACCESS_CODE_ALLOW_WITH_TOTP", false),
+		AuthAdminUsername:           strings.TrimSpace(getEnv("AUTH_ADMIN_USERNAME", "")),
+		AuthAdminPassword:           getEnv("AUTH_ADMIN_PASSWORD", ""),
+		RandomVIPCode:               getEnv("RANDOM_VIP_CODE", ""),
+		JWTSecret:                   getEnv("JWT_SECRET", "your-jwt-secret-key-at-least-256-bits-long-please-change-this-to-random-string"),
+		TokenExpireHours:            getEnvInt("TOKEN_EXPIRE_HOURS", 24),
+		AccessTokenExpireMinutes:    getEnvInt("ACCESS_TOKEN_EXPIRE_MINUTES", 15),
 
 		WebSocketFallback: getEnv("WEBSOCKET_UPSTREAM_URL", "ws://localhost:9999"),
 
@@ -179,6 +277,47 @@ func Load() (Config, error) {
 		VideoExtractWorkers:     getEnvInt("VIDEO_EXTRACT_WORKERS", 1),
 		VideoExtractQueueSize:   getEnvInt("VIDEO_EXTRACT_QUEUE_SIZE", 32),
 		VideoExtractFramePageSz: getEnvInt("VIDEO_EXTRACT_FRAME_PAGE_SIZE", 120),
+
+		WSMaxIdentities:     getEnvInt("WS_MAX_IDENTITIES", 2),
+		WSEvictionPolicy:    strings.ToLower(strings.TrimSpace(getEnv("WS_EVICTION_POLICY", "oldest"))),
+		WSPinnedIdentities:  getEnvList("WS_PINNED_IDENTITIES"),
+		WSCloseDelaySeconds: getEnvInt("WS_CLOSE_DELAY_SECONDS", 80),
+
+		ForceoutBanSeconds: getEnvInt("FORCEOUT_BAN_SECONDS", 300),
+
+		WSClusterEnabled:      getEnvBool("WS_CLUSTER_ENABLED", false),
+		WSClusterReplicaID:    strings.TrimSpace(getEnv("WS_CLUSTER_REPLICA_ID", "")),
+		WSClusterKeyPrefix:    strings.TrimSpace(getEnv("WS_CLUSTER_KEY_PREFIX", "liao:ws:")),
+		WSClusterLeaseSeconds: getEnvInt("WS_CLUSTER_LEASE_SECONDS", 30),
+
+		WSRecordDir:     strings.TrimSpace(getEnv("WS_RECORD_DIR", "")),
+		WSRecordUserIDs: getEnvList("WS_RECORD_USER_IDS"),
+
+		WSFilterPhoneAction:      strings.ToLower(strings.TrimSpace(getEnv("WS_FILTER_PHONE_ACTION", "off"))),
+		WSFilterBannedWords:      getEnvList("WS_FILTER_BANNED_WORDS"),
+		WSFilterBannedWordAction: strings.ToLower(strings.TrimSpace(getEnv("WS_FILTER_BANNED_WORD_ACTION", "block"))),
+		WSFilterBlockedDomains:   getEnvList("WS_FILTER_BLOCKED_DOMAINS"),
+		WSFilterDomainAction:     strings.ToLower(strings.TrimSpace(getEnv("WS_FILTER_DOMAIN_ACTION", "block"))),
+
+		WSUpstreamPingSeconds:   getEnvInt("WS_UPSTREAM_PING_SECONDS", 0),
+		WSDownstreamPingSeconds: getEnvInt("WS_DOWNSTREAM_PING_SECONDS", 30),
+		WSPingMaxMissed:         getEnvInt("WS_PING_MAX_MISSED", 3),
+
+		WSAllowedOrigins:      getEnvList("WS_ALLOWED_ORIGINS"),
+		WSMaxSessionsPerToken: getEnvInt("WS_MAX_SESSIONS_PER_TOKEN", 8),
+		WSRateLimitBurst:      getEnvInt("WS_RATE_LIMIT_BURST", 40),
+
+		AuthLoginMaxFailures:       getEnvInt("AUTH_LOGIN_MAX_FAILURES", 5),
+		AuthLoginGlobalMaxFailures: getEnvInt("AUTH_LOGIN_GLOBAL_MAX_FAILURES", 100),
+		AuthLoginWindowSeconds:     getEnvInt("AUTH_LOGIN_WINDOW_SECONDS", 900),
+		AuthLoginLockoutSeconds:    getEnvInt("AUTH_LOGIN_LOCKOUT_SECONDS", 900),
+		AuthLoginBaseDelayMillis:   getEnvInt("AUTH_LOGIN_BASE_DELAY_MS", 1000),
+		AuthLoginTrustForwarded:    getEnvBool("AUTH_LOGIN_TRUST_FORWARDED", false),
+		MediaURLSigning:            getEnvBool("MEDIA_URL_SIGNING", false),
+		MediaURLSecret:             strings.TrimSpace(os.Getenv("MEDIA_URL_SECRET")),
+		MediaURLTTLSeconds:         getEnvInt("MEDIA_URL_TTL_SECONDS", 21600),
+		AuditLogRetentionDays:      getEnvInt("AUDIT_LOG_RETENTION_DAYS", 90),
+		AuthTOTPIssuer:             strings.TrimSpace(getEnv("AUTH_TOTP_ISSUER", "Liao")),
 	}
 
 	if cfg.ServerPort <= 0 || cfg.ServerPort > 65535 {
@@ -188,6 +327,9 @@ func Load() (Config, error) {
 	if cfg.TokenExpireHours <= 0 {
 		return Config{}, fmt.Errorf("TOKEN_EXPIRE_HOURS 非法: %d", cfg.TokenExpireHours)
 	}
+	if cfg.AccessTokenExpireMinutes <= 0 {
+		return Config{}, fmt.Errorf("ACCESS_TOKEN_EXPIRE_MINUTES 非法: %d", cfg.AccessTokenExpireMinutes)
+	}
 
 	switch cfg.CacheType {
 	case "memory", "redis":
@@ -266,6 +408,113 @@ func Load() (Config, error) {
 		return Config{}, fmt.Errorf("MTPHOTO_TIMELINE_DEFER_SUBFOLDER_THRESHOLD 非法: %d（最大 500）", cfg.MtPhotoTimelineDeferSubfolderThreshold)
 	}
 
+	if cfg.WSMaxIdentities <= 0 {
+		return Config{}, fmt.Errorf("WS_MAX_IDENTITIES 非法: %d", cfg.WSMaxIdentities)
+	}
+	switch cfg.WSEvictionPolicy {
+	case "oldest", "lru", "never":
+	default:
+		return Config{}, fmt.Errorf("WS_EVICTION_POLICY 非法: %s（仅支持 oldest/lru/never）", cfg.WSEvictionPolicy)
+	}
+	if cfg.WSCloseDelaySeconds <= 0 {
+		return Config{}, fmt.Errorf("WS_CLOSE_DELAY_SECONDS 非法: %d", cfg.WSCloseDelaySeconds)
+	}
+	if cfg.ForceoutBanSeconds <= 0 {
+		return Config{}, fmt.Errorf("FORCEOUT_BAN_SECONDS 非法: %d", cfg.ForceoutBanSeconds)
+	}
+	if cfg.WSClusterLeaseSeconds < 3 {
+		return Config{}, fmt.Errorf("WS_CLUSTER_LEASE_SECONDS 非法: %d（最小 3）", cfg.WSClusterLeaseSeconds)
+	}
+	closeDelays, err := parseIdentitySeconds(os.Getenv("WS_IDENTITY_CLOSE_DELAYS"))
+	if err != nil {
+		return Config{}, fmt.Errorf("WS_IDENTITY_CLOSE_DELAYS 非法: %w", err)
+	}
+	cfg.WSIdentityCloseDelaySeconds = closeDelays
+
+	for key, action := range map[string]string{
+		"WS_FILTER_PHONE_ACTION":       cfg.WSFilterPhoneAction,
+		"WS_FILTER_BANNED_WORD_ACTION": cfg.WSFilterBannedWordAction,
+		"WS_FILTER_DOMAIN_ACTION":      cfg.WSFilterDomainAction,
+	} {
+		switch action {
+		case "off", "redact", "block":
+		default:
+			return Config{}, fmt.Errorf("%s 非法: %s（仅支持 off/redact/block）", key, action)
+		}
+	}
+	rewrites, err := parseKeyValueList(os.Getenv("WS_FILTER_REWRITES"))
+	if err != nil {
+		return Config{}, fmt.Errorf("WS_FILTER_REWRITES 非法: %w", err)
+	}
+	cfg.WSFilterRewrites = rewrites
+
+	if cfg.WSUpstreamPingSeconds < 0 {
+		return Config{}, fmt.Errorf("WS_UPSTREAM_PING_SECONDS 非法: %d", cfg.WSUpstreamPingSeconds)
+	}
+	if cfg.WSDownstreamPingSeconds < 0 {
+		return Config{}, fmt.Errorf("WS_DOWNSTREAM_PING_SECONDS 非法: %d", cfg.WSDownstreamPingSeconds)
+	}
+	if cfg.WSPingMaxMissed <= 0 {
+		return Config{}, fmt.Errorf("WS_PING_MAX_MISSED 非法: %d", cfg.WSPingMaxMissed)
+	}
+
+	for _, origin := range cfg.WSAllowedOrigins {
+		if origin == "*" {
+			continue
+		}
+		if u, err := url.Parse(origin); err != nil || u.Scheme == "" || u.Host == "" {
+			return Config{}, fmt.Errorf("WS_ALLOWED_ORIGINS 非法: %s（需为 scheme://host[:port] 或 *）", origin)
+		}
+	}
+	if cfg.WSMaxSessionsPerToken < 0 {
+		return Config{}, fmt.Errorf("WS_MAX_SESSIONS_PER_TOKEN 非法: %d", cfg.WSMaxSessionsPerToken)
+	}
+	rateLimit, err := strconv.ParseFloat(getEnv("WS_RATE_LIMIT_PER_SECOND", "20"), 64)
+	if err != nil || rateLimit < 0 {
+		return Config{}, fmt.Errorf("WS_RATE_LIMIT_PER_SECOND 非法: %s", os.Getenv("WS_RATE_LIMIT_PER_SECOND"))
+	}
+	cfg.WSRateLimitPerSecond = rateLimit
+	if cfg.WSRateLimitBurst <= 0 {
+		return Config{}, fmt.Errorf("WS_RATE_LIMIT_BURST 非法: %d", cfg.WSRateLimitBurst)
+	}
+
+	if (cfg.AuthAdminUsername == "") != (cfg.AuthAdminPassword == "") {
+		return Config{}, fmt.Errorf("AUTH_ADMIN_USERNAME/AUTH_ADMIN_PASSWORD 非法: 需同时配置")
+	}
+	if n := utf8.RuneCountInString(cfg.AuthAdminPassword); n > 0 && n < 8 {
+		return Config{}, fmt.Errorf("AUTH_ADMIN_PASSWORD 非法: 长度至少 8 个字符")
+	}
+	if cfg.AuthLoginMaxFailures < 0 {
+		return Config{}, fmt.Errorf("AUTH_LOGIN_MAX_FAILURES 非法: %d", cfg.AuthLoginMaxFailures)
+	}
+	if cfg.AuthLoginGlobalMaxFailures < 0 {
+		return Config{}, fmt.Errorf("AUTH_LOGIN_GLOBAL_MAX_FAILURES 非法: %d", cfg.AuthLoginGlobalMaxFailures)
+	}
+	if cfg.AuthLoginWindowSeconds <= 0 {
+		return Config{}, fmt.Errorf("AUTH_LOGIN_WINDOW_SECONDS 非法: %d", cfg.AuthLoginWindowSeconds)
+	}
+	if cfg.AuthLoginLockoutSeconds <= 0 {
+		return Config{}, fmt.Errorf("AUTH_LOGIN_LOCKOUT_SECONDS 非法: %d", cfg.AuthLoginLockoutSeconds)
+	}
+	if cfg.AuthLoginBaseDelayMillis < 0 {
+		return Config{}, fmt.Errorf("AUTH_LOGIN_BASE_DELAY_MS 非法: %d", cfg.AuthLoginBaseDelayMillis)
+	}
+	if cfg.MediaURLTTLSeconds < 60 {
+		return Config{}, fmt.Errorf("MEDIA_URL_TTL_SECONDS 非法: %d（至少 60）", cfg.MediaURLTTLSeconds)
+	}
+	if cfg.MediaURLSigning && cfg.MediaURLSecret == "" {
+		return Config{}, fmt.Errorf("开启 MEDIA_URL_SIGNING 时必须配置 MEDIA_URL_SECRET")
+	}
+	if cfg.MediaURLSecret != "" && cfg.MediaURLSecret == cfg.JWTSecret {
+		return Config{}, fmt.Errorf("MEDIA_URL_SECRET 不能与 JWT_SECRET 相同")
+	}
+	if cfg.AuditLogRetentionDays < 0 {
+		return Config{}, fmt.Errorf("AUDIT_LOG_RETENTION_DAYS 非法: %d", cfg.AuditLogRetentionDays)
+	}
+	if strings.Contains(cfg.AuthTOTPIssuer, ":") {
+		return Config{}, fmt.Errorf("AUTH_TOTP_ISSUER 非法: %q", cfg.AuthTOTPIssuer)
+	}
+
 	return cfg, nil
 }
 
@@ -281,6 +530,53 @@ func getEnv(key, defaultValue string) string {
 	return val
 }
 
+// parseIdentitySeconds 解析 "id=秒,id=秒" 形式的按身份配置，忽略空项。
+func parseIdentitySeconds(raw string) (map[string]int, error) {
+	out := map[string]int{}
+	for _, part := range strings.Split(raw, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		id, value, ok := strings.Cut(part, "=")
+		id = strings.TrimSpace(id)
+		seconds, err := strconv.Atoi(strings.TrimSpace(value))
+		if !ok || id == "" || err != nil || seconds < 0 {
+			return nil, fmt.Errorf("%q", part)
+		}
+		out[id] = seconds
+	}
+	return out, nil
+}
+
+// parseKeyValueList 解析 "键=值,键=值" 形式的映射，忽略空项；值可为空。
+func parseKeyValueList(raw string) (map[string]string, error) {
+	out := map[string]string{}
+	for _, part := range strings.Split(raw, ",") {
+		if strings.TrimSpace(part) == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(part, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return nil, fmt.Errorf("%q", strings.TrimSpace(part))
+		}
+		out[key] = strings.TrimSpace(value)
+	}
+	return out, nil
+}
+
+// getEnvList 读取逗号分隔的列表，忽略空项。
+func getEnvList(key string) []string {
+	var out []string
+	for _, part := range strings.Split(os.Getenv(key), ",") {
+		if v := strings.TrimSpace(part); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
 func getEnvOptional2(key1, key2 string) string {
 	if v := strings.TrimSpace(os.Getenv(key1)); v != "" {
 		return v
@@ -301,6 +597,19 @@ func getEnvInt(key string, defaultValue int) int {
 	return parsed
 }
 
+func getEnvBool(key string, defaultValue bool) bool {
+	val := strings.TrimSpace(os.Getenv(key))
+	if val == "" {
+		return defaultValue
+	}
+
+	parsed, err := strconv.ParseBool(val)
+	if err != nil {
+		return defaultValue
+	}
+	return parsed
+}
+
 func getEnvIntOptional2(key1, key2 string, defaultValue int) int {
 	if v := strings.TrimSpace(os.Getenv(key1)); v != "" {
 		if parsed, err := strconv.Atoi(v); err == nil {
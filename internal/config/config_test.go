@@ -2,6 +2,7 @@ package config
 
 import (
 	"net/url"
+	"strings"
 	"testing"
 )
 
@@ -217,6 +218,378 @@ func TestLoad_SetsDefaultsAndValidates(t *testing.T) {
 	}
 }
 
+func TestLoad_ReadsWSRecordConfig(t *testing.T) {
+	t.Setenv("WS_RECORD_DIR", " /tmp/ws-record ")
+	t.Setenv("WS_RECORD_USER_IDS", "u1, ,u2,")
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if cfg.WSRecordDir != "/tmp/ws-record" || len(cfg.WSRecordUserIDs) != 2 || cfg.WSRecordUserIDs[0] != "u1" || cfg.WSRecordUserIDs[1] != "u2" {
+		t.Fatalf("WSRecordDir=%q WSRecordUserIDs=%v", cfg.WSRecordDir, cfg.WSRecordUserIDs)
+	}
+}
+
+func TestLoad_WSSchedulerConfig(t *testing.T) {
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if cfg.WSMaxIdentities != 2 || cfg.WSEvictionPolicy != "oldest" || cfg.WSCloseDelaySeconds != 80 || len(cfg.WSIdentityCloseDelaySeconds) != 0 {
+		t.Fatalf("scheduler defaults=%+v", cfg)
+	}
+
+	t.Setenv("WS_MAX_IDENTITIES", "5")
+	t.Setenv("WS_EVICTION_POLICY", " LRU ")
+	t.Setenv("WS_PINNED_IDENTITIES", "u1,u2")
+	t.Setenv("WS_CLOSE_DELAY_SECONDS", "30")
+	t.Setenv("WS_IDENTITY_CLOSE_DELAYS", "u1=600, u3=0,")
+	cfg, err = Load()
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if cfg.WSMaxIdentities != 5 || cfg.WSEvictionPolicy != "lru" || len(cfg.WSPinnedIdentities) != 2 || cfg.WSCloseDelaySeconds != 30 {
+		t.Fatalf("cfg=%+v", cfg)
+	}
+	if cfg.WSIdentityCloseDelaySeconds["u1"] != 600 || cfg.WSIdentityCloseDelaySeconds["u3"] != 0 || len(cfg.WSIdentityCloseDelaySeconds) != 2 {
+		t.Fatalf("close delays=%v", cfg.WSIdentityCloseDelaySeconds)
+	}
+
+	for key, value := range map[string]string{
+		"WS_MAX_IDENTITIES":        "0",
+		"WS_EVICTION_POLICY":       "random",
+		"WS_CLOSE_DELAY_SECONDS":   "0",
+		"WS_IDENTITY_CLOSE_DELAYS": "u1=abc",
+	} {
+		t.Run(key, func(t *testing.T) {
+			t.Setenv(key, value)
+			if _, err := Load(); err == nil || !strings.Contains(err.Error(), key) {
+				t.Fatalf("err=%v, want %s error", err, key)
+			}
+		})
+	}
+}
+
+func TestLoad_WSClusterConfig(t *testing.T) {
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if cfg.WSClusterEnabled || cfg.WSClusterKeyPrefix != "liao:ws:" || cfg.WSClusterLeaseSeconds != 30 {
+		t.Fatalf("cluster defaults=%+v", cfg)
+	}
+
+	t.Setenv("WS_CLUSTER_ENABLED", "true")
+	t.Setenv("WS_CLUSTER_REPLICA_ID", " replica-a ")
+	t.Setenv("WS_CLUSTER_LEASE_SECONDS", "10")
+	cfg, err = Load()
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if !cfg.WSClusterEnabled || cfg.WSClusterReplicaID != "replica-a" || cfg.WSClusterLeaseSeconds != 10 {
+		t.Fatalf("cfg=%+v", cfg)
+	}
+
+	t.Setenv("WS_CLUSTER_ENABLED", "nope")
+	t.Setenv("WS_CLUSTER_LEASE_SECONDS", "2")
+	if _, err := Load(); err == nil || !strings.Contains(err.Error(), "WS_CLUSTER_LEASE_SECONDS") {
+		t.Fatalf("err=%v", err)
+	}
+}
+
+func TestLoad_ForceoutBanSeconds(t *testing.T) {
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if cfg.ForceoutBanSeconds != 300 {
+		t.Fatalf("ForceoutBanSeconds=%d, want 300", cfg.ForceoutBanSeconds)
+	}
+
+	t.Setenv("FORCEOUT_BAN_SECONDS", "900")
+	if cfg, err = Load(); err != nil || cfg.ForceoutBanSeconds != 900 {
+		t.Fatalf("cfg=%+v err=%v", cfg, err)
+	}
+
+	t.Setenv("FORCEOUT_BAN_SECONDS", "0")
+	if _, err := Load(); err == nil || !strings.Contains(err.Error(), "FORCEOUT_BAN_SECONDS") {
+		t.Fatalf("err=%v", err)
+	}
+}
+
+func TestLoad_WSOutboundFilter(t *testing.T) {
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if cfg.WSFilterPhoneAction != "off" || cfg.WSFilterBannedWordAction != "block" || cfg.WSFilterDomainAction != "block" || len(cfg.WSFilterRewrites) != 0 {
+		t.Fatalf("cfg=%+v", cfg)
+	}
+
+	t.Setenv("WS_FILTER_PHONE_ACTION", " Redact ")
+	t.Setenv("WS_FILTER_BANNED_WORDS", "a, b ,")
+	t.Setenv("WS_FILTER_BLOCKED_DOMAINS", "example.com")
+	t.Setenv("WS_FILTER_REWRITES", "微信=VX, qq = 扣扣,空=")
+	cfg, err = Load()
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if cfg.WSFilterPhoneAction != "redact" || len(cfg.WSFilterBannedWords) != 2 || cfg.WSFilterBlockedDomains[0] != "example.com" ||
+		cfg.WSFilterRewrites["微信"] != "VX" || cfg.WSFilterRewrites["qq"] != "扣扣" || cfg.WSFilterRewrites["空"] != "" {
+		t.Fatalf("cfg=%+v", cfg)
+	}
+
+	t.Setenv("WS_FILTER_REWRITES", "novalue")
+	if _, err := Load(); err == nil || !strings.Contains(err.Error(), "WS_FILTER_REWRITES") {
+		t.Fatalf("err=%v", err)
+	}
+	t.Setenv("WS_FILTER_REWRITES", "")
+	t.Setenv("WS_FILTER_DOMAIN_ACTION", "rewrite")
+	if _, err := Load(); err == nil || !strings.Contains(err.Error(), "WS_FILTER_DOMAIN_ACTION") {
+		t.Fatalf("err=%v", err)
+	}
+}
+
+func TestLoad_WSHeartbeat(t *testing.T) {
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if cfg.WSUpstreamPingSeconds != 0 || cfg.WSDownstreamPingSeconds != 30 || cfg.WSPingMaxMissed != 3 {
+		t.Fatalf("cfg=%+v", cfg)
+	}
+
+	t.Setenv("WS_UPSTREAM_PING_SECONDS", "45")
+	t.Setenv("WS_DOWNSTREAM_PING_SECONDS", "0")
+	t.Setenv("WS_PING_MAX_MISSED", "5")
+	cfg, err = Load()
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if cfg.WSUpstreamPingSeconds != 45 || cfg.WSDownstreamPingSeconds != 0 || cfg.WSPingMaxMissed != 5 {
+		t.Fatalf("cfg=%+v", cfg)
+	}
+
+	t.Setenv("WS_PING_MAX_MISSED", "0")
+	if _, err := Load(); err == nil || !strings.Contains(err.Error(), "WS_PING_MAX_MISSED") {
+		t.Fatalf("err=%v", err)
+	}
+	t.Setenv("WS_PING_MAX_MISSED", "")
+	t.Setenv("WS_UPSTREAM_PING_SECONDS", "-1")
+	if _, err := Load(); err == nil || !strings.Contains(err.Error(), "WS_UPSTREAM_PING_SECONDS") {
+		t.Fatalf("err=%v", err)
+	}
+}
+
+func TestLoad_WSGuard(t *testing.T) {
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if len(cfg.WSAllowedOrigins) != 0 || cfg.WSMaxSessionsPerToken != 8 || cfg.WSRateLimitPerSecond != 20 || cfg.WSRateLimitBurst != 40 {
+		t.Fatalf("cfg=%+v", cfg)
+	}
+
+	t.Setenv("WS_ALLOWED_ORIGINS", "https://chat.example.com, *")
+	t.Setenv("WS_MAX_SESSIONS_PER_TOKEN", "0")
+	t.Setenv("WS_RATE_LIMIT_PER_SECOND", "2.5")
+	t.Setenv("WS_RATE_LIMIT_BURST", "5")
+	cfg, err = Load()
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if len(cfg.WSAllowedOrigins) != 2 || cfg.WSMaxSessionsPerToken != 0 || cfg.WSRateLimitPerSecond != 2.5 || cfg.WSRateLimitBurst != 5 {
+		t.Fatalf("cfg=%+v", cfg)
+	}
+
+	t.Setenv("WS_ALLOWED_ORIGINS", "chat.example.com")
+	if _, err := Load(); err == nil || !strings.Contains(err.Error(), "WS_ALLOWED_ORIGINS") {
+		t.Fatalf("err=%v", err)
+	}
+	t.Setenv("WS_ALLOWED_ORIGINS", "")
+	t.Setenv("WS_RATE_LIMIT_PER_SECOND", "fast")
+	if _, err := Load(); err == nil || !strings.Contains(err.Error(), "WS_RATE_LIMIT_PER_SECOND") {
+		t.Fatalf("err=%v", err)
+	}
+	t.Setenv("WS_RATE_LIMIT_PER_SECOND", "")
+	t.Setenv("WS_MAX_SESSIONS_PER_TOKEN", "-1")
+	if _, err := Load(); err == nil || !strings.Contains(err.Error(), "WS_MAX_SESSIONS_PER_TOKEN") {
+		t.Fatalf("err=%v", err)
+	}
+}
+
+func TestLoad_AuthAccounts(t *testing.T) {
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if cfg.AuthAccessCodeDisabled || cfg.AuthAccessCodeAllowWithTOTP || cfg.AuthAdminUsername != "" || cfg.AuthAdminPassword != "" {
+		t.Fatalf("cfg=%+v", cfg)
+	}
+
+	t.Setenv("AUTH_ACCESS_CODE_DISABLED", "true")
+	t.Setenv("AUTH_ACCESS_CODE_ALLOW_WITH_TOTP", "true")
+	t.Setenv("AUTH_ADMIN_USERNAME", " root ")
+	t.Setenv("AUTH_ADMIN_PASSWORD", "change-me-please")
+	cfg, err = Load()
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if !cfg.AuthAccessCodeDisabled || !cfg.AuthAccessCodeAllowWithTOTP || cfg.AuthAdminUsername != "root" || cfg.AuthAdminPassword != "change-me-please" {
+		t.Fatalf("cfg=%+v", cfg)
+	}
+
+	t.Setenv("AUTH_ADMIN_PASSWORD", "short")
+	if _, err := Load(); err == nil || !strings.Contains(err.Error(), "AUTH_ADMIN_PASSWORD") {
+		t.Fatalf("err=%v", err)
+	}
+	t.Setenv("AUTH_ADMIN_PASSWORD", "")
+	if _, err := Load(); err == nil || !strings.Contains(err.Error(), "AUTH_ADMIN_USERNAME") {
+		t.Fatalf("err=%v", err)
+	}
+}
+
+func TestLoad_AccessTokenExpiry(t *testing.T) {
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if cfg.AccessTokenExpireMinutes != 15 {
+		t.Fatalf("AccessTokenExpireMinutes=%d", cfg.AccessTokenExpireMinutes)
+	}
+
+	t.Setenv("ACCESS_TOKEN_EXPIRE_MINUTES", "5")
+	cfg, err = Load()
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if cfg.AccessTokenExpireMinutes != 5 {
+		t.Fatalf("AccessTokenExpireMinutes=%d", cfg.AccessTokenExpireMinutes)
+	}
+
+	t.Setenv("ACCESS_TOKEN_EXPIRE_MINUTES", "0")
+	if _, err := Load(); err == nil || !strings.Contains(err.Error(), "ACCESS_TOKEN_EXPIRE_MINUTES") {
+		t.Fatalf("err=%v", err)
+	}
+}
+
+func TestLoad_AuthLoginGuard(t *testing.T) {
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if cfg.AuthLoginMaxFailures != 5 || cfg.AuthLoginGlobalMaxFailures != 100 || cfg.AuthLoginWindowSeconds != 900 ||
+		cfg.AuthLoginLockoutSeconds != 900 || cfg.AuthLoginBaseDelayMillis != 1000 || cfg.AuthLoginTrustForwarded {
+		t.Fatalf("cfg=%+v", cfg)
+	}
+
+	t.Setenv("AUTH_LOGIN_MAX_FAILURES", "0")
+	t.Setenv("AUTH_LOGIN_GLOBAL_MAX_FAILURES", "50")
+	t.Setenv("AUTH_LOGIN_WINDOW_SECONDS", "60")
+	t.Setenv("AUTH_LOGIN_LOCKOUT_SECONDS", "120")
+	t.Setenv("AUTH_LOGIN_BASE_DELAY_MS", "0")
+	t.Setenv("AUTH_LOGIN_TRUST_FORWARDED", "true")
+	cfg, err = Load()
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if cfg.AuthLoginMaxFailures != 0 || cfg.AuthLoginGlobalMaxFailures != 50 || cfg.AuthLoginWindowSeconds != 60 ||
+		cfg.AuthLoginLockoutSeconds != 120 || cfg.AuthLoginBaseDelayMillis != 0 || !cfg.AuthLoginTrustForwarded {
+		t.Fatalf("cfg=%+v", cfg)
+	}
+
+	for _, tc := range []struct{ key, value string }{
+		{"AUTH_LOGIN_MAX_FAILURES", "-1"},
+		{"AUTH_LOGIN_GLOBAL_MAX_FAILURES", "-1"},
+		{"AUTH_LOGIN_WINDOW_SECONDS", "0"},
+		{"AUTH_LOGIN_LOCKOUT_SECONDS", "0"},
+		{"AUTH_LOGIN_BASE_DELAY_MS", "-5"},
+	} {
+		t.Run(tc.key, func(t *testing.T) {
+			t.Setenv(tc.key, tc.value)
+			if _, err := Load(); err == nil || !strings.Contains(err.Error(), tc.key) {
+				t.Fatalf("err=%v", err)
+			}
+		})
+	}
+}
+
+func TestLoad_MediaURLSigning(t *testing.T) {
+	t.Setenv("JWT_SECRET", "jwt-secret-1")
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if cfg.MediaURLSigning || cfg.MediaURLSecret != "" || cfg.MediaURLTTLSeconds != 21600 {
+		t.Fatalf("cfg=%+v", cfg)
+	}
+
+	// 签名密钥不沿用 JWT_SECRET：开启签名时必须单独配置。
+	t.Setenv("MEDIA_URL_SIGNING", "true")
+	if _, err := Load(); err == nil || !strings.Contains(err.Error(), "MEDIA_URL_SECRET") {
+		t.Fatalf("err=%v, want missing secret", err)
+	}
+	t.Setenv("MEDIA_URL_SECRET", "jwt-secret-1")
+	if _, err := Load(); err == nil || !strings.Contains(err.Error(), "JWT_SECRET") {
+		t.Fatalf("err=%v, want secret reuse rejected", err)
+	}
+
+	t.Setenv("MEDIA_URL_SECRET", " media-secret ")
+	t.Setenv("MEDIA_URL_TTL_SECONDS", "600")
+	cfg, err = Load()
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if !cfg.MediaURLSigning || cfg.MediaURLSecret != "media-secret" || cfg.MediaURLTTLSeconds != 600 {
+		t.Fatalf("cfg=%+v", cfg)
+	}
+
+	t.Setenv("MEDIA_URL_TTL_SECONDS", "59")
+	if _, err := Load(); err == nil || !strings.Contains(err.Error(), "MEDIA_URL_TTL_SECONDS") {
+		t.Fatalf("err=%v", err)
+	}
+}
+
+func TestLoad_AuditLogRetention(t *testing.T) {
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if cfg.AuditLogRetentionDays != 90 {
+		t.Fatalf("AuditLogRetentionDays=%d", cfg.AuditLogRetentionDays)
+	}
+
+	t.Setenv("AUDIT_LOG_RETENTION_DAYS", "0")
+	if cfg, err = Load(); err != nil || cfg.AuditLogRetentionDays != 0 {
+		t.Fatalf("cfg=%+v err=%v", cfg, err)
+	}
+
+	t.Setenv("AUDIT_LOG_RETENTION_DAYS", "-1")
+	if _, err := Load(); err == nil || !strings.Contains(err.Error(), "AUDIT_LOG_RETENTION_DAYS") {
+		t.Fatalf("err=%v", err)
+	}
+}
+
+func TestLoad_AuthTOTPIssuer(t *testing.T) {
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if cfg.AuthTOTPIssuer != "Liao" {
+		t.Fatalf("AuthTOTPIssuer=%q", cfg.AuthTOTPIssuer)
+	}
+
+	t.Setenv("AUTH_TOTP_ISSUER", " Liao Home ")
+	if cfg, err = Load(); err != nil || cfg.AuthTOTPIssuer != "Liao Home" {
+		t.Fatalf("cfg=%+v err=%v", cfg, err)
+	}
+
+	t.Setenv("AUTH_TOTP_ISSUER", "a:b")
+	if _, err := Load(); err == nil || !strings.Contains(err.Error(), "AUTH_TOTP_ISSUER") {
+		t.Fatalf("err=%v", err)
+	}
+}
+
 func TestLoad_ReadsRandomVIPCodeFromEnv(t *testing.T) {
 	t.Setenv("RANDOM_VIP_CODE", " vip-from-env ")
 	cfg, err := Load()
@@ -0,0 +1,599 @@
+// Package fakeupstream is an in-process stand-in for the v1.chat2019.cn chat
+// service, used by end-to-end tests and local development.
+//
+// It serves the getRandServer discovery endpoint, a WebSocket that accepts
+// sign/random/randomOut/touser_* frames and emits code 7, 15 and -3 frames,
+// and the history/favorite/message-page HTTP endpoints.
+package fakeupstream
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"liao/internal/protocol"
+)
+
+// Paths served by the fake, mirroring the real upstream.
+const (
+	RandServerPath   = "/Act/WebService.asmx/getRandServer"
+	WebSocketPath    = "/ws"
+	HistoryUsersPath = "/asmx/method.asmx/randomVIPGetHistoryUserList_Random"
+	FavoritesPath    = "/asmx/method.asmx/randomVIPGetHistoryUserList_My"
+	MessagesPath     = "/asmx/method.asmx/randomVIPGetHistoryUserMsgsPage"
+)
+
+// ForceoutContent is the text the real upstream sends with code=-3.
+const ForceoutContent = "请不要在同一个浏览器下重复登录"
+
+const messagePageSize = 20
+
+// User describes a participant known to the fake (a signed-in client or a bot).
+type User struct {
+	ID       string `json:"id"`
+	Nickname string `json:"nickname"`
+	Sex      string `json:"sex"`
+	Age      string `json:"age"`
+	Address  string `json:"address"`
+}
+
+// DefaultBots returns the bots used by cmd/fakeupstream.
+func DefaultBots() []User {
+	return []User{
+		{ID: "bot-alice", Nickname: "Alice", Sex: "女", Age: "22", Address: "上海"},
+		{ID: "bot-bob", Nickname: "Bob", Sex: "男", Age: "25", Address: "北京"},
+	}
+}
+
+// Message is a stored chat message in the shape of the upstream contents_list.
+type Message struct {
+	Tid      string `json:"Tid"`
+	ID       string `json:"id"`
+	ToID     string `json:"toid"`
+	Content  string `json:"content"`
+	Time     string `json:"time"`
+	Nickname string `json:"nickname,omitempty"`
+}
+
+type client struct {
+	user    User
+	conn    *websocket.Conn
+	writeMu sync.Mutex
+}
+
+func (c *client) send(frame []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return c.conn.WriteMessage(websocket.TextMessage, frame)
+}
+
+// Server is a running fake upstream. The zero value is not usable; call Start.
+type Server struct {
+	// BotReplyDelay delays bot echo replies; zero replies immediately.
+	BotReplyDelay time.Duration
+
+	httpSrv  *http.Server
+	baseURL  string
+	upgrader websocket.Upgrader
+	now      func() time.Time
+
+	mu        sync.Mutex
+	bots      []User
+	nextBot   int
+	clients   map[string]*client
+	profiles  map[string]User
+	waiting   string
+	contacts  map[string][]string // userID -> partner IDs, oldest first
+	favorites map[string][]string
+	messages  map[string][]Message // conversation key -> messages, oldest first
+	received  map[string][]string
+	signCount map[string]int
+	nextTid   int64
+}
+
+// Start listens on addr (e.g. "127.0.0.1:0") and serves until Close.
+// With no bots, random only pairs clients with each other.
+func Start(addr string, bots ...User) (*Server, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("fakeupstream: listen: %w", err)
+	}
+	s := &Server{
+		baseURL:   "http://" + ln.Addr().String(),
+		upgrader:  websocket.Upgrader{CheckOrigin: func(*http.Request) bool { return true }},
+		now:       time.Now,
+		bots:      append([]User(nil), bots...),
+		clients:   make(map[string]*client),
+		profiles:  make(map[string]User),
+		contacts:  make(map[string][]string),
+		favorites: make(map[string][]string),
+		messages:  make(map[string][]Message),
+		received:  make(map[string][]string),
+		signCount: make(map[string]int),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(RandServerPath, s.handleRandServer)
+	mux.HandleFunc(WebSocketPath, s.handleWebSocket)
+	mux.HandleFunc(HistoryUsersPath, s.handleHistoryUsers)
+	mux.HandleFunc(FavoritesPath, s.handleFavorites)
+	mux.HandleFunc(MessagesPath, s.handleMessages)
+	s.httpSrv = &http.Server{Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+
+	go func() {
+		if err := s.httpSrv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("fakeupstream: serve", "error", err)
+		}
+	}()
+	return s, nil
+}
+
+// Close stops the listener and drops every WebSocket client.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	clients := make([]*client, 0, len(s.clients))
+	for _, c := range s.clients {
+		clients = append(clients, c)
+	}
+	s.clients = make(map[string]*client)
+	s.mu.Unlock()
+
+	for _, c := range clients {
+		_ = c.conn.Close()
+	}
+	return s.httpSrv.Close()
+}
+
+// URL returns the HTTP base URL, e.g. "http://127.0.0.1:40123".
+func (s *Server) URL() string { return s.baseURL }
+
+// WebSocketURL returns the WebSocket address advertised by getRandServer.
+func (s *Server) WebSocketURL() string {
+	return "ws" + strings.TrimPrefix(s.baseURL, "http") + WebSocketPath
+}
+
+// RandServerBase returns a value for the app's wsWebServiceRandServerBase;
+// the caller appends a timestamp as it does for the real service.
+func (s *Server) RandServerBase() string {
+	return s.baseURL + RandServerPath + "?ServerInfo=serversdeskry&_="
+}
+
+// Client returns an HTTP client that sends every request to the fake regardless
+// of the URL host, so hard-coded upstream URLs resolve here.
+func (s *Server) Client() *http.Client {
+	target, _ := url.Parse(s.baseURL)
+	return &http.Client{
+		Timeout:   5 * time.Second,
+		Transport: rewriteTransport{target: target, next: http.DefaultTransport},
+	}
+}
+
+type rewriteTransport struct {
+	target *url.URL
+	next   http.RoundTripper
+}
+
+func (t rewriteTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	clone := req.Clone(req.Context())
+	clone.URL.Scheme = t.target.Scheme
+	clone.URL.Host = t.target.Host
+	return t.next.RoundTrip(clone)
+}
+
+// Connected reports whether userID currently has a signed-in WebSocket.
+func (s *Server) Connected(userID string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.clients[userID]
+	return ok
+}
+
+// SignCount returns how many times userID has signed in.
+func (s *Server) SignCount(userID string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.signCount[userID]
+}
+
+// Received returns the frames userID has sent after its sign, oldest first.
+func (s *Server) Received(userID string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.received[userID]...)
+}
+
+// Messages returns the stored conversation between two users, oldest first.
+func (s *Server) Messages(a, b string) []Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Message(nil), s.messages[conversationKey(a, b)]...)
+}
+
+// AddFavorite marks target as a favorite of userID.
+func (s *Server) AddFavorite(userID string, target User) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rememberUserLocked(target)
+	s.favorites[userID] = appendUnique(s.favorites[userID], target.ID)
+}
+
+// Push sends a raw frame to userID.
+func (s *Server) Push(userID string, frame string) error {
+	c := s.client(userID)
+	if c == nil {
+		return fmt.Errorf("fakeupstream: %s not connected", userID)
+	}
+	return c.send([]byte(frame))
+}
+
+// SendChat delivers a code=7 message from another user (connected or not) to
+// userID and stores it in the conversation history.
+func (s *Server) SendChat(from User, userID string, content string) error {
+	s.mu.Lock()
+	s.rememberUserLocked(from)
+	to := s.userLocked(userID)
+	frame, err := s.recordChatLocked(from, to, content)
+	c := s.clients[userID]
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	if c == nil {
+		return fmt.Errorf("fakeupstream: %s not connected", userID)
+	}
+	return c.send(frame)
+}
+
+// Forceout sends code=-3 to userID and closes its connection, as the real
+// upstream does when the identity signs in elsewhere.
+func (s *Server) Forceout(userID string) error {
+	s.mu.Lock()
+	c := s.clients[userID]
+	if c != nil {
+		delete(s.clients, userID)
+	}
+	s.mu.Unlock()
+	if c == nil {
+		return fmt.Errorf("fakeupstream: %s not connected", userID)
+	}
+	return forceout(c)
+}
+
+// Drop closes userID's connection without a close frame, simulating a network failure.
+func (s *Server) Drop(userID string) error {
+	s.mu.Lock()
+	c := s.clients[userID]
+	if c != nil {
+		delete(s.clients, userID)
+	}
+	s.mu.Unlock()
+	if c == nil {
+		return fmt.Errorf("fakeupstream: %s not connected", userID)
+	}
+	return c.conn.UnderlyingConn().Close()
+}
+
+func (s *Server) client(userID string) *client {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.clients[userID]
+}
+
+func forceout(c *client) error {
+	frame, err := protocol.Encode(&protocol.Forceout{Content: ForceoutContent, Forceout: true})
+	if err != nil {
+		return err
+	}
+	err = c.send(frame)
+	_ = c.conn.Close()
+	return err
+}
+
+func (s *Server) handleRandServer(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, map[string]any{
+		"state": "OK",
+		"msg":   map[string]any{"server": s.WebSocketURL()},
+	})
+}
+
+func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	conn, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	_, data, err := conn.ReadMessage()
+	if err != nil {
+		return
+	}
+	msg, err := protocol.Decode(data)
+	sign, ok := msg.(*protocol.Sign)
+	if err != nil || !ok {
+		_ = conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "sign required"))
+		return
+	}
+
+	c := &client{conn: conn, user: User{ID: sign.ID, Nickname: sign.Name, Sex: sign.UserSex, Address: sign.UserAddress}}
+	s.mu.Lock()
+	previous := s.clients[sign.ID]
+	s.clients[sign.ID] = c
+	s.signCount[sign.ID]++
+	s.rememberUserLocked(c.user)
+	s.mu.Unlock()
+	if previous != nil {
+		_ = forceout(previous)
+	}
+
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			break
+		}
+		s.handleFrame(c, string(data))
+	}
+
+	s.mu.Lock()
+	if s.clients[sign.ID] == c {
+		delete(s.clients, sign.ID)
+	}
+	if s.waiting == sign.ID {
+		s.waiting = ""
+	}
+	s.mu.Unlock()
+}
+
+func (s *Server) handleFrame(c *client, raw string) {
+	s.mu.Lock()
+	s.received[c.user.ID] = append(s.received[c.user.ID], raw)
+	s.mu.Unlock()
+
+	var node map[string]any
+	if err := json.Unmarshal([]byte(raw), &node); err != nil {
+		return
+	}
+	act, _ := node["act"].(string)
+	switch {
+	case act == "random":
+		s.handleRandom(c)
+	case act == "randomOut":
+		s.mu.Lock()
+		if s.waiting == c.user.ID {
+			s.waiting = ""
+		}
+		s.mu.Unlock()
+	case strings.HasPrefix(act, "touser_"):
+		content, _ := node["msg"].(string)
+		s.handleChat(c, parseTargetID(act), content)
+	}
+}
+
+// handleRandom pairs c with a waiting client, or the next bot, or leaves it waiting.
+func (s *Server) handleRandom(c *client) {
+	s.mu.Lock()
+	var partner User
+	var partnerClient *client
+	switch {
+	case s.waiting != "" && s.waiting != c.user.ID && s.clients[s.waiting] != nil:
+		partnerClient = s.clients[s.waiting]
+		partner = partnerClient.user
+		s.waiting = ""
+	case len(s.bots) > 0:
+		partner = s.bots[s.nextBot%len(s.bots)]
+		s.nextBot++
+	default:
+		s.waiting = c.user.ID
+		s.mu.Unlock()
+		return
+	}
+	s.contacts[c.user.ID] = appendUnique(s.contacts[c.user.ID], partner.ID)
+	s.contacts[partner.ID] = appendUnique(s.contacts[partner.ID], c.user.ID)
+	s.mu.Unlock()
+
+	_ = sendUserInfo(c, partner)
+	if partnerClient != nil {
+		_ = sendUserInfo(partnerClient, c.user)
+	}
+}
+
+func sendUserInfo(c *client, partner User) error {
+	frame, err := protocol.Encode(&protocol.UserInfo{
+		UserID:   partner.ID,
+		Nickname: partner.Nickname,
+		Sex:      partner.Sex,
+		Age:      partner.Age,
+		Address:  partner.Address,
+	})
+	if err != nil {
+		return err
+	}
+	return c.send(frame)
+}
+
+// handleChat echoes the message to the sender, delivers it to a connected
+// recipient and lets bots reply.
+func (s *Server) handleChat(c *client, targetID string, content string) {
+	if targetID == "" || content == "" {
+		return
+	}
+	s.mu.Lock()
+	to := s.userLocked(targetID)
+	frame, err := s.recordChatLocked(c.user, to, content)
+	recipient := s.clients[targetID]
+	bot, isBot := s.botLocked(targetID)
+	s.mu.Unlock()
+	if err != nil {
+		return
+	}
+
+	_ = c.send(frame)
+	if recipient != nil && recipient != c {
+		_ = recipient.send(frame)
+	}
+	if isBot {
+		reply := func() {
+			s.mu.Lock()
+			replyFrame, err := s.recordChatLocked(bot, c.user, "收到: "+content)
+			s.mu.Unlock()
+			if err == nil {
+				_ = c.send(replyFrame)
+			}
+		}
+		if s.BotReplyDelay > 0 {
+			time.AfterFunc(s.BotReplyDelay, reply)
+		} else {
+			reply()
+		}
+	}
+}
+
+func (s *Server) recordChatLocked(from User, to User, content string) ([]byte, error) {
+	s.nextTid++
+	tid := strconv.FormatInt(s.nextTid, 10)
+	tm := s.now().Format("2006-01-02 15:04:05.000")
+
+	key := conversationKey(from.ID, to.ID)
+	s.messages[key] = append(s.messages[key], Message{
+		Tid:      tid,
+		ID:       from.ID,
+		ToID:     to.ID,
+		Content:  content,
+		Time:     tm,
+		Nickname: from.Nickname,
+	})
+	s.contacts[from.ID] = appendUnique(s.contacts[from.ID], to.ID)
+	s.contacts[to.ID] = appendUnique(s.contacts[to.ID], from.ID)
+
+	return protocol.Encode(&protocol.Chat{
+		From: protocol.ChatParticipant{ID: from.ID, Nickname: from.Nickname, Content: content, Time: tm, Type: "text", TID: tid},
+		To:   protocol.ChatParticipant{ID: to.ID, Nickname: to.Nickname},
+	})
+}
+
+func (s *Server) handleHistoryUsers(w http.ResponseWriter, r *http.Request) {
+	_ = r.ParseForm()
+	s.mu.Lock()
+	myUserID := r.FormValue("myUserID")
+	list := s.userListLocked(myUserID, s.contacts[myUserID])
+	s.mu.Unlock()
+	writeJSON(w, list)
+}
+
+func (s *Server) handleFavorites(w http.ResponseWriter, r *http.Request) {
+	_ = r.ParseForm()
+	s.mu.Lock()
+	myUserID := r.FormValue("myUserID")
+	list := s.userListLocked(myUserID, s.favorites[myUserID])
+	s.mu.Unlock()
+	writeJSON(w, list)
+}
+
+// handleMessages returns one page of the conversation, newest first; firstTid
+// other than "0" pages back to messages older than that Tid.
+func (s *Server) handleMessages(w http.ResponseWriter, r *http.Request) {
+	_ = r.ParseForm()
+	firstTid, _ := strconv.ParseInt(strings.TrimSpace(r.FormValue("firstTid")), 10, 64)
+
+	s.mu.Lock()
+	stored := s.messages[conversationKey(r.FormValue("myUserID"), r.FormValue("UserToID"))]
+	page := make([]Message, 0, messagePageSize)
+	for i := len(stored) - 1; i >= 0 && len(page) < messagePageSize; i-- {
+		tid, _ := strconv.ParseInt(stored[i].Tid, 10, 64)
+		if firstTid > 0 && tid >= firstTid {
+			continue
+		}
+		page = append(page, stored[i])
+	}
+	s.mu.Unlock()
+
+	writeJSON(w, map[string]any{"code": 0, "contents_list": page})
+}
+
+// userListLocked renders users newest-contact first, like the upstream history list.
+func (s *Server) userListLocked(myUserID string, ids []string) []map[string]any {
+	list := make([]map[string]any, 0, len(ids))
+	for i := len(ids) - 1; i >= 0; i-- {
+		u := s.userLocked(ids[i])
+		item := map[string]any{
+			"id":       u.ID,
+			"nickname": u.Nickname,
+			"sex":      u.Sex,
+			"age":      u.Age,
+			"address":  u.Address,
+		}
+		if msgs := s.messages[conversationKey(myUserID, ids[i])]; len(msgs) > 0 {
+			item["lastMsg"] = msgs[len(msgs)-1].Content
+		}
+		list = append(list, item)
+	}
+	return list
+}
+
+// rememberUserLocked keeps profile data for users seen via sign or SendChat.
+func (s *Server) rememberUserLocked(u User) {
+	if u.ID == "" {
+		return
+	}
+	if _, isBot := s.botLocked(u.ID); isBot {
+		return
+	}
+	s.profiles[u.ID] = u
+}
+
+func (s *Server) userLocked(id string) User {
+	if bot, ok := s.botLocked(id); ok {
+		return bot
+	}
+	if u, ok := s.profiles[id]; ok {
+		return u
+	}
+	return User{ID: id}
+}
+
+func (s *Server) botLocked(id string) (User, bool) {
+	for _, bot := range s.bots {
+		if bot.ID == id {
+			return bot, true
+		}
+	}
+	return User{}, false
+}
+
+func parseTargetID(act string) string {
+	rest := strings.TrimPrefix(act, "touser_")
+	if i := strings.Index(rest, "_"); i >= 0 {
+		rest = rest[:i]
+	}
+	return strings.TrimSpace(rest)
+}
+
+func conversationKey(a, b string) string {
+	ids := []string{a, b}
+	sort.Strings(ids)
+	return ids[0] + "_" + ids[1]
+}
+
+func appendUnique(ids []string, id string) []string {
+	for i, existing := range ids {
+		if existing == id {
+			return append(append(ids[:i:i], ids[i+1:]...), id)
+		}
+	}
+	return append(ids, id)
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,246 @@
+package fakeupstream
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func startServer(t *testing.T, bots ...User) *Server {
+	t.Helper()
+	s, err := Start("127.0.0.1:0", bots...)
+	if err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	t.Cleanup(func() { _ = s.Close() })
+	return s
+}
+
+func dialAndSign(t *testing.T, s *Server, id string, name string) *websocket.Conn {
+	t.Helper()
+	conn, _, err := websocket.DefaultDialer.Dial(s.WebSocketURL(), nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	sign := `{"act":"sign","id":"` + id + `","name":"` + name + `","userSex":"男"}`
+	if err := conn.WriteMessage(websocket.TextMessage, []byte(sign)); err != nil {
+		t.Fatalf("sign: %v", err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for s.SignCount(id) == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	return conn
+}
+
+func send(t *testing.T, conn *websocket.Conn, frame string) {
+	t.Helper()
+	if err := conn.WriteMessage(websocket.TextMessage, []byte(frame)); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+}
+
+func readCode(t *testing.T, conn *websocket.Conn, want int) map[string]any {
+	t.Helper()
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("read (want code=%d): %v", want, err)
+		}
+		var node map[string]any
+		if err := json.Unmarshal(data, &node); err != nil {
+			continue
+		}
+		if code, _ := node["code"].(float64); int(code) == want {
+			return node
+		}
+	}
+}
+
+func postForm(t *testing.T, s *Server, endpoint string, form url.Values) string {
+	t.Helper()
+	// Use the real upstream host to check that Client() rewrites it to the fake.
+	resp, err := s.Client().PostForm("http://v1.chat2019.cn"+endpoint, form)
+	if err != nil {
+		t.Fatalf("post %s: %v", endpoint, err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return string(body)
+}
+
+func TestServer_RandServerAdvertisesWebSocket(t *testing.T) {
+	s := startServer(t)
+
+	resp, err := http.Get(s.RandServerBase() + "123")
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	defer resp.Body.Close()
+	var node struct {
+		State string `json:"state"`
+		Msg   struct {
+			Server string `json:"server"`
+		} `json:"msg"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&node); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if node.State != "OK" || node.Msg.Server != s.WebSocketURL() {
+		t.Fatalf("unexpected discovery response: %+v", node)
+	}
+}
+
+func TestServer_RejectsFramesBeforeSign(t *testing.T) {
+	s := startServer(t)
+
+	conn, _, err := websocket.DefaultDialer.Dial(s.WebSocketURL(), nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	send(t, conn, `{"act":"random","id":"u1"}`)
+
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, _, err = conn.ReadMessage()
+	if !websocket.IsCloseError(err, websocket.ClosePolicyViolation) {
+		t.Fatalf("err=%v, want policy violation close", err)
+	}
+}
+
+func TestServer_RandomPairsBotAndChatIsEchoedAndStored(t *testing.T) {
+	bots := DefaultBots()
+	s := startServer(t, bots...)
+	conn := dialAndSign(t, s, "u1", "Me")
+
+	send(t, conn, `{"act":"random","id":"u1","userAge":"0"}`)
+	info := readCode(t, conn, 15)
+	if info["sel_userid"] != bots[0].ID || info["sel_userNikename"] != bots[0].Nickname {
+		t.Fatalf("unexpected match: %v", info)
+	}
+
+	send(t, conn, `{"act":"touser_`+bots[0].ID+`_Alice","id":"u1","msg":"hello"}`)
+	echo := readCode(t, conn, 7)
+	from, _ := echo["fromuser"].(map[string]any)
+	if from["id"] != "u1" || from["content"] != "hello" {
+		t.Fatalf("unexpected echo: %v", echo)
+	}
+	reply := readCode(t, conn, 7)
+	from, _ = reply["fromuser"].(map[string]any)
+	if from["id"] != bots[0].ID || from["content"] != "收到: hello" {
+		t.Fatalf("unexpected reply: %v", reply)
+	}
+
+	if got := len(s.Messages("u1", bots[0].ID)); got != 2 {
+		t.Fatalf("stored messages=%d, want 2", got)
+	}
+
+	body := postForm(t, s, MessagesPath, url.Values{"myUserID": {"u1"}, "UserToID": {bots[0].ID}, "isFirst": {"1"}, "firstTid": {"0"}})
+	var page struct {
+		Code     int       `json:"code"`
+		Contents []Message `json:"contents_list"`
+	}
+	if err := json.Unmarshal([]byte(body), &page); err != nil {
+		t.Fatalf("decode page: %v (%s)", err, body)
+	}
+	if len(page.Contents) != 2 || page.Contents[0].Content != "收到: hello" {
+		t.Fatalf("unexpected page: %+v", page)
+	}
+
+	body = postForm(t, s, MessagesPath, url.Values{"myUserID": {"u1"}, "UserToID": {bots[0].ID}, "firstTid": {page.Contents[0].Tid}})
+	if !strings.Contains(body, `"content":"hello"`) || strings.Contains(body, "收到") {
+		t.Fatalf("unexpected older page: %s", body)
+	}
+
+	body = postForm(t, s, HistoryUsersPath, url.Values{"myUserID": {"u1"}})
+	var users []map[string]any
+	if err := json.Unmarshal([]byte(body), &users); err != nil {
+		t.Fatalf("decode history: %v (%s)", err, body)
+	}
+	if len(users) != 1 || users[0]["id"] != bots[0].ID || users[0]["lastMsg"] != "收到: hello" {
+		t.Fatalf("unexpected history users: %v", users)
+	}
+}
+
+func TestServer_PairsWaitingClientsAndDeliversChat(t *testing.T) {
+	s := startServer(t)
+	a := dialAndSign(t, s, "ua", "A")
+	b := dialAndSign(t, s, "ub", "B")
+
+	send(t, a, `{"act":"random","id":"ua"}`)
+	deadline := time.Now().Add(2 * time.Second)
+	for len(s.Received("ua")) == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	send(t, b, `{"act":"random","id":"ub"}`)
+
+	if info := readCode(t, b, 15); info["sel_userid"] != "ua" || info["sel_userNikename"] != "A" {
+		t.Fatalf("unexpected match for ub: %v", info)
+	}
+	if info := readCode(t, a, 15); info["sel_userid"] != "ub" {
+		t.Fatalf("unexpected match for ua: %v", info)
+	}
+
+	send(t, a, `{"act":"touser_ub_B","id":"ua","msg":"hi b"}`)
+	got := readCode(t, b, 7)
+	if to, _ := got["touser"].(map[string]any); to["id"] != "ub" {
+		t.Fatalf("unexpected chat: %v", got)
+	}
+}
+
+func TestServer_ForceoutAndDuplicateSign(t *testing.T) {
+	s := startServer(t)
+	first := dialAndSign(t, s, "u1", "Me")
+
+	// A second sign for the same identity forces the older connection out.
+	second := dialAndSign(t, s, "u1", "Me")
+	deadline := time.Now().Add(2 * time.Second)
+	for s.SignCount("u1") < 2 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if got := readCode(t, first, -3); got["forceout"] != true || got["content"] != ForceoutContent {
+		t.Fatalf("unexpected forceout: %v", got)
+	}
+
+	if err := s.Forceout("u1"); err != nil {
+		t.Fatalf("Forceout: %v", err)
+	}
+	readCode(t, second, -3)
+	if s.Connected("u1") {
+		t.Fatalf("expected u1 disconnected")
+	}
+	if err := s.Forceout("u1"); err == nil {
+		t.Fatalf("expected error for disconnected user")
+	}
+}
+
+func TestServer_SendChatAndFavorites(t *testing.T) {
+	s := startServer(t)
+	conn := dialAndSign(t, s, "u1", "Me")
+
+	peer := User{ID: "p1", Nickname: "Peer", Sex: "女"}
+	if err := s.SendChat(peer, "u1", "ping"); err != nil {
+		t.Fatalf("SendChat: %v", err)
+	}
+	got := readCode(t, conn, 7)
+	if from, _ := got["fromuser"].(map[string]any); from["nickname"] != "Peer" || from["Tid"] == "" {
+		t.Fatalf("unexpected chat: %v", got)
+	}
+
+	s.AddFavorite("u1", peer)
+	body := postForm(t, s, FavoritesPath, url.Values{"myUserID": {"u1"}})
+	if !strings.Contains(body, `"id":"p1"`) || !strings.Contains(body, `"nickname":"Peer"`) {
+		t.Fatalf("unexpected favorites: %s", body)
+	}
+	if body := postForm(t, s, FavoritesPath, url.Values{"myUserID": {"nobody"}}); strings.TrimSpace(body) != "[]" {
+		t.Fatalf("unexpected empty favorites: %s", body)
+	}
+}
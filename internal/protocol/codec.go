@@ -0,0 +1,375 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Decode strictly parses a frame into its typed model.
+// Frames without a typed model return ErrUnsupported.
+func Decode(data []byte) (Message, error) {
+	return decode(data, true)
+}
+
+// DecodeLenient parses a frame, coercing scalar types and ignoring missing fields.
+// Frames without a typed model are returned as *Unknown.
+func DecodeLenient(data []byte) (Message, error) {
+	return decode(data, false)
+}
+
+func decode(data []byte, strict bool) (Message, error) {
+	raw, err := decodeObject(data)
+	if err != nil {
+		return nil, err
+	}
+
+	act := ""
+	if v, ok := raw["act"]; ok {
+		_ = json.Unmarshal(v, &act)
+	}
+	if strings.TrimSpace(act) == ActSign {
+		return decodeSign(newReader(KindSign, raw, strict))
+	}
+
+	code, hasCode := peekCode(raw)
+	switch {
+	case hasCode && code == CodeChat:
+		return decodeChat(newReader(KindChat, raw, strict))
+	case hasCode && code == CodeUserInfo:
+		return decodeUserInfo(newReader(KindUserInfo, raw, strict))
+	case hasCode && code == CodeForceout:
+		return decodeForceout(newReader(KindForceout, raw, strict))
+	case hasCode && code == CodeReject:
+		return decodeReject(newReader(KindReject, raw, strict))
+	case hasCode && code == CodeEvict:
+		return decodeEvict(newReader(KindEvict, raw, strict))
+	case hasCode && code == CodeCapacity:
+		return decodeCapacityReject(newReader(KindCapacity, raw, strict))
+	case hasCode && code == CodeFiltered:
+		return decodeFilterReject(newReader(KindFiltered, raw, strict))
+	}
+
+	if strict {
+		if hasCode {
+			return nil, fmt.Errorf("%w: code=%d", ErrUnsupported, code)
+		}
+		return nil, fmt.Errorf("%w: act=%q", ErrUnsupported, act)
+	}
+	return &Unknown{Code: code, Act: act, Fields: raw}, nil
+}
+
+// Encode serialises a typed message, including preserved unknown fields.
+func Encode(msg Message) ([]byte, error) {
+	switch m := msg.(type) {
+	case *Sign:
+		return m.encode()
+	case *Chat:
+		return m.encode()
+	case *UserInfo:
+		return m.encode()
+	case *Forceout:
+		return m.encode()
+	case *Reject:
+		return m.encode()
+	case *Evict:
+		return m.encode()
+	case *CapacityReject:
+		return m.encode()
+	case *FilterReject:
+		return m.encode()
+	case *Unknown:
+		return json.Marshal(m.Fields)
+	case nil:
+		return nil, fmt.Errorf("protocol: nil message")
+	default:
+		return nil, fmt.Errorf("protocol: cannot encode %T", msg)
+	}
+}
+
+func decodeObject(data []byte) (map[string]json.RawMessage, error) {
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) == 0 || trimmed[0] != '{' {
+		return nil, fmt.Errorf("protocol: frame is not a JSON object")
+	}
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(trimmed, &raw); err != nil {
+		return nil, fmt.Errorf("protocol: invalid JSON: %w", err)
+	}
+	if raw == nil {
+		return nil, fmt.Errorf("protocol: frame is not a JSON object")
+	}
+	return raw, nil
+}
+
+// peekCode reads "code" leniently for dispatch; the typed decoder re-validates it.
+func peekCode(raw map[string]json.RawMessage) (int, bool) {
+	v, ok := raw["code"]
+	if !ok {
+		return 0, false
+	}
+	var n json.Number
+	if err := json.Unmarshal(v, &n); err == nil {
+		if i, err := strconv.Atoi(n.String()); err == nil {
+			return i, true
+		}
+	}
+	var s string
+	if err := json.Unmarshal(v, &s); err == nil {
+		if i, err := strconv.Atoi(strings.TrimSpace(s)); err == nil {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// reader extracts typed fields from a raw object, recording the first schema error.
+type reader struct {
+	kind   Kind
+	strict bool
+	prefix string
+	raw    map[string]json.RawMessage
+	seen   map[string]struct{}
+	err    *error
+}
+
+func newReader(kind Kind, raw map[string]json.RawMessage, strict bool) *reader {
+	var err error
+	return &reader{kind: kind, strict: strict, raw: raw, seen: make(map[string]struct{}), err: &err}
+}
+
+func (r *reader) fail(key string, reason string) {
+	if *r.err != nil {
+		return
+	}
+	*r.err = &DecodeError{Kind: r.kind, Field: r.prefix + key, Reason: reason}
+}
+
+// take returns the raw value for key, treating JSON null as absent.
+func (r *reader) take(key string, required bool) (json.RawMessage, bool) {
+	v, ok := r.raw[key]
+	if ok {
+		r.seen[key] = struct{}{}
+	}
+	if !ok || bytes.Equal(bytes.TrimSpace(v), []byte("null")) {
+		if required && r.strict {
+			r.fail(key, "missing required field")
+		}
+		return nil, false
+	}
+	return v, true
+}
+
+func (r *reader) str(key string, required bool) string {
+	v, ok := r.take(key, required)
+	if !ok {
+		return ""
+	}
+	var s string
+	if err := json.Unmarshal(v, &s); err == nil {
+		if required && r.strict && strings.TrimSpace(s) == "" {
+			r.fail(key, "empty required field")
+		}
+		return s
+	}
+	if r.strict {
+		r.fail(key, "expected string, got "+jsonType(v))
+		return ""
+	}
+	return coerceString(v)
+}
+
+func (r *reader) boolean(key string, required bool) bool {
+	v, ok := r.take(key, required)
+	if !ok {
+		return false
+	}
+	var b bool
+	if err := json.Unmarshal(v, &b); err == nil {
+		return b
+	}
+	if r.strict {
+		r.fail(key, "expected boolean, got "+jsonType(v))
+		return false
+	}
+	var s string
+	if err := json.Unmarshal(v, &s); err == nil {
+		s = strings.ToLower(strings.TrimSpace(s))
+		return s == "true" || s == "1"
+	}
+	var f float64
+	if err := json.Unmarshal(v, &f); err == nil {
+		return f != 0
+	}
+	return false
+}
+
+func (r *reader) integer(key string, required bool) int {
+	v, ok := r.take(key, required)
+	if !ok {
+		return 0
+	}
+	var f float64
+	if err := json.Unmarshal(v, &f); err == nil {
+		if f != math.Trunc(f) && r.strict {
+			r.fail(key, "expected integer")
+		}
+		return int(f)
+	}
+	if r.strict {
+		r.fail(key, "expected number, got "+jsonType(v))
+		return 0
+	}
+	var s string
+	if err := json.Unmarshal(v, &s); err == nil {
+		if i, err := strconv.Atoi(strings.TrimSpace(s)); err == nil {
+			return i
+		}
+	}
+	return 0
+}
+
+// object returns a reader for a nested object that shares the error slot.
+func (r *reader) object(key string, required bool) (*reader, bool) {
+	v, ok := r.take(key, required)
+	child := &reader{kind: r.kind, strict: r.strict, prefix: r.prefix + key + ".", seen: make(map[string]struct{}), err: r.err}
+	if !ok {
+		child.raw = map[string]json.RawMessage{}
+		return child, false
+	}
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(v, &raw); err != nil || raw == nil {
+		if r.strict {
+			r.fail(key, "expected object, got "+jsonType(v))
+		}
+		child.raw = map[string]json.RawMessage{}
+		return child, false
+	}
+	child.raw = raw
+	return child, true
+}
+
+// firstStr reads the first present key among aliases, returning the key used.
+func (r *reader) firstStr(keys ...string) (string, string) {
+	for _, key := range keys {
+		if _, ok := r.raw[key]; ok {
+			return r.str(key, false), key
+		}
+	}
+	return "", ""
+}
+
+func (r *reader) meta() meta {
+	m := meta{present: make(map[string]struct{}, len(r.seen))}
+	for key := range r.seen {
+		m.present[key] = struct{}{}
+	}
+	for key, v := range r.raw {
+		if _, ok := r.seen[key]; ok {
+			continue
+		}
+		if m.Extra == nil {
+			m.Extra = make(Fields)
+		}
+		m.Extra[key] = v
+	}
+	return m
+}
+
+func (r *reader) error() error {
+	return *r.err
+}
+
+func coerceString(v json.RawMessage) string {
+	var f float64
+	if err := json.Unmarshal(v, &f); err == nil {
+		if f == math.Trunc(f) && math.Abs(f) < 1e15 {
+			return strconv.FormatInt(int64(f), 10)
+		}
+		return strconv.FormatFloat(f, 'f', -1, 64)
+	}
+	var b bool
+	if err := json.Unmarshal(v, &b); err == nil {
+		return strconv.FormatBool(b)
+	}
+	return ""
+}
+
+func jsonType(v json.RawMessage) string {
+	trimmed := bytes.TrimSpace(v)
+	if len(trimmed) == 0 {
+		return "empty"
+	}
+	switch trimmed[0] {
+	case '"':
+		return "string"
+	case '{':
+		return "object"
+	case '[':
+		return "array"
+	case 't', 'f':
+		return "boolean"
+	case 'n':
+		return "null"
+	default:
+		return "number"
+	}
+}
+
+// writer builds an object from preserved extras plus typed fields.
+type writer struct {
+	out  map[string]json.RawMessage
+	meta meta
+}
+
+func newWriter(m meta) *writer {
+	out := make(map[string]json.RawMessage, len(m.Extra)+8)
+	for k, v := range m.Extra {
+		out[k] = v
+	}
+	return &writer{out: out, meta: m}
+}
+
+// keep reports whether a field should be written: always for required fields,
+// otherwise when non-zero or present in the decoded frame.
+func (w *writer) keep(key string, zero bool, always bool) bool {
+	return always || !zero || w.meta.has(key)
+}
+
+func (w *writer) str(key string, v string, always bool) {
+	if w.keep(key, v == "", always) {
+		b, _ := json.Marshal(v)
+		w.out[key] = b
+	}
+}
+
+func (w *writer) boolean(key string, v bool, always bool) {
+	if w.keep(key, !v, always) {
+		w.out[key] = json.RawMessage(strconv.FormatBool(v))
+	}
+}
+
+func (w *writer) integer(key string, v int, always bool) {
+	if w.keep(key, v == 0, always) {
+		w.out[key] = json.RawMessage(strconv.Itoa(v))
+	}
+}
+
+func (w *writer) object(key string, v map[string]json.RawMessage, always bool) error {
+	if !w.keep(key, len(v) == 0, always) {
+		return nil
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	w.out[key] = b
+	return nil
+}
+
+func (w *writer) bytes() ([]byte, error) {
+	return json.Marshal(w.out)
+}
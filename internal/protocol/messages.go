@@ -0,0 +1,416 @@
+package protocol
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// Sign is the login frame a client sends before anything else ({"act":"sign",...}).
+type Sign struct {
+	ID               string
+	Name             string
+	UserSex          string
+	AddressShow      string
+	RandomHealthMode string
+	RandomVipSex     string
+	RandomVipAddress string
+	UserIP           string
+	UserAddress      string // upstream spells the key "useraddree"
+	RandomVipCode    string
+	meta
+}
+
+func (*Sign) Kind() Kind { return KindSign }
+
+func decodeSign(r *reader) (Message, error) {
+	if act := r.str("act", true); r.strict && act != ActSign {
+		r.fail("act", "expected \"sign\"")
+	}
+	msg := &Sign{
+		ID:               r.str("id", true),
+		Name:             r.str("name", false),
+		UserSex:          r.str("userSex", false),
+		AddressShow:      r.str("address_show", false),
+		RandomHealthMode: r.str("randomhealthmode", false),
+		RandomVipSex:     r.str("randomvipsex", false),
+		RandomVipAddress: r.str("randomvipaddress", false),
+		UserIP:           r.str("userip", false),
+		UserAddress:      r.str("useraddree", false),
+		RandomVipCode:    r.str("randomvipcode", false),
+	}
+	msg.meta = r.meta()
+	if err := r.error(); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+func (m *Sign) encode() ([]byte, error) {
+	w := newWriter(m.meta)
+	w.str("act", ActSign, true)
+	w.str("id", m.ID, true)
+	w.str("name", m.Name, false)
+	w.str("userSex", m.UserSex, false)
+	w.str("address_show", m.AddressShow, false)
+	w.str("randomhealthmode", m.RandomHealthMode, false)
+	w.str("randomvipsex", m.RandomVipSex, false)
+	w.str("randomvipaddress", m.RandomVipAddress, false)
+	w.str("userip", m.UserIP, false)
+	w.str("useraddree", m.UserAddress, false)
+	w.str("randomvipcode", m.RandomVipCode, false)
+	return w.bytes()
+}
+
+// ChatParticipant is the "fromuser"/"touser" object of a chat frame.
+type ChatParticipant struct {
+	ID       string
+	Nickname string
+	Name     string
+	Content  string
+	Time     string
+	Type     string
+	TID      string
+	tidKey   string
+	meta
+}
+
+func decodeChatParticipant(r *reader, required bool) ChatParticipant {
+	p := ChatParticipant{
+		ID:       r.str("id", required),
+		Nickname: r.str("nickname", false),
+		Name:     r.str("name", false),
+		Content:  r.str("content", false),
+		Time:     r.str("time", false),
+		Type:     r.str("type", false),
+	}
+	p.TID, p.tidKey = r.firstStr("Tid", "tid")
+	p.meta = r.meta()
+	return p
+}
+
+func (p ChatParticipant) fields(always bool) map[string]json.RawMessage {
+	w := newWriter(p.meta)
+	w.str("id", p.ID, always)
+	w.str("nickname", p.Nickname, false)
+	w.str("name", p.Name, false)
+	w.str("content", p.Content, false)
+	w.str("time", p.Time, false)
+	w.str("type", p.Type, false)
+	w.str(tidKeyOrDefault(p.tidKey), p.TID, false)
+	return w.out
+}
+
+// Chat is a private chat frame (code=7).
+// Content/Time/Type/TID may appear on the sender object or at the top level; use the
+// accessor methods to read the effective value.
+type Chat struct {
+	From     ChatParticipant
+	To       ChatParticipant
+	Content  string
+	Time     string
+	Type     string
+	TID      string
+	Nickname string
+	Name     string
+	tidKey   string
+	meta
+}
+
+func (*Chat) Kind() Kind { return KindChat }
+
+func decodeChat(r *reader) (Message, error) {
+	r.integer("code", true)
+	from, _ := r.object("fromuser", true)
+	to, _ := r.object("touser", true)
+	msg := &Chat{
+		From:     decodeChatParticipant(from, true),
+		To:       decodeChatParticipant(to, true),
+		Content:  r.str("content", false),
+		Time:     r.str("time", false),
+		Type:     r.str("type", false),
+		Nickname: r.str("nickname", false),
+		Name:     r.str("name", false),
+	}
+	msg.TID, msg.tidKey = r.firstStr("Tid", "tid")
+	if r.strict {
+		if msg.Text() == "" {
+			r.fail("content", "missing required field (fromuser.content or content)")
+		}
+		if msg.Timestamp() == "" {
+			r.fail("time", "missing required field (fromuser.time or time)")
+		}
+	}
+	msg.meta = r.meta()
+	if err := r.error(); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+func (m *Chat) encode() ([]byte, error) {
+	w := newWriter(m.meta)
+	w.integer("code", CodeChat, true)
+	if err := w.object("fromuser", m.From.fields(true), true); err != nil {
+		return nil, err
+	}
+	if err := w.object("touser", m.To.fields(true), true); err != nil {
+		return nil, err
+	}
+	w.str("content", m.Content, false)
+	w.str("time", m.Time, false)
+	w.str("type", m.Type, false)
+	w.str("nickname", m.Nickname, false)
+	w.str("name", m.Name, false)
+	w.str(tidKeyOrDefault(m.tidKey), m.TID, false)
+	return w.bytes()
+}
+
+// Text returns the message body, preferring the sender object.
+func (m *Chat) Text() string {
+	return firstNonEmpty(m.From.Content, m.Content)
+}
+
+// Timestamp returns the message time, preferring the sender object.
+func (m *Chat) Timestamp() string {
+	return firstNonEmpty(m.From.Time, m.Time)
+}
+
+// MessageType returns the message type, defaulting to "text".
+func (m *Chat) MessageType() string {
+	if t := firstNonEmpty(m.From.Type, m.Type); t != "" {
+		return t
+	}
+	return "text"
+}
+
+// MessageTID returns the upstream message id (Tid/tid), preferring the sender object.
+func (m *Chat) MessageTID() string {
+	return firstNonEmpty(m.From.TID, m.TID)
+}
+
+// SenderNickname returns the best-effort sender display name.
+func (m *Chat) SenderNickname() string {
+	return firstNonEmpty(m.From.Nickname, m.From.Name, m.Nickname, m.Name)
+}
+
+// UserInfo is the match result frame (code=15) describing the matched user.
+type UserInfo struct {
+	UserID   string // sel_userid
+	Nickname string // sel_userNikename (sic)
+	Sex      string // sel_userSex
+	Age      string // sel_userAge
+	Address  string // sel_userAddress
+	meta
+}
+
+func (*UserInfo) Kind() Kind { return KindUserInfo }
+
+func decodeUserInfo(r *reader) (Message, error) {
+	r.integer("code", true)
+	msg := &UserInfo{
+		UserID:   r.str("sel_userid", true),
+		Nickname: r.str("sel_userNikename", false),
+		Sex:      r.str("sel_userSex", false),
+		Age:      r.str("sel_userAge", false),
+		Address:  r.str("sel_userAddress", false),
+	}
+	msg.meta = r.meta()
+	if err := r.error(); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+func (m *UserInfo) encode() ([]byte, error) {
+	w := newWriter(m.meta)
+	w.integer("code", CodeUserInfo, true)
+	w.str("sel_userid", m.UserID, true)
+	w.str("sel_userNikename", m.Nickname, false)
+	w.str("sel_userSex", m.Sex, false)
+	w.str("sel_userAge", m.Age, false)
+	w.str("sel_userAddress", m.Address, false)
+	return w.bytes()
+}
+
+// Forceout is sent by upstream (code=-3) when the identity logged in elsewhere.
+type Forceout struct {
+	Content  string
+	Forceout bool
+	meta
+}
+
+func (*Forceout) Kind() Kind { return KindForceout }
+
+func decodeForceout(r *reader) (Message, error) {
+	r.integer("code", true)
+	msg := &Forceout{
+		Content:  r.str("content", false),
+		Forceout: r.boolean("forceout", true),
+	}
+	msg.meta = r.meta()
+	if err := r.error(); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+func (m *Forceout) encode() ([]byte, error) {
+	w := newWriter(m.meta)
+	w.integer("code", CodeForceout, true)
+	w.str("content", m.Content, true)
+	w.boolean("forceout", m.Forceout, true)
+	return w.bytes()
+}
+
+// Reject is the local frame (code=-4) sent when a sign is refused during a forceout ban.
+type Reject struct {
+	Content  string
+	Forceout bool
+	meta
+}
+
+func (*Reject) Kind() Kind { return KindReject }
+
+func decodeReject(r *reader) (Message, error) {
+	r.integer("code", true)
+	msg := &Reject{
+		Content:  r.str("content", true),
+		Forceout: r.boolean("forceout", true),
+	}
+	msg.meta = r.meta()
+	if err := r.error(); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+func (m *Reject) encode() ([]byte, error) {
+	w := newWriter(m.meta)
+	w.integer("code", CodeReject, true)
+	w.str("content", m.Content, true)
+	w.boolean("forceout", m.Forceout, true)
+	return w.bytes()
+}
+
+// Evict is the local frame (code=-6) sent when an identity is evicted by a newer one.
+type Evict struct {
+	Content string
+	Evicted bool
+	meta
+}
+
+func (*Evict) Kind() Kind { return KindEvict }
+
+func decodeEvict(r *reader) (Message, error) {
+	r.integer("code", true)
+	msg := &Evict{
+		Content: r.str("content", true),
+		Evicted: r.boolean("evicted", true),
+	}
+	msg.meta = r.meta()
+	if err := r.error(); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+func (m *Evict) encode() ([]byte, error) {
+	w := newWriter(m.meta)
+	w.integer("code", CodeEvict, true)
+	w.str("content", m.Content, true)
+	w.boolean("evicted", m.Evicted, true)
+	return w.bytes()
+}
+
+// CapacityReject is the local frame (code=-10) sent when a new identity is refused
+// because the connection scheduler is full and nothing can be evicted.
+type CapacityReject struct {
+	Content  string
+	Rejected bool
+	Capacity int
+	meta
+}
+
+func (*CapacityReject) Kind() Kind { return KindCapacity }
+
+func decodeCapacityReject(r *reader) (Message, error) {
+	r.integer("code", true)
+	msg := &CapacityReject{
+		Content:  r.str("content", true),
+		Rejected: r.boolean("rejected", true),
+		Capacity: r.integer("capacity", false),
+	}
+	msg.meta = r.meta()
+	if err := r.error(); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+func (m *CapacityReject) encode() ([]byte, error) {
+	w := newWriter(m.meta)
+	w.integer("code", CodeCapacity, true)
+	w.str("content", m.Content, true)
+	w.boolean("rejected", m.Rejected, true)
+	w.integer("capacity", m.Capacity, false)
+	return w.bytes()
+}
+
+// FilterReject is the local frame (code=-11) sent back to the sender when an
+// outbound message is blocked by the outbound filter and never reaches upstream.
+type FilterReject struct {
+	Content  string
+	Rejected bool
+	Rule     string
+	meta
+}
+
+func (*FilterReject) Kind() Kind { return KindFiltered }
+
+func decodeFilterReject(r *reader) (Message, error) {
+	r.integer("code", true)
+	msg := &FilterReject{
+		Content:  r.str("content", true),
+		Rejected: r.boolean("rejected", true),
+		Rule:     r.str("rule", false),
+	}
+	msg.meta = r.meta()
+	if err := r.error(); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+func (m *FilterReject) encode() ([]byte, error) {
+	w := newWriter(m.meta)
+	w.integer("code", CodeFiltered, true)
+	w.str("content", m.Content, true)
+	w.boolean("rejected", m.Rejected, true)
+	w.str("rule", m.Rule, false)
+	return w.bytes()
+}
+
+// Unknown is returned by DecodeLenient for frames without a typed model.
+type Unknown struct {
+	Code   int
+	Act    string
+	Fields Fields
+}
+
+func (*Unknown) Kind() Kind { return KindUnknown }
+
+func tidKeyOrDefault(key string) string {
+	if key == "" {
+		return "Tid"
+	}
+	return key
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if strings.TrimSpace(v) != "" {
+			return strings.TrimSpace(v)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,83 @@
+// Package protocol models the upstream chat WebSocket protocol (v1.chat2019.cn) and the
+// local control frames the proxy sends downstream.
+//
+// Two decoders are provided:
+//   - Decode is strict: known fields must have the documented JSON type and required
+//     fields must be present, so upstream schema drift surfaces as a *DecodeError.
+//   - DecodeLenient coerces scalar types (number <-> string, "1"/"true" -> bool) and
+//     tolerates missing fields, matching how the proxy has always read frames.
+//
+// Unknown fields are preserved in Extra, and Encode writes them back, so a frame that
+// passes Decode round-trips to semantically identical JSON.
+package protocol
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// Upstream and local frame codes.
+const (
+	CodeChat     = 7
+	CodeUserInfo = 15
+	CodeForceout = -3
+	CodeReject   = -4
+	CodeEvict    = -6
+	CodeCapacity = -10
+	CodeFiltered = -11
+)
+
+// ActSign is the "act" value of the downstream/upstream sign frame.
+const ActSign = "sign"
+
+// Kind identifies a decoded message type.
+type Kind string
+
+const (
+	KindSign     Kind = "sign"
+	KindChat     Kind = "chat"
+	KindUserInfo Kind = "userInfo"
+	KindForceout Kind = "forceout"
+	KindReject   Kind = "reject"
+	KindEvict    Kind = "evict"
+	KindCapacity Kind = "capacity"
+	KindFiltered Kind = "filtered"
+	KindUnknown  Kind = "unknown"
+)
+
+// Message is implemented by every typed frame.
+type Message interface {
+	Kind() Kind
+}
+
+// Fields holds raw JSON members that are not part of a typed model.
+type Fields map[string]json.RawMessage
+
+// ErrUnsupported is returned by Decode for frames whose code/act has no typed model.
+var ErrUnsupported = errors.New("protocol: unsupported frame")
+
+// DecodeError reports a schema violation found by the strict decoder.
+type DecodeError struct {
+	Kind   Kind
+	Field  string
+	Reason string
+}
+
+func (e *DecodeError) Error() string {
+	if e.Field == "" {
+		return fmt.Sprintf("protocol: %s: %s", e.Kind, e.Reason)
+	}
+	return fmt.Sprintf("protocol: %s.%s: %s", e.Kind, e.Field, e.Reason)
+}
+
+// meta carries unknown fields and which known keys were present, for lossless re-encoding.
+type meta struct {
+	Extra   Fields
+	present map[string]struct{}
+}
+
+func (m meta) has(key string) bool {
+	_, ok := m.present[key]
+	return ok
+}
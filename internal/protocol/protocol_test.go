@@ -0,0 +1,160 @@
+package protocol
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func assertSameJSON(t *testing.T, got []byte, want string) {
+	t.Helper()
+	var a, b any
+	if err := json.Unmarshal(got, &a); err != nil {
+		t.Fatalf("unmarshal got: %v (%s)", err, got)
+	}
+	if err := json.Unmarshal([]byte(want), &b); err != nil {
+		t.Fatalf("unmarshal want: %v", err)
+	}
+	if !reflect.DeepEqual(a, b) {
+		t.Fatalf("json mismatch\n got=%s\nwant=%s", got, want)
+	}
+}
+
+func TestDecode_RoundTrip(t *testing.T) {
+	frames := []struct {
+		raw  string
+		kind Kind
+	}{
+		{`{"act":"sign","id":"u1","name":"N","userSex":"男","address_show":"false","randomhealthmode":"0","randomvipsex":"0","randomvipaddress":"0","userip":"1.2.3.4","useraddree":"CN","randomvipcode":"","lastSeq":3}`, KindSign},
+		{`{"code":7,"fromuser":{"id":"a","nickname":"A","content":"hi","time":"2026-01-01 00:00:00","type":"text","Tid":"t1","avatar":"x.png"},"touser":{"id":"b"},"extra":[1,2]}`, KindChat},
+		{`{"code":7,"fromuser":{"id":"a"},"touser":{"id":"b","name":"B"},"content":"hi","time":"t","tid":"t2"}`, KindChat},
+		{`{"code":15,"sel_userid":"u9","sel_userNikename":"Bob","sel_userSex":"男","sel_userAge":"20","sel_userAddress":"BJ","sel_vip":0}`, KindUserInfo},
+		{`{"code":-3,"forceout":true,"content":"请不要在同一个浏览器下重复登录"}`, KindForceout},
+		{`{"code":-4,"content":"由于重复登录，您的连接被暂时禁止，请300秒后再试","forceout":true}`, KindReject},
+		{`{"code":-6,"content":"由于新身份连接，您已被自动断开","evicted":true}`, KindEvict},
+		{`{"code":-10,"content":"连接身份已达上限","rejected":true,"capacity":2}`, KindCapacity},
+		{`{"code":-11,"content":"消息包含违禁词，未发送","rejected":true,"rule":"banned-word"}`, KindFiltered},
+	}
+	for _, tc := range frames {
+		msg, err := Decode([]byte(tc.raw))
+		if err != nil {
+			t.Fatalf("Decode(%s): %v", tc.raw, err)
+		}
+		if msg.Kind() != tc.kind {
+			t.Fatalf("kind=%s, want %s", msg.Kind(), tc.kind)
+		}
+		out, err := Encode(msg)
+		if err != nil {
+			t.Fatalf("Encode: %v", err)
+		}
+		assertSameJSON(t, out, tc.raw)
+	}
+}
+
+func TestDecode_ChatAccessors(t *testing.T) {
+	msg, err := Decode([]byte(`{"code":7,"fromuser":{"id":"a","name":"A"},"touser":{"id":"b"},"content":"hi","time":"t","tid":"t2"}`))
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	chat := msg.(*Chat)
+	if chat.Text() != "hi" || chat.Timestamp() != "t" || chat.MessageType() != "text" || chat.MessageTID() != "t2" || chat.SenderNickname() != "A" {
+		t.Fatalf("unexpected accessors: %+v", chat)
+	}
+	if chat.From.ID != "a" || chat.To.ID != "b" {
+		t.Fatalf("unexpected participants: %+v", chat)
+	}
+}
+
+func TestDecode_StrictReportsSchemaDrift(t *testing.T) {
+	cases := []struct {
+		raw   string
+		field string
+	}{
+		{`{"act":"sign"}`, "id"},
+		{`{"code":"7","fromuser":{"id":"a","content":"x","time":"t"},"touser":{"id":"b"}}`, "code"},
+		{`{"code":7,"from_user":{"id":"a"},"touser":{"id":"b"},"content":"x","time":"t"}`, "fromuser"},
+		{`{"code":7,"fromuser":{"id":1,"content":"x","time":"t"},"touser":{"id":"b"}}`, "fromuser.id"},
+		{`{"code":7,"fromuser":{"id":"a","time":"t"},"touser":{"id":"b"}}`, "content"},
+		{`{"code":15,"sel_userId":"u9"}`, "sel_userid"},
+		{`{"code":15,"sel_userid":"u9","sel_userAge":20}`, "sel_userAge"},
+		{`{"code":-3,"forceout":"true"}`, "forceout"},
+		{`{"code":-6,"content":"x"}`, "evicted"},
+	}
+	for _, tc := range cases {
+		_, err := Decode([]byte(tc.raw))
+		var de *DecodeError
+		if !errors.As(err, &de) {
+			t.Fatalf("Decode(%s) err=%v, want DecodeError", tc.raw, err)
+		}
+		if de.Field != tc.field {
+			t.Fatalf("Decode(%s) field=%q, want %q (%v)", tc.raw, de.Field, tc.field, err)
+		}
+		if !strings.HasPrefix(err.Error(), "protocol: ") {
+			t.Fatalf("unexpected error text: %v", err)
+		}
+	}
+
+	if _, err := Decode([]byte(`{"code":12,"content":"x"}`)); !errors.Is(err, ErrUnsupported) {
+		t.Fatalf("err=%v, want ErrUnsupported", err)
+	}
+	for _, raw := range []string{`not-json`, `[1]`, `{"a":`} {
+		if _, err := Decode([]byte(raw)); err == nil {
+			t.Fatalf("Decode(%s) expected error", raw)
+		}
+	}
+}
+
+func TestDecodeLenient_CoercesAndTolerates(t *testing.T) {
+	msg, err := DecodeLenient([]byte(`{"code":"15","sel_userid":9527,"sel_userAge":20}`))
+	if err != nil {
+		t.Fatalf("DecodeLenient: %v", err)
+	}
+	info := msg.(*UserInfo)
+	if info.UserID != "9527" || info.Age != "20" {
+		t.Fatalf("unexpected user info: %+v", info)
+	}
+
+	msg, err = DecodeLenient([]byte(`{"code":-3,"forceout":"1"}`))
+	if err != nil || !msg.(*Forceout).Forceout {
+		t.Fatalf("msg=%+v err=%v", msg, err)
+	}
+
+	msg, err = DecodeLenient([]byte(`{"code":7,"fromuser":"oops"}`))
+	if err != nil {
+		t.Fatalf("DecodeLenient: %v", err)
+	}
+	if chat := msg.(*Chat); chat.MessageType() != "text" || chat.From.ID != "" {
+		t.Fatalf("unexpected chat: %+v", chat)
+	}
+
+	msg, err = DecodeLenient([]byte(`{"code":12,"content":"x"}`))
+	if err != nil {
+		t.Fatalf("DecodeLenient: %v", err)
+	}
+	unknown, ok := msg.(*Unknown)
+	if !ok || unknown.Code != 12 || unknown.Kind() != KindUnknown {
+		t.Fatalf("unexpected message: %#v", msg)
+	}
+	out, _ := Encode(unknown)
+	assertSameJSON(t, out, `{"code":12,"content":"x"}`)
+}
+
+func TestEncode_ConstructedMessages(t *testing.T) {
+	out, err := Encode(&Reject{Content: "x", Forceout: true})
+	if err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	assertSameJSON(t, out, `{"code":-4,"content":"x","forceout":true}`)
+
+	out, _ = Encode(&Chat{From: ChatParticipant{ID: "a", Content: "hi", TID: "t1"}, To: ChatParticipant{ID: "b"}, Time: "t"})
+	assertSameJSON(t, out, `{"code":7,"fromuser":{"id":"a","content":"hi","Tid":"t1"},"touser":{"id":"b"},"time":"t"}`)
+
+	out, _ = Encode(&Sign{ID: "u1"})
+	assertSameJSON(t, out, `{"act":"sign","id":"u1"}`)
+
+	if _, err := Encode(nil); err == nil {
+		t.Fatalf("expected error for nil message")
+	}
+}
@@ -0,0 +1,548 @@
+// Package qrcode is a small QR Code (ISO/IEC 18004) encoder for provisioning URIs such as
+// otpauth:// links, so that enrollment screens can show a scannable image without calling
+// an external service.
+//
+// Only what that use case needs is implemented: byte mode, error correction level M and
+// versions 1-15 (up to 412 bytes). The mask with the lowest penalty score is chosen.
+package qrcode
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+)
+
+// MaxVersion is the largest symbol version Encode produces.
+const MaxVersion = 15
+
+// quietZone is the light border (in modules) required around the symbol.
+const quietZone = 4
+
+// ErrTooLong is returned when the data does not fit in a version MaxVersion symbol.
+var ErrTooLong = errors.New("qrcode: data too long")
+
+// blockLayout describes how a version's codewords are split at error correction level M.
+type blockLayout struct {
+	ecPerBlock int
+	// group1/group2 are {block count, data codewords per block}; group2 blocks hold one more.
+	group1 [2]int
+	group2 [2]int
+}
+
+var layoutsM = [MaxVersion + 1]blockLayout{
+	1:  {10, [2]int{1, 16}, [2]int{0, 0}},
+	2:  {16, [2]int{1, 28}, [2]int{0, 0}},
+	3:  {26, [2]int{1, 44}, [2]int{0, 0}},
+	4:  {18, [2]int{2, 32}, [2]int{0, 0}},
+	5:  {24, [2]int{2, 43}, [2]int{0, 0}},
+	6:  {16, [2]int{4, 27}, [2]int{0, 0}},
+	7:  {18, [2]int{4, 31}, [2]int{0, 0}},
+	8:  {22, [2]int{2, 38}, [2]int{2, 39}},
+	9:  {22, [2]int{3, 36}, [2]int{2, 37}},
+	10: {26, [2]int{4, 43}, [2]int{1, 44}},
+	11: {30, [2]int{1, 50}, [2]int{4, 51}},
+	12: {22, [2]int{6, 36}, [2]int{2, 37}},
+	13: {22, [2]int{8, 37}, [2]int{1, 38}},
+	14: {24, [2]int{4, 40}, [2]int{5, 41}},
+	15: {24, [2]int{5, 41}, [2]int{5, 42}},
+}
+
+func (l blockLayout) dataCodewords() int {
+	return l.group1[0]*l.group1[1] + l.group2[0]*l.group2[1]
+}
+
+func (l blockLayout) blocks() int {
+	return l.group1[0] + l.group2[0]
+}
+
+// Code is an encoded QR symbol. Modules are indexed [y][x]; true means dark.
+type Code struct {
+	version  int
+	size     int
+	modules  [][]bool
+	function [][]bool
+}
+
+// Encode builds the smallest symbol (level M) that holds data in byte mode.
+func Encode(data []byte) (*Code, error) {
+	version := 0
+	for v := 1; v <= MaxVersion; v++ {
+		if len(data) <= byteCapacity(v) {
+			version = v
+			break
+		}
+	}
+	if version == 0 {
+		return nil, ErrTooLong
+	}
+
+	c := newCode(version)
+	c.drawFunctionPatterns()
+	c.drawCodewords(c.addErrorCorrection(encodeData(data, version)))
+
+	best, bestPenalty := 0, -1
+	for mask := 0; mask < 8; mask++ {
+		c.applyMask(mask)
+		c.drawFormatBits(mask)
+		if p := c.penalty(); bestPenalty < 0 || p < bestPenalty {
+			best, bestPenalty = mask, p
+		}
+		c.applyMask(mask) // XOR again to undo
+	}
+	c.applyMask(best)
+	c.drawFormatBits(best)
+	return c, nil
+}
+
+// Version returns the symbol version (1-MaxVersion).
+func (c *Code) Version() int { return c.version }
+
+// Size returns the width of the symbol in modules, excluding the quiet zone.
+func (c *Code) Size() int { return c.size }
+
+// Dark reports whether the module at column x, row y is dark.
+func (c *Code) Dark(x, y int) bool {
+	return x >= 0 && y >= 0 && x < c.size && y < c.size && c.modules[y][x]
+}
+
+// Image renders the symbol with scale pixels per module and the standard quiet zone.
+func (c *Code) Image(scale int) image.Image {
+	if scale < 1 {
+		scale = 1
+	}
+	width := (c.size + 2*quietZone) * scale
+	img := image.NewPaletted(image.Rect(0, 0, width, width), color.Palette{color.White, color.Black})
+	for y := 0; y < c.size; y++ {
+		for x := 0; x < c.size; x++ {
+			if !c.modules[y][x] {
+				continue
+			}
+			for dy := 0; dy < scale; dy++ {
+				row := (y+quietZone)*scale + dy
+				for dx := 0; dx < scale; dx++ {
+					img.SetColorIndex((x+quietZone)*scale+dx, row, 1)
+				}
+			}
+		}
+	}
+	return img
+}
+
+// PNG renders the symbol as a PNG image (see Image).
+func (c *Code) PNG(scale int) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, c.Image(scale)); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func newCode(version int) *Code {
+	size := version*4 + 17
+	c := &Code{version: version, size: size, modules: make([][]bool, size), function: make([][]bool, size)}
+	for i := range c.modules {
+		c.modules[i] = make([]bool, size)
+		c.function[i] = make([]bool, size)
+	}
+	return c
+}
+
+// byteCapacity returns how many bytes fit in a level M symbol of the given version.
+func byteCapacity(version int) int {
+	return (layoutsM[version].dataCodewords()*8 - 4 - charCountBits(version)) / 8
+}
+
+func charCountBits(version int) int {
+	if version <= 9 {
+		return 8
+	}
+	return 16
+}
+
+// rawDataModules returns the number of modules available for codewords (and remainder bits).
+func rawDataModules(version int) int {
+	n := (16*version+128)*version + 64
+	if version >= 2 {
+		align := version/7 + 2
+		n -= (25*align-10)*align - 55
+		if version >= 7 {
+			n -= 36
+		}
+	}
+	return n
+}
+
+// alignmentPositions returns the row/column centers of the alignment patterns.
+func alignmentPositions(version int) []int {
+	if version == 1 {
+		return nil
+	}
+	count := version/7 + 2
+	step := (version*4 + count*2 + 1) / (count*2 - 2) * 2
+	out := make([]int, count)
+	out[0] = 6
+	for i, pos := count-1, version*4+10; i >= 1; i, pos = i-1, pos-step {
+		out[i] = pos
+	}
+	return out
+}
+
+type bitBuffer []bool
+
+func (b *bitBuffer) append(value, n int) {
+	for i := n - 1; i >= 0; i-- {
+		*b = append(*b, (value>>i)&1 != 0)
+	}
+}
+
+// encodeData produces the data codewords: mode, length, payload, terminator and padding.
+func encodeData(data []byte, version int) []byte {
+	capacity := layoutsM[version].dataCodewords()
+	var bits bitBuffer
+	bits.append(0x4, 4) // byte mode
+	bits.append(len(data), charCountBits(version))
+	for _, b := range data {
+		bits.append(int(b), 8)
+	}
+	terminator := capacity*8 - len(bits)
+	if terminator > 4 {
+		terminator = 4
+	}
+	bits.append(0, terminator)
+	bits.append(0, (8-len(bits)%8)%8)
+
+	out := make([]byte, 0, capacity)
+	for i := 0; i < len(bits); i += 8 {
+		var b byte
+		for j := 0; j < 8; j++ {
+			if bits[i+j] {
+				b |= 1 << (7 - j)
+			}
+		}
+		out = append(out, b)
+	}
+	for pad := byte(0xEC); len(out) < capacity; pad ^= 0xEC ^ 0x11 {
+		out = append(out, pad)
+	}
+	return out
+}
+
+// addErrorCorrection splits data into blocks, appends Reed-Solomon codewords to each and
+// interleaves the result.
+func (c *Code) addErrorCorrection(data []byte) []byte {
+	layout := layoutsM[c.version]
+	divisor := rsDivisor(layout.ecPerBlock)
+	dataBlocks := make([][]byte, 0, layout.blocks())
+	ecBlocks := make([][]byte, 0, layout.blocks())
+	offset := 0
+	for _, group := range [][2]int{layout.group1, layout.group2} {
+		for i := 0; i < group[0]; i++ {
+			block := data[offset : offset+group[1]]
+			offset += group[1]
+			dataBlocks = append(dataBlocks, block)
+			ecBlocks = append(ecBlocks, rsRemainder(block, divisor))
+		}
+	}
+
+	out := make([]byte, 0, rawDataModules(c.version)/8)
+	for i := 0; ; i++ {
+		wrote := false
+		for _, block := range dataBlocks {
+			if i < len(block) {
+				out = append(out, block[i])
+				wrote = true
+			}
+		}
+		if !wrote {
+			break
+		}
+	}
+	for i := 0; i < layout.ecPerBlock; i++ {
+		for _, block := range ecBlocks {
+			out = append(out, block[i])
+		}
+	}
+	return out
+}
+
+func (c *Code) set(x, y int, dark bool) {
+	c.modules[y][x] = dark
+	c.function[y][x] = true
+}
+
+func (c *Code) drawFunctionPatterns() {
+	for i := 0; i < c.size; i++ {
+		c.set(6, i, i%2 == 0)
+		c.set(i, 6, i%2 == 0)
+	}
+	c.drawFinder(3, 3)
+	c.drawFinder(c.size-4, 3)
+	c.drawFinder(3, c.size-4)
+
+	positions := alignmentPositions(c.version)
+	last := len(positions) - 1
+	for i, y := range positions {
+		for j, x := range positions {
+			// Skip the three corners occupied by finder patterns.
+			if (i == 0 && j == 0) || (i == 0 && j == last) || (i == last && j == 0) {
+				continue
+			}
+			for dy := -2; dy <= 2; dy++ {
+				for dx := -2; dx <= 2; dx++ {
+					c.set(x+dx, y+dy, max(abs(dx), abs(dy)) != 1)
+				}
+			}
+		}
+	}
+
+	c.drawFormatBits(0) // reserve the area; redrawn once the mask is chosen
+	c.drawVersion()
+}
+
+// drawFinder draws a finder pattern centered at (x, y) together with its separator.
+func (c *Code) drawFinder(x, y int) {
+	for dy := -4; dy <= 4; dy++ {
+		for dx := -4; dx <= 4; dx++ {
+			xx, yy := x+dx, y+dy
+			if xx < 0 || yy < 0 || xx >= c.size || yy >= c.size {
+				continue
+			}
+			dist := max(abs(dx), abs(dy))
+			c.set(xx, yy, dist != 2 && dist != 4)
+		}
+	}
+}
+
+// formatBits returns the 15-bit BCH-protected format information for level M and mask.
+func formatBits(mask int) int {
+	data := 0<<3 | mask // level M is encoded as 00
+	rem := data
+	for i := 0; i < 10; i++ {
+		rem = (rem << 1) ^ ((rem >> 9) * 0x537)
+	}
+	return (data<<10 | rem) ^ 0x5412
+}
+
+func (c *Code) drawFormatBits(mask int) {
+	bits := formatBits(mask)
+	bit := func(i int) bool { return (bits>>i)&1 != 0 }
+
+	for i := 0; i <= 5; i++ {
+		c.set(8, i, bit(i))
+	}
+	c.set(8, 7, bit(6))
+	c.set(8, 8, bit(7))
+	c.set(7, 8, bit(8))
+	for i := 9; i < 15; i++ {
+		c.set(14-i, 8, bit(i))
+	}
+
+	for i := 0; i < 8; i++ {
+		c.set(c.size-1-i, 8, bit(i))
+	}
+	for i := 8; i < 15; i++ {
+		c.set(8, c.size-15+i, bit(i))
+	}
+	c.set(8, c.size-8, true) // dark module
+}
+
+// versionBits returns the 18-bit BCH-protected version information (versions >= 7).
+func versionBits(version int) int {
+	rem := version
+	for i := 0; i < 12; i++ {
+		rem = (rem << 1) ^ ((rem >> 11) * 0x1F25)
+	}
+	return version<<12 | rem
+}
+
+func (c *Code) drawVersion() {
+	if c.version < 7 {
+		return
+	}
+	bits := versionBits(c.version)
+	for i := 0; i < 18; i++ {
+		dark := (bits>>i)&1 != 0
+		a, b := c.size-11+i%3, i/3
+		c.set(a, b, dark)
+		c.set(b, a, dark)
+	}
+}
+
+// drawCodewords places the bits in the zigzag order, two columns at a time from the right.
+func (c *Code) drawCodewords(codewords []byte) {
+	i := 0
+	for right := c.size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5 // skip the vertical timing pattern
+		}
+		upward := (right+1)&2 == 0
+		for vert := 0; vert < c.size; vert++ {
+			y := vert
+			if upward {
+				y = c.size - 1 - vert
+			}
+			for j := 0; j < 2; j++ {
+				x := right - j
+				if c.function[y][x] {
+					continue
+				}
+				if i < len(codewords)*8 {
+					c.modules[y][x] = (codewords[i>>3]>>(7-i&7))&1 != 0
+					i++
+				}
+				// Remainder bits stay light.
+			}
+		}
+	}
+}
+
+func maskBit(mask, x, y int) bool {
+	switch mask {
+	case 0:
+		return (x+y)%2 == 0
+	case 1:
+		return y%2 == 0
+	case 2:
+		return x%3 == 0
+	case 3:
+		return (x+y)%3 == 0
+	case 4:
+		return (x/3+y/2)%2 == 0
+	case 5:
+		return x*y%2+x*y%3 == 0
+	case 6:
+		return (x*y%2+x*y%3)%2 == 0
+	default:
+		return ((x+y)%2+x*y%3)%2 == 0
+	}
+}
+
+func (c *Code) applyMask(mask int) {
+	for y := 0; y < c.size; y++ {
+		for x := 0; x < c.size; x++ {
+			if !c.function[y][x] && maskBit(mask, x, y) {
+				c.modules[y][x] = !c.modules[y][x]
+			}
+		}
+	}
+}
+
+// penalty scores the symbol with the four rules of ISO/IEC 18004 section 7.8.3.
+func (c *Code) penalty() int {
+	score := 0
+	line := make([]bool, c.size)
+	for _, vertical := range []bool{false, true} {
+		for a := 0; a < c.size; a++ {
+			for b := 0; b < c.size; b++ {
+				if vertical {
+					line[b] = c.modules[b][a]
+				} else {
+					line[b] = c.modules[a][b]
+				}
+			}
+			score += linePenalty(line)
+		}
+	}
+
+	dark := 0
+	for y := 0; y < c.size; y++ {
+		for x := 0; x < c.size; x++ {
+			if c.modules[y][x] {
+				dark++
+			}
+			if x+1 < c.size && y+1 < c.size {
+				v := c.modules[y][x]
+				if c.modules[y][x+1] == v && c.modules[y+1][x] == v && c.modules[y+1][x+1] == v {
+					score += 3
+				}
+			}
+		}
+	}
+	total := c.size * c.size
+	deviation := abs(dark*20-total*10) / total // 5% steps away from 50%
+	return score + deviation*10
+}
+
+var finderLike = [2][11]bool{
+	{true, false, true, true, true, false, true, false, false, false, false},
+	{false, false, false, false, true, false, true, true, true, false, true},
+}
+
+// linePenalty applies rule 1 (runs of five or more) and rule 3 (finder-like patterns).
+func linePenalty(line []bool) int {
+	score := 0
+	run := 1
+	for i := 1; i <= len(line); i++ {
+		if i < len(line) && line[i] == line[i-1] {
+			run++
+			continue
+		}
+		if run >= 5 {
+			score += run - 2
+		}
+		run = 1
+	}
+	for i := 0; i+11 <= len(line); i++ {
+		for _, pattern := range finderLike {
+			match := true
+			for j := 0; j < 11 && match; j++ {
+				match = line[i+j] == pattern[j]
+			}
+			if match {
+				score += 40
+			}
+		}
+	}
+	return score
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+// rsDivisor returns the Reed-Solomon generator polynomial of the given degree, highest
+// coefficient first and the leading 1 omitted.
+func rsDivisor(degree int) []byte {
+	result := make([]byte, degree)
+	result[degree-1] = 1
+	root := byte(1)
+	for i := 0; i < degree; i++ {
+		for j := range result {
+			result[j] = gfMultiply(result[j], root)
+			if j+1 < len(result) {
+				result[j] ^= result[j+1]
+			}
+		}
+		root = gfMultiply(root, 0x02)
+	}
+	return result
+}
+
+// rsRemainder returns the error correction codewords for data.
+func rsRemainder(data, divisor []byte) []byte {
+	result := make([]byte, len(divisor))
+	for _, b := range data {
+		factor := b ^ result[0]
+		copy(result, result[1:])
+		result[len(result)-1] = 0
+		for i, coef := range divisor {
+			result[i] ^= gfMultiply(coef, factor)
+		}
+	}
+	return result
+}
+
+// gfMultiply multiplies in GF(2^8) modulo x^8 + x^4 + x^3 + x^2 + 1.
+func gfMultiply(x, y byte) byte {
+	z := 0
+	for i := 7; i >= 0; i-- {
+		z = (z << 1) ^ ((z >> 7) * 0x11D)
+		z ^= int((y>>i)&1) * int(x)
+	}
+	return byte(z)
+}
@@ -0,0 +1,196 @@
+package qrcode
+
+import (
+	"bytes"
+	"errors"
+	"image/png"
+	"strings"
+	"testing"
+)
+
+func TestRSRemainder_KnownVector(t *testing.T) {
+	// "HELLO WORLD" at 1-M, from the well-known worked example.
+	data := []byte{32, 91, 11, 120, 209, 114, 220, 77, 67, 64, 236, 17, 236, 17, 236, 17}
+	want := []byte{196, 35, 39, 119, 235, 215, 231, 226, 93, 23}
+	if got := rsRemainder(data, rsDivisor(10)); !bytes.Equal(got, want) {
+		t.Fatalf("ec=%v, want %v", got, want)
+	}
+}
+
+func TestFormatAndVersionBits(t *testing.T) {
+	if got := formatBits(0); got != 0b101010000010010 {
+		t.Fatalf("format(M,0)=%015b", got)
+	}
+	if got := versionBits(7); got != 0b000111110010010100 {
+		t.Fatalf("version(7)=%018b", got)
+	}
+}
+
+func TestLayoutsMatchModuleCount(t *testing.T) {
+	for v := 1; v <= MaxVersion; v++ {
+		l := layoutsM[v]
+		if got, want := l.dataCodewords()+l.blocks()*l.ecPerBlock, rawDataModules(v)/8; got != want {
+			t.Fatalf("version %d: %d codewords, want %d", v, got, want)
+		}
+	}
+}
+
+func TestEncode_RoundTrip(t *testing.T) {
+	inputs := []string{
+		"",
+		"hello",
+		"otpauth://totp/Liao:admin?secret=JBSWY3DPEHPK3PXPJBSWY3DPEHPK3PXP&issuer=Liao&algorithm=SHA1&digits=6&period=30",
+		strings.Repeat("0123456789abcdef", 12),
+		strings.Repeat("x", byteCapacity(MaxVersion)),
+	}
+	for _, input := range inputs {
+		code, err := Encode([]byte(input))
+		if err != nil {
+			t.Fatalf("Encode(%d bytes): %v", len(input), err)
+		}
+		if code.Size() != code.Version()*4+17 {
+			t.Fatalf("size=%d version=%d", code.Size(), code.Version())
+		}
+		if got := decode(t, code); got != input {
+			t.Fatalf("round trip mismatch: got %q, want %q", got, input)
+		}
+	}
+
+	if _, err := Encode(make([]byte, byteCapacity(MaxVersion)+1)); !errors.Is(err, ErrTooLong) {
+		t.Fatalf("err=%v, want ErrTooLong", err)
+	}
+}
+
+func TestPNG(t *testing.T) {
+	code, err := Encode([]byte("hello"))
+	if err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	raw, err := code.PNG(3)
+	if err != nil {
+		t.Fatalf("PNG: %v", err)
+	}
+	img, err := png.Decode(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatalf("decode png: %v", err)
+	}
+	if w := img.Bounds().Dx(); w != (code.Size()+2*quietZone)*3 {
+		t.Fatalf("width=%d", w)
+	}
+	// Top-left finder corner is dark, the quiet zone is light.
+	if r, _, _, _ := img.At(quietZone*3, quietZone*3).RGBA(); r != 0 {
+		t.Fatalf("finder corner not dark")
+	}
+	if r, _, _, _ := img.At(0, 0).RGBA(); r == 0 {
+		t.Fatalf("quiet zone not light")
+	}
+}
+
+// decode reads the symbol back independently of the mask choice: it recovers the mask from
+// the format information, checks every block's error correction and parses the byte segment.
+func decode(t *testing.T, code *Code) string {
+	t.Helper()
+	ref := newCode(code.Version())
+	ref.drawFunctionPatterns()
+
+	var format int
+	for i := 0; i <= 5; i++ {
+		format |= b2i(code.Dark(8, i)) << i
+	}
+	format |= b2i(code.Dark(8, 7))<<6 | b2i(code.Dark(8, 8))<<7 | b2i(code.Dark(7, 8))<<8
+	for i := 9; i < 15; i++ {
+		format |= b2i(code.Dark(14-i, 8)) << i
+	}
+	mask := -1
+	for m := 0; m < 8; m++ {
+		if formatBits(m) == format {
+			mask = m
+		}
+	}
+	if mask < 0 {
+		t.Fatalf("unknown format bits %015b", format)
+	}
+
+	layout := layoutsM[code.Version()]
+	total := layout.dataCodewords() + layout.blocks()*layout.ecPerBlock
+	stream := make([]byte, total)
+	i := 0
+	for right := code.Size() - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+		upward := (right+1)&2 == 0
+		for vert := 0; vert < code.Size(); vert++ {
+			y := vert
+			if upward {
+				y = code.Size() - 1 - vert
+			}
+			for j := 0; j < 2; j++ {
+				x := right - j
+				if ref.function[y][x] || i >= total*8 {
+					continue
+				}
+				if code.Dark(x, y) != maskBit(mask, x, y) {
+					stream[i>>3] |= 1 << (7 - i&7)
+				}
+				i++
+			}
+		}
+	}
+
+	// De-interleave and verify each block.
+	sizes := make([]int, 0, layout.blocks())
+	for _, group := range [][2]int{layout.group1, layout.group2} {
+		for n := 0; n < group[0]; n++ {
+			sizes = append(sizes, group[1])
+		}
+	}
+	blocks := make([][]byte, len(sizes))
+	pos := 0
+	for k := 0; k < sizes[len(sizes)-1]; k++ {
+		for b, size := range sizes {
+			if k < size {
+				blocks[b] = append(blocks[b], stream[pos])
+				pos++
+			}
+		}
+	}
+	var data []byte
+	divisor := rsDivisor(layout.ecPerBlock)
+	for b := range blocks {
+		ec := make([]byte, layout.ecPerBlock)
+		for k := range ec {
+			ec[k] = stream[pos+k*len(blocks)+b]
+		}
+		if got := rsRemainder(blocks[b], divisor); !bytes.Equal(got, ec) {
+			t.Fatalf("block %d: ec mismatch", b)
+		}
+		data = append(data, blocks[b]...)
+	}
+
+	if data[0]>>4 != 0x4 {
+		t.Fatalf("mode=%x, want byte mode", data[0]>>4)
+	}
+	bitAt := func(n int) int { return int(data[n>>3]>>(7-n&7)) & 1 }
+	read := func(off, n int) int {
+		v := 0
+		for k := 0; k < n; k++ {
+			v = v<<1 | bitAt(off+k)
+		}
+		return v
+	}
+	countBits := charCountBits(code.Version())
+	length := read(4, countBits)
+	out := make([]byte, length)
+	for k := range out {
+		out[k] = byte(read(4+countBits+k*8, 8))
+	}
+	return string(out)
+}
+
+func b2i(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,16 @@
+-- MySQL schema migration: 009_upstream_outbox
+-- Persist every frame sent to upstream WebSocket so undelivered messages survive disconnects and restarts.
+
+CREATE TABLE IF NOT EXISTS upstream_outbox (
+	id BIGINT AUTO_INCREMENT PRIMARY KEY,
+	user_id VARCHAR(64) NOT NULL COMMENT '发送身份ID',
+	payload LONGTEXT NOT NULL COMMENT '发往上游的原始消息',
+	status VARCHAR(16) NOT NULL COMMENT '状态：queued/sent/failed',
+	attempts INT NOT NULL DEFAULT 0 COMMENT '已尝试发送次数',
+	last_error VARCHAR(512) NULL COMMENT '最近一次失败原因',
+	created_at DATETIME NOT NULL COMMENT '创建时间',
+	updated_at DATETIME NOT NULL COMMENT '更新时间',
+	sent_at DATETIME NULL COMMENT '发送成功时间',
+	INDEX idx_upstream_outbox_user_status (user_id, status, created_at),
+	INDEX idx_upstream_outbox_status_updated (status, updated_at)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='上游 WebSocket 发送队列';
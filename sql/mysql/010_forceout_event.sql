@@ -0,0 +1,13 @@
+-- MySQL schema migration: 010_forceout_event
+-- Persist upstream forceout events; the active ban of an identity is derived from its latest uncleared event.
+
+CREATE TABLE IF NOT EXISTS forceout_event (
+	id BIGINT AUTO_INCREMENT PRIMARY KEY,
+	user_id VARCHAR(64) NOT NULL COMMENT '被踢下线的身份ID',
+	raw_message LONGTEXT NULL COMMENT '上游 forceout 原始消息',
+	banned_until DATETIME NOT NULL COMMENT '禁止重连截止时间',
+	cleared_at DATETIME NULL COMMENT '手动解除时间',
+	created_at DATETIME NOT NULL COMMENT '发生时间',
+	INDEX idx_forceout_event_user_created (user_id, created_at),
+	INDEX idx_forceout_event_banned_until (banned_until)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='上游 forceout 事件';
@@ -0,0 +1,21 @@
+-- MySQL schema migration: 011_auto_reply_rule
+-- Per-identity auto-reply rules evaluated against incoming private chat messages (code=7).
+
+CREATE TABLE IF NOT EXISTS auto_reply_rule (
+	id BIGINT AUTO_INCREMENT PRIMARY KEY,
+	user_id VARCHAR(64) NOT NULL COMMENT '本地身份ID',
+	name VARCHAR(128) NOT NULL COMMENT '规则名称',
+	enabled TINYINT(1) NOT NULL DEFAULT 1 COMMENT '是否启用',
+	priority INT NOT NULL DEFAULT 0 COMMENT '优先级（越大越先匹配）',
+	match_senders VARCHAR(1024) NULL COMMENT '发送者ID列表（逗号分隔，空表示任意）',
+	match_keyword VARCHAR(255) NULL COMMENT '关键字（包含匹配，忽略大小写）',
+	match_regex VARCHAR(512) NULL COMMENT '正则表达式',
+	match_type VARCHAR(32) NULL COMMENT '消息类型（空表示任意）',
+	time_start VARCHAR(5) NULL COMMENT '生效开始时间 HH:MM',
+	time_end VARCHAR(5) NULL COMMENT '生效结束时间 HH:MM',
+	reply_template TEXT NOT NULL COMMENT '回复模板',
+	cooldown_seconds INT NOT NULL DEFAULT 60 COMMENT '同一会话最小回复间隔（秒）',
+	created_at DATETIME NOT NULL COMMENT '创建时间',
+	updated_at DATETIME NOT NULL COMMENT '更新时间',
+	INDEX idx_auto_reply_rule_user (user_id, enabled, priority)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='WebSocket 自动回复规则';
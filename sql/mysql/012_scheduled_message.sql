@@ -0,0 +1,20 @@
+-- MySQL schema migration: 012_scheduled_message
+-- Chat messages queued per identity for a future send time; survives restarts.
+
+CREATE TABLE IF NOT EXISTS scheduled_message (
+	id BIGINT AUTO_INCREMENT PRIMARY KEY,
+	user_id VARCHAR(64) NOT NULL COMMENT '发送身份ID',
+	target_user_id VARCHAR(64) NOT NULL COMMENT '接收用户ID',
+	target_nickname VARCHAR(128) NULL COMMENT '接收用户昵称',
+	content TEXT NOT NULL COMMENT '消息内容',
+	sign_message TEXT NOT NULL COMMENT '建立上游连接使用的 sign 消息',
+	send_at DATETIME NOT NULL COMMENT '计划发送时间',
+	status VARCHAR(16) NOT NULL COMMENT '状态：pending/sending/sent/failed/canceled',
+	attempts INT NOT NULL DEFAULT 0 COMMENT '已尝试发送次数',
+	last_error VARCHAR(512) NULL COMMENT '最近一次失败原因',
+	sent_at DATETIME NULL COMMENT '发送成功时间',
+	created_at DATETIME NOT NULL COMMENT '创建时间',
+	updated_at DATETIME NOT NULL COMMENT '更新时间',
+	INDEX idx_scheduled_message_status_send (status, send_at),
+	INDEX idx_scheduled_message_user_send (user_id, send_at)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='定时发送消息';
@@ -0,0 +1,15 @@
+-- MySQL schema migration: 013_ws_connection_event
+-- Lifecycle audit log of upstream WebSocket connections (connect/disconnect/evict/forceout/reconnect).
+
+CREATE TABLE IF NOT EXISTS ws_connection_event (
+	id BIGINT AUTO_INCREMENT PRIMARY KEY,
+	user_id VARCHAR(64) NOT NULL COMMENT '身份ID',
+	event_type VARCHAR(16) NOT NULL COMMENT '事件类型：connect/disconnect/evict/forceout/reconnect',
+	upstream_url VARCHAR(512) NULL COMMENT '本次连接使用的上游地址',
+	reason VARCHAR(255) NULL COMMENT '事件原因',
+	duration_ms BIGINT NOT NULL DEFAULT 0 COMMENT '耗时（毫秒）：connect 为建连耗时，断开类为连接存活时长，reconnect 为退避延迟',
+	downstream_count INT NOT NULL DEFAULT 0 COMMENT '事件发生时该身份的下游会话数',
+	created_at DATETIME NOT NULL COMMENT '发生时间',
+	INDEX idx_ws_connection_event_user_created (user_id, created_at),
+	INDEX idx_ws_connection_event_type_created (event_type, created_at)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='上游连接生命周期事件';
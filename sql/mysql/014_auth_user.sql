@@ -0,0 +1,14 @@
+-- MySQL schema migration: 014_auth_user
+-- Login accounts with hashed passwords and roles (admin/operator/viewer), replacing the single shared access code.
+
+CREATE TABLE IF NOT EXISTS auth_user (
+	id BIGINT AUTO_INCREMENT PRIMARY KEY,
+	username VARCHAR(64) NOT NULL COMMENT '登录用户名',
+	password_hash VARCHAR(255) NOT NULL COMMENT '密码哈希（pbkdf2-sha256）',
+	role VARCHAR(16) NOT NULL COMMENT '角色：admin/operator/viewer',
+	disabled TINYINT(1) NOT NULL DEFAULT 0 COMMENT '是否停用',
+	last_login_at DATETIME NULL COMMENT '最近登录时间',
+	created_at DATETIME NOT NULL COMMENT '创建时间',
+	updated_at DATETIME NOT NULL COMMENT '更新时间',
+	UNIQUE KEY uk_auth_user_username (username)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='登录账号';
@@ -0,0 +1,21 @@
+-- MySQL schema migration: 015_auth_session
+-- Server-side login sessions backing rotating refresh tokens; revoked sessions form the access-token revocation list.
+
+CREATE TABLE IF NOT EXISTS auth_session (
+	id BIGINT AUTO_INCREMENT PRIMARY KEY,
+	session_id VARCHAR(32) NOT NULL COMMENT '会话ID（access token 的 sid 声明）',
+	subject VARCHAR(64) NOT NULL COMMENT '登录主体：账号用户名或访问码登录的 user',
+	role VARCHAR(16) NOT NULL COMMENT '登录时的角色',
+	account TINYINT(1) NOT NULL DEFAULT 0 COMMENT '是否账号登录',
+	refresh_hash CHAR(64) NOT NULL COMMENT '当前 refresh token 的 SHA-256',
+	user_agent VARCHAR(255) NULL COMMENT '登录客户端 User-Agent',
+	remote_addr VARCHAR(64) NULL COMMENT '登录来源地址',
+	expires_at DATETIME NOT NULL COMMENT '会话过期时间',
+	last_used_at DATETIME NULL COMMENT '最近刷新时间',
+	revoked_at DATETIME NULL COMMENT '吊销时间',
+	revoke_reason VARCHAR(64) NULL COMMENT '吊销原因：logout/revoke all/refresh reuse/account changed',
+	created_at DATETIME NOT NULL COMMENT '创建时间',
+	UNIQUE KEY uk_auth_session_session_id (session_id),
+	INDEX idx_auth_session_subject (subject),
+	INDEX idx_auth_session_revoked (revoked_at)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='登录会话';
@@ -0,0 +1,16 @@
+-- MySQL schema migration: 016_auth_login_failure
+-- Failed login attempts kept for review; lockout state itself lives in memory or Redis.
+
+CREATE TABLE IF NOT EXISTS auth_login_failure (
+	id BIGINT AUTO_INCREMENT PRIMARY KEY,
+	remote_ip VARCHAR(64) NOT NULL COMMENT '来源IP',
+	method VARCHAR(16) NOT NULL COMMENT '登录方式：accessCode/account',
+	username VARCHAR(64) NULL COMMENT '账号登录时提交的用户名',
+	reason VARCHAR(64) NOT NULL COMMENT '失败原因',
+	user_agent VARCHAR(255) NULL COMMENT '客户端 User-Agent',
+	failures INT NOT NULL DEFAULT 0 COMMENT '该IP在统计窗口内的累计失败次数',
+	locked TINYINT(1) NOT NULL DEFAULT 0 COMMENT '本次失败是否触发锁定',
+	created_at DATETIME NOT NULL COMMENT '发生时间',
+	INDEX idx_auth_login_failure_created (created_at),
+	INDEX idx_auth_login_failure_ip_created (remote_ip, created_at)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='登录失败记录';
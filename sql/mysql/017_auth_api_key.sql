@@ -0,0 +1,19 @@
+-- MySQL schema migration: 017_auth_api_key
+-- Long-lived API keys for automation clients; only the SHA-256 hash of each key is stored.
+
+CREATE TABLE IF NOT EXISTS auth_api_key (
+	id BIGINT AUTO_INCREMENT PRIMARY KEY,
+	name VARCHAR(64) NOT NULL COMMENT '名称（用途说明）',
+	key_prefix VARCHAR(16) NOT NULL COMMENT '明文前缀，仅用于辨识',
+	key_hash CHAR(64) NOT NULL COMMENT '密钥 SHA-256 哈希（hex）',
+	scopes VARCHAR(255) NOT NULL COMMENT '授权范围，逗号分隔：douyin/mtphoto/media/system',
+	role VARCHAR(16) NOT NULL COMMENT '角色：admin/operator/viewer',
+	disabled TINYINT(1) NOT NULL DEFAULT 0 COMMENT '是否停用',
+	expires_at DATETIME NULL COMMENT '过期时间（NULL 表示不过期）',
+	last_used_at DATETIME NULL COMMENT '最近使用时间',
+	last_used_ip VARCHAR(64) NULL COMMENT '最近使用来源IP',
+	created_by VARCHAR(64) NOT NULL COMMENT '创建者',
+	created_at DATETIME NOT NULL COMMENT '创建时间',
+	updated_at DATETIME NOT NULL COMMENT '更新时间',
+	UNIQUE KEY uk_auth_api_key_hash (key_hash)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='API Key';
@@ -0,0 +1,21 @@
+-- MySQL schema migration: 018_audit_log
+-- Audit trail of mutating API calls: caller, route, redacted parameters, result and latency.
+
+CREATE TABLE IF NOT EXISTS audit_log (
+	id BIGINT AUTO_INCREMENT PRIMARY KEY,
+	subject VARCHAR(80) NOT NULL COMMENT '调用者：账号用户名、访问码登录为 user、API Key 为 apikey:<名称>',
+	role VARCHAR(16) NOT NULL COMMENT '调用时角色',
+	api_key_id BIGINT NULL COMMENT 'API Key 调用时的 Key ID',
+	method VARCHAR(8) NOT NULL COMMENT 'HTTP 方法',
+	path VARCHAR(255) NOT NULL COMMENT '请求路径',
+	params TEXT NULL COMMENT '请求参数 JSON（敏感字段已打码，过长时截断）',
+	status INT NOT NULL COMMENT 'HTTP 状态码',
+	result_code INT NULL COMMENT '响应体中的业务 code',
+	latency_ms INT NOT NULL DEFAULT 0 COMMENT '处理耗时（毫秒）',
+	remote_ip VARCHAR(64) NULL COMMENT '来源IP',
+	user_agent VARCHAR(255) NULL COMMENT '客户端 User-Agent',
+	created_at DATETIME NOT NULL COMMENT '请求时间',
+	INDEX idx_audit_log_created (created_at),
+	INDEX idx_audit_log_subject_created (subject, created_at),
+	INDEX idx_audit_log_path_created (path, created_at)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='写操作审计记录';
@@ -0,0 +1,23 @@
+-- MySQL schema migration: 019_auth_totp
+-- Optional TOTP (RFC 6238) second factor for login accounts plus single-use recovery codes.
+
+CREATE TABLE IF NOT EXISTS auth_totp (
+	id BIGINT AUTO_INCREMENT PRIMARY KEY,
+	user_id BIGINT NOT NULL COMMENT '账号ID（auth_user.id）',
+	secret VARCHAR(64) NOT NULL COMMENT 'TOTP 密钥（base32）',
+	enabled TINYINT(1) NOT NULL DEFAULT 0 COMMENT '是否已启用（0 表示已生成密钥、待验证）',
+	last_used_step BIGINT NOT NULL DEFAULT 0 COMMENT '最近一次通过校验的时间步，防止验证码重放',
+	created_at DATETIME NOT NULL COMMENT '创建时间',
+	enabled_at DATETIME NULL COMMENT '启用时间',
+	updated_at DATETIME NOT NULL COMMENT '更新时间',
+	UNIQUE KEY uk_auth_totp_user (user_id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='账号两步验证';
+
+CREATE TABLE IF NOT EXISTS auth_totp_recovery (
+	id BIGINT AUTO_INCREMENT PRIMARY KEY,
+	user_id BIGINT NOT NULL COMMENT '账号ID（auth_user.id）',
+	code_hash CHAR(64) NOT NULL COMMENT '恢复码 SHA-256 哈希（hex）',
+	used_at DATETIME NULL COMMENT '使用时间（NULL 表示未使用）',
+	created_at DATETIME NOT NULL COMMENT '创建时间',
+	INDEX idx_auth_totp_recovery_user (user_id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='两步验证恢复码';
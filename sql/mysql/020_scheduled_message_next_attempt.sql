@@ -0,0 +1,9 @@
+-- MySQL schema migration: 020_scheduled_message_next_attempt
+-- Retry time is tracked separately so a failed send does not overwrite the user's send_at.
+
+ALTER TABLE scheduled_message
+  ADD COLUMN next_attempt_at DATETIME NULL COMMENT '下次尝试发送时间：首次为 send_at，失败重试时延后';
+
+UPDATE scheduled_message SET next_attempt_at = send_at WHERE next_attempt_at IS NULL;
+
+CREATE INDEX idx_scheduled_message_status_next ON scheduled_message (status, next_attempt_at);
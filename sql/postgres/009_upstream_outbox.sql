@@ -0,0 +1,20 @@
+-- PostgreSQL schema migration: 009_upstream_outbox
+-- Persist every frame sent to upstream WebSocket so undelivered messages survive disconnects and restarts.
+
+CREATE TABLE IF NOT EXISTS upstream_outbox (
+	id BIGSERIAL PRIMARY KEY,
+	user_id VARCHAR(64) NOT NULL,
+	payload TEXT NOT NULL,
+	status VARCHAR(16) NOT NULL,
+	attempts INT NOT NULL DEFAULT 0,
+	last_error VARCHAR(512) NULL,
+	created_at TIMESTAMP NOT NULL,
+	updated_at TIMESTAMP NOT NULL,
+	sent_at TIMESTAMP NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_upstream_outbox_user_status
+	ON upstream_outbox (user_id, status, created_at);
+
+CREATE INDEX IF NOT EXISTS idx_upstream_outbox_status_updated
+	ON upstream_outbox (status, updated_at);
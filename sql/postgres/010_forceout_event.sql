@@ -0,0 +1,17 @@
+-- PostgreSQL schema migration: 010_forceout_event
+-- Persist upstream forceout events; the active ban of an identity is derived from its latest uncleared event.
+
+CREATE TABLE IF NOT EXISTS forceout_event (
+	id BIGSERIAL PRIMARY KEY,
+	user_id VARCHAR(64) NOT NULL,
+	raw_message TEXT NULL,
+	banned_until TIMESTAMP NOT NULL,
+	cleared_at TIMESTAMP NULL,
+	created_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_forceout_event_user_created
+	ON forceout_event (user_id, created_at);
+
+CREATE INDEX IF NOT EXISTS idx_forceout_event_banned_until
+	ON forceout_event (banned_until);
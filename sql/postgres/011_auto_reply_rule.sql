@@ -0,0 +1,23 @@
+-- PostgreSQL schema migration: 011_auto_reply_rule
+-- Per-identity auto-reply rules evaluated against incoming private chat messages (code=7).
+
+CREATE TABLE IF NOT EXISTS auto_reply_rule (
+	id BIGSERIAL PRIMARY KEY,
+	user_id VARCHAR(64) NOT NULL,
+	name VARCHAR(128) NOT NULL,
+	enabled SMALLINT NOT NULL DEFAULT 1,
+	priority INT NOT NULL DEFAULT 0,
+	match_senders VARCHAR(1024) NULL,
+	match_keyword VARCHAR(255) NULL,
+	match_regex VARCHAR(512) NULL,
+	match_type VARCHAR(32) NULL,
+	time_start VARCHAR(5) NULL,
+	time_end VARCHAR(5) NULL,
+	reply_template TEXT NOT NULL,
+	cooldown_seconds INT NOT NULL DEFAULT 60,
+	created_at TIMESTAMP NOT NULL,
+	updated_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_auto_reply_rule_user
+	ON auto_reply_rule (user_id, enabled, priority);
@@ -0,0 +1,24 @@
+-- PostgreSQL schema migration: 012_scheduled_message
+-- Chat messages queued per identity for a future send time; survives restarts.
+
+CREATE TABLE IF NOT EXISTS scheduled_message (
+	id BIGSERIAL PRIMARY KEY,
+	user_id VARCHAR(64) NOT NULL,
+	target_user_id VARCHAR(64) NOT NULL,
+	target_nickname VARCHAR(128) NULL,
+	content TEXT NOT NULL,
+	sign_message TEXT NOT NULL,
+	send_at TIMESTAMP NOT NULL,
+	status VARCHAR(16) NOT NULL,
+	attempts INT NOT NULL DEFAULT 0,
+	last_error VARCHAR(512) NULL,
+	sent_at TIMESTAMP NULL,
+	created_at TIMESTAMP NOT NULL,
+	updated_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_scheduled_message_status_send
+	ON scheduled_message (status, send_at);
+
+CREATE INDEX IF NOT EXISTS idx_scheduled_message_user_send
+	ON scheduled_message (user_id, send_at);
@@ -0,0 +1,19 @@
+-- PostgreSQL schema migration: 013_ws_connection_event
+-- Lifecycle audit log of upstream WebSocket connections (connect/disconnect/evict/forceout/reconnect).
+
+CREATE TABLE IF NOT EXISTS ws_connection_event (
+	id BIGSERIAL PRIMARY KEY,
+	user_id VARCHAR(64) NOT NULL,
+	event_type VARCHAR(16) NOT NULL,
+	upstream_url VARCHAR(512) NULL,
+	reason VARCHAR(255) NULL,
+	duration_ms BIGINT NOT NULL DEFAULT 0,
+	downstream_count INT NOT NULL DEFAULT 0,
+	created_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_ws_connection_event_user_created
+	ON ws_connection_event (user_id, created_at);
+
+CREATE INDEX IF NOT EXISTS idx_ws_connection_event_type_created
+	ON ws_connection_event (event_type, created_at);
@@ -0,0 +1,16 @@
+-- PostgreSQL schema migration: 014_auth_user
+-- Login accounts with hashed passwords and roles (admin/operator/viewer), replacing the single shared access code.
+
+CREATE TABLE IF NOT EXISTS auth_user (
+	id BIGSERIAL PRIMARY KEY,
+	username VARCHAR(64) NOT NULL,
+	password_hash VARCHAR(255) NOT NULL,
+	role VARCHAR(16) NOT NULL,
+	disabled SMALLINT NOT NULL DEFAULT 0,
+	last_login_at TIMESTAMP NULL,
+	created_at TIMESTAMP NOT NULL,
+	updated_at TIMESTAMP NOT NULL
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS uk_auth_user_username
+	ON auth_user (username);
@@ -0,0 +1,27 @@
+-- PostgreSQL schema migration: 015_auth_session
+-- Server-side login sessions backing rotating refresh tokens; revoked sessions form the access-token revocation list.
+
+CREATE TABLE IF NOT EXISTS auth_session (
+	id BIGSERIAL PRIMARY KEY,
+	session_id VARCHAR(32) NOT NULL,
+	subject VARCHAR(64) NOT NULL,
+	role VARCHAR(16) NOT NULL,
+	account SMALLINT NOT NULL DEFAULT 0,
+	refresh_hash CHAR(64) NOT NULL,
+	user_agent VARCHAR(255) NULL,
+	remote_addr VARCHAR(64) NULL,
+	expires_at TIMESTAMP NOT NULL,
+	last_used_at TIMESTAMP NULL,
+	revoked_at TIMESTAMP NULL,
+	revoke_reason VARCHAR(64) NULL,
+	created_at TIMESTAMP NOT NULL
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS uk_auth_session_session_id
+	ON auth_session (session_id);
+
+CREATE INDEX IF NOT EXISTS idx_auth_session_subject
+	ON auth_session (subject);
+
+CREATE INDEX IF NOT EXISTS idx_auth_session_revoked
+	ON auth_session (revoked_at);
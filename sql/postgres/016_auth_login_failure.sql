@@ -0,0 +1,20 @@
+-- PostgreSQL schema migration: 016_auth_login_failure
+-- Failed login attempts kept for review; lockout state itself lives in memory or Redis.
+
+CREATE TABLE IF NOT EXISTS auth_login_failure (
+	id BIGSERIAL PRIMARY KEY,
+	remote_ip VARCHAR(64) NOT NULL,
+	method VARCHAR(16) NOT NULL,
+	username VARCHAR(64) NULL,
+	reason VARCHAR(64) NOT NULL,
+	user_agent VARCHAR(255) NULL,
+	failures INT NOT NULL DEFAULT 0,
+	locked SMALLINT NOT NULL DEFAULT 0,
+	created_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_auth_login_failure_created
+	ON auth_login_failure (created_at);
+
+CREATE INDEX IF NOT EXISTS idx_auth_login_failure_ip_created
+	ON auth_login_failure (remote_ip, created_at);
@@ -0,0 +1,21 @@
+-- PostgreSQL schema migration: 017_auth_api_key
+-- Long-lived API keys for automation clients; only the SHA-256 hash of each key is stored.
+
+CREATE TABLE IF NOT EXISTS auth_api_key (
+	id BIGSERIAL PRIMARY KEY,
+	name VARCHAR(64) NOT NULL,
+	key_prefix VARCHAR(16) NOT NULL,
+	key_hash CHAR(64) NOT NULL,
+	scopes VARCHAR(255) NOT NULL,
+	role VARCHAR(16) NOT NULL,
+	disabled SMALLINT NOT NULL DEFAULT 0,
+	expires_at TIMESTAMP NULL,
+	last_used_at TIMESTAMP NULL,
+	last_used_ip VARCHAR(64) NULL,
+	created_by VARCHAR(64) NOT NULL,
+	created_at TIMESTAMP NOT NULL,
+	updated_at TIMESTAMP NOT NULL
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS uk_auth_api_key_hash
+	ON auth_api_key (key_hash);
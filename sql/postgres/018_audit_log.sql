@@ -0,0 +1,27 @@
+-- PostgreSQL schema migration: 018_audit_log
+-- Audit trail of mutating API calls: caller, route, redacted parameters, result and latency.
+
+CREATE TABLE IF NOT EXISTS audit_log (
+	id BIGSERIAL PRIMARY KEY,
+	subject VARCHAR(80) NOT NULL,
+	role VARCHAR(16) NOT NULL,
+	api_key_id BIGINT NULL,
+	method VARCHAR(8) NOT NULL,
+	path VARCHAR(255) NOT NULL,
+	params TEXT NULL,
+	status INT NOT NULL,
+	result_code INT NULL,
+	latency_ms INT NOT NULL DEFAULT 0,
+	remote_ip VARCHAR(64) NULL,
+	user_agent VARCHAR(255) NULL,
+	created_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_audit_log_created
+	ON audit_log (created_at);
+
+CREATE INDEX IF NOT EXISTS idx_audit_log_subject_created
+	ON audit_log (subject, created_at);
+
+CREATE INDEX IF NOT EXISTS idx_audit_log_path_created
+	ON audit_log (path, created_at);
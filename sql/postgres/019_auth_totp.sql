@@ -0,0 +1,27 @@
+-- PostgreSQL schema migration: 019_auth_totp
+-- Optional TOTP (RFC 6238) second factor for login accounts plus single-use recovery codes.
+
+CREATE TABLE IF NOT EXISTS auth_totp (
+	id BIGSERIAL PRIMARY KEY,
+	user_id BIGINT NOT NULL,
+	secret VARCHAR(64) NOT NULL,
+	enabled SMALLINT NOT NULL DEFAULT 0,
+	last_used_step BIGINT NOT NULL DEFAULT 0,
+	created_at TIMESTAMP NOT NULL,
+	enabled_at TIMESTAMP NULL,
+	updated_at TIMESTAMP NOT NULL
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS uk_auth_totp_user
+	ON auth_totp (user_id);
+
+CREATE TABLE IF NOT EXISTS auth_totp_recovery (
+	id BIGSERIAL PRIMARY KEY,
+	user_id BIGINT NOT NULL,
+	code_hash CHAR(64) NOT NULL,
+	used_at TIMESTAMP NULL,
+	created_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_auth_totp_recovery_user
+	ON auth_totp_recovery (user_id);
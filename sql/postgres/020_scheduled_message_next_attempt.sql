@@ -0,0 +1,9 @@
+-- PostgreSQL schema migration: 020_scheduled_message_next_attempt
+-- Retry time is tracked separately so a failed send does not overwrite the user's send_at.
+
+ALTER TABLE scheduled_message ADD COLUMN IF NOT EXISTS next_attempt_at TIMESTAMP NULL;
+
+UPDATE scheduled_message SET next_attempt_at = send_at WHERE next_attempt_at IS NULL;
+
+CREATE INDEX IF NOT EXISTS idx_scheduled_message_status_next
+	ON scheduled_message (status, next_attempt_at);